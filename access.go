@@ -0,0 +1,92 @@
+package influxdb
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// AccessList restricts requests to clients on a set of networks.
+type AccessList struct {
+	// Networks that are allowed. All networks are allowed if empty.
+	Allow []*net.IPNet
+
+	// Networks that are denied, even if they are also allowed.
+	Deny []*net.IPNet
+}
+
+// ParseAccessList returns an access list from lists of CIDR ranges.
+// Plain IP addresses are treated as ranges containing a single address.
+func ParseAccessList(allow, deny []string) (*AccessList, error) {
+	var l AccessList
+	var err error
+	if l.Allow, err = parseNetworks(allow); err != nil {
+		return nil, err
+	} else if l.Deny, err = parseNetworks(deny); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// parseNetworks parses a list of CIDR ranges or IP addresses.
+func parseNetworks(a []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, s := range a {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid network: %s", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network: %s", s)
+		}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+// Allowed returns true if the address is not denied and is allowed.
+func (l *AccessList) Allowed(ip net.IP) bool {
+	if l == nil {
+		return true
+	} else if ip == nil {
+		return false
+	}
+	for _, n := range l.Deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(l.Allow) == 0 {
+		return true
+	}
+	for _, n := range l.Allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// makeAdminHandler returns a handler that only calls fn for requests from
+// clients allowed by the handler's admin access list. Requests over a unix
+// socket are not restricted since they are protected by file permissions.
+func (h *Handler) makeAdminHandler(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.RemoteAddr != "" && r.RemoteAddr != "@" && !h.AdminAccess.Allowed(net.ParseIP(remoteAddr(r))) {
+			h.error(w, ErrAddressNotAllowed.Error(), http.StatusForbidden)
+			return
+		}
+		fn(w, r)
+	}
+}
@@ -0,0 +1,53 @@
+package influxdb_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/influxdb/influxdb"
+)
+
+// Ensure the access list allows addresses on allowed networks that are not denied.
+func TestAccessList_Allowed(t *testing.T) {
+	l, err := influxdb.ParseAccessList([]string{"127.0.0.1", "10.0.0.0/8", "fd00::/8"}, []string{"10.0.99.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tt := range []struct {
+		ip  string
+		exp bool
+	}{
+		{ip: "127.0.0.1", exp: true},
+		{ip: "127.0.0.2", exp: false},
+		{ip: "10.1.2.3", exp: true},
+		{ip: "10.0.99.1", exp: false},
+		{ip: "192.168.0.1", exp: false},
+		{ip: "fd00::1", exp: true},
+		{ip: "fe80::1", exp: false},
+	} {
+		if allowed := l.Allowed(net.ParseIP(tt.ip)); allowed != tt.exp {
+			t.Errorf("%d. %s: unexpected allowed: %v", i, tt.ip, allowed)
+		}
+	}
+
+	// An empty allow list allows every network that is not denied.
+	l, _ = influxdb.ParseAccessList(nil, []string{"10.0.0.0/8"})
+	if !l.Allowed(net.ParseIP("192.168.0.1")) || l.Allowed(net.ParseIP("10.0.0.1")) {
+		t.Fatal("unexpected deny-only access")
+	}
+
+	// A nil access list allows everything.
+	if !(*influxdb.AccessList)(nil).Allowed(net.ParseIP("10.0.0.1")) {
+		t.Fatal("expected nil access list to allow")
+	}
+}
+
+// Ensure invalid networks are rejected.
+func TestParseAccessList_Invalid(t *testing.T) {
+	for _, s := range []string{"foo", "10.0.0.0/33", "10.0.0"} {
+		if _, err := influxdb.ParseAccessList([]string{s}, nil); err == nil {
+			t.Fatalf("expected error: %s", s)
+		}
+	}
+}
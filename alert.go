@@ -0,0 +1,600 @@
+package influxdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/messaging"
+)
+
+const (
+	// DefaultAlertCheckInterval is the default time between checks for
+	// alert rules that are due to be evaluated.
+	DefaultAlertCheckInterval = 10 * time.Second
+
+	// DefaultPagerDutyURL is the default endpoint of the PagerDuty events API.
+	DefaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+	// DefaultAlertNotifyTimeout is the default time a notification can take
+	// to be sent.
+	DefaultAlertNotifyTimeout = 10 * time.Second
+)
+
+const (
+	// AlertFiring is the level of a series that meets a rule's condition.
+	AlertFiring = "firing"
+
+	// AlertOK is the level of a series that no longer meets a rule's condition.
+	AlertOK = "ok"
+)
+
+// AlertRule represents a condition checked by running a query on a schedule.
+//
+// Threshold rules compare the last value of each series returned by the query
+// with the threshold. Deadman rules fire for each series that returns no
+// values, or that has stopped being returned, and for the rule itself if the
+// query returns nothing at all.
+type AlertRule struct {
+	Name  string        `json:"name"`
+	Query string        `json:"query"`
+	Every time.Duration `json:"every"`
+
+	// Either "threshold" or "deadman".
+	Type string `json:"type"`
+
+	// The comparison of threshold rules: ">", ">=", "<", "<=", "=" or "!=".
+	Operator  string  `json:"operator,omitempty"`
+	Threshold float64 `json:"threshold,omitempty"`
+
+	// Where notifications are sent: http or https webhook URLs,
+	// "mailto:<address>" or "pagerduty:<routing key>".
+	Notify []string `json:"notify"`
+}
+
+// validate returns an error if the rule cannot be evaluated.
+func (r *AlertRule) validate() error {
+	if r.Name == "" {
+		return ErrAlertRuleNameRequired
+	} else if _, err := r.statement(); err != nil {
+		return err
+	} else if r.Every <= 0 {
+		return ErrInvalidAlertInterval
+	}
+
+	switch r.Type {
+	case "threshold":
+		switch r.Operator {
+		case ">", ">=", "<", "<=", "=", "!=":
+		default:
+			return ErrInvalidAlertOperator
+		}
+	case "deadman":
+	default:
+		return ErrInvalidAlertType
+	}
+
+	if len(r.Notify) == 0 {
+		return ErrAlertNotifyRequired
+	}
+	for _, target := range r.Notify {
+		if u, err := url.Parse(target); err != nil {
+			return ErrInvalidAlertNotify
+		} else if (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+			continue
+		} else if (u.Scheme == "mailto" || u.Scheme == "pagerduty") && u.Opaque != "" {
+			continue
+		}
+		return ErrInvalidAlertNotify
+	}
+	return nil
+}
+
+// statement returns the rule's parsed query.
+func (r *AlertRule) statement() (*influxql.SelectStatement, error) {
+	q, err := influxql.NewParser(strings.NewReader(r.Query)).ParseQuery()
+	if err != nil || len(q.Statements) != 1 {
+		return nil, ErrInvalidAlertQuery
+	}
+	stmt, ok := q.Statements[0].(*influxql.SelectStatement)
+	if !ok {
+		return nil, ErrInvalidAlertQuery
+	}
+	return stmt, nil
+}
+
+// exceeds returns true if a value meets a threshold rule's condition.
+func (r *AlertRule) exceeds(v float64) bool {
+	switch r.Operator {
+	case ">":
+		return v > r.Threshold
+	case ">=":
+		return v >= r.Threshold
+	case "<":
+		return v < r.Threshold
+	case "<=":
+		return v <= r.Threshold
+	case "=":
+		return v == r.Threshold
+	case "!=":
+		return v != r.Threshold
+	}
+	return false
+}
+
+// AlertRules returns the alert rules of a database, sorted by name.
+func (s *Server) AlertRules(database string) ([]*AlertRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+
+	a := make(alertRules, 0, len(db.alertRules))
+	for _, r := range db.alertRules {
+		a = append(a, r)
+	}
+	sort.Sort(a)
+	return a, nil
+}
+
+// SetAlertRule adds an alert rule to a database, replacing any existing rule
+// with the same name.
+func (s *Server) SetAlertRule(database string, r *AlertRule) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+	c := &setAlertRuleCommand{Database: database, Rule: r}
+	_, err := s.broadcast(setAlertRuleMessageType, c)
+	return err
+}
+
+func (s *Server) applySetAlertRule(m *messaging.Message) (err error) {
+	var c setAlertRuleCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// Replace the rule.
+	db.alertRules[c.Rule.Name] = c.Rule
+
+	// Persist to metastore.
+	err = s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+
+	return
+}
+
+type setAlertRuleCommand struct {
+	Database string     `json:"database"`
+	Rule     *AlertRule `json:"rule"`
+}
+
+// DeleteAlertRule removes an alert rule from a database.
+func (s *Server) DeleteAlertRule(database, name string) error {
+	c := &deleteAlertRuleCommand{Database: database, Name: name}
+	_, err := s.broadcast(deleteAlertRuleMessageType, c)
+	return err
+}
+
+func (s *Server) applyDeleteAlertRule(m *messaging.Message) (err error) {
+	var c deleteAlertRuleCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	} else if db.alertRules[c.Name] == nil {
+		return ErrAlertRuleNotFound
+	}
+
+	// Remove the rule.
+	delete(db.alertRules, c.Name)
+
+	// Persist to metastore.
+	err = s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+
+	return
+}
+
+type deleteAlertRuleCommand struct {
+	Database string `json:"database"`
+	Name     string `json:"name"`
+}
+
+// alertRules represents a list of rules sortable by name.
+type alertRules []*AlertRule
+
+func (a alertRules) Len() int           { return len(a) }
+func (a alertRules) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (a alertRules) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// AlertEvent represents a change in the level of a series checked by a rule.
+// It is the body of webhook notifications.
+type AlertEvent struct {
+	Database string            `json:"database"`
+	Rule     string            `json:"rule"`
+	Level    string            `json:"level"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Value    interface{}       `json:"value,omitempty"`
+	Message  string            `json:"message"`
+	Time     time.Time         `json:"time"`
+}
+
+// AlertService evaluates the alert rules of every database and sends
+// notifications when the level of a series changes. Levels are kept in
+// memory so a series only notifies once when it starts firing and once when
+// it recovers.
+//
+// Each service evaluates every rule so it should only run on one data node.
+type AlertService struct {
+	server *Server
+
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	closing chan struct{}
+
+	series  map[string]*alertSeries // levels of series by database, rule and tags
+	lastRun map[string]time.Time    // time each rule was last evaluated, by database and rule
+
+	// The time between checks for rules that are due to be evaluated.
+	CheckInterval time.Duration
+
+	// The SMTP server and sender address of "mailto:" notifications.
+	SMTPAddr string
+	SMTPFrom string
+
+	// The endpoint that "pagerduty:" notifications are sent to.
+	PagerDutyURL string
+
+	// The client used for webhook and PagerDuty notifications.
+	Client *http.Client
+
+	Logger *log.Logger
+}
+
+// NewAlertService returns a new instance of AlertService attached to a Server.
+func NewAlertService(s *Server) *AlertService {
+	return &AlertService{
+		server:        s,
+		series:        make(map[string]*alertSeries),
+		lastRun:       make(map[string]time.Time),
+		CheckInterval: DefaultAlertCheckInterval,
+		PagerDutyURL:  DefaultPagerDutyURL,
+		Client:        &http.Client{Timeout: DefaultAlertNotifyTimeout},
+		Logger:        log.New(os.Stderr, "[alert] ", log.LstdFlags),
+	}
+}
+
+// Open starts evaluating rules every check interval.
+func (a *AlertService) Open() error {
+	if a.CheckInterval <= 0 {
+		return ErrInvalidAlertCheckInterval
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closing = make(chan struct{})
+	a.wg.Add(1)
+	go a.run(a.CheckInterval, a.closing)
+	return nil
+}
+
+// Close stops evaluating rules and waits for the running check to finish.
+func (a *AlertService) Close() error {
+	a.mu.Lock()
+	if a.closing == nil {
+		a.mu.Unlock()
+		return ErrServerClosed
+	}
+	close(a.closing)
+	a.closing = nil
+	a.mu.Unlock()
+
+	a.wg.Wait()
+	return nil
+}
+
+// run checks for due rules every interval until closing is closed.
+func (a *AlertService) run(interval time.Duration, closing <-chan struct{}) {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closing:
+			return
+		case t := <-ticker.C:
+			a.Check(t.UTC())
+		}
+	}
+}
+
+// Check evaluates the rules that haven't been evaluated within their
+// interval and sends notifications for series whose level changed.
+func (a *AlertService) Check(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for _, database := range a.server.Databases() {
+		rules, err := a.server.AlertRules(database)
+		if err != nil {
+			continue
+		}
+		for _, r := range rules {
+			key := database + "/" + r.Name
+			seen[key] = true
+			if last, ok := a.lastRun[key]; ok && now.Sub(last) < r.Every {
+				continue
+			}
+			a.lastRun[key] = now
+
+			for _, e := range a.evaluate(database, r, now) {
+				a.notify(r, e)
+			}
+		}
+	}
+
+	// Forget the state of rules that were deleted.
+	for key := range a.lastRun {
+		if !seen[key] {
+			delete(a.lastRun, key)
+		}
+	}
+	for key, s := range a.series {
+		if !seen[s.rule] {
+			delete(a.series, key)
+		}
+	}
+}
+
+// alertSeries represents the level of a series checked by a rule.
+type alertSeries struct {
+	rule  string // database and rule name
+	tags  map[string]string
+	level string
+}
+
+// evaluate runs a rule's query and returns an event for each series whose
+// level changed. Series that haven't been seen before start at AlertOK.
+func (a *AlertService) evaluate(database string, r *AlertRule, now time.Time) []*AlertEvent {
+	stmt, err := r.statement()
+	if err != nil {
+		return nil
+	}
+	result := a.server.ExecuteQuery(&influxql.Query{Statements: influxql.Statements{stmt}}, database, nil, QueryOptions{})[0]
+
+	// A deadman rule's measurement or field may not exist if it has never
+	// received data, which is the same as receiving no data.
+	if r.Type == "deadman" && isNotFoundError(result.Err) {
+		result.Err, result.Rows = nil, nil
+	}
+	if result.Err != nil {
+		a.Logger.Printf("evaluate %s.%s: %s", database, r.Name, result.Err)
+		return nil
+	}
+
+	rule := database + "/" + r.Name
+	var events []*AlertEvent
+	update := func(tags map[string]string, level string, value interface{}, msg string) {
+		key := rule + "\x00" + string(marshalTags(tags))
+		s := a.series[key]
+		if s == nil {
+			s = &alertSeries{rule: rule, tags: tags, level: AlertOK}
+			a.series[key] = s
+		}
+		if s.level == level {
+			return
+		}
+		s.level = level
+		events = append(events, &AlertEvent{
+			Database: database,
+			Rule:     r.Name,
+			Level:    level,
+			Tags:     tags,
+			Value:    value,
+			Message:  msg,
+			Time:     now,
+		})
+	}
+
+	returned := make(map[string]bool)
+	for _, row := range result.Rows {
+		returned[rule+"\x00"+string(marshalTags(row.Tags))] = true
+		value, ok := lastRowValue(row)
+
+		switch r.Type {
+		case "threshold":
+			if !ok {
+				continue
+			} else if r.exceeds(value) {
+				update(row.Tags, AlertFiring, value, fmt.Sprintf("%s is firing: %v %s %v%s", r.Name, value, r.Operator, r.Threshold, formatAlertTags(row.Tags)))
+			} else {
+				update(row.Tags, AlertOK, value, fmt.Sprintf("%s is ok: %v%s", r.Name, value, formatAlertTags(row.Tags)))
+			}
+		case "deadman":
+			if ok {
+				update(row.Tags, AlertOK, value, fmt.Sprintf("%s is ok: receiving data%s", r.Name, formatAlertTags(row.Tags)))
+			} else {
+				update(row.Tags, AlertFiring, nil, fmt.Sprintf("%s is firing: no data%s", r.Name, formatAlertTags(row.Tags)))
+			}
+		}
+	}
+
+	// Deadman rules also fire for series that are no longer returned, or for
+	// the rule itself if nothing has been returned. The rule recovers once
+	// any series is returned.
+	if r.Type == "deadman" {
+		var missing []string
+		for key, s := range a.series {
+			if s.rule != rule || returned[key] {
+				continue
+			} else if len(s.tags) == 0 && len(result.Rows) > 0 {
+				update(nil, AlertOK, nil, fmt.Sprintf("%s is ok: receiving data", r.Name))
+				continue
+			}
+			missing = append(missing, key)
+		}
+		if len(missing) == 0 && len(result.Rows) == 0 {
+			update(nil, AlertFiring, nil, fmt.Sprintf("%s is firing: no data", r.Name))
+		}
+		sort.Strings(missing)
+		for _, key := range missing {
+			tags := a.series[key].tags
+			update(tags, AlertFiring, nil, fmt.Sprintf("%s is firing: no data%s", r.Name, formatAlertTags(tags)))
+		}
+	}
+
+	return events
+}
+
+// isNotFoundError returns true if err is caused by a missing measurement or field.
+func isNotFoundError(err error) bool {
+	return err == ErrMeasurementNotFound || (err != nil && strings.HasPrefix(err.Error(), "field not found"))
+}
+
+// lastRowValue returns the last numeric value in the first value column of a row.
+func lastRowValue(row *influxql.Row) (float64, bool) {
+	col := 0
+	if len(row.Columns) > 1 && row.Columns[0] == "time" {
+		col = 1
+	}
+	for i := len(row.Values) - 1; i >= 0; i-- {
+		if col >= len(row.Values[i]) {
+			continue
+		}
+		switch v := row.Values[i][col].(type) {
+		case float64:
+			return v, true
+		case int64:
+			return float64(v), true
+		case uint64:
+			return float64(v), true
+		}
+	}
+	return 0, false
+}
+
+// formatAlertTags returns tags in the form " (k1=v1, k2=v2)" sorted by key.
+func formatAlertTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	a := make([]string, len(keys))
+	for i, k := range keys {
+		a[i] = k + "=" + tags[k]
+	}
+	return " (" + strings.Join(a, ", ") + ")"
+}
+
+// notify sends an event to each of a rule's targets. Failures are logged.
+func (a *AlertService) notify(r *AlertRule, e *AlertEvent) {
+	for _, target := range r.Notify {
+		var err error
+		switch u, _ := url.Parse(target); u.Scheme {
+		case "http", "https":
+			err = a.postJSON(target, e)
+		case "mailto":
+			err = a.sendMail(u.Opaque, e)
+		case "pagerduty":
+			err = a.sendPagerDuty(u.Opaque, e)
+		}
+		if err != nil {
+			a.Logger.Printf("notify %s.%s: %s", e.Database, e.Rule, err)
+		}
+	}
+}
+
+// postJSON posts a JSON-encoded value to a URL.
+func (a *AlertService) postJSON(rawurl string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := a.Client.Post(rawurl, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: unexpected status: %d", rawurl, resp.StatusCode)
+	}
+	return nil
+}
+
+// sendMail emails an event to an address.
+func (a *AlertService) sendMail(addr string, e *AlertEvent) error {
+	if a.SMTPAddr == "" {
+		return fmt.Errorf("smtp server not configured")
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: [%s] %s\r\n\r\n%s\r\n", a.SMTPFrom, addr, strings.ToUpper(e.Level), e.Rule, e.Message)
+	return smtp.SendMail(a.SMTPAddr, nil, a.SMTPFrom, []string{addr}, []byte(msg))
+}
+
+// sendPagerDuty triggers or resolves a PagerDuty incident for an event. The
+// incident is keyed by the series so it is resolved once the series recovers.
+func (a *AlertService) sendPagerDuty(routingKey string, e *AlertEvent) error {
+	action := "trigger"
+	if e.Level == AlertOK {
+		action = "resolve"
+	}
+	return a.postJSON(a.PagerDutyURL, &pagerDutyEvent{
+		RoutingKey:  routingKey,
+		EventAction: action,
+		DedupKey:    e.Database + "/" + e.Rule + formatAlertTags(e.Tags),
+		Payload: pagerDutyPayload{
+			Summary:  e.Message,
+			Source:   "influxdb",
+			Severity: "critical",
+		},
+	})
+}
+
+// pagerDutyEvent is the body of a request to the PagerDuty events API.
+type pagerDutyEvent struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	DedupKey    string           `json:"dedup_key"`
+	Payload     pagerDutyPayload `json:"payload"`
+}
+
+type pagerDutyPayload struct {
+	Summary  string `json:"summary"`
+	Source   string `json:"source"`
+	Severity string `json:"severity"`
+}
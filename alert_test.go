@@ -0,0 +1,187 @@
+package influxdb_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb"
+)
+
+// Ensure alert rules can be set, listed and deleted, and are persisted.
+func TestServer_AlertRules(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	rule := &influxdb.AlertRule{Name: "cpu_high", Query: `SELECT mean(value) FROM cpu GROUP BY host`, Every: time.Minute, Type: "threshold", Operator: ">", Threshold: 90, Notify: []string{"mailto:ops@example.com"}}
+	if err := s.SetAlertRule("foo", rule); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetAlertRule("foo", &influxdb.AlertRule{Name: "cpu_dead", Query: `SELECT count(value) FROM cpu`, Every: time.Minute, Type: "deadman", Notify: []string{"pagerduty:abc123"}}); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+
+	if rules, err := s.AlertRules("foo"); err != nil {
+		t.Fatal(err)
+	} else if len(rules) != 2 || rules[0].Name != "cpu_dead" || !reflect.DeepEqual(rules[1], rule) {
+		t.Fatalf("unexpected rules: %s", mustMarshalJSON(rules))
+	}
+
+	if err := s.DeleteAlertRule("foo", "cpu_dead"); err != nil {
+		t.Fatal(err)
+	} else if err := s.DeleteAlertRule("foo", "cpu_dead"); err != influxdb.ErrAlertRuleNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	} else if rules, _ := s.AlertRules("foo"); len(rules) != 1 {
+		t.Fatalf("unexpected rules: %s", mustMarshalJSON(rules))
+	}
+}
+
+// Ensure invalid alert rules are rejected.
+func TestServer_SetAlertRule_Invalid(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	for i, tt := range []struct {
+		rule *influxdb.AlertRule
+		err  error
+	}{
+		{rule: &influxdb.AlertRule{Query: `SELECT mean(value) FROM cpu`, Every: time.Minute, Type: "deadman", Notify: []string{"http://localhost"}}, err: influxdb.ErrAlertRuleNameRequired},
+		{rule: &influxdb.AlertRule{Name: "r", Query: `DROP SERIES 1`, Every: time.Minute, Type: "deadman", Notify: []string{"http://localhost"}}, err: influxdb.ErrInvalidAlertQuery},
+		{rule: &influxdb.AlertRule{Name: "r", Query: `SELECT mean(value) FROM cpu`, Type: "deadman", Notify: []string{"http://localhost"}}, err: influxdb.ErrInvalidAlertInterval},
+		{rule: &influxdb.AlertRule{Name: "r", Query: `SELECT mean(value) FROM cpu`, Every: time.Minute, Type: "spike", Notify: []string{"http://localhost"}}, err: influxdb.ErrInvalidAlertType},
+		{rule: &influxdb.AlertRule{Name: "r", Query: `SELECT mean(value) FROM cpu`, Every: time.Minute, Type: "threshold", Operator: "~", Notify: []string{"http://localhost"}}, err: influxdb.ErrInvalidAlertOperator},
+		{rule: &influxdb.AlertRule{Name: "r", Query: `SELECT mean(value) FROM cpu`, Every: time.Minute, Type: "deadman"}, err: influxdb.ErrAlertNotifyRequired},
+		{rule: &influxdb.AlertRule{Name: "r", Query: `SELECT mean(value) FROM cpu`, Every: time.Minute, Type: "deadman", Notify: []string{"ops@example.com"}}, err: influxdb.ErrInvalidAlertNotify},
+	} {
+		if err := s.SetAlertRule("foo", tt.rule); err != tt.err {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		}
+	}
+}
+
+// Ensure threshold rules notify when a series starts firing and recovers.
+func TestAlertService_Check_Threshold(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	s.WriteSeries("foo", "bar", "cpu", map[string]string{"host": "serverA"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 95.0})
+	s.WriteSeries("foo", "bar", "cpu", map[string]string{"host": "serverB"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 50.0})
+	s.Sync(c.index)
+
+	hook := NewAlertHook()
+	defer hook.Close()
+	if err := s.SetAlertRule("foo", &influxdb.AlertRule{Name: "cpu_high", Query: `SELECT mean(value) FROM cpu GROUP BY host`, Every: time.Minute, Type: "threshold", Operator: ">", Threshold: 90, Notify: []string{hook.URL}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first check fires for serverA only.
+	a := influxdb.NewAlertService(s.Server)
+	now := mustParseTime("2000-01-01T00:01:00Z")
+	a.Check(now)
+	if events := hook.Events(); len(events) != 1 {
+		t.Fatalf("unexpected events: %s", mustMarshalJSON(events))
+	} else if e := events[0]; e.Level != influxdb.AlertFiring || e.Tags["host"] != "serverA" || e.Value != 95.0 || e.Message != "cpu_high is firing: 95 > 90 (host=serverA)" {
+		t.Fatalf("unexpected event: %s", mustMarshalJSON(e))
+	}
+
+	// Rules aren't evaluated again until their interval has passed, and
+	// series that are still firing don't notify again.
+	a.Check(now.Add(30 * time.Second))
+	a.Check(now.Add(time.Minute))
+	if events := hook.Events(); len(events) != 0 {
+		t.Fatalf("unexpected events: %s", mustMarshalJSON(events))
+	}
+
+	// Recovering notifies once.
+	s.WriteSeries("foo", "bar", "cpu", map[string]string{"host": "serverA"}, mustParseTime("2000-01-01T00:00:10Z"), map[string]interface{}{"value": 5.0})
+	s.Sync(c.index)
+	a.Check(now.Add(2 * time.Minute))
+	if events := hook.Events(); len(events) != 1 {
+		t.Fatalf("unexpected events: %s", mustMarshalJSON(events))
+	} else if e := events[0]; e.Level != influxdb.AlertOK || e.Tags["host"] != "serverA" || e.Value != 50.0 {
+		t.Fatalf("unexpected event: %s", mustMarshalJSON(e))
+	}
+}
+
+// Ensure deadman rules fire when no data is returned and resolve PagerDuty incidents.
+func TestAlertService_Check_Deadman(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+
+	hook := NewAlertHook()
+	defer hook.Close()
+	if err := s.SetAlertRule("foo", &influxdb.AlertRule{Name: "mem_dead", Query: `SELECT count(value) FROM mem`, Every: time.Minute, Type: "deadman", Notify: []string{"pagerduty:abc123"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	a := influxdb.NewAlertService(s.Server)
+	a.PagerDutyURL = hook.URL
+	now := mustParseTime("2000-01-01T00:01:00Z")
+	a.Check(now)
+	if body := hook.Bodies(); len(body) != 1 || body[0] != `{"routing_key":"abc123","event_action":"trigger","dedup_key":"foo/mem_dead","payload":{"summary":"mem_dead is firing: no data","source":"influxdb","severity":"critical"}}` {
+		t.Fatalf("unexpected requests: %q", body)
+	}
+
+	s.WriteSeries("foo", "bar", "mem", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 1.0})
+	s.Sync(c.index)
+	a.Check(now.Add(time.Minute))
+	if body := hook.Bodies(); len(body) != 1 || body[0] != `{"routing_key":"abc123","event_action":"resolve","dedup_key":"foo/mem_dead","payload":{"summary":"mem_dead is ok: receiving data","source":"influxdb","severity":"critical"}}` {
+		t.Fatalf("unexpected requests: %q", body)
+	}
+}
+
+// AlertHook is a test HTTP server that records the requests it receives.
+type AlertHook struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies []string
+}
+
+// NewAlertHook returns a new, running instance of AlertHook.
+func NewAlertHook() *AlertHook {
+	h := &AlertHook{}
+	h.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var v json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.mu.Lock()
+		h.bodies = append(h.bodies, string(v))
+		h.mu.Unlock()
+	}))
+	return h
+}
+
+// Bodies returns and clears the bodies of the requests received.
+func (h *AlertHook) Bodies() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	a := h.bodies
+	h.bodies = nil
+	return a
+}
+
+// Events returns and clears the events received.
+func (h *AlertHook) Events() []*influxdb.AlertEvent {
+	var a []*influxdb.AlertEvent
+	for _, b := range h.Bodies() {
+		var e influxdb.AlertEvent
+		if err := json.Unmarshal([]byte(b), &e); err != nil {
+			panic(err)
+		}
+		a = append(a, &e)
+	}
+	return a
+}
@@ -0,0 +1,242 @@
+package influxdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// AnnotationMeasurement is the measurement that annotations are stored in.
+const AnnotationMeasurement = "annotations"
+
+// Annotation represents an event, such as a deploy or an outage, that is
+// shown on dashboards alongside series data.
+//
+// Annotations are stored as points in the AnnotationMeasurement measurement
+// with "title", "text" and "tags" string fields. Tags are joined by commas
+// so they can also be read with a regular query. Annotations at the same
+// time replace each other.
+type Annotation struct {
+	Time  time.Time `json:"time"`
+	Title string    `json:"title"`
+	Text  string    `json:"text,omitempty"`
+	Tags  []string  `json:"tags,omitempty"`
+}
+
+// point returns the point that stores the annotation.
+func (a *Annotation) point() *Point {
+	values := map[string]interface{}{"title": a.Title}
+	if a.Text != "" {
+		values["text"] = a.Text
+	}
+	if len(a.Tags) > 0 {
+		values["tags"] = strings.Join(a.Tags, ",")
+	}
+	return &Point{Name: AnnotationMeasurement, Timestamp: a.Time, Values: values}
+}
+
+// hasTags returns true if the annotation has every tag in tags.
+func (a *Annotation) hasTags(tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, t := range a.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteAnnotations stores annotations in a database. Annotations without a
+// time are stored at the current time.
+func (s *Server) WriteAnnotations(database, retentionPolicy string, a []*Annotation) error {
+	now := time.Now().UTC()
+	points := make([]*Point, len(a))
+	for i, an := range a {
+		if an.Title == "" {
+			return ErrAnnotationTitleRequired
+		}
+		for _, t := range an.Tags {
+			if t == "" || strings.Contains(t, ",") {
+				return ErrInvalidAnnotationTag
+			}
+		}
+		points[i] = an.point()
+		if an.Time.IsZero() {
+			points[i].Timestamp = now
+		}
+	}
+	return s.WritePoints(database, retentionPolicy, points)
+}
+
+// Annotations returns the annotations in a database from start up to, but
+// not including, end that have every tag in tags. Annotations are sorted
+// by time and read from the retention policy in the query options.
+func (s *Server) Annotations(database string, user *User, start, end time.Time, tags []string, opt QueryOptions) ([]*Annotation, error) {
+	// Nothing has been annotated until the measurement exists.
+	s.mu.RLock()
+	db := s.databases[database]
+	if db == nil {
+		s.mu.RUnlock()
+		return nil, ErrDatabaseNotFound
+	}
+	idx, err := db.loadIndex()
+	if err != nil {
+		s.mu.RUnlock()
+		return nil, err
+	}
+	var fields []string
+	if m := idx.measurements[AnnotationMeasurement]; m != nil && m.field("title") != nil {
+		for _, name := range []string{"title", "text", "tags"} {
+			if m.field(name) != nil {
+				fields = append(fields, name)
+			}
+		}
+	}
+	s.mu.RUnlock()
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	// Raw values can only be selected one field at a time so each field is
+	// read separately and merged by time. The title is read first so that
+	// annotations are only created for points that have one.
+	cond := &influxql.BinaryExpr{
+		Op:  influxql.AND,
+		LHS: &influxql.BinaryExpr{Op: influxql.GTE, LHS: &influxql.VarRef{Val: "time"}, RHS: &influxql.TimeLiteral{Val: start.UTC()}},
+		RHS: &influxql.BinaryExpr{Op: influxql.LT, LHS: &influxql.VarRef{Val: "time"}, RHS: &influxql.TimeLiteral{Val: end.UTC()}},
+	}
+	m := make(map[int64]*Annotation)
+	for _, name := range fields {
+		stmt := &influxql.SelectStatement{
+			Fields:    influxql.Fields{{Expr: &influxql.VarRef{Val: name}}},
+			Source:    &influxql.Measurement{Name: AnnotationMeasurement},
+			Condition: cond,
+		}
+		results := s.ExecuteQuery(&influxql.Query{Statements: influxql.Statements{stmt}}, database, user, opt)
+		if err := results[0].Err; err != nil {
+			return nil, err
+		}
+
+		// Row timestamps are in microseconds.
+		for _, row := range results[0].Rows {
+			for _, values := range row.Values {
+				timestamp, ok := values[0].(int64)
+				value, ok2 := values[1].(string)
+				if !ok || !ok2 {
+					continue
+				}
+
+				an := m[timestamp]
+				if an == nil {
+					if name != "title" {
+						continue
+					}
+					an = &Annotation{Time: time.Unix(0, timestamp*int64(time.Microsecond)).UTC()}
+					m[timestamp] = an
+				}
+				switch name {
+				case "title":
+					an.Title = value
+				case "text":
+					an.Text = value
+				case "tags":
+					if value != "" {
+						an.Tags = strings.Split(value, ",")
+					}
+				}
+			}
+		}
+	}
+
+	var a []*Annotation
+	for _, an := range m {
+		if an.Title != "" && an.hasTags(tags) {
+			a = append(a, an)
+		}
+	}
+	sort.Sort(annotations(a))
+	return a, nil
+}
+
+// annotationJSON is the format of annotations written over HTTP. Time is an
+// RFC3339 string or a number in the write's precision.
+type annotationJSON struct {
+	Time  interface{} `json:"time"`
+	Title string      `json:"title"`
+	Text  string      `json:"text"`
+	Tags  []string    `json:"tags"`
+}
+
+// decodeAnnotations decodes a JSON annotation or array of annotations.
+func decodeAnnotations(r io.Reader, precision TimePrecision) ([]*Annotation, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var a []*annotationJSON
+	if len(data) > 0 && data[0] == '[' {
+		err = dec.Decode(&a)
+	} else {
+		v := &annotationJSON{}
+		err = dec.Decode(v)
+		a = append(a, v)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := make([]*Annotation, len(a))
+	for i, v := range a {
+		an := &Annotation{Title: v.Title, Text: v.Text, Tags: v.Tags}
+		switch t := v.Time.(type) {
+		case nil:
+		case string:
+			an.Time, err = parseAnnotationTime(t, precision)
+		default:
+			an.Time, err = parseTimestamp(t, precision)
+		}
+		if err != nil {
+			return nil, err
+		}
+		annotations[i] = an
+	}
+	return annotations, nil
+}
+
+// parseAnnotationTime parses an RFC3339 time or an integer timestamp in the
+// given precision.
+func parseAnnotationTime(s string, precision TimePrecision) (time.Time, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return precision.Time(n), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time: %s", s)
+	}
+	return t.UTC(), nil
+}
+
+// annotations sorts annotations by time.
+type annotations []*Annotation
+
+func (a annotations) Len() int           { return len(a) }
+func (a annotations) Less(i, j int) bool { return a[i].Time.Before(a[j].Time) }
+func (a annotations) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
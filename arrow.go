@@ -0,0 +1,388 @@
+package influxdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// arrowContentType is the media type of an Apache Arrow IPC stream.
+const arrowContentType = "application/vnd.apache.arrow.stream"
+
+// Arrow metadata constants from Schema.fbs and Message.fbs.
+const (
+	arrowMetadataV5 = 4
+
+	arrowHeaderSchema      = 1
+	arrowHeaderRecordBatch = 3
+
+	arrowTypeInt           = 2
+	arrowTypeFloatingPoint = 3
+	arrowTypeUtf8          = 5
+	arrowTypeBool          = 6
+	arrowTypeTimestamp     = 10
+
+	arrowPrecisionDouble = 2
+)
+
+// arrowTimeUnit returns the Arrow time unit of a precision. Returns false
+// if Arrow has no unit for the precision.
+func arrowTimeUnit(p TimePrecision) (int, bool) {
+	switch p {
+	case SecondPrecision:
+		return 0, true
+	case MillisecondPrecision:
+		return 1, true
+	case MicrosecondPrecision:
+		return 2, true
+	case NanosecondPrecision:
+		return 3, true
+	}
+	return 0, false
+}
+
+// marshalArrowStream encodes rows as an Apache Arrow IPC stream. The stream
+// has a "name" column, a column for each tag key and a column for each
+// column of the rows. Each row is written as a record batch. Column types
+// are inferred from their values: numbers of different types are written as
+// doubles and other mixed or complex values as JSON strings. The time column
+// is written as a UTC timestamp in the given Arrow time unit.
+func marshalArrowStream(rows []*influxql.Row, unit int) []byte {
+	columns := resultColumns(rows)
+
+	var buf bytes.Buffer
+	writeArrowMessage(&buf, arrowHeaderSchema, arrowSchema(columns, unit), nil)
+	for _, row := range rows {
+		header, body := arrowRecordBatch(columns, row)
+		writeArrowMessage(&buf, arrowHeaderRecordBatch, header, body)
+	}
+
+	// End of stream marker.
+	buf.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0})
+	return buf.Bytes()
+}
+
+// arrowSchema returns the Schema message header for the columns.
+func arrowSchema(columns []*resultColumn, unit int) *fbTable {
+	fields := make([]*fbTable, len(columns))
+	for i, c := range columns {
+		var typeID int
+		var typ *fbTable
+		switch c.kind {
+		case columnInteger, columnUnsigned:
+			typeID, typ = arrowTypeInt, &fbTable{fbScalar{4, 64}, fbScalar{1, boolToUint64(c.kind == columnInteger)}}
+		case columnFloat:
+			typeID, typ = arrowTypeFloatingPoint, &fbTable{fbScalar{2, arrowPrecisionDouble}}
+		case columnBoolean:
+			typeID, typ = arrowTypeBool, &fbTable{}
+		case columnTimestamp:
+			typeID, typ = arrowTypeTimestamp, &fbTable{fbScalar{2, uint64(unit)}, fbString("UTC")}
+		default:
+			typeID, typ = arrowTypeUtf8, &fbTable{}
+		}
+		fields[i] = &fbTable{
+			fbString(c.name),
+			fbScalar{1, 1}, // nullable
+			fbScalar{1, uint64(typeID)},
+			typ,
+			nil,           // dictionary
+			fbTables(nil), // children
+		}
+	}
+	return &fbTable{nil, fbTables(fields)}
+}
+
+// arrowRecordBatch returns the RecordBatch message header and body for a row.
+func arrowRecordBatch(columns []*resultColumn, row *influxql.Row) (*fbTable, []byte) {
+	n := len(row.Values)
+	index := make(map[string]int, len(row.Columns))
+	for i, name := range row.Columns {
+		index[name] = i
+	}
+
+	var body []byte
+	var nodes, buffers []byte
+	addBuffer := func(b []byte) {
+		buffers = appendInt64s(buffers, int64(len(body)), int64(len(b)))
+		body = append(body, b...)
+		for len(body)%8 != 0 {
+			body = append(body, 0)
+		}
+	}
+
+	for _, c := range columns {
+		// Look up the value of the column in each row.
+		values := make([]interface{}, n)
+		for i := range values {
+			values[i] = c.value(row, index, row.Values[i])
+		}
+
+		// Build the validity bitmap.
+		validity := make([]byte, (n+7)/8)
+		var nullN int
+		for i, v := range values {
+			if v == nil {
+				nullN++
+			} else {
+				validity[i/8] |= 1 << uint(i%8)
+			}
+		}
+		nodes = appendInt64s(nodes, int64(n), int64(nullN))
+		addBuffer(validity)
+
+		switch c.kind {
+		case columnInteger, columnTimestamp:
+			data := make([]byte, 8*n)
+			for i, v := range values {
+				if v, ok := v.(int64); ok {
+					binary.LittleEndian.PutUint64(data[8*i:], uint64(v))
+				}
+			}
+			addBuffer(data)
+		case columnUnsigned:
+			data := make([]byte, 8*n)
+			for i, v := range values {
+				if v, ok := v.(uint64); ok {
+					binary.LittleEndian.PutUint64(data[8*i:], v)
+				}
+			}
+			addBuffer(data)
+		case columnFloat:
+			data := make([]byte, 8*n)
+			for i, v := range values {
+				var f float64
+				switch v := v.(type) {
+				case float64:
+					f = v
+				case int64:
+					f = float64(v)
+				case uint64:
+					f = float64(v)
+				}
+				binary.LittleEndian.PutUint64(data[8*i:], math.Float64bits(f))
+			}
+			addBuffer(data)
+		case columnBoolean:
+			data := make([]byte, (n+7)/8)
+			for i, v := range values {
+				if v == true {
+					data[i/8] |= 1 << uint(i%8)
+				}
+			}
+			addBuffer(data)
+		default:
+			offsets := make([]byte, 4*(n+1))
+			var data []byte
+			for i, v := range values {
+				switch v := v.(type) {
+				case nil:
+				case string:
+					data = append(data, v...)
+				default:
+					b, _ := json.Marshal(v)
+					data = append(data, b...)
+				}
+				binary.LittleEndian.PutUint32(offsets[4*(i+1):], uint32(len(data)))
+			}
+			addBuffer(offsets)
+			addBuffer(data)
+		}
+	}
+
+	return &fbTable{
+		fbScalar{8, uint64(n)},
+		fbStructs{align: 8, n: len(nodes) / 16, data: nodes},
+		fbStructs{align: 8, n: len(buffers) / 16, data: buffers},
+	}, body
+}
+
+// writeArrowMessage writes an encapsulated IPC message: a continuation
+// marker, the length of the metadata, the Message flatbuffer padded to
+// 8 bytes and the body.
+func writeArrowMessage(buf *bytes.Buffer, headerType int, header *fbTable, body []byte) {
+	meta := marshalFlatbuffer(&fbTable{
+		fbScalar{2, arrowMetadataV5},
+		fbScalar{1, uint64(headerType)},
+		header,
+		fbScalar{8, uint64(len(body))},
+	})
+	for (8+len(meta))%8 != 0 {
+		meta = append(meta, 0)
+	}
+
+	var prefix [8]byte
+	binary.LittleEndian.PutUint32(prefix[0:], 0xFFFFFFFF)
+	binary.LittleEndian.PutUint32(prefix[4:], uint32(len(meta)))
+	buf.Write(prefix[:])
+	buf.Write(meta)
+	buf.Write(body)
+}
+
+// appendInt64s appends little endian int64s to b.
+func appendInt64s(b []byte, a ...int64) []byte {
+	for _, v := range a {
+		var tmp [8]byte
+		binary.LittleEndian.PutUint64(tmp[:], uint64(v))
+		b = append(b, tmp[:]...)
+	}
+	return b
+}
+
+func boolToUint64(v bool) uint64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// fbTable is a flatbuffer table. Each element is the field with that id:
+// an fbScalar, fbString, *fbTable, fbTables, fbStructs or nil if absent.
+type fbTable []interface{}
+
+// fbScalar is a little endian scalar field of 1, 2, 4 or 8 bytes.
+type fbScalar struct {
+	size  int
+	value uint64
+}
+
+// fbString is a string field.
+type fbString string
+
+// fbTables is a vector of tables.
+type fbTables []*fbTable
+
+// fbStructs is a vector of n structs encoded in data.
+type fbStructs struct {
+	align int
+	n     int
+	data  []byte
+}
+
+// marshalFlatbuffer encodes a table as the root of a flatbuffer. Objects are
+// written front to back, so each vtable precedes its table and referenced
+// objects follow the field that refers to them.
+func marshalFlatbuffer(root *fbTable) []byte {
+	var b fbBuilder
+	b.buf = make([]byte, 4)
+	pos := b.writeTable(root)
+	binary.LittleEndian.PutUint32(b.buf[0:], uint32(pos))
+	return b.buf
+}
+
+// fbBuilder writes flatbuffer objects to a buffer.
+type fbBuilder struct {
+	buf []byte
+}
+
+// pad appends zeros until the buffer length is a multiple of n.
+func (b *fbBuilder) pad(n int) {
+	for len(b.buf)%n != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+// patch sets the offset at pos to refer to target.
+func (b *fbBuilder) patch(pos, target int) {
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(target-pos))
+}
+
+// writeTable writes a table's vtable, its fields and the objects it refers
+// to. Returns the position of the table.
+func (b *fbBuilder) writeTable(t *fbTable) int {
+	// Lay out the fields after the vtable offset, aligned to their size.
+	// Tables start on an 8 byte boundary so the alignment is absolute.
+	offsets := make([]int, len(*t))
+	size := 4
+	for i, f := range *t {
+		n := 4
+		switch f := f.(type) {
+		case nil:
+			continue
+		case fbScalar:
+			n = f.size
+		}
+		for size%n != 0 {
+			size++
+		}
+		offsets[i] = size
+		size += n
+	}
+
+	// Write the vtable.
+	b.pad(2)
+	vtable := len(b.buf)
+	b.buf = appendUint16(b.buf, uint16(4+2*len(*t)))
+	b.buf = appendUint16(b.buf, uint16(size))
+	for _, off := range offsets {
+		b.buf = appendUint16(b.buf, uint16(off))
+	}
+
+	// Write the table with its scalars.
+	b.pad(8)
+	pos := len(b.buf)
+	b.buf = append(b.buf, make([]byte, size)...)
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(pos-vtable))
+	for i, f := range *t {
+		if f, ok := f.(fbScalar); ok {
+			var tmp [8]byte
+			binary.LittleEndian.PutUint64(tmp[:], f.value)
+			copy(b.buf[pos+offsets[i]:], tmp[:f.size])
+		}
+	}
+
+	// Write the referenced objects after the table.
+	for i, f := range *t {
+		switch f := f.(type) {
+		case fbString:
+			b.patch(pos+offsets[i], b.writeString(string(f)))
+		case *fbTable:
+			b.patch(pos+offsets[i], b.writeTable(f))
+		case fbTables:
+			b.patch(pos+offsets[i], b.writeTables(f))
+		case fbStructs:
+			b.patch(pos+offsets[i], b.writeStructs(f))
+		}
+	}
+	return pos
+}
+
+// writeString writes a length prefixed, null terminated string.
+func (b *fbBuilder) writeString(s string) int {
+	b.pad(4)
+	pos := len(b.buf)
+	b.buf = appendUint32(b.buf, uint32(len(s)))
+	b.buf = append(b.buf, s...)
+	b.buf = append(b.buf, 0)
+	return pos
+}
+
+// writeTables writes a vector of offsets followed by the tables.
+func (b *fbBuilder) writeTables(a fbTables) int {
+	b.pad(4)
+	pos := len(b.buf)
+	b.buf = appendUint32(b.buf, uint32(len(a)))
+	b.buf = append(b.buf, make([]byte, 4*len(a))...)
+	for i, t := range a {
+		b.patch(pos+4+4*i, b.writeTable(t))
+	}
+	return pos
+}
+
+// writeStructs writes a vector of structs with the structs aligned.
+func (b *fbBuilder) writeStructs(v fbStructs) int {
+	for (len(b.buf)+4)%v.align != 0 {
+		b.buf = append(b.buf, 0)
+	}
+	pos := len(b.buf)
+	b.buf = appendUint32(b.buf, uint32(v.n))
+	b.buf = append(b.buf, v.data...)
+	return pos
+}
+
+func appendUint16(b []byte, v uint16) []byte { return append(b, byte(v), byte(v>>8)) }
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
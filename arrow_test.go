@@ -0,0 +1,209 @@
+package influxdb
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Ensure rows are encoded as an Arrow stream with a schema and a record batch per row.
+func TestMarshalArrowStream(t *testing.T) {
+	rows := []*influxql.Row{
+		{
+			Name:    "cpu",
+			Tags:    map[string]string{"host": "serverA"},
+			Columns: []string{"time", "value", "ok"},
+			Values: [][]interface{}{
+				{int64(10), float64(1.5), true},
+				{int64(20), int64(2), nil},
+			},
+		},
+		{
+			Name:    "mem",
+			Columns: []string{"time", "value", "msg"},
+			Values: [][]interface{}{
+				{int64(30), float64(3), "hello"},
+			},
+		},
+	}
+	buf := marshalArrowStream(rows, 2)
+
+	// Read the encapsulated messages up to the end of stream marker.
+	var messages []*testArrowMessage
+	for {
+		if len(buf) < 8 || binary.LittleEndian.Uint32(buf) != 0xFFFFFFFF {
+			t.Fatalf("expected continuation marker: %x", buf)
+		}
+		n := int(binary.LittleEndian.Uint32(buf[4:]))
+		if n == 0 {
+			if len(buf) != 8 {
+				t.Fatalf("unexpected data after end of stream: %x", buf[8:])
+			}
+			break
+		} else if (8+n)%8 != 0 {
+			t.Fatalf("metadata not padded: %d", n)
+		}
+		meta := fbTestTable{buf[8 : 8+n], int(binary.LittleEndian.Uint32(buf[8:]))}
+		bodyLength := int(meta.scalar(3, 8))
+		messages = append(messages, &testArrowMessage{meta: meta, body: buf[8+n : 8+n+bodyLength]})
+		buf = buf[8+n+bodyLength:]
+	}
+	if len(messages) != 3 {
+		t.Fatalf("unexpected message count: %d", len(messages))
+	}
+
+	// Verify the schema.
+	schema := messages[0].meta
+	if v := schema.scalar(0, 2); v != arrowMetadataV5 {
+		t.Fatalf("unexpected version: %d", v)
+	} else if v := schema.scalar(1, 1); v != arrowHeaderSchema {
+		t.Fatalf("unexpected header type: %d", v)
+	}
+	fields := schema.table(2).tables(1)
+	var names []string
+	var types []uint64
+	for _, f := range fields {
+		names = append(names, f.string(0))
+		types = append(types, f.scalar(2, 1))
+	}
+	if !reflect.DeepEqual(names, []string{"name", "host", "time", "value", "ok", "msg"}) {
+		t.Fatalf("unexpected field names: %v", names)
+	} else if !reflect.DeepEqual(types, []uint64{arrowTypeUtf8, arrowTypeUtf8, arrowTypeTimestamp, arrowTypeFloatingPoint, arrowTypeBool, arrowTypeUtf8}) {
+		t.Fatalf("unexpected field types: %v", types)
+	}
+	if ts := fields[2].table(3); ts.scalar(0, 2) != 2 || ts.string(1) != "UTC" {
+		t.Fatalf("unexpected timestamp type: unit=%d tz=%s", ts.scalar(0, 2), ts.string(1))
+	} else if fp := fields[3].table(3); fp.scalar(0, 2) != arrowPrecisionDouble {
+		t.Fatalf("unexpected float precision: %d", fp.scalar(0, 2))
+	}
+
+	// Verify the first record batch.
+	m := messages[1]
+	if v := m.meta.scalar(1, 1); v != arrowHeaderRecordBatch {
+		t.Fatalf("unexpected header type: %d", v)
+	}
+	batch := m.meta.table(2)
+	if n := batch.scalar(0, 8); n != 2 {
+		t.Fatalf("unexpected length: %d", n)
+	}
+	nodes, buffers := batch.structs(1, 16), batch.structs(2, 16)
+	if len(nodes) != 6 {
+		t.Fatalf("unexpected node count: %d", len(nodes))
+	} else if len(buffers) != 15 {
+		t.Fatalf("unexpected buffer count: %d", len(buffers))
+	}
+	for _, b := range buffers {
+		if off := binary.LittleEndian.Uint64(b); off%8 != 0 {
+			t.Fatalf("buffer not aligned: %d", off)
+		}
+	}
+	buffer := func(i int) []byte {
+		off, n := binary.LittleEndian.Uint64(buffers[i]), binary.LittleEndian.Uint64(buffers[i][8:])
+		return m.body[off : off+n]
+	}
+
+	// name: validity, offsets, data.
+	if s := string(buffer(2)); s != "cpucpu" {
+		t.Fatalf("unexpected names: %s", s)
+	}
+	// host: validity, offsets, data.
+	if s := string(buffer(5)); s != "serverAserverA" {
+		t.Fatalf("unexpected hosts: %s", s)
+	}
+	// time: validity, data.
+	if b := buffer(7); binary.LittleEndian.Uint64(b) != 10 || binary.LittleEndian.Uint64(b[8:]) != 20 {
+		t.Fatalf("unexpected times: %x", b)
+	}
+	// value: validity, data. Integers are written as doubles.
+	if b := buffer(9); math.Float64frombits(binary.LittleEndian.Uint64(b)) != 1.5 || math.Float64frombits(binary.LittleEndian.Uint64(b[8:])) != 2 {
+		t.Fatalf("unexpected values: %x", b)
+	}
+	// ok: the second value is null.
+	if null := binary.LittleEndian.Uint64(nodes[4][8:]); null != 1 {
+		t.Fatalf("unexpected null count: %d", null)
+	} else if b := buffer(10); b[0] != 0x01 {
+		t.Fatalf("unexpected validity: %x", b)
+	} else if b := buffer(11); b[0] != 0x01 {
+		t.Fatalf("unexpected bools: %x", b)
+	}
+	// msg: missing from the row so every value is null.
+	if null := binary.LittleEndian.Uint64(nodes[5][8:]); null != 2 {
+		t.Fatalf("unexpected null count: %d", null)
+	}
+}
+
+// testArrowMessage is an encapsulated message read from an Arrow stream.
+type testArrowMessage struct {
+	meta fbTestTable
+	body []byte
+}
+
+// fbTestTable reads the fields of a flatbuffer table at pos.
+type fbTestTable struct {
+	buf []byte
+	pos int
+}
+
+// field returns the position of a field or zero if it's absent.
+func (t fbTestTable) field(id int) int {
+	vtable := t.pos - int(int32(binary.LittleEndian.Uint32(t.buf[t.pos:])))
+	if 4+2*id >= int(binary.LittleEndian.Uint16(t.buf[vtable:])) {
+		return 0
+	}
+	off := int(binary.LittleEndian.Uint16(t.buf[vtable+4+2*id:]))
+	if off == 0 {
+		return 0
+	}
+	return t.pos + off
+}
+
+// ref returns the position an offset field refers to.
+func (t fbTestTable) ref(id int) int {
+	pos := t.field(id)
+	return pos + int(binary.LittleEndian.Uint32(t.buf[pos:]))
+}
+
+func (t fbTestTable) scalar(id, size int) uint64 {
+	pos := t.field(id)
+	if pos == 0 {
+		return 0
+	}
+	var tmp [8]byte
+	copy(tmp[:], t.buf[pos:pos+size])
+	return binary.LittleEndian.Uint64(tmp[:])
+}
+
+func (t fbTestTable) string(id int) string {
+	pos := t.ref(id)
+	n := int(binary.LittleEndian.Uint32(t.buf[pos:]))
+	return string(t.buf[pos+4 : pos+4+n])
+}
+
+func (t fbTestTable) table(id int) fbTestTable {
+	return fbTestTable{t.buf, t.ref(id)}
+}
+
+func (t fbTestTable) tables(id int) []fbTestTable {
+	pos := t.ref(id)
+	a := make([]fbTestTable, binary.LittleEndian.Uint32(t.buf[pos:]))
+	for i := range a {
+		elem := pos + 4 + 4*i
+		a[i] = fbTestTable{t.buf, elem + int(binary.LittleEndian.Uint32(t.buf[elem:]))}
+	}
+	return a
+}
+
+func (t fbTestTable) structs(id, size int) [][]byte {
+	pos := t.ref(id)
+	if (pos+4)%8 != 0 {
+		panic("structs not aligned")
+	}
+	a := make([][]byte, binary.LittleEndian.Uint32(t.buf[pos:]))
+	for i := range a {
+		a[i] = t.buf[pos+4+size*i : pos+4+size*(i+1)]
+	}
+	return a
+}
@@ -0,0 +1,163 @@
+package influxdb
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// DefaultAuditExportSize is the maximum number of audit entries held for
+// export to the monitoring database between writes.
+const DefaultAuditExportSize = 1000
+
+// AuditEntry represents an administrative action recorded in the audit log.
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user,omitempty"`   // blank if authentication is disabled
+	Source string    `json:"source,omitempty"` // address of the client
+	Action string    `json:"action"`
+	Target string    `json:"target,omitempty"` // name of the database, policy, user or node
+}
+
+// SetAuditLog opens the append-only file that administrative actions are
+// recorded to. An empty path stops recording.
+func (s *Server) SetAuditLog(path string) error {
+	var f *os.File
+	if path != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		var err error
+		if f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600); err != nil {
+			return err
+		}
+	}
+
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	if s.auditFile != nil {
+		_ = s.auditFile.Close()
+	}
+	s.auditFile, s.auditPath = f, path
+	return nil
+}
+
+// audit records a successful administrative action. The entry is also held
+// for export if self-monitoring is running.
+func (s *Server) audit(user *User, source, action, target string) {
+	e := &AuditEntry{Time: time.Now().UTC(), Source: source, Action: action, Target: target}
+	if user != nil {
+		e.User = user.Name
+	}
+
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+
+	if s.auditFile != nil {
+		if _, err := s.auditFile.Write(append(mustMarshalJSON(e), '\n')); err != nil {
+			s.Logger.Printf("audit: %s", err)
+		}
+	}
+
+	if s.auditExport {
+		if len(s.auditPending) >= DefaultAuditExportSize {
+			s.auditPending = s.auditPending[1:]
+		}
+		s.auditPending = append(s.auditPending, e)
+	}
+}
+
+// AuditLog returns the most recent n entries in the audit log, oldest first.
+// All entries are returned if n is zero.
+func (s *Server) AuditLog(n int) ([]*AuditEntry, error) {
+	s.auditMu.Lock()
+	path := s.auditPath
+	s.auditMu.Unlock()
+	if path == "" {
+		return nil, ErrAuditLogDisabled
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var a []*AuditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue // skip a partially written last line
+		}
+		a = append(a, &e)
+		if n > 0 && len(a) > n {
+			a = a[1:]
+		}
+	}
+	return a, scanner.Err()
+}
+
+// takeAuditPending returns and clears the entries held for export.
+func (s *Server) takeAuditPending() []*AuditEntry {
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	a := s.auditPending
+	s.auditPending = nil
+	return a
+}
+
+// exportAudit writes the entries held for export as points in a measurement
+// named "audit" on the given database and retention policy.
+func (s *Server) exportAudit(database, retention string) {
+	for _, e := range s.takeAuditPending() {
+		tags := map[string]string{"action": e.Action, "user": e.User}
+		values := map[string]interface{}{"target": e.Target, "source": e.Source}
+		if err := s.WriteSeries(database, retention, "audit", tags, e.Time, values); err != nil {
+			s.Logger.Printf("monitor: audit: %s", err)
+		}
+	}
+}
+
+// auditAction returns the audit log action and target for a statement that
+// changes metadata or deletes data. Returns a blank action for other statements.
+func auditAction(stmt influxql.Statement, database string) (action, target string) {
+	switch stmt := stmt.(type) {
+	case *influxql.AlterDatabaseStatement:
+		return "alter database", stmt.Name
+	case *influxql.AlterRetentionPolicyStatement:
+		return "alter retention policy", stmt.Database + "." + stmt.Name
+	case *influxql.DeleteStatement:
+		if stmt.Source != nil {
+			return "delete", database + "." + stmt.Source.String()
+		}
+		return "delete", database
+	}
+	return "", ""
+}
+
+// executeShowAuditStatement returns the most recent audit log entries.
+// Requires an admin user.
+func (s *Server) executeShowAuditStatement(stmt *influxql.ShowAuditStatement, user *User) *Result {
+	if user != nil && !user.Admin {
+		return &Result{Err: ErrAdminRequired}
+	}
+
+	a, err := s.AuditLog(stmt.Limit)
+	if err != nil {
+		return &Result{Err: err}
+	}
+
+	row := &influxql.Row{
+		Name:    "audit",
+		Columns: []string{"time", "user", "source", "action", "target"},
+	}
+	for _, e := range a {
+		row.Values = append(row.Values, []interface{}{e.Time.Format(time.RFC3339Nano), e.User, e.Source, e.Action, e.Target})
+	}
+	return &Result{Rows: []*influxql.Row{row}}
+}
@@ -0,0 +1,129 @@
+package influxdb
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"sync"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// DefaultAuthCacheTTL is the default length of time that credentials
+// verified by an external service are trusted before being checked again.
+const DefaultAuthCacheTTL = 5 * time.Minute
+
+// Authenticator verifies the credentials of requests to the handler.
+// The server's user store is used unless another authenticator is set.
+type Authenticator interface {
+	// Authenticate returns the user with the given credentials.
+	// Returns an error if the credentials are invalid.
+	Authenticate(username, password string) (*User, error)
+}
+
+// ExternalVerifier checks credentials against an external service, such as
+// LDAP or PAM, and returns the groups that the user belongs to.
+type ExternalVerifier interface {
+	Verify(username, password string) (groups []string, err error)
+}
+
+// ExternalAuthenticator authenticates users with an external service instead
+// of the server's user store.
+//
+// Users must belong to at least one group in Groups. Members of a group that
+// is granted influxql.AllPrivileges are admins. Verified credentials are
+// cached for CacheTTL so the service is not queried on every request.
+type ExternalAuthenticator struct {
+	mu    sync.Mutex
+	salt  []byte
+	cache map[string]*authCacheEntry
+
+	// The service used to verify credentials.
+	Verifier ExternalVerifier
+
+	// Privileges granted to the members of each group.
+	Groups map[string]influxql.Privilege
+
+	// Length of time verified credentials are cached. Zero disables caching.
+	CacheTTL time.Duration
+}
+
+// authCacheEntry represents a user whose credentials have been verified.
+type authCacheEntry struct {
+	hash    [sha256.Size]byte // salted password hash
+	user    *User
+	expires time.Time
+}
+
+// NewExternalAuthenticator returns a new instance of ExternalAuthenticator.
+func NewExternalAuthenticator(v ExternalVerifier) *ExternalAuthenticator {
+	salt := make([]byte, 16)
+	_, _ = rand.Read(salt)
+	return &ExternalAuthenticator{
+		salt:     salt,
+		cache:    make(map[string]*authCacheEntry),
+		Verifier: v,
+		Groups:   make(map[string]influxql.Privilege),
+		CacheTTL: DefaultAuthCacheTTL,
+	}
+}
+
+// Authenticate returns the user with the given credentials. Cached users are
+// returned without querying the external service.
+func (a *ExternalAuthenticator) Authenticate(username, password string) (*User, error) {
+	hash := a.hash(password)
+
+	// Return the cached user if the password matches.
+	a.mu.Lock()
+	e := a.cache[username]
+	if e != nil && time.Now().Before(e.expires) && subtle.ConstantTimeCompare(e.hash[:], hash[:]) == 1 {
+		a.mu.Unlock()
+		return e.user, nil
+	}
+	a.mu.Unlock()
+
+	// Verify the credentials and map the user's groups to privileges.
+	groups, err := a.Verifier.Verify(username, password)
+	if err != nil {
+		return nil, err
+	}
+	u, err := a.user(username, groups)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cache the user.
+	if a.CacheTTL > 0 {
+		a.mu.Lock()
+		a.cache[username] = &authCacheEntry{hash: hash, user: u, expires: time.Now().Add(a.CacheTTL)}
+		a.mu.Unlock()
+	}
+	return u, nil
+}
+
+// user returns a user with the privileges granted to its groups.
+// Returns ErrUserNotAuthorized if none of the groups are mapped.
+func (a *ExternalAuthenticator) user(username string, groups []string) (*User, error) {
+	var authorized bool
+	u := &User{Name: username}
+	for _, g := range groups {
+		p, ok := a.Groups[g]
+		if !ok {
+			continue
+		}
+		authorized = true
+		if p == influxql.AllPrivileges {
+			u.Admin = true
+		}
+	}
+	if !authorized {
+		return nil, ErrUserNotAuthorized
+	}
+	return u, nil
+}
+
+// hash returns the salted hash of a password.
+func (a *ExternalAuthenticator) hash(password string) [sha256.Size]byte {
+	return sha256.Sum256(append(append([]byte{}, a.salt...), password...))
+}
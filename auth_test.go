@@ -0,0 +1,83 @@
+package influxdb_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Ensure the external authenticator maps groups to privileges.
+func TestExternalAuthenticator_Authenticate(t *testing.T) {
+	v := &Verifier{Groups: map[string][]string{"lisa": {"ops"}, "bob": {"dev"}, "sue": {"sales"}}}
+	a := influxdb.NewExternalAuthenticator(v)
+	a.Groups["ops"] = influxql.AllPrivileges
+	a.Groups["dev"] = influxql.ReadPrivilege
+
+	if u, err := a.Authenticate("lisa", "password"); err != nil {
+		t.Fatal(err)
+	} else if u.Name != "lisa" || !u.Admin {
+		t.Fatalf("unexpected user: %#v", u)
+	}
+	if u, err := a.Authenticate("bob", "password"); err != nil {
+		t.Fatal(err)
+	} else if u.Name != "bob" || u.Admin {
+		t.Fatalf("unexpected user: %#v", u)
+	}
+	if _, err := a.Authenticate("sue", "password"); err != influxdb.ErrUserNotAuthorized {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := a.Authenticate("lisa", "wrong"); err != errInvalidCredentials {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure the external authenticator caches verified credentials.
+func TestExternalAuthenticator_Authenticate_Cache(t *testing.T) {
+	v := &Verifier{Groups: map[string][]string{"lisa": {"ops"}}}
+	a := influxdb.NewExternalAuthenticator(v)
+	a.Groups["ops"] = influxql.AllPrivileges
+
+	for i := 0; i < 3; i++ {
+		if _, err := a.Authenticate("lisa", "password"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v.N != 1 {
+		t.Fatalf("unexpected verify count: %d", v.N)
+	}
+
+	// A different password must be verified again.
+	if _, err := a.Authenticate("lisa", "wrong"); err != errInvalidCredentials {
+		t.Fatalf("unexpected error: %s", err)
+	} else if v.N != 2 {
+		t.Fatalf("unexpected verify count: %d", v.N)
+	}
+
+	// Disabling the cache verifies every request.
+	b := influxdb.NewExternalAuthenticator(v)
+	b.Groups["ops"] = influxql.AllPrivileges
+	b.CacheTTL = 0
+	b.Authenticate("lisa", "password")
+	b.Authenticate("lisa", "password")
+	if v.N != 4 {
+		t.Fatalf("unexpected verify count: %d", v.N)
+	}
+}
+
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// Verifier is a mock external verifier that accepts the password "password".
+type Verifier struct {
+	Groups map[string][]string
+	N      int
+}
+
+func (v *Verifier) Verify(username, password string) ([]string, error) {
+	v.N++
+	if password != "password" {
+		return nil, errInvalidCredentials
+	}
+	return v.Groups[username], nil
+}
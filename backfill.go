@@ -0,0 +1,221 @@
+package influxdb
+
+import (
+	"time"
+)
+
+// backfillChunkIntervals is the number of downsampling intervals recomputed
+// by each step of a backfill. Progress is recorded after every step.
+const backfillChunkIntervals = 100
+
+// maxBackfillHistory is the number of finished backfills that are kept.
+const maxBackfillHistory = 100
+
+// Backfill represents a run of a downsampling rule over a historical time
+// range, such as after points were imported into the default retention policy.
+// Aggregates already written for the range are replaced.
+type Backfill struct {
+	ID              uint64    `json:"id"`
+	Database        string    `json:"database"`
+	RetentionPolicy string    `json:"retentionPolicy"`
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+
+	// Maximum rate that aggregates are written at. Zero means no limit.
+	PointsPerSecond int `json:"pointsPerSecond,omitempty"`
+
+	// End of the last interval that has been recomputed.
+	Completed time.Time `json:"completed"`
+
+	// Number of aggregates written so far.
+	PointsWritten int `json:"pointsWritten"`
+
+	// Set once the backfill has finished. Err is set if it failed.
+	Done bool   `json:"done"`
+	Err  string `json:"error,omitempty"`
+}
+
+// StartBackfill recomputes the aggregates of the downsampling rule of a
+// retention policy from start to end in the background. The range is widened
+// to whole intervals and ends at the last interval that ended before now.
+// Backfills stop once the server is closed.
+func (s *Server) StartBackfill(database, name string, start, end time.Time, pointsPerSecond int) (*Backfill, error) {
+	if !start.Before(end) {
+		return nil, ErrInvalidBackfillRange
+	} else if pointsPerSecond < 0 {
+		pointsPerSecond = 0
+	}
+
+	s.mu.RLock()
+	closing := s.closing
+	_, _, rp, err := s.downsamplePolicies(database, name)
+	var interval time.Duration
+	if err == nil {
+		interval = rp.Downsample.Interval
+	}
+	s.mu.RUnlock()
+
+	if closing == nil {
+		return nil, ErrServerClosed
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Align the range to the rule's intervals.
+	start = start.UTC().Truncate(interval)
+	if t := end.UTC().Truncate(interval); t.Before(end) {
+		end = t.Add(interval)
+	} else {
+		end = t
+	}
+	if now := time.Now().UTC().Truncate(interval); end.After(now) {
+		end = now
+	}
+	if !start.Before(end) {
+		return nil, ErrInvalidBackfillRange
+	}
+
+	b := &Backfill{
+		Database:        database,
+		RetentionPolicy: name,
+		Start:           start,
+		End:             end,
+		PointsPerSecond: pointsPerSecond,
+		Completed:       start,
+	}
+
+	s.backfillMu.Lock()
+	s.backfillID++
+	b.ID = s.backfillID
+	s.backfills = append(s.backfills, b)
+	s.trimBackfills()
+	other := *b
+	s.backfillMu.Unlock()
+
+	s.wg.Add(1)
+	go s.runBackfill(b, interval, closing)
+	return &other, nil
+}
+
+// trimBackfills removes the oldest finished backfills once there are more
+// than maxBackfillHistory. The backfill lock must be held.
+func (s *Server) trimBackfills() {
+	n := len(s.backfills) - maxBackfillHistory
+	if n <= 0 {
+		return
+	}
+	a := s.backfills[:0]
+	for _, b := range s.backfills {
+		if n > 0 && b.Done {
+			n--
+			continue
+		}
+		a = append(a, b)
+	}
+	s.backfills = a
+}
+
+// Backfills returns the progress of running and recently finished backfills.
+func (s *Server) Backfills() []*Backfill {
+	s.backfillMu.Lock()
+	defer s.backfillMu.Unlock()
+	a := make([]*Backfill, len(s.backfills))
+	for i, b := range s.backfills {
+		other := *b
+		a[i] = &other
+	}
+	return a
+}
+
+// runBackfill runs a backfill and records its result.
+func (s *Server) runBackfill(b *Backfill, interval time.Duration, closing <-chan struct{}) {
+	defer s.wg.Done()
+
+	err := s.backfill(b, interval, closing)
+	if err != nil {
+		s.Logger.Printf("backfill %d: %s", b.ID, err)
+	}
+
+	s.backfillMu.Lock()
+	defer s.backfillMu.Unlock()
+	b.Done = true
+	if err != nil {
+		b.Err = err.Error()
+	}
+}
+
+// backfill recomputes the aggregates of a backfill's range a chunk of
+// intervals at a time. Writes are throttled to the backfill's rate.
+func (s *Server) backfill(b *Backfill, interval time.Duration, closing <-chan struct{}) error {
+	for start := b.Start; start.Before(b.End); {
+		end := start.Add(interval * backfillChunkIntervals)
+		if end.After(b.End) {
+			end = b.End
+		}
+
+		// Build the task from the current schema so that measurements
+		// created during the backfill are included.
+		t0 := time.Now()
+		t, err := s.backfillTask(b.Database, b.RetentionPolicy, start, end)
+		if err != nil {
+			return err
+		}
+		var n int
+		for _, m := range t.measurements {
+			written, err := s.downsampleMeasurement(t, m)
+			if err != nil {
+				return err
+			}
+			n += written
+		}
+
+		s.backfillMu.Lock()
+		b.Completed, b.PointsWritten = end, b.PointsWritten+n
+		s.backfillMu.Unlock()
+
+		// Wait until the points written are within the rate limit.
+		var wait time.Duration
+		if b.PointsPerSecond > 0 {
+			wait = time.Duration(n)*time.Second/time.Duration(b.PointsPerSecond) - time.Since(t0)
+		}
+		if wait < 0 {
+			wait = 0
+		}
+		select {
+		case <-closing:
+			return ErrServerClosed
+		case <-time.After(wait):
+		}
+		start = end
+	}
+	return nil
+}
+
+// backfillTask returns a downsampling task for part of a backfill.
+func (s *Server) backfillTask(database, name string, start, end time.Time) (*downsampleTask, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	db, def, rp, err := s.downsamplePolicies(database, name)
+	if err != nil {
+		return nil, err
+	}
+	return newDownsampleTask(db, def, rp, start, end)
+}
+
+// downsamplePolicies returns a database, its default retention policy and a
+// retention policy with a downsampling rule. The server's lock must be held.
+func (s *Server) downsamplePolicies(dbName, name string) (*database, *RetentionPolicy, *RetentionPolicy, error) {
+	db := s.databases[dbName]
+	if db == nil {
+		return nil, nil, nil, ErrDatabaseNotFound
+	}
+	def, rp := db.policies[db.defaultRetentionPolicy], db.policies[name]
+	if rp == nil {
+		return nil, nil, nil, ErrRetentionPolicyNotFound
+	} else if rp.Downsample == nil {
+		return nil, nil, nil, ErrDownsampleNotFound
+	} else if def == nil || def == rp {
+		return nil, nil, nil, ErrDownsampleDefaultPolicy
+	}
+	return db, def, rp, nil
+}
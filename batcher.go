@@ -0,0 +1,154 @@
+package influxdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errPointBatchTruncated is returned when a point batch ends unexpectedly.
+var errPointBatchTruncated = errors.New("point batch truncated")
+
+// pointBatcher coalesces encoded points written concurrently to the same shard
+// so they are published to the broker, and committed to the shard, together.
+//
+// A batch is published once it holds the maximum number of points or once the
+// first point in it has waited for the maximum delay. Writers block until
+// their batch has been published.
+type pointBatcher struct {
+	mu      sync.Mutex
+	size    int                    // maximum points per batch
+	delay   time.Duration          // maximum time a point waits for a batch to fill
+	batches map[uint64]*pointBatch // pending batches by shard id
+
+	// Publishes a batch of encoded points to a shard's topic.
+	publish func(topicID uint64, points [][]byte) error
+}
+
+// newPointBatcher returns a new instance of pointBatcher.
+// Batching is disabled until a size and delay are set.
+func newPointBatcher(publish func(topicID uint64, points [][]byte) error) *pointBatcher {
+	return &pointBatcher{
+		batches: make(map[uint64]*pointBatch),
+		publish: publish,
+	}
+}
+
+// pointBatch represents a set of points waiting to be published to a shard.
+type pointBatch struct {
+	topicID uint64
+	points  [][]byte
+	timer   *time.Timer
+
+	done chan struct{} // closed once the batch is published
+	err  error         // publish error; set before done is closed
+}
+
+// wait blocks until the batch is published and returns the publish error.
+func (b *pointBatch) wait() error {
+	<-b.done
+	return b.err
+}
+
+// setConfig sets the maximum batch size and delay. A size of one or less or
+// a delay of zero disables batching. Pending batches keep their timers.
+func (pb *pointBatcher) setConfig(size int, delay time.Duration) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	pb.size, pb.delay = size, delay
+}
+
+// add queues an encoded point for a shard and returns the batch it was added to.
+// If batching is disabled or the batch is full then it is published immediately.
+func (pb *pointBatcher) add(topicID uint64, data []byte) *pointBatch {
+	pb.mu.Lock()
+
+	// Publish the point by itself if batching is disabled.
+	if pb.size <= 1 || pb.delay <= 0 {
+		pb.mu.Unlock()
+		b := &pointBatch{topicID: topicID, points: [][]byte{data}, done: make(chan struct{})}
+		pb.flush(b)
+		return b
+	}
+
+	// Start a new batch if there isn't one pending for the shard.
+	b := pb.batches[topicID]
+	if b == nil {
+		b = &pointBatch{topicID: topicID, done: make(chan struct{})}
+		b.timer = time.AfterFunc(pb.delay, func() { pb.expire(b) })
+		pb.batches[topicID] = b
+	}
+	b.points = append(b.points, data)
+
+	// Leave the batch pending until it fills or its delay expires.
+	if len(b.points) < pb.size {
+		pb.mu.Unlock()
+		return b
+	}
+
+	// Otherwise remove the full batch and publish it from this goroutine.
+	delete(pb.batches, topicID)
+	b.timer.Stop()
+	pb.mu.Unlock()
+
+	pb.flush(b)
+	return b
+}
+
+// expire publishes a batch once its delay has elapsed.
+// The batch is ignored if it has already been published because it filled up.
+func (pb *pointBatcher) expire(b *pointBatch) {
+	pb.mu.Lock()
+	if pb.batches[b.topicID] != b {
+		pb.mu.Unlock()
+		return
+	}
+	delete(pb.batches, b.topicID)
+	pb.mu.Unlock()
+
+	pb.flush(b)
+}
+
+// flush publishes a batch and notifies its writers.
+func (pb *pointBatcher) flush(b *pointBatch) {
+	b.err = pb.publish(b.topicID, b.points)
+	close(b.done)
+}
+
+// marshalPointBatch encodes a list of encoded points into a single message.
+// Each point is prefixed with its length.
+func marshalPointBatch(points [][]byte) []byte {
+	n := 0
+	for _, p := range points {
+		n += 4 + len(p)
+	}
+
+	b := make([]byte, 0, n)
+	for _, p := range points {
+		var buf [4]byte
+		binary.BigEndian.PutUint32(buf[:], uint32(len(p)))
+		b = append(b, buf[:]...)
+		b = append(b, p...)
+	}
+	return b
+}
+
+// unmarshalPointBatch decodes a message created by marshalPointBatch.
+func unmarshalPointBatch(data []byte) ([][]byte, error) {
+	var points [][]byte
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errPointBatchTruncated
+		}
+		n := binary.BigEndian.Uint32(data)
+		data = data[4:]
+
+		if uint32(len(data)) < n {
+			return nil, errPointBatchTruncated
+		}
+		points = append(points, data[:n])
+		data = data[n:]
+	}
+	return points, nil
+}
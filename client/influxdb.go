@@ -3,50 +3,82 @@ package client
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
 	UDPMaxMessageSize = 2048
+
+	// DefaultMaxIdleConns is the default number of idle connections kept
+	// open to each host.
+	DefaultMaxIdleConns = 10
+
+	// DefaultRetryBackoff is the default delay before retrying a request
+	// once every host has failed.
+	DefaultRetryBackoff = 100 * time.Millisecond
+
+	// MaxRetryBackoff is the longest delay between retries.
+	MaxRetryBackoff = 10 * time.Second
 )
 
+// requestIDHeader is the header used by the server to detect retried writes.
+const requestIDHeader = "X-Influxdb-Request-Id"
+
 type Client struct {
-	host        string
-	username    string
-	password    string
-	database    string
-	httpClient  *http.Client
-	udpConn     *net.UDPConn
-	schema      string
-	compression bool
+	mu           sync.Mutex
+	hosts        []string
+	current      int // index of the host requests are sent to first; guarded by mu
+	username     string
+	password     string
+	database     string
+	httpClient   *http.Client
+	udpConn      *net.UDPConn
+	schema       string
+	compression  bool
+	maxRetries   int
+	retryBackoff time.Duration
+	sleep        func(time.Duration) // waits between rounds of retries
 }
 
 type ClientConfig struct {
 	Host       string
+	Hosts      []string // hosts to fail over to, in order, if Host is unavailable
 	Username   string
 	Password   string
 	Database   string
 	HttpClient *http.Client
 	IsSecure   bool
 	IsUDP      bool
+	UnixSocket string // path of the server's unix socket, if connecting locally
+
+	// Connection pool and retry settings. These are ignored by a
+	// user-supplied HttpClient, except for the retries.
+	MaxIdleConns int           // idle connections kept open to each host
+	Timeout      time.Duration // time limit of each attempt, zero is no limit
+	MaxRetries   int           // retries of a failed request, zero is none
+	RetryBackoff time.Duration // delay before the first retry, doubled after each
 }
 
 var defaults *ClientConfig
 
 func init() {
 	defaults = &ClientConfig{
-		Host:       "localhost:8086",
-		Username:   "root",
-		Password:   "root",
-		Database:   "",
-		HttpClient: http.DefaultClient,
+		Host:         "localhost:8086",
+		Username:     "root",
+		Password:     "root",
+		Database:     "",
+		MaxIdleConns: DefaultMaxIdleConns,
+		RetryBackoff: DefaultRetryBackoff,
 	}
 }
 
@@ -66,9 +98,32 @@ func NewClient(config *ClientConfig) (*Client, error) {
 	username := getDefault(config.Username, defaults.Username)
 	password := getDefault(config.Password, defaults.Password)
 	database := getDefault(config.Database, defaults.Database)
+	hosts := append([]string{host}, config.Hosts...)
+
+	// Pool connections to the hosts unless a client is supplied.
 	if config.HttpClient == nil {
-		config.HttpClient = defaults.HttpClient
+		maxIdleConns := config.MaxIdleConns
+		if maxIdleConns == 0 {
+			maxIdleConns = defaults.MaxIdleConns
+		}
+		transport := &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConnsPerHost: maxIdleConns,
+		}
+		if config.UnixSocket != "" {
+			path := config.UnixSocket
+			transport.Proxy = nil
+			transport.Dial = func(network, addr string) (net.Conn, error) {
+				return net.Dial("unix", path)
+			}
+		}
+		config.HttpClient = &http.Client{Transport: transport, Timeout: config.Timeout}
 	}
+	retryBackoff := config.RetryBackoff
+	if retryBackoff == 0 {
+		retryBackoff = defaults.RetryBackoff
+	}
+
 	var udpConn *net.UDPConn
 	if config.IsUDP {
 		serverAddr, err := net.ResolveUDPAddr("udp", host)
@@ -85,7 +140,18 @@ func NewClient(config *ClientConfig) (*Client, error) {
 	if config.IsSecure {
 		schema = "https"
 	}
-	return &Client{host, username, password, database, config.HttpClient, udpConn, schema, false}, nil
+	return &Client{
+		hosts:        hosts,
+		username:     username,
+		password:     password,
+		database:     database,
+		httpClient:   config.HttpClient,
+		udpConn:      udpConn,
+		schema:       schema,
+		maxRetries:   config.MaxRetries,
+		retryBackoff: retryBackoff,
+		sleep:        time.Sleep,
+	}, nil
 }
 
 func (self *Client) DisableCompression() {
@@ -96,8 +162,97 @@ func (self *Client) getUrl(path string) string {
 	return self.getUrlWithUserAndPass(path, self.username, self.password)
 }
 
+// getUrlWithUserAndPass returns the url of a path relative to the host.
+// The host is added when the request is sent so that it can fail over.
 func (self *Client) getUrlWithUserAndPass(path, username, password string) string {
-	return fmt.Sprintf("%s://%s%s?u=%s&p=%s", self.schema, self.host, path, username, password)
+	return fmt.Sprintf("%s?u=%s&p=%s", path, username, password)
+}
+
+// do sends a request to the current host. Requests that fail with a network
+// error, such as a timeout, or a 5xx status are retried against the next
+// host, up to the client's maximum number of retries. Once every host has
+// failed the client waits before retrying, doubling the wait each round.
+//
+// Only idempotent requests are retried after they were sent: GETs, DELETEs
+// and writes with a request id. Other requests are only retried if they
+// could not connect.
+func (self *Client) do(method, url string, header http.Header, body []byte) (*http.Response, error) {
+	idempotent := method == "GET" || method == "DELETE" || header.Get(requestIDHeader) != ""
+
+	self.mu.Lock()
+	start := self.current
+	self.mu.Unlock()
+
+	backoff := self.retryBackoff
+	for attempt := 0; ; attempt++ {
+		i := (start + attempt) % len(self.hosts)
+		req, err := http.NewRequest(method, self.schema+"://"+self.hosts[i]+url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := self.httpClient.Do(req)
+
+		// Send later requests to the next host if this one failed.
+		failed := err != nil || resp.StatusCode >= 500
+		if failed {
+			self.failover(i)
+		}
+		if !failed || attempt >= self.maxRetries || !(idempotent || isDialError(err)) {
+			return resp, err
+		}
+
+		// Discard the failed response so its connection can be reused.
+		if resp != nil {
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+
+		// Wait before starting another round of hosts.
+		if (attempt+1)%len(self.hosts) == 0 {
+			self.sleep(backoff)
+			if backoff *= 2; backoff > MaxRetryBackoff {
+				backoff = MaxRetryBackoff
+			}
+		}
+	}
+}
+
+// failover moves requests from a failed host to the next one, unless
+// another request already has.
+func (self *Client) failover(i int) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.current == i {
+		self.current = (i + 1) % len(self.hosts)
+	}
+}
+
+// post sends a JSON body.
+func (self *Client) post(url string, data []byte) (*http.Response, error) {
+	return self.do("POST", url, http.Header{"Content-Type": {"application/json"}}, data)
+}
+
+// isDialError returns true if a request failed because it could not connect,
+// in which case it was never sent.
+func isDialError(err error) bool {
+	if e, ok := err.(*url.Error); ok {
+		err = e.Err
+	}
+	e, ok := err.(*net.OpError)
+	return ok && e.Op == "dial"
+}
+
+// newRequestID returns a random id that lets the server ignore retries of a
+// write that already succeeded.
+func newRequestID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
 
 func responseToError(response *http.Response, err error, closeResponse bool) error {
@@ -125,7 +280,7 @@ func (self *Client) CreateDatabase(name string) error {
 	if err != nil {
 		return err
 	}
-	resp, err := self.httpClient.Post(url, "application/json", bytes.NewBuffer(data))
+	resp, err := self.post(url, data)
 	return responseToError(resp, err, true)
 }
 
@@ -133,12 +288,8 @@ func (self *Client) del(url string) (*http.Response, error) {
 	return self.delWithBody(url, nil)
 }
 
-func (self *Client) delWithBody(url string, body io.Reader) (*http.Response, error) {
-	req, err := http.NewRequest("DELETE", url, body)
-	if err != nil {
-		return nil, err
-	}
-	return self.httpClient.Do(req)
+func (self *Client) delWithBody(url string, body []byte) (*http.Response, error) {
+	return self.do("DELETE", url, nil, body)
 }
 
 func (self *Client) DeleteDatabase(name string) error {
@@ -148,7 +299,7 @@ func (self *Client) DeleteDatabase(name string) error {
 }
 
 func (self *Client) get(url string) ([]byte, error) {
-	resp, err := self.httpClient.Get(url)
+	resp, err := self.do("GET", url, nil, nil)
 	err = responseToError(resp, err, false)
 	if err != nil {
 		return nil, err
@@ -159,7 +310,7 @@ func (self *Client) get(url string) ([]byte, error) {
 }
 
 func (self *Client) getWithVersion(url string) ([]byte, string, error) {
-	resp, err := self.httpClient.Get(url)
+	resp, err := self.do("GET", url, nil, nil)
 	err = responseToError(resp, err, false)
 	if err != nil {
 		return nil, "", err
@@ -199,7 +350,7 @@ func (self *Client) CreateClusterAdmin(name, password string) error {
 	if err != nil {
 		return err
 	}
-	resp, err := self.httpClient.Post(url, "application/json", bytes.NewBuffer(data))
+	resp, err := self.post(url, data)
 	return responseToError(resp, err, true)
 }
 
@@ -210,7 +361,7 @@ func (self *Client) UpdateClusterAdmin(name, password string) error {
 	if err != nil {
 		return err
 	}
-	resp, err := self.httpClient.Post(url, "application/json", bytes.NewBuffer(data))
+	resp, err := self.post(url, data)
 	return responseToError(resp, err, true)
 }
 
@@ -262,7 +413,7 @@ func (self *Client) CreateDatabaseUser(database, name, password string, permissi
 	if err != nil {
 		return err
 	}
-	resp, err := self.httpClient.Post(url, "application/json", bytes.NewBuffer(data))
+	resp, err := self.post(url, data)
 	return responseToError(resp, err, true)
 }
 
@@ -274,7 +425,7 @@ func (self *Client) ChangeClusterAdminPassword(name, newPassword string) error {
 	if err != nil {
 		return err
 	}
-	resp, err := self.httpClient.Post(url, "application/json", bytes.NewBuffer(data))
+	resp, err := self.post(url, data)
 	return responseToError(resp, err, true)
 }
 
@@ -296,7 +447,7 @@ func (self *Client) ChangeDatabaseUser(database, name, newPassword string, isAdm
 	if err != nil {
 		return err
 	}
-	resp, err := self.httpClient.Post(url, "application/json", bytes.NewBuffer(data))
+	resp, err := self.post(url, data)
 	return responseToError(resp, err, true)
 }
 
@@ -323,7 +474,7 @@ func (self *Client) updateDatabaseUserCommon(database, name string, password *st
 	if err != nil {
 		return err
 	}
-	resp, err := self.httpClient.Post(url, "application/json", bytes.NewBuffer(data))
+	resp, err := self.post(url, data)
 	return responseToError(resp, err, true)
 }
 
@@ -398,26 +549,27 @@ func (self *Client) writeSeriesCommon(series []*Series, options map[string]strin
 	for name, value := range options {
 		url += fmt.Sprintf("&%s=%s", name, value)
 	}
-	var b *bytes.Buffer
+
+	// Identify the write so that it can be safely retried.
+	requestID, err := newRequestID()
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set(requestIDHeader, requestID)
+
 	if self.compression {
-		b = bytes.NewBuffer(nil)
-		w := gzip.NewWriter(b)
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
 		if _, err := w.Write(data); err != nil {
 			return err
 		}
 		w.Flush()
 		w.Close()
-	} else {
-		b = bytes.NewBuffer(data)
+		data = b.Bytes()
+		header.Set("Content-Encoding", "gzip")
 	}
-	req, err := http.NewRequest("POST", url, b)
-	if err != nil {
-		return err
-	}
-	if self.compression {
-		req.Header.Set("Content-Encoding", "gzip")
-	}
-	resp, err := self.httpClient.Do(req)
+	resp, err := self.do("POST", url, header, data)
 	return responseToError(resp, err, true)
 }
 
@@ -436,14 +588,11 @@ func (self *Client) queryCommon(query string, useNumber bool, precision ...TimeP
 		url += "&time_precision=" + string(precision[0])
 	}
 	url += "&q=" + escapedQuery
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
+	header := http.Header{}
 	if !self.compression {
-		req.Header.Set("Accept-Encoding", "identity")
+		header.Set("Accept-Encoding", "identity")
 	}
-	resp, err := self.httpClient.Do(req)
+	resp, err := self.do("GET", url, header, nil)
 	err = responseToError(resp, err, false)
 	if err != nil {
 		return nil, err
@@ -464,19 +613,19 @@ func (self *Client) queryCommon(query string, useNumber bool, precision ...TimeP
 
 func (self *Client) Ping() error {
 	url := self.getUrl("/ping")
-	resp, err := self.httpClient.Get(url)
+	resp, err := self.do("GET", url, nil, nil)
 	return responseToError(resp, err, true)
 }
 
 func (self *Client) AuthenticateDatabaseUser(database, username, password string) error {
 	url := self.getUrlWithUserAndPass(fmt.Sprintf("/db/%s/authenticate", database), username, password)
-	resp, err := self.httpClient.Get(url)
+	resp, err := self.do("GET", url, nil, nil)
 	return responseToError(resp, err, true)
 }
 
 func (self *Client) AuthenticateClusterAdmin(username, password string) error {
 	url := self.getUrlWithUserAndPass("/cluster_admins/authenticate", username, password)
-	resp, err := self.httpClient.Get(url)
+	resp, err := self.do("GET", url, nil, nil)
 	return responseToError(resp, err, true)
 }
 
@@ -583,7 +732,7 @@ func (self *Client) CreateShardSpace(database string, space *ShardSpace) error {
 	if err != nil {
 		return err
 	}
-	resp, err := self.httpClient.Post(url, "application/json", bytes.NewBuffer(data))
+	resp, err := self.post(url, data)
 	return responseToError(resp, err, true)
 }
 
@@ -594,7 +743,7 @@ func (self *Client) DropShard(id uint32, serverIds []uint32) error {
 	if err != nil {
 		return err
 	}
-	_, err = self.delWithBody(url, bytes.NewBuffer(body))
+	_, err = self.delWithBody(url, body)
 	return err
 }
 
@@ -605,6 +754,6 @@ func (self *Client) UpdateShardSpace(database, name string, space *ShardSpace) e
 	if err != nil {
 		return err
 	}
-	resp, err := self.httpClient.Post(url, "application/json", bytes.NewBuffer(data))
+	resp, err := self.post(url, data)
 	return responseToError(resp, err, true)
 }
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Ensure requests that fail with a 5xx status are retried.
+func TestClient_Retry(t *testing.T) {
+	var mu sync.Mutex
+	var ids []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		ids = append(ids, r.Header.Get(requestIDHeader))
+		if len(ids) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	c := MustNewClient(t, &ClientConfig{Host: hostOf(ts), Database: "foo", MaxRetries: 1})
+	c.sleep = func(time.Duration) {}
+	if err := c.WriteSeries([]*Series{{Name: "cpu", Columns: []string{"value"}, Points: [][]interface{}{{1}}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The retry is sent with the same request id so the server can ignore it
+	// if the first attempt was written.
+	if len(ids) != 2 {
+		t.Fatalf("unexpected request count: %d", len(ids))
+	} else if ids[0] == "" || ids[0] != ids[1] {
+		t.Fatalf("unexpected request ids: %v", ids)
+	}
+}
+
+// Ensure requests fail over to the next host if they can't connect.
+func TestClient_Failover(t *testing.T) {
+	var n int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	c := MustNewClient(t, &ClientConfig{Host: closedAddr(t), Hosts: []string{hostOf(ts)}, MaxRetries: 1})
+	if err := c.Ping(); err != nil {
+		t.Fatal(err)
+	} else if c.current != 1 {
+		t.Fatalf("unexpected current host: %d", c.current)
+	}
+
+	// Later requests are sent to the working host first.
+	c.maxRetries = 0
+	if err := c.Ping(); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatalf("unexpected request count: %d", n)
+	}
+}
+
+// Ensure requests without a request id aren't retried once they were sent.
+func TestClient_Retry_NotIdempotent(t *testing.T) {
+	var n int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	c := MustNewClient(t, &ClientConfig{Host: hostOf(ts), MaxRetries: 3})
+	c.sleep = func(time.Duration) {}
+	if err := c.CreateDatabase("foo"); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("unexpected error: %v", err)
+	} else if n != 1 {
+		t.Fatalf("unexpected request count: %d", n)
+	}
+
+	// They are retried if they couldn't connect.
+	c = MustNewClient(t, &ClientConfig{Host: closedAddr(t), Hosts: []string{hostOf(ts)}, MaxRetries: 3})
+	c.sleep = func(time.Duration) {}
+	if err := c.CreateDatabase("foo"); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("unexpected error: %v", err)
+	} else if n != 2 {
+		t.Fatalf("unexpected request count: %d", n)
+	}
+}
+
+// Ensure requests are retried up to the maximum number of retries and the
+// backoff between rounds of hosts doubles up to its limit.
+func TestClient_Retry_Limits(t *testing.T) {
+	var n int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	c := MustNewClient(t, &ClientConfig{Host: hostOf(ts), MaxRetries: 4, RetryBackoff: 3 * time.Second})
+	var sleeps []time.Duration
+	c.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	if err := c.Ping(); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Fatalf("unexpected error: %v", err)
+	} else if n != 5 {
+		t.Fatalf("unexpected request count: %d", n)
+	} else if exp := []time.Duration{3 * time.Second, 6 * time.Second, MaxRetryBackoff, MaxRetryBackoff}; !reflect.DeepEqual(sleeps, exp) {
+		t.Fatalf("unexpected backoff: %v", sleeps)
+	}
+
+	// The client only waits once every host has failed.
+	n, sleeps = 0, nil
+	c = MustNewClient(t, &ClientConfig{Host: hostOf(ts), Hosts: []string{hostOf(ts)}, MaxRetries: 3, RetryBackoff: time.Second})
+	c.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	if err := c.Ping(); err == nil {
+		t.Fatal("expected error")
+	} else if n != 4 {
+		t.Fatalf("unexpected request count: %d", n)
+	} else if exp := []time.Duration{time.Second}; !reflect.DeepEqual(sleeps, exp) {
+		t.Fatalf("unexpected backoff: %v", sleeps)
+	}
+}
+
+// MustNewClient returns a new client or fails the test.
+func MustNewClient(t *testing.T, config *ClientConfig) *Client {
+	c, err := NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// hostOf returns the host and port of a test server.
+func hostOf(ts *httptest.Server) string { return strings.TrimPrefix(ts.URL, "http://") }
+
+// closedAddr returns an address that refuses connections.
+func closedAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
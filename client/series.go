@@ -1,9 +1,10 @@
 package client
 
 type Series struct {
-	Name    string          `json:"name"`
-	Columns []string        `json:"columns"`
-	Points  [][]interface{} `json:"points"`
+	Name    string            `json:"name"`
+	Tags    map[string]string `json:"tags,omitempty"`
+	Columns []string          `json:"columns"`
+	Points  [][]interface{}   `json:"points"`
 }
 
 func (self *Series) GetName() string {
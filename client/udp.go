@@ -0,0 +1,202 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultUDPPayloadSize is the default largest packet sent by a UDPWriter.
+	// It fits in a typical Ethernet MTU so that packets aren't fragmented.
+	DefaultUDPPayloadSize = 1400
+
+	// MaxUDPPayloadSize is the largest payload of a UDP packet over IPv4.
+	MaxUDPPayloadSize = 65507
+
+	// DefaultUDPFlushInterval is the default longest time that a UDPWriter
+	// buffers series before sending them.
+	DefaultUDPFlushInterval = time.Second
+)
+
+// ErrPointTooLarge is returned when a single point does not fit in a packet.
+var ErrPointTooLarge = errors.New("point too large for udp payload")
+
+type UDPConfig struct {
+	Addr          string        // address of the server's UDP listener
+	PayloadSize   int           // largest packet sent, in bytes
+	SampleRate    float64       // fraction of points sent, zero sends every point
+	FlushInterval time.Duration // longest time series are buffered
+}
+
+// UDPWriter sends series to the server's UDP listener in the JSON write
+// format. Series are buffered and packed into as few packets as fit in the
+// payload size. Delivery is not acknowledged so points may be lost.
+type UDPWriter struct {
+	mu          sync.Mutex
+	conn        *net.UDPConn
+	buf         []byte // series waiting to be sent, without the closing bracket
+	payloadSize int
+	sampleRate  float64
+	rand        *rand.Rand
+
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewUDPWriter returns a writer connected to a UDP listener. Buffered series
+// are sent at least once every flush interval.
+func NewUDPWriter(config *UDPConfig) (*UDPWriter, error) {
+	payloadSize := config.PayloadSize
+	if payloadSize == 0 {
+		payloadSize = DefaultUDPPayloadSize
+	} else if payloadSize > MaxUDPPayloadSize {
+		return nil, fmt.Errorf("payload size over limit %v limit is %v", payloadSize, MaxUDPPayloadSize)
+	}
+	if config.SampleRate < 0 || config.SampleRate > 1 {
+		return nil, fmt.Errorf("sample rate must be between 0 and 1: %v", config.SampleRate)
+	}
+	flushInterval := config.FlushInterval
+	if flushInterval == 0 {
+		flushInterval = DefaultUDPFlushInterval
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", config.Addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &UDPWriter{
+		conn:        conn,
+		buf:         make([]byte, 0, payloadSize),
+		payloadSize: payloadSize,
+		sampleRate:  config.SampleRate,
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		closing:     make(chan struct{}),
+	}
+	w.wg.Add(1)
+	go w.run(flushInterval)
+	return w, nil
+}
+
+// WriteSeries buffers series to be sent, sending full packets immediately.
+// Series that don't fit in a packet are split between packets.
+func (w *UDPWriter) WriteSeries(series []*Series) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, s := range series {
+		if s = w.sample(s); s == nil {
+			continue
+		}
+		if err := w.add(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush sends any buffered series.
+func (w *UDPWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flush()
+}
+
+// Close sends any buffered series and closes the connection.
+func (w *UDPWriter) Close() error {
+	close(w.closing)
+	w.wg.Wait()
+
+	err := w.Flush()
+	if e := w.conn.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// run periodically sends buffered series until the writer is closed.
+// Errors are dropped since delivery is not guaranteed anyway.
+func (w *UDPWriter) run(interval time.Duration) {
+	defer w.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.closing:
+			return
+		case <-ticker.C:
+			w.Flush()
+		}
+	}
+}
+
+// sample returns a series with a random sample of its points.
+// Returns nil if no points are sampled.
+func (w *UDPWriter) sample(s *Series) *Series {
+	if w.sampleRate == 0 || w.sampleRate == 1 {
+		return s
+	}
+
+	var points [][]interface{}
+	for _, p := range s.Points {
+		if w.rand.Float64() < w.sampleRate {
+			points = append(points, p)
+		}
+	}
+	if len(points) == 0 {
+		return nil
+	}
+	return &Series{Name: s.Name, Tags: s.Tags, Columns: s.Columns, Points: points}
+}
+
+// add encodes a series into the buffer, sending the buffer first if the
+// series doesn't fit. Series larger than a packet are split in half by
+// points until each half fits.
+func (w *UDPWriter) add(s *Series) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	// Encoded series are wrapped in brackets and separated by commas.
+	if len(data)+2 > w.payloadSize {
+		if len(s.Points) <= 1 {
+			return ErrPointTooLarge
+		}
+		n := len(s.Points) / 2
+		if err := w.add(&Series{Name: s.Name, Tags: s.Tags, Columns: s.Columns, Points: s.Points[:n]}); err != nil {
+			return err
+		}
+		return w.add(&Series{Name: s.Name, Tags: s.Tags, Columns: s.Columns, Points: s.Points[n:]})
+	} else if len(w.buf) > 0 && len(w.buf)+len(data)+2 > w.payloadSize {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(w.buf) == 0 {
+		w.buf = append(w.buf, '[')
+	} else {
+		w.buf = append(w.buf, ',')
+	}
+	w.buf = append(w.buf, data...)
+	return nil
+}
+
+// flush sends the buffered series in a single packet.
+func (w *UDPWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.conn.Write(append(w.buf, ']'))
+	w.buf = w.buf[:0]
+	return err
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	log.SetFlags(0)
+
+	// Parse command flags.
+	fs := flag.NewFlagSet("", flag.ExitOnError)
+	var (
+		host             = fs.String("url", "http://localhost:8086", "")
+		database         = fs.String("db", "stress", "")
+		retentionPolicy  = fs.String("rp", "", "")
+		username         = fs.String("username", "", "")
+		password         = fs.String("password", "", "")
+		create           = fs.Bool("create", false, "")
+		duration         = fs.Duration("create-duration", 24*time.Hour, "")
+		points           = fs.Int("points", 1000000, "")
+		series           = fs.Int("series", 10000, "")
+		measurements     = fs.Int("measurements", 1, "")
+		batchSize        = fs.Int("batch-size", 5000, "")
+		concurrency      = fs.Int("concurrency", 10, "")
+		queries          = fs.String("queries", "", "")
+		queryConcurrency = fs.Int("query-concurrency", 1, "")
+	)
+	fs.Usage = printUsage
+	fs.Parse(os.Args[1:])
+
+	u, err := url.Parse(*host)
+	if err != nil {
+		log.Fatalf("invalid url: %s", err)
+	}
+	c := &Config{
+		URL:              *u,
+		Database:         *database,
+		RetentionPolicy:  *retentionPolicy,
+		Username:         *username,
+		Password:         *password,
+		Points:           *points,
+		Series:           *series,
+		Measurements:     *measurements,
+		BatchSize:        *batchSize,
+		Concurrency:      *concurrency,
+		QueryConcurrency: *queryConcurrency,
+	}
+	for _, q := range strings.Split(*queries, ";") {
+		if q = strings.TrimSpace(q); q != "" {
+			c.Queries = append(c.Queries, q)
+		}
+	}
+
+	// Create the database, if requested.
+	if *create {
+		if err := c.setup(*duration); err != nil {
+			log.Fatalf("create: %s", err)
+		}
+	}
+
+	log.Printf("Writing %d points to %d series in batches of %d with %d writers",
+		c.Points, c.Series, c.BatchSize, c.Concurrency)
+	if len(c.Queries) > 0 {
+		log.Printf("Running %d queries with %d readers", len(c.Queries), c.QueryConcurrency)
+	}
+
+	r, err := Run(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	printReport(r)
+}
+
+// printReport prints the throughput and latencies of a run.
+func printReport(r *Report) {
+	secs := r.Duration.Seconds()
+	fmt.Printf("Wrote %d points in %s (%.0f points/sec)\n", r.Points, r.Duration, float64(r.Points)/secs)
+	printLatencies("Writes", r.Writes, secs)
+	if n := r.Queries.N() + r.Queries.Errors(); n > 0 {
+		printLatencies("Queries", r.Queries, secs)
+	}
+}
+
+// printLatencies prints the rate, errors and latency percentiles of requests.
+func printLatencies(name string, l *Latencies, secs float64) {
+	fmt.Printf("%s: %d ok, %d failed (%.1f/sec)\n", name, l.N(), l.Errors(), float64(l.N())/secs)
+	fmt.Printf("    p50 %s  p90 %s  p99 %s  max %s\n",
+		round(l.Percentile(50)), round(l.Percentile(90)), round(l.Percentile(99)), round(l.Percentile(100)))
+}
+
+// round rounds a latency to a readable precision.
+func round(d time.Duration) time.Duration {
+	if d > 10*time.Millisecond {
+		return d - d%time.Millisecond
+	}
+	return d - d%time.Microsecond
+}
+
+func printUsage() {
+	log.Print(`usage: influx_stress [flags]
+
+influx_stress writes generated points to a running server and optionally runs
+queries while it writes, then reports the throughput and the p50, p90 and p99
+latencies of each. The flags are:
+
+        -url <url>
+                          URL of a data node. Defaults to http://localhost:8086.
+
+        -username <name>
+        -password <password>
+                          Credentials of a user if authentication is enabled.
+
+        -db <name>
+        -rp <name>
+                          Database and retention policy to write to. Defaults
+                          to the "stress" database and its default policy.
+
+        -create
+                          Create the database and a retention policy named by
+                          -rp ("stress" if unset) before writing, and write
+                          to that policy.
+
+        -create-duration <duration>
+                          Duration of the created retention policy, such as
+                          "72h". Defaults to 24h.
+
+        -points <n>
+                          Number of points written. Defaults to 1000000.
+
+        -series <n>
+        -measurements <n>
+                          Number of series that points are spread over, and of
+                          measurements that the series are spread over. Each
+                          series has a single "host" tag. Defaults to 10000
+                          series in 1 measurement.
+
+        -batch-size <n>
+                          Points written by each request. Defaults to 5000.
+
+        -concurrency <n>
+                          Requests written at once. Defaults to 10.
+
+        -queries <queries>
+                          Semicolon-separated queries run in turn until every
+                          point has been written, such as
+                          "SELECT count(value) FROM stress0".
+
+        -query-concurrency <n>
+                          Queries run at once. Defaults to 1.
+`)
+}
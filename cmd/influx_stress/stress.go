@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config represents the settings of a stress run.
+type Config struct {
+	URL             url.URL
+	Database        string
+	RetentionPolicy string
+	Username        string
+	Password        string
+
+	// Points are spread evenly over the series, which are spread evenly over
+	// the measurements. Each series has a single "host" tag.
+	Points       int
+	Series       int
+	Measurements int
+
+	BatchSize   int // points written by each request
+	Concurrency int // requests written at once
+
+	// Queries are run in turn by the query workers until every point has been
+	// written. No queries are run if the list is empty.
+	Queries          []string
+	QueryConcurrency int
+}
+
+// Report represents the results of a stress run.
+type Report struct {
+	Duration time.Duration
+	Points   int // points written successfully
+	Writes   *Latencies
+	Queries  *Latencies
+}
+
+// Latencies records the latencies of requests and the number that failed.
+type Latencies struct {
+	mu     sync.Mutex
+	a      []time.Duration
+	errN   int
+	sorted bool
+}
+
+// add records the latency of a request and whether it failed.
+func (l *Latencies) add(d time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil {
+		l.errN++
+		return
+	}
+	l.a = append(l.a, d)
+	l.sorted = false
+}
+
+// N returns the number of requests that succeeded.
+func (l *Latencies) N() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.a)
+}
+
+// Errors returns the number of requests that failed.
+func (l *Latencies) Errors() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.errN
+}
+
+// Percentile returns the latency that p percent of successful requests were
+// at or below, using the nearest rank. Returns zero if there were none.
+func (l *Latencies) Percentile(p float64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.a) == 0 {
+		return 0
+	}
+	if !l.sorted {
+		sort.Sort(durations(l.a))
+		l.sorted = true
+	}
+
+	i := int(p/100*float64(len(l.a))+0.999999) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= len(l.a) {
+		i = len(l.a) - 1
+	}
+	return l.a[i]
+}
+
+// Run writes points and runs queries against a server as configured and
+// reports the throughput and latencies.
+func Run(c *Config) (*Report, error) {
+	if c.Points < 1 || c.Series < 1 || c.Measurements < 1 || c.BatchSize < 1 || c.Concurrency < 1 {
+		return nil, fmt.Errorf("points, series, measurements, batch size and concurrency must be positive")
+	}
+
+	r := &Report{Writes: &Latencies{}, Queries: &Latencies{}}
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: c.Concurrency + c.QueryConcurrency}}
+
+	// Timestamps count up by a microsecond from the start of the run so that
+	// no point overwrites another.
+	start := time.Now().UTC()
+	epoch := start.UnixNano() / int64(time.Microsecond)
+
+	// Hand out batches to the writers.
+	batches := make(chan int)
+	go func() {
+		for i := 0; i < c.Points; i += c.BatchSize {
+			batches <- i
+		}
+		close(batches)
+	}()
+
+	var pointN int64
+	var wg sync.WaitGroup
+	for i := 0; i < c.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for offset := range batches {
+				n := c.BatchSize
+				if offset+n > c.Points {
+					n = c.Points - offset
+				}
+				body, err := json.Marshal(c.batch(offset, n, epoch))
+				if err != nil {
+					panic(err)
+				}
+
+				t := time.Now()
+				err = c.write(client, body)
+				r.Writes.add(time.Since(t), err)
+				if err == nil {
+					atomic.AddInt64(&pointN, int64(n))
+				}
+			}
+		}()
+	}
+
+	// Run queries until the writers are done.
+	done := make(chan struct{})
+	var qwg sync.WaitGroup
+	var next int64
+	if len(c.Queries) > 0 {
+		for i := 0; i < c.QueryConcurrency; i++ {
+			qwg.Add(1)
+			go func() {
+				defer qwg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					q := c.Queries[int(atomic.AddInt64(&next, 1)-1)%len(c.Queries)]
+
+					t := time.Now()
+					err := c.query(client, c.Database, q)
+					r.Queries.add(time.Since(t), err)
+				}
+			}()
+		}
+	}
+
+	wg.Wait()
+	close(done)
+	qwg.Wait()
+
+	r.Duration = time.Since(start)
+	r.Points = int(pointN)
+	return r, nil
+}
+
+// series represents a series in the write endpoint's wire format.
+type series struct {
+	Name    string            `json:"name"`
+	Tags    map[string]string `json:"tags,omitempty"`
+	Columns []string          `json:"columns"`
+	Points  [][]interface{}   `json:"points"`
+}
+
+// batch returns n points starting from the point at offset, grouped by
+// series. Point i is written to series i modulo the number of series at
+// epoch plus i microseconds.
+func (c *Config) batch(offset, n int, epoch int64) []*series {
+	var a []*series
+	m := make(map[int]*series)
+	for i := offset; i < offset+n; i++ {
+		id := i % c.Series
+		s := m[id]
+		if s == nil {
+			s = &series{
+				Name:    fmt.Sprintf("stress%d", id%c.Measurements),
+				Tags:    map[string]string{"host": fmt.Sprintf("host%d", id)},
+				Columns: []string{"time", "value"},
+			}
+			m[id] = s
+			a = append(a, s)
+		}
+		s.Points = append(s.Points, []interface{}{epoch + int64(i), float64(i % 100)})
+	}
+	return a
+}
+
+// write posts an encoded batch to the write endpoint.
+func (c *Config) write(client *http.Client, body []byte) error {
+	params := c.params()
+	params.Set("time_precision", "u")
+	if c.RetentionPolicy != "" {
+		params.Set("rp", c.RetentionPolicy)
+	}
+	resp, err := client.Post(c.endpoint("/db/"+url.QueryEscape(c.Database)+"/series", params), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// query runs a query against a database and returns the error of the
+// request or of the first statement that failed.
+func (c *Config) query(client *http.Client, db, q string) error {
+	params := c.params()
+	if db != "" {
+		params.Set("db", db)
+	}
+	params.Set("q", q)
+	resp, err := client.Get(c.endpoint("/query", params))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	var results []struct {
+		Err string `json:"error"`
+	}
+	if err := json.Unmarshal(b, &results); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Err != "" {
+			return fmt.Errorf("%s", r.Err)
+		}
+	}
+	return nil
+}
+
+// setup creates the database and a retention policy for the run and writes
+// to that policy. The policy is named "stress" if none is set. Returns an
+// error if either exists.
+func (c *Config) setup(duration time.Duration) error {
+	if c.RetentionPolicy == "" {
+		c.RetentionPolicy = "stress"
+	}
+
+	client := &http.Client{}
+	if err := c.post(client, "/db", map[string]interface{}{"name": c.Database}); err != nil {
+		return fmt.Errorf("create database: %s", err)
+	}
+	if err := c.post(client, "/db/"+url.QueryEscape(c.Database)+"/retention_policies", map[string]interface{}{
+		"name":     c.RetentionPolicy,
+		"duration": duration,
+		"replicaN": 1,
+	}); err != nil {
+		return fmt.Errorf("create retention policy: %s", err)
+	}
+	return nil
+}
+
+// post posts a value encoded as JSON to a path on the server.
+func (c *Config) post(client *http.Client, path string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(c.endpoint(path, c.params()), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// params returns the query parameters sent with every request.
+func (c *Config) params() url.Values {
+	params := url.Values{}
+	if c.Username != "" {
+		params.Set("u", c.Username)
+		params.Set("p", c.Password)
+	}
+	return params
+}
+
+// endpoint returns the URL of a path on the server.
+func (c *Config) endpoint(path string, params url.Values) string {
+	u := c.URL
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
+// durations represents a list of durations, sortable in ascending order.
+type durations []time.Duration
+
+func (a durations) Len() int           { return len(a) }
+func (a durations) Less(i, j int) bool { return a[i] < a[j] }
+func (a durations) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Ensure a run writes every point across the configured series and runs
+// queries while it writes.
+func TestRun(t *testing.T) {
+	var mu sync.Mutex
+	timestamps := make(map[float64]bool)
+	seen := make(map[string]bool)
+	var queryN int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/db/foo/series":
+			if r.URL.Query().Get("time_precision") != "u" || r.URL.Query().Get("u") != "admin" {
+				http.Error(w, "bad params", http.StatusBadRequest)
+				return
+			}
+			var a []*series
+			if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for _, s := range a {
+				seen[s.Name+","+s.Tags["host"]] = true
+				for _, p := range s.Points {
+					timestamps[p[0].(float64)] = true
+				}
+			}
+			w.WriteHeader(http.StatusOK)
+		case "/query":
+			queryN++
+			w.Write([]byte(`[{"statement_id":0}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+	r, err := Run(&Config{
+		URL:              *u,
+		Database:         "foo",
+		Username:         "admin",
+		Points:           25,
+		Series:           3,
+		Measurements:     2,
+		BatchSize:        10,
+		Concurrency:      2,
+		Queries:          []string{"SELECT count(value) FROM stress0"},
+		QueryConcurrency: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if r.Points != 25 || r.Writes.N() != 3 || r.Writes.Errors() != 0 {
+		t.Fatalf("unexpected writes: points=%d, n=%d, errors=%d", r.Points, r.Writes.N(), r.Writes.Errors())
+	} else if r.Queries.N() != queryN || r.Queries.Errors() != 0 {
+		t.Fatalf("unexpected queries: n=%d, errors=%d", r.Queries.N(), r.Queries.Errors())
+	}
+
+	var keys []string
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"stress0,host0", "stress0,host2", "stress1,host1"}) {
+		t.Fatalf("unexpected series: %v", keys)
+	} else if len(timestamps) != 25 {
+		t.Fatalf("unexpected timestamp count: %d", len(timestamps))
+	}
+}
+
+// Ensure failed writes and query errors are counted.
+func TestRun_Errors(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/query" {
+			w.Write([]byte(`[{"statement_id":0,"error":"measurement not found"}]`))
+			return
+		}
+		http.Error(w, "write failed", http.StatusInternalServerError)
+	}))
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+	r, err := Run(&Config{URL: *u, Database: "foo", Points: 5, Series: 1, Measurements: 1, BatchSize: 2, Concurrency: 1, Queries: []string{"SELECT"}, QueryConcurrency: 1})
+	if err != nil {
+		t.Fatal(err)
+	} else if r.Points != 0 || r.Writes.N() != 0 || r.Writes.Errors() != 3 {
+		t.Fatalf("unexpected writes: points=%d, n=%d, errors=%d", r.Points, r.Writes.N(), r.Writes.Errors())
+	} else if r.Queries.N() != 0 {
+		t.Fatalf("unexpected successful queries: %d", r.Queries.N())
+	}
+}
+
+// Ensure setup creates the database and a retention policy that points are
+// then written to.
+func TestConfig_Setup(t *testing.T) {
+	var paths []string
+	var policy map[string]interface{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		if r.URL.Path == "/db/foo/retention_policies" {
+			json.NewDecoder(r.Body).Decode(&policy)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+	c := &Config{URL: *u, Database: "foo"}
+	if err := c.setup(time.Hour); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(paths, []string{"POST /db", "POST /db/foo/retention_policies"}) {
+		t.Fatalf("unexpected requests: %v", paths)
+	} else if policy["name"] != "stress" || policy["duration"] != float64(time.Hour) || policy["replicaN"] != float64(1) {
+		t.Fatalf("unexpected policy: %v", policy)
+	} else if c.RetentionPolicy != "stress" {
+		t.Fatalf("unexpected retention policy: %s", c.RetentionPolicy)
+	}
+}
+
+// Ensure setup fails if the database exists.
+func TestConfig_Setup_Exists(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "database exists", http.StatusConflict)
+	}))
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+	c := &Config{URL: *u, Database: "foo"}
+	if err := c.setup(time.Hour); err == nil || err.Error() != "create database: 409: database exists" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure percentiles are read by nearest rank.
+func TestLatencies_Percentile(t *testing.T) {
+	var l Latencies
+	if d := l.Percentile(50); d != 0 {
+		t.Fatalf("unexpected empty percentile: %s", d)
+	}
+	for i := 100; i >= 1; i-- {
+		l.add(time.Duration(i)*time.Millisecond, nil)
+	}
+	for _, tt := range []struct {
+		p   float64
+		exp time.Duration
+	}{{0, 1 * time.Millisecond}, {50, 50 * time.Millisecond}, {99, 99 * time.Millisecond}, {99.5, 100 * time.Millisecond}, {100, 100 * time.Millisecond}} {
+		if d := l.Percentile(tt.p); d != tt.exp {
+			t.Errorf("p%v: unexpected latency: %s", tt.p, d)
+		}
+	}
+}
@@ -10,7 +10,9 @@ import (
 	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/influxdb/influxdb"
 	"github.com/influxdb/influxdb/graphite"
+	"github.com/influxdb/influxdb/statsd"
 )
 
 const (
@@ -20,6 +22,22 @@ const (
 	// DefaultPointBatchSize represents the number of writes to batch together.
 	DefaultWriteBatchSize = 10 * 1024 * 1024 // 10MB
 
+	// DefaultGroupCommitSize represents the maximum number of points committed
+	// to a shard together.
+	DefaultGroupCommitSize = 1000
+
+	// DefaultGroupCommitDelay represents how long a write waits for other
+	// writes to the same shard before it is committed.
+	DefaultGroupCommitDelay = time.Millisecond
+
+	// DefaultMaxPendingPoints represents the number of points waiting to be
+	// published before writes are rejected.
+	DefaultMaxPendingPoints = 100000
+
+	// DefaultMaxUnappliedWrites represents the number of published writes
+	// waiting to be applied before writes are rejected.
+	DefaultMaxUnappliedWrites = 10000
+
 	// DefaultConcurrentShardQueryLimit represents the number of shards that
 	// can be queried concurrently at one time.
 	DefaultConcurrentShardQueryLimit = 10
@@ -32,6 +50,52 @@ const (
 
 	// DefaultHTTPAPIPort represents the default port the HTTP API runs on.
 	DefaultHTTPAPIPort = 8086
+
+	// DefaultUnixSocketPermissions represents the file mode of the HTTP API unix socket.
+	DefaultUnixSocketPermissions = 0770
+
+	// DefaultQueryCacheSize represents the number of parsed queries cached by the server.
+	DefaultQueryCacheSize = 1000
+
+	// DefaultResultCacheMinAge represents how long ago a query's time range
+	// must have ended before its results are cached.
+	DefaultResultCacheMinAge = time.Minute
+
+	// DefaultWriteIDCacheSize represents the number of write request ids remembered by the server.
+	DefaultWriteIDCacheSize = 10000
+
+	// DefaultWriteIDTTL represents how long a write request id is remembered.
+	DefaultWriteIDTTL = 10 * time.Minute
+
+	// DefaultMonitoringDatabase represents the database that server statistics are written to.
+	DefaultMonitoringDatabase = "_internal"
+
+	// DefaultMonitoringRetentionPolicy represents the retention policy used for server statistics.
+	DefaultMonitoringRetentionPolicy = "default"
+
+	// DefaultMonitoringWriteInterval represents the period between writes of server statistics.
+	DefaultMonitoringWriteInterval = 1 * time.Minute
+
+	// DefaultShutdownTimeout represents how long the server waits for running
+	// queries and writes to finish when it shuts down.
+	DefaultShutdownTimeout = 30 * time.Second
+
+	// DefaultCompactionCheckInterval represents the period between checks for shards to compact.
+	DefaultCompactionCheckInterval = 1 * time.Hour
+
+	// DefaultCompactionConcurrency represents the number of shards compacted at once.
+	DefaultCompactionConcurrency = 1
+
+	// DefaultScrubInterval represents the period between scrubs of every shard.
+	DefaultScrubInterval = 24 * time.Hour
+
+	// DefaultDownsamplingCheckInterval represents the period between runs of
+	// the downsampling rules.
+	DefaultDownsamplingCheckInterval = 1 * time.Minute
+
+	// DefaultKafkaGroup represents the consumer group that Kafka offsets are
+	// committed for.
+	DefaultKafkaGroup = "influxdb"
 )
 
 // Config represents the configuration format for the influxd binary.
@@ -44,6 +108,23 @@ type (
 		Protocol      string `toml:"protocol"`
 		NamePosition  string `toml:"name-position"`
 		NameSeparator string `toml:"name-separator"`
+		Precision     string `toml:"precision"`
+
+		ServerTimestamps bool     `toml:"server-timestamps"`
+		MaxPastSkew      Duration `toml:"max-past-skew"`
+		MaxFutureSkew    Duration `toml:"max-future-skew"`
+	}
+
+	KafkaTopic struct {
+		Name            string `toml:"name"`
+		Database        string `toml:"database"`
+		RetentionPolicy string `toml:"retention-policy"`
+		Format          string `toml:"format"`
+		Precision       string `toml:"precision"`
+
+		ServerTimestamps bool     `toml:"server-timestamps"`
+		MaxPastSkew      Duration `toml:"max-past-skew"`
+		MaxFutureSkew    Duration `toml:"max-future-skew"`
 	}
 
 	Config struct {
@@ -67,15 +148,98 @@ type (
 			SSLPort     int      `toml:"ssl-port"`
 			SSLCertPath string   `toml:"ssl-cert"`
 			ReadTimeout Duration `toml:"read-timeout"`
+
+			UnixSocket            string   `toml:"unix-socket"`
+			UnixSocketPermissions FileMode `toml:"unix-socket-permissions"`
+
+			QueryCacheSize int `toml:"query-cache-size"`
+
+			ResultCacheSize   int      `toml:"result-cache-size"`
+			ResultCacheMinAge Duration `toml:"result-cache-min-age"`
+
+			WriteIDCacheSize int      `toml:"write-id-cache-size"`
+			WriteIDTTL       Duration `toml:"write-id-ttl"`
+
+			MetricsAuthentication bool `toml:"metrics-authentication"`
+
+			SessionIdleTimeout Duration `toml:"session-idle-timeout"`
+			SecureCookies      bool     `toml:"secure-cookies"`
+
+			AdminAllow []string `toml:"admin-allow"`
+			AdminDeny  []string `toml:"admin-deny"`
+
+			ServerTimestamps bool     `toml:"server-timestamps"`
+			MaxPastSkew      Duration `toml:"max-past-skew"`
+			MaxFutureSkew    Duration `toml:"max-future-skew"`
+
+			Limits struct {
+				QueriesPerMinute int      `toml:"queries-per-minute"`
+				PointsPerSecond  int      `toml:"points-per-second"`
+				MaxPointsScanned int      `toml:"max-points-scanned"`
+				MaxQueryMemory   int64    `toml:"max-query-memory"`
+				QueryTimeout     Duration `toml:"query-timeout"`
+				MaxRowLimit      int      `toml:"max-row-limit"`
+
+				MaxFieldsPerPoint int `toml:"max-fields-per-point"`
+				MaxTagsPerPoint   int `toml:"max-tags-per-point"`
+				MaxKeyLength      int `toml:"max-key-length"`
+				MaxValueLength    int `toml:"max-value-length"`
+
+				MaxFuture     Duration `toml:"max-future"`
+				RejectExpired bool     `toml:"reject-expired"`
+			} `toml:"limits"`
 		} `toml:"api"`
 
 		Graphites []Graphite `toml:"graphite"`
 
+		Statsd struct {
+			Enabled         bool      `toml:"enabled"`
+			Addr            string    `toml:"address"`
+			Port            int       `toml:"port"`
+			Database        string    `toml:"database"`
+			RetentionPolicy string    `toml:"retention-policy"`
+			FlushInterval   Duration  `toml:"flush-interval"`
+			Percentiles     []float64 `toml:"percentiles"`
+		} `toml:"statsd"`
+
+		Stream struct {
+			Enabled     bool     `toml:"enabled"`
+			Addr        string   `toml:"address"`
+			Port        int      `toml:"port"`
+			BatchSize   int      `toml:"batch-size"`
+			AckInterval Duration `toml:"ack-interval"`
+
+			ServerTimestamps bool     `toml:"server-timestamps"`
+			MaxPastSkew      Duration `toml:"max-past-skew"`
+			MaxFutureSkew    Duration `toml:"max-future-skew"`
+		} `toml:"stream"`
+
+		Postgres struct {
+			Enabled bool   `toml:"enabled"`
+			Addr    string `toml:"address"`
+			Port    int    `toml:"port"`
+		} `toml:"postgres"`
+
+		Kafka struct {
+			Enabled      bool         `toml:"enabled"`
+			Brokers      []string     `toml:"brokers"`
+			Group        string       `toml:"group"`
+			BatchSize    int          `toml:"batch-size"`
+			BatchTimeout Duration     `toml:"batch-timeout"`
+			StartOffset  string       `toml:"start-offset"`
+			Topics       []KafkaTopic `toml:"topics"`
+		} `toml:"kafka"`
+
 		InputPlugins struct {
 			UDPInput struct {
-				Enabled  bool   `toml:"enabled"`
-				Port     uint16 `toml:"port"`
-				Database string `toml:"database"`
+				Enabled   bool   `toml:"enabled"`
+				Port      uint16 `toml:"port"`
+				Database  string `toml:"database"`
+				Precision string `toml:"precision"`
+
+				ServerTimestamps bool     `toml:"server-timestamps"`
+				MaxPastSkew      Duration `toml:"max-past-skew"`
+				MaxFutureSkew    Duration `toml:"max-future-skew"`
 			} `toml:"udp"`
 			UDPServersInput []struct {
 				Enabled  bool   `toml:"enabled"`
@@ -96,6 +260,17 @@ type (
 			MaxOpenShards        int                       `toml:"max-open-shards"`
 			PointBatchSize       int                       `toml:"point-batch-size"`
 			WriteBatchSize       int                       `toml:"write-batch-size"`
+			GroupCommitSize      int                       `toml:"group-commit-size"`
+			GroupCommitDelay     Duration                  `toml:"group-commit-delay"`
+			MaxPendingPoints     int                       `toml:"max-pending-points"`
+			MaxUnappliedWrites   int                       `toml:"max-unapplied-writes"`
+			QueryConcurrency     int                       `toml:"query-concurrency"`
+			QuerySpillDir        string                    `toml:"query-spill-dir"`
+			MaxCursors           int                       `toml:"max-cursors"`
+			CursorTimeout        Duration                  `toml:"cursor-timeout"`
+			IndexIdleTimeout     Duration                  `toml:"index-idle-timeout"`
+			ShutdownTimeout      Duration                  `toml:"shutdown-timeout"`
+			TimestampPrecision   string                    `toml:"timestamp-precision"`
 			Engines              map[string]toml.Primitive `toml:"engines"`
 			RetentionSweepPeriod Duration                  `toml:"retention-sweep-period"`
 		} `toml:"data"`
@@ -112,6 +287,52 @@ type (
 			MaxResponseBufferSize     int      `toml:"max-response-buffer-size"`
 		} `toml:"cluster"`
 
+		Monitoring struct {
+			Enabled         bool     `toml:"enabled"`
+			Database        string   `toml:"database"`
+			RetentionPolicy string   `toml:"retention-policy"`
+			WriteInterval   Duration `toml:"write-interval"`
+		} `toml:"monitoring"`
+
+		Compaction struct {
+			Enabled       bool     `toml:"enabled"`
+			CheckInterval Duration `toml:"check-interval"`
+			Concurrency   int      `toml:"concurrency"`
+			MaxThroughput Size     `toml:"max-throughput"`
+			Window        string   `toml:"window"`
+		} `toml:"compaction"`
+
+		Jobs struct {
+			Concurrency   int  `toml:"concurrency"`
+			MaxQueued     int  `toml:"max-queued"`
+			MaxThroughput Size `toml:"max-throughput"`
+		} `toml:"jobs"`
+
+		Scrub struct {
+			Enabled  bool     `toml:"enabled"`
+			Interval Duration `toml:"interval"`
+			Username string   `toml:"username"`
+			Password string   `toml:"password"`
+		} `toml:"scrub"`
+
+		Downsampling struct {
+			Enabled       bool     `toml:"enabled"`
+			CheckInterval Duration `toml:"check-interval"`
+		} `toml:"downsampling"`
+
+		Alerting struct {
+			Enabled       bool     `toml:"enabled"`
+			CheckInterval Duration `toml:"check-interval"`
+			SMTPAddr      string   `toml:"smtp-address"`
+			SMTPFrom      string   `toml:"smtp-from"`
+			PagerDutyURL  string   `toml:"pagerduty-url"`
+		} `toml:"alerting"`
+
+		Audit struct {
+			Enabled bool   `toml:"enabled"`
+			File    string `toml:"file"`
+		} `toml:"audit"`
+
 		Logging struct {
 			File  string `toml:"file"`
 			Level string `toml:"level"`
@@ -131,13 +352,53 @@ func NewConfig() *Config {
 	c.Broker.Timeout = Duration(1 * time.Second)
 	c.HTTPAPI.Port = DefaultHTTPAPIPort
 	c.HTTPAPI.ReadTimeout = Duration(DefaultAPIReadTimeout)
+	c.HTTPAPI.UnixSocketPermissions = FileMode(DefaultUnixSocketPermissions)
+	c.HTTPAPI.QueryCacheSize = DefaultQueryCacheSize
+	c.HTTPAPI.ResultCacheMinAge = Duration(DefaultResultCacheMinAge)
+	c.HTTPAPI.WriteIDCacheSize = DefaultWriteIDCacheSize
+	c.HTTPAPI.WriteIDTTL = Duration(DefaultWriteIDTTL)
+	c.HTTPAPI.SessionIdleTimeout = Duration(influxdb.DefaultSessionIdleTimeout)
 	c.Cluster.MinBackoff = Duration(1 * time.Second)
 	c.Cluster.MaxBackoff = Duration(10 * time.Second)
 	c.Cluster.ProtobufHeartbeatInterval = Duration(10 * time.Millisecond)
 	c.Data.Dir = filepath.Join(u.HomeDir, ".influxdb/data")
+	c.Data.QuerySpillDir = filepath.Join(u.HomeDir, ".influxdb/spill")
+	c.Data.MaxCursors = influxdb.DefaultMaxCursors
+	c.Data.CursorTimeout = Duration(influxdb.DefaultCursorTimeout)
+	c.Data.IndexIdleTimeout = Duration(influxdb.DefaultIndexIdleTimeout)
+	c.Data.ShutdownTimeout = Duration(DefaultShutdownTimeout)
 	c.Data.WriteBufferSize = 1000
+	c.Data.GroupCommitSize = DefaultGroupCommitSize
+	c.Data.GroupCommitDelay = Duration(DefaultGroupCommitDelay)
+	c.Data.MaxPendingPoints = DefaultMaxPendingPoints
+	c.Data.MaxUnappliedWrites = DefaultMaxUnappliedWrites
 	c.Cluster.WriteBufferSize = 1000
 	c.Cluster.MaxResponseBufferSize = 100
+	c.Statsd.Port = statsd.DefaultPort
+	c.Statsd.FlushInterval = Duration(statsd.DefaultFlushInterval)
+	c.Statsd.Percentiles = statsd.DefaultPercentiles
+	c.Stream.Port = influxdb.DefaultStreamWritePort
+	c.Stream.BatchSize = influxdb.DefaultStreamWriteBatchSize
+	c.Stream.AckInterval = Duration(influxdb.DefaultStreamWriteAckInterval)
+	c.Postgres.Port = influxdb.DefaultPostgresPort
+	c.Kafka.Group = DefaultKafkaGroup
+	c.Kafka.BatchSize = influxdb.DefaultKafkaBatchSize
+	c.Kafka.BatchTimeout = Duration(influxdb.DefaultKafkaBatchTimeout)
+	c.Kafka.StartOffset = "oldest"
+	c.Monitoring.Database = DefaultMonitoringDatabase
+	c.Monitoring.RetentionPolicy = DefaultMonitoringRetentionPolicy
+	c.Monitoring.WriteInterval = Duration(DefaultMonitoringWriteInterval)
+	c.Compaction.CheckInterval = Duration(DefaultCompactionCheckInterval)
+	c.Compaction.Concurrency = DefaultCompactionConcurrency
+	c.Jobs.Concurrency = influxdb.DefaultJobConcurrency
+	c.Jobs.MaxQueued = influxdb.DefaultMaxQueuedJobs
+	c.Scrub.Interval = Duration(DefaultScrubInterval)
+	c.Downsampling.Enabled = true
+	c.Downsampling.CheckInterval = Duration(DefaultDownsamplingCheckInterval)
+	c.Alerting.CheckInterval = Duration(influxdb.DefaultAlertCheckInterval)
+	c.Alerting.PagerDutyURL = influxdb.DefaultPagerDutyURL
+	c.Audit.Enabled = true
+	c.Audit.File = filepath.Join(u.HomeDir, ".influxdb/audit.log")
 
 	// Detect hostname (or set to localhost).
 	if c.Hostname, _ = os.Hostname(); c.Hostname == "" {
@@ -175,10 +436,41 @@ func (c *Config) WriteBatchSize() int {
 }
 
 // MaxOpenShards returns the maximum number of shards to keep open at once.
+// Zero is unlimited.
 func (c *Config) MaxOpenShards() int {
 	return c.Data.MaxOpenShards
 }
 
+// CompactionWindow returns the start and end of the compaction window as
+// offsets from midnight. The window is formatted as "HH:MM-HH:MM" and an
+// empty window allows compactions at any time.
+func (c *Config) CompactionWindow() (start, end time.Duration, err error) {
+	if c.Compaction.Window == "" {
+		return 0, 0, nil
+	}
+
+	a := strings.Split(c.Compaction.Window, "-")
+	if len(a) != 2 {
+		return 0, 0, fmt.Errorf("invalid compaction window: %s", c.Compaction.Window)
+	}
+	if start, err = parseTimeOfDay(a[0]); err != nil {
+		return 0, 0, err
+	}
+	if end, err = parseTimeOfDay(a[1]); err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// parseTimeOfDay parses a "HH:MM" time into an offset from midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day: %s", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
 // ApiHTTPListenAddr returns the binding address the API HTTP server
 func (c *Config) ApiHTTPListenAddr() string {
 	return fmt.Sprintf("%s:%d", c.BindAddress, c.HTTPAPI.Port)
@@ -247,6 +539,28 @@ func (d *Duration) UnmarshalText(text []byte) error {
 	return nil
 }
 
+// FileMode is a TOML wrapper type for os.FileMode.
+// Modes are specified as octal strings such as "0770".
+type FileMode os.FileMode
+
+// UnmarshalText parses an octal file mode from text.
+func (m *FileMode) UnmarshalText(text []byte) error {
+	// Ignore if there is no value set.
+	if len(text) == 0 {
+		return nil
+	}
+
+	mode, err := strconv.ParseUint(string(text), 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid file mode: %s", text)
+	} else if mode > 0777 {
+		return fmt.Errorf("file mode out of range: %s", text)
+	}
+
+	*m = FileMode(mode)
+	return nil
+}
+
 // ParseConfigFile parses a configuration file at a given path.
 func ParseConfigFile(path string) (*Config, error) {
 	c := NewConfig()
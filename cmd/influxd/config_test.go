@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/influxdb/influxdb"
 	main "github.com/influxdb/influxdb/cmd/influxd"
 )
 
@@ -33,6 +34,26 @@ func TestSize_UnmarshalText_GB(t *testing.T) {
 	}
 }
 
+// Ensure that octal file modes can be parsed.
+func TestFileMode_UnmarshalText(t *testing.T) {
+	var m main.FileMode
+	if err := m.UnmarshalText([]byte("0750")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if m != 0750 {
+		t.Fatalf("unexpected mode: %o", m)
+	}
+}
+
+// Ensure that invalid file modes return an error.
+func TestFileMode_UnmarshalText_Invalid(t *testing.T) {
+	var m main.FileMode
+	if err := m.UnmarshalText([]byte("0789")); err == nil || err.Error() != "invalid file mode: 0789" {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := m.UnmarshalText([]byte("01777")); err == nil || err.Error() != "file mode out of range: 01777" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 // Ensure that a TOML configuration file can be parsed into a Config.
 func TestParseConfig(t *testing.T) {
 	c, err := main.ParseConfig(testFile)
@@ -64,6 +85,60 @@ func TestParseConfig(t *testing.T) {
 		t.Fatalf("http api ssl port mismatch: %v", c.HTTPAPI.SSLPort)
 	} else if c.HTTPAPI.SSLCertPath != "../cert.pem" {
 		t.Fatalf("http api ssl cert path mismatch: %v", c.HTTPAPI.SSLCertPath)
+	} else if c.HTTPAPI.UnixSocket != "/var/run/influxdb.sock" {
+		t.Fatalf("http api unix socket mismatch: %v", c.HTTPAPI.UnixSocket)
+	} else if c.HTTPAPI.UnixSocketPermissions != 0660 {
+		t.Fatalf("http api unix socket permissions mismatch: %o", c.HTTPAPI.UnixSocketPermissions)
+	} else if c.HTTPAPI.QueryCacheSize != 500 {
+		t.Fatalf("http api query cache size mismatch: %v", c.HTTPAPI.QueryCacheSize)
+	} else if c.HTTPAPI.ResultCacheSize != 100 {
+		t.Fatalf("http api result cache size mismatch: %v", c.HTTPAPI.ResultCacheSize)
+	} else if time.Duration(c.HTTPAPI.ResultCacheMinAge) != 5*time.Minute {
+		t.Fatalf("http api result cache min age mismatch: %v", c.HTTPAPI.ResultCacheMinAge)
+	} else if c.HTTPAPI.WriteIDCacheSize != 200 {
+		t.Fatalf("http api write id cache size mismatch: %v", c.HTTPAPI.WriteIDCacheSize)
+	} else if time.Duration(c.HTTPAPI.WriteIDTTL) != time.Minute {
+		t.Fatalf("http api write id ttl mismatch: %v", c.HTTPAPI.WriteIDTTL)
+	} else if !c.HTTPAPI.MetricsAuthentication {
+		t.Fatalf("http api metrics authentication mismatch: %v", c.HTTPAPI.MetricsAuthentication)
+	} else if time.Duration(c.HTTPAPI.SessionIdleTimeout) != 15*time.Minute {
+		t.Fatalf("http api session idle timeout mismatch: %v", c.HTTPAPI.SessionIdleTimeout)
+	} else if !c.HTTPAPI.SecureCookies {
+		t.Fatalf("http api secure cookies mismatch: %v", c.HTTPAPI.SecureCookies)
+	} else if !reflect.DeepEqual(c.HTTPAPI.AdminAllow, []string{"127.0.0.1", "10.0.0.0/8"}) {
+		t.Fatalf("http api admin allow mismatch: %v", c.HTTPAPI.AdminAllow)
+	} else if !reflect.DeepEqual(c.HTTPAPI.AdminDeny, []string{"10.0.99.0/24"}) {
+		t.Fatalf("http api admin deny mismatch: %v", c.HTTPAPI.AdminDeny)
+	} else if c.HTTPAPI.ServerTimestamps {
+		t.Fatalf("http api server timestamps mismatch: %v", c.HTTPAPI.ServerTimestamps)
+	} else if time.Duration(c.HTTPAPI.MaxPastSkew) != 24*time.Hour {
+		t.Fatalf("http api max past skew mismatch: %v", c.HTTPAPI.MaxPastSkew)
+	} else if time.Duration(c.HTTPAPI.MaxFutureSkew) != 10*time.Minute {
+		t.Fatalf("http api max future skew mismatch: %v", c.HTTPAPI.MaxFutureSkew)
+	} else if c.HTTPAPI.Limits.QueriesPerMinute != 600 {
+		t.Fatalf("http api queries per minute mismatch: %v", c.HTTPAPI.Limits.QueriesPerMinute)
+	} else if c.HTTPAPI.Limits.PointsPerSecond != 5000 {
+		t.Fatalf("http api points per second mismatch: %v", c.HTTPAPI.Limits.PointsPerSecond)
+	} else if c.HTTPAPI.Limits.MaxPointsScanned != 1000000 {
+		t.Fatalf("http api max points scanned mismatch: %v", c.HTTPAPI.Limits.MaxPointsScanned)
+	} else if c.HTTPAPI.Limits.MaxQueryMemory != 104857600 {
+		t.Fatalf("http api max query memory mismatch: %v", c.HTTPAPI.Limits.MaxQueryMemory)
+	} else if time.Duration(c.HTTPAPI.Limits.QueryTimeout) != 30*time.Second {
+		t.Fatalf("http api query timeout mismatch: %v", c.HTTPAPI.Limits.QueryTimeout)
+	} else if c.HTTPAPI.Limits.MaxRowLimit != 10000 {
+		t.Fatalf("http api max row limit mismatch: %v", c.HTTPAPI.Limits.MaxRowLimit)
+	} else if c.HTTPAPI.Limits.MaxFieldsPerPoint != 100 {
+		t.Fatalf("http api max fields per point mismatch: %v", c.HTTPAPI.Limits.MaxFieldsPerPoint)
+	} else if time.Duration(c.HTTPAPI.Limits.MaxFuture) != 1*time.Hour {
+		t.Fatalf("http api max future mismatch: %v", c.HTTPAPI.Limits.MaxFuture)
+	} else if !c.HTTPAPI.Limits.RejectExpired {
+		t.Fatalf("http api reject expired mismatch: %v", c.HTTPAPI.Limits.RejectExpired)
+	} else if c.HTTPAPI.Limits.MaxTagsPerPoint != 10 {
+		t.Fatalf("http api max tags per point mismatch: %v", c.HTTPAPI.Limits.MaxTagsPerPoint)
+	} else if c.HTTPAPI.Limits.MaxKeyLength != 256 {
+		t.Fatalf("http api max key length mismatch: %v", c.HTTPAPI.Limits.MaxKeyLength)
+	} else if c.HTTPAPI.Limits.MaxValueLength != 1024 {
+		t.Fatalf("http api max value length mismatch: %v", c.HTTPAPI.Limits.MaxValueLength)
 	}
 
 	if len(c.Graphites) != 2 {
@@ -100,6 +175,71 @@ func TestParseConfig(t *testing.T) {
 		t.Fatalf("graphite database mismatch: expected %v, got %v", "graphite_udp", udpGraphite.Database)
 	case strings.ToLower(udpGraphite.Protocol) != "udp":
 		t.Fatalf("graphite udp protocol mismatch: expected %v, got %v", "udp", strings.ToLower(udpGraphite.Protocol))
+	case udpGraphite.Precision != "s":
+		t.Fatalf("graphite udp precision mismatch: expected %v, got %v", "s", udpGraphite.Precision)
+	case time.Duration(udpGraphite.MaxFutureSkew) != 5*time.Minute:
+		t.Fatalf("graphite udp max future skew mismatch: expected %v, got %v", 5*time.Minute, udpGraphite.MaxFutureSkew)
+	}
+
+	if c.Statsd.Enabled != true {
+		t.Fatalf("statsd enabled mismatch: %v", c.Statsd.Enabled)
+	} else if c.Statsd.Port != 8126 {
+		t.Fatalf("statsd port mismatch: %v", c.Statsd.Port)
+	} else if c.Statsd.Database != "statsd" {
+		t.Fatalf("statsd database mismatch: %v", c.Statsd.Database)
+	} else if c.Statsd.RetentionPolicy != "raw" {
+		t.Fatalf("statsd retention policy mismatch: %v", c.Statsd.RetentionPolicy)
+	} else if time.Duration(c.Statsd.FlushInterval) != 5*time.Second {
+		t.Fatalf("statsd flush interval mismatch: %v", c.Statsd.FlushInterval)
+	} else if !reflect.DeepEqual(c.Statsd.Percentiles, []float64{90, 99.9}) {
+		t.Fatalf("statsd percentiles mismatch: %v", c.Statsd.Percentiles)
+	}
+
+	if c.Stream.Enabled != true {
+		t.Fatalf("stream enabled mismatch: %v", c.Stream.Enabled)
+	} else if c.Stream.Port != 8088 {
+		t.Fatalf("stream port mismatch: %v", c.Stream.Port)
+	} else if c.Stream.BatchSize != influxdb.DefaultStreamWriteBatchSize {
+		t.Fatalf("stream batch size mismatch: %v", c.Stream.BatchSize)
+	} else if time.Duration(c.Stream.AckInterval) != 500*time.Millisecond {
+		t.Fatalf("stream ack interval mismatch: %v", c.Stream.AckInterval)
+	} else if time.Duration(c.Stream.MaxPastSkew) != time.Hour {
+		t.Fatalf("stream max past skew mismatch: %v", c.Stream.MaxPastSkew)
+	}
+
+	if c.Postgres.Enabled != true {
+		t.Fatalf("postgres enabled mismatch: %v", c.Postgres.Enabled)
+	} else if c.Postgres.Port != 5433 {
+		t.Fatalf("postgres port mismatch: %v", c.Postgres.Port)
+	}
+
+	if c.Kafka.Enabled != true {
+		t.Fatalf("kafka enabled mismatch: %v", c.Kafka.Enabled)
+	} else if !reflect.DeepEqual(c.Kafka.Brokers, []string{"kafka1:9092", "kafka2:9092"}) {
+		t.Fatalf("kafka brokers mismatch: %v", c.Kafka.Brokers)
+	} else if c.Kafka.Group != "influx" {
+		t.Fatalf("kafka group mismatch: %v", c.Kafka.Group)
+	} else if c.Kafka.BatchSize != 500 {
+		t.Fatalf("kafka batch size mismatch: %v", c.Kafka.BatchSize)
+	} else if time.Duration(c.Kafka.BatchTimeout) != 2*time.Second {
+		t.Fatalf("kafka batch timeout mismatch: %v", c.Kafka.BatchTimeout)
+	} else if c.Kafka.StartOffset != "newest" {
+		t.Fatalf("kafka start offset mismatch: %v", c.Kafka.StartOffset)
+	} else if !reflect.DeepEqual(c.Kafka.Topics, []main.KafkaTopic{
+		{Name: "cpu", Database: "metrics"},
+		{Name: "events", Database: "events", RetentionPolicy: "raw", Format: "json", Precision: "s", ServerTimestamps: true},
+	}) {
+		t.Fatalf("kafka topics mismatch: %v", c.Kafka.Topics)
+	}
+
+	if u := c.InputPlugins.UDPInput; !u.Enabled {
+		t.Fatalf("udp input enabled mismatch: %v", u.Enabled)
+	} else if u.Port != 4444 {
+		t.Fatalf("udp input port mismatch: %v", u.Port)
+	} else if u.Precision != "ms" {
+		t.Fatalf("udp input precision mismatch: %v", u.Precision)
+	} else if !u.ServerTimestamps {
+		t.Fatalf("udp input server timestamps mismatch: %v", u.ServerTimestamps)
 	}
 
 	if c.Broker.Port != 8090 {
@@ -112,6 +252,30 @@ func TestParseConfig(t *testing.T) {
 
 	if c.Data.Dir != "/tmp/influxdb/development/db" {
 		t.Fatalf("data dir mismatch: %v", c.Data.Dir)
+	} else if c.Data.GroupCommitSize != 500 {
+		t.Fatalf("group commit size mismatch: %v", c.Data.GroupCommitSize)
+	} else if c.Data.TimestampPrecision != "ms" {
+		t.Fatalf("timestamp precision mismatch: %v", c.Data.TimestampPrecision)
+	} else if time.Duration(c.Data.GroupCommitDelay) != 5*time.Millisecond {
+		t.Fatalf("group commit delay mismatch: %v", c.Data.GroupCommitDelay)
+	} else if c.Data.MaxPendingPoints != 2000 {
+		t.Fatalf("max pending points mismatch: %v", c.Data.MaxPendingPoints)
+	} else if c.Data.MaxUnappliedWrites != 300 {
+		t.Fatalf("max unapplied writes mismatch: %v", c.Data.MaxUnappliedWrites)
+	} else if c.Data.QueryConcurrency != 4 {
+		t.Fatalf("query concurrency mismatch: %v", c.Data.QueryConcurrency)
+	} else if c.Data.QuerySpillDir != "/tmp/influxdb/spill" {
+		t.Fatalf("query spill dir mismatch: %v", c.Data.QuerySpillDir)
+	} else if c.Data.MaxCursors != 10 {
+		t.Fatalf("max cursors mismatch: %v", c.Data.MaxCursors)
+	} else if time.Duration(c.Data.CursorTimeout) != 30*time.Second {
+		t.Fatalf("cursor timeout mismatch: %v", c.Data.CursorTimeout)
+	} else if time.Duration(c.Data.IndexIdleTimeout) != 5*time.Minute {
+		t.Fatalf("index idle timeout mismatch: %v", c.Data.IndexIdleTimeout)
+	} else if c.Data.MaxOpenShards != 50 {
+		t.Fatalf("max open shards mismatch: %v", c.Data.MaxOpenShards)
+	} else if time.Duration(c.Data.ShutdownTimeout) != 10*time.Second {
+		t.Fatalf("shutdown timeout mismatch: %v", c.Data.ShutdownTimeout)
 	}
 
 	if c.Cluster.ProtobufPort != 8099 {
@@ -128,6 +292,66 @@ func TestParseConfig(t *testing.T) {
 		t.Fatalf("max response buffer size mismatch: %v", c.Cluster.MaxResponseBufferSize)
 	}
 
+	if !c.Monitoring.Enabled {
+		t.Fatalf("monitoring enabled mismatch: %v", c.Monitoring.Enabled)
+	} else if c.Monitoring.Database != "_internal" {
+		t.Fatalf("monitoring database mismatch: %v", c.Monitoring.Database)
+	} else if c.Monitoring.RetentionPolicy != "default" {
+		t.Fatalf("monitoring retention policy mismatch: %v", c.Monitoring.RetentionPolicy)
+	} else if time.Duration(c.Monitoring.WriteInterval) != 10*time.Second {
+		t.Fatalf("monitoring write interval mismatch: %v", c.Monitoring.WriteInterval)
+	}
+
+	if !c.Compaction.Enabled {
+		t.Fatalf("compaction enabled mismatch: %v", c.Compaction.Enabled)
+	} else if time.Duration(c.Compaction.CheckInterval) != 30*time.Minute {
+		t.Fatalf("compaction check interval mismatch: %v", c.Compaction.CheckInterval)
+	} else if c.Compaction.Concurrency != 2 {
+		t.Fatalf("compaction concurrency mismatch: %v", c.Compaction.Concurrency)
+	} else if c.Compaction.MaxThroughput != 5*(1<<20) {
+		t.Fatalf("compaction max throughput mismatch: %v", c.Compaction.MaxThroughput)
+	} else if start, end, err := c.CompactionWindow(); err != nil || start != 22*time.Hour+30*time.Minute || end != 4*time.Hour {
+		t.Fatalf("compaction window mismatch: %v-%v (%v)", start, end, err)
+	}
+
+	if c.Jobs.Concurrency != 3 {
+		t.Fatalf("jobs concurrency mismatch: %v", c.Jobs.Concurrency)
+	} else if c.Jobs.MaxQueued != 20 {
+		t.Fatalf("jobs max queued mismatch: %v", c.Jobs.MaxQueued)
+	} else if c.Jobs.MaxThroughput != 8*(1<<20) {
+		t.Fatalf("jobs max throughput mismatch: %v", c.Jobs.MaxThroughput)
+	}
+
+	if !c.Scrub.Enabled {
+		t.Fatalf("scrub enabled mismatch: %v", c.Scrub.Enabled)
+	} else if time.Duration(c.Scrub.Interval) != 6*time.Hour {
+		t.Fatalf("scrub interval mismatch: %v", c.Scrub.Interval)
+	} else if c.Scrub.Username != "scrubber" || c.Scrub.Password != "secret" {
+		t.Fatalf("scrub credentials mismatch: %v/%v", c.Scrub.Username, c.Scrub.Password)
+	}
+
+	if c.Downsampling.Enabled {
+		t.Fatalf("downsampling enabled mismatch: %v", c.Downsampling.Enabled)
+	} else if time.Duration(c.Downsampling.CheckInterval) != 5*time.Minute {
+		t.Fatalf("downsampling check interval mismatch: %v", c.Downsampling.CheckInterval)
+	}
+
+	if !c.Alerting.Enabled {
+		t.Fatalf("alerting enabled mismatch: %v", c.Alerting.Enabled)
+	} else if time.Duration(c.Alerting.CheckInterval) != 30*time.Second {
+		t.Fatalf("alerting check interval mismatch: %v", c.Alerting.CheckInterval)
+	} else if c.Alerting.SMTPAddr != "smtp.example.com:25" || c.Alerting.SMTPFrom != "alerts@example.com" {
+		t.Fatalf("alerting smtp mismatch: %v/%v", c.Alerting.SMTPAddr, c.Alerting.SMTPFrom)
+	} else if c.Alerting.PagerDutyURL != influxdb.DefaultPagerDutyURL {
+		t.Fatalf("alerting pagerduty url mismatch: %v", c.Alerting.PagerDutyURL)
+	}
+
+	if c.Audit.Enabled {
+		t.Fatalf("audit enabled mismatch: %v", c.Audit.Enabled)
+	} else if c.Audit.File != "/tmp/audit.log" {
+		t.Fatalf("audit file mismatch: %v", c.Audit.File)
+	}
+
 	// TODO: UDP Servers testing.
 	/*
 		c.Assert(config.UdpServers, HasLen, 1)
@@ -169,6 +393,34 @@ ssl-cert = "../cert.pem"
 # and keep alive connections they don't use won't end up connection a million times.
 # However, if a request is taking longer than this to complete, could be a problem.
 read-timeout = "5s"
+unix-socket = "/var/run/influxdb.sock"
+unix-socket-permissions = "0660"
+query-cache-size = 500
+result-cache-size = 100
+result-cache-min-age = "5m"
+write-id-cache-size = 200
+write-id-ttl = "1m"
+metrics-authentication = true
+session-idle-timeout = "15m"
+secure-cookies = true
+admin-allow = ["127.0.0.1", "10.0.0.0/8"]
+admin-deny = ["10.0.99.0/24"]
+max-past-skew = "24h"
+max-future-skew = "10m"
+
+  [api.limits]
+  queries-per-minute = 600
+  points-per-second = 5000
+  max-points-scanned = 1000000
+  max-query-memory = 104857600
+  query-timeout = "30s"
+  max-row-limit = 10000
+  max-fields-per-point = 100
+  max-tags-per-point = 10
+  max-key-length = 256
+  max-value-length = 1024
+  max-future = "1h"
+  reject-expired = true
 
 [input_plugins]
 
@@ -176,6 +428,8 @@ read-timeout = "5s"
   enabled = true
   port = 4444
   database = "test"
+  precision = "ms"
+  server-timestamps = true
 
 # Configure the Graphite servers
 [[graphite]]
@@ -193,6 +447,83 @@ enabled = true
 address = "192.168.0.2"
 port = 2005
 database = "graphite_udp"  # store graphite data in this database
+precision = "s"
+max-future-skew = "5m"
+
+[statsd]
+enabled = true
+port = 8126
+database = "statsd"
+retention-policy = "raw"
+flush-interval = "5s"
+percentiles = [90.0, 99.9]
+
+[stream]
+enabled = true
+port = 8088
+ack-interval = "500ms"
+max-past-skew = "1h"
+
+[postgres]
+enabled = true
+port = 5433
+
+[kafka]
+enabled = true
+brokers = ["kafka1:9092", "kafka2:9092"]
+group = "influx"
+batch-size = 500
+batch-timeout = "2s"
+start-offset = "newest"
+
+[[kafka.topics]]
+name = "cpu"
+database = "metrics"
+
+[[kafka.topics]]
+name = "events"
+database = "events"
+retention-policy = "raw"
+format = "json"
+precision = "s"
+server-timestamps = true
+
+# Write per-database statistics to the _internal database
+[monitoring]
+enabled = true
+write-interval = "10s"
+
+[compaction]
+enabled = true
+check-interval = "30m"
+concurrency = 2
+max-throughput = "5m"
+window = "22:30-04:00"
+
+[jobs]
+concurrency = 3
+max-queued = 20
+max-throughput = "8m"
+
+[scrub]
+enabled = true
+interval = "6h"
+username = "scrubber"
+password = "secret"
+
+[downsampling]
+enabled = false
+check-interval = "5m"
+
+[alerting]
+enabled = true
+check-interval = "30s"
+smtp-address = "smtp.example.com:25"
+smtp-from = "alerts@example.com"
+
+[audit]
+enabled = false
+file = "/tmp/audit.log"
 
 # Raft configuration
 [raft]
@@ -217,6 +548,19 @@ dir = "/tmp/influxdb/development/db"
 # will be replayed from the WAL
 write-buffer-size = 10000
 
+group-commit-size = 500
+timestamp-precision = "ms"
+group-commit-delay = "5ms"
+max-pending-points = 2000
+max-unapplied-writes = 300
+query-concurrency = 4
+query-spill-dir = "/tmp/influxdb/spill"
+max-cursors = 10
+cursor-timeout = "30s"
+index-idle-timeout = "5m"
+max-open-shards = 50
+shutdown-timeout = "10s"
+
 # The server will check this often for shards that have expired and should be cleared.
 retention-sweep-period = "10m"
 
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ClusterClient manages the nodes of a cluster over the HTTP API.
+type ClusterClient struct {
+	URL      url.URL
+	Username string
+	Password string
+}
+
+// Node represents a meta or data node returned by the API.
+// Meta nodes only have a URL.
+type Node struct {
+	ID   uint64 `json:"id,omitempty"`
+	URL  string `json:"url"`
+	Role string `json:"role,omitempty"`
+}
+
+// MetaNodes returns the brokers that hold the cluster's metadata.
+func (c *ClusterClient) MetaNodes() ([]*Node, error) {
+	var a []*Node
+	if err := c.do("GET", "/meta_nodes", nil, &a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// DataNodes returns the nodes that hold shards.
+func (c *ClusterClient) DataNodes() ([]*Node, error) {
+	var a []*Node
+	if err := c.do("GET", "/data_nodes", nil, &a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// AddDataNode adds a data node to the cluster. The role defaults to "data".
+func (c *ClusterClient) AddDataNode(u *url.URL, role string) (*Node, error) {
+	body, _ := json.Marshal(&Node{URL: u.String(), Role: role})
+	var n Node
+	if err := c.do("POST", "/data_nodes", body, &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// RemoveDataNode removes a data node from the cluster.
+func (c *ClusterClient) RemoveDataNode(id uint64) error {
+	return c.do("DELETE", "/data_nodes/"+strconv.FormatUint(id, 10), nil, nil)
+}
+
+// do sends a request to the API and decodes the response into v, if set.
+func (c *ClusterClient) do(method, path string, body []byte, v interface{}) error {
+	params := url.Values{}
+	if c.Username != "" {
+		params.Set("u", c.Username)
+		params.Set("p", c.Password)
+	}
+
+	u := c.URL
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	} else if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// execCtl runs the "ctl" command.
+func execCtl(args []string) {
+	// Parse command flags.
+	fs := flag.NewFlagSet("", flag.ExitOnError)
+	var (
+		host     = fs.String("url", "http://localhost:8086", "")
+		username = fs.String("username", "", "")
+		password = fs.String("password", "", "")
+		role     = fs.String("role", "", "")
+	)
+	fs.Usage = printCtlUsage
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		printCtlUsage()
+		os.Exit(2)
+	}
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+
+	u, err := url.Parse(*host)
+	if err != nil {
+		log.Fatalf("ctl: invalid url: %s", err)
+	}
+	c := &ClusterClient{URL: *u, Username: *username, Password: *password}
+
+	switch cmd {
+	case "show":
+		metaNodes, err := c.MetaNodes()
+		if err != nil {
+			log.Fatalf("ctl: %s", err)
+		}
+		dataNodes, err := c.DataNodes()
+		if err != nil {
+			log.Fatalf("ctl: %s", err)
+		}
+
+		fmt.Println("Meta nodes:")
+		for _, n := range metaNodes {
+			fmt.Printf("    %s\n", n.URL)
+		}
+		fmt.Println("Data nodes:")
+		for _, n := range dataNodes {
+			fmt.Printf("    %d\t%s\t%s\n", n.ID, n.URL, n.Role)
+		}
+
+	case "add-data":
+		if len(args) != 1 {
+			log.Fatal("ctl: url required")
+		}
+		nodeURL, err := url.Parse(args[0])
+		if err != nil {
+			log.Fatalf("ctl: invalid node url: %s", err)
+		}
+		n, err := c.AddDataNode(nodeURL, *role)
+		if err != nil {
+			log.Fatalf("ctl: %s", err)
+		}
+		log.Printf("ctl: added data node %d (%s)", n.ID, n.Role)
+
+	case "remove-data":
+		if len(args) != 1 {
+			log.Fatal("ctl: node id required")
+		}
+		id, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			log.Fatalf("ctl: invalid node id: %s", args[0])
+		}
+		if err := c.RemoveDataNode(id); err != nil {
+			log.Fatalf("ctl: %s", err)
+		}
+		log.Printf("ctl: removed data node %d", id)
+
+	default:
+		log.Fatalf(`ctl: unknown command "%s"`, cmd)
+	}
+}
+
+func printCtlUsage() {
+	log.Print(`usage: ctl [flags] <command> [arguments]
+
+ctl manages the nodes of a running cluster. Meta nodes are the brokers that
+hold the cluster's metadata and data nodes hold shards. The commands are:
+
+        show
+                          List the meta and data nodes.
+
+        add-data <url>
+                          Add a data node with the given URL.
+
+        remove-data <id>
+                          Remove a data node by id.
+
+        -url <url>
+                          URL of a data node. Defaults to http://localhost:8086.
+
+        -username <name>
+        -password <password>
+                          Credentials of an admin if authentication is enabled.
+
+        -role <role>
+                          Role of a new data node, either "data" or "query".
+                          Query nodes hold shards but don't accept writes.
+
+Meta nodes are added by running "join-cluster -role broker" on the new node.
+`)
+}
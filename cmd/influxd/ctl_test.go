@@ -0,0 +1,98 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	main "github.com/influxdb/influxdb/cmd/influxd"
+)
+
+// Ensure the client can list the nodes of a cluster.
+func TestClusterClient_Nodes(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Query().Get("u") != "susy" {
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL)
+		}
+		switch r.URL.Path {
+		case "/meta_nodes":
+			w.Write([]byte(`[{"url":"http://localhost:8086"}]`))
+		case "/data_nodes":
+			w.Write([]byte(`[{"id":1,"url":"http://localhost:8086","role":"data"},{"id":2,"url":"http://localhost:9086","role":"query"}]`))
+		default:
+			t.Fatalf("unexpected path: %s", r.URL.Path)
+		}
+	}))
+	defer s.Close()
+
+	c := NewClusterClient(s)
+	c.Username, c.Password = "susy", "pass"
+	if a, err := c.MetaNodes(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(a, []*main.Node{{URL: "http://localhost:8086"}}) {
+		t.Fatalf("unexpected meta nodes: %#v", a)
+	}
+	if a, err := c.DataNodes(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(a, []*main.Node{
+		{ID: 1, URL: "http://localhost:8086", Role: "data"},
+		{ID: 2, URL: "http://localhost:9086", Role: "query"},
+	}) {
+		t.Fatalf("unexpected data nodes: %#v", a)
+	}
+}
+
+// Ensure the client can add and remove data nodes.
+func TestClusterClient_AddRemoveDataNode(t *testing.T) {
+	var requests []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+string(b))
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":2,"url":"http://localhost:9086","role":"query"}`))
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer s.Close()
+
+	c := NewClusterClient(s)
+	u, _ := url.Parse("http://localhost:9086")
+	if n, err := c.AddDataNode(u, "query"); err != nil {
+		t.Fatal(err)
+	} else if n.ID != 2 || n.Role != "query" {
+		t.Fatalf("unexpected node: %#v", n)
+	}
+	if err := c.RemoveDataNode(2); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(requests, []string{
+		`POST /data_nodes {"url":"http://localhost:9086","role":"query"}`,
+		`DELETE /data_nodes/2 `,
+	}) {
+		t.Fatalf("unexpected requests: %q", requests)
+	}
+}
+
+// Ensure the client returns errors from the server.
+func TestClusterClient_RemoveDataNode_Error(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "data node not found", http.StatusNotFound)
+	}))
+	defer s.Close()
+
+	if err := NewClusterClient(s).RemoveDataNode(100); err == nil || err.Error() != "404: data node not found" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// NewClusterClient returns a client for a test server.
+func NewClusterClient(s *httptest.Server) *main.ClusterClient {
+	u, _ := url.Parse(s.URL)
+	return &main.ClusterClient{URL: *u}
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/influxql"
+)
+
+const (
+	// DefaultImportBatchSize represents the number of points written per request.
+	DefaultImportBatchSize = 5000
+
+	// DefaultImportProgressInterval represents the period between progress reports.
+	DefaultImportProgressInterval = 5 * time.Second
+
+	// DefaultImportMaxRetries represents the number of times a batch is retried
+	// while the server is rejecting writes.
+	DefaultImportMaxRetries = 10
+)
+
+// Importer writes a dump of line protocol to a server over the HTTP API.
+//
+// A dump has an optional "# DDL" section of statements that are executed
+// first, followed by a "# DML" section of points. The points are written to
+// the database and retention policy set by the most recent
+// "# CONTEXT-DATABASE:" and "# CONTEXT-RETENTION-POLICY:" lines.
+// Other lines starting with "#" are ignored.
+type Importer struct {
+	URL      url.URL
+	Username string
+	Password string
+
+	// Timestamp precision of the points in the dump.
+	Precision influxdb.TimePrecision
+
+	// Number of points written per request.
+	BatchSize int
+
+	// Maximum number of points written per second. Zero is unlimited.
+	PointsPerSecond int
+
+	// Number of times a batch is retried while the server rejects writes.
+	MaxRetries int
+
+	// Lines that fail are written here so that they can be imported again.
+	Failed io.Writer
+
+	// Period between progress reports.
+	ProgressInterval time.Duration
+
+	Logger *log.Logger
+
+	database        string
+	retentionPolicy string
+	batch           []*importLine
+
+	start        time.Time
+	lastProgress time.Time
+	points       int // number of points written
+	failures     int // number of lines that failed
+	failedDB     string
+	failedRP     string
+}
+
+// importLine represents a parsed line waiting to be written.
+type importLine struct {
+	text  string
+	point *influxdb.Point
+}
+
+// NewImporter returns a new instance of Importer.
+func NewImporter(u url.URL) *Importer {
+	return &Importer{
+		URL:              u,
+		Precision:        influxdb.NanosecondPrecision,
+		BatchSize:        DefaultImportBatchSize,
+		MaxRetries:       DefaultImportMaxRetries,
+		Failed:           ioutil.Discard,
+		ProgressInterval: DefaultImportProgressInterval,
+		Logger:           log.New(os.Stderr, "", 0),
+	}
+}
+
+// Points returns the number of points written and the number of lines that failed.
+func (i *Importer) Points() (written, failed int) {
+	return i.points, i.failures
+}
+
+// Import reads a dump from r and writes it to the server.
+// Lines that cannot be parsed or are rejected by the server are written to
+// Failed and do not stop the import.
+func (i *Importer) Import(r io.Reader) error {
+	i.start = time.Now()
+	i.lastProgress = i.start
+
+	ddl := false
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+			continue
+		case line == "# DDL":
+			ddl = true
+		case line == "# DML":
+			ddl = false
+		case strings.HasPrefix(line, "# CONTEXT-DATABASE:"):
+			if err := i.flush(); err != nil {
+				return err
+			}
+			i.database = strings.TrimSpace(strings.TrimPrefix(line, "# CONTEXT-DATABASE:"))
+		case strings.HasPrefix(line, "# CONTEXT-RETENTION-POLICY:"):
+			if err := i.flush(); err != nil {
+				return err
+			}
+			i.retentionPolicy = strings.TrimSpace(strings.TrimPrefix(line, "# CONTEXT-RETENTION-POLICY:"))
+		case strings.HasPrefix(line, "#"):
+			continue
+		case ddl:
+			if err := i.execute(line); err != nil {
+				i.fail(line, err)
+			}
+		default:
+			if err := i.add(line); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := i.flush(); err != nil {
+		return err
+	}
+
+	i.Logger.Printf("import: wrote %d points, %d failed, in %s", i.points, i.failures, time.Since(i.start))
+	return nil
+}
+
+// add parses a line and adds it to the batch, writing the batch once it is full.
+func (i *Importer) add(line string) error {
+	p, err := influxdb.ParseLine(line, i.Precision, time.Now().UTC())
+	if err != nil {
+		i.fail(line, err)
+		return nil
+	} else if i.database == "" {
+		i.fail(line, fmt.Errorf("database required"))
+		return nil
+	}
+
+	i.batch = append(i.batch, &importLine{text: line, point: p})
+	if len(i.batch) >= i.BatchSize {
+		return i.flush()
+	}
+	return nil
+}
+
+// flush writes the batch to the server, limits the write rate and reports
+// progress. Rejected points are failed and the rest of the batch is retried.
+func (i *Importer) flush() error {
+	batch := i.batch
+	i.batch = nil
+
+	for attempt := 0; len(batch) > 0; attempt++ {
+		status, body, retryAfter, err := i.write(batch)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case status == http.StatusNoContent:
+			i.points += len(batch)
+			batch = nil
+
+		case status == http.StatusServiceUnavailable && attempt < i.MaxRetries:
+			i.Logger.Printf("import: server busy, retrying in %s", retryAfter)
+			time.Sleep(retryAfter)
+
+		case status == http.StatusBadRequest:
+			// Fail the rejected points and retry the rest. Otherwise fail the batch.
+			var resp struct {
+				Err    string `json:"error"`
+				Points []struct {
+					Index int    `json:"index"`
+					Err   string `json:"error"`
+				} `json:"points"`
+			}
+			if json.Unmarshal(body, &resp) != nil || len(resp.Points) == 0 {
+				i.failBatch(batch, fmt.Errorf("%s", strings.TrimSpace(string(body))))
+				batch = nil
+				continue
+			}
+			rejected := make(map[int]bool)
+			for _, p := range resp.Points {
+				if p.Index >= 0 && p.Index < len(batch) && !rejected[p.Index] {
+					rejected[p.Index] = true
+					i.fail(batch[p.Index].text, fmt.Errorf("%s", p.Err))
+				}
+			}
+			var other []*importLine
+			for j, l := range batch {
+				if !rejected[j] {
+					other = append(other, l)
+				}
+			}
+			batch = other
+
+		default:
+			i.failBatch(batch, fmt.Errorf("%d: %s", status, strings.TrimSpace(string(body))))
+			batch = nil
+		}
+	}
+
+	i.throttle()
+	i.progress()
+	return nil
+}
+
+// write sends a batch of points to the server. Returns the response status
+// and body, and how long to wait before retrying.
+func (i *Importer) write(batch []*importLine) (status int, body []byte, retryAfter time.Duration, err error) {
+	// Encode each point as a series in the JSON write format.
+	type series struct {
+		Name    string            `json:"name"`
+		Tags    map[string]string `json:"tags,omitempty"`
+		Columns []string          `json:"columns"`
+		Points  [][]interface{}   `json:"points"`
+	}
+	a := make([]*series, 0, len(batch))
+	for _, l := range batch {
+		s := &series{Name: l.point.Name, Tags: l.point.Tags, Columns: []string{"time"}}
+		row := []interface{}{l.point.Timestamp.UnixNano()}
+		for k, v := range l.point.Values {
+			s.Columns = append(s.Columns, k)
+			row = append(row, v)
+		}
+		s.Points = [][]interface{}{row}
+		a = append(a, s)
+	}
+	b, err := json.Marshal(a)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+
+	params := url.Values{"time_precision": {"n"}}
+	if i.retentionPolicy != "" {
+		params.Set("rp", i.retentionPolicy)
+	}
+	resp, err := i.do("POST", "/db/"+i.database+"/series", params, b)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	retryAfter = time.Second
+	if n, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && n > 0 {
+		retryAfter = time.Duration(n) * time.Second
+	}
+	return resp.StatusCode, body, retryAfter, nil
+}
+
+// execute runs a DDL statement. Databases and retention policies are created
+// with the HTTP API and other statements are sent as queries.
+func (i *Importer) execute(line string) error {
+	stmt, err := influxql.NewParser(strings.NewReader(line)).ParseStatement()
+	if err != nil {
+		return err
+	}
+
+	switch stmt := stmt.(type) {
+	case *influxql.CreateDatabaseStatement:
+		b, _ := json.Marshal(map[string]string{"name": stmt.Name})
+		return i.request("POST", "/db", nil, b, http.StatusCreated, http.StatusConflict)
+
+	case *influxql.CreateRetentionPolicyStatement:
+		b, _ := json.Marshal(map[string]interface{}{"name": stmt.Name, "duration": stmt.Duration, "replicaN": stmt.Replication})
+		if err := i.request("POST", "/db/"+stmt.Database+"/retention_policies", nil, b, http.StatusCreated, http.StatusConflict); err != nil {
+			return err
+		} else if stmt.Default {
+			return i.query(stmt.Database, fmt.Sprintf("ALTER RETENTION POLICY %s ON %s DEFAULT", stmt.Name, stmt.Database))
+		}
+		return nil
+
+	case *influxql.AlterDatabaseStatement:
+		return i.query(stmt.Name, line)
+	case *influxql.AlterRetentionPolicyStatement:
+		return i.query(stmt.Database, line)
+	default:
+		return i.query(i.database, line)
+	}
+}
+
+// query executes a query against a database. Returns the first statement error.
+func (i *Importer) query(database, q string) error {
+	resp, err := i.do("GET", "/query", url.Values{"db": {database}, "q": {q}}, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	var results []struct {
+		Err string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Err != "" {
+			return fmt.Errorf("%s", r.Err)
+		}
+	}
+	return nil
+}
+
+// request sends a request and returns an error unless the response has one of the given statuses.
+func (i *Importer) request(method, path string, params url.Values, body []byte, statuses ...int) error {
+	resp, err := i.do(method, path, params, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	for _, status := range statuses {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("%d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+}
+
+// do sends a request to the server with the importer's credentials.
+func (i *Importer) do(method, path string, params url.Values, body []byte) (*http.Response, error) {
+	if params == nil {
+		params = url.Values{}
+	}
+	if i.Username != "" {
+		params.Set("u", i.Username)
+		params.Set("p", i.Password)
+	}
+
+	u := i.URL
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// fail writes a failed line to the failed lines, preceded by its context
+// if the context changed since the last failed line.
+func (i *Importer) fail(line string, err error) {
+	i.failures++
+	i.Logger.Printf("import: %s: %s", err, line)
+
+	if i.database != i.failedDB {
+		fmt.Fprintf(i.Failed, "# CONTEXT-DATABASE: %s\n", i.database)
+		i.failedDB = i.database
+	}
+	if i.retentionPolicy != i.failedRP {
+		fmt.Fprintf(i.Failed, "# CONTEXT-RETENTION-POLICY: %s\n", i.retentionPolicy)
+		i.failedRP = i.retentionPolicy
+	}
+	fmt.Fprintln(i.Failed, line)
+}
+
+// failBatch fails every line in a batch.
+func (i *Importer) failBatch(batch []*importLine, err error) {
+	for _, l := range batch {
+		i.fail(l.text, err)
+	}
+}
+
+// throttle sleeps until the write rate is within the points per second limit.
+func (i *Importer) throttle() {
+	if i.PointsPerSecond <= 0 {
+		return
+	}
+	expected := time.Duration(float64(i.points) / float64(i.PointsPerSecond) * float64(time.Second))
+	if d := expected - time.Since(i.start); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// progress reports the number of points written once per progress interval.
+func (i *Importer) progress() {
+	if i.ProgressInterval <= 0 || time.Since(i.lastProgress) < i.ProgressInterval {
+		return
+	}
+	i.lastProgress = time.Now()
+
+	elapsed := time.Since(i.start)
+	i.Logger.Printf("import: wrote %d points, %d failed, %.0f points/sec", i.points, i.failures, float64(i.points)/elapsed.Seconds())
+}
+
+// execImport runs the "import" command.
+func execImport(args []string) {
+	// Parse command flags.
+	fs := flag.NewFlagSet("", flag.ExitOnError)
+	var (
+		host      = fs.String("url", "http://localhost:8086", "")
+		path      = fs.String("path", "", "")
+		username  = fs.String("username", "", "")
+		password  = fs.String("password", "", "")
+		precision = fs.String("precision", "n", "")
+		batchSize = fs.Int("batch-size", DefaultImportBatchSize, "")
+		pps       = fs.Int("pps", 0, "")
+		failed    = fs.String("failed", "", "")
+	)
+	fs.Usage = printImportUsage
+	fs.Parse(args)
+
+	if *path == "" {
+		log.Fatal("import: path required")
+	}
+	u, err := url.Parse(*host)
+	if err != nil {
+		log.Fatalf("import: invalid url: %s", err)
+	}
+	p, err := influxdb.ParseTimePrecision(*precision)
+	if err != nil {
+		log.Fatalf("import: %s", err)
+	}
+
+	// Open the dump, or read from stdin.
+	var r io.Reader = os.Stdin
+	if *path != "-" {
+		f, err := os.Open(*path)
+		if err != nil {
+			log.Fatalf("import: %s", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	i := NewImporter(*u)
+	i.Username, i.Password = *username, *password
+	i.Precision = p
+	i.BatchSize = *batchSize
+	i.PointsPerSecond = *pps
+
+	// Write failed lines to a file, if specified.
+	if *failed != "" {
+		f, err := os.Create(*failed)
+		if err != nil {
+			log.Fatalf("import: %s", err)
+		}
+		defer f.Close()
+		i.Failed = f
+	}
+
+	if err := i.Import(r); err != nil {
+		log.Fatalf("import: %s", err)
+	}
+}
+
+func printImportUsage() {
+	log.Printf(`usage: import [flags]
+
+import writes a dump of line protocol to a server. DDL statements in the
+"# DDL" section are executed first and points in the "# DML" section are
+written in batches to the database set by "# CONTEXT-DATABASE:".
+
+        -path <path>
+                          Path to the dump file. Use "-" to read stdin.
+
+        -url <url>
+                          URL of the server. Defaults to http://localhost:8086.
+
+        -username <name>
+        -password <password>
+                          Credentials used if authentication is enabled.
+
+        -precision <n|u|ms|s|m|h>
+                          Precision of the timestamps in the dump. Defaults to n.
+
+        -batch-size <n>
+                          Points written per request. Defaults to %d.
+
+        -pps <n>
+                          Maximum points written per second. Defaults to unlimited.
+
+        -failed <path>
+                          File that lines that fail to import are written to.
+`, DefaultImportBatchSize)
+}
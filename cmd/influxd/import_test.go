@@ -0,0 +1,155 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	main "github.com/influxdb/influxdb/cmd/influxd"
+)
+
+// Ensure the importer executes DDL statements and writes points in batches.
+func TestImporter_Import(t *testing.T) {
+	s := NewImportServer()
+	defer s.Close()
+
+	// Reject points with a "bad" tag so the rest of the batch is retried.
+	s.Reject = func(series []map[string]interface{}) []int {
+		var a []int
+		for i, ss := range series {
+			if tags, _ := ss["tags"].(map[string]interface{}); tags["host"] == "bad" {
+				a = append(a, i)
+			}
+		}
+		return a
+	}
+
+	var failed bytes.Buffer
+	i := NewImporter(s)
+	i.BatchSize = 2
+	i.Failed = &failed
+
+	dump := `# DDL
+CREATE DATABASE db0
+CREATE RETENTION POLICY rp0 ON db0 DURATION 1h REPLICATION 1 DEFAULT
+# DML
+# CONTEXT-DATABASE: db0
+# CONTEXT-RETENTION-POLICY: rp0
+cpu,host=a value=1 1000000000
+cpu,host=bad value=2 2000000000
+cpu,host=c value=3 3000000000
+not a point
+mem free=4i 4000000000
+`
+	if err := i.Import(strings.NewReader(dump)); err != nil {
+		t.Fatal(err)
+	}
+
+	if written, n := i.Points(); written != 3 || n != 2 {
+		t.Fatalf("unexpected counts: written=%d, failed=%d", written, n)
+	}
+
+	exp := []string{
+		`POST /db {"name":"db0"}`,
+		`POST /db/db0/retention_policies {"duration":3600000000000,"name":"rp0","replicaN":1}`,
+		`GET /query db=db0&q=ALTER+RETENTION+POLICY+rp0+ON+db0+DEFAULT`,
+		`POST /db/db0/series?rp=rp0&time_precision=n 2`,
+		`POST /db/db0/series?rp=rp0&time_precision=n 1`,
+		`POST /db/db0/series?rp=rp0&time_precision=n 2`,
+	}
+	if !reflect.DeepEqual(s.Requests, exp) {
+		t.Fatalf("unexpected requests:\n%s", strings.Join(s.Requests, "\n"))
+	}
+
+	if failed.String() != "# CONTEXT-DATABASE: db0\n# CONTEXT-RETENTION-POLICY: rp0\ncpu,host=bad value=2 2000000000\nnot a point\n" {
+		t.Fatalf("unexpected failed lines: %s", failed.String())
+	}
+}
+
+// Ensure the importer fails points without a database context.
+func TestImporter_Import_ErrDatabaseRequired(t *testing.T) {
+	s := NewImportServer()
+	defer s.Close()
+
+	var failed bytes.Buffer
+	i := NewImporter(s)
+	i.Failed = &failed
+
+	if err := i.Import(strings.NewReader("cpu value=1\n")); err != nil {
+		t.Fatal(err)
+	} else if written, n := i.Points(); written != 0 || n != 1 {
+		t.Fatalf("unexpected counts: written=%d, failed=%d", written, n)
+	} else if len(s.Requests) != 0 {
+		t.Fatalf("unexpected requests: %v", s.Requests)
+	}
+}
+
+// NewImporter returns an importer for a test server that does not log.
+func NewImporter(s *ImportServer) *main.Importer {
+	u, _ := url.Parse(s.URL)
+	i := main.NewImporter(*u)
+	i.Logger = log.New(ioutil.Discard, "", 0)
+	return i
+}
+
+// ImportServer is a test HTTP server that records the requests it receives.
+type ImportServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	Requests []string
+
+	// Returns the indexes of the series in a write to reject.
+	Reject func(series []map[string]interface{}) []int
+}
+
+func NewImportServer() *ImportServer {
+	s := &ImportServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+func (s *ImportServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+
+	switch {
+	case r.URL.Path == "/query":
+		s.Requests = append(s.Requests, r.Method+" "+r.URL.Path+" "+r.URL.RawQuery)
+		w.Write([]byte(`[{"statement_id":0}]`))
+
+	case strings.HasSuffix(r.URL.Path, "/series"):
+		var series []map[string]interface{}
+		json.Unmarshal(body, &series)
+		s.Requests = append(s.Requests, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery+" "+strconv.Itoa(len(series)))
+
+		if s.Reject != nil {
+			if a := s.Reject(series); len(a) > 0 {
+				var points []map[string]interface{}
+				for _, i := range a {
+					points = append(points, map[string]interface{}{"index": i, "error": "rejected"})
+				}
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{"error": "rejected", "points": points})
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		var m map[string]interface{}
+		json.Unmarshal(body, &m)
+		b, _ := json.Marshal(m)
+		s.Requests = append(s.Requests, r.Method+" "+r.URL.Path+" "+string(b))
+		w.WriteHeader(http.StatusCreated)
+	}
+}
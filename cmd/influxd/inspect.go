@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/influxdb/influxdb"
+)
+
+// execInspect runs the "inspect" command.
+func execInspect(args []string) {
+	// Parse command flags.
+	fs := flag.NewFlagSet("", flag.ExitOnError)
+	var (
+		configPath = fs.String("config", configDefaultPath, "")
+		path       = fs.String("path", "", "")
+	)
+	fs.Usage = printInspectUsage
+	fs.Parse(args)
+
+	// Use the data directory from the config unless it's set explicitly.
+	if *path == "" {
+		*path = parseConfig(*configPath, "").Data.Dir
+	}
+	if fs.NArg() == 0 {
+		printInspectUsage()
+		os.Exit(2)
+	}
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+
+	// Only rebuilding the index writes to the data directory.
+	i, err := influxdb.OpenInspector(*path, cmd == "rebuild-index")
+	if err != nil {
+		log.Fatalf("inspect: %s", err)
+	}
+	defer i.Close()
+
+	switch cmd {
+	case "dump":
+		if len(args) != 1 {
+			log.Fatal("inspect: shard id required")
+		}
+		id, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			log.Fatalf("inspect: invalid shard id: %s", args[0])
+		}
+		if err := i.DumpShard(id, os.Stdout); err != nil {
+			log.Fatalf("inspect: %s", err)
+		}
+
+	case "verify":
+		problems, err := i.Verify()
+		if err != nil {
+			log.Fatalf("inspect: %s", err)
+		}
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			log.Fatalf("inspect: %d problems found", len(problems))
+		}
+		log.Print("inspect: no problems found")
+
+	case "usage":
+		a, err := i.Usage()
+		if err != nil {
+			log.Fatalf("inspect: %s", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "DATABASE\tMEASUREMENT\tSERIES\tPOINTS\tBYTES")
+		for _, u := range a {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\n", u.Database, u.Measurement, u.SeriesN, u.PointN, u.Bytes)
+		}
+		w.Flush()
+
+	case "rebuild-index":
+		changes, err := i.RebuildIndex()
+		if err != nil {
+			log.Fatalf("inspect: %s", err)
+		}
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+		log.Printf("inspect: %d changes made", len(changes))
+
+	default:
+		log.Fatalf(`inspect: unknown command "%s"`, cmd)
+	}
+}
+
+func printInspectUsage() {
+	log.Print(`usage: inspect [flags] <command> [arguments]
+
+inspect reads the data directory of a stopped server to dump, verify and
+repair it. The commands are:
+
+        dump <shard-id>
+                          Write each point in a shard as a JSON object per line.
+
+        verify
+                          Check the files for corruption and that the data in
+                          each shard matches the index. Exits with an error
+                          if problems are found.
+
+        usage
+                          Report the series, points and bytes stored for each
+                          measurement.
+
+        rebuild-index
+                          Add fields found in the shards to the index and
+                          rebuild the dictionary of each shard.
+
+        -config <path>
+                          The path to the configuration file.
+
+        -path <path>
+                          The data directory. Defaults to the one in the
+                          configuration file.
+`)
+}
@@ -56,10 +56,20 @@ func main() {
 
 	// Extract name from args.
 	switch cmd {
+	case "ctl":
+		execCtl(args[1:])
+	case "import":
+		execImport(args[1:])
+	case "inspect":
+		execInspect(args[1:])
 	case "join-cluster":
 		execJoinCluster(args[1:])
+	case "meta":
+		execMeta(args[1:])
 	case "run":
 		execRun(args[1:])
+	case "upgrade":
+		execUpgrade(args[1:])
 	case "":
 		execRun(args)
 	case "version":
@@ -101,8 +111,13 @@ Usage:
 
 The commands are:
 
+    ctl                  list, add and remove the nodes of a cluster
+    import               write a dump of line protocol to a server
+    inspect              dump, verify and repair the data of a stopped node
     join-cluster         create a new node that will join an existing cluster
+    meta                 dump and apply descriptions of databases and users
     run                  run node with existing configuration
+    upgrade              migrate the data of a stopped node to the current formats
     version              displays the InfluxDB version
 
 "run" is the default command.
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/influxdb/influxdb"
+)
+
+// MetaClient dumps and applies descriptions of a server's databases, retention
+// policies and users over the HTTP API.
+type MetaClient struct {
+	URL      url.URL
+	Username string
+	Password string
+}
+
+// Dump returns the description of the server's metadata.
+func (c *MetaClient) Dump() (*influxdb.Metadata, error) {
+	var m influxdb.Metadata
+	if err := c.do("GET", nil, nil, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Apply brings the server in line with a description and returns the changes
+// made. If dryRun is true then the changes are returned without being made.
+// Items that aren't described are deleted if prune is true.
+func (c *MetaClient) Apply(m *influxdb.Metadata, dryRun, prune bool) ([]*influxdb.MetadataChange, error) {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	if dryRun {
+		params.Set("dry_run", "true")
+	}
+	if prune {
+		params.Set("prune", "true")
+	}
+
+	var changes []*influxdb.MetadataChange
+	if err := c.do("POST", params, body, &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// do sends a request to the metadata endpoint and decodes the response into v.
+func (c *MetaClient) do(method string, params url.Values, body []byte, v interface{}) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	if c.Username != "" {
+		params.Set("u", c.Username)
+		params.Set("p", c.Password)
+	}
+
+	u := c.URL
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/meta"
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// execMeta runs the "meta" command.
+func execMeta(args []string) {
+	// Parse command flags.
+	fs := flag.NewFlagSet("", flag.ExitOnError)
+	var (
+		host     = fs.String("url", "http://localhost:8086", "")
+		username = fs.String("username", "", "")
+		password = fs.String("password", "", "")
+		diff     = fs.Bool("diff", false, "")
+		prune    = fs.Bool("prune", false, "")
+	)
+	fs.Usage = printMetaUsage
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		printMetaUsage()
+		os.Exit(2)
+	}
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+
+	u, err := url.Parse(*host)
+	if err != nil {
+		log.Fatalf("meta: invalid url: %s", err)
+	}
+	c := &MetaClient{URL: *u, Username: *username, Password: *password}
+
+	switch cmd {
+	case "dump":
+		m, err := c.Dump()
+		if err != nil {
+			log.Fatalf("meta: %s", err)
+		}
+		b, _ := json.MarshalIndent(m, "", "    ")
+		fmt.Println(string(b))
+
+	case "apply":
+		if len(args) != 1 {
+			log.Fatal("meta: path required")
+		}
+
+		// Read the description from the file, or from stdin.
+		var r io.Reader = os.Stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				log.Fatalf("meta: %s", err)
+			}
+			defer f.Close()
+			r = f
+		}
+		var m influxdb.Metadata
+		if err := json.NewDecoder(r).Decode(&m); err != nil {
+			log.Fatalf("meta: invalid description: %s", err)
+		}
+
+		changes, err := c.Apply(&m, *diff, *prune)
+		if err != nil {
+			log.Fatalf("meta: %s", err)
+		}
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+		if len(changes) == 0 {
+			log.Print("meta: no changes")
+		} else if *diff {
+			log.Printf("meta: %d changes to make", len(changes))
+		} else {
+			log.Printf("meta: %d changes made", len(changes))
+		}
+
+	default:
+		log.Fatalf(`meta: unknown command "%s"`, cmd)
+	}
+}
+
+func printMetaUsage() {
+	log.Print(`usage: meta [flags] <command> [arguments]
+
+meta dumps and applies a JSON description of the databases, retention
+policies and users of a running server, so they can be kept under version
+control. The commands are:
+
+        dump
+                          Write the description of the server's metadata.
+                          Passwords are not included.
+
+        apply <path>
+                          Create and update databases, retention policies
+                          and users to match a description. Use "-" to read
+                          stdin. New users must have a "password".
+
+        -url <url>
+                          URL of the server. Defaults to http://localhost:8086.
+
+        -username <name>
+        -password <password>
+                          Credentials of an admin if authentication is enabled.
+
+        -diff
+                          Print the changes that apply would make without
+                          making them.
+
+        -prune
+                          Also delete databases, retention policies and users
+                          that aren't in the description.
+`)
+}
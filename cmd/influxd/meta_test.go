@@ -0,0 +1,78 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/influxdb/influxdb"
+	main "github.com/influxdb/influxdb/cmd/influxd"
+)
+
+// Ensure the client can dump a server's metadata.
+func TestMetaClient_Dump(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/meta" || r.URL.Query().Get("u") != "susy" {
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL)
+		}
+		w.Write([]byte(`{"databases":[{"name":"foo","defaultRetentionPolicy":"raw","retentionPolicies":[{"name":"raw","duration":"1w"}]}],"users":[{"name":"susy","admin":true}]}`))
+	}))
+	defer s.Close()
+
+	c := NewMetaClient(s)
+	c.Username, c.Password = "susy", "pass"
+	m, err := c.Dump()
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m, &influxdb.Metadata{
+		Databases: []*influxdb.DatabaseMetadata{{Name: "foo", DefaultRetentionPolicy: "raw", RetentionPolicies: []*influxdb.RetentionPolicyMetadata{{Name: "raw", Duration: "1w"}}}},
+		Users:     []*influxdb.UserMetadata{{Name: "susy", Admin: true}},
+	}) {
+		t.Fatalf("unexpected metadata: %#v", m)
+	}
+}
+
+// Ensure the client can apply a description and return the changes.
+func TestMetaClient_Apply(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		if r.Method != "POST" || r.URL.Path != "/meta" {
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL)
+		} else if q := r.URL.Query(); q.Get("dry_run") != "true" || q.Get("prune") != "" {
+			t.Fatalf("unexpected params: %s", r.URL.RawQuery)
+		} else if string(b) != `{"databases":[{"name":"foo"}],"users":null}` {
+			t.Fatalf("unexpected body: %s", b)
+		}
+		json.NewEncoder(w).Encode([]*influxdb.MetadataChange{{Op: "create", Kind: "database", Name: "foo"}})
+	}))
+	defer s.Close()
+
+	changes, err := NewMetaClient(s).Apply(&influxdb.Metadata{Databases: []*influxdb.DatabaseMetadata{{Name: "foo"}}}, true, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(changes) != 1 || changes[0].String() != "+ database foo" {
+		t.Fatalf("unexpected changes: %#v", changes)
+	}
+}
+
+// Ensure the client returns errors from the server.
+func TestMetaClient_Apply_Error(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "password required to create user", http.StatusBadRequest)
+	}))
+	defer s.Close()
+
+	if _, err := NewMetaClient(s).Apply(&influxdb.Metadata{}, false, false); err == nil || err.Error() != "400: password required to create user" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// NewMetaClient returns a client for a test server.
+func NewMetaClient(s *httptest.Server) *main.MetaClient {
+	u, _ := url.Parse(s.URL)
+	return &main.MetaClient{URL: *u}
+}
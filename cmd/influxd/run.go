@@ -4,16 +4,22 @@ import (
 	"flag"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/influxdb/influxdb"
 	"github.com/influxdb/influxdb/graphite"
+	"github.com/influxdb/influxdb/kafka"
 	"github.com/influxdb/influxdb/messaging"
+	"github.com/influxdb/influxdb/statsd"
 )
 
 // execRun runs the "run" command.
@@ -48,6 +54,9 @@ func execRun(args []string) {
 	hasServer := fileExists(config.Data.Dir)
 	initializing := !hasBroker && !hasServer
 
+	// Track HTTP listeners so they can be closed on shutdown.
+	var listeners []net.Listener
+
 	// Open broker if it exists or if we're initializing for the first time.
 	var b *messaging.Broker
 	var h *Handler
@@ -64,12 +73,17 @@ func execRun(args []string) {
 
 		// Start the broker handler.
 		h = &Handler{brokerHandler: messaging.NewHandler(b)}
-		go func() { log.Fatal(http.ListenAndServe(config.BrokerListenAddr(), h)) }()
+		listeners = append(listeners, serveHTTP(listenTCP(config.BrokerListenAddr()), h))
 		log.Printf("Broker running on %s", config.BrokerListenAddr())
 	}
 
 	// Open server if it exists or we're initializing for the first time.
 	var s *influxdb.Server
+	var ss *statsd.Server
+	var sw *influxdb.StreamWriteServer
+	var pg *influxdb.PostgresServer
+	var ki *influxdb.KafkaInput
+	var as *influxdb.AlertService
 	if hasServer || (initializing && (*role == "combined" || *role == "data")) {
 		s = openServer(config.Data.Dir)
 
@@ -83,16 +97,131 @@ func execRun(args []string) {
 
 		// Start the server handler.
 		// If it uses the same port as the broker then simply attach it.
+		s.SetQueryCacheSize(config.HTTPAPI.QueryCacheSize)
+		s.SetResultCache(config.HTTPAPI.ResultCacheSize, time.Duration(config.HTTPAPI.ResultCacheMinAge))
+		s.SetWriteIDCache(config.HTTPAPI.WriteIDCacheSize, time.Duration(config.HTTPAPI.WriteIDTTL))
+		s.SetGroupCommit(config.Data.GroupCommitSize, time.Duration(config.Data.GroupCommitDelay))
+		s.SetWriteBackpressure(config.Data.MaxPendingPoints, config.Data.MaxUnappliedWrites)
+		s.SetQueryConcurrency(config.Data.QueryConcurrency)
+		s.SetQuerySpillDir(config.Data.QuerySpillDir)
+		s.SetCursorLimits(config.Data.MaxCursors, time.Duration(config.Data.CursorTimeout))
+		s.SetMaxOpenShards(config.MaxOpenShards())
+		s.SetJobLimits(config.Jobs.Concurrency, config.Jobs.MaxQueued, int64(config.Jobs.MaxThroughput))
+		s.SetShutdownTimeout(time.Duration(config.Data.ShutdownTimeout))
+		if config.Audit.Enabled {
+			if err := s.SetAuditLog(config.Audit.File); err != nil {
+				log.Fatalf("audit log: %s", err)
+			}
+		}
+		s.SetPointLimits(influxdb.PointLimits{
+			MaxFields:      config.HTTPAPI.Limits.MaxFieldsPerPoint,
+			MaxTags:        config.HTTPAPI.Limits.MaxTagsPerPoint,
+			MaxKeyLength:   config.HTTPAPI.Limits.MaxKeyLength,
+			MaxValueLength: config.HTTPAPI.Limits.MaxValueLength,
+			MaxFuture:      time.Duration(config.HTTPAPI.Limits.MaxFuture),
+			RejectExpired:  config.HTTPAPI.Limits.RejectExpired,
+		})
+		if config.Data.TimestampPrecision != "" {
+			p, err := influxdb.ParseTimePrecision(config.Data.TimestampPrecision)
+			if err != nil {
+				log.Fatalf("timestamp precision: %s", err)
+			}
+			s.SetTimestampPrecision(p)
+		}
 		sh := influxdb.NewHandler(s)
 		sh.AuthenticationEnabled = config.Authentication.Enabled
+		sh.MetricsAuthenticationEnabled = config.HTTPAPI.MetricsAuthentication
+		sh.SessionIdleTimeout = time.Duration(config.HTTPAPI.SessionIdleTimeout)
+		sh.SecureCookies = config.HTTPAPI.SecureCookies
+		sh.Limits = influxdb.UserLimits{
+			QueriesPerMinute: config.HTTPAPI.Limits.QueriesPerMinute,
+			PointsPerSecond:  config.HTTPAPI.Limits.PointsPerSecond,
+			MaxPointsScanned: config.HTTPAPI.Limits.MaxPointsScanned,
+			MaxQueryMemory:   config.HTTPAPI.Limits.MaxQueryMemory,
+			QueryTimeout:     time.Duration(config.HTTPAPI.Limits.QueryTimeout),
+			MaxRowLimit:      config.HTTPAPI.Limits.MaxRowLimit,
+		}
+		sh.Timestamps = timestampPolicy(config.HTTPAPI.ServerTimestamps, config.HTTPAPI.MaxPastSkew, config.HTTPAPI.MaxFutureSkew)
+		access, err := influxdb.ParseAccessList(config.HTTPAPI.AdminAllow, config.HTTPAPI.AdminDeny)
+		if err != nil {
+			log.Fatalf("admin access: %s", err)
+		}
+		sh.AdminAccess = access
 
 		if config.BrokerListenAddr() == config.ApiHTTPListenAddr() {
 			h.serverHandler = sh
 		} else {
-			go func() { log.Fatal(http.ListenAndServe(config.ApiHTTPListenAddr(), sh)) }()
+			listeners = append(listeners, serveHTTP(listenTCP(config.ApiHTTPListenAddr()), sh))
 		}
 		log.Printf("DataNode#%d running on %s", s.ID(), config.ApiHTTPListenAddr())
 
+		// Also serve the API on a unix socket, if configured.
+		if path := config.HTTPAPI.UnixSocket; path != "" {
+			l := listenUnixSocket(path, os.FileMode(config.HTTPAPI.UnixSocketPermissions))
+			listeners = append(listeners, serveHTTP(l, sh))
+			log.Printf("DataNode#%d running on unix socket %s", s.ID(), path)
+		}
+
+		// Write server statistics to the monitoring database, if enabled.
+		if config.Monitoring.Enabled {
+			m := config.Monitoring
+			if err := s.StartSelfMonitoring(m.Database, m.RetentionPolicy, time.Duration(m.WriteInterval)); err != nil {
+				log.Fatalf("self-monitoring: %s", err)
+			}
+		}
+
+		// Release the indexes of disk-indexed databases once they go unused.
+		if err := s.StartIndexEviction(time.Duration(config.Data.IndexIdleTimeout)); err != nil {
+			log.Fatalf("index eviction: %s", err)
+		}
+
+		// Compact cold shards in the background, if enabled.
+		if config.Compaction.Enabled {
+			start, end, err := config.CompactionWindow()
+			if err != nil {
+				log.Fatalf("compaction: %s", err)
+			}
+			if err := s.StartCompaction(influxdb.CompactionConfig{
+				CheckInterval: time.Duration(config.Compaction.CheckInterval),
+				Concurrency:   config.Compaction.Concurrency,
+				MaxThroughput: int64(config.Compaction.MaxThroughput),
+				WindowStart:   start,
+				WindowEnd:     end,
+			}); err != nil {
+				log.Fatalf("compaction: %s", err)
+			}
+		}
+
+		// Verify and repair stored blocks in the background, if enabled.
+		if config.Scrub.Enabled {
+			if err := s.StartScrubbing(influxdb.ScrubConfig{
+				Interval: time.Duration(config.Scrub.Interval),
+				Username: config.Scrub.Username,
+				Password: config.Scrub.Password,
+			}); err != nil {
+				log.Fatalf("scrub: %s", err)
+			}
+		}
+
+		// Run the downsampling rules of each retention policy, if enabled.
+		if config.Downsampling.Enabled {
+			if err := s.StartDownsampling(time.Duration(config.Downsampling.CheckInterval)); err != nil {
+				log.Fatalf("downsampling: %s", err)
+			}
+		}
+
+		// Evaluate alert rules, if enabled.
+		if c := config.Alerting; c.Enabled {
+			as = influxdb.NewAlertService(s)
+			as.CheckInterval = time.Duration(c.CheckInterval)
+			as.SMTPAddr = c.SMTPAddr
+			as.SMTPFrom = c.SMTPFrom
+			as.PagerDutyURL = c.PagerDutyURL
+			if err := as.Open(); err != nil {
+				log.Fatalf("alerting: %s", err)
+			}
+		}
+
 		// Spin up any Graphite servers
 		for _, c := range config.Graphites {
 			if !c.Enabled {
@@ -103,17 +232,30 @@ func execRun(args []string) {
 			parser := graphite.NewParser()
 			parser.Separator = c.NameSeparatorString()
 			parser.LastEnabled = c.LastEnabled()
+			if c.Precision != "" {
+				p, err := influxdb.ParseTimePrecision(c.Precision)
+				if err != nil {
+					log.Fatalf("graphite: %s", err)
+				}
+				parser.Precision = p.Duration()
+			}
+
+			// Apply the timestamp policy before series are written.
+			var w graphite.SeriesWriter = s
+			if policy := timestampPolicy(c.ServerTimestamps, c.MaxPastSkew, c.MaxFutureSkew); policy != (influxdb.TimestampPolicy{}) {
+				w = &timestampWriter{server: s, policy: policy}
+			}
 
 			// Start the relevant server.
 			if strings.ToLower(c.Protocol) == "tcp" {
-				g := graphite.NewTCPServer(parser, s)
+				g := graphite.NewTCPServer(parser, w)
 				g.Database = c.Database
 				err := g.ListenAndServe(c.ConnectionString(config.BindAddress))
 				if err != nil {
 					log.Println("failed to start TCP Graphite Server", err.Error())
 				}
 			} else if strings.ToLower(c.Protocol) == "udp" {
-				g := graphite.NewUDPServer(parser, s)
+				g := graphite.NewUDPServer(parser, w)
 				g.Database = c.Database
 				err := g.ListenAndServe(c.ConnectionString(config.BindAddress))
 				if err != nil {
@@ -123,10 +265,193 @@ func execRun(args []string) {
 				log.Fatalf("unrecognized Graphite Server prototcol", c.Protocol)
 			}
 		}
+
+		// Start the StatsD listener, if enabled.
+		if c := config.Statsd; c.Enabled {
+			addr := c.Addr
+			if addr == "" {
+				addr = config.BindAddress
+			}
+
+			ss = statsd.NewServer(s)
+			ss.Database = c.Database
+			ss.RetentionPolicy = c.RetentionPolicy
+			ss.FlushInterval = time.Duration(c.FlushInterval)
+			ss.Percentiles = c.Percentiles
+			if err := ss.ListenAndServe(net.JoinHostPort(addr, strconv.Itoa(c.Port))); err != nil {
+				log.Fatalf("statsd: %s", err)
+			}
+			log.Printf("StatsD listening on %s", ss.Addr())
+		}
+
+		// Start the streaming write listener, if enabled.
+		if c := config.Stream; c.Enabled {
+			addr := c.Addr
+			if addr == "" {
+				addr = config.BindAddress
+			}
+
+			sw = influxdb.NewStreamWriteServer(s)
+			sw.RequireAuthentication = config.Authentication.Enabled
+			sw.BatchSize = c.BatchSize
+			sw.AckInterval = time.Duration(c.AckInterval)
+			sw.Timestamps = timestampPolicy(c.ServerTimestamps, c.MaxPastSkew, c.MaxFutureSkew)
+			if err := sw.ListenAndServe(net.JoinHostPort(addr, strconv.Itoa(c.Port))); err != nil {
+				log.Fatalf("stream: %s", err)
+			}
+			log.Printf("Streaming writes listening on %s", sw.Addr())
+		}
+
+		// Start the Postgres gateway, if enabled.
+		if c := config.Postgres; c.Enabled {
+			addr := c.Addr
+			if addr == "" {
+				addr = config.BindAddress
+			}
+
+			pg = influxdb.NewPostgresServer(s)
+			pg.RequireAuthentication = config.Authentication.Enabled
+			if err := pg.ListenAndServe(net.JoinHostPort(addr, strconv.Itoa(c.Port))); err != nil {
+				log.Fatalf("postgres: %s", err)
+			}
+			log.Printf("Postgres gateway listening on %s", pg.Addr())
+		}
+
+		// Start consuming from Kafka, if enabled.
+		if c := config.Kafka; c.Enabled {
+			ki = influxdb.NewKafkaInput(s, kafka.NewClient(c.Brokers))
+			ki.Group = c.Group
+			ki.BatchSize = c.BatchSize
+			ki.BatchTimeout = time.Duration(c.BatchTimeout)
+			switch c.StartOffset {
+			case "oldest":
+				ki.StartOffset = kafka.OffsetOldest
+			case "newest":
+				ki.StartOffset = kafka.OffsetNewest
+			default:
+				log.Fatalf("kafka: invalid start offset: %s", c.StartOffset)
+			}
+			for _, t := range c.Topics {
+				topic := &influxdb.KafkaTopic{Name: t.Name, Database: t.Database, RetentionPolicy: t.RetentionPolicy, Format: t.Format}
+				topic.Timestamps = timestampPolicy(t.ServerTimestamps, t.MaxPastSkew, t.MaxFutureSkew)
+				if t.Precision != "" {
+					p, err := influxdb.ParseTimePrecision(t.Precision)
+					if err != nil {
+						log.Fatalf("kafka: %s", err)
+					}
+					topic.Precision = p
+				}
+				ki.Topics = append(ki.Topics, topic)
+			}
+			if err := ki.Open(); err != nil {
+				log.Fatalf("kafka: %s", err)
+			}
+			log.Printf("Kafka input consuming %d topics from %s", len(ki.Topics), strings.Join(c.Brokers, ","))
+		}
+
+		// Start the UDP input, if enabled.
+		if u := config.InputPlugins.UDPInput; u.Enabled {
+			us := influxdb.NewUDPServer(s)
+			us.Database = u.Database
+			us.Timestamps = timestampPolicy(u.ServerTimestamps, u.MaxPastSkew, u.MaxFutureSkew)
+			if u.Precision != "" {
+				p, err := influxdb.ParseTimePrecision(u.Precision)
+				if err != nil {
+					log.Fatalf("udp: %s", err)
+				}
+				us.Precision = p
+			}
+
+			addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(config.BindAddress, strconv.Itoa(int(u.Port))))
+			if err != nil {
+				log.Fatalf("udp: %s", err)
+			}
+			us.Addr = addr
+			if err := us.ListenAndServe(); err != nil {
+				log.Fatalf("udp: %s", err)
+			}
+			log.Printf("UDP input listening on %s", us.LocalAddr())
+		}
 	}
 
-	// Wait indefinitely.
-	<-(chan struct{})(nil)
+	// Wait for a termination signal.
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	log.Printf("received %s, shutting down", <-c)
+
+	// Stop accepting connections and then wait for running queries and
+	// writes to finish before closing the server and broker.
+	for _, l := range listeners {
+		_ = l.Close()
+	}
+	if ki != nil {
+		_ = ki.Close()
+	}
+	if as != nil {
+		_ = as.Close()
+	}
+	if ss != nil {
+		_ = ss.Close()
+	}
+	if sw != nil {
+		_ = sw.Close()
+	}
+	if pg != nil {
+		_ = pg.Close()
+	}
+	if s != nil {
+		if err := s.Close(); err != nil {
+			log.Printf("close server: %s", err)
+		}
+	}
+	if b != nil {
+		if err := b.Close(); err != nil {
+			log.Printf("close broker: %s", err)
+		}
+	}
+}
+
+// listenTCP opens a TCP listener on addr.
+// timestampPolicy returns the timestamp policy configured for an input.
+func timestampPolicy(serverTime bool, maxPastSkew, maxFutureSkew Duration) influxdb.TimestampPolicy {
+	return influxdb.TimestampPolicy{
+		ServerTime:    serverTime,
+		MaxPastSkew:   time.Duration(maxPastSkew),
+		MaxFutureSkew: time.Duration(maxFutureSkew),
+	}
+}
+
+// timestampWriter applies a timestamp policy to series before writing them
+// to the server. It's used by inputs that write series one at a time.
+type timestampWriter struct {
+	server *influxdb.Server
+	policy influxdb.TimestampPolicy
+}
+
+// WriteSeries writes a series with its timestamp assigned by the policy.
+func (w *timestampWriter) WriteSeries(database, retentionPolicy, name string, tags map[string]string, timestamp time.Time, values map[string]interface{}) error {
+	p := &influxdb.Point{Name: name, Tags: tags, Timestamp: timestamp, Values: values}
+	w.server.CorrectTimestamps(database, w.policy, []*influxdb.Point{p})
+	return w.server.WriteSeries(database, retentionPolicy, name, tags, p.Timestamp, values)
+}
+
+func listenTCP(addr string) net.Listener {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return l
+}
+
+// serveHTTP serves HTTP requests on l in the background and returns l.
+// Serving stops without an error once the listener is closed.
+func serveHTTP(l net.Listener, h http.Handler) net.Listener {
+	go func() {
+		if err := http.Serve(l, h); err != nil && !strings.Contains(err.Error(), "use of closed network connection") {
+			log.Fatal(err)
+		}
+	}()
+	return l
 }
 
 // write the current process id to a file specified by path.
@@ -226,6 +551,27 @@ func parseSeedServers(s string) (a []*url.URL) {
 	return
 }
 
+// creates a unix socket listener at a given path with the given permissions.
+// Any stale socket left behind by a previous process is removed first.
+func listenUnixSocket(path string, mode os.FileMode) net.Listener {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			log.Fatalf("unix socket: %s exists and is not a socket", path)
+		} else if err := os.Remove(path); err != nil {
+			log.Fatalf("unix socket: %s", err)
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		log.Fatalf("unix socket: %s", err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		log.Fatalf("unix socket: %s", err)
+	}
+	return l
+}
+
 // returns true if the file exists.
 func fileExists(path string) bool {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
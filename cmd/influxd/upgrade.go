@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/influxdb/influxdb"
+)
+
+// execUpgrade runs the "upgrade" command.
+func execUpgrade(args []string) {
+	// Parse command flags.
+	fs := flag.NewFlagSet("", flag.ExitOnError)
+	var (
+		configPath = fs.String("config", configDefaultPath, "")
+		path       = fs.String("path", "", "")
+		dryRun     = fs.Bool("dry-run", false, "")
+	)
+	fs.Usage = printUpgradeUsage
+	fs.Parse(args)
+
+	// Use the data directory from the config unless it's set explicitly.
+	if *path == "" {
+		*path = parseConfig(*configPath, "").Data.Dir
+	}
+
+	i, err := influxdb.OpenInspector(*path, true)
+	if err != nil {
+		log.Fatalf("upgrade: %s", err)
+	}
+	defer i.Close()
+
+	changes, err := i.Upgrade(*dryRun)
+	if err != nil {
+		log.Fatalf("upgrade: %s", err)
+	}
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+	if *dryRun {
+		log.Printf("upgrade: %d changes to make", len(changes))
+		return
+	}
+	log.Printf("upgrade: %d changes made, meta format %d, shard format %d", len(changes), influxdb.MetaFormat, influxdb.ShardFormat)
+}
+
+func printUpgradeUsage() {
+	log.Print(`usage: upgrade [flags]
+
+upgrade migrates the metastore and shards in the data directory of a stopped
+server to the on-disk formats of this version. A server reads data in older
+formats as it is, so only upgrade once every node in the cluster runs a
+version that can read the new formats. Nodes on older versions refuse to open
+data in formats they can't read.
+
+        -config <path>
+                          The path to the configuration file.
+
+        -path <path>
+                          The data directory. Defaults to the one in the
+                          configuration file.
+
+        -dry-run
+                          Report the changes without making them.
+`)
+}
@@ -0,0 +1,361 @@
+package influxdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// errCompactionAborted is returned when a compaction is stopped because the
+// server is closing or the shard changed while it was being copied.
+var errCompactionAborted = errors.New("compaction aborted")
+
+// CompactionConfig represents the settings used to schedule shard compactions.
+//
+// Cold shards are compacted by copying them into a new store with full pages
+// and replacing the original. Compactions only start within the window, which
+// is given as offsets from midnight in local time. A window that ends before
+// it starts wraps past midnight and an empty window allows any time.
+type CompactionConfig struct {
+	CheckInterval time.Duration // time between planning compactions
+	Concurrency   int           // shards compacted at once
+	MaxThroughput int64         // bytes per second copied by all compactions, zero is unlimited
+
+	WindowStart time.Duration
+	WindowEnd   time.Duration
+}
+
+// inWindow returns true if compactions may start at t.
+func (c *CompactionConfig) inWindow(t time.Time) bool {
+	if c.WindowStart == c.WindowEnd {
+		return true
+	}
+
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	if c.WindowStart < c.WindowEnd {
+		return offset >= c.WindowStart && offset < c.WindowEnd
+	}
+	return offset >= c.WindowStart || offset < c.WindowEnd
+}
+
+// StartCompaction starts compacting cold shards in the background until the
+// server is closed.
+func (s *Server) StartCompaction(c CompactionConfig) error {
+	if c.CheckInterval <= 0 {
+		return ErrInvalidCompactionInterval
+	}
+	if c.Concurrency < 1 {
+		c.Concurrency = 1
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing == nil {
+		return ErrServerClosed
+	}
+
+	s.wg.Add(1)
+	go s.compactor(c, s.closing)
+	return nil
+}
+
+// compactor purges pending deletions and compacts planned shards every check
+// interval until closing is closed.
+func (s *Server) compactor(c CompactionConfig, closing <-chan struct{}) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(c.CheckInterval)
+	defer ticker.Stop()
+
+	t := newThrottle(c.MaxThroughput)
+	t.parent = s.jobs.throttle
+	for {
+		select {
+		case <-closing:
+			return
+		case now := <-ticker.C:
+			if c.inWindow(now) {
+				s.runJob("purge deletions", jobPriorityNormal, closing, func() error {
+					s.purgeDeletions()
+					return nil
+				})
+				s.compactShards(s.planCompactions(now), &c, t, closing)
+			}
+		}
+	}
+}
+
+// planCompactions returns the shards that have ended before now and have
+// been written to since they were last compacted. Shards that aren't open
+// are skipped so that planning doesn't open every shard; they're planned
+// once they're next used.
+func (s *Server) planCompactions(now time.Time) []*Shard {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var a []*Shard
+	for _, db := range s.databases {
+		for _, sh := range db.shards {
+			sh.mu.Lock()
+			if sh.store != nil && !sh.compacted && sh.EndTime.Before(now) {
+				a = append(a, sh)
+			}
+			sh.mu.Unlock()
+		}
+	}
+	sort.Sort(Shards(a))
+	return a
+}
+
+// compactShards compacts a list of shards using up to c.Concurrency workers.
+// Each shard is compacted as a background job. Shards are not started once
+// the window has closed.
+func (s *Server) compactShards(shards []*Shard, c *CompactionConfig, t *throttle, closing <-chan struct{}) {
+	ch := make(chan *Shard, len(shards))
+	for _, sh := range shards {
+		ch <- sh
+	}
+	close(ch)
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sh := range ch {
+				if !c.inWindow(time.Now()) {
+					return
+				}
+				sh := sh
+				s.runJob(fmt.Sprintf("compact shard %d", sh.ID), jobPriorityLow, closing, func() error {
+					if err := s.compactShard(sh, t, closing); err != errCompactionAborted {
+						return err
+					}
+					return nil
+				})
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// compactShard copies a shard into a new store and replaces the original.
+// The new store is discarded if the shard is written to or removed before
+// it can be swapped in.
+func (s *Server) compactShard(sh *Shard, t *throttle, closing <-chan struct{}) error {
+	s.mu.RLock()
+	src, err := sh.acquire()
+	path := s.shardPath(sh.ID)
+	s.mu.RUnlock()
+	if err == ErrShardNotFound {
+		return errCompactionAborted
+	} else if err != nil {
+		return err
+	}
+	defer sh.release(src)
+
+	sh.mu.Lock()
+	writeN := sh.writeN
+	sh.mu.Unlock()
+	if path == "" {
+		return errCompactionAborted
+	}
+
+	// Copy the shard into a temporary store.
+	tmp := path + ".compact"
+	_ = os.Remove(tmp)
+	srcSize, dstSize, err := copyShardStore(src.DB, tmp, t, closing)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	// Block queries and writes while the store is replaced.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	// Discard the copy if the shard was removed or written to.
+	if s.databasesByShard[sh.ID] == nil || sh.store != src || sh.writeN != writeN {
+		_ = os.Remove(tmp)
+		return errCompactionAborted
+	}
+
+	// Replace the shard's store with the copy. The original store is closed
+	// once the queries reading it have finished.
+	if err := sh.closeStore(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		_ = sh.open(path)
+		return err
+	}
+	if err := sh.open(path); err != nil {
+		return err
+	}
+
+	s.Logger.Printf("compacted shard %d: %d -> %d bytes", sh.ID, srcSize, dstSize)
+	return nil
+}
+
+// copyShardStore copies every bucket in src into a new store at path with
+// full pages and marks it as compacted. Returns the size of both stores.
+func copyShardStore(src *bolt.DB, path string, t *throttle, closing <-chan struct{}) (srcSize, dstSize int64, err error) {
+	dst, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if e := dst.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	if err := src.View(func(stx *bolt.Tx) error {
+		srcSize = stx.Size()
+		return stx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if err := dst.Update(func(dtx *bolt.Tx) error {
+				_, err := dtx.CreateBucketIfNotExists(name)
+				return err
+			}); err != nil {
+				return err
+			}
+
+			// Copy each series in its own transaction so the whole
+			// shard is not held in memory while it is being copied.
+			c := b.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				if err := dst.Update(func(dtx *bolt.Tx) error {
+					return copyBucketKey(b, dtx.Bucket(name), k, v, t, closing)
+				}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}); err != nil {
+		return 0, 0, err
+	}
+
+	if err := dst.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("meta"))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("compacted"), []byte{1}); err != nil {
+			return err
+		}
+		dstSize = tx.Size()
+		return nil
+	}); err != nil {
+		return 0, 0, err
+	}
+	return srcSize, dstSize, nil
+}
+
+// copyBucketKey copies a key from src into dst. Nested buckets are copied
+// recursively. Returns errCompactionAborted if closing is closed.
+func copyBucketKey(src, dst *bolt.Bucket, k, v []byte, t *throttle, closing <-chan struct{}) error {
+	dst.FillPercent = 1.0
+
+	// Copy a value.
+	if v != nil {
+		if err := t.wait(len(k)+len(v), closing); err != nil {
+			return err
+		}
+		return dst.Put(k, v)
+	}
+
+	// Copy a nested bucket.
+	sb := src.Bucket(k)
+	db, err := dst.CreateBucketIfNotExists(k)
+	if err != nil {
+		return err
+	}
+	c := sb.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if err := copyBucketKey(sb, db, k, v, t, closing); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// throttle limits the rate that bytes are copied across all compactions.
+// Bytes are also counted against the parent throttle, if set.
+type throttle struct {
+	mu     sync.Mutex
+	rate   int64     // bytes per second, zero is unlimited
+	next   time.Time // time that the next bytes may be copied
+	parent *throttle
+}
+
+// newThrottle returns a throttle that allows rate bytes per second.
+func newThrottle(rate int64) *throttle {
+	return &throttle{rate: rate}
+}
+
+// setRate changes the rate that bytes may be copied at.
+func (t *throttle) setRate(rate int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rate = rate
+}
+
+// wait blocks until n more bytes can be copied without exceeding the rate
+// of the throttle or its parent. Returns errCompactionAborted if closing is
+// closed while waiting.
+func (t *throttle) wait(n int, closing <-chan struct{}) error {
+	if err := t.waitRate(n, closing); err != nil {
+		return err
+	} else if t.parent != nil {
+		return t.parent.wait(n, closing)
+	}
+	return nil
+}
+
+// waitRate blocks until n more bytes can be copied at the throttle's rate.
+func (t *throttle) waitRate(n int, closing <-chan struct{}) error {
+	select {
+	case <-closing:
+		return errCompactionAborted
+	default:
+	}
+
+	// Reserve the time needed to copy the bytes at the limited rate.
+	t.mu.Lock()
+	if t.rate <= 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(time.Duration(int64(n) * int64(time.Second) / t.rate))
+	d := t.next.Sub(now)
+	t.mu.Unlock()
+
+	// Only sleep once the delay is noticeable.
+	if d < 10*time.Millisecond {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-closing:
+		return errCompactionAborted
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,53 @@
+package influxdb
+
+import (
+	"testing"
+	"time"
+)
+
+// Ensure compactions only start within the configured window.
+func TestCompactionConfig_inWindow(t *testing.T) {
+	for i, tt := range []struct {
+		start, end time.Duration
+		t          string
+		exp        bool
+	}{
+		{start: 0, end: 0, t: "12:00", exp: true},
+		{start: 1 * time.Hour, end: 5 * time.Hour, t: "00:59", exp: false},
+		{start: 1 * time.Hour, end: 5 * time.Hour, t: "01:00", exp: true},
+		{start: 1 * time.Hour, end: 5 * time.Hour, t: "05:00", exp: false},
+		{start: 22 * time.Hour, end: 4 * time.Hour, t: "23:30", exp: true},
+		{start: 22 * time.Hour, end: 4 * time.Hour, t: "03:00", exp: true},
+		{start: 22 * time.Hour, end: 4 * time.Hour, t: "12:00", exp: false},
+	} {
+		now, _ := time.Parse("15:04", tt.t)
+		c := &CompactionConfig{WindowStart: tt.start, WindowEnd: tt.end}
+		if act := c.inWindow(now); act != tt.exp {
+			t.Errorf("%d. %s: unexpected result: %v", i, tt.t, act)
+		}
+	}
+}
+
+// Ensure the throttle delays copies that exceed its rate.
+func TestThrottle_Wait(t *testing.T) {
+	th := newThrottle(1000)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := th.wait(10, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := th.wait(100, nil); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("unexpected duration: %s", d)
+	}
+
+	// A closed channel aborts the wait.
+	closing := make(chan struct{})
+	close(closing)
+	if err := th.wait(10, closing); err != errCompactionAborted {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
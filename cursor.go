@@ -0,0 +1,213 @@
+package influxdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+const (
+	// DefaultMaxCursors is the default number of cursors open at once.
+	DefaultMaxCursors = 100
+
+	// DefaultCursorTimeout is the default time a cursor stays open without
+	// a page being read from it.
+	DefaultCursorTimeout = time.Minute
+)
+
+// queryCursor represents a select statement whose results are read a page at
+// a time. The statement keeps executing between pages and holds its memory
+// and running query until the last page is read or the cursor expires.
+type queryCursor struct {
+	id       string
+	user     string // name of the user that opened the cursor
+	pageSize int    // values returned by each page unless set when it's read
+
+	ch          <-chan *influxql.Row
+	e           *influxql.Executor
+	q           *dbq
+	rq          *runningQuery
+	cancel      context.CancelFunc
+	maxRowLimit int
+	resolution  *Resolution
+
+	row   *influxql.Row // row with values not yet returned
+	timer *time.Timer   // closes the cursor once it expires
+}
+
+// next reads the next row from the executor, truncated to the row limit.
+// Returns nil once the executor is done.
+func (c *queryCursor) next() *influxql.Row {
+	row, ok := <-c.ch
+	if !ok {
+		return nil
+	}
+	if c.maxRowLimit > 0 && len(row.Values) > c.maxRowLimit {
+		row.Values, row.Partial = row.Values[:c.maxRowLimit:c.maxRowLimit], true
+	}
+	c.rq.alloc(rowSize(row))
+	return row
+}
+
+// stop cancels the cursor's statement and waits for it to finish.
+func (c *queryCursor) stop() {
+	c.cancel()
+	for _ = range c.ch {
+	}
+}
+
+// page reads up to n values from the cursor. Rows are split across pages if
+// needed. Returns true if values remain to be read.
+func (c *queryCursor) page(n int) ([]*influxql.Row, bool, error) {
+	var rows []*influxql.Row
+	for n > 0 {
+		if c.row == nil {
+			if c.row = c.next(); c.row == nil {
+				break
+			}
+		}
+
+		if len(c.row.Values) <= n {
+			rows = append(rows, c.row)
+			n -= len(c.row.Values)
+			c.row = nil
+		} else {
+			head := *c.row
+			head.Values = c.row.Values[:n:n]
+			rows = append(rows, &head)
+			c.row.Values = c.row.Values[n:]
+			n = 0
+		}
+	}
+
+	// Read ahead so the last page is never empty.
+	if c.row == nil {
+		c.row = c.next()
+	}
+
+	if err := c.e.Err(); err != nil {
+		return nil, false, err
+	} else if err := c.q.Err(); err != nil {
+		return nil, false, err
+	} else if c.rq.limitExceeded() {
+		return nil, false, ErrQueryMemoryExceeded
+	}
+	return rows, c.row != nil, nil
+}
+
+// SetCursorLimits sets the number of cursors that can be open at once and
+// the time a cursor stays open without a page being read from it.
+func (s *Server) SetCursorLimits(max int, timeout time.Duration) {
+	s.cursorsMu.Lock()
+	defer s.cursorsMu.Unlock()
+	s.maxCursors = max
+	s.cursorTimeout = timeout
+}
+
+// openCursor reads the first page of a select statement's results. If more
+// values remain then the cursor is kept open and the result has a token for
+// the next page. The cursor owns the running query while it's open;
+// otherwise the caller finishes it.
+func (s *Server) openCursor(c *queryCursor) *Result {
+	rows, more, err := c.page(c.pageSize)
+	if err != nil || !more {
+		c.stop()
+		return &Result{Rows: rows, Resolution: c.resolution, Err: err}
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		c.stop()
+		return &Result{Err: err}
+	}
+	c.id = id
+
+	s.cursorsMu.Lock()
+	if len(s.cursors) >= s.maxCursors {
+		s.cursorsMu.Unlock()
+		c.stop()
+		return &Result{Err: ErrTooManyCursors}
+	}
+	s.addCursor(c)
+	s.cursorsMu.Unlock()
+
+	return &Result{Rows: rows, Resolution: c.resolution, Next: c.id}
+}
+
+// addCursor registers a cursor and starts its expiry timer.
+// Must be called with cursorsMu held.
+func (s *Server) addCursor(c *queryCursor) {
+	s.cursors[c.id] = c
+	c.timer = time.AfterFunc(s.cursorTimeout, func() {
+		s.cursorsMu.Lock()
+		if s.cursors[c.id] != c {
+			s.cursorsMu.Unlock()
+			return
+		}
+		delete(s.cursors, c.id)
+		s.cursorsMu.Unlock()
+		s.closeCursor(c)
+	})
+}
+
+// NextPage reads the next page of up to pageSize values from a cursor
+// returned by a select statement executed with a page size. A pageSize of
+// zero uses the statement's page size. The result has a token for the
+// following page until the last page is read. Returns ErrCursorNotFound if
+// the cursor has expired or was opened by another user.
+func (s *Server) NextPage(token string, user *User, pageSize int) *Result {
+	if err := s.begin(); err != nil {
+		return &Result{Err: err}
+	}
+	defer s.end()
+
+	var name string
+	if user != nil {
+		name = user.Name
+	}
+
+	// Take the cursor so that it can't expire while the page is read.
+	s.cursorsMu.Lock()
+	c := s.cursors[token]
+	if c == nil || c.user != name {
+		s.cursorsMu.Unlock()
+		return &Result{Err: ErrCursorNotFound}
+	}
+	delete(s.cursors, token)
+	c.timer.Stop()
+	s.cursorsMu.Unlock()
+
+	if pageSize <= 0 {
+		pageSize = c.pageSize
+	}
+	rows, more, err := c.page(pageSize)
+	if err != nil || !more {
+		s.closeCursor(c)
+		return &Result{Rows: rows, Resolution: c.resolution, Err: err}
+	}
+
+	s.cursorsMu.Lock()
+	s.addCursor(c)
+	s.cursorsMu.Unlock()
+	return &Result{Rows: rows, Resolution: c.resolution, Next: c.id}
+}
+
+// closeCursor stops a cursor's statement and releases its running query.
+func (s *Server) closeCursor(c *queryCursor) {
+	c.stop()
+	s.finishQuery(c.rq)
+}
+
+// closeCursors closes every open cursor.
+func (s *Server) closeCursors() {
+	s.cursorsMu.Lock()
+	cursors := s.cursors
+	s.cursors = make(map[string]*queryCursor)
+	s.cursorsMu.Unlock()
+
+	for _, c := range cursors {
+		c.timer.Stop()
+		s.closeCursor(c)
+	}
+}
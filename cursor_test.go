@@ -0,0 +1,100 @@
+package influxdb_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb"
+)
+
+// Ensure select statements can be read a page at a time.
+func TestServer_ExecuteQuery_Paged(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	for i, host := range []string{"serverA", "serverA", "serverA", "serverB", "serverB"} {
+		s.WriteSeries("foo", "raw", "cpu", map[string]string{"host": host}, mustParseTime("2000-01-01T00:00:00Z").Add(time.Duration(i)*time.Second), map[string]interface{}{"value": float64(i)})
+	}
+	s.Sync(c.index)
+
+	// Each page returns up to two values and rows are split across pages.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu GROUP BY host`), "foo", nil, influxdb.QueryOptions{PageSize: 2})
+	r := results[0]
+	var pages []string
+	for {
+		if r.Err != nil {
+			t.Fatalf("unexpected error: %s", r.Err)
+		}
+		pages = append(pages, mustMarshalJSON(r.Rows))
+		if r.Next == "" {
+			break
+		}
+		r = s.NextPage(r.Next, nil, 0)
+	}
+	if len(pages) != 3 ||
+		pages[0] != `[{"name":"cpu","tags":{"host":"serverA"},"columns":["time","value"],"values":[[946684800000000,0],[946684801000000,1]]}]` ||
+		pages[1] != `[{"name":"cpu","tags":{"host":"serverA"},"columns":["time","value"],"values":[[946684802000000,2]]},{"name":"cpu","tags":{"host":"serverB"},"columns":["time","value"],"values":[[946684803000000,3]]}]` ||
+		pages[2] != `[{"name":"cpu","tags":{"host":"serverB"},"columns":["time","value"],"values":[[946684804000000,4]]}]` {
+		t.Fatalf("unexpected pages: %v", pages)
+	}
+
+	// Statements that fit in a page don't open a cursor.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu WHERE host = 'serverB'`), "foo", nil, influxdb.QueryOptions{PageSize: 2})
+	if results[0].Err != nil || results[0].Next != "" {
+		t.Fatalf("unexpected result: %s", mustMarshalJSON(results[0]))
+	}
+
+	// Open cursors are listed as running queries until they're read to the end.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu`), "foo", nil, influxdb.QueryOptions{PageSize: 1})
+	next := results[0].Next
+	if next == "" {
+		t.Fatal("expected cursor")
+	} else if rows := s.ExecuteQuery(MustParseQuery(`SHOW QUERIES`), "foo", nil, influxdb.QueryOptions{}); len(rows[0].Rows[0].Values) != 2 {
+		t.Fatalf("unexpected running queries: %s", mustMarshalJSON(rows))
+	}
+
+	// Cursors can only be read by the user that opened them.
+	if r := s.NextPage(next, &influxdb.User{Name: "susy"}, 0); r.Err != influxdb.ErrCursorNotFound {
+		t.Fatalf("unexpected error: %v", r.Err)
+	} else if r := s.NextPage(next, nil, 10); r.Err != nil || r.Next != "" || len(r.Rows[0].Values) != 4 {
+		t.Fatalf("unexpected result: %s", mustMarshalJSON(r))
+	} else if r := s.NextPage(next, nil, 10); r.Err != influxdb.ErrCursorNotFound {
+		t.Fatalf("unexpected error: %v", r.Err)
+	}
+}
+
+// Ensure cursors expire and the number of open cursors is limited.
+func TestServer_ExecuteQuery_PagedLimits(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.WriteSeries("foo", "raw", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 1.0})
+	s.WriteSeries("foo", "raw", "cpu", nil, mustParseTime("2000-01-01T00:00:01Z"), map[string]interface{}{"value": 2.0})
+	s.Sync(c.index)
+	s.SetCursorLimits(1, 50*time.Millisecond)
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu`), "foo", nil, influxdb.QueryOptions{PageSize: 1})
+	next := results[0].Next
+	if next == "" {
+		t.Fatal("expected cursor")
+	}
+	results = s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu`), "foo", nil, influxdb.QueryOptions{PageSize: 1})
+	if results[0].Err != influxdb.ErrTooManyCursors {
+		t.Fatalf("unexpected error: %v", results[0].Err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if r := s.NextPage(next, nil, 0); r.Err != influxdb.ErrCursorNotFound {
+		t.Fatalf("unexpected error: %v", r.Err)
+	}
+	results = s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu`), "foo", nil, influxdb.QueryOptions{PageSize: 1})
+	if results[0].Err != nil || results[0].Next == "" {
+		t.Fatalf("unexpected result: %s", mustMarshalJSON(results[0]))
+	}
+}
@@ -2,10 +2,14 @@ package influxdb
 
 import (
 	"encoding/json"
+	"hash/fnv"
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/influxdb/influxdb/influxql"
 )
 
 // database is a collection of retention policies and shards. It also has methods
@@ -20,10 +24,38 @@ type database struct {
 
 	defaultRetentionPolicy string
 
-	// in memory indexing structures
-	measurements map[string]*Measurement // measurement name to object and index
-	series       map[uint32]*Series      // map series id to the Series object
-	names        []string                // sorted list of the measurement names
+	readOnly bool // rejects writes
+	disabled bool // rejects writes and queries
+
+	// declared measurement schemas, enforced on writes if strictSchema is set
+	strictSchema bool
+	schemas      map[string]*MeasurementSchema
+
+	// limits on the values of tag keys, by measurement and tag key
+	tagGuards map[string]map[string]*TagGuard
+
+	// alerting rules by name
+	alertRules map[string]*AlertRule
+
+	// rules that direct writes to other databases or retention policies, by name
+	routingRules map[string]*RoutingRule
+
+	// deletions not yet purged from the shards, by id
+	deletions map[uint64]*Deletion
+
+	// quotas, zero is unlimited
+	maxSeries    int
+	maxDiskBytes int64
+	maxRetention time.Duration
+
+	// kind of storage for the series index, blank for the metastore
+	indexType string
+
+	// in memory index, read on first use from the log if the index is on disk
+	indexMu   sync.Mutex
+	idx       *seriesIndex
+	indexLog  *indexLog
+	indexUsed bool // index was used since it was last checked for eviction
 }
 
 // newDatabase returns an instance of database.
@@ -31,19 +63,49 @@ func newDatabase() *database {
 	return &database{
 		policies:     make(map[string]*RetentionPolicy),
 		shards:       make(map[uint64]*Shard),
-		measurements: make(map[string]*Measurement),
-		series:       make(map[uint32]*Series),
-		names:        make([]string, 0),
+		schemas:      make(map[string]*MeasurementSchema),
+		tagGuards:    make(map[string]map[string]*TagGuard),
+		alertRules:   make(map[string]*AlertRule),
+		routingRules: make(map[string]*RoutingRule),
+		deletions:    make(map[uint64]*Deletion),
+		idx:          newSeriesIndex(),
 	}
 }
 
-// shardByTimestamp returns a shard that owns a given timestamp.
-func (db *database) shardByTimestamp(policy string, seriesID uint32, timestamp time.Time) (*Shard, error) {
+// shardByTimestamp returns a shard that owns a given timestamp for a series key.
+func (db *database) shardByTimestamp(policy string, key []byte, timestamp time.Time) (*Shard, error) {
 	p := db.policies[policy]
 	if p == nil {
 		return nil, ErrRetentionPolicyNotFound
 	}
-	return p.shardByTimestamp(seriesID, timestamp), nil
+	return p.shardByTimestamp(key, timestamp), nil
+}
+
+// seriesShards returns the shards that can hold a series. Only the shard that
+// the series key hashes to is kept from each group of split shards.
+func (db *database) seriesShards(shards []*Shard, key []byte) []*Shard {
+	skip := make(map[uint64]bool)
+	for _, rp := range db.policies {
+		for _, group := range rp.shardGroups() {
+			if len(group) < 2 {
+				continue
+			}
+			owner := group[jumpHash(key, len(group))]
+			for _, sh := range group {
+				if sh != owner {
+					skip[sh.ID] = true
+				}
+			}
+		}
+	}
+
+	a := make([]*Shard, 0, len(shards))
+	for _, sh := range shards {
+		if !skip[sh.ID] {
+			a = append(a, sh)
+		}
+	}
+	return a
 }
 
 // shardsByTimestamp returns all shards that own a given timestamp.
@@ -66,12 +128,36 @@ func (db *database) MarshalJSON() ([]byte, error) {
 	var o databaseJSON
 	o.Name = db.name
 	o.DefaultRetentionPolicy = db.defaultRetentionPolicy
+	o.ReadOnly = db.readOnly
+	o.Disabled = db.disabled
+	o.StrictSchema = db.strictSchema
+	o.MaxSeries = db.maxSeries
+	o.MaxDiskBytes = db.maxDiskBytes
+	o.MaxRetention = db.maxRetention
+	o.Index = db.indexType
 	for _, rp := range db.policies {
 		o.Policies = append(o.Policies, rp)
 	}
 	for _, s := range db.shards {
 		o.Shards = append(o.Shards, s)
 	}
+	for _, ms := range db.schemas {
+		o.Schemas = append(o.Schemas, ms)
+	}
+	for _, guards := range db.tagGuards {
+		for _, g := range guards {
+			o.TagGuards = append(o.TagGuards, g)
+		}
+	}
+	for _, r := range db.alertRules {
+		o.AlertRules = append(o.AlertRules, r)
+	}
+	for _, r := range db.routingRules {
+		o.RoutingRules = append(o.RoutingRules, r)
+	}
+	for _, d := range db.deletions {
+		o.Deletions = append(o.Deletions, d)
+	}
 	return json.Marshal(&o)
 }
 
@@ -86,6 +172,13 @@ func (db *database) UnmarshalJSON(data []byte) error {
 	// Copy over properties from intermediate type.
 	db.name = o.Name
 	db.defaultRetentionPolicy = o.DefaultRetentionPolicy
+	db.readOnly = o.ReadOnly
+	db.disabled = o.Disabled
+	db.strictSchema = o.StrictSchema
+	db.maxSeries = o.MaxSeries
+	db.maxDiskBytes = o.MaxDiskBytes
+	db.maxRetention = o.MaxRetention
+	db.indexType = o.Index
 
 	// Copy shard policies.
 	db.policies = make(map[string]*RetentionPolicy)
@@ -99,15 +192,68 @@ func (db *database) UnmarshalJSON(data []byte) error {
 		db.shards[s.ID] = s
 	}
 
+	// Copy measurement schemas.
+	db.schemas = make(map[string]*MeasurementSchema)
+	for _, ms := range o.Schemas {
+		db.schemas[ms.Name] = ms
+	}
+
+	// Copy tag guards.
+	db.tagGuards = make(map[string]map[string]*TagGuard)
+	for _, g := range o.TagGuards {
+		db.setTagGuard(g)
+	}
+
+	// Copy alert rules.
+	db.alertRules = make(map[string]*AlertRule)
+	for _, r := range o.AlertRules {
+		db.alertRules[r.Name] = r
+	}
+
+	// Copy routing rules.
+	db.routingRules = make(map[string]*RoutingRule)
+	for _, r := range o.RoutingRules {
+		db.routingRules[r.Name] = r
+	}
+
+	// Copy deletions.
+	db.deletions = make(map[uint64]*Deletion)
+	for _, d := range o.Deletions {
+		db.deletions[d.ID] = d
+	}
+
+	// Ensure policies reference the same shard instances as the database.
+	for _, rp := range db.policies {
+		for i, s := range rp.Shards {
+			if sh := db.shards[s.ID]; sh != nil {
+				rp.Shards[i] = sh
+			} else {
+				db.shards[s.ID] = s
+			}
+		}
+	}
+
 	return nil
 }
 
 // databaseJSON represents the JSON-serialization format for a database.
 type databaseJSON struct {
-	Name                   string             `json:"name,omitempty"`
-	DefaultRetentionPolicy string             `json:"defaultRetentionPolicy,omitempty"`
-	Policies               []*RetentionPolicy `json:"policies,omitempty"`
-	Shards                 []*Shard           `json:"shards,omitempty"`
+	Name                   string               `json:"name,omitempty"`
+	DefaultRetentionPolicy string               `json:"defaultRetentionPolicy,omitempty"`
+	Policies               []*RetentionPolicy   `json:"policies,omitempty"`
+	Shards                 []*Shard             `json:"shards,omitempty"`
+	ReadOnly               bool                 `json:"readOnly,omitempty"`
+	Disabled               bool                 `json:"disabled,omitempty"`
+	StrictSchema           bool                 `json:"strictSchema,omitempty"`
+	Schemas                []*MeasurementSchema `json:"schemas,omitempty"`
+	TagGuards              []*TagGuard          `json:"tagGuards,omitempty"`
+	AlertRules             []*AlertRule         `json:"alertRules,omitempty"`
+	RoutingRules           []*RoutingRule       `json:"routingRules,omitempty"`
+	Deletions              []*Deletion          `json:"deletions,omitempty"`
+	MaxSeries              int                  `json:"maxSeries,omitempty"`
+	MaxDiskBytes           int64                `json:"maxDiskBytes,omitempty"`
+	MaxRetention           time.Duration        `json:"maxRetention,omitempty"`
+	Index                  string               `json:"index,omitempty"`
 }
 
 // Measurement represents a collection of time series in a database. It also contains in memory
@@ -115,8 +261,8 @@ type databaseJSON struct {
 // object. Generally these methods are only accessed from Index, which is responsible for ensuring
 // go routine safe access.
 type Measurement struct {
-	Name   string    `json:"name,omitempty"`
-	Fields []*Fields `json:"fields,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Fields Fields `json:"fields,omitempty"`
 
 	// in memory index fields
 	series              map[string]*Series // sorted tagset string to the series object
@@ -124,17 +270,21 @@ type Measurement struct {
 	measurement         *Measurement
 	seriesByTagKeyValue map[string]map[string]SeriesIDs // map from tag key to value to sorted set of series ids
 	ids                 SeriesIDs                       // sorted list of series IDs in this measurement
+	seriesSketch        *sketch                         // estimates the number of series
+	tagSketches         map[string]*sketch              // estimates the number of values for each tag key
 }
 
 func NewMeasurement(name string) *Measurement {
 	return &Measurement{
 		Name:   name,
-		Fields: make([]*Fields, 0),
+		Fields: make(Fields, 0),
 
 		series:              make(map[string]*Series),
 		seriesByID:          make(map[uint32]*Series),
 		seriesByTagKeyValue: make(map[string]map[string]SeriesIDs),
 		ids:                 SeriesIDs(make([]uint32, 0)),
+		seriesSketch:        newSketch(),
+		tagSketches:         make(map[string]*sketch),
 	}
 }
 
@@ -146,6 +296,7 @@ func (m *Measurement) addSeries(s *Series) bool {
 	m.seriesByID[s.ID] = s
 	tagset := string(marshalTags(s.Tags))
 	m.series[tagset] = s
+	m.seriesSketch.add(tagset)
 	m.ids = append(m.ids, s.ID)
 	// the series ID should always be higher than all others because it's a new
 	// series. So don't do the sort if we don't have to.
@@ -169,6 +320,14 @@ func (m *Measurement) addSeries(s *Series) bool {
 			sort.Sort(ids)
 		}
 		valueMap[v] = ids
+
+		// add the value to the estimate for the tag key
+		sk := m.tagSketches[k]
+		if sk == nil {
+			sk = newSketch()
+			m.tagSketches[k] = sk
+		}
+		sk.add(v)
 	}
 
 	return true
@@ -238,22 +397,53 @@ func (m *Measurement) tagValues(key string) TagValues {
 
 type Measurements []*Measurement
 
-// Field represents a series field.
-type Field struct {
-	ID   uint8     `json:"id,omitempty"`
-	Name string    `json:"name,omitempty"`
-	Type FieldType `json:"field"`
+// field returns a field by name. Returns nil if the field does not exist.
+func (m *Measurement) field(name string) *Field {
+	for _, f := range m.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
 }
 
-type FieldType int
+// fieldByID returns a field by id. Returns nil if the field does not exist.
+func (m *Measurement) fieldByID(id uint8) *Field {
+	for _, f := range m.Fields {
+		if f.ID == id {
+			return f
+		}
+	}
+	return nil
+}
 
-const (
-	Int64 FieldType = iota
-	Float64
-	String
-	Boolean
-	Binary
-)
+// createFieldIfNotExists returns the field with the given name, creating it
+// if it doesn't exist. Returns an error if the field already exists with a
+// different data type or if the measurement has too many fields.
+func (m *Measurement) createFieldIfNotExists(name string, typ influxql.DataType) (*Field, error) {
+	if f := m.field(name); f != nil {
+		if f.Type != typ {
+			return nil, ErrFieldTypeConflict
+		}
+		return f, nil
+	}
+
+	// Field ids are a single byte and zero is reserved.
+	if len(m.Fields) >= 255 {
+		return nil, ErrFieldOverflow
+	}
+
+	f := &Field{ID: uint8(len(m.Fields) + 1), Name: name, Type: typ}
+	m.Fields = append(m.Fields, f)
+	return f, nil
+}
+
+// Field represents a series field.
+type Field struct {
+	ID   uint8             `json:"id,omitempty"`
+	Name string            `json:"name,omitempty"`
+	Type influxql.DataType `json:"type,omitempty"`
+}
 
 // Fields represents a list of fields.
 type Fields []*Field
@@ -277,6 +467,9 @@ type RetentionPolicy struct {
 	ReplicaN uint32
 	SplitN   uint32
 
+	// Rule for filling the policy with aggregates of the default policy, if set.
+	Downsample *Downsample
+
 	Shards []*Shard
 }
 
@@ -290,16 +483,55 @@ func NewRetentionPolicy(name string) *RetentionPolicy {
 	}
 }
 
-// shardByTimestamp returns the shard in the space that owns a given timestamp for a given series id.
+// shardByTimestamp returns the shard in the space that owns a given timestamp for a given series key.
+// Series are spread over the shards of a group by consistent hashing of the key
+// so that each series is always written to the same shard.
 // Returns nil if the shard does not exist.
-func (rp *RetentionPolicy) shardByTimestamp(seriesID uint32, timestamp time.Time) *Shard {
-	shards := rp.shardsByTimestamp(timestamp)
+func (rp *RetentionPolicy) shardByTimestamp(key []byte, timestamp time.Time) *Shard {
+	shards := rp.shardGroupByTimestamp(timestamp)
 	if len(shards) > 0 {
-		return shards[int(seriesID)%len(shards)]
+		return shards[jumpHash(key, len(shards))]
+	}
+	return nil
+}
+
+// splitN returns the number of shards in each group. Defaults to one.
+func (rp *RetentionPolicy) splitN() int {
+	if rp.SplitN < 1 {
+		return 1
+	}
+	return int(rp.SplitN)
+}
+
+// shardGroupByTimestamp returns the group of shards that owns a given timestamp.
+// Returns nil if no group owns the timestamp.
+func (rp *RetentionPolicy) shardGroupByTimestamp(timestamp time.Time) []*Shard {
+	for _, group := range rp.shardGroups() {
+		if timeBetweenInclusive(timestamp, group[0].StartTime, group[0].EndTime) {
+			return group
+		}
 	}
 	return nil
 }
 
+// shardGroups returns the policy's shards grouped by time range. The shards
+// of a group are split over the same range and are in the order they were
+// created, which is the same on every node.
+func (rp *RetentionPolicy) shardGroups() (a [][]*Shard) {
+	type timeRange struct{ start, end int64 }
+	index := make(map[timeRange]int)
+	for _, sh := range rp.Shards {
+		r := timeRange{sh.StartTime.UnixNano(), sh.EndTime.UnixNano()}
+		if i, ok := index[r]; ok {
+			a[i] = append(a[i], sh)
+			continue
+		}
+		index[r] = len(a)
+		a = append(a, []*Shard{sh})
+	}
+	return
+}
+
 func (rp *RetentionPolicy) shardsByTimestamp(timestamp time.Time) []*Shard {
 	shards := make([]*Shard, 0, rp.SplitN)
 	for _, s := range rp.Shards {
@@ -313,10 +545,12 @@ func (rp *RetentionPolicy) shardsByTimestamp(timestamp time.Time) []*Shard {
 // MarshalJSON encodes a retention policy to a JSON-encoded byte slice.
 func (rp *RetentionPolicy) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&retentionPolicyJSON{
-		Name:     rp.Name,
-		Duration: rp.Duration,
-		ReplicaN: rp.ReplicaN,
-		SplitN:   rp.SplitN,
+		Name:       rp.Name,
+		Duration:   rp.Duration,
+		ReplicaN:   rp.ReplicaN,
+		SplitN:     rp.SplitN,
+		Downsample: rp.Downsample,
+		Shards:     rp.Shards,
 	})
 }
 
@@ -333,6 +567,7 @@ func (rp *RetentionPolicy) UnmarshalJSON(data []byte) error {
 	rp.ReplicaN = o.ReplicaN
 	rp.SplitN = o.SplitN
 	rp.Duration = o.Duration
+	rp.Downsample = o.Downsample
 	rp.Shards = o.Shards
 
 	return nil
@@ -340,11 +575,12 @@ func (rp *RetentionPolicy) UnmarshalJSON(data []byte) error {
 
 // retentionPolicyJSON represents an intermediate struct for JSON marshaling.
 type retentionPolicyJSON struct {
-	Name     string        `json:"name"`
-	ReplicaN uint32        `json:"replicaN,omitempty"`
-	SplitN   uint32        `json:"splitN,omitempty"`
-	Duration time.Duration `json:"duration,omitempty"`
-	Shards   []*Shard      `json:"shards,omitempty"`
+	Name       string        `json:"name"`
+	ReplicaN   uint32        `json:"replicaN,omitempty"`
+	SplitN     uint32        `json:"splitN,omitempty"`
+	Duration   time.Duration `json:"duration,omitempty"`
+	Downsample *Downsample   `json:"downsample,omitempty"`
+	Shards     []*Shard      `json:"shards,omitempty"`
 }
 
 // RetentionPolicies represents a list of shard policies.
@@ -477,32 +713,12 @@ func (l SeriesIDs) Reject(r SeriesIDs) SeriesIDs {
 
 // addSeriesToIndex adds the series for the given measurement to the index. Returns false if already present
 func (d *database) addSeriesToIndex(measurementName string, s *Series) bool {
-	// if there is a measurement for this id, it's already been added
-	if d.series[s.ID] != nil {
-		return false
-	}
-
-	// get or create the measurement index and index it globally and in the measurement
-	idx := d.createMeasurementIfNotExists(measurementName)
-
-	s.measurement = idx
-	d.series[s.ID] = s
-
-	// TODO: add this series to the global tag index
-
-	return idx.addSeries(s)
+	return d.index().addSeries(measurementName, s)
 }
 
 // createMeasurementIfNotExists will either add a measurement object to the index or return the existing one.
 func (d *database) createMeasurementIfNotExists(name string) *Measurement {
-	idx := d.measurements[name]
-	if idx == nil {
-		idx = NewMeasurement(name)
-		d.measurements[name] = idx
-		d.names = append(d.names, name)
-		sort.Strings(d.names)
-	}
-	return idx
+	return d.index().createMeasurementIfNotExists(name)
 }
 
 // AddField adds a field to the measurement name. Returns false if already present
@@ -515,8 +731,9 @@ func (d *database) AddField(name string, f *Field) bool {
 func (d *database) MeasurementsBySeriesIDs(seriesIDs SeriesIDs) []*Measurement {
 	measurements := make(map[*Measurement]bool)
 
+	series := d.index().series
 	for _, id := range seriesIDs {
-		m := d.series[id].measurement
+		m := series[id].measurement
 		measurements[m] = true
 	}
 
@@ -535,7 +752,7 @@ func (d *database) SeriesIDs(names []string, filters []*TagFilter) SeriesIDs {
 	// they want all ids if no filters are specified
 	if len(filters) == 0 {
 		ids := SeriesIDs(make([]uint32, 0))
-		for _, idx := range d.measurements {
+		for _, idx := range d.index().measurements {
 			ids = ids.Union(idx.ids)
 		}
 		return ids
@@ -553,12 +770,12 @@ func (d *database) SeriesIDs(names []string, filters []*TagFilter) SeriesIDs {
 // If an empty or nil slice is passed in, the tag keys for the entire database will be returned.
 func (d *database) TagKeys(names []string) []string {
 	if len(names) == 0 {
-		names = d.names
+		names = d.index().names
 	}
 
 	keys := make(map[string]bool)
 	for _, n := range names {
-		idx := d.measurements[n]
+		idx := d.index().measurements[n]
 		if idx != nil {
 			for k, _ := range idx.seriesByTagKeyValue {
 				keys[k] = true
@@ -585,7 +802,7 @@ func (d *database) TagValues(names []string, key string, filters []*TagFilter) T
 	// see if they just want all the tag values for this key
 	if len(filters) == 0 {
 		for _, n := range names {
-			idx := d.measurements[n]
+			idx := d.index().measurements[n]
 			if idx != nil {
 				values.Union(idx.tagValues(key))
 			}
@@ -601,8 +818,9 @@ func (d *database) TagValues(names []string, key string, filters []*TagFilter) T
 // tagValuesBySeries will return a TagValues map of all the unique tag values for a collection of series.
 func (d *database) tagValuesBySeries(key string, seriesIDs SeriesIDs) TagValues {
 	values := make(map[string]bool)
+	series := d.index().series
 	for _, id := range seriesIDs {
-		s := d.series[id]
+		s := series[id]
 		if s == nil {
 			continue
 		}
@@ -643,7 +861,7 @@ func (l TagValues) Intersect(r TagValues) {
 
 //seriesIDsByName is the same as SeriesIDs, but for a specific measurement.
 func (d *database) seriesIDsByName(name string, filters []*TagFilter) SeriesIDs {
-	idx := d.measurements[name]
+	idx := d.index().measurements[name]
 	if idx == nil {
 		return nil
 	}
@@ -665,7 +883,7 @@ func (d *database) seriesIDsByName(name string, filters []*TagFilter) SeriesIDs
 
 // MeasurementBySeriesID returns the Measurement that is the parent of the given series id.
 func (d *database) MeasurementBySeriesID(id uint32) *Measurement {
-	if s, ok := d.series[id]; ok {
+	if s, ok := d.index().series[id]; ok {
 		return s.measurement
 	}
 	return nil
@@ -673,22 +891,75 @@ func (d *database) MeasurementBySeriesID(id uint32) *Measurement {
 
 // MeasurementAndSeries returns the Measurement and the Series for a given measurement name and tag set.
 func (d *database) MeasurementAndSeries(name string, tags map[string]string) (*Measurement, *Series) {
-	idx := d.measurements[name]
+	idx := d.index().measurements[name]
 	if idx == nil {
 		return nil, nil
 	}
 	return idx, idx.seriesByTags(tags)
 }
 
+// batchFields holds the types of the fields that a batch of points creates,
+// by measurement name and field name.
+type batchFields map[string]map[string]influxql.DataType
+
+// checkFields returns a PointError for each field of p that has a different
+// type than the existing field of its measurement or the same field created
+// by an earlier point in the batch, or that exceeds the measurement's field
+// limit. Fields that the point creates are added to batch and passed to fn,
+// if set. Fields are checked by the types they are stored as.
+func (d *database) checkFields(p *Point, batch batchFields, fn func(name string, typ influxql.DataType)) PointErrors {
+	data, err := appendValues(nil, p.Values)
+	if err != nil {
+		return PointErrors{&PointError{Err: err}}
+	}
+
+	m := d.index().measurements[p.Name]
+	n := 0
+	if m != nil {
+		n = len(m.Fields)
+	}
+	if batch[p.Name] == nil {
+		batch[p.Name] = make(map[string]influxql.DataType)
+	}
+
+	var errs PointErrors
+	_ = valueTypes(data, func(key string, typ influxql.DataType) error {
+		if m != nil {
+			if f := m.field(key); f != nil {
+				if f.Type != typ {
+					errs = append(errs, &PointError{Key: key, Err: ErrFieldTypeConflict})
+				}
+				return nil
+			}
+		}
+		if other, ok := batch[p.Name][key]; ok {
+			if other != typ {
+				errs = append(errs, &PointError{Key: key, Err: ErrFieldTypeConflict})
+			}
+			return nil
+		} else if n+len(batch[p.Name]) >= 255 {
+			errs = append(errs, &PointError{Key: key, Err: ErrFieldOverflow})
+			return nil
+		}
+		batch[p.Name][key] = typ
+		if fn != nil {
+			fn(key, typ)
+		}
+		return nil
+	})
+	return errs
+}
+
 // SereiesByID returns the Series that has the given id.
 func (d *database) SeriesByID(id uint32) *Series {
-	return d.series[id]
+	return d.index().series[id]
 }
 
 // Measurements returns all measurements that match the given filters.
 func (d *database) Measurements(filters []*TagFilter) []*Measurement {
-	measurements := make([]*Measurement, 0, len(d.measurements))
-	for _, idx := range d.measurements {
+	index := d.index()
+	measurements := make([]*Measurement, 0, len(index.measurements))
+	for _, idx := range index.measurements {
 		measurements = append(measurements, idx.measurement)
 	}
 	return measurements
@@ -696,7 +967,7 @@ func (d *database) Measurements(filters []*TagFilter) []*Measurement {
 
 // Names returns all measurement names in sorted order.
 func (d *database) Names() []string {
-	return d.names
+	return d.index().names
 }
 
 // DropSeries will clear the index of all references to a series.
@@ -709,6 +980,27 @@ func (d *database) DropMeasurement(name string) {
 	panic("not implemented")
 }
 
+// seriesKey returns the key that a series is hashed by to pick its shard.
+func seriesKey(name string, tags map[string]string) []byte {
+	return append([]byte(name+"|"), marshalTags(tags)...)
+}
+
+// jumpHash returns the bucket in [0, n) for a key using jump consistent
+// hashing, so few keys move to another bucket when n changes.
+func jumpHash(key []byte, n int) int {
+	h := fnv.New64a()
+	h.Write(key)
+	k := h.Sum64()
+
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		k = k*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((k>>33)+1)))
+	}
+	return int(b)
+}
+
 // used to convert the tag set to bytes for use as a lookup key
 func marshalTags(tags map[string]string) []byte {
 	s := make([]string, 0, len(tags))
@@ -1,10 +1,12 @@
 package influxdb
 
 import (
+	"fmt"
 	"reflect"
 	"regexp"
 	"sort"
 	"testing"
+	"time"
 )
 
 // Ensure that the index will return a sorted array of measurement names.
@@ -421,6 +423,80 @@ func TestDatabase_TagValuesWhereTagFilter(t *testing.T) {
 	}
 }
 
+// Ensure series are spread over a split shard group by their key.
+func TestRetentionPolicy_ShardByTimestamp(t *testing.T) {
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	rp := &RetentionPolicy{Shards: []*Shard{
+		{ID: 1, StartTime: start, EndTime: start.Add(time.Hour)},
+		{ID: 2, StartTime: start.Add(time.Hour), EndTime: start.Add(2 * time.Hour)},
+		{ID: 3, StartTime: start, EndTime: start.Add(time.Hour)},
+		{ID: 4, StartTime: start, EndTime: start.Add(time.Hour)},
+	}}
+
+	// Every series in the group maps to one of its shards, every time.
+	counts := make(map[uint64]int)
+	for i := 0; i < 300; i++ {
+		key := seriesKey("cpu", map[string]string{"host": fmt.Sprintf("server%d", i)})
+		sh := rp.shardByTimestamp(key, start.Add(time.Minute))
+		if sh == nil || sh.ID == 2 {
+			t.Fatalf("unexpected shard: %#v", sh)
+		} else if other := rp.shardByTimestamp(key, start.Add(30*time.Minute)); other != sh {
+			t.Fatalf("series moved from shard %d to %d", sh.ID, other.ID)
+		}
+		counts[sh.ID]++
+	}
+	for _, id := range []uint64{1, 3, 4} {
+		if counts[id] < 50 {
+			t.Fatalf("unexpected distribution: %v", counts)
+		}
+	}
+
+	// A group of one shard owns every series.
+	if sh := rp.shardByTimestamp([]byte("cpu|"), start.Add(90*time.Minute)); sh == nil || sh.ID != 2 {
+		t.Fatalf("unexpected shard: %#v", sh)
+	} else if sh := rp.shardByTimestamp([]byte("cpu|"), start.Add(3*time.Hour)); sh != nil {
+		t.Fatalf("unexpected shard: %#v", sh)
+	}
+}
+
+// Ensure only the owner of a series is read from a split shard group.
+func TestDatabase_SeriesShards(t *testing.T) {
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	rp := &RetentionPolicy{Name: "raw", Shards: []*Shard{
+		{ID: 1, StartTime: start, EndTime: start.Add(time.Hour)},
+		{ID: 2, StartTime: start, EndTime: start.Add(time.Hour)},
+		{ID: 3, StartTime: start.Add(time.Hour), EndTime: start.Add(2 * time.Hour)},
+	}}
+	db := newDatabase()
+	db.policies[rp.Name] = rp
+
+	key := seriesKey("cpu", map[string]string{"host": "servera"})
+	owner := rp.shardByTimestamp(key, start)
+	if a := db.seriesShards(rp.Shards, key); len(a) != 2 || a[0] != owner || a[1].ID != 3 {
+		t.Fatalf("unexpected shards: %v", a)
+	}
+}
+
+// Ensure jump hashing moves few keys when a bucket is added.
+func TestJumpHash(t *testing.T) {
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("cpu|host|server%d", i))
+		a, b := jumpHash(key, 4), jumpHash(key, 5)
+		if a < 0 || a >= 4 || b < 0 || b >= 5 {
+			t.Fatalf("bucket out of range: %d, %d", a, b)
+		} else if a != b {
+			if b != 4 {
+				t.Fatalf("key moved between existing buckets: %d -> %d", a, b)
+			}
+			moved++
+		}
+	}
+	if moved > 300 {
+		t.Fatalf("too many keys moved: %d", moved)
+	}
+}
+
 func TestDatabase_DropSeries(t *testing.T) {
 	t.Skip("pending")
 }
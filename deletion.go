@@ -0,0 +1,214 @@
+package influxdb
+
+import (
+	"sort"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// Deletion represents points deleted from a database that have not yet been
+// purged from its shards. Deletes only write a deletion, which masks the
+// points from queries right away, and the points are removed from disk later
+// by the compactor or by PurgeDeletions.
+//
+// Each deletion has the index of its delete command as its epoch. Only points
+// written at or before the epoch are deleted so points written into the range
+// after the delete are kept.
+type Deletion struct {
+	ID          uint64    `json:"id"`
+	Measurement string    `json:"measurement,omitempty"`
+	Before      time.Time `json:"before"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// mask returns the mask for the deletion's points in a shard. Returns false
+// if the shard was created after the deletion and can't hold any of them.
+func (d *Deletion) mask(sh *Shard) (deleteMask, bool) {
+	if sh.ID > d.ID || !sh.StartTime.Before(d.Before) {
+		return deleteMask{}, false
+	}
+	return deleteMask{before: d.Before.UnixNano(), epoch: d.ID}, true
+}
+
+// deleteMask masks the points of a series in a shard that have been deleted.
+type deleteMask struct {
+	before int64  // points before this timestamp are masked
+	epoch  uint64 // only points written at or before this index are masked
+}
+
+// masked returns true if a point is masked by any of the masks. Points
+// without a sequence number were written before they were stored and are
+// older than any deletion.
+func masked(masks []deleteMask, timestamp int64, seq []byte) bool {
+	for _, m := range masks {
+		if timestamp < m.before && (seq == nil || btou64(seq) <= m.epoch) {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteMasks returns the masks for a measurement's points in a shard.
+func (db *database) deleteMasks(name string, sh *Shard) (a []deleteMask) {
+	for _, d := range db.deletions {
+		if d.Measurement != "" && d.Measurement != name {
+			continue
+		}
+		if m, ok := d.mask(sh); ok {
+			a = append(a, m)
+		}
+	}
+	return
+}
+
+// Deletions returns the deletions of a database that have not yet been
+// purged, oldest first.
+func (s *Server) Deletions(database string) ([]*Deletion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+
+	a := make(deletions, 0, len(db.deletions))
+	for _, d := range db.deletions {
+		a = append(a, d)
+	}
+	sort.Sort(a)
+	return a, nil
+}
+
+// PurgeDeletions removes the points of every pending deletion in a database
+// from disk now instead of waiting for the compactor.
+func (s *Server) PurgeDeletions(database string) error {
+	a, err := s.Deletions(database)
+	if err != nil {
+		return err
+	}
+	for _, d := range a {
+		if err := s.purgeDeletion(database, d.ID); err != nil && err != ErrDeletionNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeDeletions purges the pending deletions of every database. Errors are
+// logged. Called by the compactor before it plans compactions so that the
+// shards the points are removed from are compacted afterward.
+func (s *Server) purgeDeletions() {
+	s.mu.RLock()
+	var names []string
+	for name, db := range s.databases {
+		if len(db.deletions) > 0 {
+			names = append(names, name)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, name := range names {
+		if err := s.PurgeDeletions(name); err != nil && err != ErrDatabaseNotFound {
+			s.Logger.Printf("purge deletions: %s: %s", name, err)
+		}
+	}
+}
+
+// purgeDeletion removes the points of a deletion from disk on every node and
+// then removes the deletion.
+func (s *Server) purgeDeletion(database string, id uint64) error {
+	c := &purgeDeletionCommand{Database: database, ID: id}
+	_, err := s.broadcast(purgeDeletionMessageType, c)
+	return err
+}
+
+func (s *Server) applyPurgeDeletion(m *messaging.Message) error {
+	var c purgeDeletionCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+	d := db.deletions[c.ID]
+	if d == nil {
+		return ErrDeletionNotFound
+	}
+
+	// Restrict the purge to the measurement's series, if set. A measurement
+	// without any series has no points to remove.
+	var seriesIDs []uint32
+	if d.Measurement != "" {
+		idx, err := db.loadIndex()
+		if err != nil {
+			return err
+		}
+		mm := idx.measurements[d.Measurement]
+		if mm != nil {
+			seriesIDs = append([]uint32{}, mm.ids...)
+		}
+	}
+
+	// Remove the points from the shards stored on this node. The shards are
+	// compacted again to reclaim the space.
+	if d.Measurement == "" || len(seriesIDs) > 0 {
+		for _, sh := range db.shards {
+			mask, ok := d.mask(sh)
+			if !ok {
+				continue
+			}
+			if _, err := sh.deletePoints(seriesIDs, mask); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Remove the deletion now that its points are gone.
+	delete(db.deletions, c.ID)
+
+	// Persist to metastore.
+	return s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+}
+
+type purgeDeletionCommand struct {
+	Database string `json:"database"`
+	ID       uint64 `json:"id"`
+}
+
+// executeShowDeletionsStatement returns a row for each pending deletion in
+// the database. Requires an admin user.
+func (s *Server) executeShowDeletionsStatement(database string, user *User) *Result {
+	if user != nil && !user.Admin {
+		return &Result{Err: ErrAdminRequired}
+	}
+
+	a, err := s.Deletions(database)
+	if err != nil {
+		return &Result{Err: err}
+	}
+
+	row := &influxql.Row{
+		Name:    "deletions",
+		Columns: []string{"id", "measurement", "before", "created_at"},
+	}
+	for _, d := range a {
+		row.Values = append(row.Values, []interface{}{d.ID, d.Measurement, d.Before.Format(time.RFC3339Nano), d.CreatedAt.Format(time.RFC3339Nano)})
+	}
+	return &Result{Rows: []*influxql.Row{row}}
+}
+
+// deletions represents a list of deletions, sortable by id.
+type deletions []*Deletion
+
+func (a deletions) Len() int           { return len(a) }
+func (a deletions) Less(i, j int) bool { return a[i].ID < a[j].ID }
+func (a deletions) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
@@ -0,0 +1,69 @@
+package influxdb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Ensure deleted points are masked from reads and only points written at or
+// before the deletion's epoch are removed.
+func TestShard_DeletePoints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influxdb-shard-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sh := newShard()
+	if err := sh.open(filepath.Join(dir, "1")); err != nil {
+		t.Fatal(err)
+	}
+	defer sh.close()
+	for _, p := range []struct {
+		seq       uint64
+		timestamp int64
+	}{{1, 10}, {5, 20}, {1, 30}} {
+		data, err := marshalPoint(1, time.Unix(0, p.timestamp), map[string]interface{}{"value": float64(p.timestamp)})
+		if err != nil {
+			t.Fatal(err)
+		} else if _, err := sh.writeSeries(nil, p.seq, [][]byte{data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	timestamps := func(masks []deleteMask) (a []int64) {
+		st, err := sh.acquire()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sh.release(st)
+		points, _, err := st.readSeries(context.Background(), 1, 0, 0, 0, masks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range points {
+			a = append(a, p.timestamp)
+		}
+		return
+	}
+
+	// The point written after the deletion and the point after its time are kept.
+	mask := deleteMask{before: 25, epoch: 3}
+	if a := timestamps([]deleteMask{mask}); len(a) != 2 || a[0] != 20 || a[1] != 30 {
+		t.Fatalf("unexpected masked points: %v", a)
+	}
+
+	// Purging removes the same points from disk.
+	if n, err := sh.deletePoints(nil, mask); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("unexpected deleted point count: %d", n)
+	}
+	if a := timestamps(nil); len(a) != 2 || a[0] != 20 || a[1] != 30 {
+		t.Fatalf("unexpected points: %v", a)
+	}
+}
@@ -0,0 +1,79 @@
+package influxdb
+
+import (
+	"github.com/boltdb/bolt"
+)
+
+// String field values are stored once in a shard's dictionary and points
+// refer to them by id. Only strings that are long enough to be larger than
+// their id and short enough to be likely to repeat are added, and the
+// dictionary stops growing once it is full so that unique values such as
+// log messages are stored inline.
+const (
+	minDictionaryString = 4
+	maxDictionaryString = 256
+	maxDictionarySize   = 1 << 16
+)
+
+// stringDictionary maps string field values in a shard to ids. It is only
+// valid for the lifetime of the transaction it was opened in.
+type stringDictionary struct {
+	ids     *bolt.Bucket // string to id
+	strings *bolt.Bucket // id to string
+	cache   map[uint64]string
+}
+
+// newStringDictionary returns the dictionary of a shard's store.
+// Returns an empty dictionary if the store does not have one.
+func newStringDictionary(tx *bolt.Tx) *stringDictionary {
+	d := &stringDictionary{cache: make(map[uint64]string)}
+	if b := tx.Bucket([]byte("dictionary")); b != nil {
+		d.ids, d.strings = b.Bucket([]byte("ids")), b.Bucket([]byte("strings"))
+	}
+	return d
+}
+
+// intern returns the id of a string, adding it to the dictionary if needed.
+// Returns false if the string should be stored inline.
+func (d *stringDictionary) intern(s []byte) (uint64, bool, error) {
+	if d.ids == nil || len(s) < minDictionaryString || len(s) > maxDictionaryString {
+		return 0, false, nil
+	}
+	if v := d.ids.Get(s); v != nil {
+		return btou64(v), true, nil
+	}
+
+	// Ids are assigned in order so the next id follows the last one. Bucket
+	// sequences are not used as they are not kept when a shard is compacted.
+	var id uint64
+	if k, _ := d.strings.Cursor().Last(); k != nil {
+		id = btou64(k) + 1
+	}
+	if id >= maxDictionarySize {
+		return 0, false, nil
+	}
+
+	if err := d.ids.Put(append([]byte(nil), s...), u64tob(id)); err != nil {
+		return 0, false, err
+	} else if err := d.strings.Put(u64tob(id), append([]byte(nil), s...)); err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+// lookup returns the string for an id.
+func (d *stringDictionary) lookup(id uint64) (string, bool) {
+	if s, ok := d.cache[id]; ok {
+		return s, true
+	} else if d.strings == nil {
+		return "", false
+	}
+
+	v := d.strings.Get(u64tob(id))
+	if v == nil {
+		return "", false
+	}
+	s := string(v)
+	d.cache[id] = s
+	return s, true
+}
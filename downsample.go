@@ -0,0 +1,402 @@
+package influxdb
+
+import (
+	"strings"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// DefaultDownsampleFunction is the function used to aggregate points when a
+// downsampling rule does not set one.
+const DefaultDownsampleFunction = "mean"
+
+// Downsample represents a rule for filling a retention policy with aggregates
+// of the points in its database's default retention policy, such as "after 7d,
+// keep 5m means". Queries that don't set a retention policy read from the
+// policy once their time range reaches further back than After.
+type Downsample struct {
+	// Age of data that is read from the policy instead of the default policy.
+	After time.Duration `json:"after"`
+
+	// Width of the intervals that points are aggregated over.
+	Interval time.Duration `json:"interval"`
+
+	// Function applied to each field, either "mean" or "sum".
+	Function string `json:"function,omitempty"`
+
+	// End of the last interval that has been aggregated.
+	Completed time.Time `json:"completed"`
+}
+
+// function returns the aggregate function for the rule.
+func (d *Downsample) function() string {
+	if d.Function == "" {
+		return DefaultDownsampleFunction
+	}
+	return strings.ToLower(d.Function)
+}
+
+// validate returns an error if the rule cannot be run.
+func (d *Downsample) validate() error {
+	if d.Interval <= 0 {
+		return ErrDownsampleIntervalRequired
+	}
+	switch d.function() {
+	case "mean", "sum":
+		return nil
+	}
+	return ErrInvalidDownsampleFunction
+}
+
+// readPolicy returns the retention policy that a select statement without a
+// retention policy reads from. Returns nil if the database has no downsampling
+// rules so that every policy is read.
+//
+// Rollup statements read from the downsampled policy with the oldest rule that
+// covers the start of their time range, as long as they group by a multiple of
+// the rule's interval and only call the rule's function. Otherwise statements
+// read from the default policy.
+func (db *database) readPolicy(stmt *influxql.SelectStatement, min time.Time, interval time.Duration, now time.Time, rollup bool) *RetentionPolicy {
+	def := db.policies[db.defaultRetentionPolicy]
+	if def == nil {
+		return nil
+	}
+
+	var rules bool
+	rp := def
+	for _, p := range db.policies {
+		d := p.Downsample
+		if d == nil || p == def {
+			continue
+		}
+		rules = true
+		if !rollup {
+			continue
+		}
+
+		// Skip rules that the statement cannot be answered from.
+		if !min.IsZero() && now.Sub(min) < d.After {
+			continue
+		} else if interval == 0 || interval%d.Interval != 0 {
+			continue
+		} else if !callsOnly(stmt, d.function()) {
+			continue
+		}
+
+		if rp == def || d.After > rp.Downsample.After {
+			rp = p
+		}
+	}
+
+	if !rules {
+		return nil
+	}
+	return rp
+}
+
+// callsOnly returns true if every field in a statement only calls the
+// given function and does not read fields directly.
+func callsOnly(stmt *influxql.SelectStatement, name string) bool {
+	var walk func(expr influxql.Expr) bool
+	walk = func(expr influxql.Expr) bool {
+		switch expr := expr.(type) {
+		case *influxql.Call:
+			return strings.ToLower(expr.Name) == name
+		case *influxql.VarRef:
+			return false
+		case *influxql.CastExpr:
+			return walk(expr.Expr)
+		case *influxql.ParenExpr:
+			return walk(expr.Expr)
+		case *influxql.BinaryExpr:
+			return walk(expr.LHS) && walk(expr.RHS)
+		}
+		return true
+	}
+
+	for _, f := range stmt.Fields {
+		if !walk(f.Expr) {
+			return false
+		}
+	}
+	return true
+}
+
+// Resolution represents the retention policy that a rollup statement read
+// from and the interval its points were aggregated over. An interval of zero
+// means the statement read points as they were written.
+type Resolution struct {
+	RetentionPolicy string        `json:"retentionPolicy"`
+	Interval        time.Duration `json:"interval"`
+}
+
+// newResolution returns the resolution of the points in a retention policy.
+func newResolution(rp *RetentionPolicy) *Resolution {
+	r := &Resolution{RetentionPolicy: rp.Name}
+	if rp.Downsample != nil {
+		r.Interval = rp.Downsample.Interval
+	}
+	return r
+}
+
+// downsampleTask represents a run of a downsampling rule over a time range.
+type downsampleTask struct {
+	database   string
+	source     string // default retention policy
+	target     string // downsampled retention policy
+	function   string
+	interval   time.Duration
+	start, end time.Time
+
+	measurements []*downsampleMeasurement
+}
+
+// downsampleMeasurement represents the numeric fields and tag keys of a
+// measurement that are aggregated by a downsampling task.
+type downsampleMeasurement struct {
+	name   string
+	fields []string
+	tags   []string
+}
+
+// Downsample runs every downsampling rule. Points in each database's default
+// retention policy are aggregated into the policies with rules for every
+// interval that ended before now and has not been aggregated yet.
+func (s *Server) Downsample(now time.Time) error {
+	tasks, err := s.downsampleTasks(now)
+	if err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		if err := s.downsample(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downsampleTasks returns a task for each rule with intervals to aggregate.
+func (s *Server) downsampleTasks(now time.Time) ([]*downsampleTask, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var tasks []*downsampleTask
+	for _, db := range s.databases {
+		def := db.policies[db.defaultRetentionPolicy]
+		if def == nil {
+			continue
+		}
+
+		for _, rp := range db.policies {
+			d := rp.Downsample
+			if d == nil || rp == def {
+				continue
+			}
+
+			// Aggregate from the last completed interval, or from the start
+			// of the oldest shard in the default policy.
+			start, end := d.Completed, now.Truncate(d.Interval)
+			if start.IsZero() {
+				for _, sh := range def.Shards {
+					if start.IsZero() || sh.StartTime.Before(start) {
+						start = sh.StartTime
+					}
+				}
+				start = start.Truncate(d.Interval)
+			}
+			if start.IsZero() || !start.Before(end) {
+				continue
+			}
+			t, err := newDownsampleTask(db, def, rp, start, end)
+			if err != nil {
+				return nil, err
+			}
+			tasks = append(tasks, t)
+		}
+	}
+	return tasks, nil
+}
+
+// newDownsampleTask returns a task that aggregates the numeric fields of every
+// measurement in the default policy into a downsampled policy over a time range.
+// The server's lock must be held.
+func newDownsampleTask(db *database, def, rp *RetentionPolicy, start, end time.Time) (*downsampleTask, error) {
+	t := &downsampleTask{
+		database: db.name,
+		source:   def.Name,
+		target:   rp.Name,
+		function: rp.Downsample.function(),
+		interval: rp.Downsample.Interval,
+		start:    start,
+		end:      end,
+	}
+	idx, err := db.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range idx.names {
+		m := &downsampleMeasurement{name: name, tags: db.TagKeys([]string{name})}
+		for _, f := range idx.measurements[name].Fields {
+			if f.Type == influxql.Number {
+				m.fields = append(m.fields, f.Name)
+			}
+		}
+		if len(m.fields) > 0 {
+			t.measurements = append(t.measurements, m)
+		}
+	}
+	return t, nil
+}
+
+// downsample aggregates the measurements in a task and records the end of
+// the task's time range as completed.
+func (s *Server) downsample(t *downsampleTask) error {
+	for _, m := range t.measurements {
+		if _, err := s.downsampleMeasurement(t, m); err != nil {
+			return err
+		}
+	}
+
+	c := &updateDownsampleCommand{Database: t.database, Name: t.target, Completed: t.end}
+	_, err := s.broadcast(updateDownsampleMessageType, c)
+	return err
+}
+
+// downsampleMeasurement aggregates each numeric field in a measurement for
+// every interval and series and writes the aggregates with the same tags.
+// Intervals without any values are skipped. Returns the number of points written.
+func (s *Server) downsampleMeasurement(t *downsampleTask, m *downsampleMeasurement) (int, error) {
+	// Select the aggregate and count of each field.
+	stmt := &influxql.SelectStatement{
+		Source: &influxql.Measurement{Name: m.name},
+		Condition: &influxql.BinaryExpr{
+			Op:  influxql.AND,
+			LHS: &influxql.BinaryExpr{Op: influxql.GTE, LHS: &influxql.VarRef{Val: "time"}, RHS: &influxql.TimeLiteral{Val: t.start}},
+			RHS: &influxql.BinaryExpr{Op: influxql.LT, LHS: &influxql.VarRef{Val: "time"}, RHS: &influxql.TimeLiteral{Val: t.end}},
+		},
+		Dimensions: influxql.Dimensions{{Expr: &influxql.Call{Name: "time", Args: []influxql.Expr{&influxql.DurationLiteral{Val: t.interval}}}}},
+	}
+	for _, k := range m.tags {
+		stmt.Dimensions = append(stmt.Dimensions, &influxql.Dimension{Expr: &influxql.VarRef{Val: k}})
+	}
+	for _, fn := range []string{t.function, "count"} {
+		for _, f := range m.fields {
+			stmt.Fields = append(stmt.Fields, &influxql.Field{Expr: &influxql.Call{Name: fn, Args: []influxql.Expr{&influxql.VarRef{Val: f}}}})
+		}
+	}
+
+	e, q, ch, err := s.planAndExecute(stmt, t.database, nil, QueryOptions{RetentionPolicy: t.source}, nil, true)
+	if err != nil {
+		return 0, err
+	}
+
+	// Convert each row into points for its series.
+	var points []*Point
+	n := len(m.fields)
+	for row := range ch {
+		tags := make(map[string]string)
+		for k, v := range row.Tags {
+			if v != "" {
+				tags[k] = v
+			}
+		}
+
+		for _, values := range row.Values {
+			p := &Point{Name: m.name, Tags: tags, Values: make(map[string]interface{})}
+			p.Timestamp = time.Unix(0, values[0].(int64)*int64(time.Microsecond)).UTC()
+			for i, f := range m.fields {
+				if count, _ := values[1+n+i].(int64); count > 0 && values[1+i] != nil {
+					p.Values[f] = values[1+i]
+				}
+			}
+			if len(p.Values) > 0 {
+				points = append(points, p)
+			}
+		}
+	}
+	if err := e.Err(); err != nil {
+		return 0, err
+	} else if err := q.Err(); err != nil {
+		return 0, err
+	} else if len(points) == 0 {
+		return 0, nil
+	}
+
+	if err := s.WritePoints(t.database, t.target, points); err != nil {
+		return 0, err
+	}
+	return len(points), nil
+}
+
+func (s *Server) applyUpdateDownsample(m *messaging.Message) error {
+	var c updateDownsampleCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Retrieve the policy.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+	rp := db.policies[c.Name]
+	if rp == nil {
+		return ErrRetentionPolicyNotFound
+	} else if rp.Downsample == nil || !c.Completed.After(rp.Downsample.Completed) {
+		return nil
+	}
+
+	// Record the completed intervals.
+	rp.Downsample.Completed = c.Completed
+
+	// Persist to metastore.
+	return s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+}
+
+type updateDownsampleCommand struct {
+	Database  string    `json:"database"`
+	Name      string    `json:"name"`
+	Completed time.Time `json:"completed"`
+}
+
+// StartDownsampling runs the downsampling rules every interval until the
+// server is closed.
+func (s *Server) StartDownsampling(interval time.Duration) error {
+	if interval <= 0 {
+		return ErrInvalidDownsampleInterval
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing == nil {
+		return ErrServerClosed
+	}
+
+	s.wg.Add(1)
+	go s.runDownsampling(interval, s.closing)
+	return nil
+}
+
+// runDownsampling runs the downsampling rules every interval until closing is closed.
+func (s *Server) runDownsampling(interval time.Duration, closing <-chan struct{}) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closing:
+			return
+		case t := <-ticker.C:
+			s.runJob("downsample", jobPriorityHigh, closing, func() error {
+				return s.Downsample(t.UTC())
+			})
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package influxdb
+
+import (
+	"sort"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// WriteReport describes the changes a batch of points would make to the
+// schema of a database if it was written.
+type WriteReport struct {
+	Points          int         `json:"points"`
+	NewMeasurements []string    `json:"newMeasurements,omitempty"`
+	NewFields       []*NewField `json:"newFields,omitempty"`
+	NewSeries       int         `json:"newSeries"`
+	SeriesN         int         `json:"seriesN"` // series in the database after the write
+}
+
+// NewField represents a field that a write would add to a measurement.
+type NewField struct {
+	Measurement string            `json:"measurement"`
+	Name        string            `json:"name"`
+	Type        influxql.DataType `json:"type"`
+}
+
+// ValidatePoints checks a batch of points as WritePoints would without
+// writing them. Points are validated against the point limits, the declared
+// schemas, the tag guards and the types of existing fields, and PointErrors
+// are returned for every invalid point. Returns ErrSeriesQuotaExceeded if the
+// write would exceed the database's series quota. Otherwise returns the
+// series and fields it would create.
+func (s *Server) ValidatePoints(database, retentionPolicy string, points []*Point) (*WriteReport, error) {
+	// Ensure the retention policy exists.
+	var rp *RetentionPolicy
+	var err error
+	if retentionPolicy == "" {
+		rp, err = s.DefaultRetentionPolicy(database)
+	} else {
+		rp, err = s.RetentionPolicy(database, retentionPolicy)
+	}
+	if err != nil {
+		return nil, err
+	} else if rp == nil {
+		return nil, ErrRetentionPolicyNotFound
+	}
+
+	l := s.PointLimits()
+	min, max := s.timestampWindow(database, retentionPolicy, l)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	db := s.databases[database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	} else if _, err := db.loadIndex(); err != nil {
+		return nil, err
+	}
+
+	// Track the measurements, series and fields that the batch creates so
+	// that later points are checked against them as well.
+	report := &WriteReport{Points: len(points)}
+	measurements := make(map[string]bool)
+	series := make(map[string]bool)
+	fields := make(batchFields)
+
+	var errs PointErrors
+	seen := make(map[tagGuardKey]map[string]bool)
+	for i, p := range points {
+		if err := p.validate(l, min, max); err != nil {
+			e := err.(*PointError)
+			e.Index = i
+			errs = append(errs, e)
+			continue
+		} else if err := db.checkSchema(p); err != nil {
+			e := err.(*PointError)
+			e.Index = i
+			errs = append(errs, e)
+			continue
+		}
+
+		// Apply tag guards so rewritten values are counted as they'd be written.
+		p, err := db.guardTags(p, seen)
+		if err != nil {
+			e := err.(*PointError)
+			e.Index = i
+			errs = append(errs, e)
+			continue
+		}
+
+		// Determine if the measurement and series exist.
+		m, ser := db.MeasurementAndSeries(p.Name, p.Tags)
+		if m == nil && !measurements[p.Name] {
+			measurements[p.Name] = true
+			report.NewMeasurements = append(report.NewMeasurements, p.Name)
+		}
+		if key := p.Name + "|" + string(marshalTags(p.Tags)); ser == nil && !series[key] {
+			series[key] = true
+			report.NewSeries++
+		}
+
+		// Check the field types against the measurement and the batch.
+		for _, e := range db.checkFields(p, fields, func(key string, typ influxql.DataType) {
+			report.NewFields = append(report.NewFields, &NewField{Measurement: p.Name, Name: key, Type: typ})
+		}) {
+			e.Index = i
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	// Ensure the new series fit within the database's quota.
+	report.SeriesN = db.seriesN() + report.NewSeries
+	if db.maxSeries > 0 && report.NewSeries > 0 && report.SeriesN > db.maxSeries {
+		return nil, ErrSeriesQuotaExceeded
+	}
+
+	sort.Strings(report.NewMeasurements)
+	sort.Sort(newFields(report.NewFields))
+	return report, nil
+}
+
+// newFields represents a list of new fields sortable by measurement and name.
+type newFields []*NewField
+
+func (a newFields) Len() int { return len(a) }
+func (a newFields) Less(i, j int) bool {
+	if a[i].Measurement != a[j].Measurement {
+		return a[i].Measurement < a[j].Measurement
+	}
+	return a[i].Name < a[j].Name
+}
+func (a newFields) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
@@ -0,0 +1,465 @@
+package influxdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"time"
+	"unsafe"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// pointHeaderSize is the size of the series id and timestamp that prefix
+// an encoded point.
+const pointHeaderSize = 12
+
+// valuesVersion is the first byte of values in the binary encoding.
+// Values written before it was introduced are JSON objects and start with '{'.
+const valuesVersion = 1
+
+// valuesChecksummed is the first byte of values stored in a shard with a
+// checksum. It is followed by the 4-byte CRC-32C of the encoded values and
+// then the values. Values stored before checksums were added have none.
+const valuesChecksummed = 2
+
+// checksumTable is the CRC-32C table used to checksum stored values.
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Type markers of encoded field values.
+const (
+	valueFloat     = 1 // 8-byte IEEE 754 bits
+	valueBool      = 2 // 1 byte
+	valueString    = 3 // uvarint length followed by the bytes
+	valueHistogram = 4 // uvarint number of bounds followed by the 8-byte bounds and counts
+	valueInteger   = 5 // 8-byte two's complement
+	valueUnsigned  = 6 // 8 bytes
+	valueStringRef = 7 // uvarint id of the string in the shard's dictionary
+)
+
+// errInvalidValues is returned when encoded values cannot be decoded.
+var errInvalidValues = errors.New("invalid encoded values")
+
+// marshalPoint encodes a point's series id, timestamp and values.
+func marshalPoint(seriesID uint32, timestamp time.Time, values map[string]interface{}) ([]byte, error) {
+	return appendPoint(nil, seriesID, timestamp, values)
+}
+
+// appendPoint appends the encoding of a point to b so that many points can
+// be encoded into one buffer. The same encoding is published to the broker,
+// replicated to data nodes and, without the header, stored in shards.
+func appendPoint(b []byte, seriesID uint32, timestamp time.Time, values map[string]interface{}) ([]byte, error) {
+	var hdr [pointHeaderSize]byte
+	*(*uint32)(unsafe.Pointer(&hdr[0])) = seriesID
+	*(*int64)(unsafe.Pointer(&hdr[4])) = timestamp.UnixNano()
+	return appendValues(append(b, hdr[:]...), values)
+}
+
+// unmarshalPoint decodes a point encoded by marshalPoint.
+func unmarshalPoint(data []byte) (uint32, time.Time, map[string]interface{}, error) {
+	id, ts, err := unmarshalPointHeader(data)
+	if err != nil {
+		return 0, time.Time{}, nil, err
+	}
+	values, err := unmarshalValues(data[pointHeaderSize:])
+	return id, time.Unix(0, ts), values, err
+}
+
+// unmarshalPointHeader decodes the series id and timestamp of an encoded
+// point without decoding its values.
+func unmarshalPointHeader(data []byte) (seriesID uint32, timestamp int64, err error) {
+	if len(data) < pointHeaderSize {
+		return 0, 0, errInvalidValues
+	}
+	seriesID = *(*uint32)(unsafe.Pointer(&data[0]))
+	timestamp = *(*int64)(unsafe.Pointer(&data[4]))
+	return
+}
+
+// appendValues appends the encoding of field values to b. Each field is
+// encoded as its uvarint-prefixed key, a type marker and the value. Times and
+// durations are encoded as a string and a float, as they were in JSON.
+func appendValues(b []byte, values map[string]interface{}) ([]byte, error) {
+	b = append(b, valuesVersion)
+	for k, v := range values {
+		b = appendUvarint(b, uint64(len(k)))
+		b = append(b, k...)
+
+		switch v := v.(type) {
+		case float64:
+			b = appendFloat(b, v)
+		case int64:
+			b = appendUint64(append(b, valueInteger), uint64(v))
+		case uint64:
+			b = appendUint64(append(b, valueUnsigned), v)
+		case time.Duration:
+			b = appendFloat(b, float64(v))
+		case bool:
+			if v {
+				b = append(b, valueBool, 1)
+			} else {
+				b = append(b, valueBool, 0)
+			}
+		case string:
+			b = appendString(b, v)
+		case time.Time:
+			b = appendString(b, v.Format(time.RFC3339Nano))
+		case *influxql.HistogramValue:
+			b = appendHistogram(b, v)
+		default:
+			return nil, fmt.Errorf("unsupported value: %v", v)
+		}
+	}
+	return b, nil
+}
+
+// appendFloat appends an encoded float value to b.
+func appendFloat(b []byte, v float64) []byte {
+	return appendUint64(append(b, valueFloat), math.Float64bits(v))
+}
+
+// appendUint64 appends 8 big endian bytes to b.
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// appendString appends an encoded string value to b.
+func appendString(b []byte, v string) []byte {
+	b = appendUvarint(append(b, valueString), uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendHistogram appends an encoded histogram value to b.
+func appendHistogram(b []byte, h *influxql.HistogramValue) []byte {
+	b = appendUvarint(append(b, valueHistogram), uint64(len(h.Bounds)))
+	for _, a := range [][]float64{h.Bounds, h.Counts} {
+		for _, v := range a {
+			b = appendUint64(b, math.Float64bits(v))
+		}
+	}
+	return b
+}
+
+// unmarshalHistogram decodes a histogram value encoded by appendHistogram.
+func unmarshalHistogram(data []byte) *influxql.HistogramValue {
+	n, i := binary.Uvarint(data)
+	data = data[i:]
+
+	h := &influxql.HistogramValue{Bounds: make([]float64, n), Counts: make([]float64, n+1)}
+	for _, a := range [][]float64{h.Bounds, h.Counts} {
+		for j := range a {
+			a[j] = math.Float64frombits(binary.BigEndian.Uint64(data))
+			data = data[8:]
+		}
+	}
+	return h
+}
+
+// appendUvarint appends a uvarint to b.
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+// appendChecksum appends values prefixed with their checksum to b.
+func appendChecksum(b, values []byte) []byte {
+	var hdr [5]byte
+	hdr[0] = valuesChecksummed
+	binary.BigEndian.PutUint32(hdr[1:], crc32.Checksum(values, checksumTable))
+	return append(append(b, hdr[:]...), values...)
+}
+
+// verifyChecksum returns stored values without their checksum. Values
+// without a checksum are returned as is. Returns ErrChecksumMismatch if the
+// values do not match their checksum.
+func verifyChecksum(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != valuesChecksummed {
+		return data, nil
+	} else if len(data) < 5 || binary.BigEndian.Uint32(data[1:5]) != crc32.Checksum(data[5:], checksumTable) {
+		return nil, ErrChecksumMismatch
+	}
+	return data[5:], nil
+}
+
+// unmarshalValues decodes field values encoded by appendValues, or as JSON.
+func unmarshalValues(data []byte) (map[string]interface{}, error) {
+	return unmarshalStoredValues(data, nil)
+}
+
+// unmarshalStoredValues decodes field values stored in a shard after
+// verifying their checksum. References to strings in the shard's dictionary
+// are resolved with lookup.
+func unmarshalStoredValues(data []byte, lookup func(id uint64) (string, bool)) (map[string]interface{}, error) {
+	data, err := verifyChecksum(data)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{})
+	if len(data) > 0 && data[0] == '{' {
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, err
+		}
+		return values, nil
+	}
+
+	err = scanValues(data, func(key []byte, typ byte, value []byte) error {
+		switch typ {
+		case valueFloat:
+			values[string(key)] = math.Float64frombits(binary.BigEndian.Uint64(value))
+		case valueInteger:
+			values[string(key)] = int64(binary.BigEndian.Uint64(value))
+		case valueUnsigned:
+			values[string(key)] = binary.BigEndian.Uint64(value)
+		case valueBool:
+			values[string(key)] = value[0] == 1
+		case valueString:
+			values[string(key)] = string(value)
+		case valueHistogram:
+			values[string(key)] = unmarshalHistogram(value)
+		case valueStringRef:
+			id, _ := binary.Uvarint(value)
+			if lookup == nil {
+				return errInvalidValues
+			}
+			s, ok := lookup(id)
+			if !ok {
+				return errInvalidValues
+			}
+			values[string(key)] = s
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// mergeValues returns the encoding of the existing values of a point stored
+// in a shard updated with new values. String references in the existing values
+// are resolved with lookup.
+func mergeValues(prev, data []byte, lookup func(id uint64) (string, bool)) ([]byte, error) {
+	values, err := unmarshalStoredValues(prev, lookup)
+	if err != nil {
+		return nil, err
+	}
+	other, err := unmarshalValues(data)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range other {
+		values[k] = v
+	}
+	return appendValues(nil, values)
+}
+
+// mergeDuplicate returns the encoding of the existing values of a point
+// combined with the values of a point written at the same timestamp under a
+// duplicate policy. Numeric fields stored with the same type in both points
+// are summed or compared. Other fields take the new value.
+func mergeDuplicate(policy string, prev, data []byte, lookup func(id uint64) (string, bool)) ([]byte, error) {
+	values, err := unmarshalStoredValues(prev, lookup)
+	if err != nil {
+		return nil, err
+	}
+	other, err := unmarshalValues(data)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range other {
+		values[k] = combineValue(policy, values[k], v)
+	}
+	return appendValues(nil, values)
+}
+
+// combineValue returns the value of a field under a duplicate policy.
+func combineValue(policy string, prev, v interface{}) interface{} {
+	switch v := v.(type) {
+	case float64:
+		if p, ok := prev.(float64); ok {
+			switch policy {
+			case DuplicateSum:
+				return p + v
+			case DuplicateMin:
+				return math.Min(p, v)
+			case DuplicateMax:
+				return math.Max(p, v)
+			}
+		}
+	case int64:
+		if p, ok := prev.(int64); ok {
+			switch policy {
+			case DuplicateSum:
+				return p + v
+			case DuplicateMin:
+				if p < v {
+					return p
+				}
+			case DuplicateMax:
+				if p > v {
+					return p
+				}
+			}
+		}
+	case uint64:
+		if p, ok := prev.(uint64); ok {
+			switch policy {
+			case DuplicateSum:
+				return p + v
+			case DuplicateMin:
+				if p < v {
+					return p
+				}
+			case DuplicateMax:
+				if p > v {
+					return p
+				}
+			}
+		}
+	}
+	return v
+}
+
+// internStrings returns encoded values with each string replaced by the
+// reference returned by intern. Strings are left inline if intern returns
+// false. Values encoded as JSON are returned unchanged.
+func internStrings(data []byte, intern func(s []byte) (uint64, bool, error)) ([]byte, error) {
+	if len(data) > 0 && data[0] == '{' {
+		return data, nil
+	}
+
+	b := make([]byte, 1, len(data))
+	b[0] = valuesVersion
+	err := scanValues(data, func(key []byte, typ byte, value []byte) error {
+		b = appendUvarint(b, uint64(len(key)))
+		b = append(b, key...)
+
+		switch typ {
+		case valueString:
+			id, ok, err := intern(value)
+			if err != nil {
+				return err
+			} else if ok {
+				b = appendUvarint(append(b, valueStringRef), id)
+			} else {
+				b = appendUvarint(append(b, valueString), uint64(len(value)))
+				b = append(b, value...)
+			}
+		default:
+			b = append(append(b, typ), value...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// valueTypes calls fn with the key and data type of each encoded field value
+// without decoding the values. Stored values are checked against their checksum.
+func valueTypes(data []byte, fn func(key string, typ influxql.DataType) error) error {
+	data, err := verifyChecksum(data)
+	if err != nil {
+		return err
+	}
+
+	if len(data) > 0 && data[0] == '{' {
+		values, err := unmarshalValues(data)
+		if err != nil {
+			return err
+		}
+		for k, v := range values {
+			if err := fn(k, influxql.InspectDataType(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return scanValues(data, func(key []byte, typ byte, _ []byte) error {
+		switch typ {
+		case valueFloat:
+			return fn(string(key), influxql.Number)
+		case valueInteger:
+			return fn(string(key), influxql.Integer)
+		case valueUnsigned:
+			return fn(string(key), influxql.Unsigned)
+		case valueBool:
+			return fn(string(key), influxql.Boolean)
+		case valueHistogram:
+			return fn(string(key), influxql.Histogram)
+		default: // valueString, valueStringRef
+			return fn(string(key), influxql.String)
+		}
+	})
+}
+
+// scanValues calls fn with the key, type marker and value bytes of each
+// field in values encoded by appendValues. The slices are only valid until
+// fn returns.
+func scanValues(data []byte, fn func(key []byte, typ byte, value []byte) error) error {
+	if len(data) == 0 || data[0] != valuesVersion {
+		return errInvalidValues
+	}
+	data = data[1:]
+
+	for len(data) > 0 {
+		// Read the key.
+		n, i := binary.Uvarint(data)
+		if i <= 0 || uint64(len(data)-i) < n+1 {
+			return errInvalidValues
+		}
+		key := data[i : i+int(n)]
+		typ := data[i+int(n)]
+		data = data[i+int(n)+1:]
+
+		// Read the value.
+		var value []byte
+		switch typ {
+		case valueFloat, valueInteger, valueUnsigned:
+			if len(data) < 8 {
+				return errInvalidValues
+			}
+			value, data = data[:8], data[8:]
+		case valueBool:
+			if len(data) < 1 {
+				return errInvalidValues
+			}
+			value, data = data[:1], data[1:]
+		case valueString:
+			n, i := binary.Uvarint(data)
+			if i <= 0 || uint64(len(data)-i) < n {
+				return errInvalidValues
+			}
+			value, data = data[i:i+int(n)], data[i+int(n):]
+		case valueStringRef:
+			_, i := binary.Uvarint(data)
+			if i <= 0 {
+				return errInvalidValues
+			}
+			value, data = data[:i], data[i:]
+		case valueHistogram:
+			n, i := binary.Uvarint(data)
+			if i <= 0 || n > uint64(len(data)) || uint64(len(data)-i) < (2*n+1)*8 {
+				return errInvalidValues
+			}
+			size := i + int(2*n+1)*8
+			value, data = data[:size], data[size:]
+		default:
+			return errInvalidValues
+		}
+
+		if err := fn(key, typ, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package influxdb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Ensure points can be encoded and decoded.
+func TestMarshalPoint(t *testing.T) {
+	timestamp := time.Unix(0, 1000000000)
+	values := map[string]interface{}{
+		"float":     1.5,
+		"integer":   int64(-9223372036854775808),
+		"unsigned":  uint64(18446744073709551615),
+		"bool":      true,
+		"string":    "foo",
+		"empty":     "",
+		"time":      time.Unix(10, 0).UTC(),
+		"duration":  2 * time.Second,
+		"histogram": &influxql.HistogramValue{Bounds: []float64{1, 2}, Counts: []float64{3, 4, 5}},
+	}
+
+	data, err := marshalPoint(100, timestamp, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, ts, other, err := unmarshalPoint(data)
+	if err != nil {
+		t.Fatal(err)
+	} else if id != 100 {
+		t.Fatalf("unexpected id: %d", id)
+	} else if !ts.Equal(timestamp) {
+		t.Fatalf("unexpected timestamp: %s", ts)
+	}
+
+	// Times and durations are read back as strings and numbers.
+	exp := map[string]interface{}{
+		"float":     1.5,
+		"integer":   int64(-9223372036854775808),
+		"unsigned":  uint64(18446744073709551615),
+		"bool":      true,
+		"string":    "foo",
+		"empty":     "",
+		"time":      "1970-01-01T00:00:10Z",
+		"duration":  float64(2 * time.Second),
+		"histogram": &influxql.HistogramValue{Bounds: []float64{1, 2}, Counts: []float64{3, 4, 5}},
+	}
+	if !reflect.DeepEqual(other, exp) {
+		t.Fatalf("unexpected values: %#v", other)
+	}
+}
+
+// Ensure multiple points can be appended to the same buffer.
+func TestAppendPoint(t *testing.T) {
+	var b []byte
+	var offsets []int
+	for i := 0; i < 3; i++ {
+		var err error
+		offsets = append(offsets, len(b))
+		if b, err = appendPoint(b, uint32(i), time.Unix(0, int64(i)), map[string]interface{}{"value": float64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	offsets = append(offsets, len(b))
+
+	for i := 0; i < 3; i++ {
+		id, ts, values, err := unmarshalPoint(b[offsets[i]:offsets[i+1]])
+		if err != nil {
+			t.Fatal(err)
+		} else if id != uint32(i) || ts.UnixNano() != int64(i) || values["value"] != float64(i) {
+			t.Fatalf("%d. unexpected point: %d, %s, %v", i, id, ts, values)
+		}
+	}
+}
+
+// Ensure values written as JSON before the binary encoding can be decoded.
+func TestUnmarshalValues_JSON(t *testing.T) {
+	values, err := unmarshalValues([]byte(`{"value":100,"host":"serverA"}`))
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(values, map[string]interface{}{"value": float64(100), "host": "serverA"}) {
+		t.Fatalf("unexpected values: %#v", values)
+	}
+}
+
+// Ensure invalid values return an error.
+func TestUnmarshalValues_Invalid(t *testing.T) {
+	data, err := appendValues(nil, map[string]interface{}{"host": "serverA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the version byte and the full encoding are valid.
+	for i := 0; i < len(data); i++ {
+		if _, err := unmarshalValues(data[:i]); i != 1 && err != errInvalidValues {
+			t.Fatalf("%d. unexpected error: %v", i, err)
+		}
+	}
+	if _, err := unmarshalValues([]byte{valuesVersion, 1, 'x', 9}); err != errInvalidValues {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure truncated histograms cannot be decoded.
+func TestUnmarshalValues_InvalidHistogram(t *testing.T) {
+	data, err := appendValues(nil, map[string]interface{}{"latency": &influxql.HistogramValue{Bounds: []float64{1}, Counts: []float64{2, 3}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 2; i < len(data); i++ {
+		if _, err := unmarshalValues(data[:i]); err != errInvalidValues {
+			t.Fatalf("%d. unexpected error: %v", i, err)
+		}
+	}
+	if _, err := unmarshalValues([]byte{valuesVersion, 1, 'x', valueHistogram, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}); err != errInvalidValues {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure unsupported values cannot be encoded.
+func TestAppendValues_Unsupported(t *testing.T) {
+	if _, err := appendValues(nil, map[string]interface{}{"value": 100}); err == nil || err.Error() != "unsupported value: 100" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure values can be merged.
+func TestMergeValues(t *testing.T) {
+	prev, _ := appendValues(nil, map[string]interface{}{"a": 1.0, "b": "x"})
+	data, _ := appendValues(nil, map[string]interface{}{"b": "y", "c": true})
+
+	merged, err := mergeValues(prev, data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := unmarshalValues(merged)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(values, map[string]interface{}{"a": 1.0, "b": "y", "c": true}) {
+		t.Fatalf("unexpected values: %#v", values)
+	}
+}
+
+// Ensure duplicate values are combined by policy.
+func TestMergeDuplicate(t *testing.T) {
+	prev, _ := appendValues(nil, map[string]interface{}{"f": 2.0, "i": int64(2), "u": uint64(2), "s": "x", "only": true})
+	data, _ := appendValues(nil, map[string]interface{}{"f": 1.0, "i": int64(5), "u": uint64(1), "s": "y"})
+
+	for i, tt := range []struct {
+		policy string
+		exp    map[string]interface{}
+	}{
+		{policy: DuplicateSum, exp: map[string]interface{}{"f": 3.0, "i": int64(7), "u": uint64(3), "s": "y", "only": true}},
+		{policy: DuplicateMin, exp: map[string]interface{}{"f": 1.0, "i": int64(2), "u": uint64(1), "s": "y", "only": true}},
+		{policy: DuplicateMax, exp: map[string]interface{}{"f": 2.0, "i": int64(5), "u": uint64(2), "s": "y", "only": true}},
+	} {
+		merged, err := mergeDuplicate(tt.policy, prev, data, nil)
+		if err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		}
+		if values, err := unmarshalValues(merged); err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		} else if !reflect.DeepEqual(values, tt.exp) {
+			t.Errorf("%d. unexpected values: %#v", i, values)
+		}
+	}
+
+	// Fields of different types take the new value.
+	prev, _ = appendValues(nil, map[string]interface{}{"value": int64(2)})
+	data, _ = appendValues(nil, map[string]interface{}{"value": 1.5})
+	merged, _ := mergeDuplicate(DuplicateSum, prev, data, nil)
+	if values, _ := unmarshalValues(merged); !reflect.DeepEqual(values, map[string]interface{}{"value": 1.5}) {
+		t.Fatalf("unexpected values: %#v", values)
+	}
+}
+
+// Ensure strings can be replaced by references and resolved again.
+func TestInternStrings(t *testing.T) {
+	dict := []string{"zero"}
+	intern := func(s []byte) (uint64, bool, error) {
+		if string(s) == "inline" {
+			return 0, false, nil
+		}
+		dict = append(dict, string(s))
+		return uint64(len(dict) - 1), true, nil
+	}
+	lookup := func(id uint64) (string, bool) {
+		if id >= uint64(len(dict)) {
+			return "", false
+		}
+		return dict[id], true
+	}
+
+	data, _ := appendValues(nil, map[string]interface{}{"a": 1.0, "b": "disk full", "c": "inline", "d": int64(2)})
+	interned, err := internStrings(data, intern)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(interned) >= len(data) {
+		t.Fatalf("unexpected size: %d >= %d", len(interned), len(data))
+	}
+
+	values, err := unmarshalStoredValues(interned, lookup)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(values, map[string]interface{}{"a": 1.0, "b": "disk full", "c": "inline", "d": int64(2)}) {
+		t.Fatalf("unexpected values: %#v", values)
+	}
+
+	// References can't be resolved without the dictionary.
+	if _, err := unmarshalValues(interned); err != errInvalidValues {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dict = dict[:1]
+	if _, err := unmarshalStoredValues(interned, lookup); err != errInvalidValues {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure stored values are checked against their checksum.
+func TestVerifyChecksum(t *testing.T) {
+	data, _ := appendValues(nil, map[string]interface{}{"a": 1.0, "b": "x"})
+	stored := appendChecksum(nil, data)
+
+	values, err := unmarshalStoredValues(stored, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(values, map[string]interface{}{"a": 1.0, "b": "x"}) {
+		t.Fatalf("unexpected values: %#v", values)
+	}
+
+	// Values stored without a checksum are still readable.
+	if other, err := verifyChecksum(data); err != nil || !reflect.DeepEqual(other, data) {
+		t.Fatalf("unexpected values: %v (%v)", other, err)
+	}
+
+	// Flip a bit in the values and truncate the checksum.
+	corrupt := append([]byte(nil), stored...)
+	corrupt[len(corrupt)-1] ^= 1
+	if _, err := unmarshalStoredValues(corrupt, nil); err != ErrChecksumMismatch {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := valueTypes(corrupt, func(string, influxql.DataType) error { return nil }); err != ErrChecksumMismatch {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := verifyChecksum(stored[:3]); err != ErrChecksumMismatch {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the types of values can be read without decoding them.
+func TestValueTypes(t *testing.T) {
+	data, _ := appendValues(nil, map[string]interface{}{"a": 1.0, "b": "x", "c": false, "d": &influxql.HistogramValue{Counts: []float64{1}}, "e": int64(1), "f": uint64(1)})
+
+	types := make(map[string]influxql.DataType)
+	if err := valueTypes(data, func(key string, typ influxql.DataType) error {
+		types[key] = typ
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(types, map[string]influxql.DataType{"a": influxql.Number, "b": influxql.String, "c": influxql.Boolean, "d": influxql.Histogram, "e": influxql.Integer, "f": influxql.Unsigned}) {
+		t.Fatalf("unexpected types: %v", types)
+	}
+}
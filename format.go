@@ -0,0 +1,221 @@
+package influxdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/boltdb/bolt"
+)
+
+// Versions of the on-disk formats written by this node. Each store records
+// the version of the format it was written in and the oldest version that
+// can read it, so a node still opens stores written in a newer format as
+// long as that format is compatible with it. This lets nodes on adjacent
+// versions share a cluster, and be rolled back, during a rolling upgrade.
+//
+// A node reads stores in older formats as they are and never moves them to
+// a newer format itself. "influxd upgrade" migrates them once no node that
+// can't read the newer format remains.
+const (
+	// MetaFormat is the version of the metastore format.
+	MetaFormat = 1
+
+	// ShardFormat is the version of the shard format. Version 1 stores
+	// every block of values in the binary encoding with a checksum.
+	ShardFormat = 1
+)
+
+// The oldest versions that can read the formats written by this node.
+const (
+	metaMinReader  = 1
+	shardMinReader = 1
+)
+
+// storeFormat represents the format of a metastore or shard. Stores written
+// before formats were versioned are version zero.
+type storeFormat struct {
+	version   int // version that the store was written in
+	minReader int // oldest version that can read the store
+}
+
+// readFormat returns the format recorded in a store's bucket.
+func readFormat(b *bolt.Bucket) storeFormat {
+	v := b.Get([]byte("format"))
+	if len(v) != 16 {
+		return storeFormat{}
+	}
+	return storeFormat{version: int(btou64(v[:8])), minReader: int(btou64(v[8:]))}
+}
+
+// writeFormat records a store's format in its bucket.
+func writeFormat(b *bolt.Bucket, f storeFormat) error {
+	return b.Put([]byte("format"), append(u64tob(uint64(f.version)), u64tob(uint64(f.minReader))...))
+}
+
+// check returns an error if a node that reads up to version can't read the
+// store.
+func (f storeFormat) check(version int) error {
+	if f.minReader > version {
+		return fmt.Errorf("format version %d requires version %d or later, this node reads up to %d", f.version, f.minReader, version)
+	}
+	return nil
+}
+
+// metaMigrations move a metastore from each format version to the next. The
+// migration at index i moves it from version i to i+1.
+var metaMigrations = []func(tx *bolt.Tx) (string, error){
+	// Version 1 only records the format.
+	func(tx *bolt.Tx) (string, error) { return "", nil },
+}
+
+// shardMigrations move a shard from each format version to the next. The
+// migration at index i moves it from version i to i+1.
+var shardMigrations = []func(tx *bolt.Tx) (string, error){
+	upgradeShardValues,
+}
+
+// upgradeShardValues rewrites the blocks of values in a shard that are
+// stored as JSON or without a checksum in the binary encoding with a
+// checksum. Strings are added to the shard's dictionary.
+func upgradeShardValues(tx *bolt.Tx) (string, error) {
+	values := tx.Bucket([]byte("values"))
+	if values == nil {
+		return "", nil
+	}
+	dict := newStringDictionary(tx)
+
+	// Blocks are rewritten after each series is read as a bucket can't be
+	// changed while it's being iterated.
+	var ids [][]byte
+	_ = values.ForEach(func(k, _ []byte) error {
+		ids = append(ids, append([]byte(nil), k...))
+		return nil
+	})
+
+	var n int
+	for _, id := range ids {
+		b := values.Bucket(id)
+		if b == nil {
+			continue
+		}
+
+		var keys, blocks [][]byte
+		if err := b.ForEach(func(k, v []byte) error {
+			if len(v) > 0 && v[0] == valuesChecksummed {
+				return nil
+			}
+			m, err := unmarshalStoredValues(v, dict.lookup)
+			if err != nil {
+				return fmt.Errorf("series %d: point %d: %s", btou32(id), int64(btou64(k)), err)
+			}
+			data, err := appendValues(nil, m)
+			if err != nil {
+				return fmt.Errorf("series %d: point %d: %s", btou32(id), int64(btou64(k)), err)
+			}
+			if data, err = internStrings(data, dict.intern); err != nil {
+				return err
+			}
+			keys = append(keys, append([]byte(nil), k...))
+			blocks = append(blocks, appendChecksum(nil, data))
+			return nil
+		}); err != nil {
+			return "", err
+		}
+
+		for i := range keys {
+			if err := b.Put(keys[i], blocks[i]); err != nil {
+				return "", err
+			}
+		}
+		n += len(keys)
+	}
+
+	if n == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("rewrote %d blocks", n), nil
+}
+
+// migrateFormat moves a store from format f to version with migrations and
+// records the new format in b. Returns a description of each change.
+func migrateFormat(tx *bolt.Tx, b *bolt.Bucket, f storeFormat, migrations []func(*bolt.Tx) (string, error), version, minReader int) ([]string, error) {
+	changes := []string{fmt.Sprintf("format %d -> %d", f.version, version)}
+	for v := f.version; v < version; v++ {
+		change, err := migrations[v](tx)
+		if err != nil {
+			return nil, err
+		} else if change != "" {
+			changes = append(changes, change)
+		}
+	}
+	return changes, writeFormat(b, storeFormat{version: version, minReader: minReader})
+}
+
+// errUpgradeDryRun rolls back the changes of an upgrade that is only reported.
+var errUpgradeDryRun = errors.New("upgrade dry run")
+
+// Upgrade migrates the metastore and every shard to the formats written by
+// this node. Stores already in the current format are left as they are. If
+// dryRun is set then the changes are only reported. Returns a description
+// of each change, or an error if a store is in a format that is too new.
+//
+// Only upgrade once every node in the cluster runs a version that can read
+// the new formats.
+func (i *Inspector) Upgrade(dryRun bool) ([]string, error) {
+	if !i.writable {
+		return nil, fmt.Errorf("inspector is read-only")
+	}
+
+	var changes []string
+	report := newReporter(&changes)
+	upgrade := func(name string, db *bolt.DB, bucket string, migrations []func(*bolt.Tx) (string, error), version, minReader int) error {
+		err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+			if err != nil {
+				return err
+			}
+			f := readFormat(b)
+			if err := f.check(version); err != nil {
+				return err
+			} else if f.version >= version {
+				return nil
+			}
+			a, err := migrateFormat(tx, b, f, migrations, version, minReader)
+			if err != nil {
+				return err
+			}
+			for _, change := range a {
+				report("%s: %s", name, change)
+			}
+			if dryRun {
+				return errUpgradeDryRun
+			}
+			return nil
+		})
+		if err != nil && err != errUpgradeDryRun {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		return nil
+	}
+
+	if err := upgrade("meta", i.meta.db, "Server", metaMigrations, MetaFormat, metaMinReader); err != nil {
+		return nil, err
+	}
+	for _, db := range i.databases {
+		for _, id := range db.shardIDs() {
+			st, err := i.openShard(id)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("shard %d: %s", id, err)
+			}
+			err = upgrade(fmt.Sprintf("shard %d", id), st, "meta", shardMigrations, ShardFormat, shardMinReader)
+			_ = st.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,72 @@
+package influxdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+// Ensure line protocol parsing doesn't panic on arbitrary input.
+func FuzzParseLine(f *testing.F) {
+	for _, s := range []string{
+		`cpu value=1`,
+		`cpu,host=servera,region=uswest value=1.5,ok=true,msg="hello" 946684800000000000`,
+		`cpu\ load,host=server\,a value=-1i`,
+		`cpu value=1 946684800000000000` + "\n" + `mem free=100i`,
+		`cpu,host= value=`,
+	} {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ParseLineBytes(data, NanosecondPrecision, time.Unix(0, 0))
+	})
+}
+
+// Ensure JSON series parsing doesn't panic on arbitrary input.
+func FuzzParseJSON(f *testing.F) {
+	for _, s := range []string{
+		`[{"name":"cpu","columns":["value"],"points":[[1]]}]`,
+		`[{"name":"cpu","tags":{"host":"servera"},"columns":["time","value","ok"],"points":[[946684800,1.5,true],[946684801,2,false]]}]`,
+		`[{"name":"cpu","columns":["value"],"points":[[1,2]]}]`,
+		`[{"name":"","columns":[],"points":[]}]`,
+	} {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var ss []*serializedSeries
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&ss); err != nil {
+			return
+		}
+		serializedSeriesSlice(ss).points(SecondPrecision)
+	})
+}
+
+// Ensure protobuf series parsing doesn't panic on arbitrary input.
+func FuzzParseProtobuf(f *testing.F) {
+	var series []byte
+	series = appendProtobufBytes(series, 1, []byte("cpu"))
+	series = appendProtobufBytes(series, 2, appendProtobufBytes(appendProtobufBytes(nil, 1, []byte("host")), 2, []byte("servera")))
+	series = appendProtobufBytes(series, 3, []byte("value"))
+	for i, v := range []float64{100, 20} {
+		var pt []byte
+		pt = appendProtobufVarint(pt, 1, uint64(946684800000000000+i*1000000000))
+		pt = appendProtobufBytes(pt, 2, appendProtobufFixed64(nil, 1, math.Float64bits(v)))
+		series = appendProtobufBytes(series, 4, pt)
+	}
+	req := appendProtobufBytes(nil, 1, series)
+	f.Add(req)
+	f.Add(req[:len(req)-3])
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ss, err := unmarshalProtobufSeries(data)
+		if err != nil {
+			return
+		}
+		serializedSeriesSlice(ss).points(NanosecondPrecision)
+	})
+}
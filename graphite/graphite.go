@@ -14,6 +14,9 @@ const (
 
 	// DefaultGraphiteNameSeparator represents the default Graphite field separator.
 	DefaultGraphiteNameSeparator = "."
+
+	// DefaultGraphitePrecision represents the default unit of Graphite timestamps.
+	DefaultGraphitePrecision = time.Millisecond
 )
 
 var (
@@ -44,15 +47,28 @@ type Metric struct {
 	Timestamp time.Time
 }
 
+// Values returns the metric as field values for writing.
+// Integer values are converted to floats since numeric fields are stored as floats.
+func (m *Metric) Values() map[string]interface{} {
+	v := m.Value
+	if i, ok := v.(int64); ok {
+		v = float64(i)
+	}
+	return map[string]interface{}{m.Name: v}
+}
+
 // Parser encapulates a Graphite Parser.
 type Parser struct {
 	Separator   string
 	LastEnabled bool
+
+	// The unit of parsed timestamps.
+	Precision time.Duration
 }
 
 // NewParser returns a GraphiteParser instance.
 func NewParser() *Parser {
-	return &Parser{Separator: DefaultGraphiteNameSeparator}
+	return &Parser{Separator: DefaultGraphiteNameSeparator, Precision: DefaultGraphitePrecision}
 }
 
 // Parse performs Graphite parsing of a single line.
@@ -91,7 +107,7 @@ func (p *Parser) Parse(line string) (*Metric, error) {
 		return nil, err
 	}
 
-	m.Timestamp = time.Unix(0, unixTime*int64(time.Millisecond))
+	m.Timestamp = time.Unix(0, unixTime*int64(p.Precision))
 
 	return m, nil
 }
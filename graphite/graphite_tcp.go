@@ -70,11 +70,7 @@ func (t *TCPServer) handleConnection(conn net.Conn) {
 			continue
 		}
 
-		// Convert metric to a field value.
-		var values = make(map[string]interface{})
-		values[metric.Name] = metric.Value
-
 		// Send the data to database
-		t.writer.WriteSeries(t.Database, "", metric.Name, metric.Tags, metric.Timestamp, values)
+		t.writer.WriteSeries(t.Database, "", metric.Name, metric.Tags, metric.Timestamp, metric.Values())
 	}
 }
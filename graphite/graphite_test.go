@@ -1,6 +1,7 @@
 package graphite_test
 
 import (
+	"reflect"
 	"strconv"
 	"testing"
 	"time"
@@ -241,6 +242,29 @@ func Test_DecodeMetric(t *testing.T) {
 	}
 }
 
+func Test_Metric_Values(t *testing.T) {
+	p := graphite.NewParser()
+
+	m, err := p.Parse(`cpu 50 946684800`)
+	if err != nil {
+		t.Fatal(err)
+	} else if v := m.Values(); !reflect.DeepEqual(v, map[string]interface{}{"cpu": 50.0}) {
+		t.Fatalf("unexpected values: %#v", v)
+	}
+}
+
+func Test_DecodeMetric_Precision(t *testing.T) {
+	p := graphite.NewParser()
+	p.Precision = time.Second
+
+	m, err := p.Parse(`cpu 50 946684800`)
+	if err != nil {
+		t.Fatal(err)
+	} else if !m.Timestamp.Equal(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected timestamp: %s", m.Timestamp)
+	}
+}
+
 // Test Helpers
 func errstr(err error) string {
 	if err != nil {
@@ -58,12 +58,8 @@ func (u *UDPServer) ListenAndServe(iface string) error {
 					continue
 				}
 
-				// Convert metric to a field value.
-				var values = make(map[string]interface{})
-				values[m.Name] = m.Value
-
 				// Send the data to database
-				u.writer.WriteSeries(u.Database, "", m.Name, m.Tags, m.Timestamp, values)
+				u.writer.WriteSeries(u.Database, "", m.Name, m.Tags, m.Timestamp, m.Values())
 			}
 		}
 	}()
@@ -1,16 +1,33 @@
 package influxdb
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/bmizerany/pat"
 	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/pipeql"
 )
 
 // TODO: Standard response headers (see: HeaderHandler)
@@ -46,6 +63,20 @@ func getUsernameAndPassword(r *http.Request) (string, string, error) {
 	return fields[0], fields[1], nil
 }
 
+// hasCredentials returns true if the request includes a username and password
+// or an Authorization header. Credentials take precedence over a session.
+func hasCredentials(r *http.Request) bool {
+	return r.URL.Query().Get("u") != "" || r.Header.Get("Authorization") != ""
+}
+
+// getToken returns the API token from the "Authorization: Token" header, if any.
+func getToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Token ") {
+		return strings.TrimSpace(auth[len("Token "):])
+	}
+	return ""
+}
+
 // Handler represents an HTTP handler for the InfluxDB server.
 type Handler struct {
 	server *Server
@@ -54,38 +85,112 @@ type Handler struct {
 	// Whether endpoints require authentication.
 	AuthenticationEnabled bool
 
+	// Verifies credentials when authentication is enabled.
+	// Defaults to the server's user store.
+	Authenticator Authenticator
+
+	// Whether the metrics endpoint requires an admin user when authentication
+	// is enabled. Metrics can be scraped without credentials by default.
+	MetricsAuthenticationEnabled bool
+
+	// Networks that can use the user, shard and data node endpoints.
+	// All networks are allowed if nil.
+	AdminAccess *AccessList
+
+	// Request limits for users.
+	Limits  UserLimits
+	limiter *rateLimiter
+
+	// How timestamps are assigned to written points.
+	Timestamps TimestampPolicy
+
+	// Length of time a login session can go unused before it expires.
+	SessionIdleTimeout time.Duration
+
+	// Whether session cookies are only sent over HTTPS. Cookies are always
+	// secure on TLS connections. Set this when TLS is terminated by a proxy.
+	SecureCookies bool
+
+	sessions *sessionStore
+
 	// The InfluxDB verion returned by the HTTP response header.
 	Version string
+
+	// The logging interface used by the handler for request logs.
+	Logger *log.Logger
 }
 
 // NewHandler returns a new instance of Handler.
 func NewHandler(s *Server) *Handler {
 	h := &Handler{
-		server: s,
-		mux:    pat.New(),
+		server:        s,
+		mux:           pat.New(),
+		Authenticator: s,
+		limiter:       newRateLimiter(),
+		Logger:        log.New(os.Stderr, "[http] ", log.LstdFlags),
+
+		SessionIdleTimeout: DefaultSessionIdleTimeout,
+		sessions:           newSessionStore(),
 	}
 
 	// Authentication route
 	h.mux.Get("/authenticate", http.HandlerFunc(h.serveAuthenticate))
 
+	// Session routes.
+	h.mux.Post("/login", http.HandlerFunc(h.serveLogin))
+	h.mux.Post("/logout", http.HandlerFunc(h.serveLogout))
+	h.mux.Get("/session", http.HandlerFunc(h.serveSession))
+
 	// User routes.
-	h.mux.Get("/users", h.makeAuthenticationHandler(h.serveUsers))
-	h.mux.Post("/users", http.HandlerFunc(h.serveCreateUser)) // Non-standard authentication
-	h.mux.Put("/users/:user", h.makeAuthenticationHandler(h.serveUpdateUser))
-	h.mux.Del("/users/:user", h.makeAuthenticationHandler(h.serveDeleteUser))
+	h.mux.Get("/users", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveUsers)))
+	h.mux.Post("/users", h.makeAdminHandler(h.serveCreateUser)) // Non-standard authentication
+	h.mux.Put("/users/:user", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveUpdateUser)))
+	h.mux.Del("/users/:user", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveDeleteUser)))
+	h.mux.Get("/users/:user/tokens", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveTokens)))
+	h.mux.Post("/users/:user/tokens", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveCreateToken)))
+	h.mux.Del("/users/:user/tokens/:id", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveDeleteToken)))
 
 	// Database routes
 	h.mux.Get("/db", h.makeAuthenticationHandler(h.serveDatabases))
 	h.mux.Post("/db", h.makeAuthenticationHandler(h.serveCreateDatabase))
+	h.mux.Put("/db/:name", h.makeAuthenticationHandler(h.serveUpdateDatabase))
 	h.mux.Del("/db/:name", h.makeAuthenticationHandler(h.serveDeleteDatabase))
+	h.mux.Post("/db/:name/provision", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveProvisionDatabase)))
+
+	// Query routes.
+	h.mux.Get("/query", h.makeAuthenticationHandler(h.serveQuery))
+	h.mux.Post("/query", h.readQueryBody(h.makeAuthenticationHandler(h.serveQuery)))
+
+	// Experimental pipe query language routes.
+	h.mux.Get("/query2", h.makeAuthenticationHandler(h.serveQuery2))
+	h.mux.Post("/query2", h.makeAuthenticationHandler(h.serveQuery2))
 
 	// Series routes.
 	h.mux.Get("/db/:db/series", h.makeAuthenticationHandler(h.serveQuery))
 	h.mux.Post("/db/:db/series", h.makeAuthenticationHandler(h.serveWriteSeries))
 
+	// WebSocket route for running queries and tailing measurements.
+	h.mux.Get("/db/:db/ws", h.makeAuthenticationHandler(h.serveWebSocket))
+
+	// Server-sent events route for tailing a measurement.
+	h.mux.Get("/stream", h.makeAuthenticationHandler(h.serveStream))
+
+	// Prometheus remote storage routes.
+	h.mux.Post("/api/v1/prom/write", h.makeAuthenticationHandler(h.servePromWrite))
+	h.mux.Post("/api/v1/prom/read", h.makeAuthenticationHandler(h.servePromRead))
+
+	// Annotation routes.
+	h.mux.Get("/annotations", h.makeAuthenticationHandler(h.serveAnnotations))
+	h.mux.Post("/annotations", h.makeAuthenticationHandler(h.serveWriteAnnotations))
+
 	// Shard routes.
-	h.mux.Get("/db/:db/shards", h.makeAuthenticationHandler(h.serveShards))
-	h.mux.Del("/db/:db/shards/:id", h.makeAuthenticationHandler(h.serveDeleteShard))
+	h.mux.Get("/db/:db/shards", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveShards)))
+	h.mux.Del("/db/:db/shards/:id", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveDeleteShard)))
+	h.mux.Get("/db/:db/shards/:id/blocks", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveBlock)))
+
+	// Deletion routes.
+	h.mux.Get("/db/:db/deletions", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveDeletions)))
+	h.mux.Post("/db/:db/deletions/purge", h.makeAdminHandler(h.makeAuthenticationHandler(h.servePurgeDeletions)))
 
 	// Retention policy routes.
 	h.mux.Get("/db/:db/retention_policies", h.makeAuthenticationHandler(h.serveRetentionPolicies))
@@ -93,23 +198,77 @@ func NewHandler(s *Server) *Handler {
 	h.mux.Put("/db/:db/retention_policies/:name", h.makeAuthenticationHandler(h.serveUpdateRetentionPolicy))
 	h.mux.Del("/db/:db/retention_policies/:name", h.makeAuthenticationHandler(h.serveDeleteRetentionPolicy))
 
+	// Backfill routes.
+	h.mux.Get("/backfills", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveBackfills)))
+	h.mux.Post("/db/:db/retention_policies/:name/backfill", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveCreateBackfill)))
+
+	// Background job routes.
+	h.mux.Get("/jobs", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveJobs)))
+
+	// Measurement schema routes.
+	h.mux.Get("/db/:db/schemas", h.makeAuthenticationHandler(h.serveMeasurementSchemas))
+	h.mux.Put("/db/:db/schemas/:name", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveSetMeasurementSchema)))
+	h.mux.Del("/db/:db/schemas/:name", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveDeleteMeasurementSchema)))
+
+	// Tag guard routes.
+	h.mux.Get("/db/:db/tag_guards", h.makeAuthenticationHandler(h.serveTagGuards))
+	h.mux.Put("/db/:db/tag_guards/:measurement/:key", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveSetTagGuard)))
+	h.mux.Del("/db/:db/tag_guards/:measurement/:key", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveDeleteTagGuard)))
+
+	// Alert rule routes.
+	h.mux.Get("/db/:db/alerts", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveAlertRules)))
+	h.mux.Put("/db/:db/alerts/:name", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveSetAlertRule)))
+	h.mux.Del("/db/:db/alerts/:name", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveDeleteAlertRule)))
+
+	// Routing rule routes.
+	h.mux.Get("/db/:db/routes", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveRoutingRules)))
+	h.mux.Put("/db/:db/routes/:name", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveSetRoutingRule)))
+	h.mux.Del("/db/:db/routes/:name", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveDeleteRoutingRule)))
+
+	// Metadata routes.
+	h.mux.Get("/meta", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveMetadata)))
+	h.mux.Post("/meta", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveApplyMetadata)))
+
 	// Data node routes.
-	h.mux.Get("/data_nodes", h.makeAuthenticationHandler(h.serveDataNodes))
-	h.mux.Post("/data_nodes", h.makeAuthenticationHandler(h.serveCreateDataNode))
-	h.mux.Del("/data_nodes/:id", h.makeAuthenticationHandler(h.serveDeleteDataNode))
+	h.mux.Get("/meta_nodes", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveMetaNodes)))
+	h.mux.Get("/data_nodes", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveDataNodes)))
+	h.mux.Post("/data_nodes", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveCreateDataNode)))
+	h.mux.Put("/data_nodes/:id", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveUpdateDataNode)))
+	h.mux.Del("/data_nodes/:id", h.makeAdminHandler(h.makeAuthenticationHandler(h.serveDeleteDataNode)))
 
 	// Utilities
 	h.mux.Get("/ping", h.makeAuthenticationHandler(h.servePing))
+	h.mux.Get("/health", http.HandlerFunc(h.serveHealth))
+	h.mux.Get("/metrics", http.HandlerFunc(h.serveMetrics))
 
 	return h
 }
 
 // ServeHTTP responds to HTTP request to the handler.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Use the caller's request id, if valid, or generate a new one.
+	// The id is set on the request so handlers can pass it along.
+	id := r.Header.Get("X-Request-Id")
+	if !isValidRequestID(id) {
+		id = newRequestID()
+		r.Header.Set("X-Request-Id", id)
+	}
+	w.Header().Set("X-Request-Id", id)
+
+	// Log the request once it has been served.
+	start := time.Now()
+	rw := &responseLogger{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		h.Logger.Printf("request id=%s method=%s path=%q status=%d duration=%s",
+			id, r.Method, r.URL.Path, rw.status, time.Since(start))
+	}()
+	w = rw
+
 	w.Header().Add("Access-Control-Allow-Origin", "*")
 	w.Header().Add("Access-Control-Max-Age", "2592000")
 	w.Header().Add("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
-	w.Header().Add("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept")
+	w.Header().Add("Access-Control-Allow-Headers", "Origin, X-Requested-With, X-Request-Id, X-Influxdb-Request-Id, Content-Type, Accept")
+	w.Header().Add("Access-Control-Expose-Headers", "X-Request-Id, X-Influxdb-Duplicate")
 	w.Header().Add("X-Influxdb-Version", h.Version)
 
 	// If this is a CORS OPTIONS request then send back okie-dokie.
@@ -126,11 +285,28 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // the system's standard authentication policies have been applied before the custom handler is called.
 //
 // The standard policy is if authentication is disabled, all operations are allowed and no user credentials
-// are required. If authentication is enabled, valid user credentials must be supplied.
+// are required. If authentication is enabled, valid user credentials or an API token must be supplied.
 func (h *Handler) makeAuthenticationHandler(fn func(http.ResponseWriter, *http.Request, *User)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var user *User
-		if h.AuthenticationEnabled {
+		if token := getToken(r); h.AuthenticationEnabled && token != "" {
+			var err error
+			user, err = h.server.AuthenticateToken(token)
+			if err != nil {
+				h.error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		} else if id := sessionID(r); h.AuthenticationEnabled && id != "" && !hasCredentials(r) {
+			var err error
+			_, user, err = h.sessionUser(r, id)
+			if err == ErrInvalidCSRFToken {
+				h.error(w, err.Error(), http.StatusForbidden)
+				return
+			} else if err != nil {
+				h.error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		} else if h.AuthenticationEnabled {
 			username, password, err := getUsernameAndPassword(r)
 			if err != nil {
 				h.error(w, err.Error(), http.StatusUnauthorized)
@@ -141,7 +317,7 @@ func (h *Handler) makeAuthenticationHandler(fn func(http.ResponseWriter, *http.R
 				return
 			}
 
-			user, err = h.server.Authenticate(username, password)
+			user, err = h.Authenticator.Authenticate(username, password)
 			if err != nil {
 				h.error(w, err.Error(), http.StatusUnauthorized)
 				return
@@ -155,252 +331,1596 @@ func (h *Handler) makeAuthenticationHandler(fn func(http.ResponseWriter, *http.R
 func (h *Handler) serveQuery(w http.ResponseWriter, r *http.Request, u *User) {
 	// TODO: Authentication.
 
-	// Parse query from query string.
+	// Read the next page of a paged statement, if requested.
 	urlQry := r.URL.Query()
-	_, err := influxql.NewParser(strings.NewReader(urlQry.Get("q"))).ParseQuery()
+	if urlQry.Get("cursor") != "" {
+		h.serveQueryPage(w, r, u)
+		return
+	}
+
+	// Parse bound parameters from the query string, if present.
+	var params map[string]interface{}
+	if s := urlQry.Get("params"); s != "" {
+		if err := json.Unmarshal([]byte(s), &params); err != nil {
+			h.error(w, "invalid params: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Read the database from the path or the "db" parameter.
+	db := urlQry.Get(":db")
+	if db == "" {
+		db = urlQry.Get("db")
+	}
+
+	// Parse query from query string.
+	q, err := h.server.ParseQuery(db, urlQry.Get("q"), params)
 	if err != nil {
 		h.error(w, "parse error: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Retrieve database from server.
-	/*
-		db := h.server.Database(urlQry.Get(":db"))
-		if db == nil {
-			h.error(w, ErrDatabaseNotFound.Error(), http.StatusNotFound)
+	// Ensure the database exists and accepts queries.
+	if !h.server.DatabaseExists(db) {
+		h.error(w, ErrDatabaseNotFound.Error(), http.StatusNotFound)
+		return
+	} else if h.server.DatabaseDisabled(db) {
+		h.error(w, ErrDatabaseDisabled.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Parse the precision of returned timestamps. Defaults to microseconds.
+	precision := MicrosecondPrecision
+	if s := urlQry.Get("time_precision"); s != "" {
+		if precision, err = ParseTimePrecision(s); err != nil {
+			h.error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-	*/
+	}
 
-	// Parse the time precision from the query params.
-	/*
-		precision, err := parseTimePrecision(urlQry.Get("time_precision"))
-		if err != nil {
-			h.error(w, err.Error(), http.StatusBadRequest)
+	// Return select statements a page at a time, if requested.
+	pageSize, err := parsePageSize(urlQry.Get("page_size"))
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Results can be returned as series, flattened into a table per statement
+	// or, for a single statement, as an Arrow stream. Timestamps use the
+	// precision as their unit.
+	var arrowUnit int
+	format := urlQry.Get("format")
+	switch format {
+	case "", "json", "table":
+	case "arrow":
+		var ok bool
+		if len(q.Statements) != 1 {
+			h.error(w, "arrow format requires a single statement", http.StatusBadRequest)
+			return
+		} else if arrowUnit, ok = arrowTimeUnit(precision); !ok {
+			h.error(w, "arrow format does not support time precision "+precision.String(), http.StatusBadRequest)
+			return
+		} else if pageSize > 0 {
+			h.error(w, "arrow format does not support paging", http.StatusBadRequest)
 			return
 		}
-	*/
+	default:
+		h.error(w, "invalid format: "+format, http.StatusBadRequest)
+		return
+	}
 
-	// Execute query against the database.
-	/*
-		if err := db.ExecuteQuery(q); err != nil {
-			h.error(w, err.Error(), http.StatusInternalServerError)
+	// Allow reads from downsampled retention policies if requested.
+	var rollup bool
+	switch s := urlQry.Get("rollup"); s {
+	case "auto":
+		rollup = true
+	case "", "off":
+	default:
+		h.error(w, "invalid rollup: "+s, http.StatusBadRequest)
+		return
+	}
+
+	// Read as of a snapshot, if set. "now" uses the server's current index.
+	// The snapshot that related queries can read from is always returned.
+	var snapshot uint64
+	switch s := urlQry.Get("snapshot"); s {
+	case "":
+	case "now":
+		snapshot = h.server.Index()
+	default:
+		if snapshot, err = strconv.ParseUint(s, 10, 64); err != nil || snapshot == 0 {
+			h.error(w, ErrInvalidSnapshot.Error(), http.StatusBadRequest)
+			return
+		} else if snapshot > h.server.Index() {
+			w.Header().Set("Retry-After", strconv.Itoa(int(backpressureRetryAfter.Seconds())))
+			h.error(w, ErrSnapshotNotApplied.Error(), http.StatusServiceUnavailable)
 			return
 		}
-	*/
-}
+	}
+	token := snapshot
+	if token == 0 {
+		token = h.server.Index()
+	}
+	w.Header().Set("X-Influxdb-Snapshot", strconv.FormatUint(token, 10))
 
-// serveWriteSeries receives incoming series data and writes it to the database.
-func (h *Handler) serveWriteSeries(w http.ResponseWriter, r *http.Request, u *User) {
-	// TODO: Authentication.
+	// Ensure the user has not exceeded their query rate.
+	if !h.allowQueries(w, u, len(q.Statements)) {
+		return
+	}
 
-	/* TEMPORARILY REMOVED FOR PROTOBUFS.
-	// Retrieve database from server.
-	db := h.server.Database(r.URL.Query().Get(":db"))
-	if db == nil {
-		h.error(w, ErrDatabaseNotFound.Error(), http.StatusNotFound)
+	// Execute query against the database.
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+	opt := QueryOptions{
+		Trace:            urlQry.Get("trace") == "true",
+		RequestID:        r.Header.Get("X-Request-Id"),
+		MaxPointsScanned: h.Limits.MaxPointsScanned,
+		RetentionPolicy:  urlQry.Get("rp"),
+		Rollup:           rollup,
+		MaxMemory:        h.Limits.MaxQueryMemory,
+		MaxRowLimit:      h.Limits.MaxRowLimit,
+		RemoteAddr:       remoteAddr(r),
+		Snapshot:         snapshot,
+		Context:          ctx,
+		PageSize:         pageSize,
+	}
+	results := h.server.ExecuteQuery(q, db, u, opt)
+	if len(results) > 0 && results[0].Err == ErrServerShuttingDown {
+		w.Header().Set("Retry-After", strconv.Itoa(int(backpressureRetryAfter.Seconds())))
+		h.error(w, ErrServerShuttingDown.Error(), http.StatusServiceUnavailable)
 		return
 	}
+	if precision != MicrosecondPrecision {
+		convertResultTimes(results, precision)
+	}
 
-	// Parse time precision from query parameters.
-	precision, err := parseTimePrecision(r.URL.Query().Get("time_precision"))
+	// Write the rows of the statement as an Arrow stream.
+	if format == "arrow" {
+		if results[0].Err != nil {
+			h.error(w, results[0].Err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Add("content-type", arrowContentType)
+		_, _ = w.Write(marshalArrowStream(results[0].Rows, arrowUnit))
+		return
+	}
+
+	writeQueryResults(w, results, format)
+}
+
+// serveQueryPage returns the next page of a paged select statement from the
+// cursor returned by its previous page. The page size defaults to the page
+// size the statement was executed with.
+func (h *Handler) serveQueryPage(w http.ResponseWriter, r *http.Request, u *User) {
+	q := r.URL.Query()
+	pageSize, err := parsePageSize(q.Get("page_size"))
 	if err != nil {
 		h.error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Setup HTTP request reader. Wrap in a gzip reader if encoding set in header.
-	reader := r.Body
-	if r.Header.Get("Content-Encoding") == "gzip" {
-		if reader, err = gzip.NewReader(r.Body); err != nil {
+	precision := MicrosecondPrecision
+	if s := q.Get("time_precision"); s != "" {
+		if precision, err = ParseTimePrecision(s); err != nil {
 			h.error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 	}
 
-	// Decode series from reader.
-	ss := []*serializedSeries{}
-	dec := json.NewDecoder(reader)
-	dec.UseNumber()
-	if err := dec.Decode(&ss); err != nil {
-		h.error(w, err.Error(), http.StatusBadRequest)
+	format := q.Get("format")
+	switch format {
+	case "", "json", "table":
+	default:
+		h.error(w, "invalid format: "+format, http.StatusBadRequest)
 		return
 	}
 
-	// Convert the wire format to the internal representation of the time series.
-	series, err := serializedSeriesSlice(ss).series(precision)
-	if err != nil {
-		h.error(w, err.Error(), http.StatusBadRequest)
+	result := h.server.NextPage(q.Get("cursor"), u, pageSize)
+	switch result.Err {
+	case ErrCursorNotFound:
+		h.error(w, result.Err.Error(), http.StatusNotFound)
+		return
+	case ErrServerShuttingDown:
+		w.Header().Set("Retry-After", strconv.Itoa(int(backpressureRetryAfter.Seconds())))
+		h.error(w, result.Err.Error(), http.StatusServiceUnavailable)
 		return
 	}
 
-	// Write series data to the database.
-	// TODO: Allow multiple series written to DB at once.
-	for _, s := range series {
-		if err := db.WriteSeries(s); err != nil {
-			h.error(w, err.Error(), http.StatusInternalServerError)
-			return
+	results := Results{result}
+	if precision != MicrosecondPrecision {
+		convertResultTimes(results, precision)
+	}
+	writeQueryResults(w, results, format)
+}
+
+// parsePageSize parses the page_size parameter. Returns zero if blank.
+func parsePageSize(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, errors.New("invalid page_size: " + s)
+	}
+	return n, nil
+}
+
+// writeQueryResults writes results as JSON, either as series or with each
+// result flattened into a single table.
+func writeQueryResults(w http.ResponseWriter, results Results, format string) {
+	w.Header().Add("content-type", "application/json")
+	if format == "table" {
+		tables := make([]*TableResult, len(results))
+		for i, r := range results {
+			tables[i] = NewTableResult(r)
 		}
+		_ = json.NewEncoder(w).Encode(tables)
+		return
 	}
-	*/
+	_ = json.NewEncoder(w).Encode(results)
 }
 
-// serveDatabases returns a list of all databases on the server.
-func (h *Handler) serveDatabases(w http.ResponseWriter, r *http.Request, u *User) {
+// readQueryBody returns a handler that adds the parameters of a query sent by
+// POST to the request's query string before calling fn, so that it's read
+// like a GET. Long queries can exceed the URL length allowed by proxies.
+// Because the body is read before authentication, a form-encoded body can
+// also carry the "u" and "p" credentials.
+func (h *Handler) readQueryBody(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := parseQueryBody(r); err == errQueryTooLarge {
+			h.error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			h.error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fn(w, r)
+	}
+}
 
-	// Retrieve databases from the server.
-	databases := h.server.Databases()
+// parseQueryBody moves the parameters in a request body to its query string.
+// A form-encoded body can set any parameter and any other body is the "q"
+// parameter. Bodies larger than maxQuerySize return errQueryTooLarge.
+func parseQueryBody(r *http.Request) error {
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxQuerySize+1))
+	if err != nil {
+		return err
+	} else if len(b) > maxQuerySize {
+		return errQueryTooLarge
+	}
 
-	// JSON encode databases to the response.
-	w.Header().Add("content-type", "application/json")
-	_ = json.NewEncoder(w).Encode(databases)
+	values := r.URL.Query()
+	if typ, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); typ == "application/x-www-form-urlencoded" {
+		form, err := url.ParseQuery(string(b))
+		if err != nil {
+			return err
+		}
+		for k, v := range form {
+			values[k] = v
+		}
+	} else if len(bytes.TrimSpace(b)) > 0 {
+		values.Set("q", string(b))
+	}
+	r.URL.RawQuery = values.Encode()
+	return nil
 }
 
-// serveCreateDatabase creates a new database on the server.
-func (h *Handler) serveCreateDatabase(w http.ResponseWriter, r *http.Request, u *User) {
-	var req struct {
-		Name string `json:"name"`
+// maxQuerySize is the largest query read from a request body.
+const maxQuerySize = 1 << 20
+
+// errQueryTooLarge is returned when a query body is larger than maxQuerySize.
+var errQueryTooLarge = errors.New("query too large")
+
+// serveQuery2 runs a query in the experimental pipe query language and
+// returns its results. The query is read from the "q" parameter or, for
+// POST requests, the body.
+func (h *Handler) serveQuery2(w http.ResponseWriter, r *http.Request, u *User) {
+	text := r.URL.Query().Get("q")
+	if r.Method == "POST" && text == "" {
+		b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxPipeQuerySize))
+		if err != nil {
+			h.error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		text = string(b)
 	}
 
-	// Decode the request from the body.
-	err := json.NewDecoder(r.Body).Decode(&req)
+	prog, err := pipeql.ParseProgram(text)
 	if err != nil {
-		h.error(w, err.Error(), http.StatusBadRequest)
-		return
-	} else if req.Name == "" {
-		h.error(w, ErrDatabaseNameRequired.Error(), http.StatusBadRequest)
+		h.error(w, "parse error: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Create the database.
-	if err := h.server.CreateDatabase(req.Name); err == ErrDatabaseExists {
-		h.error(w, err.Error(), http.StatusConflict)
-		return
-	} else if err != nil {
-		h.error(w, err.Error(), http.StatusInternalServerError)
+	// Ensure the user has not exceeded their query rate.
+	if !h.allowQueries(w, u, 1) {
 		return
 	}
-	w.WriteHeader(http.StatusCreated)
-}
 
-// serveDeleteDatabase deletes an existing database on the server.
-func (h *Handler) serveDeleteDatabase(w http.ResponseWriter, r *http.Request, u *User) {
-	name := r.URL.Query().Get(":name")
-	if err := h.server.DeleteDatabase(name); err == ErrDatabaseNotFound {
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+	opt := QueryOptions{
+		RequestID:        r.Header.Get("X-Request-Id"),
+		MaxPointsScanned: h.Limits.MaxPointsScanned,
+		MaxMemory:        h.Limits.MaxQueryMemory,
+		RemoteAddr:       remoteAddr(r),
+		Context:          ctx,
+	}
+	results, err := h.server.ExecutePipeQuery(prog, u, opt)
+	if err == ErrReadAccessDenied || err == ErrDatabaseDisabled {
+		h.error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err == ErrDatabaseNotFound {
 		h.error(w, err.Error(), http.StatusNotFound)
 		return
+	} else if err == ErrServerShuttingDown {
+		h.backpressure(w, err)
+		return
 	} else if err != nil {
-		h.error(w, err.Error(), http.StatusInternalServerError)
+		h.error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	w.WriteHeader(http.StatusNoContent)
-}
-
-// serveAuthenticate authenticates a user.
-func (h *Handler) serveAuthenticate(w http.ResponseWriter, r *http.Request) {}
-
-// serveUsers returns data about a single user.
-func (h *Handler) serveUsers(w http.ResponseWriter, r *http.Request, u *User) {
-
-	// Generate a list of objects for encoding to the API.
-	a := make([]*userJSON, 0)
-	for _, u := range h.server.Users() {
-		a = append(a, &userJSON{
-			Name:  u.Name,
-			Admin: u.Admin,
-		})
-	}
 
 	w.Header().Add("content-type", "application/json")
-	_ = json.NewEncoder(w).Encode(a)
+	_ = json.NewEncoder(w).Encode(&pipeResultsJSON{Results: results})
 }
 
-type userJSON struct {
-	Name     string `json:"name"`
-	Password string `json:"password,omitempty"`
-	Admin    bool   `json:"admin,omitempty"`
+// maxPipeQuerySize is the largest pipe query read from a request body.
+const maxPipeQuerySize = 1 << 20
+
+// pipeResultsJSON is the response body of a pipe query.
+type pipeResultsJSON struct {
+	Results []*pipeql.Result `json:"results"`
 }
 
-// serveCreateUser creates a new user.
-func (h *Handler) serveCreateUser(w http.ResponseWriter, r *http.Request) {
-	// Read in user from request body.
-	var newUser userJSON
-	if err := json.NewDecoder(r.Body).Decode(&newUser); err != nil {
+// serveWriteSeries receives incoming series data and writes it to the database.
+// The request body is decoded based on its content type. JSON bodies use the
+// serialized series format and protobuf bodies use the schema in write.proto.
+func (h *Handler) serveWriteSeries(w http.ResponseWriter, r *http.Request, u *User) {
+	// TODO: Authentication.
+	q := r.URL.Query()
+
+	// Ensure the database exists and accepts writes.
+	db := q.Get(":db")
+	if !h.authorizeWrite(w, db, u) {
+		return
+	}
+
+	// Parse time precision from query parameters.
+	precision, err := ParseTimePrecision(q.Get("time_precision"))
+	if err != nil {
 		h.error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Creating a User involves a non-standard authentication policy. Iff no Admin
-	// already exists, and the used being created will be an admin, no authorization
-	// is required.
-	if h.AuthenticationEnabled && (h.server.AdminUserExists() || !newUser.Admin) {
-		username, password, err := getUsernameAndPassword(r)
-		if err != nil {
-			h.error(w, err.Error(), http.StatusUnauthorized)
+	// Validate the optional id used to detect retried writes.
+	requestID := r.Header.Get("X-Influxdb-Request-Id")
+	if requestID != "" && !isValidRequestID(requestID) {
+		h.error(w, "invalid request id", http.StatusBadRequest)
+		return
+	}
+
+	// Dry runs validate the points without writing them.
+	dryRun := q.Get("dry_run") == "true"
+
+	// Reject the write before reading the body if the write path is backed up.
+	if !dryRun {
+		if err := h.server.WriteBackpressure(); err != nil {
+			h.backpressure(w, err)
 			return
 		}
+	}
 
-		_, err = h.server.Authenticate(username, password)
+	// Setup HTTP request reader. Wrap in a gzip reader if encoding set in header.
+	var reader io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
 		if err != nil {
-			h.error(w, err.Error(), http.StatusUnauthorized)
+			h.error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		defer gz.Close()
+		reader = gz
 	}
 
-	// Create the user.
-	if err := h.server.CreateUser(newUser.Name, newUser.Password, newUser.Admin); err == ErrUserExists {
-		h.error(w, err.Error(), http.StatusConflict)
-		return
-	} else if err != nil {
-		h.error(w, err.Error(), http.StatusInternalServerError)
+	// Decode series from reader based on the content type.
+	var ss []*serializedSeries
+	switch typ, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); typ {
+	case "", "application/json":
+		dec := json.NewDecoder(reader)
+		dec.UseNumber()
+		err = dec.Decode(&ss)
+	case "application/x-protobuf":
+		var b []byte
+		if b, err = ioutil.ReadAll(reader); err == nil {
+			ss, err = unmarshalProtobufSeries(b)
+		}
+	default:
+		h.error(w, "unsupported content type: "+typ, http.StatusUnsupportedMediaType)
 		return
 	}
-	w.WriteHeader(http.StatusCreated)
-}
-
-// serveUpdateUser updates an existing user.
-func (h *Handler) serveUpdateUser(w http.ResponseWriter, r *http.Request, u *User) {
-	// Read in user from request body.
-	var user userJSON
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+	if err != nil {
 		h.error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Create the user.
-	if err := h.server.UpdateUser(r.URL.Query().Get(":user"), user.Password); err == ErrUserNotFound {
-		h.error(w, err.Error(), http.StatusNotFound)
+	// Convert the wire format to points.
+	points, err := serializedSeriesSlice(ss).points(precision)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
 		return
-	} else if err != nil {
-		h.error(w, err.Error(), http.StatusInternalServerError)
+	} else if !h.authorizePoints(w, db, u, points) {
 		return
 	}
-	w.WriteHeader(http.StatusNoContent)
-}
+	h.server.CorrectTimestamps(db, h.Timestamps, points)
 
-// serveDeleteUser removes an existing user.
-func (h *Handler) serveDeleteUser(w http.ResponseWriter, r *http.Request, u *User) {
-	// Delete the user.
-	if err := h.server.DeleteUser(r.URL.Query().Get(":user")); err == ErrUserNotFound {
-		h.error(w, err.Error(), http.StatusNotFound)
+	// Report the changes the write would make instead of writing it.
+	if dryRun {
+		report, err := h.server.ValidatePoints(db, q.Get("rp"), points)
+		if err == ErrRetentionPolicyNotFound {
+			h.error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			h.writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(report)
 		return
-	} else if err != nil {
-		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+
+	// Ensure the user has not exceeded their write rate.
+	if !h.allowPoints(w, u, len(points)) {
 		return
 	}
 
+	// Write points to the database. Requests with a client supplied id are
+	// only written once so that retries do not duplicate points.
+	duplicate, err := h.server.WritePointsOnce(db, q.Get("rp"), requestID, points)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if duplicate {
+		w.Header().Set("X-Influxdb-Duplicate", "true")
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// servePing returns a simple response to let the client know the server is running.
+// authorizeWrite returns true if the node and the database accept writes and
+// the user can write to the database. Otherwise an error is written to the response.
+func (h *Handler) authorizeWrite(w http.ResponseWriter, db string, u *User) bool {
+	if h.server.QueryOnly() {
+		h.error(w, ErrQueryOnlyNode.Error(), http.StatusForbidden)
+		return false
+	} else if !h.server.DatabaseExists(db) {
+		h.error(w, ErrDatabaseNotFound.Error(), http.StatusNotFound)
+		return false
+	} else if h.server.DatabaseDisabled(db) {
+		h.error(w, ErrDatabaseDisabled.Error(), http.StatusForbidden)
+		return false
+	} else if h.server.DatabaseReadOnly(db) {
+		h.error(w, ErrDatabaseReadOnly.Error(), http.StatusForbidden)
+		return false
+	} else if !u.Authorize(influxql.WritePrivilege, db) {
+		h.error(w, ErrWriteAccessDenied.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// authorizePoints returns true if the user can write to the measurement of
+// every point. Otherwise an error is written to the response.
+func (h *Handler) authorizePoints(w http.ResponseWriter, db string, u *User, points []*Point) bool {
+	for _, p := range points {
+		if !u.AuthorizeMeasurement(influxql.WritePrivilege, db, p.Name) {
+			h.error(w, ErrWriteAccessDenied.Error(), http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+// writeError writes the response for an error returned when writing points.
+func (h *Handler) writeError(w http.ResponseWriter, err error) {
+	if errs, ok := err.(PointErrors); ok {
+		// Return each rejected point so the client can tell which failed.
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&pointErrorsJSON{Err: errs.Error(), Points: errs})
+		return
+	} else if err == ErrWriteQueueFull || err == ErrWriteLogBehind || err == ErrServerShuttingDown {
+		h.backpressure(w, err)
+		return
+	} else if err == ErrSeriesQuotaExceeded || err == ErrDiskQuotaExceeded {
+		h.error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	h.error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// servePromWrite writes samples sent by a Prometheus server using the remote
+// storage protocol. The body is a snappy compressed WriteRequest protobuf.
+// Points are written to the database and retention policy in the "db" and
+// "rp" parameters. Metric names are stored as measurement names, labels as
+// tags and sample values in the "value" field.
+func (h *Handler) servePromWrite(w http.ResponseWriter, r *http.Request, u *User) {
+	q := r.URL.Query()
+	db := q.Get("db")
+	if !h.authorizeWrite(w, db, u) {
+		return
+	}
+
+	// Reject the write before reading the body if the write path is backed up.
+	if err := h.server.WriteBackpressure(); err != nil {
+		h.backpressure(w, err)
+		return
+	}
+
+	data, err := readSnappyBody(r)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	points, err := unmarshalPromWriteRequest(data)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if !h.authorizePoints(w, db, u, points) {
+		return
+	}
+
+	// Ensure the user has not exceeded their write rate.
+	if !h.allowPoints(w, u, len(points)) {
+		return
+	}
+
+	h.server.CorrectTimestamps(db, h.Timestamps, points)
+	if err := h.server.WritePoints(db, q.Get("rp"), points); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// servePromRead returns the samples requested by a Prometheus server using
+// the remote storage protocol. The body is a snappy compressed ReadRequest
+// protobuf and the response is a snappy compressed ReadResponse. Samples are
+// read from the database and retention policy in the "db" and "rp" parameters.
+func (h *Handler) servePromRead(w http.ResponseWriter, r *http.Request, u *User) {
+	q := r.URL.Query()
+	db := q.Get("db")
+	if !h.server.DatabaseExists(db) {
+		h.error(w, ErrDatabaseNotFound.Error(), http.StatusNotFound)
+		return
+	} else if h.server.DatabaseDisabled(db) {
+		h.error(w, ErrDatabaseDisabled.Error(), http.StatusForbidden)
+		return
+	}
+
+	data, err := readSnappyBody(r)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	queries, err := unmarshalPromReadRequest(data)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Ensure the user has not exceeded their query rate.
+	if !h.allowQueries(w, u, len(queries)) {
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+	opt := QueryOptions{
+		RequestID:        r.Header.Get("X-Request-Id"),
+		MaxPointsScanned: h.Limits.MaxPointsScanned,
+		RetentionPolicy:  q.Get("rp"),
+		MaxMemory:        h.Limits.MaxQueryMemory,
+		RemoteAddr:       remoteAddr(r),
+		Context:          ctx,
+	}
+	results := make([][]*promTimeSeries, len(queries))
+	for i, pq := range queries {
+		if results[i], err = h.server.readPrometheus(db, u, pq, opt); err == ErrReadAccessDenied {
+			h.error(w, err.Error(), http.StatusForbidden)
+			return
+		} else if err == ErrServerShuttingDown {
+			h.backpressure(w, err)
+			return
+		} else if err != nil {
+			h.error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Header().Set("Content-Encoding", "snappy")
+	_, _ = w.Write(snappyEncode(marshalPromReadResponse(results)))
+}
+
+// serveWriteAnnotations stores the annotations in the request body in the
+// database and retention policy in the "db" and "rp" parameters. The body is
+// a JSON annotation or an array of them. Times are RFC3339 strings or numbers
+// in the units of the "time_precision" parameter and default to now.
+func (h *Handler) serveWriteAnnotations(w http.ResponseWriter, r *http.Request, u *User) {
+	q := r.URL.Query()
+	db := q.Get("db")
+	if !h.authorizeWrite(w, db, u) {
+		return
+	} else if !u.AuthorizeMeasurement(influxql.WritePrivilege, db, AnnotationMeasurement) {
+		h.error(w, ErrWriteAccessDenied.Error(), http.StatusForbidden)
+		return
+	}
+
+	precision, err := ParseTimePrecision(q.Get("time_precision"))
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Reject the write before reading the body if the write path is backed up.
+	if err := h.server.WriteBackpressure(); err != nil {
+		h.backpressure(w, err)
+		return
+	}
+
+	a, err := decodeAnnotations(r.Body, precision)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Ensure the user has not exceeded their write rate.
+	if !h.allowPoints(w, u, len(a)) {
+		return
+	}
+
+	if err := h.server.WriteAnnotations(db, q.Get("rp"), a); err == ErrAnnotationTitleRequired || err == ErrInvalidAnnotationTag {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveAnnotations returns the annotations in the database and retention
+// policy in the "db" and "rp" parameters as a JSON array. Annotations are
+// read from the "start" time up to the "end" time, which default to the
+// epoch and now, and can be limited to those with every tag in the comma
+// separated "tags" parameter.
+func (h *Handler) serveAnnotations(w http.ResponseWriter, r *http.Request, u *User) {
+	q := r.URL.Query()
+	db := q.Get("db")
+	if !h.server.DatabaseExists(db) {
+		h.error(w, ErrDatabaseNotFound.Error(), http.StatusNotFound)
+		return
+	} else if h.server.DatabaseDisabled(db) {
+		h.error(w, ErrDatabaseDisabled.Error(), http.StatusForbidden)
+		return
+	}
+
+	precision, err := ParseTimePrecision(q.Get("time_precision"))
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	start, end := time.Unix(0, 0).UTC(), time.Now().UTC()
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"start", &start}, {"end", &end}} {
+		if v := q.Get(p.name); v != "" {
+			if *p.t, err = parseAnnotationTime(v, precision); err != nil {
+				h.error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	var tags []string
+	if v := q.Get("tags"); v != "" {
+		tags = strings.Split(v, ",")
+	}
+
+	// Ensure the user has not exceeded their query rate.
+	if !h.allowQueries(w, u, 1) {
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+	opt := QueryOptions{
+		RequestID:        r.Header.Get("X-Request-Id"),
+		MaxPointsScanned: h.Limits.MaxPointsScanned,
+		RetentionPolicy:  q.Get("rp"),
+		MaxMemory:        h.Limits.MaxQueryMemory,
+		RemoteAddr:       remoteAddr(r),
+		Context:          ctx,
+	}
+	a, err := h.server.Annotations(db, u, start, end, tags, opt)
+	if err == ErrReadAccessDenied {
+		h.error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err == ErrServerShuttingDown {
+		h.backpressure(w, err)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if a == nil {
+		a = []*Annotation{}
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(a)
+}
+
+// readSnappyBody reads and decompresses a snappy compressed request body.
+func readSnappyBody(r *http.Request) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSnappyDecodedSize))
+	if err != nil {
+		return nil, err
+	}
+	return snappyDecode(b)
+}
+
+// pointErrorsJSON is the response body returned when points fail validation.
+type pointErrorsJSON struct {
+	Err    string      `json:"error"`
+	Points PointErrors `json:"points"`
+}
+
+// serveDatabases returns a list of all databases on the server.
+func (h *Handler) serveDatabases(w http.ResponseWriter, r *http.Request, u *User) {
+
+	// Retrieve databases from the server.
+	databases := h.server.Databases()
+
+	// JSON encode databases to the response.
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(databases)
+}
+
+// serveCreateDatabase creates a new database on the server.
+func (h *Handler) serveCreateDatabase(w http.ResponseWriter, r *http.Request, u *User) {
+	var req struct {
+		Name string `json:"name"`
+	}
+
+	// Decode the request from the body.
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if req.Name == "" {
+		h.error(w, ErrDatabaseNameRequired.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Create the database.
+	if err := h.server.CreateDatabase(req.Name); err == ErrDatabaseExists {
+		h.error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, u, "create database", req.Name)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// serveUpdateDatabase changes the flags, quotas and index storage of a database.
+func (h *Handler) serveUpdateDatabase(w http.ResponseWriter, r *http.Request, u *User) {
+	name := r.URL.Query().Get(":name")
+
+	// Decode the flags to change from the body.
+	var update DatabaseUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Update the database.
+	if err := h.server.UpdateDatabase(name, &update); err == ErrDatabaseNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err == ErrInvalidIndexType {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, u, "update database", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveProvisionDatabase creates a database from a template. The template is
+// decoded as TOML if the content type is "application/toml", otherwise as JSON.
+func (h *Handler) serveProvisionDatabase(w http.ResponseWriter, r *http.Request, u *User) {
+	name := r.URL.Query().Get(":name")
+
+	// Decode the template from the body.
+	var t DatabaseTemplate
+	var err error
+	if typ, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); typ == "application/toml" {
+		_, err = toml.DecodeReader(r.Body, &t)
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&t)
+	}
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Create the database, its retention policies and users.
+	tokens, err := h.server.ProvisionDatabase(name, &t)
+	switch err {
+	case nil:
+	case ErrDatabaseExists, ErrUserExists:
+		h.error(w, err.Error(), http.StatusConflict)
+		return
+	case ErrDatabaseNameRequired, ErrContinuousQueriesNotSupported, ErrRetentionPolicyNameRequired,
+		ErrRetentionPolicyExists, ErrInvalidRetentionDuration, ErrMultipleDefaultRetentionPolicies,
+		ErrUsernameRequired, ErrInvalidTokenScope:
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	case ErrRetentionQuotaExceeded:
+		h.error(w, err.Error(), http.StatusForbidden)
+		return
+	default:
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, u, "provision database", name)
+
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(&struct {
+		Tokens map[string]string `json:"tokens,omitempty"`
+	}{tokens})
+}
+
+// serveMetadata returns a description of the databases, retention policies
+// and users on the server.
+func (h *Handler) serveMetadata(w http.ResponseWriter, r *http.Request, u *User) {
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.server.Metadata())
+}
+
+// serveApplyMetadata brings the server in line with a description and returns
+// the changes made. If "dry_run" is set then the changes are only returned.
+// Items that aren't described are deleted if "prune" is set.
+func (h *Handler) serveApplyMetadata(w http.ResponseWriter, r *http.Request, u *User) {
+	q := r.URL.Query()
+	dryRun, prune := q.Get("dry_run") == "true", q.Get("prune") == "true"
+
+	var m Metadata
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var changes []*MetadataChange
+	var err error
+	if dryRun {
+		changes, err = h.server.DiffMetadata(&m, prune)
+	} else {
+		changes, err = h.server.ApplyMetadata(&m, prune)
+		for _, c := range changes {
+			h.audit(r, u, "apply metadata", c.String())
+		}
+	}
+	switch err {
+	case nil:
+	case ErrDatabaseNameRequired, ErrDatabaseExists, ErrRetentionPolicyNameRequired, ErrRetentionPolicyExists,
+		ErrRetentionPolicyNotFound, ErrInvalidRetentionDuration, ErrUsernameRequired, ErrUserExists, ErrUserPasswordRequired:
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	default:
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if changes == nil {
+		changes = []*MetadataChange{}
+	}
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(changes)
+}
+
+// serveDeleteDatabase deletes an existing database on the server.
+func (h *Handler) serveDeleteDatabase(w http.ResponseWriter, r *http.Request, u *User) {
+	name := r.URL.Query().Get(":name")
+	if err := h.server.DeleteDatabase(name); err == ErrDatabaseNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, u, "delete database", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveAuthenticate authenticates a user.
+func (h *Handler) serveAuthenticate(w http.ResponseWriter, r *http.Request) {}
+
+// serveLogin authenticates a user and starts a session. The session id is
+// returned in an HTTP-only cookie so browsers don't need to keep the user's
+// credentials. The session's CSRF token is returned in the body.
+func (h *Handler) serveLogin(w http.ResponseWriter, r *http.Request) {
+	var req loginJSON
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if req.Username == "" {
+		h.error(w, "username required", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := h.Authenticator.Authenticate(req.Username, req.Password)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	id, s, err := h.sessions.create(u, h.SessionIdleTimeout, time.Now())
+	if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.setSessionCookie(w, r, id)
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(&sessionJSON{Username: u.Name, Admin: u.Admin, CSRFToken: s.csrf})
+}
+
+// serveLogout ends the request's session and clears its cookie.
+func (h *Handler) serveLogout(w http.ResponseWriter, r *http.Request) {
+	id := sessionID(r)
+	if id == "" {
+		h.error(w, ErrInvalidSession.Error(), http.StatusUnauthorized)
+		return
+	}
+	if _, _, err := h.sessionUser(r, id); err == ErrInvalidCSRFToken {
+		h.error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	h.sessions.delete(id)
+	h.setSessionCookie(w, r, "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveSession returns the user and CSRF token of the request's session so
+// that a reloaded page can continue the session.
+func (h *Handler) serveSession(w http.ResponseWriter, r *http.Request) {
+	id := sessionID(r)
+	if id == "" {
+		h.error(w, ErrInvalidSession.Error(), http.StatusUnauthorized)
+		return
+	}
+	s, u, err := h.sessionUser(r, id)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(&sessionJSON{Username: u.Name, Admin: u.Admin, CSRFToken: s.csrf})
+}
+
+type loginJSON struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type sessionJSON struct {
+	Username  string `json:"username"`
+	Admin     bool   `json:"admin"`
+	CSRFToken string `json:"csrfToken"`
+}
+
+// serveUsers returns data about a single user.
+func (h *Handler) serveUsers(w http.ResponseWriter, r *http.Request, u *User) {
+
+	// Generate a list of objects for encoding to the API.
+	a := make([]*userJSON, 0)
+	for _, u := range h.server.Users() {
+		a = append(a, &userJSON{
+			Name:   u.Name,
+			Admin:  u.Admin,
+			Grants: u.Grants,
+		})
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(a)
+}
+
+type userJSON struct {
+	Name     string   `json:"name"`
+	Password string   `json:"password,omitempty"`
+	Admin    bool     `json:"admin,omitempty"`
+	Grants   []string `json:"grants,omitempty"`
+}
+
+// serveCreateUser creates a new user.
+func (h *Handler) serveCreateUser(w http.ResponseWriter, r *http.Request) {
+	// Read in user from request body.
+	var newUser userJSON
+	if err := json.NewDecoder(r.Body).Decode(&newUser); err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Creating a User involves a non-standard authentication policy. Iff no Admin
+	// already exists, and the used being created will be an admin, no authorization
+	// is required.
+	var u *User
+	if id := sessionID(r); h.AuthenticationEnabled && (h.server.AdminUserExists() || !newUser.Admin) && id != "" && !hasCredentials(r) {
+		var err error
+		if _, u, err = h.sessionUser(r, id); err == ErrInvalidCSRFToken {
+			h.error(w, err.Error(), http.StatusForbidden)
+			return
+		} else if err != nil {
+			h.error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	} else if h.AuthenticationEnabled && (h.server.AdminUserExists() || !newUser.Admin) {
+		username, password, err := getUsernameAndPassword(r)
+		if err != nil {
+			h.error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		u, err = h.Authenticator.Authenticate(username, password)
+		if err != nil {
+			h.error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// Create the user.
+	if err := h.server.CreateUser(newUser.Name, newUser.Password, newUser.Admin); err == ErrUserExists {
+		h.error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, u, "create user", newUser.Name)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// serveUpdateUser updates an existing user. Only admins can change a user's grants.
+func (h *Handler) serveUpdateUser(w http.ResponseWriter, r *http.Request, u *User) {
+	// Read in user from request body.
+	var user userJSON
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if user.Grants != nil && u != nil && !u.Admin {
+		h.error(w, ErrAdminRequired.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Create the user.
+	if err := h.server.UpdateUser(r.URL.Query().Get(":user"), user.Password); err == ErrUserNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Replace the user's grants, if set.
+	if user.Grants != nil {
+		if err := h.server.SetUserGrants(r.URL.Query().Get(":user"), user.Grants); err == ErrInvalidTokenScope {
+			h.error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			h.error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	h.audit(r, u, "update user", r.URL.Query().Get(":user"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveDeleteUser removes an existing user.
+func (h *Handler) serveDeleteUser(w http.ResponseWriter, r *http.Request, u *User) {
+	// Delete the user.
+	if err := h.server.DeleteUser(r.URL.Query().Get(":user")); err == ErrUserNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, u, "delete user", r.URL.Query().Get(":user"))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveTokens returns the API tokens issued to a user. The tokens themselves
+// are not returned.
+func (h *Handler) serveTokens(w http.ResponseWriter, r *http.Request, u *User) {
+	name := r.URL.Query().Get(":user")
+	if !h.authorizeTokens(w, u, name) {
+		return
+	}
+
+	tokens, err := h.server.Tokens(name)
+	if err == ErrUserNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	a := make([]*tokenJSON, 0)
+	for _, t := range tokens {
+		a = append(a, &tokenJSON{ID: t.ID, Scopes: t.Scopes, CreatedAt: t.CreatedAt})
+	}
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(a)
+}
+
+// serveCreateToken issues a new API token to a user.
+func (h *Handler) serveCreateToken(w http.ResponseWriter, r *http.Request, u *User) {
+	name := r.URL.Query().Get(":user")
+	if !h.authorizeTokens(w, u, name) {
+		return
+	}
+
+	// Read in the token scopes from request body.
+	var t tokenJSON
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Create the token.
+	tok, token, err := h.server.CreateToken(name, t.Scopes)
+	if err == ErrUserNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err == ErrTokenScopesRequired || err == ErrInvalidTokenScope {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, u, "create token", name+"."+tok.ID)
+
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(&tokenJSON{ID: tok.ID, Token: token, Scopes: tok.Scopes, CreatedAt: tok.CreatedAt})
+}
+
+// serveDeleteToken revokes an API token issued to a user.
+func (h *Handler) serveDeleteToken(w http.ResponseWriter, r *http.Request, u *User) {
+	q := r.URL.Query()
+	name, id := q.Get(":user"), q.Get(":id")
+	if !h.authorizeTokens(w, u, name) {
+		return
+	}
+
+	if err := h.server.DeleteToken(name, id); err == ErrUserNotFound || err == ErrTokenNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, u, "delete token", name+"."+id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeTokens returns true if a user can manage another user's tokens.
+// Tokens can only be managed by their user or an admin using a password.
+func (h *Handler) authorizeTokens(w http.ResponseWriter, u *User, name string) bool {
+	if u == nil {
+		return true
+	} else if u.token != nil {
+		h.error(w, ErrPasswordRequired.Error(), http.StatusForbidden)
+		return false
+	} else if !u.Admin && u.Name != name {
+		h.error(w, ErrAdminRequired.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+type tokenJSON struct {
+	ID        string    `json:"id,omitempty"`
+	Token     string    `json:"token,omitempty"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// servePing returns a simple response to let the client know the server is running.
 func (h *Handler) servePing(w http.ResponseWriter, r *http.Request, u *User) {}
 
-// serveShards returns a list of shards.
-func (h *Handler) serveShards(w http.ResponseWriter, r *http.Request, u *User) {
+// serveHealth returns the state of the write path and of the last scrub. A
+// 503 is returned while writes are being rejected so load balancers can route
+// writes elsewhere. Quarantined blocks are reported as degraded but don't
+// affect the status code since writes and most queries still succeed.
+func (h *Handler) serveHealth(w http.ResponseWriter, r *http.Request) {
+	st := h.server.WriteState()
+
+	var scrub *ScrubState
+	if ss := h.server.ScrubState(); !ss.LastScrub.IsZero() {
+		scrub = &ss
+	}
+
+	status := "ok"
+	w.Header().Set("Content-Type", "application/json")
+	if st.Backpressure == ErrServerShuttingDown.Error() {
+		status = "shutting down"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else if st.Backpressure != "" {
+		status = "overloaded"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else if scrub != nil && scrub.Quarantined > 0 {
+		status = "degraded"
+	}
+	_ = json.NewEncoder(w).Encode(&healthJSON{Status: status, Writes: st, Scrub: scrub})
+}
+
+// healthJSON is the response body for the health endpoint.
+type healthJSON struct {
+	Status string      `json:"status"`
+	Writes WriteState  `json:"writes"`
+	Scrub  *ScrubState `json:"scrub,omitempty"`
+}
+
+// serveMetrics returns the server's statistics in the Prometheus text
+// exposition format.
+func (h *Handler) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if h.MetricsAuthenticationEnabled {
+		h.makeAuthenticationHandler(h.serveAuthenticatedMetrics)(w, r)
+		return
+	}
+	h.serveAuthenticatedMetrics(w, r, nil)
+}
+
+// serveAuthenticatedMetrics returns the server's statistics if the user is
+// an admin. Metrics cover every database so they're hidden from other users.
+func (h *Handler) serveAuthenticatedMetrics(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, ErrAdminRequired.Error(), http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", metricsContentType)
+	if err := h.server.WriteMetrics(w); err != nil {
+		h.Logger.Printf("metrics: %s", err)
+	}
+}
+
+// serveShards returns a list of shards.
+func (h *Handler) serveShards(w http.ResponseWriter, r *http.Request, u *User) {
+	q := r.URL.Query()
+
+	// Parse the optional time range.
+	from, err := parseEpochParam(q.Get("from"))
+	if err != nil {
+		h.error(w, "invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseEpochParam(q.Get("to"))
+	if err != nil {
+		h.error(w, "invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Retrieves shards for the database.
+	shards, err := h.server.ShardInfos(q.Get(":db"), from, to)
+	if err == ErrDatabaseNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write data to the response.
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(shards)
+}
+
+// parseEpochParam parses a time given in seconds since the epoch.
+// Returns a zero time if the string is blank.
+func parseEpochParam(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, 0).UTC(), nil
+}
+
+// serveDeleteShard removes an existing shard.
+func (h *Handler) serveDeleteShard(w http.ResponseWriter, r *http.Request, u *User) {
+	q := r.URL.Query()
+
+	// Parse the shard id.
+	id, err := strconv.ParseUint(q.Get(":id"), 10, 64)
+	if err != nil {
+		h.error(w, "invalid shard id", http.StatusBadRequest)
+		return
+	}
+
+	// Delete the shard.
+	if err := h.server.DeleteShard(q.Get(":db"), id); err == ErrDatabaseNotFound || err == ErrShardNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err == ErrShardOwnedElsewhere {
+		h.error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, u, "delete shard", q.Get(":id"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveDeletions returns the deletions of a database not yet purged from disk.
+func (h *Handler) serveDeletions(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, ErrAdminRequired.Error(), http.StatusForbidden)
+		return
+	}
+	a, err := h.server.Deletions(r.URL.Query().Get(":db"))
+	if err == ErrDatabaseNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(a)
+}
+
+// servePurgeDeletions removes the points of a database's pending deletions
+// from disk without waiting for the compactor.
+func (h *Handler) servePurgeDeletions(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, ErrAdminRequired.Error(), http.StatusForbidden)
+		return
+	}
+	db := r.URL.Query().Get(":db")
+	if err := h.server.PurgeDeletions(db); err == ErrDatabaseNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, u, "purge deletions", db)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveBlock returns the values stored in a shard for a series at a time with
+// their checksum. Data nodes use it to repair corrupt blocks from replicas.
+func (h *Handler) serveBlock(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, ErrAdminRequired.Error(), http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+
+	// Parse the shard, series and timestamp of the block.
+	id, err := strconv.ParseUint(q.Get(":id"), 10, 64)
+	if err != nil {
+		h.error(w, "invalid shard id", http.StatusBadRequest)
+		return
+	}
+	seriesID, err := strconv.ParseUint(q.Get("series"), 10, 32)
+	if err != nil {
+		h.error(w, "invalid series id", http.StatusBadRequest)
+		return
+	}
+	timestamp, err := strconv.ParseInt(q.Get("time"), 10, 64)
+	if err != nil {
+		h.error(w, "invalid time", http.StatusBadRequest)
+		return
+	}
+
+	data, err := h.server.ReadBlock(q.Get(":db"), id, uint32(seriesID), timestamp)
+	if err == ErrDatabaseNotFound || err == ErrShardNotFound || err == ErrBlockNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err == ErrChecksumMismatch {
+		h.error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(data)
+}
+
+// serveRetentionPolicies returns a list of retention policys.
+func (h *Handler) serveRetentionPolicies(w http.ResponseWriter, r *http.Request, u *User) {
+	// Retrieve policies by database.
+	policies, err := h.server.RetentionPolicies(r.URL.Query().Get(":db"))
+	if err == ErrDatabaseNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Write data to response body.
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(policies)
+}
+
+// serveCreateRetentionPolicy creates a new retention policy.
+func (h *Handler) serveCreateRetentionPolicy(w http.ResponseWriter, r *http.Request, u *User) {
+	// Decode the policy from the body.
+	var policy RetentionPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Create the retention policy.
+	if err := h.server.CreateRetentionPolicy(r.URL.Query().Get(":db"), &policy); err == ErrDatabaseNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err == ErrRetentionPolicyExists {
+		h.error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err == ErrRetentionQuotaExceeded {
+		h.error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if isDownsampleError(err) {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, u, "create retention policy", r.URL.Query().Get(":db")+"."+policy.Name)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// serveUpdateRetentionPolicy updates an existing retention policy.
+func (h *Handler) serveUpdateRetentionPolicy(w http.ResponseWriter, r *http.Request, u *User) {
+	q := r.URL.Query()
+	db, name := q.Get(":db"), q.Get(":name")
+
+	// Decode the new policy values from the body.
+	var policy RetentionPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Update the retention policy.
+	if err := h.server.UpdateRetentionPolicy(db, name, &policy); err == ErrDatabaseNotFound || err == ErrRetentionPolicyNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if isDownsampleError(err) {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, u, "update retention policy", db+"."+name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isDownsampleError returns true if err is caused by an invalid downsampling rule.
+func isDownsampleError(err error) bool {
+	return err == ErrDownsampleIntervalRequired || err == ErrInvalidDownsampleFunction || err == ErrDownsampleDefaultPolicy
+}
+
+// serveDeleteRetentionPolicy removes an existing retention policy.
+func (h *Handler) serveDeleteRetentionPolicy(w http.ResponseWriter, r *http.Request, u *User) {
+	q := r.URL.Query()
+	db, name := q.Get(":db"), q.Get(":name")
+
+	// Delete the retention policy.
+	if err := h.server.DeleteRetentionPolicy(db, name); err == ErrDatabaseNotFound || err == ErrRetentionPolicyNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, u, "delete retention policy", db+"."+name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveBackfills returns the progress of running and recently finished backfills.
+func (h *Handler) serveBackfills(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, ErrAdminRequired.Error(), http.StatusForbidden)
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.server.Backfills())
+}
+
+// serveJobs returns the background jobs that are running or waiting to run.
+func (h *Handler) serveJobs(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, ErrAdminRequired.Error(), http.StatusForbidden)
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.server.Jobs())
+}
+
+// serveCreateBackfill starts recomputing the downsampled points of a retention
+// policy over the time range in the request body. Progress is reported by the
+// backfills endpoint.
+func (h *Handler) serveCreateBackfill(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, ErrAdminRequired.Error(), http.StatusForbidden)
+		return
+	}
 	q := r.URL.Query()
+	db, name := q.Get(":db"), q.Get(":name")
 
-	// Retrieves shards for the database.
-	shards, err := h.server.Shards(q.Get(":db"))
+	var req backfillJSON
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	b, err := h.server.StartBackfill(db, name, req.Start, req.End, req.PointsPerSecond)
+	if err == ErrDatabaseNotFound || err == ErrRetentionPolicyNotFound || err == ErrDownsampleNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err == ErrInvalidBackfillRange || err == ErrDownsampleDefaultPolicy {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, u, "backfill", db+"."+name)
+
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(b)
+}
+
+// backfillJSON represents the request body of a backfill.
+type backfillJSON struct {
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	PointsPerSecond int       `json:"pointsPerSecond,omitempty"`
+}
+
+// serveMeasurementSchemas returns the declared measurement schemas of a database.
+func (h *Handler) serveMeasurementSchemas(w http.ResponseWriter, r *http.Request, u *User) {
+	schemas, err := h.server.MeasurementSchemas(r.URL.Query().Get(":db"))
 	if err == ErrDatabaseNotFound {
 		h.error(w, err.Error(), http.StatusNotFound)
 		return
@@ -409,18 +1929,66 @@ func (h *Handler) serveShards(w http.ResponseWriter, r *http.Request, u *User) {
 		return
 	}
 
-	// Write data to the response.
+	// Write data to response body.
 	w.Header().Add("content-type", "application/json")
-	_ = json.NewEncoder(w).Encode(shards)
+	_ = json.NewEncoder(w).Encode(schemas)
 }
 
-// serveDeleteShard removes an existing shard.
-func (h *Handler) serveDeleteShard(w http.ResponseWriter, r *http.Request, u *User) {}
+// serveSetMeasurementSchema declares the fields and tags of a measurement.
+func (h *Handler) serveSetMeasurementSchema(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, ErrAdminRequired.Error(), http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	db, name := q.Get(":db"), q.Get(":name")
 
-// serveRetentionPolicies returns a list of retention policys.
-func (h *Handler) serveRetentionPolicies(w http.ResponseWriter, r *http.Request, u *User) {
-	// Retrieve policies by database.
-	policies, err := h.server.RetentionPolicies(r.URL.Query().Get(":db"))
+	// Decode the schema from the body. The name is taken from the path.
+	var schema MeasurementSchema
+	if err := json.NewDecoder(r.Body).Decode(&schema); err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	schema.Name = name
+
+	// Declare the schema.
+	if err := h.server.SetMeasurementSchema(db, &schema); err == ErrDatabaseNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err == ErrInvalidFieldType || err == ErrFieldNameRequired || err == ErrInvalidDuplicatePolicy {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, u, "set measurement schema", db+"."+name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveDeleteMeasurementSchema removes the declared schema of a measurement.
+func (h *Handler) serveDeleteMeasurementSchema(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, ErrAdminRequired.Error(), http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	db, name := q.Get(":db"), q.Get(":name")
+
+	if err := h.server.DeleteMeasurementSchema(db, name); err == ErrDatabaseNotFound || err == ErrMeasurementSchemaNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, u, "delete measurement schema", db+"."+name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveTagGuards returns the tag guards of a database.
+func (h *Handler) serveTagGuards(w http.ResponseWriter, r *http.Request, u *User) {
+	guards, err := h.server.TagGuards(r.URL.Query().Get(":db"))
 	if err == ErrDatabaseNotFound {
 		h.error(w, err.Error(), http.StatusNotFound)
 		return
@@ -431,79 +1999,216 @@ func (h *Handler) serveRetentionPolicies(w http.ResponseWriter, r *http.Request,
 
 	// Write data to response body.
 	w.Header().Add("content-type", "application/json")
-	_ = json.NewEncoder(w).Encode(policies)
+	_ = json.NewEncoder(w).Encode(guards)
 }
 
-// serveCreateRetentionPolicy creates a new retention policy.
-func (h *Handler) serveCreateRetentionPolicy(w http.ResponseWriter, r *http.Request, u *User) {
-	// Decode the policy from the body.
-	var policy RetentionPolicy
-	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+// serveSetTagGuard limits the values of a measurement's tag key.
+func (h *Handler) serveSetTagGuard(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, ErrAdminRequired.Error(), http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	db, measurement, key := q.Get(":db"), q.Get(":measurement"), q.Get(":key")
+
+	// Decode the guard from the body. The measurement and key are taken from the path.
+	var guard TagGuard
+	if err := json.NewDecoder(r.Body).Decode(&guard); err != nil {
 		h.error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	guard.Measurement, guard.Key = measurement, key
 
-	// Create the retention policy.
-	if err := h.server.CreateRetentionPolicy(r.URL.Query().Get(":db"), &policy); err == ErrDatabaseNotFound {
+	// Set the guard.
+	if err := h.server.SetTagGuard(db, &guard); err == ErrDatabaseNotFound {
 		h.error(w, err.Error(), http.StatusNotFound)
 		return
-	} else if err == ErrRetentionPolicyExists {
-		h.error(w, err.Error(), http.StatusConflict)
+	} else if err == ErrInvalidTagGuard {
+		h.error(w, err.Error(), http.StatusBadRequest)
 		return
 	} else if err != nil {
 		h.error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	w.WriteHeader(http.StatusCreated)
+	h.audit(r, u, "set tag guard", db+"."+measurement+"."+key)
+	w.WriteHeader(http.StatusNoContent)
 }
 
-// serveUpdateRetentionPolicy updates an existing retention policy.
-func (h *Handler) serveUpdateRetentionPolicy(w http.ResponseWriter, r *http.Request, u *User) {
+// serveDeleteTagGuard removes the guard from a measurement's tag key.
+func (h *Handler) serveDeleteTagGuard(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, ErrAdminRequired.Error(), http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	db, measurement, key := q.Get(":db"), q.Get(":measurement"), q.Get(":key")
+
+	if err := h.server.DeleteTagGuard(db, measurement, key); err == ErrDatabaseNotFound || err == ErrTagGuardNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, u, "delete tag guard", db+"."+measurement+"."+key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveAlertRules returns the alert rules of a database.
+func (h *Handler) serveAlertRules(w http.ResponseWriter, r *http.Request, u *User) {
+	rules, err := h.server.AlertRules(r.URL.Query().Get(":db"))
+	if err == ErrDatabaseNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(rules)
+}
+
+// serveSetAlertRule adds or replaces an alert rule.
+func (h *Handler) serveSetAlertRule(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, ErrAdminRequired.Error(), http.StatusForbidden)
+		return
+	}
 	q := r.URL.Query()
 	db, name := q.Get(":db"), q.Get(":name")
 
-	// Decode the new policy values from the body.
-	var policy RetentionPolicy
-	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+	// Decode the rule from the body. The name is taken from the path.
+	var rule AlertRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
 		h.error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	rule.Name = name
 
-	// Update the retention policy.
-	if err := h.server.UpdateRetentionPolicy(db, name, &policy); err == ErrDatabaseNotFound || err == ErrRetentionPolicyNotFound {
+	// Set the rule.
+	switch err := h.server.SetAlertRule(db, &rule); err {
+	case nil:
+	case ErrDatabaseNotFound:
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	case ErrAlertRuleNameRequired, ErrInvalidAlertQuery, ErrInvalidAlertInterval, ErrInvalidAlertType,
+		ErrInvalidAlertOperator, ErrAlertNotifyRequired, ErrInvalidAlertNotify:
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	default:
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, u, "set alert rule", db+"."+name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveDeleteAlertRule removes an alert rule.
+func (h *Handler) serveDeleteAlertRule(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, ErrAdminRequired.Error(), http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	db, name := q.Get(":db"), q.Get(":name")
+
+	if err := h.server.DeleteAlertRule(db, name); err == ErrDatabaseNotFound || err == ErrAlertRuleNotFound {
 		h.error(w, err.Error(), http.StatusNotFound)
 		return
 	} else if err != nil {
 		h.error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.audit(r, u, "delete alert rule", db+"."+name)
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// serveDeleteRetentionPolicy removes an existing retention policy.
-func (h *Handler) serveDeleteRetentionPolicy(w http.ResponseWriter, r *http.Request, u *User) {
+// serveRoutingRules returns the routing rules of a database.
+func (h *Handler) serveRoutingRules(w http.ResponseWriter, r *http.Request, u *User) {
+	rules, err := h.server.RoutingRules(r.URL.Query().Get(":db"))
+	if err == ErrDatabaseNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(rules)
+}
+
+// serveSetRoutingRule adds or replaces a routing rule.
+func (h *Handler) serveSetRoutingRule(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, ErrAdminRequired.Error(), http.StatusForbidden)
+		return
+	}
 	q := r.URL.Query()
 	db, name := q.Get(":db"), q.Get(":name")
 
-	// Delete the retention policy.
-	if err := h.server.DeleteRetentionPolicy(db, name); err == ErrDatabaseNotFound || err == ErrRetentionPolicyNotFound {
+	// Decode the rule from the body. The name is taken from the path.
+	var rule RoutingRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rule.Name = name
+
+	// Set the rule.
+	switch err := h.server.SetRoutingRule(db, &rule); err {
+	case nil:
+	case ErrDatabaseNotFound:
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	case ErrRoutingRuleNameRequired, ErrInvalidRoutingRule:
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	default:
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, u, "set routing rule", db+"."+name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveDeleteRoutingRule removes a routing rule.
+func (h *Handler) serveDeleteRoutingRule(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, ErrAdminRequired.Error(), http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	db, name := q.Get(":db"), q.Get(":name")
+
+	if err := h.server.DeleteRoutingRule(db, name); err == ErrDatabaseNotFound || err == ErrRoutingRuleNotFound {
 		h.error(w, err.Error(), http.StatusNotFound)
 		return
 	} else if err != nil {
 		h.error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.audit(r, u, "delete routing rule", db+"."+name)
 	w.WriteHeader(http.StatusNoContent)
 }
 
 // serveDataNodes returns a list of all data nodes in the cluster.
 func (h *Handler) serveDataNodes(w http.ResponseWriter, r *http.Request, u *User) {
+	// Only return nodes with the given role, if any. Clients choosing a
+	// write target should request the "data" role.
+	role := r.URL.Query().Get("role")
+
 	// Generate a list of objects for encoding to the API.
 	a := make([]*dataNodeJSON, 0)
 	for _, n := range h.server.DataNodes() {
+		if role != "" && n.Role != role {
+			continue
+		}
 		a = append(a, &dataNodeJSON{
-			ID:  n.ID,
-			URL: n.URL.String(),
+			ID:   n.ID,
+			URL:  n.URL.String(),
+			Role: n.Role,
 		})
 	}
 
@@ -511,6 +2216,18 @@ func (h *Handler) serveDataNodes(w http.ResponseWriter, r *http.Request, u *User
 	_ = json.NewEncoder(w).Encode(a)
 }
 
+// serveMetaNodes returns a list of brokers that hold the cluster's metadata.
+// Metadata changes made through the API are published to these nodes.
+func (h *Handler) serveMetaNodes(w http.ResponseWriter, r *http.Request, u *User) {
+	a := make([]*metaNodeJSON, 0)
+	for _, n := range h.server.MetaNodes() {
+		a = append(a, &metaNodeJSON{URL: n.String()})
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(a)
+}
+
 // serveCreateDataNode creates a new data node in the cluster.
 func (h *Handler) serveCreateDataNode(w http.ResponseWriter, r *http.Request, u *User) {
 	// Read in data node from request body.
@@ -527,6 +2244,12 @@ func (h *Handler) serveCreateDataNode(w http.ResponseWriter, r *http.Request, u
 		return
 	}
 
+	// Validate the role before creating the node.
+	if n.Role != "" && !isValidDataNodeRole(n.Role) {
+		h.error(w, ErrInvalidDataNodeRole.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Create the data node.
 	if err := h.server.CreateDataNode(url); err == ErrDataNodeExists {
 		h.error(w, err.Error(), http.StatusConflict)
@@ -535,12 +2258,55 @@ func (h *Handler) serveCreateDataNode(w http.ResponseWriter, r *http.Request, u
 		h.error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	node := h.server.DataNodeByURL(url)
+
+	// Nodes are created with the data role.
+	if n.Role == DataNodeRoleQuery {
+		if err := h.server.SetDataNodeRole(node.ID, n.Role); err != nil {
+			h.error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		node = h.server.DataNode(node.ID)
+	}
+
+	h.audit(r, u, "create data node", url.String())
 
 	// Write new node back to client.
-	node := h.server.DataNodeByURL(url)
 	w.WriteHeader(http.StatusCreated)
 	w.Header().Add("content-type", "application/json")
-	_ = json.NewEncoder(w).Encode(&dataNodeJSON{ID: node.ID, URL: node.URL.String()})
+	_ = json.NewEncoder(w).Encode(&dataNodeJSON{ID: node.ID, URL: node.URL.String(), Role: node.Role})
+}
+
+// serveUpdateDataNode changes the role of an existing node.
+func (h *Handler) serveUpdateDataNode(w http.ResponseWriter, r *http.Request, u *User) {
+	// Parse node id.
+	nodeID, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
+	if err != nil {
+		h.error(w, "invalid node id", http.StatusBadRequest)
+		return
+	}
+
+	// Read in the update from the request body.
+	var n dataNodeJSON
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Update the node's role.
+	if err := h.server.SetDataNodeRole(nodeID, n.Role); err == ErrDataNodeNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err == ErrInvalidDataNodeRole {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, u, "update data node", r.URL.Query().Get(":id"))
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
 // serveDeleteDataNode removes an existing node.
@@ -560,17 +2326,153 @@ func (h *Handler) serveDeleteDataNode(w http.ResponseWriter, r *http.Request, u
 		h.error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.audit(r, u, "delete data node", r.URL.Query().Get(":id"))
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-type dataNodeJSON struct {
-	ID  uint64 `json:"id"`
+type metaNodeJSON struct {
 	URL string `json:"url"`
 }
 
+type dataNodeJSON struct {
+	ID   uint64 `json:"id"`
+	URL  string `json:"url"`
+	Role string `json:"role,omitempty"`
+}
+
 // error returns an error to the client in a standard format.
+// queryContext returns the context for the statements of a request. It is
+// done once the client disconnects or the query timeout passes.
+func (h *Handler) queryContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if h.Limits.QueryTimeout > 0 {
+		return context.WithTimeout(r.Context(), h.Limits.QueryTimeout)
+	}
+	return context.WithCancel(r.Context())
+}
+
+// allowQueries returns true if the user can execute n more statements.
+// Otherwise a 429 response is written.
+func (h *Handler) allowQueries(w http.ResponseWriter, u *User, n int) bool {
+	if u == nil || h.Limits.QueriesPerMinute == 0 {
+		return true
+	}
+	qpm := float64(h.Limits.QueriesPerMinute)
+	return h.allow(w, "queries:"+u.Name, float64(n), qpm/60, qpm)
+}
+
+// allowPoints returns true if the user can write n more points.
+// Otherwise a 429 response is written.
+func (h *Handler) allowPoints(w http.ResponseWriter, u *User, n int) bool {
+	if u == nil || h.Limits.PointsPerSecond == 0 {
+		return true
+	}
+	pps := float64(h.Limits.PointsPerSecond)
+	return h.allow(w, "points:"+u.Name, float64(n), pps, pps)
+}
+
+// allow takes n tokens from the limiter for a key. If the tokens are not
+// available then a 429 response is written with a Retry-After header.
+func (h *Handler) allow(w http.ResponseWriter, key string, n, rate, burst float64) bool {
+	d := h.limiter.take(key, n, rate, burst, time.Now())
+	if d == 0 {
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+	h.error(w, ErrRateLimitExceeded.Error(), http.StatusTooManyRequests)
+	return false
+}
+
+// backpressureRetryAfter is how long clients are asked to wait before retrying a rejected write.
+const backpressureRetryAfter = 1 * time.Second
+
+// backpressure writes a 503 response asking the client to retry a rejected write.
+func (h *Handler) backpressure(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(backpressureRetryAfter.Seconds())))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_ = json.NewEncoder(w).Encode(&backpressureJSON{Err: "server overloaded", Reason: err.Error()})
+}
+
+// backpressureJSON is the response body returned when a write is rejected.
+type backpressureJSON struct {
+	Err    string `json:"error"`
+	Reason string `json:"reason"`
+}
+
+// audit records a successful administrative request in the server's audit log.
+func (h *Handler) audit(r *http.Request, u *User, action, target string) {
+	h.server.audit(u, remoteAddr(r), action, target)
+}
+
+// remoteAddr returns the host of the client that sent a request.
+func remoteAddr(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
 func (h *Handler) error(w http.ResponseWriter, error string, code int) {
 	// TODO: Return error as JSON.
 	http.Error(w, error, code)
 }
+
+// responseLogger wraps a response writer to record the response status.
+type responseLogger struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code and writes it to the underlying writer.
+func (w *responseLogger) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Flush sends any buffered data to the client.
+func (w *responseLogger) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack takes over the underlying connection, such as when upgrading to a
+// WebSocket connection.
+func (w *responseLogger) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return hj.Hijack()
+}
+
+// maxRequestIDLen is the maximum length of a client supplied request id.
+const maxRequestIDLen = 128
+
+// newRequestID returns a random request id.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("request id: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// isValidRequestID returns true if a client supplied request id can be used.
+// Ids are restricted to a safe character set so they can be logged as-is.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
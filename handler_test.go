@@ -1,18 +1,30 @@
 package influxdb_test
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"code.google.com/p/go.net/websocket"
 	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/messaging"
 )
 
 func init() {
@@ -92,31 +104,1933 @@ func TestHandler_CreateDatabase_Conflict(t *testing.T) {
 	}
 }
 
+func TestHandler_ProvisionDatabase(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/db/foo/provision`, `{"retentionPolicies":[{"name":"raw","duration":"7d","default":true}],"users":[{"name":"$database_admin","password":"pass","admin":true}]}`)
+	if status != http.StatusCreated {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{}` {
+		t.Fatalf("unexpected body: %s", body)
+	} else if rp, _ := srvr.DefaultRetentionPolicy("foo"); rp == nil || rp.Name != "raw" {
+		t.Fatalf("unexpected default policy: %#v", rp)
+	} else if u := srvr.User("foo_admin"); u == nil || !u.Admin {
+		t.Fatalf("unexpected user: %#v", u)
+	}
+
+	// Templates can also be written in TOML.
+	status, body = MustHTTPWithHeaders("POST", s.URL+`/db/bar/provision`, map[string]string{"Content-Type": "application/toml"}, `
+[[retention-policy]]
+name = "raw"
+duration = "1d"
+default = true
+
+[[user]]
+name = "$database_reader"
+password = "pass"
+grants = ["read"]
+`)
+	if status != http.StatusCreated {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if !strings.HasPrefix(body, `{"tokens":{"bar_reader":"`) {
+		t.Fatalf("unexpected body: %s", body)
+	} else if rp, _ := srvr.DefaultRetentionPolicy("bar"); rp == nil || rp.Duration != 24*time.Hour {
+		t.Fatalf("unexpected default policy: %#v", rp)
+	}
+
+	status, body = MustHTTP("POST", s.URL+`/db/foo/provision`, `{}`)
+	if status != http.StatusConflict || body != `database exists` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+	status, body = MustHTTP("POST", s.URL+`/db/baz/provision`, `{"continuousQueries":["CREATE CONTINUOUS QUERY cq ON baz BEGIN SELECT count(value) INTO cpu_1h FROM cpu GROUP BY time(1h) END"]}`)
+	if status != http.StatusBadRequest || body != `continuous queries not supported` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+}
+
+func TestHandler_Metadata(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 24 * time.Hour, ReplicaN: 1})
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/meta`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"databases":[{"name":"foo","retentionPolicies":[{"name":"raw","duration":"1d","replicaN":1}]}],"users":[]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// A dry run returns the changes without making them.
+	status, body = MustHTTP("POST", s.URL+`/meta?dry_run=true&prune=true`, `{"databases":[{"name":"bar"}],"users":[]}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `[{"op":"create","kind":"database","name":"bar"},{"op":"delete","kind":"database","name":"foo"}]` {
+		t.Fatalf("unexpected body: %s", body)
+	} else if srvr.DatabaseExists("bar") || !srvr.DatabaseExists("foo") {
+		t.Fatal("unexpected databases")
+	}
+
+	status, body = MustHTTP("POST", s.URL+`/meta`, `{"databases":[{"name":"foo","retentionPolicies":[{"name":"raw","duration":"7d"}]}]}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `[{"op":"update","kind":"retention policy","name":"foo.raw","diff":"duration: 1d -\u003e 1w"}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("POST", s.URL+`/meta`, `{"users":[{"name":"susy"}]}`)
+	if status != http.StatusBadRequest || body != `password required to create user` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+}
+
 func TestHandler_DeleteDatabase(t *testing.T) {
 	srvr := OpenServer(NewMessagingClient())
 	srvr.CreateDatabase("foo")
 	s := NewHTTPServer(srvr)
 	defer s.Close()
 
-	status, body := MustHTTP("DELETE", s.URL+`/db/foo`, "")
+	status, body := MustHTTP("DELETE", s.URL+`/db/foo`, "")
+
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != "" {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_DeleteDatabase_NotFound(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("DELETE", s.URL+`/db/foo`, "")
+
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `database not found` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+// Ensure read-only databases reject writes and disabled databases reject writes and queries.
+func TestHandler_UpdateDatabase(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Mark the database as read-only.
+	status, body := MustHTTP("PUT", s.URL+`/db/foo`, `{"readOnly":true}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != "" {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("POST", s.URL+`/db/foo/series`, `[{"name":"cpu","columns":["value"],"points":[[100]]}]`)
+	if status != http.StatusForbidden {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != "database is read-only" {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("GET", s.URL+`/db/foo/series?q=SELECT+sum(value)+FROM+cpu`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	// Disable the database.
+	status, body = MustHTTP("PUT", s.URL+`/db/foo`, `{"disabled":true}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	status, body = MustHTTP("GET", s.URL+`/db/foo/series?q=SELECT+sum(value)+FROM+cpu`, "")
+	if status != http.StatusForbidden {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != "database is disabled" {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("POST", s.URL+`/db/foo/series`, `[{"name":"cpu","columns":["value"],"points":[[100]]}]`)
+	if status != http.StatusForbidden {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != "database is disabled" {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Enable the database and allow writes again.
+	status, body = MustHTTP("PUT", s.URL+`/db/foo`, `{"readOnly":false,"disabled":false}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	status, body = MustHTTP("POST", s.URL+`/db/foo/series`, `[{"name":"cpu","columns":["value"],"points":[[100]]}]`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+}
+
+func TestHandler_UpdateDatabase_NotFound(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("PUT", s.URL+`/db/foo`, `{"readOnly":true}`)
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `database not found` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+// Ensure writes that exceed a database quota are rejected with a 403.
+func TestHandler_WriteSeries_QuotaExceeded(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("PUT", s.URL+`/db/foo`, `{"maxSeries":1}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	status, body = MustHTTP("POST", s.URL+`/db/foo/series`, `[{"name":"cpu","columns":["value"],"points":[[100]]},{"name":"mem","columns":["value"],"points":[[100]]}]`)
+	if status != http.StatusForbidden {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != "series quota exceeded" {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+// Ensure administrative requests are recorded in the audit log.
+func TestHandler_Audit(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	path := tempfile()
+	defer os.Remove(path)
+	srvr.SetAuditLog(path)
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	MustHTTP("POST", s.URL+`/db`, `{"name": "foo"}`)
+	MustHTTP("POST", s.URL+`/db`, `{"name": "foo"}`) // conflict, not recorded
+	MustHTTP("POST", s.URL+`/db/foo/retention_policies`, `{"name": "bar", "duration": 3600000000000}`)
+	MustHTTP("DELETE", s.URL+`/db/foo/retention_policies/bar`, "")
+
+	a, err := srvr.AuditLog(0)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(a) != 3 {
+		t.Fatalf("unexpected entry count: %d", len(a))
+	}
+	for i, exp := range []struct{ action, target string }{
+		{"create database", "foo"},
+		{"create retention policy", "foo.bar"},
+		{"delete retention policy", "foo.bar"},
+	} {
+		if e := a[i]; e.Action != exp.action || e.Target != exp.target || e.Source != "127.0.0.1" || e.Time.IsZero() {
+			t.Fatalf("%d. unexpected entry: %#v", i, e)
+		}
+	}
+}
+
+func TestHandler_Query(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.WriteSeries("foo", "bar", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 100.0})
+	srvr.Sync(c.index)
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/db/foo/series?q=SELECT+sum(value)+FROM+cpu`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,100]]}]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+// Ensure queries can be run and measurements tailed over a WebSocket.
+func TestHandler_WebSocket(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.WriteSeries("foo", "bar", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 100.0})
+	srvr.Sync(c.index)
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	ws, err := websocket.Dial(strings.Replace(s.URL, "http://", "ws://", 1)+"/db/foo/ws?time_precision=s", "", s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Send a message and verify the response.
+	mustExchange := func(req, exp string) {
+		if req != "" {
+			if err := websocket.Message.Send(ws, req); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var resp string
+		if err := websocket.Message.Receive(ws, &resp); err != nil {
+			t.Fatal(err)
+		} else if resp != exp {
+			t.Fatalf("unexpected response: %s", resp)
+		}
+	}
+
+	mustExchange(`{"id":"1","q":"SELECT sum(value) FROM cpu"}`, `{"id":"1","results":[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,100]]}]}]}`)
+	mustExchange(`{"id":"2","q":"SELECT"}`, `{"id":"2","error":"parse error: found EOF, expected identifier, string, number, bool at line 1, char 8"}`)
+	mustExchange(`{"id":"3","tail":"cpu","where":"host = 'serverA'"}`, `{"id":"3","status":"tailing"}`)
+
+	// Only points matching the tail's condition are sent.
+	srvr.WriteSeries("foo", "bar", "cpu", map[string]string{"host": "serverB"}, mustParseTime("2000-01-01T00:00:01Z"), map[string]interface{}{"value": 1.0})
+	srvr.WriteSeries("foo", "bar", "cpu", map[string]string{"host": "serverA"}, mustParseTime("2000-01-01T00:00:02Z"), map[string]interface{}{"value": 2.0})
+	srvr.Sync(c.index)
+	mustExchange("", `{"id":"3","series":{"name":"cpu","tags":{"host":"serverA"},"columns":["time","value"],"values":[[946684802,2]]}}`)
+
+	mustExchange(`{"id":"3","stop":true}`, `{"id":"3","status":"stopped"}`)
+	mustExchange(`{"id":"4"}`, `{"id":"4","error":"query or tail required"}`)
+}
+
+// Ensure written points can be streamed as server-sent events.
+func TestHandler_Stream(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + `/stream?db=foo&measurement=cpu&where=value+>+1&time_precision=s`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	} else if typ := resp.Header.Get("Content-Type"); typ != "text/event-stream" {
+		t.Fatalf("unexpected content type: %s", typ)
+	}
+
+	srvr.WriteSeries("foo", "bar", "cpu", nil, mustParseTime("2000-01-01T00:00:01Z"), map[string]interface{}{"value": 1.0})
+	srvr.WriteSeries("foo", "bar", "cpu", map[string]string{"host": "serverA"}, mustParseTime("2000-01-01T00:00:02Z"), map[string]interface{}{"value": 2.0})
+	srvr.Sync(c.index)
+
+	r := bufio.NewReader(resp.Body)
+	for _, exp := range []string{
+		`data: {"name":"cpu","tags":{"host":"serverA"},"columns":["time","value"],"values":[[946684802,2]]}` + "\n",
+		"\n",
+	} {
+		if line, err := r.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		} else if line != exp {
+			t.Fatalf("unexpected line: %q", line)
+		}
+	}
+}
+
+// Ensure invalid stream requests are rejected.
+func TestHandler_Stream_BadRequest(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	for i, tt := range []struct {
+		query  string
+		status int
+		body   string
+	}{
+		{query: `measurement=cpu`, status: http.StatusBadRequest, body: "database required"},
+		{query: `db=foo`, status: http.StatusBadRequest, body: "measurement name required"},
+		{query: `db=bar&measurement=cpu`, status: http.StatusNotFound, body: "database not found"},
+		{query: `db=foo&measurement=cpu&buffer=0`, status: http.StatusBadRequest, body: "invalid buffer: 0"},
+		{query: `db=foo&measurement=cpu&drop=all`, status: http.StatusBadRequest, body: "invalid drop policy: all"},
+	} {
+		status, body := MustHTTP("GET", s.URL+`/stream?`+tt.query, "")
+		if status != tt.status {
+			t.Errorf("%d. unexpected status: %d", i, status)
+		} else if strings.TrimSpace(body) != tt.body {
+			t.Errorf("%d. unexpected body: %s", i, body)
+		}
+	}
+}
+
+func TestHandler_Query_DatabaseContext(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "baz", Duration: time.Hour})
+	srvr.WriteSeries("foo", "bar", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 100.0})
+	srvr.WriteSeries("foo", "baz", "cpu", nil, mustParseTime("2000-01-01T00:00:10Z"), map[string]interface{}{"value": 20.0})
+	srvr.Sync(c.index)
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Without a retention policy every policy is read.
+	status, body := MustHTTP("GET", s.URL+`/query?db=foo&q=SELECT+sum(value)+FROM+cpu`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,120]]}]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Only the retention policy is read when one is set.
+	status, body = MustHTTP("GET", s.URL+`/query?db=foo&rp=baz&q=SELECT+sum(value)+FROM+cpu`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,20]]}]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("GET", s.URL+`/query?db=foo&rp=no_such_policy&q=SELECT+sum(value)+FROM+cpu`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"statement_id":0,"error":"retention policy not found"}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, _ = MustHTTP("GET", s.URL+`/query?q=SELECT+sum(value)+FROM+cpu`, "")
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+func TestHandler_Query_Snapshot(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	srvr.WriteSeries("foo", "bar", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 100.0})
+	srvr.Sync(c.index)
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Every response returns the snapshot to read from.
+	resp, err := http.Get(s.URL + `/query?db=foo&q=SELECT+sum(value)+FROM+cpu`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	snapshot := resp.Header.Get("X-Influxdb-Snapshot")
+	if snapshot != strconv.FormatUint(srvr.Index(), 10) {
+		t.Fatalf("unexpected snapshot: %s", snapshot)
+	}
+
+	// Points written after the snapshot are not read.
+	srvr.WriteSeries("foo", "bar", "cpu", nil, mustParseTime("2000-01-01T00:00:10Z"), map[string]interface{}{"value": 20.0})
+	srvr.Sync(c.index)
+	status, body := MustHTTP("GET", s.URL+`/query?db=foo&snapshot=`+snapshot+`&q=SELECT+sum(value)+FROM+cpu`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,100]]}]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("GET", s.URL+`/query?db=foo&snapshot=now&q=SELECT+sum(value)+FROM+cpu`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,120]]}]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Malformed snapshots and snapshots the server hasn't reached are rejected.
+	status, body = MustHTTP("GET", s.URL+`/query?db=foo&snapshot=yesterday&q=SELECT+sum(value)+FROM+cpu`, "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `invalid snapshot` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+	status, body = MustHTTP("GET", s.URL+`/query?db=foo&snapshot=1000000&q=SELECT+sum(value)+FROM+cpu`, "")
+	if status != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `snapshot not yet applied` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_Query_Paged(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	srvr.WriteSeries("foo", "bar", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 100.0})
+	srvr.WriteSeries("foo", "bar", "cpu", nil, mustParseTime("2000-01-01T00:00:10Z"), map[string]interface{}{"value": 20.0})
+	srvr.Sync(c.index)
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/query?db=foo&page_size=1&time_precision=s&q=SELECT+value+FROM+cpu`, "")
+	var results []struct {
+		Rows []json.RawMessage `json:"rows"`
+		Next string            `json:"next"`
+	}
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if err := json.Unmarshal([]byte(body), &results); err != nil {
+		t.Fatal(err)
+	} else if results[0].Next == "" || string(results[0].Rows[0]) != `{"name":"cpu","columns":["time","value"],"values":[[946684800,100]]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// The next page is read from the cursor and is the last.
+	status, body = MustHTTP("GET", s.URL+`/query?format=table&time_precision=s&cursor=`+results[0].Next, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"statement_id":0,"columns":[{"name":"name","type":"string","tag":true},{"name":"time","type":"timestamp"},{"name":"value","type":"float"}],"values":[["cpu",946684810,20]]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("GET", s.URL+`/query?cursor=`+results[0].Next, "")
+	if status != http.StatusNotFound || body != `cursor not found` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+	status, body = MustHTTP("GET", s.URL+`/query?db=foo&page_size=0&q=SELECT+value+FROM+cpu`, "")
+	if status != http.StatusBadRequest || body != `invalid page_size: 0` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+}
+
+func TestHandler_Query_Table(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	srvr.WriteSeries("foo", "bar", "cpu", map[string]string{"host": "serverA"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 100.0})
+	srvr.WriteSeries("foo", "bar", "cpu", map[string]string{"host": "serverB"}, mustParseTime("2000-01-01T00:00:10Z"), map[string]interface{}{"value": 20.0})
+	srvr.Sync(c.index)
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/query?db=foo&format=table&time_precision=s&q=SELECT+value+FROM+cpu+GROUP+BY+host%3BSELECT+value+FROM+mem`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"statement_id":0,"columns":[{"name":"name","type":"string","tag":true},{"name":"host","type":"string","tag":true},{"name":"time","type":"timestamp"},{"name":"value","type":"float"}],"values":[["cpu","serverA",946684800,100],["cpu","serverB",946684810,20]]},{"statement_id":1,"error":"field not found: mem.value"}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_Query_Arrow(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	srvr.WriteSeries("foo", "bar", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 100.0})
+	srvr.Sync(c.index)
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + `/query?db=foo&format=arrow&q=SELECT+value+FROM+cpu`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", resp.StatusCode, b)
+	} else if typ := resp.Header.Get("Content-Type"); typ != "application/vnd.apache.arrow.stream" {
+		t.Fatalf("unexpected content type: %s", typ)
+	} else if !bytes.HasPrefix(b, []byte{0xFF, 0xFF, 0xFF, 0xFF}) || !bytes.HasSuffix(b, []byte{0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0}) {
+		t.Fatalf("unexpected body: %x", b)
+	}
+
+	// Only single statements with a precision Arrow supports can be exported.
+	for i, tt := range []struct {
+		query string
+		body  string
+	}{
+		{query: `format=arrow&q=SELECT+value+FROM+cpu%3BSELECT+value+FROM+cpu`, body: `arrow format requires a single statement`},
+		{query: `format=arrow&time_precision=m&q=SELECT+value+FROM+cpu`, body: `arrow format does not support time precision m`},
+		{query: `format=parquet&q=SELECT+value+FROM+cpu`, body: `invalid format: parquet`},
+	} {
+		status, body := MustHTTP("GET", s.URL+`/query?db=foo&`+tt.query, "")
+		if status != http.StatusBadRequest {
+			t.Errorf("%d. unexpected status: %d", i, status)
+		} else if body != tt.body {
+			t.Errorf("%d. unexpected body: %s", i, body)
+		}
+	}
+}
+
+func TestHandler_Query_Rollup(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw"})
+	srvr.SetDefaultRetentionPolicy("foo", "raw")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "5m", Downsample: &influxdb.Downsample{After: time.Hour, Interval: 5 * time.Minute}})
+	srvr.WriteSeries("foo", "raw", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 100.0})
+	srvr.Sync(c.index)
+	srvr.Downsample(mustParseTime("2000-01-01T00:10:00Z"))
+	srvr.Sync(c.index)
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	q := url.QueryEscape(`SELECT mean(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 00:10:00" GROUP BY time(10m)`)
+	status, body := MustHTTP("GET", s.URL+`/query?db=foo&rollup=auto&q=`+q, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","mean"],"values":[[946684800000000,100]]}],"resolution":{"retentionPolicy":"5m","interval":300000000000}}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("GET", s.URL+`/query?db=foo&rollup=always&q=`+q, "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != "invalid rollup: always" {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_Query_MultipleStatements(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.WriteSeries("foo", "bar", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 100.0})
+	srvr.Sync(c.index)
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/db/foo/series?q=SELECT+sum(value)+FROM+mem%3BSELECT+sum(value)+FROM+cpu`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"statement_id":0,"error":"field not found: mem.value"},{"statement_id":1,"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,100]]}]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+// Ensure queries can be sent in the body of a POST.
+func TestHandler_Query_Post(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.WriteSeries("foo", "bar", "cpu", map[string]string{"host": "servera"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 100.0})
+	srvr.WriteSeries("foo", "bar", "cpu", map[string]string{"host": "serverb"}, mustParseTime("2000-01-01T00:00:10Z"), map[string]interface{}{"value": 20.0})
+	srvr.Sync(c.index)
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	for i, tt := range []struct {
+		url         string
+		contentType string
+		body        string
+	}{
+		// Form-encoded body with every parameter.
+		{url: `/query`, contentType: "application/x-www-form-urlencoded", body: `db=foo&q=SELECT+sum(value)+FROM+cpu+WHERE+time+%3C+now()`},
+
+		// Form-encoded body with the database in the URL.
+		{url: `/query?db=foo`, contentType: "application/x-www-form-urlencoded", body: `q=SELECT+sum(value)+FROM+cpu`},
+
+		// Raw body is the query.
+		{url: `/query?db=foo`, contentType: "text/plain", body: "SELECT sum(value)\nFROM cpu\nWHERE time < now()\n"},
+	} {
+		status, body := MustHTTPWithHeaders("POST", s.URL+tt.url, map[string]string{"Content-Type": tt.contentType}, tt.body)
+		if status != http.StatusOK {
+			t.Fatalf("%d. unexpected status: %d: %s", i, status, body)
+		} else if body != `[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,120]]}]}]` {
+			t.Fatalf("%d. unexpected body: %s", i, body)
+		}
+	}
+
+	// Invalid form bodies are rejected.
+	status, body := MustHTTPWithHeaders("POST", s.URL+`/query?db=foo`, map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, `q=%zz`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	// Bodies larger than the query size limit are rejected.
+	status, body = MustHTTPWithHeaders("POST", s.URL+`/query?db=foo`, map[string]string{"Content-Type": "text/plain"}, "SELECT sum(value) FROM cpu"+strings.Repeat(" ", 1<<20))
+	if status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+}
+
+// Ensure credentials can be sent in the form-encoded body of a POST query.
+func TestHandler_Query_Post_Credentials(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.CreateUser("lisa", "password", true)
+	srvr.WriteSeries("foo", "bar", "cpu", map[string]string{"host": "servera"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 100.0})
+	srvr.Sync(c.index)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	form := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+	for i, tt := range []struct {
+		url    string
+		body   string
+		status int
+	}{
+		// Credentials in the form-encoded body.
+		{url: `/query?db=foo`, body: `u=lisa&p=password&q=SELECT+sum(value)+FROM+cpu`, status: http.StatusOK},
+
+		// Credentials in the URL.
+		{url: `/query?db=foo&u=lisa&p=password`, body: `q=SELECT+sum(value)+FROM+cpu`, status: http.StatusOK},
+
+		// Wrong password in the form-encoded body.
+		{url: `/query?db=foo`, body: `u=lisa&p=wrong&q=SELECT+sum(value)+FROM+cpu`, status: http.StatusUnauthorized},
+
+		// No credentials.
+		{url: `/query?db=foo`, body: `q=SELECT+sum(value)+FROM+cpu`, status: http.StatusUnauthorized},
+	} {
+		status, body := MustHTTPWithHeaders("POST", s.URL+tt.url, form, tt.body)
+		if status != tt.status {
+			t.Fatalf("%d. unexpected status: %d: %s", i, status, body)
+		} else if status == http.StatusOK && body != `[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,100]]}]}]` {
+			t.Fatalf("%d. unexpected body: %s", i, body)
+		}
+	}
+}
+
+func TestHandler_Query_BoundParams(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.WriteSeries("foo", "bar", "cpu", map[string]string{"host": "server01"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 100.0})
+	srvr.WriteSeries("foo", "bar", "cpu", map[string]string{"host": "server02"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 200.0})
+	srvr.Sync(c.index)
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	query := url.Values{}
+	query.Set("q", `SELECT sum(value) FROM cpu WHERE host = $host`)
+	query.Set("params", `{"host":"server02"}`)
+	status, body := MustHTTP("GET", s.URL+`/db/foo/series?`+query.Encode(), "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,200]]}]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_Query_BoundParams_BadRequest(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/db/foo/series?q=SELECT+sum(value)+FROM+cpu&params=xxx`, "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `invalid params: invalid character 'x' looking for beginning of value` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("GET", s.URL+`/db/foo/series?q=SELECT+sum(value)+FROM+cpu+WHERE+host+%3D+$host`, "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `parse error: missing parameter: host at line 1, char 41` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_Query_Trace(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.WriteSeries("foo", "bar", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 100.0})
+	srvr.Sync(c.index)
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/db/foo/series?trace=true&q=SELECT+sum(value)+FROM+cpu`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if !strings.Contains(body, `"trace":{"name":"select","detail":"SELECT sum(value) FROM cpu","rows":1,`) {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_Query_Explain(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/db/foo/series?q=EXPLAIN+SELECT+sum(value)+FROM+cpu`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"statement_id":0,"error":"field not found: cpu.value"}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_Query_BadRequest(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/db/foo/series?q=SELECT`, "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `parse error: found EOF, expected identifier, string, number, bool at line 1, char 8` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_Query_DatabaseNotFound(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/db/foo/series?q=SELECT+sum(value)+FROM+cpu`, "")
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != "database not found" {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+// Ensure queries are rejected with a 503 once the server is shutting down.
+func TestHandler_Query_ShuttingDown(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	defer os.RemoveAll(srvr.Path())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+	srvr.Server.Close()
+
+	resp, err := http.Get(s.URL + `/db/foo/series?q=SELECT+sum(value)+FROM+cpu`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	} else if v := resp.Header.Get("Retry-After"); v != "1" {
+		t.Fatalf("unexpected Retry-After: %s", v)
+	} else if string(b) != "server shutting down\n" {
+		t.Fatalf("unexpected body: %q", b)
+	}
+}
+
+func TestHandler_Query_RateLimitExceeded(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateUser("lisa", "password", true)
+	srvr.CreateUser("bob", "password", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	s.Handler.Limits.QueriesPerMinute = 1
+	defer s.Close()
+
+	// The first query is allowed.
+	status, _ := MustHTTP("GET", s.URL+`/db/foo/series?u=lisa&p=password&q=SHOW+STATS`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	// The second query is rejected until the user's rate allows it.
+	resp, err := http.Get(s.URL + `/db/foo/series?u=lisa&p=password&q=SHOW+STATS`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	} else if v := resp.Header.Get("Retry-After"); v != "60" {
+		t.Fatalf("unexpected retry after: %s", v)
+	}
+
+	// Other users are tracked separately.
+	status, _ = MustHTTP("GET", s.URL+`/db/foo/series?u=bob&p=password&q=SHOW+STATS`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+func TestHandler_Query_MaxPointsScanned(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.WriteSeries("foo", "bar", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 100.0})
+	srvr.WriteSeries("foo", "bar", "cpu", nil, mustParseTime("2000-01-01T00:00:10Z"), map[string]interface{}{"value": 200.0})
+	srvr.Sync(c.index)
+	s := NewHTTPServer(srvr)
+	s.Handler.Limits.MaxPointsScanned = 1
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/db/foo/series?q=SELECT+sum(value)+FROM+cpu`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"statement_id":0,"error":"max points scanned exceeded"}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+// Ensure series with more values than the row limit are truncated and marked as partial.
+func TestHandler_Query_MaxRowLimit(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.WriteSeries("foo", "bar", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 100.0})
+	srvr.WriteSeries("foo", "bar", "cpu", nil, mustParseTime("2000-01-01T00:00:10Z"), map[string]interface{}{"value": 200.0})
+	srvr.Sync(c.index)
+	s := NewHTTPServer(srvr)
+	s.Handler.Limits.MaxRowLimit = 1
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/db/foo/series?q=SELECT+value+FROM+cpu`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","value"],"values":[[946684800000000,100]],"partial":true}]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Series within the limit are not marked.
+	status, body = MustHTTP("GET", s.URL+`/db/foo/series?q=SELECT+sum(value)+FROM+cpu`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,300]]}]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_WriteSeries(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/db/foo/series?time_precision=s`, `[{"name":"cpu","tags":{"host":"servera"},"columns":["time","value","up"],"points":[[946684800,100,true],[946684810,20,null]]}]`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != "" {
+		t.Fatalf("unexpected body: %s", body)
+	}
+	srvr.Sync(c.index)
+
+	status, body = MustHTTP("GET", s.URL+`/db/foo/series?q=SELECT+sum(value)+FROM+cpu+WHERE+host%3D'servera'`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,120]]}]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+// Ensure histogram fields can be written and queried for percentiles.
+func TestHandler_WriteSeries_Histogram(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/db/foo/series?time_precision=s`, `[{"name":"http","tags":{"host":"servera"},"columns":["time","latency"],"points":[[946684800,{"bounds":[10,100],"counts":[6,2,0]}]]},{"name":"http","tags":{"host":"serverb"},"columns":["time","latency"],"points":[[946684810,{"bounds":[10,100],"counts":[0,1,1]}]]}]`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	srvr.Sync(c.index)
+
+	for i, tt := range []struct {
+		q    string
+		body string
+	}{
+		{q: `SELECT latency FROM http WHERE host = 'servera'`, body: `[{"statement_id":0,"rows":[{"name":"http","columns":["time","latency"],"values":[[946684800000000,{"bounds":[10,100],"counts":[6,2,0]}]]}]}]`},
+		{q: `SELECT histogram(latency) FROM http`, body: `[{"statement_id":0,"rows":[{"name":"http","columns":["time","histogram"],"values":[[0,{"bounds":[10,100],"counts":[6,3,1]}]]}]}]`},
+		{q: `SELECT percentile(latency, 60) FROM http`, body: `[{"statement_id":0,"rows":[{"name":"http","columns":["time","percentile"],"values":[[0,10]]}]}]`},
+		{q: `SELECT percentile(latency, 75) FROM http GROUP BY host`, body: `[{"statement_id":0,"rows":[{"name":"http","tags":{"host":"servera"},"columns":["time","percentile"],"values":[[0,10]]},{"name":"http","tags":{"host":"serverb"},"columns":["time","percentile"],"values":[[0,100]]}]}]`},
+	} {
+		status, body := MustHTTP("GET", s.URL+`/db/foo/series?q=`+url.QueryEscape(tt.q), "")
+		if status != http.StatusOK {
+			t.Errorf("%d. unexpected status: %d: %s", i, status, body)
+		} else if body != tt.body {
+			t.Errorf("%d. unexpected body: %s", i, body)
+		}
+	}
+}
+
+func TestHandler_WriteSeries_Protobuf(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Encode a WriteRequest with two points.
+	var series []byte
+	series = appendProtoBytes(series, 1, []byte("cpu"))
+	series = appendProtoBytes(series, 2, appendProtoBytes(appendProtoBytes(nil, 1, []byte("host")), 2, []byte("servera")))
+	series = appendProtoBytes(series, 3, []byte("value"))
+	for i, v := range []float64{100, 20} {
+		var pt []byte
+		pt = appendProtoVarint(pt, 1, uint64(946684800000+i*1000))
+		pt = appendProtoBytes(pt, 2, appendProtoDouble(nil, 1, v))
+		series = appendProtoBytes(series, 4, pt)
+	}
+	req := appendProtoBytes(nil, 1, series)
+
+	status, body := MustHTTPWithHeaders("POST", s.URL+`/db/foo/series?rp=bar`, map[string]string{"Content-Type": "application/x-protobuf"}, string(req))
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	srvr.Sync(c.index)
+
+	status, body = MustHTTP("GET", s.URL+`/db/foo/series?q=SELECT+sum(value)+FROM+cpu+WHERE+host%3D'servera'`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,120]]}]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Truncated messages are rejected.
+	status, _ = MustHTTPWithHeaders("POST", s.URL+`/db/foo/series?rp=bar`, map[string]string{"Content-Type": "application/x-protobuf"}, string(req[:len(req)-3]))
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+// Ensure integers written with protobuf are stored and summed exactly.
+func TestHandler_WriteSeries_ProtobufInteger(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Encode a point with an integer and an unsigned value.
+	var series []byte
+	series = appendProtoBytes(series, 1, []byte("billing"))
+	series = appendProtoBytes(series, 3, []byte("bytes"))
+	series = appendProtoBytes(series, 3, []byte("credits"))
+	for i, v := range []int64{9007199254740993, 1} {
+		var pt []byte
+		pt = appendProtoVarint(pt, 1, uint64(946684800000+i*1000))
+		pt = appendProtoBytes(pt, 2, appendProtoVarint(nil, 4, uint64(v)))
+		pt = appendProtoBytes(pt, 2, appendProtoVarint(nil, 5, 18446744073709551615))
+		series = appendProtoBytes(series, 4, pt)
+	}
+	req := appendProtoBytes(nil, 1, series)
+
+	status, body := MustHTTPWithHeaders("POST", s.URL+`/db/foo/series?rp=bar`, map[string]string{"Content-Type": "application/x-protobuf"}, string(req))
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	srvr.Sync(c.index)
+
+	status, body = MustHTTP("GET", s.URL+`/db/foo/series?q=SELECT+sum(bytes)+FROM+billing%3BSELECT+sum(credits)+FROM+billing%3BSELECT+credits+FROM+billing`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"statement_id":0,"rows":[{"name":"billing","columns":["time","sum"],"values":[[0,9007199254740994]]}]},{"statement_id":1,"rows":[{"name":"billing","columns":["time","sum"],"values":[[0,36893488147419103230]]}]},{"statement_id":2,"rows":[{"name":"billing","columns":["time","credits"],"values":[[946684800000000,18446744073709551615],[946684801000000,18446744073709551615]]}]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+// Ensure Prometheus can write samples and read them back with the remote storage protocol.
+func TestHandler_Prometheus(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Encode a WriteRequest with two time series. NaN samples are skipped.
+	t0 := uint64(946684800000)
+	var req []byte
+	for _, ts := range []struct {
+		labels  []string
+		samples []float64
+	}{
+		{labels: []string{"__name__", "cpu", "host", "serverA"}, samples: []float64{1, 2, math.NaN()}},
+		{labels: []string{"__name__", "cpu", "host", "serverB", "region", "uswest"}, samples: []float64{3}},
+	} {
+		req = appendProtoBytes(req, 1, appendPromTimeSeries(nil, ts.labels, t0, ts.samples))
+	}
+
+	status, body := MustHTTPWithHeaders("POST", s.URL+`/api/v1/prom/write?db=foo&rp=bar`, map[string]string{"Content-Encoding": "snappy"}, snappyLiteral(req))
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	srvr.Sync(c.index)
+
+	status, body = MustHTTP("GET", s.URL+`/db/foo/series?q=SELECT+sum(value)+FROM+cpu+GROUP+BY+host`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"statement_id":0,"rows":[{"name":"cpu","tags":{"host":"serverA"},"columns":["time","sum"],"values":[[0,3]]},{"name":"cpu","tags":{"host":"serverB"},"columns":["time","sum"],"values":[[0,3]]}]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Read the series without a region in the first second.
+	var query []byte
+	query = appendProtoVarint(query, 1, t0)
+	query = appendProtoVarint(query, 2, t0+1000)
+	query = appendProtoBytes(query, 3, appendPromMatcher(0, "__name__", "cpu"))
+	query = appendProtoBytes(query, 3, appendPromMatcher(2, "host", "server.*"))
+	query = appendProtoBytes(query, 3, appendPromMatcher(0, "region", ""))
+
+	resp, err := http.Post(s.URL+`/api/v1/prom/read?db=foo&rp=bar`, "application/x-protobuf", strings.NewReader(snappyLiteral(appendProtoBytes(nil, 1, query))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", resp.StatusCode, b)
+	} else if resp.Header.Get("Content-Encoding") != "snappy" {
+		t.Fatalf("unexpected content encoding: %s", resp.Header.Get("Content-Encoding"))
+	}
+
+	exp := appendProtoBytes(nil, 1, appendProtoBytes(nil, 1, appendPromTimeSeries(nil, []string{"__name__", "cpu", "host", "serverA"}, t0, []float64{1, 2})))
+	if act := mustSnappyDecode(b); !bytes.Equal(act, exp) {
+		t.Fatalf("unexpected response:\n\nexp: %x\n\ngot: %x", exp, act)
+	}
+}
+
+// Ensure invalid Prometheus requests are rejected.
+func TestHandler_Prometheus_BadRequest(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	noName := appendProtoBytes(nil, 1, appendPromTimeSeries(nil, []string{"host", "serverA"}, 0, []float64{1}))
+	valid := appendProtoBytes(nil, 1, appendPromTimeSeries(nil, []string{"__name__", "cpu"}, 0, []float64{1}))
+	badRegex := appendProtoBytes(nil, 1, appendProtoBytes(nil, 3, appendPromMatcher(2, "host", "(")))
+	for i, tt := range []struct {
+		path   string
+		body   string
+		status int
+		err    string
+	}{
+		{path: `/api/v1/prom/write?db=foo`, body: "\xff", status: http.StatusBadRequest, err: `snappy: corrupt input`},
+		{path: `/api/v1/prom/write?db=foo`, body: snappyLiteral(noName), status: http.StatusBadRequest, err: `time series has no __name__ label`},
+		{path: `/api/v1/prom/write?db=bad`, body: snappyLiteral(valid), status: http.StatusNotFound, err: `database not found`},
+		{path: `/api/v1/prom/read?db=foo`, body: snappyLiteral(badRegex), status: http.StatusBadRequest, err: "invalid regex: error parsing regexp: missing closing ): `^(?:()$`"},
+		{path: `/api/v1/prom/read?db=bad`, body: snappyLiteral(nil), status: http.StatusNotFound, err: `database not found`},
+	} {
+		status, body := MustHTTPWithHeaders("POST", s.URL+tt.path, map[string]string{"Content-Type": "application/x-protobuf"}, tt.body)
+		if status != tt.status {
+			t.Errorf("%d. unexpected status: %d", i, status)
+		} else if body != tt.err {
+			t.Errorf("%d. unexpected body: %s", i, body)
+		}
+	}
+}
+
+// Ensure annotations can be written and read back.
+func TestHandler_Annotations(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Nothing is returned before anything is annotated.
+	status, body := MustHTTP("GET", s.URL+`/annotations?db=foo`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != "[]" {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("POST", s.URL+`/annotations?db=foo`, `[{"time":"2000-01-01T00:00:00Z","title":"deploy","text":"v1.2","tags":["deploy","prod"]},{"time":"2000-01-01T00:02:00Z","title":"rollback","tags":["deploy"]}]`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	status, body = MustHTTP("POST", s.URL+`/annotations?db=foo&time_precision=s`, `{"time":946684860,"title":"outage"}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	srvr.Sync(c.index)
+
+	for i, tt := range []struct {
+		params string
+		body   string
+	}{
+		{
+			params: `start=2000-01-01T00:00:00Z&end=2000-01-02T00:00:00Z`,
+			body:   `[{"time":"2000-01-01T00:00:00Z","title":"deploy","text":"v1.2","tags":["deploy","prod"]},{"time":"2000-01-01T00:01:00Z","title":"outage"},{"time":"2000-01-01T00:02:00Z","title":"rollback","tags":["deploy"]}]`,
+		},
+		{
+			params: `start=946684800000&end=946684920000`,
+			body:   `[{"time":"2000-01-01T00:00:00Z","title":"deploy","text":"v1.2","tags":["deploy","prod"]},{"time":"2000-01-01T00:01:00Z","title":"outage"}]`,
+		},
+		{
+			params: `tags=deploy`,
+			body:   `[{"time":"2000-01-01T00:00:00Z","title":"deploy","text":"v1.2","tags":["deploy","prod"]},{"time":"2000-01-01T00:02:00Z","title":"rollback","tags":["deploy"]}]`,
+		},
+		{
+			params: `tags=deploy,prod`,
+			body:   `[{"time":"2000-01-01T00:00:00Z","title":"deploy","text":"v1.2","tags":["deploy","prod"]}]`,
+		},
+		{
+			params: `start=2000-01-02T00:00:00Z`,
+			body:   `[]`,
+		},
+	} {
+		status, body := MustHTTP("GET", s.URL+`/annotations?db=foo&`+tt.params, "")
+		if status != http.StatusOK {
+			t.Fatalf("%d. unexpected status: %d: %s", i, status, body)
+		} else if body != tt.body {
+			t.Fatalf("%d. unexpected body: %s", i, body)
+		}
+	}
+
+	// Annotations can also be read with a query.
+	status, body = MustHTTP("GET", s.URL+`/db/foo/series?q=SELECT+title+FROM+annotations`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"statement_id":0,"rows":[{"name":"annotations","columns":["time","title"],"values":[[946684800000000,"deploy"],[946684860000000,"outage"],[946684920000000,"rollback"]]}]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+// Ensure invalid annotation requests are rejected.
+func TestHandler_Annotations_BadRequest(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	for i, tt := range []struct {
+		method string
+		url    string
+		body   string
+		status int
+		err    string
+	}{
+		{method: "POST", url: `/annotations?db=foo`, body: `{"text":"no title"}`, status: http.StatusBadRequest, err: "annotation title required"},
+		{method: "POST", url: `/annotations?db=foo`, body: `{"title":"a","tags":["a,b"]}`, status: http.StatusBadRequest, err: "invalid annotation tag"},
+		{method: "POST", url: `/annotations?db=foo`, body: `{"title":"a","time":"yesterday"}`, status: http.StatusBadRequest, err: "invalid time: yesterday"},
+		{method: "POST", url: `/annotations?db=foo`, body: `[{"title":`, status: http.StatusBadRequest, err: "unexpected EOF"},
+		{method: "POST", url: `/annotations?db=bar`, body: `{"title":"a"}`, status: http.StatusNotFound, err: "database not found"},
+		{method: "GET", url: `/annotations?db=bar`, status: http.StatusNotFound, err: "database not found"},
+		{method: "GET", url: `/annotations?db=foo&start=now`, status: http.StatusBadRequest, err: "invalid time: now"},
+	} {
+		status, body := MustHTTP(tt.method, s.URL+tt.url, tt.body)
+		if status != tt.status {
+			t.Errorf("%d. unexpected status: %d: %s", i, status, body)
+		} else if body != tt.err {
+			t.Errorf("%d. unexpected body: %s", i, body)
+		}
+	}
+}
+
+// Ensure pipe queries can be executed.
+func TestHandler_Query2(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	srvr.WriteSeries("foo", "bar", "cpu", map[string]string{"host": "server01"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 10.0})
+	srvr.WriteSeries("foo", "bar", "cpu", map[string]string{"host": "server01"}, mustParseTime("2000-01-01T00:00:30Z"), map[string]interface{}{"value": 20.0})
+	srvr.WriteSeries("foo", "bar", "cpu", map[string]string{"host": "server02"}, mustParseTime("2000-01-01T00:01:00Z"), map[string]interface{}{"value": 100.0})
+	srvr.WriteSeries("foo", "bar", "mem", map[string]string{"host": "server01"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"free": 512.0})
+	srvr.Sync(c.index)
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	for i, tt := range []struct {
+		q    string
+		body string
+	}{
+		{
+			q:    `from(bucket: "foo") |> range(start: 2000-01-01T00:00:00Z, stop: 2000-01-01T00:02:00Z) |> filter(fn: (r) => r._measurement == "cpu" and r._field == "value" and r.host == "server01")`,
+			body: `{"results":[{"name":"_result","tables":[{"key":{"_field":"value","_measurement":"cpu","host":"server01"},"columns":["_time","_value"],"values":[["2000-01-01T00:00:00Z",10],["2000-01-01T00:00:30Z",20]]}]}]}`,
+		},
+		{
+			q:    `from(bucket: "foo/bar") |> range(start: 2000-01-01T00:00:00Z, stop: 2000-01-01T00:02:00Z) |> filter(fn: (r) => r._measurement == "cpu" and r._field == "value") |> aggregateWindow(every: 1m, fn: sum)`,
+			body: `{"results":[{"name":"_result","tables":[{"key":{"_field":"value","_measurement":"cpu","host":"server01"},"columns":["_time","_value"],"values":[["2000-01-01T00:00:00Z",30],["2000-01-01T00:01:00Z",0]]},{"key":{"_field":"value","_measurement":"cpu","host":"server02"},"columns":["_time","_value"],"values":[["2000-01-01T00:00:00Z",0],["2000-01-01T00:01:00Z",100]]}]}]}`,
+		},
+		{
+			q:    `from(bucket: "foo") |> filter(fn: (r) => r._measurement == "cpu" and r._field == "value" and r._value >= 20) |> group() |> yield(name: "high")`,
+			body: `{"results":[{"name":"high","tables":[{"key":{"_field":"value","_measurement":"cpu"},"columns":["_time","_value"],"values":[["2000-01-01T00:00:30Z",20],["2000-01-01T00:01:00Z",100]]}]}]}`,
+		},
+		{
+			q: `
+				cpu = from(bucket: "foo") |> filter(fn: (r) => r._measurement == "cpu" and r._field == "value")
+				mem = from(bucket: "foo") |> filter(fn: (r) => r._measurement == "mem" and r._field == "free")
+				join(tables: {cpu: cpu, mem: mem}, on: ["_time", "host"])
+					|> pivot(rowKey: ["_time"], columnKey: ["host"], valueColumn: "_value_cpu")`,
+			body: `{"results":[{"name":"_result","tables":[{"key":{},"columns":["_time","server01"],"values":[["2000-01-01T00:00:00Z",10]]}]}]}`,
+		},
+	} {
+		status, body := MustHTTP("POST", s.URL+`/query2`, tt.q)
+		if status != http.StatusOK {
+			t.Errorf("%d. unexpected status: %d: %s", i, status, body)
+		} else if body != tt.body {
+			t.Errorf("%d. unexpected body: %s", i, body)
+		}
+	}
+
+	// Queries can also be passed as a parameter.
+	status, body := MustHTTP("GET", s.URL+`/query2?q=`+url.QueryEscape(`from(bucket: "foo") |> filter(fn: (r) => r._measurement == "mem" and r._field == "free") |> group() |> count()`), "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"results":[{"name":"_result","tables":[{"key":{"_field":"free","_measurement":"mem"},"columns":["_value"],"values":[[1]]}]}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+// Ensure invalid pipe queries are rejected.
+func TestHandler_Query2_BadRequest(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	for i, tt := range []struct {
+		q      string
+		status int
+		err    string
+	}{
+		{q: `from(bucket: "foo") |>`, status: http.StatusBadRequest, err: `parse error: found EOF, expected function call at line 1, char 23`},
+		{q: `from(bucket: "foo")`, status: http.StatusBadRequest, err: `filter() on _measurement required`},
+		{q: `from(bucket: "bar") |> filter(fn: (r) => r._measurement == "cpu" and r._field == "value")`, status: http.StatusNotFound, err: `database not found`},
+	} {
+		status, body := MustHTTP("POST", s.URL+`/query2`, tt.q)
+		if status != tt.status {
+			t.Errorf("%d. unexpected status: %d: %s", i, status, body)
+		} else if body != tt.err {
+			t.Errorf("%d. unexpected body: %s", i, body)
+		}
+	}
+}
+
+func TestHandler_WriteSeries_BadRequest(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	for i, tt := range []struct {
+		url         string
+		contentType string
+		body        string
+		status      int
+		err         string
+	}{
+		{url: `/db/foo/series`, body: `[{"name":`, status: http.StatusBadRequest},
+		{url: `/db/foo/series?time_precision=x`, body: `[]`, status: http.StatusBadRequest, err: `Unknown time precision x`},
+		{url: `/db/foo/series?rp=bar`, body: `[{"name":"cpu","columns":["value"],"points":[[1,2]]}]`, status: http.StatusBadRequest, err: `series "cpu": expected 1 values, got 2`},
+		{url: `/db/foo/series?rp=bar`, body: `[{"name":"cpu","columns":["value"],"points":[[1]]},null]`, status: http.StatusBadRequest, err: `series 1: missing`},
+		{url: `/db/foo/series?rp=bar`, body: `[{"name":"cpu","columns":["value"],"points":[[1]]},{"name":"","columns":["value"],"points":[[1]]}]`, status: http.StatusBadRequest, err: `{"error":"point 1: measurement name required","points":[{"index":1,"error":"measurement name required"}]}`},
+		{url: `/db/foo/series?rp=bar`, body: `[{"name":"cpu","columns":["time"],"points":[[1]]}]`, status: http.StatusBadRequest, err: `{"error":"point 0: fields required","points":[{"index":0,"error":"fields required"}]}`},
+		{url: `/db/foo/series?rp=bar`, body: `[{"name":"cpu","columns":["value"],"points":[[[1]]]}]`, status: http.StatusBadRequest, err: `{"error":"point 0: \"value\": unsupported value: [1]","points":[{"index":0,"key":"value","error":"unsupported value: [1]"}]}`},
+		{url: `/db/foo/series?rp=bar`, body: `[{"name":"http","columns":["latency"],"points":[[{"bounds":[1],"counts":[1]}]]}]`, status: http.StatusBadRequest, err: `{"error":"point 0: \"latency\": invalid histogram","points":[{"index":0,"key":"latency","error":"invalid histogram"}]}`},
+		{url: `/db/foo/series?rp=bar`, body: `[{"name":"http","columns":["latency"],"points":[[{"bounds":["1"],"counts":[1,2]}]]}]`, status: http.StatusBadRequest, err: `series "http": histogram bounds must be a list of numbers`},
+		{url: `/db/foo/series?rp=bar`, body: `[{"name":"http","columns":["latency"],"points":[[{"sum":1}]]}]`, status: http.StatusBadRequest, err: `series "http": invalid histogram key: sum`},
+		{url: `/db/foo/series`, contentType: "text/plain", body: `cpu value=1`, status: http.StatusUnsupportedMediaType, err: `unsupported content type: text/plain`},
+		{url: `/db/bat/series`, body: `[]`, status: http.StatusNotFound, err: `database not found`},
+	} {
+		headers := map[string]string{}
+		if tt.contentType != "" {
+			headers["Content-Type"] = tt.contentType
+		}
+		status, body := MustHTTPWithHeaders("POST", s.URL+tt.url, headers, tt.body)
+		if status != tt.status {
+			t.Errorf("%d. unexpected status: %d: %s", i, status, body)
+		} else if tt.err != "" && body != tt.err {
+			t.Errorf("%d. unexpected body: %s", i, body)
+		}
+	}
+}
+
+func TestHandler_WriteSeries_PointLimits(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	srvr.SetPointLimits(influxdb.PointLimits{MaxFields: 1})
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/db/foo/series`, `[{"name":"cpu","columns":["value"],"points":[[1],[2]]},{"name":"mem","columns":["free","used"],"points":[[1,2],[null,3]]}]`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"error":"point 2: too many fields","points":[{"index":2,"error":"too many fields"}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+	srvr.Sync(c.index)
+
+	// Verify no points were written.
+	if n := srvr.DatabaseStats("foo").Get(influxdb.StatPointsWritten); n != 0 {
+		t.Fatalf("unexpected points written: %d", n)
+	}
+}
+
+// Ensure timestamps outside the handler's skew bounds are replaced with the
+// server's clock.
+func TestHandler_WriteSeries_TimestampSkew(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	s.Handler.Timestamps = influxdb.TimestampPolicy{MaxPastSkew: 10 * time.Minute, MaxFutureSkew: 10 * time.Minute}
+	defer s.Close()
+
+	// Write one point with a current timestamp and two from bad clocks.
+	now := time.Now().Unix()
+	status, body := MustHTTP("POST", s.URL+`/db/foo/series?time_precision=s`, fmt.Sprintf(`[{"name":"cpu","columns":["time","value"],"points":[[%d,1],[946684800,2]]},{"name":"mem","columns":["time","value"],"points":[[%d,3]]}]`, now-60, now+86400))
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	srvr.Sync(c.index)
+
+	// Verify every point was written within the last few minutes.
+	for i, tt := range []struct {
+		q   string
+		sum string
+	}{
+		{q: `SELECT sum(value) FROM cpu WHERE time > now() - 5m AND time < now() + 5m`, sum: `,3]]`},
+		{q: `SELECT sum(value) FROM mem WHERE time > now() - 5m AND time < now() + 5m`, sum: `,3]]`},
+	} {
+		status, body := MustHTTP("GET", s.URL+`/db/foo/series?q=`+url.QueryEscape(tt.q), "")
+		if status != http.StatusOK {
+			t.Fatalf("%d. unexpected status: %d: %s", i, status, body)
+		} else if !strings.HasSuffix(body, tt.sum+`}]}]`) {
+			t.Fatalf("%d. unexpected body: %s", i, body)
+		}
+	}
+	if n := srvr.DatabaseStats("foo").Get(influxdb.StatTimestampsCorrected); n != 2 {
+		t.Fatalf("unexpected timestamps corrected: %d", n)
+	}
+}
+
+// Ensure a dry run reports the schema changes of a write without writing it.
+func TestHandler_WriteSeries_DryRun(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/db/foo/series`, `[{"name":"cpu","tags":{"host":"servera"},"columns":["value"],"points":[[100]]}]`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	srvr.Sync(c.index)
+
+	// Validate a write with a new series, field and measurement.
+	status, body = MustHTTP("POST", s.URL+`/db/foo/series?dry_run=true`, `[{"name":"cpu","tags":{"host":"serverb"},"columns":["value","idle"],"points":[[100,true]]},{"name":"mem","columns":["free"],"points":[["low"]]}]`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"points":2,"newMeasurements":["mem"],"newFields":[{"measurement":"cpu","name":"idle","type":"boolean"},{"measurement":"mem","name":"free","type":"string"}],"newSeries":2,"seriesN":3}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Validate a write that conflicts with an existing field type.
+	status, body = MustHTTP("POST", s.URL+`/db/foo/series?dry_run=true`, `[{"name":"cpu","tags":{"host":"servera"},"columns":["value"],"points":[["high"]]}]`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"error":"point 0: \"value\": field type conflict","points":[{"index":0,"key":"value","error":"field type conflict"}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Validate against a retention policy that does not exist.
+	status, body = MustHTTP("POST", s.URL+`/db/foo/series?dry_run=true&rp=baz`, `[{"name":"cpu","columns":["value"],"points":[[100]]}]`)
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	srvr.Sync(c.index)
+
+	// Verify only the first write was applied.
+	if n := srvr.DatabaseStats("foo").Get(influxdb.StatPointsWritten); n != 1 {
+		t.Fatalf("unexpected points written: %d", n)
+	}
+}
+
+// Ensure measurement schemas can be declared, listed and removed.
+func TestHandler_MeasurementSchemas(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("PUT", s.URL+`/db/foo/schemas/cpu`, `{"fields":[{"name":"value","type":"number"}],"tags":["host"]}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	status, body = MustHTTP("PUT", s.URL+`/db/foo/schemas/mem`, `{"fields":[{"name":"free","type":"float"}]}`)
+	if status != http.StatusBadRequest || body != `invalid field type` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+	status, body = MustHTTP("PUT", s.URL+`/db/foo/schemas/mem`, `{"duplicates":"avg"}`)
+	if status != http.StatusBadRequest || body != `invalid duplicate policy` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+	status, body = MustHTTP("PUT", s.URL+`/db/foo`, `{"strictSchema":true}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	status, body = MustHTTP("GET", s.URL+`/db/foo/schemas`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"name":"cpu","fields":[{"name":"value","type":"number"}],"tags":["host"]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Writes to undeclared fields are rejected with the offending key.
+	status, body = MustHTTP("POST", s.URL+`/db/foo/series`, `[{"name":"cpu","tags":{"host":"servera"},"columns":["value","idle"],"points":[[100,true]]}]`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"error":"point 0: \"idle\": field not declared","points":[{"index":0,"key":"idle","error":"field not declared"}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("DELETE", s.URL+`/db/foo/schemas/cpu`, "")
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	status, body = MustHTTP("DELETE", s.URL+`/db/foo/schemas/cpu`, "")
+	if status != http.StatusNotFound || body != `measurement schema not found` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+}
+
+// Ensure only admins can change measurement schemas.
+func TestHandler_MeasurementSchemas_NonAdmin(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateUser("lisa", "password", true)
+	srvr.CreateUser("bob", "password", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("PUT", s.URL+`/db/foo/schemas/cpu?u=bob&p=password`, `{"fields":[{"name":"value","type":"number"}]}`)
+	if status != http.StatusForbidden || body != `admin required` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+	status, body = MustHTTP("PUT", s.URL+`/db/foo/schemas/cpu?u=lisa&p=password`, `{"fields":[{"name":"value","type":"number"}]}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	status, body = MustHTTP("DELETE", s.URL+`/db/foo/schemas/cpu?u=bob&p=password`, "")
+	if status != http.StatusForbidden || body != `admin required` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+}
+
+// Ensure tag guards can be set, listed and removed.
+func TestHandler_TagGuards(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("PUT", s.URL+`/db/foo/tag_guards/cpu/host`, `{"allow":["servera"]}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	status, body = MustHTTP("PUT", s.URL+`/db/foo/tag_guards/cpu/region`, `{}`)
+	if status != http.StatusBadRequest || body != `tag guard requires max values or allowed values` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+
+	status, body = MustHTTP("GET", s.URL+`/db/foo/tag_guards`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"measurement":"cpu","key":"host","allow":["servera"]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("POST", s.URL+`/db/foo/series`, `[{"name":"cpu","tags":{"host":"serverb"},"columns":["value"],"points":[[100]]}]`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"error":"point 0: \"host\": tag value not allowed","points":[{"index":0,"key":"host","error":"tag value not allowed"}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("DELETE", s.URL+`/db/foo/tag_guards/cpu/host`, "")
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	status, body = MustHTTP("DELETE", s.URL+`/db/foo/tag_guards/cpu/host`, "")
+	if status != http.StatusNotFound || body != `tag guard not found` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+}
+
+// Ensure only admins can change tag guards.
+func TestHandler_TagGuards_NonAdmin(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateUser("lisa", "password", true)
+	srvr.CreateUser("bob", "password", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("PUT", s.URL+`/db/foo/tag_guards/cpu/host?u=bob&p=password`, `{"allow":["servera"]}`)
+	if status != http.StatusForbidden || body != `admin required` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+	status, body = MustHTTP("PUT", s.URL+`/db/foo/tag_guards/cpu/host?u=lisa&p=password`, `{"allow":["servera"]}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	status, body = MustHTTP("DELETE", s.URL+`/db/foo/tag_guards/cpu/host?u=bob&p=password`, "")
+	if status != http.StatusForbidden || body != `admin required` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+}
+
+func TestHandler_AlertRules(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("PUT", s.URL+`/db/foo/alerts/cpu_high`, `{"query":"SELECT mean(value) FROM cpu GROUP BY host","every":60000000000,"type":"threshold","operator":">","threshold":90,"notify":["https://hooks.example.com/alerts"]}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	status, body = MustHTTP("PUT", s.URL+`/db/foo/alerts/cpu_dead`, `{"query":"SELECT count(value) FROM cpu","every":60000000000,"type":"heartbeat","notify":["mailto:ops@example.com"]}`)
+	if status != http.StatusBadRequest || body != `alert type must be threshold or deadman` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+
+	status, body = MustHTTP("GET", s.URL+`/db/foo/alerts`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"name":"cpu_high","query":"SELECT mean(value) FROM cpu GROUP BY host","every":60000000000,"type":"threshold","operator":"\u003e","threshold":90,"notify":["https://hooks.example.com/alerts"]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("DELETE", s.URL+`/db/foo/alerts/cpu_high`, "")
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	status, body = MustHTTP("DELETE", s.URL+`/db/foo/alerts/cpu_high`, "")
+	if status != http.StatusNotFound || body != `alert rule not found` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+}
+
+// Ensure only admins can change alert rules.
+func TestHandler_AlertRules_NonAdmin(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateUser("lisa", "password", true)
+	srvr.CreateUser("bob", "password", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("PUT", s.URL+`/db/foo/alerts/cpu_high?u=bob&p=password`, `{"query":"SELECT mean(value) FROM cpu","every":60000000000,"type":"threshold","operator":">","threshold":90,"notify":["https://hooks.example.com/alerts"]}`)
+	if status != http.StatusForbidden || body != `admin required` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+	status, body = MustHTTP("PUT", s.URL+`/db/foo/alerts/cpu_high?u=lisa&p=password`, `{"query":"SELECT mean(value) FROM cpu","every":60000000000,"type":"threshold","operator":">","threshold":90,"notify":["https://hooks.example.com/alerts"]}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	status, body = MustHTTP("DELETE", s.URL+`/db/foo/alerts/cpu_high?u=bob&p=password`, "")
+	if status != http.StatusForbidden || body != `admin required` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+}
+
+func TestHandler_RoutingRules(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("PUT", s.URL+`/db/foo/routes/logs`, `{"measurement":"logs_*","database":"logs"}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	status, body = MustHTTP("PUT", s.URL+`/db/foo/routes/all`, `{"database":"logs"}`)
+	if status != http.StatusBadRequest || body != `invalid routing rule` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+
+	status, body = MustHTTP("GET", s.URL+`/db/foo/routes`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"name":"logs","measurement":"logs_*","database":"logs"}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("DELETE", s.URL+`/db/foo/routes/logs`, "")
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	status, body = MustHTTP("DELETE", s.URL+`/db/foo/routes/logs`, "")
+	if status != http.StatusNotFound || body != `routing rule not found` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+}
+
+// Ensure only admins can change routing rules.
+func TestHandler_RoutingRules_NonAdmin(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateUser("lisa", "password", true)
+	srvr.CreateUser("bob", "password", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
 
+	status, body := MustHTTP("PUT", s.URL+`/db/foo/routes/logs?u=bob&p=password`, `{"measurement":"logs_*","database":"logs"}`)
+	if status != http.StatusForbidden || body != `admin required` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+	status, body = MustHTTP("PUT", s.URL+`/db/foo/routes/logs?u=lisa&p=password`, `{"measurement":"logs_*","database":"logs"}`)
 	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	status, body = MustHTTP("DELETE", s.URL+`/db/foo/routes/logs?u=bob&p=password`, "")
+	if status != http.StatusForbidden || body != `admin required` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+}
+
+func TestHandler_WriteSeries_RequestID(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Send the same request twice. The retry should not be written.
+	for i, duplicate := range []string{"", "true"} {
+		req, _ := http.NewRequest("POST", s.URL+`/db/foo/series?time_precision=s`, strings.NewReader(`[{"name":"cpu","columns":["time","value"],"points":[[946684800,100]]}]`))
+		req.Header.Set("X-Influxdb-Request-Id", "abc123")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("%d. unexpected status: %d", i, resp.StatusCode)
+		} else if v := resp.Header.Get("X-Influxdb-Duplicate"); v != duplicate {
+			t.Fatalf("%d. unexpected duplicate header: %q", i, v)
+		}
+	}
+	srvr.Sync(c.index)
+
+	if n := srvr.DatabaseStats("foo").Get(influxdb.StatPointsWritten); n != 1 {
+		t.Fatalf("unexpected points written: %d", n)
+	}
+
+	// Invalid ids are rejected.
+	status, body := MustHTTPWithHeaders("POST", s.URL+`/db/foo/series`, map[string]string{"X-Influxdb-Request-Id": "bad id!"}, `[]`)
+	if status != http.StatusBadRequest {
 		t.Fatalf("unexpected status: %d", status)
-	} else if body != "" {
+	} else if body != `invalid request id` {
 		t.Fatalf("unexpected body: %s", body)
 	}
 }
 
-func TestHandler_DeleteDatabase_NotFound(t *testing.T) {
+func TestHandler_WriteSeries_Backpressure(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	srvr.WriteSeries("foo", "bar", "cpu", nil, time.Unix(0, 0), map[string]interface{}{"value": 1.0})
+	srvr.Sync(c.index)
+	srvr.SetWriteBackpressure(0, 1)
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Hold published writes so they are not applied.
+	var held []*messaging.Message
+	c.PublishFunc = func(m *messaging.Message) (uint64, error) {
+		held = append(held, m)
+		return m.Index, nil
+	}
+
+	status, body := MustHTTP("POST", s.URL+`/db/foo/series?time_precision=s`, `[{"name":"cpu","columns":["time","value"],"points":[[0,100]]}]`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	// The next write should be rejected until the first is applied.
+	req, _ := http.NewRequest("POST", s.URL+`/db/foo/series?time_precision=s`, strings.NewReader(`[{"name":"cpu","columns":["time","value"],"points":[[1,100]]}]`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	} else if v := resp.Header.Get("Retry-After"); v != "1" {
+		t.Fatalf("unexpected Retry-After: %s", v)
+	} else if string(b) != `{"error":"server overloaded","reason":"write log behind"}`+"\n" {
+		t.Fatalf("unexpected body: %s", b)
+	}
+
+	status, body = MustHTTP("GET", s.URL+`/health`, "")
+	if status != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"status":"overloaded","writes":{"pendingPoints":0,"unappliedWrites":1,"backpressure":"write log behind"}}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Apply the held write and retry.
+	for _, m := range held {
+		c.send(m)
+	}
+	srvr.Sync(c.index)
+
+	status, body = MustHTTP("GET", s.URL+`/health`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"status":"ok","writes":{"pendingPoints":0,"unappliedWrites":0}}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+// Ensure the handler returns server statistics in the Prometheus text format.
+func TestHandler_Metrics(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/db/foo/series`, `[{"name":"cpu","columns":["time","value"],"points":[[0,100],[1,200]]}]`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	srvr.Sync(c.index)
+
+	resp, err := http.Get(s.URL + `/metrics`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	} else if v := resp.Header.Get("Content-Type"); v != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Fatalf("unexpected content type: %s", v)
+	}
+	for _, line := range []string{
+		"# HELP influxdb_database_points_written_total Number of points written.\n# TYPE influxdb_database_points_written_total counter\n",
+		"influxdb_database_points_written_total{database=\"foo\"} 2\n",
+		"influxdb_database_write_errors_total{database=\"foo\"} 0\n",
+		"# TYPE influxdb_database_series gauge\ninfluxdb_database_series{database=\"foo\"} 1\n",
+		"influxdb_write_backpressure 0\n",
+	} {
+		if !strings.Contains(string(b), line) {
+			t.Errorf("missing %q in body:\n%s", line, b)
+		}
+	}
+}
+
+// Ensure the metrics endpoint can be restricted to admin users.
+func TestHandler_Metrics_Authentication(t *testing.T) {
 	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateUser("lisa", "password", true)
+	srvr.CreateUser("bob", "password", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	// Metrics don't require credentials by default.
+	if status, _ := MustHTTP("GET", s.URL+`/metrics`, ""); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	s.Handler.MetricsAuthenticationEnabled = true
+	for i, tt := range []struct {
+		query  string
+		status int
+	}{
+		{query: ``, status: http.StatusUnauthorized},
+		{query: `?u=bob&p=password`, status: http.StatusForbidden},
+		{query: `?u=lisa&p=password`, status: http.StatusOK},
+	} {
+		if status, _ := MustHTTP("GET", s.URL+`/metrics`+tt.query, ""); status != tt.status {
+			t.Errorf("%d. unexpected status: %d", i, status)
+		}
+	}
+}
+
+func TestHandler_TimePrecision(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
 	s := NewHTTPServer(srvr)
 	defer s.Close()
 
-	status, body := MustHTTP("DELETE", s.URL+`/db/foo`, "")
+	status, body := MustHTTP("POST", s.URL+`/db/foo/series?time_precision=h`, `[{"name":"cpu","columns":["time","value"],"points":[[262968,100]]}]`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	srvr.Sync(c.index)
+
+	for i, tt := range []struct {
+		precision string
+		body      string
+	}{
+		{precision: "", body: `[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","sum"],"values":[[946684800000000,100]]}]}]`},
+		{precision: "s", body: `[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","sum"],"values":[[946684800,100]]}]}]`},
+		{precision: "m", body: `[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","sum"],"values":[[15778080,100]]}]}]`},
+		{precision: "h", body: `[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","sum"],"values":[[262968,100]]}]}]`},
+	} {
+		status, body := MustHTTP("GET", s.URL+`/db/foo/series?time_precision=`+tt.precision+`&q=SELECT+sum(value)+FROM+cpu+WHERE+time+%3E%3D+'2000-01-01'+AND+time+%3C+'2000-01-01T00:00:01Z'`, "")
+		if status != http.StatusOK {
+			t.Errorf("%d. unexpected status: %d", i, status)
+		} else if body != tt.body {
+			t.Errorf("%d. unexpected body: %s", i, body)
+		}
+	}
 
-	if status != http.StatusNotFound {
+	status, body = MustHTTP("GET", s.URL+`/db/foo/series?time_precision=x&q=SELECT+sum(value)+FROM+cpu`, "")
+	if status != http.StatusBadRequest {
 		t.Fatalf("unexpected status: %d", status)
-	} else if body != `database not found` {
+	} else if body != `Unknown time precision x` {
 		t.Fatalf("unexpected body: %s", body)
 	}
 }
@@ -130,9 +2044,70 @@ func TestHandler_Shards(t *testing.T) {
 	defer s.Close()
 
 	status, body := MustHTTP("GET", s.URL+`/db/foo/shards`, "")
+	var shards []*influxdb.ShardInfo
 	if status != http.StatusOK {
 		t.Fatalf("unexpected status: %d", status)
-	} else if body != `[{"id":3,"startTime":"0001-01-01T00:00:00Z","endTime":"0001-01-01T00:00:00Z"}]` {
+	} else if err := json.Unmarshal([]byte(body), &shards); err != nil {
+		t.Fatalf("unexpected body: %s", body)
+	} else if len(shards) != 1 {
+		t.Fatalf("unexpected shard count: %d", len(shards))
+	} else if sh := shards[0]; sh.ID != 3 || sh.RetentionPolicy != "bar" || !sh.StartTime.IsZero() || !sh.EndTime.IsZero() {
+		t.Fatalf("unexpected shard: %s", body)
+	} else if sh.SeriesN != 0 || sh.Size <= 0 || sh.State != influxdb.ShardCold {
+		t.Fatalf("unexpected shard metadata: %s", body)
+	}
+}
+
+func TestHandler_Shards_TimeRange(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.WriteSeries("foo", "bar", "cpu", map[string]string{"host": "servera"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 100.0})
+	srvr.WriteSeries("foo", "bar", "cpu", map[string]string{"host": "serverb"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 100.0})
+	srvr.WriteSeries("foo", "bar", "cpu", map[string]string{"host": "servera"}, mustParseTime("2000-01-01T02:00:00Z"), map[string]interface{}{"value": 100.0})
+	srvr.Sync(c.index)
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	for i, tt := range []struct {
+		query   string
+		seriesN []int
+	}{
+		{query: ``, seriesN: []int{2, 1}},
+		{query: `?from=946690200`, seriesN: []int{1}}, // 2000-01-01T01:30:00Z
+		{query: `?to=946690200`, seriesN: []int{2}},
+		{query: `?from=946684800&to=946692000`, seriesN: []int{2, 1}},
+		{query: `?from=946697400`, seriesN: []int{}}, // 2000-01-01T03:30:00Z
+	} {
+		status, body := MustHTTP("GET", s.URL+`/db/foo/shards`+tt.query, "")
+		var shards []*influxdb.ShardInfo
+		if status != http.StatusOK {
+			t.Fatalf("%d. unexpected status: %d", i, status)
+		} else if err := json.Unmarshal([]byte(body), &shards); err != nil {
+			t.Fatalf("%d. unexpected body: %s", i, body)
+		}
+
+		seriesN := []int{}
+		for _, sh := range shards {
+			seriesN = append(seriesN, sh.SeriesN)
+		}
+		if !reflect.DeepEqual(seriesN, tt.seriesN) {
+			t.Fatalf("%d. unexpected series counts: %v", i, seriesN)
+		}
+	}
+}
+
+func TestHandler_Shards_BadRequest(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/db/foo/shards?from=yesterday`, "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if !strings.HasPrefix(body, `invalid from: `) {
 		t.Fatalf("unexpected body: %s", body)
 	}
 }
@@ -151,6 +2126,110 @@ func TestHandler_Shards_DatabaseNotFound(t *testing.T) {
 	}
 }
 
+func TestHandler_DeleteShard(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.CreateShardsIfNotExists("foo", "bar", time.Time{})
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("DELETE", s.URL+`/db/foo/shards/3`, "")
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != "" {
+		t.Fatalf("unexpected body: %s", body)
+	} else if ss, _ := srvr.Shards("foo"); len(ss) != 0 {
+		t.Fatalf("unexpected shard count: %d", len(ss))
+	}
+}
+
+func TestHandler_DeleteShard_NotFound(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("DELETE", s.URL+`/db/foo/shards/100`, "")
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `shard not found` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("DELETE", s.URL+`/db/bar/shards/100`, "")
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `database not found` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_DeleteShard_BadRequest(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("DELETE", s.URL+`/db/foo/shards/abc`, "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `invalid shard id` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_Deletions(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	srvr.WriteSeries("foo", "bar", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 1.0})
+	srvr.Sync(c.index)
+	if err := srvr.DeletePoints("foo", "cpu", mustParseTime("2000-01-01T00:30:00Z")); err != nil {
+		t.Fatal(err)
+	}
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/db/foo/deletions`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if a, _ := srvr.Deletions("foo"); len(a) != 1 || body != mustMarshalJSON(a) {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("POST", s.URL+`/db/foo/deletions/purge`, "")
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != "" {
+		t.Fatalf("unexpected body: %s", body)
+	} else if a, _ := srvr.Deletions("foo"); len(a) != 0 {
+		t.Fatalf("unexpected deletions: %s", mustMarshalJSON(a))
+	}
+
+	status, body = MustHTTP("POST", s.URL+`/db/bar/deletions/purge`, "")
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `database not found` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_Jobs(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/jobs`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
 func TestHandler_RetentionPolicies(t *testing.T) {
 	srvr := OpenServer(NewMessagingClient())
 	srvr.CreateDatabase("foo")
@@ -229,6 +2308,22 @@ func TestHandler_CreateRetentionPolicy_Conflict(t *testing.T) {
 	}
 }
 
+func TestHandler_CreateRetentionPolicy_InvalidDownsample(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	policy := `{"name": "bar", "downsample": {"after": 3600000000000, "interval": 300000000000, "function": "max"}}`
+	status, body := MustHTTP("POST", s.URL+`/db/foo/retention_policies`, policy)
+
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != "invalid downsample function" {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
 func TestHandler_CreateRetentionPolicy_BadRequest(t *testing.T) {
 	srvr := OpenServer(NewMessagingClient())
 	srvr.CreateDatabase("foo")
@@ -359,6 +2454,44 @@ func TestHandler_DeleteRetentionPolicy_NotFound(t *testing.T) {
 	}
 }
 
+// Ensure admins can start backfills and follow their progress.
+func TestHandler_Backfill(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "raw")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "5m", Downsample: &influxdb.Downsample{Interval: 5 * time.Minute}})
+	srvr.CreateUser("lisa", "password", true)
+	srvr.CreateUser("bob", "password", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/db/foo/retention_policies/5m/backfill?u=lisa&p=password`, `{"start":"2000-01-01T00:00:00Z","end":"2000-01-01T01:00:00Z","pointsPerSecond":1000}`)
+	if status != http.StatusAccepted {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if !strings.Contains(body, `"id":1,"database":"foo","retentionPolicy":"5m","start":"2000-01-01T00:00:00Z","end":"2000-01-01T01:00:00Z","pointsPerSecond":1000`) {
+		t.Fatalf("unexpected body: %s", body)
+	}
+	status, body = MustHTTP("GET", s.URL+`/backfills?u=lisa&p=password`, "")
+	if status != http.StatusOK || !strings.Contains(body, `"id":1,`) {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+
+	// Policies without a rule can't be backfilled and only admins can backfill.
+	status, body = MustHTTP("POST", s.URL+`/db/foo/retention_policies/raw/backfill?u=lisa&p=password`, `{"start":"2000-01-01T00:00:00Z","end":"2000-01-01T01:00:00Z"}`)
+	if status != http.StatusNotFound || body != "downsample not found" {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+	status, body = MustHTTP("POST", s.URL+`/db/foo/retention_policies/5m/backfill?u=lisa&p=password`, `{"start":"2000-01-01T01:00:00Z","end":"2000-01-01T00:00:00Z"}`)
+	if status != http.StatusBadRequest || body != "invalid backfill range" {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+	status, _ = MustHTTP("GET", s.URL+`/backfills?u=bob&p=password`, "")
+	if status != http.StatusForbidden {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
 func TestHandler_Ping(t *testing.T) {
 	srvr := OpenServer(NewMessagingClient())
 	s := NewHTTPServer(srvr)
@@ -366,8 +2499,65 @@ func TestHandler_Ping(t *testing.T) {
 
 	status, _ := MustHTTP("GET", s.URL+`/ping`, "")
 
-	if status != http.StatusOK {
-		t.Fatalf("unexpected status: %d", status)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+func TestHandler_RequestID(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Generate an id if the client does not provide one.
+	resp, err := http.Get(s.URL + `/ping`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if id := resp.Header.Get("X-Request-Id"); len(id) != 32 {
+		t.Fatalf("unexpected request id: %q", id)
+	}
+
+	// Replace ids that are not safe to log.
+	req, _ := http.NewRequest("GET", s.URL+`/ping`, nil)
+	req.Header.Set("X-Request-Id", "bad id!")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if id := resp.Header.Get("X-Request-Id"); len(id) != 32 {
+		t.Fatalf("unexpected request id: %q", id)
+	}
+}
+
+func TestHandler_RequestID_Propagated(t *testing.T) {
+	var buf LockedBuffer
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.Logger = log.New(&buf, "", 0)
+	s := NewHTTPServer(srvr)
+	s.Handler.Logger = log.New(&buf, "", 0)
+	defer s.Close()
+
+	req, _ := http.NewRequest("GET", s.URL+`/db/foo/series?q=SELECT+sum(value)+FROM+cpu`, nil)
+	req.Header.Set("X-Request-Id", "abc-123")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// Verify the id is returned to the client and written to the logs.
+	if id := resp.Header.Get("X-Request-Id"); id != "abc-123" {
+		t.Fatalf("unexpected request id: %q", id)
+	} else if s := buf.String(); !strings.Contains(s, `query id=abc-123 db="foo" series=0 duration=`) {
+		t.Fatalf("query log not found: %s", s)
+	} else if !strings.Contains(s, `err="field not found: cpu.value"`) {
+		t.Fatalf("query error not logged: %s", s)
+	} else if !strings.Contains(s, `request id=abc-123 method=GET path="/db/foo/series" status=200 duration=`) {
+		t.Fatalf("request log not found: %s", s)
 	}
 }
 
@@ -477,6 +2667,34 @@ func TestHandler_UpdateUser(t *testing.T) {
 	}
 }
 
+// Ensure only admins can change a user's grants.
+func TestHandler_UpdateUser_Grants(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateUser("lisa", "password", true)
+	srvr.CreateUser("bob", "password", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("PUT", s.URL+`/users/bob?u=bob&p=password`, `{"grants":["read:foo"]}`)
+	if status != http.StatusForbidden || body != `admin required` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+	status, body = MustHTTP("PUT", s.URL+`/users/bob?u=lisa&p=password`, `{"grants":["read"]}`)
+	if status != http.StatusBadRequest || body != `invalid token scope` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+	status, body = MustHTTP("PUT", s.URL+`/users/bob?u=lisa&p=password`, `{"grants":["read:foo:cpu*"]}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	status, body = MustHTTP("GET", s.URL+`/users?u=lisa&p=password`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"name":"bob","grants":["read:foo:cpu*"]},{"name":"lisa","admin":true}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
 func TestHandler_UpdateUser_PasswordBadRequest(t *testing.T) {
 	srvr := OpenServer(NewMessagingClient())
 	srvr.CreateUser("jdoe", "1337", false)
@@ -519,6 +2737,21 @@ func TestHandler_DeleteUser_UserNotFound(t *testing.T) {
 	}
 }
 
+func TestHandler_MetaNodes(t *testing.T) {
+	c := NewMessagingClient()
+	c.Brokers = []*url.URL{MustParseURL("http://localhost:8086"), MustParseURL("http://localhost:9086")}
+	srvr := OpenServer(c)
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/meta_nodes`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"url":"http://localhost:8086"},{"url":"http://localhost:9086"}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
 func TestHandler_DataNodes(t *testing.T) {
 	srvr := OpenServer(NewMessagingClient())
 	srvr.CreateDataNode(MustParseURL("http://localhost:1000"))
@@ -530,7 +2763,31 @@ func TestHandler_DataNodes(t *testing.T) {
 	status, body := MustHTTP("GET", s.URL+`/data_nodes`, "")
 	if status != http.StatusOK {
 		t.Fatalf("unexpected status: %d", status)
-	} else if body != `[{"id":1,"url":"http://localhost:1000"},{"id":2,"url":"http://localhost:2000"},{"id":3,"url":"http://localhost:3000"}]` {
+	} else if body != `[{"id":1,"url":"http://localhost:1000","role":"data"},{"id":2,"url":"http://localhost:2000","role":"data"},{"id":3,"url":"http://localhost:3000","role":"data"}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_DataNodes_Role(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDataNode(MustParseURL("http://localhost:1000"))
+	srvr.CreateDataNode(MustParseURL("http://localhost:2000"))
+	srvr.SetDataNodeRole(2, influxdb.DataNodeRoleQuery)
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Only nodes that accept writes are returned for the data role.
+	status, body := MustHTTP("GET", s.URL+`/data_nodes?role=data`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"id":1,"url":"http://localhost:1000","role":"data"}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("GET", s.URL+`/data_nodes?role=query`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"id":2,"url":"http://localhost:2000","role":"query"}]` {
 		t.Fatalf("unexpected body: %s", body)
 	}
 }
@@ -543,7 +2800,89 @@ func TestHandler_CreateDataNode(t *testing.T) {
 	status, body := MustHTTP("POST", s.URL+`/data_nodes`, `{"url":"http://localhost:1000"}`)
 	if status != http.StatusCreated {
 		t.Fatalf("unexpected status: %d", status)
-	} else if body != `{"id":1,"url":"http://localhost:1000"}` {
+	} else if body != `{"id":1,"url":"http://localhost:1000","role":"data"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_CreateDataNode_QueryRole(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/data_nodes`, `{"url":"http://localhost:1000","role":"query"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"id":1,"url":"http://localhost:1000","role":"query"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Unknown roles are rejected without creating the node.
+	status, body = MustHTTP("POST", s.URL+`/data_nodes`, `{"url":"http://localhost:2000","role":"ingest"}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `invalid data node role` {
+		t.Fatalf("unexpected body: %s", body)
+	} else if srvr.DataNodeByURL(MustParseURL("http://localhost:2000")) != nil {
+		t.Fatal("unexpected data node")
+	}
+}
+
+func TestHandler_UpdateDataNode(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDataNode(MustParseURL("http://localhost:1000"))
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("PUT", s.URL+`/data_nodes/1`, `{"role":"query"}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d, %s", status, body)
+	} else if n := srvr.DataNode(1); n.Role != influxdb.DataNodeRoleQuery {
+		t.Fatalf("unexpected role: %s", n.Role)
+	}
+
+	status, body = MustHTTP("PUT", s.URL+`/data_nodes/1`, `{"role":"ingest"}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `invalid data node role` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("PUT", s.URL+`/data_nodes/10000`, `{"role":"data"}`)
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `data node not found` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+// Ensure a node with the query role rejects writes but still serves queries.
+func TestHandler_QueryOnlyNode(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.Initialize(MustParseURL("http://localhost:1000"))
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/db/foo/series`, `[{"name":"cpu","columns":["value"],"points":[[100]]}]`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	srvr.SetDataNodeRole(1, influxdb.DataNodeRoleQuery)
+	status, body = MustHTTP("POST", s.URL+`/db/foo/series`, `[{"name":"cpu","columns":["value"],"points":[[200]]}]`)
+	if status != http.StatusForbidden {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `node is query-only` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("GET", s.URL+`/db/foo/series?q=SELECT+value+FROM+cpu`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if !strings.Contains(body, `100`) || strings.Contains(body, `200`) {
 		t.Fatalf("unexpected body: %s", body)
 	}
 }
@@ -668,8 +3007,269 @@ func TestHandler_AuthenticatedDatabases_AuthorizedBasicAuth(t *testing.T) {
 	}
 }
 
+// Ensure the handler authenticates with an external authenticator when set.
+func TestHandler_AuthenticatedDatabases_ExternalAuthenticator(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	a := influxdb.NewExternalAuthenticator(&Verifier{Groups: map[string][]string{"lisa": {"ops"}}})
+	a.Groups["ops"] = influxql.AllPrivileges
+	s.Handler.Authenticator = a
+
+	status, _ := MustHTTP("GET", s.URL+`/db?u=lisa&p=password`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	status, _ = MustHTTP("GET", s.URL+`/db?u=lisa&p=wrong`, "")
+	if status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	status, _ = MustHTTP("GET", s.URL+`/db?u=bob&p=password`, "")
+	if status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+// Ensure the handler can issue, use and revoke API tokens.
+func TestHandler_Tokens(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	srvr.CreateUser("lisa", "password", true)
+	srvr.CreateUser("bob", "password", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	// Issue a read-only token.
+	status, body := MustHTTP("POST", s.URL+`/users/bob/tokens?u=bob&p=password`, `{"scopes":["read:foo"]}`)
+	if status != http.StatusCreated {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	var tok struct{ ID, Token string }
+	if err := json.Unmarshal([]byte(body), &tok); err != nil {
+		t.Fatal(err)
+	}
+	auth := map[string]string{"Authorization": "Token " + tok.Token}
+
+	// Tokens are listed without the token itself.
+	status, body = MustHTTP("GET", s.URL+`/users/bob/tokens?u=lisa&p=password`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if strings.Contains(body, tok.Token) || !strings.Contains(body, `"id":"`+tok.ID+`"`) {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// The token can query but not write.
+	status, body = MustHTTPWithHeaders("GET", s.URL+`/db/foo/series?q=SHOW+STATS`, auth, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	status, body = MustHTTPWithHeaders("POST", s.URL+`/db/foo/series`, auth, `[{"name":"cpu","columns":["value"],"points":[[100]]}]`)
+	if status != http.StatusForbidden || body != "write access denied" {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+
+	// Tokens cannot be managed with a token or by other non-admin users.
+	status, _ = MustHTTPWithHeaders("POST", s.URL+`/users/bob/tokens`, auth, `{"scopes":["write:foo"]}`)
+	if status != http.StatusForbidden {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	status, _ = MustHTTP("POST", s.URL+`/users/lisa/tokens?u=bob&p=password`, `{"scopes":["write:foo"]}`)
+	if status != http.StatusForbidden {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	status, _ = MustHTTP("POST", s.URL+`/users/bob/tokens?u=bob&p=password`, `{"scopes":["admin:foo"]}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	// Tokens limited to measurements can only write points to those measurements.
+	status, body = MustHTTP("POST", s.URL+`/users/bob/tokens?u=bob&p=password`, `{"scopes":["write:foo:cpu*"]}`)
+	if status != http.StatusCreated {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	var wtok struct{ Token string }
+	if err := json.Unmarshal([]byte(body), &wtok); err != nil {
+		t.Fatal(err)
+	}
+	wauth := map[string]string{"Authorization": "Token " + wtok.Token}
+	status, body = MustHTTPWithHeaders("POST", s.URL+`/db/foo/series`, wauth, `[{"name":"cpu","columns":["value"],"points":[[100]]}]`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+	status, body = MustHTTPWithHeaders("POST", s.URL+`/db/foo/series`, wauth, `[{"name":"cpu","columns":["value"],"points":[[100]]},{"name":"billing","columns":["value"],"points":[[100]]}]`)
+	if status != http.StatusForbidden || body != "write access denied" {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+
+	// Revoke the token.
+	status, _ = MustHTTP("DELETE", s.URL+`/users/bob/tokens/`+tok.ID+`?u=bob&p=password`, "")
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	status, _ = MustHTTPWithHeaders("GET", s.URL+`/db/foo/series?q=SHOW+STATS`, auth, "")
+	if status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	status, _ = MustHTTP("DELETE", s.URL+`/users/bob/tokens/`+tok.ID+`?u=bob&p=password`, "")
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+// Ensure users can log in with a session cookie instead of sending credentials.
+func TestHandler_Sessions(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateUser("lisa", "password", true)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	// Invalid credentials are rejected.
+	status, _ := MustHTTP("POST", s.URL+`/login`, `{"username":"lisa","password":"wrong"}`)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	// Log in and read the session cookie.
+	cookie, csrf := mustLogin(t, s.URL, "lisa", "password")
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode || cookie.Secure {
+		t.Fatalf("unexpected cookie: %s", cookie)
+	}
+	session := map[string]string{"Cookie": cookie.Name + "=" + cookie.Value}
+	withCSRF := map[string]string{"Cookie": cookie.Name + "=" + cookie.Value, "X-CSRF-Token": csrf}
+
+	// The session can read without a CSRF token but must send it to change state.
+	if status, body := MustHTTPWithHeaders("GET", s.URL+`/db`, session, ""); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	if status, body := MustHTTPWithHeaders("POST", s.URL+`/db`, session, `{"name":"foo"}`); status != http.StatusForbidden || body != "invalid csrf token" {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+	if status, body := MustHTTPWithHeaders("POST", s.URL+`/db`, withCSRF, `{"name":"foo"}`); status != http.StatusCreated {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	// The session's user and CSRF token can be retrieved again.
+	if status, body := MustHTTPWithHeaders("GET", s.URL+`/session`, session, ""); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"username":"lisa","admin":true,"csrfToken":"`+csrf+`"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Logging out ends the session.
+	if status, _ := MustHTTPWithHeaders("POST", s.URL+`/logout`, session, ""); status != http.StatusForbidden {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	if status, _ := MustHTTPWithHeaders("POST", s.URL+`/logout`, withCSRF, ""); status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	if status, body := MustHTTPWithHeaders("GET", s.URL+`/db`, session, ""); status != http.StatusUnauthorized || body != "invalid session" {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+
+	// Sessions end when the user's password changes.
+	cookie, _ = mustLogin(t, s.URL, "lisa", "password")
+	session = map[string]string{"Cookie": cookie.Name + "=" + cookie.Value}
+	if err := srvr.UpdateUser("lisa", "changed"); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := MustHTTPWithHeaders("GET", s.URL+`/db`, session, ""); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	// Sessions end once they have been idle for too long.
+	s.Handler.SessionIdleTimeout = time.Millisecond
+	cookie, _ = mustLogin(t, s.URL, "lisa", "changed")
+	session = map[string]string{"Cookie": cookie.Name + "=" + cookie.Value}
+	time.Sleep(10 * time.Millisecond)
+	if status, _ := MustHTTPWithHeaders("GET", s.URL+`/db`, session, ""); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+// mustLogin logs in to a handler and returns the session cookie and CSRF token.
+func mustLogin(t *testing.T, url, username, password string) (*http.Cookie, string) {
+	resp, err := http.Post(url+`/login`, "application/json", strings.NewReader(`{"username":"`+username+`","password":"`+password+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+
+	var body struct {
+		CSRFToken string `json:"csrfToken"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range resp.Cookies() {
+		if c.Name == "influxdb_session" {
+			return c, body.CSRFToken
+		}
+	}
+	t.Fatal("session cookie not set")
+	return nil, ""
+}
+
+// Ensure admin endpoints are restricted to allowed networks while queries and writes are not.
+func TestHandler_AdminAccess(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	s.Handler.AdminAccess, _ = influxdb.ParseAccessList(nil, []string{"127.0.0.0/8", "::1"})
+
+	for _, path := range []string{"/data_nodes", "/users", "/db/foo/shards"} {
+		status, body := MustHTTP("GET", s.URL+path, "")
+		if status != http.StatusForbidden || body != "address not allowed" {
+			t.Fatalf("%s: unexpected response: %d: %s", path, status, body)
+		}
+	}
+
+	status, _ := MustHTTP("GET", s.URL+`/db/foo/series?q=SHOW+STATS`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	status, _ = MustHTTP("POST", s.URL+`/db/foo/series`, `[{"name":"cpu","columns":["value"],"points":[[100]]}]`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	// Allowing the network restores access.
+	s.Handler.AdminAccess, _ = influxdb.ParseAccessList([]string{"127.0.0.0/8", "::1"}, nil)
+	status, _ = MustHTTP("GET", s.URL+`/data_nodes`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
 // Utility functions for this test suite.
 
+// LockedBuffer is a bytes.Buffer that is safe for concurrent use.
+type LockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *LockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *LockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
 func MustHTTP(verb, url, body string) (int, string) {
 	return MustHTTPWithHeaders(verb, url, nil, body)
 }
@@ -680,10 +3280,10 @@ func MustHTTPWithHeaders(verb, url string, headers map[string]string, body strin
 		panic(err)
 	}
 
+	req.Header.Set("Content-Type", "application/json")
 	for k, v := range headers {
 		req.Header.Set(k, v)
 	}
-	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
@@ -696,6 +3296,100 @@ func MustHTTPWithHeaders(verb, url string, headers map[string]string, body strin
 	return resp.StatusCode, strings.TrimRight(string(b), "\n")
 }
 
+// appendUvarint appends a protobuf varint to b.
+func appendUvarint(b []byte, x uint64) []byte {
+	for x >= 0x80 {
+		b = append(b, byte(x)|0x80)
+		x >>= 7
+	}
+	return append(b, byte(x))
+}
+
+// appendProtoVarint appends a protobuf varint field to b.
+func appendProtoVarint(b []byte, field, v uint64) []byte {
+	return appendUvarint(appendUvarint(b, field<<3), v)
+}
+
+// appendProtoBytes appends a length-delimited protobuf field to b.
+func appendProtoBytes(b []byte, field uint64, v []byte) []byte {
+	return append(appendUvarint(appendUvarint(b, field<<3|2), uint64(len(v))), v...)
+}
+
+// appendProtoDouble appends a protobuf double field to b.
+func appendProtoDouble(b []byte, field uint64, v float64) []byte {
+	b = appendUvarint(b, field<<3|1)
+	bits := math.Float64bits(v)
+	for i := uint(0); i < 8; i++ {
+		b = append(b, byte(bits>>(8*i)))
+	}
+	return b
+}
+
+// appendPromTimeSeries appends a Prometheus TimeSeries message to b with
+// label name and value pairs and samples one second apart from t0.
+func appendPromTimeSeries(b []byte, labels []string, t0 uint64, samples []float64) []byte {
+	for i := 0; i < len(labels); i += 2 {
+		b = appendProtoBytes(b, 1, appendProtoBytes(appendProtoBytes(nil, 1, []byte(labels[i])), 2, []byte(labels[i+1])))
+	}
+	for i, v := range samples {
+		b = appendProtoBytes(b, 2, appendProtoVarint(appendProtoDouble(nil, 1, v), 2, t0+uint64(i)*1000))
+	}
+	return b
+}
+
+// appendPromMatcher returns a Prometheus LabelMatcher message.
+func appendPromMatcher(typ uint64, name, value string) []byte {
+	return appendProtoBytes(appendProtoBytes(appendProtoVarint(nil, 1, typ), 2, []byte(name)), 3, []byte(value))
+}
+
+// snappyLiteral returns data as a snappy block with a single literal.
+func snappyLiteral(data []byte) string {
+	b := appendUvarint(nil, uint64(len(data)))
+	if len(data) > 0 {
+		n := len(data) - 1
+		b = append(b, 63<<2, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return string(append(b, data...))
+}
+
+// mustSnappyDecode decodes a snappy block. Panic on error.
+func mustSnappyDecode(b []byte) []byte {
+	n, i := binary.Uvarint(b)
+	b = b[i:]
+
+	dst := make([]byte, 0, n)
+	for len(b) > 0 {
+		var length, offset int
+		switch tag := b[0]; tag & 0x3 {
+		case 0:
+			length, b = int(tag>>2)+1, b[1:]
+			if length > 60 {
+				size := length - 60
+				length = 1
+				for j := 0; j < size; j++ {
+					length += int(b[j]) << (8 * uint(j))
+				}
+				b = b[size:]
+			}
+			dst, b = append(dst, b[:length]...), b[length:]
+			continue
+		case 1:
+			length, offset, b = 4+int(tag>>2)&0x7, int(tag&0xe0)<<3|int(b[1]), b[2:]
+		case 2:
+			length, offset, b = int(tag>>2)+1, int(binary.LittleEndian.Uint16(b[1:])), b[3:]
+		case 3:
+			length, offset, b = int(tag>>2)+1, int(binary.LittleEndian.Uint32(b[1:])), b[5:]
+		}
+		for j := len(dst) - offset; length > 0; j, length = j+1, length-1 {
+			dst = append(dst, dst[j])
+		}
+	}
+	if len(dst) != int(n) {
+		panic("snappy: length mismatch")
+	}
+	return dst
+}
+
 // MustParseURL parses a string into a URL. Panic on error.
 func MustParseURL(s string) *url.URL {
 	u, err := url.Parse(s)
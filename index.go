@@ -0,0 +1,532 @@
+package influxdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// MemoryIndex stores a database's series in the metastore. The index
+	// of every series is loaded into memory when the server starts.
+	MemoryIndex = "memory"
+
+	// DiskIndex stores a database's series in a log of its own. The log is
+	// only read once the database is used and its index is released from
+	// memory again once the database is idle.
+	DiskIndex = "disk"
+)
+
+// DefaultIndexIdleTimeout is the default time that the index of a database
+// stored on disk stays in memory without being used.
+const DefaultIndexIdleTimeout = 10 * time.Minute
+
+// seriesIndex represents the in-memory index of a database's measurements
+// and series.
+type seriesIndex struct {
+	measurements map[string]*Measurement // measurement name to object and index
+	series       map[uint32]*Series      // map series id to the Series object
+	names        []string                // sorted list of the measurement names
+}
+
+// newSeriesIndex returns an empty index.
+func newSeriesIndex() *seriesIndex {
+	return &seriesIndex{
+		measurements: make(map[string]*Measurement),
+		series:       make(map[uint32]*Series),
+		names:        make([]string, 0),
+	}
+}
+
+// addSeries adds the series for the given measurement to the index. Returns false if already present
+func (idx *seriesIndex) addSeries(measurementName string, s *Series) bool {
+	// if there is a measurement for this id, it's already been added
+	if idx.series[s.ID] != nil {
+		return false
+	}
+
+	// get or create the measurement index and index it globally and in the measurement
+	m := idx.createMeasurementIfNotExists(measurementName)
+
+	s.measurement = m
+	idx.series[s.ID] = s
+
+	// TODO: add this series to the global tag index
+
+	return m.addSeries(s)
+}
+
+// createMeasurementIfNotExists will either add a measurement object to the index or return the existing one.
+func (idx *seriesIndex) createMeasurementIfNotExists(name string) *Measurement {
+	m := idx.measurements[name]
+	if m == nil {
+		m = NewMeasurement(name)
+		idx.measurements[name] = m
+		idx.names = append(idx.names, name)
+		sort.Strings(idx.names)
+	}
+	return m
+}
+
+// index returns the database's index. The index of a database stored on
+// disk is read from its log if it isn't in memory. Operations check
+// loadIndex first so that an error reading the log is returned to them. If
+// the log can't be read here an empty index is returned and the log is read
+// again when the index is next used.
+func (db *database) index() *seriesIndex {
+	idx, err := db.loadIndex()
+	if err != nil {
+		return newSeriesIndex()
+	}
+	return idx
+}
+
+// loadIndex returns the database's index, reading it from its log if it
+// isn't in memory. Returns an error if the log can't be read.
+func (db *database) loadIndex() (*seriesIndex, error) {
+	db.indexMu.Lock()
+	defer db.indexMu.Unlock()
+	db.indexUsed = true
+	if db.idx == nil {
+		idx := newSeriesIndex()
+		if err := db.indexLog.load(idx); err != nil {
+			return nil, fmt.Errorf("load index: %s: %s", db.name, err)
+		}
+		db.idx = idx
+	}
+	return db.idx, nil
+}
+
+// loadIndex reads the index of a database into memory, if needed, so that
+// an error reading it is returned before the database is used. Databases
+// that don't exist are ignored.
+func (s *Server) loadIndex(database string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if db := s.databases[database]; db != nil {
+		if _, err := db.loadIndex(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seriesN returns the number of series in the database without reading
+// its index from disk.
+func (db *database) seriesN() int {
+	db.indexMu.Lock()
+	defer db.indexMu.Unlock()
+	if db.idx == nil {
+		return db.indexLog.seriesN
+	}
+	return len(db.idx.series)
+}
+
+// indexLog represents the log that a database's index is stored in when it
+// is indexed on disk. Measurements and series are appended as they change
+// and the log is rewritten with only the latest records once most of the
+// records it holds have been replaced.
+//
+// Each record is its size and checksum followed by the record as JSON.
+type indexLog struct {
+	path    string
+	f       *os.File
+	n       int  // number of records in the log
+	seriesN int  // number of series in the log
+	dirty   bool // records were appended since the log was last synced
+}
+
+// indexRecord represents a record in an index log. It holds either a series
+// of a measurement or the fields of a measurement.
+type indexRecord struct {
+	Measurement string  `json:"measurement"`
+	Series      *Series `json:"series,omitempty"`
+	Fields      Fields  `json:"fields,omitempty"`
+}
+
+// openIndexLog opens the index log at path, creating it if it doesn't exist.
+// The log isn't read until it's loaded. SeriesN is the number of series that
+// it holds.
+func openIndexLog(path string, seriesN int) (*indexLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &indexLog{path: path, f: f, seriesN: seriesN}, nil
+}
+
+// close syncs and closes the log file.
+func (l *indexLog) close() error {
+	if err := l.sync(); err != nil {
+		_ = l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
+
+// rename moves the log file to a new path.
+func (l *indexLog) rename(path string) error {
+	if err := os.Rename(l.path, path); err != nil {
+		return err
+	}
+	l.path = path
+	return nil
+}
+
+// remove closes the log and removes its file.
+func (l *indexLog) remove() error {
+	_ = l.f.Close()
+	return os.Remove(l.path)
+}
+
+// load reads the records of the log into an index. A partial record at the
+// end of the log, left by a write that didn't finish, is truncated.
+func (l *indexLog) load(idx *seriesIndex) error {
+	if _, err := l.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(l.f)
+
+	var n int
+	var offset int64
+	for {
+		rec, size, err := readIndexRecord(r)
+		if err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF || err == errIndexRecordChecksum {
+			if err := l.f.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		} else if err != nil {
+			return err
+		}
+		offset += size
+		n++
+
+		if rec.Series != nil {
+			idx.addSeries(rec.Measurement, rec.Series)
+		} else {
+			idx.createMeasurementIfNotExists(rec.Measurement).Fields = rec.Fields
+		}
+	}
+	l.n, l.seriesN = n, len(idx.series)
+	return nil
+}
+
+// appendMeasurement appends the fields of a measurement to the log.
+func (l *indexLog) appendMeasurement(m *Measurement) error {
+	return l.append([]*indexRecord{{Measurement: m.Name, Fields: m.Fields}})
+}
+
+// appendSeries appends a series of a measurement to the log.
+func (l *indexLog) appendSeries(name string, s *Series) error {
+	if err := l.append([]*indexRecord{{Measurement: name, Series: s}}); err != nil {
+		return err
+	}
+	l.seriesN++
+	return nil
+}
+
+// append writes records to the end of the log. The records aren't synced to
+// disk until sync is called so that the records appended while applying a
+// message are synced together.
+func (l *indexLog) append(records []*indexRecord) error {
+	var buf []byte
+	for _, rec := range records {
+		buf = appendIndexRecord(buf, rec)
+	}
+	if _, err := l.f.Write(buf); err != nil {
+		return err
+	}
+	l.n += len(records)
+	l.dirty = true
+	return nil
+}
+
+// sync syncs the records appended since the last sync to disk.
+func (l *indexLog) sync() error {
+	if !l.dirty {
+		return nil
+	} else if err := l.f.Sync(); err != nil {
+		return err
+	}
+	l.dirty = false
+	return nil
+}
+
+// compactable returns true if more than half of the log's records have been
+// replaced by later ones.
+func (l *indexLog) compactable(idx *seriesIndex) bool {
+	return l.n > 2*(len(idx.measurements)+len(idx.series))
+}
+
+// compact rewrites the log with a record for each measurement and series in
+// the index. The new log replaces the old one once it's synced to disk.
+func (l *indexLog) compact(idx *seriesIndex) error {
+	var buf []byte
+	for _, name := range idx.names {
+		buf = appendIndexRecord(buf, &indexRecord{Measurement: name, Fields: idx.measurements[name].Fields})
+	}
+	for _, name := range idx.names {
+		m := idx.measurements[name]
+		for _, id := range m.ids {
+			buf = appendIndexRecord(buf, &indexRecord{Measurement: name, Series: m.seriesByID[id]})
+		}
+	}
+
+	// Write the new log next to the old one and swap it in.
+	tmp := l.path + ".compact"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		_ = f.Close()
+		return err
+	} else if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	} else if err := os.Rename(tmp, l.path); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	_ = l.f.Close()
+	l.f, l.dirty = f, false
+	l.n, l.seriesN = len(idx.measurements)+len(idx.series), len(idx.series)
+	return nil
+}
+
+// errIndexRecordChecksum is returned when a record in an index log doesn't
+// match its checksum.
+var errIndexRecordChecksum = fmt.Errorf("index record checksum mismatch")
+
+// appendIndexRecord appends an encoded record to buf.
+func appendIndexRecord(buf []byte, rec *indexRecord) []byte {
+	data := mustMarshalJSON(rec)
+	var hdr [8]byte
+	binary.BigEndian.PutUint32(hdr[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(hdr[4:8], crc32.ChecksumIEEE(data))
+	return append(append(buf, hdr[:]...), data...)
+}
+
+// readIndexRecord reads the next record from r. Returns the record and its
+// encoded size. Returns io.EOF at the end of the log and io.ErrUnexpectedEOF
+// for a partial record.
+func readIndexRecord(r io.Reader) (*indexRecord, int64, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, 0, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(hdr[0:4]))
+	if _, err := io.ReadFull(r, data); err == io.EOF {
+		return nil, 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, 0, err
+	} else if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(hdr[4:8]) {
+		return nil, 0, errIndexRecordChecksum
+	}
+
+	var rec indexRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, 0, err
+	}
+	return &rec, int64(len(hdr) + len(data)), nil
+}
+
+// indexPath returns the path of a database's index log.
+func (s *Server) indexPath(name string) string {
+	if s.path == "" {
+		return ""
+	}
+	return filepath.Join(s.path, "indexes", name)
+}
+
+// saveSeries persists a new series for a measurement to the database's
+// index storage and adds it to the index. The series id is assigned by the
+// metastore for both kinds of index.
+func (s *Server) saveSeries(db *database, name string, tags map[string]string) (*Series, error) {
+	series := &Series{Tags: tags}
+	if err := s.meta.mustUpdate(func(tx *metatx) error {
+		id, err := tx.nextSeriesID(db.name)
+		if err != nil {
+			return err
+		}
+		series.ID = id
+		if db.indexLog != nil {
+			return nil
+		}
+		return tx.saveSeries(db.name, name, series)
+	}); err != nil {
+		return nil, err
+	}
+	if db.indexLog != nil {
+		if err := db.indexLog.appendSeries(name, series); err != nil {
+			return nil, err
+		} else if err := db.indexLog.sync(); err != nil {
+			return nil, err
+		}
+	}
+	db.addSeriesToIndex(name, series)
+	return series, nil
+}
+
+// saveMeasurement persists the fields of a measurement to the database's
+// index storage. Fields written to an index log aren't durable until the
+// log is synced with syncIndex.
+func (s *Server) saveMeasurement(db *database, m *Measurement) error {
+	if db.indexLog != nil {
+		return db.indexLog.appendMeasurement(m)
+	}
+	return s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveMeasurement(db.name, m)
+	})
+}
+
+// syncIndex syncs the records appended to a database's index log, if it is
+// indexed on disk. Must be called with the server lock held.
+func (s *Server) syncIndex(db *database) error {
+	if db.indexLog == nil {
+		return nil
+	}
+	return db.indexLog.sync()
+}
+
+// openIndex opens the index log of a database that is indexed on disk. The
+// log is read the first time the index is used.
+func (s *Server) openIndex(tx *metatx, db *database) error {
+	l, err := openIndexLog(s.indexPath(db.name), tx.seriesN(db.name))
+	if err != nil {
+		return fmt.Errorf("open index: %s", err)
+	}
+	db.indexLog, db.idx = l, nil
+	return nil
+}
+
+// setIndexType moves a database's index to another kind of storage,
+// MemoryIndex or DiskIndex. The index is written in full to the new storage
+// before the old one is removed. Must be called with the server lock held.
+func (s *Server) setIndexType(db *database, typ string) error {
+	if (typ == DiskIndex) == (db.indexLog != nil) {
+		return nil
+	}
+	idx, err := db.loadIndex()
+	if err != nil {
+		return err
+	}
+
+	if typ == DiskIndex {
+		// Write the index to a new log and clear it from the metastore.
+		l, err := openIndexLog(s.indexPath(db.name), len(idx.series))
+		if err != nil {
+			return err
+		}
+		if err := l.compact(idx); err != nil {
+			_ = l.remove()
+			return err
+		}
+		if err := s.meta.mustUpdate(func(tx *metatx) error { return tx.clearIndex(db.name) }); err != nil {
+			_ = l.remove()
+			return err
+		}
+		db.indexLog, db.indexType = l, DiskIndex
+		return nil
+	}
+
+	// Write the index to the metastore and remove the log.
+	if err := s.meta.mustUpdate(func(tx *metatx) error {
+		for _, name := range idx.names {
+			m := idx.measurements[name]
+			if err := tx.saveMeasurement(db.name, m); err != nil {
+				return err
+			}
+			for _, series := range m.seriesByID {
+				if err := tx.saveSeries(db.name, name, series); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := db.indexLog.remove(); err != nil {
+		s.Logger.Printf("remove index: %s", err)
+	}
+	db.indexLog, db.indexType = nil, ""
+	return nil
+}
+
+// StartIndexEviction releases the in-memory index of each database indexed
+// on disk once it hasn't been used for the timeout, so that memory is only
+// used by the indexes of active databases. Indexes are read back from disk
+// when they're next used.
+func (s *Server) StartIndexEviction(timeout time.Duration) error {
+	if timeout <= 0 {
+		return ErrInvalidIndexIdleTimeout
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing == nil {
+		return ErrServerClosed
+	}
+
+	s.wg.Add(1)
+	go s.indexEvictor(timeout, s.closing)
+	return nil
+}
+
+// indexEvictor releases idle indexes every timeout until closing is closed.
+// An index is idle if it wasn't used since the previous check.
+func (s *Server) indexEvictor(timeout time.Duration, closing <-chan struct{}) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(timeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closing:
+			return
+		case <-ticker.C:
+			s.evictIndexes()
+		}
+	}
+}
+
+// evictIndexes releases the indexes of databases indexed on disk that
+// weren't used since the last call. Logs are compacted as they're released.
+func (s *Server) evictIndexes() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, db := range s.databases {
+		if db.indexLog == nil {
+			continue
+		}
+
+		db.indexMu.Lock()
+		if db.idx != nil && !db.indexUsed {
+			if db.indexLog.compactable(db.idx) {
+				if err := db.indexLog.compact(db.idx); err != nil {
+					s.Logger.Printf("compact index: %s: %s", db.name, err)
+				}
+			}
+			db.idx = nil
+		}
+		db.indexUsed = false
+		db.indexMu.Unlock()
+	}
+}
@@ -0,0 +1,200 @@
+package influxdb
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Ensure an index log can be reloaded, truncates a partial record at its end
+// and is rewritten with only the latest records when compacted.
+func TestIndexLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influxdb-index-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "indexes", "foo")
+
+	l, err := openIndexLog(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.appendSeries("cpu", &Series{ID: 1, Tags: map[string]string{"host": "a"}}); err != nil {
+		t.Fatal(err)
+	} else if err := l.appendSeries("cpu", &Series{ID: 2, Tags: map[string]string{"host": "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		m := &Measurement{Name: "cpu", Fields: Fields{{ID: 1, Name: "value", Type: influxql.Number}}}
+		if err := l.appendMeasurement(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.close()
+
+	// Append a partial record, as left by a write that didn't finish.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := appendIndexRecord(nil, &indexRecord{Measurement: "mem", Series: &Series{ID: 3}})
+	if _, err := f.Write(buf[:len(buf)-1]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// Reload the log and verify the partial record is dropped.
+	l, err = openIndexLog(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+	idx := newSeriesIndex()
+	if err := l.load(idx); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(idx.names, []string{"cpu"}) {
+		t.Fatalf("unexpected names: %v", idx.names)
+	} else if len(idx.series) != 2 || idx.series[2].Tags["host"] != "b" {
+		t.Fatalf("unexpected series: %v", idx.series)
+	} else if len(idx.measurements["cpu"].Fields) != 1 {
+		t.Fatalf("unexpected fields: %v", idx.measurements["cpu"].Fields)
+	} else if l.n != 7 || l.seriesN != 2 {
+		t.Fatalf("unexpected counts: n=%d, seriesN=%d", l.n, l.seriesN)
+	}
+
+	// Compact the log and verify only the latest records remain.
+	if !l.compactable(idx) {
+		t.Fatal("expected log to be compactable")
+	} else if err := l.compact(idx); err != nil {
+		t.Fatal(err)
+	} else if l.n != 3 || l.compactable(idx) {
+		t.Fatalf("unexpected record count: %d", l.n)
+	}
+	if err := l.appendSeries("mem", &Series{ID: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	other := newSeriesIndex()
+	if err := l.load(other); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(other.names, []string{"cpu", "mem"}) {
+		t.Fatalf("unexpected names after compaction: %v", other.names)
+	} else if len(other.series) != 3 || len(other.measurements["cpu"].Fields) != 1 {
+		t.Fatalf("unexpected index after compaction: %v", other.series)
+	}
+}
+
+// Ensure the index of a database indexed on disk is released once it is idle
+// and read back from its log when it's next used.
+func TestServer_EvictIndexes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influxdb-index-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := openIndexLog(filepath.Join(dir, "foo"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+	if err := l.appendSeries("cpu", &Series{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer()
+	db := newDatabase()
+	db.name, db.indexType, db.indexLog, db.idx = "foo", DiskIndex, l, nil
+	s.databases["foo"] = db
+	if n := db.seriesN(); n != 1 {
+		t.Fatalf("unexpected series count: %d", n)
+	} else if db.idx != nil {
+		t.Fatal("index loaded by series count")
+	}
+
+	// The index is loaded when used and kept until it's idle for a check.
+	if len(db.index().series) != 1 {
+		t.Fatalf("unexpected series: %v", db.idx.series)
+	}
+	s.evictIndexes()
+	if db.idx == nil {
+		t.Fatal("index released while in use")
+	}
+	s.evictIndexes()
+	if db.idx != nil {
+		t.Fatal("idle index not released")
+	} else if len(db.index().series) != 1 {
+		t.Fatal("index not reloaded")
+	}
+}
+
+// Ensure records appended to an index log are synced together.
+func TestIndexLog_Sync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influxdb-index-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := openIndexLog(filepath.Join(dir, "foo"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+	for i := 0; i < 3; i++ {
+		m := &Measurement{Name: "cpu", Fields: Fields{{ID: 1, Name: "value", Type: influxql.Number}}}
+		if err := l.appendMeasurement(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !l.dirty {
+		t.Fatal("expected unsynced records")
+	} else if err := l.sync(); err != nil {
+		t.Fatal(err)
+	} else if l.dirty {
+		t.Fatal("expected records to be synced")
+	}
+}
+
+// Ensure an index log that can't be read returns an error when the index is
+// loaded instead of panicking.
+func TestServer_LoadIndex_Error(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influxdb-index-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Write a record with a valid checksum that isn't valid JSON.
+	path := filepath.Join(dir, "foo")
+	data := []byte("{")
+	hdr := make([]byte, 8)
+	binary.BigEndian.PutUint32(hdr[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(hdr[4:8], crc32.ChecksumIEEE(data))
+	if err := ioutil.WriteFile(path, append(hdr, data...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	l, err := openIndexLog(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+
+	s := NewServer()
+	db := newDatabase()
+	db.name, db.indexType, db.indexLog, db.idx = "foo", DiskIndex, l, nil
+	s.databases["foo"] = db
+	if err := s.loadIndex("foo"); err == nil || !strings.HasPrefix(err.Error(), "load index: foo: ") {
+		t.Fatalf("unexpected error: %v", err)
+	} else if idx := db.index(); len(idx.series) != 0 || db.idx != nil {
+		t.Fatal("expected empty index that isn't kept")
+	}
+}
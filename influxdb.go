@@ -29,6 +29,12 @@ var (
 	// ErrDataNodeRequired is returned when using a blank data node id.
 	ErrDataNodeRequired = errors.New("data node required")
 
+	// ErrInvalidDataNodeRole is returned when setting an unknown data node role.
+	ErrInvalidDataNodeRole = errors.New("invalid data node role")
+
+	// ErrQueryOnlyNode is returned when writing to a node with the query role.
+	ErrQueryOnlyNode = errors.New("node is query-only")
+
 	// ErrDatabaseNameRequired is returned when creating a database without a name.
 	ErrDatabaseNameRequired = errors.New("database name required")
 
@@ -41,6 +47,24 @@ var (
 	// ErrDatabaseRequired is returned when using a blank database name.
 	ErrDatabaseRequired = errors.New("database required")
 
+	// ErrDatabaseReadOnly is returned when writing to a read-only database.
+	ErrDatabaseReadOnly = errors.New("database is read-only")
+
+	// ErrDatabaseDisabled is returned when writing to or querying a disabled database.
+	ErrDatabaseDisabled = errors.New("database is disabled")
+
+	// ErrSeriesQuotaExceeded is returned when a write would create more
+	// series than a database allows.
+	ErrSeriesQuotaExceeded = errors.New("series quota exceeded")
+
+	// ErrDiskQuotaExceeded is returned when writing to a database whose
+	// shards have reached its maximum size on disk.
+	ErrDiskQuotaExceeded = errors.New("disk quota exceeded")
+
+	// ErrRetentionQuotaExceeded is returned when a retention policy keeps
+	// data longer than its database allows.
+	ErrRetentionQuotaExceeded = errors.New("retention quota exceeded")
+
 	// ErrClusterAdminExists is returned when creating a duplicate admin.
 	ErrClusterAdminExists = errors.New("cluster admin exists")
 
@@ -68,24 +92,348 @@ var (
 	// ErrRetentionPolicyNameRequired is returned using a blank shard space name.
 	ErrRetentionPolicyNameRequired = errors.New("retention policy name required")
 
+	// ErrDownsampleIntervalRequired is returned when a downsampling rule
+	// does not have a positive interval.
+	ErrDownsampleIntervalRequired = errors.New("downsample interval required")
+
+	// ErrInvalidDownsampleFunction is returned when a downsampling rule uses
+	// a function other than mean or sum.
+	ErrInvalidDownsampleFunction = errors.New("invalid downsample function")
+
+	// ErrDownsampleDefaultPolicy is returned when the default retention
+	// policy would downsample into itself.
+	ErrDownsampleDefaultPolicy = errors.New("cannot downsample into the default retention policy")
+
+	// ErrDownsampleNotFound is returned when backfilling a retention policy
+	// that does not have a downsampling rule.
+	ErrDownsampleNotFound = errors.New("downsample not found")
+
+	// ErrInvalidBackfillRange is returned when a backfill does not cover any
+	// intervals that have ended.
+	ErrInvalidBackfillRange = errors.New("invalid backfill range")
+
 	// ErrShardNotFound is returned writing to a non-existent shard.
 	ErrShardNotFound = errors.New("shard not found")
 
+	// ErrShardOwnedElsewhere is returned when deleting a shard that is only
+	// owned by other data nodes.
+	ErrShardOwnedElsewhere = errors.New("shard owned by another data node")
+
 	// ErrReadAccessDenied is returned when a user attempts to read
 	// data that he or she does not have permission to read.
 	ErrReadAccessDenied = errors.New("read access denied")
 
+	// ErrWriteAccessDenied is returned when a user attempts to write
+	// data that he or she does not have permission to write.
+	ErrWriteAccessDenied = errors.New("write access denied")
+
 	// ErrReadWritePermissionsRequired is returned when required read/write permissions aren't provided.
 	ErrReadWritePermissionsRequired = errors.New("read/write permissions required")
 
+	// ErrAuditLogDisabled is returned when reading the audit log of a server
+	// that is not recording administrative actions.
+	ErrAuditLogDisabled = errors.New("audit log disabled")
+
+	// ErrUserNotAuthorized is returned when externally verified credentials
+	// belong to a user that is not a member of any mapped group.
+	ErrUserNotAuthorized = errors.New("user not authorized")
+
+	// ErrInvalidToken is returned when authenticating with an unknown API token.
+	ErrInvalidToken = errors.New("invalid token")
+
+	// ErrTokenNotFound is returned when revoking a non-existent API token.
+	ErrTokenNotFound = errors.New("token not found")
+
+	// ErrTokenScopesRequired is returned when creating an API token without scopes.
+	ErrTokenScopesRequired = errors.New("token scopes required")
+
+	// ErrInvalidTokenScope is returned when creating an API token with a scope
+	// that is not in the form "read:db" or "write:db".
+	ErrInvalidTokenScope = errors.New("invalid token scope")
+
+	// ErrInvalidSession is returned when a session cookie is unknown or its
+	// session has expired.
+	ErrInvalidSession = errors.New("invalid session")
+
+	// ErrInvalidCSRFToken is returned when a request made with a session
+	// changes state without the session's CSRF token.
+	ErrInvalidCSRFToken = errors.New("invalid csrf token")
+
+	// ErrPasswordRequired is returned when managing API tokens while
+	// authenticated with an API token.
+	ErrPasswordRequired = errors.New("password authentication required")
+
+	// ErrAddressNotAllowed is returned when a client outside of the allowed
+	// networks requests an administrative endpoint.
+	ErrAddressNotAllowed = errors.New("address not allowed")
+
+	// ErrAdminRequired is returned when a non-admin user executes a statement
+	// that changes database or retention policy metadata.
+	ErrAdminRequired = errors.New("admin required")
+
 	// ErrInvalidQuery is returned when executing an unknown query type.
 	ErrInvalidQuery = errors.New("invalid query")
 
 	// ErrSeriesNotFound is returned when looking up a non-existent series by database, name and tags
 	ErrSeriesNotFound = errors.New("series not found")
 
+	// ErrMeasurementNotFound is returned when deleting from a non-existent measurement.
+	ErrMeasurementNotFound = errors.New("measurement not found")
+
+	// ErrInvalidDeleteCondition is returned when a delete statement's condition
+	// is not an upper bound on time, such as "time < now() - 90d".
+	ErrInvalidDeleteCondition = errors.New("delete condition must be an upper bound on time")
+
+	// ErrDeletionNotFound is returned when a deletion doesn't exist or has
+	// already been purged.
+	ErrDeletionNotFound = errors.New("deletion not found")
+
+	// ErrInvalidSeriesCondition is returned when a list series or tag values
+	// statement's condition is not made up of tag comparisons and bounds on time.
+	ErrInvalidSeriesCondition = errors.New("condition must compare tags to strings or bound time")
+
+	// ErrEstimateCondition is returned when an estimated list series or tag
+	// values statement has a condition.
+	ErrEstimateCondition = errors.New("estimates cannot be filtered by a condition")
+
+	// ErrMeasurementNameRequired is returned when writing a point without a measurement name.
+	ErrMeasurementNameRequired = errors.New("measurement name required")
+
+	// ErrFieldsRequired is returned when writing a point without any field values.
+	ErrFieldsRequired = errors.New("fields required")
+
+	// ErrWriteQueueFull is returned when too many points are waiting to be published.
+	ErrWriteQueueFull = errors.New("write queue full")
+
+	// ErrWriteLogBehind is returned when too many published writes have not been applied.
+	ErrWriteLogBehind = errors.New("write log behind")
+
+	// ErrServerShuttingDown is returned when a query or write is received
+	// after the server has started to close.
+	ErrServerShuttingDown = errors.New("server shutting down")
+
+	// ErrKeyRequired is returned when writing a point with a blank tag or field key.
+	ErrKeyRequired = errors.New("key required")
+
+	// ErrTooManyFields is returned when a point has more fields than allowed.
+	ErrTooManyFields = errors.New("too many fields")
+
+	// ErrTooManyTags is returned when a point has more tags than allowed.
+	ErrTooManyTags = errors.New("too many tags")
+
+	// ErrKeyTooLong is returned when a measurement name, tag key or field key is too long.
+	ErrKeyTooLong = errors.New("key too long")
+
+	// ErrValueTooLong is returned when a tag value or string field value is too long.
+	ErrValueTooLong = errors.New("value too long")
+
+	// ErrInvalidUTF8 is returned when a name, key or value is not valid UTF-8.
+	ErrInvalidUTF8 = errors.New("invalid UTF-8")
+
+	// ErrInvalidFloat is returned when writing a NaN or infinite float value.
+	ErrInvalidFloat = errors.New("invalid float: NaN and infinity are not supported")
+
+	// ErrTimestampInFuture is returned when a point's timestamp is further in
+	// the future than allowed.
+	ErrTimestampInFuture = errors.New("timestamp too far in the future")
+
+	// ErrTimestampExpired is returned when a point's timestamp is older than
+	// its retention policy keeps data and rejecting expired points is enabled.
+	ErrTimestampExpired = errors.New("timestamp before retention policy start")
+
+	// ErrInvalidHistogram is returned when writing a histogram whose bounds aren't
+	// increasing or that doesn't have one more count than bounds.
+	ErrInvalidHistogram = errors.New("invalid histogram")
+
+	// ErrFieldTypeConflict is returned when a field is written with a different data type.
+	ErrFieldTypeConflict = errors.New("field type conflict")
+
+	// ErrFieldOverflow is returned when too many fields are created on a measurement.
+	ErrFieldOverflow = errors.New("field overflow")
+
+	// ErrMeasurementNotDeclared is returned when writing to a measurement that
+	// has no schema in a database that enforces schemas.
+	ErrMeasurementNotDeclared = errors.New("measurement not declared")
+
+	// ErrFieldNotDeclared is returned when writing a field that is not in the
+	// measurement's schema in a database that enforces schemas.
+	ErrFieldNotDeclared = errors.New("field not declared")
+
+	// ErrTagNotDeclared is returned when writing a tag key that is not in the
+	// measurement's schema in a database that enforces schemas.
+	ErrTagNotDeclared = errors.New("tag not declared")
+
+	// ErrInvalidFieldType is returned when a schema declares a field with an
+	// unsupported data type.
+	ErrInvalidFieldType = errors.New("invalid field type")
+
+	// ErrFieldNameRequired is returned when a schema declares a field without a name.
+	ErrFieldNameRequired = errors.New("field name required")
+
+	// ErrMeasurementSchemaNotFound is returned when deleting a measurement
+	// schema that has not been declared.
+	ErrMeasurementSchemaNotFound = errors.New("measurement schema not found")
+
+	// ErrInvalidDuplicatePolicy is returned when a schema sets an unknown duplicate policy.
+	ErrInvalidDuplicatePolicy = errors.New("invalid duplicate policy")
+
+	// ErrTagValueNotAllowed is returned when writing a tag value that is not
+	// in the allowlist of the tag key's guard.
+	ErrTagValueNotAllowed = errors.New("tag value not allowed")
+
+	// ErrTagValueLimitExceeded is returned when writing a tag value would
+	// exceed the maximum number of values allowed by the tag key's guard.
+	ErrTagValueLimitExceeded = errors.New("tag value limit exceeded")
+
+	// ErrTagKeyRequired is returned when creating a tag guard without a tag key.
+	ErrTagKeyRequired = errors.New("tag key required")
+
+	// ErrInvalidTagGuard is returned when a tag guard has neither a value
+	// limit nor an allowlist.
+	ErrInvalidTagGuard = errors.New("tag guard requires max values or allowed values")
+
+	// ErrTagGuardNotFound is returned when deleting a tag guard that doesn't exist.
+	ErrTagGuardNotFound = errors.New("tag guard not found")
+
+	// ErrAlertRuleNameRequired is returned when setting an alert rule without a name.
+	ErrAlertRuleNameRequired = errors.New("alert rule name required")
+
+	// ErrInvalidAlertQuery is returned when an alert rule's query is not a
+	// single select statement.
+	ErrInvalidAlertQuery = errors.New("alert query must be a single select statement")
+
+	// ErrInvalidAlertInterval is returned when an alert rule isn't evaluated
+	// at a positive interval.
+	ErrInvalidAlertInterval = errors.New("invalid alert interval")
+
+	// ErrInvalidAlertType is returned when an alert rule's type is not
+	// "threshold" or "deadman".
+	ErrInvalidAlertType = errors.New("alert type must be threshold or deadman")
+
+	// ErrInvalidAlertOperator is returned when a threshold rule has an
+	// unknown comparison operator.
+	ErrInvalidAlertOperator = errors.New("invalid alert operator")
+
+	// ErrAlertNotifyRequired is returned when an alert rule has nowhere to
+	// send notifications.
+	ErrAlertNotifyRequired = errors.New("alert notification target required")
+
+	// ErrInvalidAlertNotify is returned when an alert rule's notification
+	// target is not a webhook URL, mailto: address or pagerduty: routing key.
+	ErrInvalidAlertNotify = errors.New("invalid alert notification target")
+
+	// ErrAlertRuleNotFound is returned when deleting an alert rule that doesn't exist.
+	ErrAlertRuleNotFound = errors.New("alert rule not found")
+
+	// ErrRoutingRuleNameRequired is returned when setting a routing rule without a name.
+	ErrRoutingRuleNameRequired = errors.New("routing rule name required")
+
+	// ErrInvalidRoutingRule is returned when a routing rule doesn't match on a
+	// measurement or tag, has an invalid pattern or has no destination.
+	ErrInvalidRoutingRule = errors.New("invalid routing rule")
+
+	// ErrRoutingRuleNotFound is returned when deleting a routing rule that doesn't exist.
+	ErrRoutingRuleNotFound = errors.New("routing rule not found")
+
+	// ErrInvalidAlertCheckInterval is returned when the alert service is
+	// opened without a positive check interval.
+	ErrInvalidAlertCheckInterval = errors.New("invalid alert check interval")
+
+	// ErrInvalidMonitorInterval is returned when self-monitoring is started
+	// without a positive interval.
+	ErrInvalidMonitorInterval = errors.New("invalid monitor interval")
+
+	// ErrInvalidDownsampleInterval is returned when downsampling is started
+	// without a positive interval.
+	ErrInvalidDownsampleInterval = errors.New("invalid downsample interval")
+
+	// ErrInvalidCompactionInterval is returned when compaction is started
+	// without a positive check interval.
+	ErrInvalidCompactionInterval = errors.New("invalid compaction interval")
+
+	// ErrInvalidIndexType is returned when a database's index is set to a
+	// kind of storage other than "memory" or "disk".
+	ErrInvalidIndexType = errors.New("invalid index type")
+
+	// ErrInvalidIndexIdleTimeout is returned when index eviction is started
+	// without a positive idle timeout.
+	ErrInvalidIndexIdleTimeout = errors.New("invalid index idle timeout")
+
+	// ErrJobQueueFull is returned when a background job can't be queued
+	// because too many jobs are already waiting to run.
+	ErrJobQueueFull = errors.New("job queue full")
+
+	// ErrInvalidScrubInterval is returned when scrubbing is started without
+	// a positive interval.
+	ErrInvalidScrubInterval = errors.New("invalid scrub interval")
+
+	// ErrChecksumMismatch is returned when stored values do not match their checksum.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
+	// ErrBlockNotFound is returned when a shard has no values for a series at a timestamp.
+	ErrBlockNotFound = errors.New("block not found")
+
+	// ErrRateLimitExceeded is returned when a user makes requests faster than their limits allow.
+	ErrRateLimitExceeded = errors.New("rate limit exceeded")
+
+	// ErrQueryMemoryExceeded is returned when a statement allocates more memory than its limit allows.
+	ErrQueryMemoryExceeded = errors.New("query memory limit exceeded")
+
+	// ErrQueryTimeout is returned when a statement is still running once the
+	// request's deadline has passed.
+	ErrQueryTimeout = errors.New("query timeout")
+
+	// ErrQueryCanceled is returned when a statement is stopped because the
+	// client canceled the request.
+	ErrQueryCanceled = errors.New("query canceled")
+
+	// ErrTooManyCursors is returned when a paged statement has more values
+	// but the maximum number of cursors are already open.
+	ErrTooManyCursors = errors.New("too many open cursors")
+
+	// ErrCursorNotFound is returned when reading a page from a cursor that
+	// doesn't exist, has been read to the end or has expired.
+	ErrCursorNotFound = errors.New("cursor not found")
+
+	// ErrInvalidSnapshot is returned when querying with a malformed snapshot token.
+	ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+	// ErrSnapshotNotApplied is returned when querying with a snapshot that
+	// the server hasn't reached yet, such as one returned by another node.
+	ErrSnapshotNotApplied = errors.New("snapshot not yet applied")
+
 	// ErrSeriesExists is returned when attempting to set the id of a series by database, name and tags that already exists
 	ErrSeriesExists = errors.New("series already exists")
+
+	// ErrKafkaTopicRequired is returned when opening a Kafka input without topics.
+	ErrKafkaTopicRequired = errors.New("kafka topic required")
+
+	// ErrInvalidKafkaFormat is returned when a Kafka topic has a message
+	// format other than "line" or "json".
+	ErrInvalidKafkaFormat = errors.New("invalid kafka message format")
+
+	// ErrAnnotationTitleRequired is returned when writing an annotation without a title.
+	ErrAnnotationTitleRequired = errors.New("annotation title required")
+
+	// ErrInvalidAnnotationTag is returned when writing an annotation with a
+	// blank tag or a tag containing a comma.
+	ErrInvalidAnnotationTag = errors.New("invalid annotation tag")
+
+	// ErrInvalidRetentionDuration is returned when a database template has a
+	// retention policy duration that can't be parsed.
+	ErrInvalidRetentionDuration = errors.New("invalid retention policy duration")
+
+	// ErrMultipleDefaultRetentionPolicies is returned when a database template
+	// marks more than one retention policy as the default.
+	ErrMultipleDefaultRetentionPolicies = errors.New("multiple default retention policies")
+
+	// ErrContinuousQueriesNotSupported is returned when a database template
+	// includes continuous queries, which the server can't store yet.
+	ErrContinuousQueriesNotSupported = errors.New("continuous queries not supported")
+
+	// ErrUserPasswordRequired is returned when applying metadata that
+	// describes a new user without a password.
+	ErrUserPasswordRequired = errors.New("password required to create user")
 )
 
 // mustMarshal encodes a value to JSON.
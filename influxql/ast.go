@@ -3,6 +3,7 @@ package influxql
 import (
 	"bytes"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -12,12 +13,15 @@ import (
 type DataType string
 
 const (
-	Unknown  = DataType("")
-	Number   = DataType("number")
-	Boolean  = DataType("boolean")
-	String   = DataType("string")
-	Time     = DataType("time")
-	Duration = DataType("duration")
+	Unknown   = DataType("")
+	Number    = DataType("number")
+	Integer   = DataType("integer")
+	Unsigned  = DataType("unsigned")
+	Boolean   = DataType("boolean")
+	String    = DataType("string")
+	Time      = DataType("time")
+	Duration  = DataType("duration")
+	Histogram = DataType("histogram")
 )
 
 // InspectDataType returns the data type of a given value.
@@ -25,6 +29,10 @@ func InspectDataType(v interface{}) DataType {
 	switch v.(type) {
 	case float64:
 		return Number
+	case int64:
+		return Integer
+	case uint64:
+		return Unsigned
 	case bool:
 		return Boolean
 	case string:
@@ -33,6 +41,8 @@ func InspectDataType(v interface{}) DataType {
 		return Time
 	case time.Duration:
 		return Duration
+	case *HistogramValue:
+		return Histogram
 	default:
 		return Unknown
 	}
@@ -47,6 +57,7 @@ type Node interface {
 func (_ *Query) node()     {}
 func (_ Statements) node() {}
 
+func (_ *AlterDatabaseStatement) node()         {}
 func (_ *AlterRetentionPolicyStatement) node()  {}
 func (_ *CreateContinuousQueryStatement) node() {}
 func (_ *CreateDatabaseStatement) node()        {}
@@ -57,6 +68,7 @@ func (_ *DropContinuousQueryStatement) node()   {}
 func (_ *DropDatabaseStatement) node()          {}
 func (_ *DropSeriesStatement) node()            {}
 func (_ *DropUserStatement) node()              {}
+func (_ *ExplainStatement) node()               {}
 func (_ *GrantStatement) node()                 {}
 func (_ *ListContinuousQueriesStatement) node() {}
 func (_ *ListDatabasesStatement) node()         {}
@@ -68,6 +80,10 @@ func (_ *ListTagKeysStatement) node()           {}
 func (_ *ListTagValuesStatement) node()         {}
 func (_ *RevokeStatement) node()                {}
 func (_ *SelectStatement) node()                {}
+func (_ *ShowQueriesStatement) node()           {}
+func (_ *ShowDeletionsStatement) node()         {}
+func (_ *ShowAuditStatement) node()             {}
+func (_ *ShowStatsStatement) node()             {}
 
 func (_ *BinaryExpr) node()      {}
 func (_ *BooleanLiteral) node()  {}
@@ -82,7 +98,9 @@ func (_ *Measurement) node()     {}
 func (_ Measurements) node()     {}
 func (_ *Merge) node()           {}
 func (_ *NumberLiteral) node()   {}
+func (_ *CastExpr) node()        {}
 func (_ *ParenExpr) node()       {}
+func (_ *RegexLiteral) node()    {}
 func (_ *SortField) node()       {}
 func (_ SortFields) node()       {}
 func (_ *StringLiteral) node()   {}
@@ -117,6 +135,7 @@ type Statement interface {
 	stmt()
 }
 
+func (_ *AlterDatabaseStatement) stmt()         {}
 func (_ *AlterRetentionPolicyStatement) stmt()  {}
 func (_ *CreateContinuousQueryStatement) stmt() {}
 func (_ *CreateDatabaseStatement) stmt()        {}
@@ -127,6 +146,7 @@ func (_ *DropContinuousQueryStatement) stmt()   {}
 func (_ *DropDatabaseStatement) stmt()          {}
 func (_ *DropSeriesStatement) stmt()            {}
 func (_ *DropUserStatement) stmt()              {}
+func (_ *ExplainStatement) stmt()               {}
 func (_ *GrantStatement) stmt()                 {}
 func (_ *ListContinuousQueriesStatement) stmt() {}
 func (_ *ListDatabasesStatement) stmt()         {}
@@ -138,6 +158,10 @@ func (_ *ListTagKeysStatement) stmt()           {}
 func (_ *ListTagValuesStatement) stmt()         {}
 func (_ *RevokeStatement) stmt()                {}
 func (_ *SelectStatement) stmt()                {}
+func (_ *ShowQueriesStatement) stmt()           {}
+func (_ *ShowDeletionsStatement) stmt()         {}
+func (_ *ShowAuditStatement) stmt()             {}
+func (_ *ShowStatsStatement) stmt()             {}
 
 // Expr represents an expression that can be evaluated to a value.
 type Expr interface {
@@ -150,7 +174,9 @@ func (_ *BooleanLiteral) expr()  {}
 func (_ *Call) expr()            {}
 func (_ *DurationLiteral) expr() {}
 func (_ *NumberLiteral) expr()   {}
+func (_ *CastExpr) expr()        {}
 func (_ *ParenExpr) expr()       {}
+func (_ *RegexLiteral) expr()    {}
 func (_ *StringLiteral) expr()   {}
 func (_ *TimeLiteral) expr()     {}
 func (_ *VarRef) expr()          {}
@@ -210,6 +236,52 @@ func (s *CreateDatabaseStatement) String() string {
 	return buf.String()
 }
 
+// AlterDatabaseStatement represents a command to rename a database or to
+// change whether it accepts writes and queries.
+type AlterDatabaseStatement struct {
+	// Name of the database to be altered.
+	Name string
+
+	// New name of the database, if it is being renamed.
+	NewName string
+
+	// Should the database reject writes?
+	ReadOnly *bool
+
+	// Should the database reject writes and queries?
+	Disabled *bool
+}
+
+// String returns a string representation of the alter database statement.
+func (s *AlterDatabaseStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("ALTER DATABASE ")
+	_, _ = buf.WriteString(s.Name)
+
+	if s.NewName != "" {
+		_, _ = buf.WriteString(" RENAME TO ")
+		_, _ = buf.WriteString(s.NewName)
+	}
+
+	if s.ReadOnly != nil {
+		if *s.ReadOnly {
+			_, _ = buf.WriteString(" READ ONLY")
+		} else {
+			_, _ = buf.WriteString(" READ WRITE")
+		}
+	}
+
+	if s.Disabled != nil {
+		if *s.Disabled {
+			_, _ = buf.WriteString(" DISABLE")
+		} else {
+			_, _ = buf.WriteString(" ENABLE")
+		}
+	}
+
+	return buf.String()
+}
+
 // DropDatabaseStatement represents a command to drop a database.
 type DropDatabaseStatement struct {
 	// Name of the database to be dropped.
@@ -394,6 +466,9 @@ type AlterRetentionPolicyStatement struct {
 
 	// Should this policy be set as defalut for the database?
 	Default bool
+
+	// New name of the policy, if it is being renamed.
+	NewName string
 }
 
 // String returns a string representation of the alter retention policy statement.
@@ -418,6 +493,11 @@ func (s *AlterRetentionPolicyStatement) String() string {
 		_, _ = buf.WriteString(" DEFAULT")
 	}
 
+	if s.NewName != "" {
+		_, _ = buf.WriteString(" RENAME TO ")
+		_, _ = buf.WriteString(s.NewName)
+	}
+
 	return buf.String()
 }
 
@@ -519,6 +599,38 @@ WHERE yyy.region == "uswest"
 
 */
 
+// Clone returns a deep copy of the statement.
+func (s *SelectStatement) Clone() *SelectStatement {
+	other := &SelectStatement{
+		Source:    CloneSource(s.Source),
+		Condition: CloneExpr(s.Condition),
+		Limit:     s.Limit,
+	}
+	if s.Target != nil {
+		t := *s.Target
+		other.Target = &t
+	}
+	if s.Fields != nil {
+		other.Fields = make(Fields, len(s.Fields))
+		for i, f := range s.Fields {
+			other.Fields[i] = &Field{Expr: CloneExpr(f.Expr), Alias: f.Alias}
+		}
+	}
+	if s.Dimensions != nil {
+		other.Dimensions = make(Dimensions, len(s.Dimensions))
+		for i, d := range s.Dimensions {
+			other.Dimensions[i] = &Dimension{Expr: CloneExpr(d.Expr)}
+		}
+	}
+	if s.SortFields != nil {
+		other.SortFields = make(SortFields, len(s.SortFields))
+		for i, f := range s.SortFields {
+			other.SortFields[i] = &SortField{Name: f.Name, Ascending: f.Ascending}
+		}
+	}
+	return other
+}
+
 // Substatement returns a single-series statement for a given variable reference.
 func (s *SelectStatement) Substatement(ref *VarRef) (*SelectStatement, error) {
 	// Copy dimensions and properties to new statement.
@@ -586,6 +698,13 @@ func filterExprBySource(name string, expr Expr) Expr {
 			return nil
 		}
 		return &ParenExpr{Expr: exp}
+
+	case *CastExpr:
+		exp := filterExprBySource(name, expr.Expr)
+		if exp == nil {
+			return nil
+		}
+		return &CastExpr{Expr: exp, Type: expr.Type}
 	}
 	return expr
 }
@@ -646,9 +765,30 @@ func (t *Target) String() string {
 	return buf.String()
 }
 
+// ExplainStatement represents a command for returning the execution plan of a query.
+type ExplainStatement struct {
+	// The query to be planned.
+	Statement *SelectStatement
+
+	// If true, the query is also executed and per-stage statistics are returned.
+	Analyze bool
+}
+
+// String returns a string representation of the explain statement.
+func (s *ExplainStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("EXPLAIN ")
+	if s.Analyze {
+		_, _ = buf.WriteString("ANALYZE ")
+	}
+	_, _ = buf.WriteString(s.Statement.String())
+	return buf.String()
+}
+
 // DeleteStatement represents a command for removing data from the database.
 type DeleteStatement struct {
 	// Data source that values are removed from.
+	// Values are removed from every measurement if nil.
 	Source Source
 
 	// An expression evaluated on data point.
@@ -658,17 +798,27 @@ type DeleteStatement struct {
 // String returns a string representation of the delete statement.
 func (s *DeleteStatement) String() string {
 	var buf bytes.Buffer
-	_, _ = buf.WriteString("DELETE ")
-	_, _ = buf.WriteString(s.Source.String())
+	_, _ = buf.WriteString("DELETE")
+	if s.Source != nil {
+		_, _ = buf.WriteString(" FROM ")
+		_, _ = buf.WriteString(s.Source.String())
+	}
 	if s.Condition != nil {
 		_, _ = buf.WriteString(" WHERE ")
 		_, _ = buf.WriteString(s.Condition.String())
 	}
-	return s.String()
+	return buf.String()
 }
 
 // ListSeriesStatement represents a command for listing series in the database.
 type ListSeriesStatement struct {
+	// Returns estimated counts instead of the series.
+	Estimate bool
+
+	// Data source that series are listed from.
+	// All measurements are used if nil.
+	Source Source
+
 	// An expression evaluated on a series name or tag.
 	Condition Expr
 
@@ -678,6 +828,9 @@ type ListSeriesStatement struct {
 	// Maximum number of rows to be returned.
 	// Unlimited if zero.
 	Limit int
+
+	// Number of rows to skip.
+	Offset int
 }
 
 // String returns a string representation of the list series statement.
@@ -685,6 +838,13 @@ func (s *ListSeriesStatement) String() string {
 	var buf bytes.Buffer
 	_, _ = buf.WriteString("LIST SERIES")
 
+	if s.Estimate {
+		_, _ = buf.WriteString(" ESTIMATE")
+	}
+	if s.Source != nil {
+		_, _ = buf.WriteString(" FROM ")
+		_, _ = buf.WriteString(s.Source.String())
+	}
 	if s.Condition != nil {
 		_, _ = buf.WriteString(" WHERE ")
 		_, _ = buf.WriteString(s.Condition.String())
@@ -697,6 +857,10 @@ func (s *ListSeriesStatement) String() string {
 		_, _ = buf.WriteString(" LIMIT ")
 		_, _ = buf.WriteString(strconv.Itoa(s.Limit))
 	}
+	if s.Offset > 0 {
+		_, _ = buf.WriteString(" OFFSET ")
+		_, _ = buf.WriteString(strconv.Itoa(s.Offset))
+	}
 	return buf.String()
 }
 
@@ -720,6 +884,48 @@ type ListDatabasesStatement struct{}
 // String returns a string representation of the list databases command.
 func (s *ListDatabasesStatement) String() string { return "LIST DATABASES" }
 
+// ShowStatsStatement represents a command for showing per-database statistics.
+type ShowStatsStatement struct {
+	// Database to show statistics and quota usage for.
+	// If blank, statistics are shown for every database.
+	Database string
+}
+
+// String returns a string representation of the show stats command.
+func (s *ShowStatsStatement) String() string {
+	if s.Database != "" {
+		return "SHOW STATS FOR DATABASE " + s.Database
+	}
+	return "SHOW STATS"
+}
+
+// ShowAuditStatement represents a command for showing the audit log.
+type ShowAuditStatement struct {
+	// Maximum number of most recent entries to show. Zero shows every entry.
+	Limit int
+}
+
+// String returns a string representation of the show audit statement.
+func (s *ShowAuditStatement) String() string {
+	if s.Limit > 0 {
+		return fmt.Sprintf("SHOW AUDIT LIMIT %d", s.Limit)
+	}
+	return "SHOW AUDIT"
+}
+
+// ShowQueriesStatement represents a command for showing running queries.
+type ShowQueriesStatement struct{}
+
+// String returns a string representation of the show queries statement.
+func (s *ShowQueriesStatement) String() string { return "SHOW QUERIES" }
+
+// ShowDeletionsStatement represents a command for showing deletions that
+// have not yet been purged from disk.
+type ShowDeletionsStatement struct{}
+
+// String returns a string representation of the show deletions statement.
+func (s *ShowDeletionsStatement) String() string { return "SHOW DELETIONS" }
+
 // CreateContinuousQueriesStatement represents a command for creating a continuous query.
 type CreateContinuousQueryStatement struct {
 	// Name of the continuous query to be created.
@@ -822,18 +1028,29 @@ func (s *ListTagKeysStatement) String() string {
 
 // ListTagValuesStatement represents a command for listing tag values.
 type ListTagValuesStatement struct {
+	// Returns estimated counts instead of the values.
+	Estimate bool
+
 	// Data source that fields are extracted from.
+	// All measurements are used if nil.
 	Source Source
 
+	// Tag keys to list the values of.
+	// All tag keys are used if empty.
+	TagKeys []string
+
 	// An expression evaluated on data point.
 	Condition Expr
 
 	// Fields to sort results by
 	SortFields SortFields
 
-	// Maximum number of rows to be returned.
+	// Maximum number of values to be returned for each tag key.
 	// Unlimited if zero.
 	Limit int
+
+	// Number of values to skip for each tag key.
+	Offset int
 }
 
 // String returns a string representation of the statement.
@@ -841,10 +1058,26 @@ func (s *ListTagValuesStatement) String() string {
 	var buf bytes.Buffer
 	_, _ = buf.WriteString("LIST TAG VALUES")
 
+	if s.Estimate {
+		_, _ = buf.WriteString(" ESTIMATE")
+	}
 	if s.Source != nil {
 		_, _ = buf.WriteString(" FROM ")
 		_, _ = buf.WriteString(s.Source.String())
 	}
+	if len(s.TagKeys) == 1 {
+		_, _ = buf.WriteString(" WITH KEY = ")
+		_, _ = buf.WriteString(QuoteIdent(s.TagKeys[0]))
+	} else if len(s.TagKeys) > 1 {
+		_, _ = buf.WriteString(" WITH KEY IN (")
+		for i, k := range s.TagKeys {
+			if i > 0 {
+				_, _ = buf.WriteString(", ")
+			}
+			_, _ = buf.WriteString(QuoteIdent(k))
+		}
+		_, _ = buf.WriteString(")")
+	}
 	if s.Condition != nil {
 		_, _ = buf.WriteString(" WHERE ")
 		_, _ = buf.WriteString(s.Condition.String())
@@ -857,6 +1090,10 @@ func (s *ListTagValuesStatement) String() string {
 		_, _ = buf.WriteString(" LIMIT ")
 		_, _ = buf.WriteString(strconv.Itoa(s.Limit))
 	}
+	if s.Offset > 0 {
+		_, _ = buf.WriteString(" OFFSET ")
+		_, _ = buf.WriteString(strconv.Itoa(s.Offset))
+	}
 	return buf.String()
 }
 
@@ -967,7 +1204,12 @@ func (f *Field) Name() string {
 	}
 
 	// Return the function name or variable name, if available.
-	switch expr := f.Expr.(type) {
+	// Casts are named after the expression being cast.
+	expr := f.Expr
+	if cast, ok := expr.(*CastExpr); ok {
+		expr = cast.Expr
+	}
+	switch expr := expr.(type) {
 	case *Call:
 		return expr.Name
 	case *VarRef:
@@ -1021,10 +1263,18 @@ func (a Measurements) String() string {
 // Measurement represents a single measurement used as a datasource.
 type Measurement struct {
 	Name string
+
+	// Matches measurement names instead of Name, if set.
+	Regex *RegexLiteral
 }
 
 // String returns a string representation of the measurement.
-func (m *Measurement) String() string { return QuoteIdent(m.Name) }
+func (m *Measurement) String() string {
+	if m.Regex != nil {
+		return m.Regex.String()
+	}
+	return QuoteIdent(m.Name)
+}
 
 // Join represents two datasources joined together.
 type Join struct {
@@ -1101,6 +1351,16 @@ type StringLiteral struct {
 // String returns a string representation of the literal.
 func (l *StringLiteral) String() string { return Quote(l.Val) }
 
+// RegexLiteral represents a regular expression literal.
+type RegexLiteral struct {
+	Val *regexp.Regexp
+}
+
+// String returns a string representation of the literal.
+func (l *RegexLiteral) String() string {
+	return "/" + strings.Replace(l.Val.String(), "/", `\/`, -1) + "/"
+}
+
 // TimeLiteral represents a point-in-time literal.
 type TimeLiteral struct {
 	Val time.Time
@@ -1139,12 +1399,104 @@ type ParenExpr struct {
 // String returns a string representation of the parenthesized expression.
 func (e *ParenExpr) String() string { return fmt.Sprintf("(%s)", e.Expr.String()) }
 
+// CastExpr represents an expression converted to another data type.
+// Values that cannot be converted are treated as null.
+type CastExpr struct {
+	Expr Expr
+	Type string
+}
+
+// String returns a string representation of the cast expression.
+func (e *CastExpr) String() string { return fmt.Sprintf("%s::%s", e.Expr.String(), e.Type) }
+
+// IsCastType returns true if typ is a data type that values can be cast to.
+func IsCastType(typ string) bool {
+	switch typ {
+	case "integer", "float", "string", "boolean":
+		return true
+	}
+	return false
+}
+
 // Wildcard represents a wild card expression.
 type Wildcard struct{}
 
 // String returns a string representation of the wildcard.
 func (e *Wildcard) String() string { return "*" }
 
+// CloneSource returns a deep copy of a source.
+func CloneSource(source Source) Source {
+	if source == nil {
+		return nil
+	}
+
+	switch source := source.(type) {
+	case *Measurement:
+		return &Measurement{Name: source.Name}
+	case *Join:
+		return &Join{Measurements: cloneMeasurements(source.Measurements)}
+	case *Merge:
+		return &Merge{Measurements: cloneMeasurements(source.Measurements)}
+	default:
+		panic("unreachable")
+	}
+}
+
+// cloneMeasurements returns a deep copy of a list of measurements.
+func cloneMeasurements(a Measurements) Measurements {
+	if a == nil {
+		return nil
+	}
+	other := make(Measurements, len(a))
+	for i, m := range a {
+		other[i] = &Measurement{Name: m.Name}
+	}
+	return other
+}
+
+// CloneExpr returns a deep copy of an expression.
+func CloneExpr(expr Expr) Expr {
+	if expr == nil {
+		return nil
+	}
+
+	switch expr := expr.(type) {
+	case *BinaryExpr:
+		return &BinaryExpr{Op: expr.Op, LHS: CloneExpr(expr.LHS), RHS: CloneExpr(expr.RHS)}
+	case *BooleanLiteral:
+		return &BooleanLiteral{Val: expr.Val}
+	case *Call:
+		var args []Expr
+		if expr.Args != nil {
+			args = make([]Expr, len(expr.Args))
+			for i, arg := range expr.Args {
+				args[i] = CloneExpr(arg)
+			}
+		}
+		return &Call{Name: expr.Name, Args: args}
+	case *DurationLiteral:
+		return &DurationLiteral{Val: expr.Val}
+	case *NumberLiteral:
+		return &NumberLiteral{Val: expr.Val}
+	case *ParenExpr:
+		return &ParenExpr{Expr: CloneExpr(expr.Expr)}
+	case *RegexLiteral:
+		return &RegexLiteral{Val: expr.Val}
+	case *CastExpr:
+		return &CastExpr{Expr: CloneExpr(expr.Expr), Type: expr.Type}
+	case *StringLiteral:
+		return &StringLiteral{Val: expr.Val}
+	case *TimeLiteral:
+		return &TimeLiteral{Val: expr.Val}
+	case *VarRef:
+		return &VarRef{Val: expr.Val}
+	case *Wildcard:
+		return &Wildcard{}
+	default:
+		panic("unreachable")
+	}
+}
+
 // Fold performs constant folding on an expression.
 // The function, "now()", is expanded into the current time during folding.
 func Fold(expr Expr, now *time.Time) Expr {
@@ -1174,6 +1526,11 @@ func Fold(expr Expr, now *time.Time) Expr {
 		}
 		return expr
 
+	case *CastExpr:
+		// Fold inside expression.
+		expr.Expr = Fold(expr.Expr, now)
+		return expr
+
 	default:
 		return expr
 	}
@@ -1424,11 +1781,178 @@ func timeExprValue(ref Expr, lit Expr) time.Time {
 			return lit.Val
 		case *DurationLiteral:
 			return time.Unix(0, int64(lit.Val)).UTC()
+		case *NumberLiteral:
+			return time.Unix(0, int64(lit.Val)).UTC()
 		}
 	}
 	return time.Time{}
 }
 
+// Eval evaluates an expression against a map of field values. Variable
+// references are looked up in the map and are nil if they are missing.
+// Comparisons of values that can't be compared are false and arithmetic on
+// non-numeric values is nil. Regular expressions only match strings.
+func Eval(expr Expr, m map[string]interface{}) interface{} {
+	switch expr := expr.(type) {
+	case *BinaryExpr:
+		return evalBinaryExpr(expr, m)
+	case *ParenExpr:
+		return Eval(expr.Expr, m)
+	case *CastExpr:
+		return castValue(Eval(expr.Expr, m), expr.Type)
+	case *VarRef:
+		return m[expr.Val]
+	case *NumberLiteral:
+		return expr.Val
+	case *StringLiteral:
+		return expr.Val
+	case *BooleanLiteral:
+		return expr.Val
+	case *TimeLiteral:
+		return expr.Val
+	case *DurationLiteral:
+		return expr.Val
+	case *RegexLiteral:
+		return expr.Val
+	}
+	return nil
+}
+
+// evalBinaryExpr evaluates a binary expression against a map of field values.
+func evalBinaryExpr(expr *BinaryExpr, m map[string]interface{}) interface{} {
+	lhs := Eval(expr.LHS, m)
+
+	// Logical operators only evaluate the RHS if they need to.
+	switch expr.Op {
+	case AND:
+		return lhs == true && Eval(expr.RHS, m) == true
+	case OR:
+		return lhs == true || Eval(expr.RHS, m) == true
+	}
+	rhs := Eval(expr.RHS, m)
+
+	switch expr.Op {
+	case EQREGEX, NEQREGEX:
+		s, ok := lhs.(string)
+		re, _ := rhs.(*regexp.Regexp)
+		if !ok || re == nil {
+			return false
+		}
+		return re.MatchString(s) == (expr.Op == EQREGEX)
+	case EQ, NEQ, LT, LTE, GT, GTE:
+		cmp, ok := compareValues(lhs, rhs)
+		if !ok {
+			return false
+		}
+		switch expr.Op {
+		case EQ:
+			return cmp == 0
+		case NEQ:
+			return cmp != 0
+		case LT:
+			return cmp < 0
+		case LTE:
+			return cmp <= 0
+		case GT:
+			return cmp > 0
+		default:
+			return cmp >= 0
+		}
+	case ADD, SUB, MUL, DIV:
+		return evalArithmetic(expr.Op, lhs, rhs)
+	}
+	return nil
+}
+
+// evalArithmetic applies an arithmetic operator to two numeric values.
+// Returns nil if either value is not numeric. Division by zero is zero.
+func evalArithmetic(op Token, lhs, rhs interface{}) interface{} {
+	l, ok := asFloat(lhs)
+	if !ok {
+		return nil
+	}
+	r, ok := asFloat(rhs)
+	if !ok {
+		return nil
+	}
+
+	switch op {
+	case ADD:
+		return l + r
+	case SUB:
+		return l - r
+	case MUL:
+		return l * r
+	default:
+		if r == 0 {
+			return float64(0)
+		}
+		return l / r
+	}
+}
+
+// compareValues compares two values of the same kind. Numbers of any type
+// are compared as floats and times are compared with numbers as nanoseconds
+// since the epoch. Returns false if the values can't be compared.
+func compareValues(a, b interface{}) (int, bool) {
+	if t, ok := a.(time.Time); ok {
+		if _, ok := asFloat(b); ok {
+			a = t.UnixNano()
+		}
+	} else if t, ok := b.(time.Time); ok {
+		if _, ok := asFloat(a); ok {
+			b = t.UnixNano()
+		}
+	}
+
+	if x, ok := asFloat(a); ok {
+		y, ok := asFloat(b)
+		switch {
+		case !ok:
+			return 0, false
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	switch a := a.(type) {
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return 0, false
+		} else if a < b {
+			return -1, true
+		} else if a > b {
+			return 1, true
+		}
+		return 0, true
+	case bool:
+		b, ok := b.(bool)
+		if !ok {
+			return 0, false
+		} else if a == b {
+			return 0, true
+		} else if b {
+			return -1, true
+		}
+		return 1, true
+	case time.Time:
+		b, ok := b.(time.Time)
+		if !ok {
+			return 0, false
+		} else if a.Before(b) {
+			return -1, true
+		} else if a.After(b) {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
 // Visitor can be called by Walk to traverse an AST hierarchy.
 // The Visit() function is called once per node.
 type Visitor interface {
@@ -1450,6 +1974,9 @@ func Walk(v Visitor, node Node) {
 			Walk(v, s)
 		}
 
+	case *ExplainStatement:
+		Walk(v, n.Statement)
+
 	case *SelectStatement:
 		Walk(v, n.Fields)
 		Walk(v, n.Dimensions)
@@ -1479,6 +2006,9 @@ func Walk(v Visitor, node Node) {
 	case *ParenExpr:
 		Walk(v, n.Expr)
 
+	case *CastExpr:
+		Walk(v, n.Expr)
+
 	case *Call:
 		for _, expr := range n.Args {
 			Walk(v, expr)
@@ -1542,6 +2072,9 @@ func Rewrite(r Rewriter, node Node) Node {
 	case *ParenExpr:
 		n.Expr = Rewrite(r, n.Expr).(Expr)
 
+	case *CastExpr:
+		n.Expr = Rewrite(r, n.Expr).(Expr)
+
 	case *Call:
 		for i, expr := range n.Args {
 			n.Args[i] = Rewrite(r, expr).(Expr)
@@ -1,6 +1,7 @@
 package influxql_test
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 
@@ -93,6 +94,88 @@ func TestSelectStatement_Substatement(t *testing.T) {
 	}
 }
 
+// Ensure a SELECT statement can be deeply copied.
+func TestSelectStatement_Clone(t *testing.T) {
+	s := `SELECT sum(value) + 1 AS total INTO "1h".cpu_1h FROM join(aa, bb) WHERE host = 'servera' AND (time > now() - 1h) GROUP BY time(10m), region ORDER BY ASC LIMIT 5`
+	stmt := MustParseSelectStatement(s)
+	other := stmt.Clone()
+	if !reflect.DeepEqual(stmt, other) {
+		t.Fatalf("mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", stmt, other)
+	}
+
+	// Folding the copy must not modify the original statement.
+	now := mustParseTime("2000-01-01T00:00:00Z")
+	other.Condition = influxql.Fold(other.Condition, &now)
+	other.Fields[0].Expr.(*influxql.BinaryExpr).RHS.(*influxql.NumberLiteral).Val = 2
+	if reflect.DeepEqual(stmt, other) {
+		t.Fatal("expected copy to be modified")
+	} else if !reflect.DeepEqual(stmt, MustParseSelectStatement(s)) {
+		t.Fatalf("original modified: %s", stmt)
+	}
+}
+
+// Ensure a cast field is named after the expression being cast.
+func TestField_Name_Cast(t *testing.T) {
+	stmt := MustParseSelectStatement(`SELECT value::integer, sum(value::float)::string, value::float AS v FROM cpu`)
+	for i, name := range []string{"value", "sum", "v"} {
+		if act := stmt.Fields[i].Name(); act != name {
+			t.Errorf("%d. unexpected name: %s", i, act)
+		}
+	}
+	if act := stmt.String(); act != `SELECT value::integer, sum(value::float)::string, value::float AS v FROM cpu` {
+		t.Fatalf("unexpected string: %s", act)
+	}
+}
+
+// Ensure a delete statement can be converted back to a string.
+func TestDeleteStatement_String(t *testing.T) {
+	for i, s := range []string{
+		`DELETE FROM cpu WHERE host = "serverA"`,
+		`DELETE WHERE time < now() - 90d`,
+	} {
+		stmt, err := influxql.NewParser(strings.NewReader(s)).ParseStatement()
+		if err != nil {
+			t.Fatalf("%d. %s: %s", i, s, err)
+		} else if act := stmt.String(); act != s {
+			t.Errorf("%d. unexpected string: %s", i, act)
+		}
+	}
+}
+
+// Ensure a list series statement can be converted back to a string.
+func TestListSeriesStatement_String(t *testing.T) {
+	for i, s := range []string{
+		`LIST SERIES`,
+		`LIST SERIES ESTIMATE FROM /^cpu/`,
+		`LIST SERIES FROM cpu WHERE region = "uswest" LIMIT 10 OFFSET 20`,
+	} {
+		stmt, err := influxql.NewParser(strings.NewReader(s)).ParseStatement()
+		if err != nil {
+			t.Fatalf("%d. %s: %s", i, s, err)
+		} else if act := stmt.String(); act != s {
+			t.Errorf("%d. unexpected string: %s", i, act)
+		}
+	}
+}
+
+// Ensure a list tag values statement can be converted back to a string.
+func TestListTagValuesStatement_String(t *testing.T) {
+	for i, s := range []string{
+		`LIST TAG VALUES`,
+		`LIST TAG VALUES FROM cpu WITH KEY = host`,
+		`LIST TAG VALUES FROM /cpu\/.*/ WITH KEY IN (host, region) WHERE time > now() - 1h`,
+		`LIST TAG VALUES ESTIMATE WITH KEY = host`,
+		`LIST TAG VALUES WITH KEY = host LIMIT 10 OFFSET 10`,
+	} {
+		stmt, err := influxql.NewParser(strings.NewReader(s)).ParseStatement()
+		if err != nil {
+			t.Fatalf("%d. %s: %s", i, s, err)
+		} else if act := stmt.String(); act != s {
+			t.Errorf("%d. unexpected string: %s", i, act)
+		}
+	}
+}
+
 // Ensure an expression can be folded.
 func TestFold(t *testing.T) {
 	for i, tt := range []struct {
@@ -130,6 +213,8 @@ func TestFold(t *testing.T) {
 		{`now() >= now() - 1h`, `true`},
 		{`now() > now() - 1h`, `true`},
 		{`now() - (now() - 60s)`, `1m`},
+		{`now() - 1h - 30m`, `"1999-12-31 22:30:00"`},
+		{`time > now() - 1h AND time < now() + 30m - 10m`, `time > "1999-12-31 23:00:00" AND time < "2000-01-01 00:20:00"`},
 		{`now() AND now()`, `"2000-01-01 00:00:00" AND "2000-01-01 00:00:00"`},
 
 		// Duration literals.
@@ -197,6 +282,9 @@ func TestTimeRange(t *testing.T) {
 
 		// Absolute time
 		{expr: `time = 1388534400s`, min: `2014-01-01 00:00:00`, max: `2014-01-01 00:00:00`},
+		{expr: `time = 1388534400000ms`, min: `2014-01-01 00:00:00`, max: `2014-01-01 00:00:00`},
+		{expr: `time = 1388534400000000000`, min: `2014-01-01 00:00:00`, max: `2014-01-01 00:00:00`},
+		{expr: `time >= '2014-01-01T00:00:00Z' AND time < '2014-01-01T02:00:00+01:00'`, min: `2014-01-01 00:00:00`, max: `2014-01-01 00:59:59.999999`},
 
 		// Non-comparative expressions.
 		{expr: `time`, min: `0001-01-01 00:00:00`, max: `0001-01-01 00:00:00`},
@@ -220,6 +308,33 @@ func TestTimeRange(t *testing.T) {
 	}
 }
 
+// Ensure an expression can be evaluated against field values.
+func TestEval(t *testing.T) {
+	values := map[string]interface{}{"msg": "disk full", "code": float64(500), "n": int64(3), "ok": false}
+	for i, tt := range []struct {
+		expr string
+		exp  interface{}
+	}{
+		{expr: `msg = 'disk full'`, exp: true},
+		{expr: `msg != 'disk full'`, exp: false},
+		{expr: `msg < 'timeout'`, exp: true},
+		{expr: `msg =~ /^disk/`, exp: true},
+		{expr: `msg !~ /^disk/`, exp: false},
+		{expr: `code =~ /5/`, exp: false},
+		{expr: `code >= 500 AND n < 4`, exp: true},
+		{expr: `code > 500 OR ok = false`, exp: true},
+		{expr: `(code + n) * 2`, exp: float64(1006)},
+		{expr: `n::string = '3'`, exp: true},
+		{expr: `msg = 500`, exp: false},
+		{expr: `missing = 'x'`, exp: false},
+		{expr: `missing + 1`, exp: nil},
+	} {
+		if v := influxql.Eval(MustParseExpr(tt.expr), values); !reflect.DeepEqual(v, tt.exp) {
+			t.Errorf("%d. %s: unexpected value: %v", i, tt.expr, v)
+		}
+	}
+}
+
 // Ensure an AST node can be rewritten.
 func TestRewrite(t *testing.T) {
 	expr := MustParseExpr(`time > 1 OR foo = 2`)
@@ -16,6 +16,11 @@ You can also add a a conditional expression to limit the results of the query:
 
 	SELECT value FROM cpu_load WHERE host = 'influxdb.com'
 
+Conditions on fields filter the points that are read. String fields can be
+matched against a regular expression with "=~" and "!~":
+
+	SELECT message FROM logs WHERE level != 'info' AND message =~ /^disk/
+
 Two or more series can be combined into a single query and executed together:
 
 	SELECT cpu0.value + cpu1.value
@@ -26,6 +31,59 @@ Limits and ordering can be set on selection queries as well:
 	SELECT value FROM cpu_load LIMIT 100 ORDER DESC;
 
 
+Bound parameters
+
+Values in an expression can be replaced with named parameters prefixed with
+a "$". The values are supplied separately with Parser.SetParams and are
+always treated as literals, never as query text:
+
+	SELECT value FROM cpu_load WHERE host = $host
+
+
+Casting values
+
+Field values can be converted to another type by appending "::" and one of
+"integer", "float", "string" or "boolean". Floats are truncated when cast to
+integers and strings are parsed. Values that cannot be converted are returned
+as null and are ignored by aggregate functions:
+
+	SELECT value::integer FROM cpu_load
+	SELECT sum(value::float) FROM cpu_load
+
+Integer and unsigned fields are summed exactly. Sums that don't fit in 64 bits
+are returned as arbitrary precision integers and means are computed from the
+exact sum.
+
+
+Explaining queries
+
+Prefixing a SELECT query with EXPLAIN returns the execution plan instead of
+the query results. The plan includes the shards, index strategy and iterators
+used to execute the query:
+
+	EXPLAIN SELECT count(value) FROM cpu_load WHERE host = 'influxdb.com'
+
+Using EXPLAIN ANALYZE will also execute the query and report the number of
+rows and the time spent in each stage of the plan. Each shard reports the
+points and bytes read from it, the time spent reading it and the time its
+reads waited for a worker:
+
+	EXPLAIN ANALYZE SELECT count(value) FROM cpu_load
+
+
+Showing statistics
+
+The SHOW STATS query returns the number of points written, bytes received,
+queries executed and errors for each database on the server:
+
+	SHOW STATS
+
+The SHOW QUERIES query lists the statements currently running on the server
+with their duration and the memory they have allocated:
+
+	SHOW QUERIES
+
+
 Removing data
 
 The DELETE query is available to remove time series data points from the
@@ -33,6 +91,12 @@ database. This query will delete "cpu_load" values older than an hour:
 
 	DELETE FROM cpu_load WHERE time < now() - 1h
 
+Deleted points are hidden from queries right away and removed from disk
+later. The SHOW DELETIONS query lists the deletes that haven't been removed
+from disk yet:
+
+	SHOW DELETIONS
+
 
 Continuous Queries
 
@@ -1,15 +1,26 @@
 package influxql
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"math/big"
+	"math/rand"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrPointLimitExceeded is returned when a query scans more points than
+// the planner allows.
+var ErrPointLimitExceeded = errors.New("max points scanned exceeded")
+
 // DB represents an interface to the underlying storage.
 type DB interface {
 	// Returns a list of series data ids matching a name and tags.
@@ -23,7 +34,8 @@ type DB interface {
 	Field(name, field string) (fieldID uint8, typ DataType)
 
 	// Returns an iterator given a series data id, field id, & field data type.
-	CreateIterator(id uint32, fieldID uint8, typ DataType, min, max time.Time, interval time.Duration) Iterator
+	// If cond is set then only points whose field values match it are read.
+	CreateIterator(id uint32, fieldID uint8, typ DataType, min, max time.Time, interval time.Duration, cond Expr) Iterator
 }
 
 // Planner represents an object for creating execution plans.
@@ -33,13 +45,32 @@ type Planner struct {
 
 	// Returns the current time. Defaults to time.Now().
 	Now func() time.Time
+
+	// The maximum number of points a single query can read.
+	// A value of zero means that there is no limit.
+	MaxPointsScanned int
+
+	// The maximum number of values returned for each row. Series stop being
+	// read once they have produced more rows than the limit. The caller is
+	// responsible for dropping the extra values. A value of zero means that
+	// there is no limit.
+	MaxRowLimit int
+
+	// Iterators stop once the context is done and execution is halted
+	// with its error. Defaults to a context that is never done.
+	Context context.Context
+
+	// If set, it is called with each measurement the statement reads from
+	// and planning fails with the error it returns.
+	Authorize func(measurement string) error
 }
 
 // NewPlanner returns a new instance of Planner.
 func NewPlanner(db DB) *Planner {
 	return &Planner{
-		DB:  db,
-		Now: time.Now,
+		DB:      db,
+		Now:     time.Now,
+		Context: context.Background(),
 	}
 }
 
@@ -49,6 +80,11 @@ func (p *Planner) Plan(stmt *SelectStatement) (*Executor, error) {
 		db:         p.DB,
 		stmt:       stmt,
 		processors: make([]processor, len(stmt.Fields)),
+		maxPoints:  int64(p.MaxPointsScanned),
+		ctx:        p.Context,
+	}
+	if e.ctx == nil {
+		e.ctx = context.Background()
 	}
 
 	// Fold conditional.
@@ -66,11 +102,40 @@ func (p *Planner) Plan(stmt *SelectStatement) (*Executor, error) {
 	e.min, e.max = min, max
 
 	// Determine group by interval.
-	interval, tags, err := p.normalizeDimensions(stmt.Dimensions)
+	interval, tags, buckets, err := p.normalizeDimensions(stmt.Dimensions)
 	if err != nil {
 		return nil, err
 	}
-	e.interval, e.tags = interval, tags
+	e.interval, e.tags, e.buckets = interval, tags, buckets
+
+	// Raw field values can only be selected by themselves.
+	if hasRawField(stmt.Fields) {
+		if len(stmt.Fields) > 1 {
+			return nil, errors.New("raw fields cannot be selected with other fields")
+		} else if interval != 0 {
+			return nil, errors.New("raw fields cannot be grouped by time")
+		}
+	}
+
+	// elapsed() returns a value for each point so it is restricted like raw fields.
+	if hasCall(stmt.Fields, "elapsed") {
+		if len(stmt.Fields) > 1 {
+			return nil, errors.New("elapsed() cannot be selected with other fields")
+		} else if interval != 0 {
+			return nil, errors.New("elapsed() cannot be grouped by time")
+		}
+	}
+
+	// Transforms read every value before they emit any and sample() emits
+	// several values for each interval so they can't be combined with other
+	// fields either.
+	if len(stmt.Fields) > 1 {
+		for _, f := range stmt.Fields {
+			if call, ok := f.Expr.(*Call); ok && (isTransform(call.Name) || strings.ToLower(call.Name) == "sample") {
+				return nil, fmt.Errorf("%s() cannot be selected with other fields", call.Name)
+			}
+		}
+	}
 
 	// Generate a processor for each field.
 	for i, f := range stmt.Fields {
@@ -81,33 +146,74 @@ func (p *Planner) Plan(stmt *SelectStatement) (*Executor, error) {
 		e.processors[i] = p
 	}
 
+	// Limit the number of rows read by each series. Samples emit several
+	// values for each interval so their mappers are left unlimited.
+	if p.MaxRowLimit > 0 {
+		for _, proc := range e.processors {
+			r, ok := proc.(*reducer)
+			if !ok || (r.call != nil && strings.ToLower(r.call.Name) == "sample") {
+				continue
+			}
+			for _, m := range r.mappers {
+				m.limit = p.MaxRowLimit
+			}
+		}
+	}
+
 	return e, nil
 }
 
 // normalizeDimensions extacts the time interval, if specified.
-// Returns all remaining dimensions.
-func (p *Planner) normalizeDimensions(dimensions Dimensions) (time.Duration, []string, error) {
+// Returns the keys of all remaining dimensions and their buckets.
+func (p *Planner) normalizeDimensions(dimensions Dimensions) (time.Duration, []string, []*buckets, error) {
 	// Ignore if there are no dimensions.
 	if len(dimensions) == 0 {
-		return 0, nil, nil
+		return 0, nil, nil, nil
 	}
 
 	// If the first dimension is a "time(duration)" then extract the duration.
 	if call, ok := dimensions[0].Expr.(*Call); ok && strings.ToLower(call.Name) == "time" {
 		// Make sure there is exactly one argument.
 		if len(call.Args) != 1 {
-			return 0, nil, errors.New("time dimension expected one argument")
+			return 0, nil, nil, errors.New("time dimension expected one argument")
 		}
 
 		// Ensure the argument is a duration.
 		lit, ok := call.Args[0].(*DurationLiteral)
 		if !ok {
-			return 0, nil, errors.New("time dimension must have one duration argument")
+			return 0, nil, nil, errors.New("time dimension must have one duration argument")
+		}
+		keys, buckets, err := dimensionKeys(dimensions[1:])
+		return lit.Val, keys, buckets, err
+	}
+
+	keys, buckets, err := dimensionKeys(dimensions)
+	return 0, keys, buckets, err
+}
+
+// hasRawField returns true if any field selects a raw field value rather
+// than an aggregate.
+func hasRawField(fields Fields) bool {
+	for _, f := range fields {
+		expr := f.Expr
+		if cast, ok := expr.(*CastExpr); ok {
+			expr = cast.Expr
+		}
+		if _, ok := expr.(*VarRef); ok {
+			return true
 		}
-		return lit.Val, dimensionKeys(dimensions[1:]), nil
 	}
+	return false
+}
 
-	return 0, dimensionKeys(dimensions), nil
+// hasCall returns true if any field is a call to the named function.
+func hasCall(fields Fields, name string) bool {
+	for _, f := range fields {
+		if call, ok := f.Expr.(*Call); ok && strings.ToLower(call.Name) == name {
+			return true
+		}
+	}
+	return false
 }
 
 // planField returns a processor for field.
@@ -119,7 +225,16 @@ func (p *Planner) planField(e *Executor, f *Field) (processor, error) {
 func (p *Planner) planExpr(e *Executor, expr Expr) (processor, error) {
 	switch expr := expr.(type) {
 	case *VarRef:
-		panic("TODO")
+		return p.planRaw(e, expr, "")
+	case *CastExpr:
+		if ref, ok := expr.Expr.(*VarRef); ok {
+			return p.planRaw(e, ref, expr.Type)
+		}
+		proc, err := p.planExpr(e, expr.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return newCastProcessor(proc, expr.Type), nil
 	case *Call:
 		return p.planCall(e, expr)
 	case *BinaryExpr:
@@ -142,24 +257,269 @@ func (p *Planner) planExpr(e *Executor, expr Expr) (processor, error) {
 
 // planCall generates a processor for a function call.
 func (p *Planner) planCall(e *Executor, c *Call) (processor, error) {
-	// Ensure there is a single argument.
-	if len(c.Args) != 1 {
-		return nil, fmt.Errorf("expected one argument for %s()", c.Name)
+	name := strings.ToLower(c.Name)
+	switch name {
+	case "holt_winters":
+		return p.planHoltWinters(e, c)
+	case "cumulative_sum", "derivative", "non_negative_derivative":
+		return p.planTransform(e, c)
+	}
+
+	// Ensure there is a single argument, a field and a percentile, or a field
+	// and an optional unit.
+	var percentile float64
+	var unit time.Duration
+	var size int
+	switch name {
+	case "percentile":
+		if len(c.Args) != 2 {
+			return nil, fmt.Errorf("expected two arguments for %s()", c.Name)
+		}
+		lit, ok := c.Args[1].(*NumberLiteral)
+		if !ok || lit.Val < 0 || lit.Val > 100 {
+			return nil, fmt.Errorf("expected percentile between 0 and 100 in %s()", c.Name)
+		}
+		percentile = lit.Val
+	case "sample":
+		if len(c.Args) != 2 {
+			return nil, fmt.Errorf("expected two arguments for %s()", c.Name)
+		}
+		lit, ok := c.Args[1].(*NumberLiteral)
+		if !ok || lit.Val < 1 || lit.Val != math.Trunc(lit.Val) {
+			return nil, fmt.Errorf("expected positive integer sample size in %s()", c.Name)
+		}
+		size = int(lit.Val)
+	case "elapsed", "integral":
+		if len(c.Args) != 1 && len(c.Args) != 2 {
+			return nil, fmt.Errorf("expected one or two arguments for %s()", c.Name)
+		}
+		unit = time.Nanosecond
+		if name == "integral" {
+			unit = time.Second
+		}
+		if len(c.Args) == 2 {
+			lit, ok := c.Args[1].(*DurationLiteral)
+			if !ok || lit.Val <= 0 {
+				return nil, fmt.Errorf("expected positive duration unit in %s()", c.Name)
+			}
+			unit = lit.Val
+		}
+	default:
+		if len(c.Args) != 1 {
+			return nil, fmt.Errorf("expected one argument for %s()", c.Name)
+		}
+	}
+
+	// count(distinct(field)) counts the distinct values of a field.
+	arg := c.Args[0]
+	var distinct bool
+	if call, ok := arg.(*Call); ok && name == "count" && strings.ToLower(call.Name) == "distinct" {
+		if len(call.Args) != 1 {
+			return nil, fmt.Errorf("expected one argument for %s()", call.Name)
+		}
+		arg, distinct = call.Args[0], true
 	}
 
-	// Ensure the argument is a variable reference.
-	ref, ok := c.Args[0].(*VarRef)
+	// Ensure the argument is a variable reference, optionally cast to a type.
+	var cast string
+	if expr, ok := arg.(*CastExpr); ok {
+		arg, cast = expr.Expr, expr.Type
+	}
+	ref, ok := arg.(*VarRef)
 	if !ok {
 		return nil, fmt.Errorf("expected field argument in %s()", c.Name)
 	}
 
-	// Extract the substatement for the call.
+	// Generate a reducer for the given function.
+	r, err := p.planReducer(e, ref, cast)
+	if err != nil {
+		return nil, err
+	}
+	r.call = c
+
+	// Histogram functions can only read histogram fields.
+	if (name == "histogram" || name == "percentile") && (r.typ != Histogram || cast != "") {
+		return nil, fmt.Errorf("%s() requires a histogram field", c.Name)
+	} else if name == "distinct" && r.typ == Histogram && cast == "" {
+		return nil, fmt.Errorf("%s() does not support histogram fields", c.Name)
+	} else if distinct && r.typ == Histogram && cast == "" {
+		return nil, errors.New("distinct() does not support histogram fields")
+	} else if (name == "integral" || name == "min" || name == "max") && (r.typ == String || r.typ == Boolean || r.typ == Histogram) && cast == "" {
+		return nil, fmt.Errorf("%s() requires a numeric field", c.Name)
+	}
+
+	// Set the appropriate reducer function.
+	switch name {
+	case "count":
+		if distinct {
+			r.fn = reduceCountDistinct
+			for _, m := range r.mappers {
+				m.fn = mapCountDistinct
+			}
+			break
+		}
+		r.fn = reduceCount
+		for _, m := range r.mappers {
+			m.fn = mapCount
+		}
+	case "sum":
+		r.fn = reduceSum
+		for _, m := range r.mappers {
+			m.fn = mapSum
+		}
+	case "mean":
+		r.fn = reduceMean
+		for _, m := range r.mappers {
+			m.fn = mapMean
+		}
+	case "distinct":
+		r.fn = reduceDistinct
+		for _, m := range r.mappers {
+			m.fn = mapDistinct
+		}
+	case "histogram":
+		r.fn = reduceHistogram
+		for _, m := range r.mappers {
+			m.fn = mapHistogram
+		}
+	case "percentile":
+		r.fn = reducePercentile(percentile)
+		for _, m := range r.mappers {
+			m.fn = mapHistogram
+		}
+	case "first", "last", "min", "max":
+		// A selector by itself returns the time of the selected point.
+		// Otherwise every field has the time of the interval.
+		pointTime := len(e.stmt.Fields) == 1 && e.stmt.Fields[0].Expr == Expr(c)
+		fn := selectors[name]
+		r.fn = reduceSelector(fn, pointTime)
+		for _, m := range r.mappers {
+			m.fn = mapSelector(fn, name == "min" || name == "max")
+		}
+	case "sample":
+		r.fn = reduceSample(size)
+		for _, m := range r.mappers {
+			m.fn = mapSample(size)
+		}
+	case "elapsed":
+		r.raw = true
+		for _, m := range r.mappers {
+			m.fn = mapElapsed(unit)
+		}
+	case "integral":
+		r.fn = reduceIntegral
+		for _, m := range r.mappers {
+			m.fn = mapIntegral(unit)
+		}
+	default:
+		return nil, fmt.Errorf("function not found: %q", c.Name)
+	}
+
+	return r, nil
+}
+
+// planTransform generates a processor for a function that transforms every
+// value of a field, of an aggregate grouped by time or of another transform,
+// in time order.
+func (p *Planner) planTransform(e *Executor, c *Call) (processor, error) {
+	name := strings.ToLower(c.Name)
+
+	// Check the function's arguments and choose the transform.
+	var fn transformFunc
+	switch name {
+	case "cumulative_sum":
+		if len(c.Args) != 1 {
+			return nil, fmt.Errorf("expected one argument for %s()", c.Name)
+		}
+		fn = cumulativeSumTransform
+	case "derivative", "non_negative_derivative":
+		if len(c.Args) != 1 && len(c.Args) != 2 {
+			return nil, fmt.Errorf("expected one or two arguments for %s()", c.Name)
+		}
+		unit := time.Second
+		if len(c.Args) == 2 {
+			lit, ok := c.Args[1].(*DurationLiteral)
+			if !ok || lit.Val <= 0 {
+				return nil, fmt.Errorf("expected positive duration unit in %s()", c.Name)
+			}
+			unit = lit.Val
+		}
+		fn = derivativeTransform(unit, name == "non_negative_derivative")
+	}
+
+	// Plan the field, aggregate or other transform being transformed.
+	var proc processor
+	var err error
+	switch arg := c.Args[0].(type) {
+	case *VarRef:
+		if e.interval != 0 {
+			return nil, fmt.Errorf("%s() of a field cannot be grouped by time", c.Name)
+		}
+		proc, err = p.planExpr(e, arg)
+	case *CastExpr:
+		if _, ok := arg.Expr.(*VarRef); !ok {
+			return nil, fmt.Errorf("expected field or aggregate argument in %s()", c.Name)
+		} else if e.interval != 0 {
+			return nil, fmt.Errorf("%s() of a field cannot be grouped by time", c.Name)
+		}
+		proc, err = p.planExpr(e, arg)
+	case *Call:
+		if strings.ToLower(arg.Name) == "elapsed" {
+			return nil, fmt.Errorf("expected field or aggregate argument in %s()", c.Name)
+		} else if e.interval == 0 && !isTransform(arg.Name) {
+			return nil, fmt.Errorf("%s() of an aggregate requires a GROUP BY time interval", c.Name)
+		}
+		proc, err = p.planCall(e, arg)
+	default:
+		return nil, fmt.Errorf("expected field or aggregate argument in %s()", c.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newTransformProcessor(proc, c, fn), nil
+}
+
+// isTransform returns true if a function transforms the values of a field or
+// aggregate instead of reducing the values of each interval.
+func isTransform(name string) bool {
+	switch strings.ToLower(name) {
+	case "holt_winters", "cumulative_sum", "derivative", "non_negative_derivative":
+		return true
+	}
+	return false
+}
+
+// planRaw generates a processor that returns every value of a field.
+// If cast is set then values are cast to that type.
+func (p *Planner) planRaw(e *Executor, ref *VarRef, cast string) (processor, error) {
+	r, err := p.planReducer(e, ref, cast)
+	if err != nil {
+		return nil, err
+	}
+	r.raw = true
+	for _, m := range r.mappers {
+		m.fn = mapRaw
+	}
+	return r, nil
+}
+
+// planReducer generates a reducer with a mapper for each series matching a
+// field reference. If cast is set then the mappers cast values to that type.
+func (p *Planner) planReducer(e *Executor, ref *VarRef, cast string) (*reducer, error) {
+	// Extract the substatement for the field.
 	sub, err := e.stmt.Substatement(ref)
 	if err != nil {
 		return nil, err
 	}
 	name := sub.Source.(*Measurement).Name
 
+	// Ensure the measurement can be read.
+	if p.Authorize != nil {
+		if err := p.Authorize(name); err != nil {
+			return nil, err
+		}
+	}
+
 	// Extract tags from conditional.
 	tags := make(map[string]string)
 	condition, err := p.extractTags(name, sub.Condition, tags)
@@ -175,37 +535,56 @@ func (p *Planner) planCall(e *Executor, c *Call) (processor, error) {
 		return nil, fmt.Errorf("field not found: %s.%s", name, fname)
 	}
 
-	// Generate a reducer for the given function.
+	// Extract the comparisons of field values from the conditional.
+	cond, err := p.fieldCondition(name, condition)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate a reducer for the field.
 	r := newReducer(e)
 	r.stmt = sub
+	r.ref = ref
+	r.typ = typ
+	r.tags = tags
+
+	// Split series into groups for the buckets of fields.
+	groups, err := p.bucketGroups(e, name, cond)
+	if err != nil {
+		return nil, err
+	}
 
 	// Retrieve a list of series data ids.
 	seriesIDs := p.DB.MatchSeries(name, tags)
 
-	// Generate mappers for each id.
-	r.mappers = make([]*mapper, len(seriesIDs))
-	for i, seriesID := range seriesIDs {
-		m := newMapper(e, seriesID, fieldID, typ)
-		m.min, m.max = e.min.UnixNano(), e.max.UnixNano()
-		m.interval = int64(e.interval)
-		m.key = append(make([]byte, 8), marshalStrings(p.DB.SeriesTagValues(seriesID, e.tags))...)
-		r.mappers[i] = m
-	}
-
-	// Set the appropriate reducer function.
-	switch strings.ToLower(c.Name) {
-	case "count":
-		r.fn = reduceSum
-		for _, m := range r.mappers {
-			m.fn = mapCount
+	// Generate mappers for each id and bucket group.
+	r.mappers = make([]*mapper, 0, len(seriesIDs)*len(groups))
+	for _, seriesID := range seriesIDs {
+		values := p.DB.SeriesTagValues(seriesID, e.tags)
+		for i, b := range e.buckets {
+			if b != nil {
+				values[i] = b.label(values[i])
+			}
 		}
-	case "sum":
-		r.fn = reduceSum
-		for _, m := range r.mappers {
-			m.fn = mapSum
+
+		for _, g := range groups {
+			a := values
+			if len(g.labels) > 0 {
+				a = make([]string, len(values))
+				copy(a, values)
+				for i, label := range g.labels {
+					a[i] = label
+				}
+			}
+
+			m := newMapper(e, seriesID, fieldID, typ)
+			m.min, m.max = e.min.UnixNano(), e.max.UnixNano()
+			m.interval = int64(e.interval)
+			m.key = append(make([]byte, 8), marshalStrings(a)...)
+			m.cast = cast
+			m.cond = g.cond
+			r.mappers = append(r.mappers, m)
 		}
-	default:
-		return nil, fmt.Errorf("function not found: %q", c.Name)
 	}
 
 	return r, nil
@@ -284,7 +663,11 @@ func (p *Planner) extractBinaryExprTags(name string, expr Expr, ref *VarRef, val
 	}
 
 	// Extract the key and remove the measurement prefix.
+	// Comparisons of string fields are filtered by the iterators instead.
 	key := strings.TrimPrefix(ref.Val, name+".")
+	if fieldID, _ := p.DB.Field(name, key); fieldID != 0 {
+		return expr, nil
+	}
 
 	// If tag is already filtered then return error.
 	if _, ok := tags[key]; ok {
@@ -298,6 +681,82 @@ func (p *Planner) extractBinaryExprTags(name string, expr Expr, ref *VarRef, val
 	return nil, nil
 }
 
+// fieldCondition returns the comparisons in a conditional that only reference
+// fields of a measurement, with the measurement prefix removed from the field
+// names. Comparisons of fields with time are kept and the time is evaluated as
+// the point's timestamp. Comparisons of tags and of time alone are left out.
+// Returns nil if there are no field comparisons.
+func (p *Planner) fieldCondition(name string, expr Expr) (Expr, error) {
+	switch expr := expr.(type) {
+	case *BinaryExpr:
+		// Keep either side of a logical expression that compares fields.
+		if expr.Op == AND || expr.Op == OR {
+			lhs, err := p.fieldCondition(name, expr.LHS)
+			if err != nil {
+				return nil, err
+			}
+			rhs, err := p.fieldCondition(name, expr.RHS)
+			if err != nil {
+				return nil, err
+			} else if lhs == nil {
+				return rhs, nil
+			} else if rhs == nil {
+				return lhs, nil
+			}
+			return &BinaryExpr{Op: expr.Op, LHS: lhs, RHS: rhs}, nil
+		}
+
+		// Otherwise every variable reference must be a field or time.
+		var refs []*VarRef
+		WalkFunc(expr, func(n Node) {
+			if ref, ok := n.(*VarRef); ok && !isTimeRef(ref) {
+				refs = append(refs, ref)
+			}
+		})
+		if len(refs) == 0 {
+			return nil, nil
+		}
+		types := make(map[string]DataType)
+		for _, ref := range refs {
+			fname := strings.TrimPrefix(ref.Val, name+".")
+			fieldID, typ := p.DB.Field(name, fname)
+			if fieldID == 0 {
+				return nil, nil
+			}
+			types[ref.Val] = typ
+		}
+
+		// Regular expressions can only match string fields.
+		if expr.Op == EQREGEX || expr.Op == NEQREGEX {
+			if ref, ok := expr.LHS.(*VarRef); !ok || types[ref.Val] != String {
+				return nil, fmt.Errorf("%s requires a string field: %s", expr.Op, expr)
+			}
+		}
+
+		return RewriteFunc(CloneExpr(expr), func(n Node) Node {
+			if ref, ok := n.(*VarRef); ok && isTimeRef(ref) {
+				return &VarRef{Val: "time"}
+			} else if ok {
+				return &VarRef{Val: strings.TrimPrefix(ref.Val, name+".")}
+			}
+			return n
+		}).(Expr), nil
+
+	case *ParenExpr:
+		e, err := p.fieldCondition(name, expr.Expr)
+		if e == nil || err != nil {
+			return nil, err
+		}
+		return &ParenExpr{Expr: e}, nil
+
+	default:
+		return nil, nil
+	}
+}
+
+// isTimeRef returns true if a variable reference is to the point's time.
+func isTimeRef(ref *VarRef) bool { return strings.ToLower(ref.Val) == "time" }
+
 // Executor represents the implementation of Executor.
 // It executes all reducers and combines their result into a row.
 type Executor struct {
@@ -307,6 +766,80 @@ type Executor struct {
 	min, max   time.Time        // time range
 	interval   time.Duration    // group by duration
 	tags       []string         // group by tag keys
+	buckets    []*buckets       // buckets of each group by key, if any
+	stats      stageStats       // execution statistics
+
+	maxPoints int64           // maximum points scanned, zero is unlimited
+	scanned   int64           // points scanned so far, updated atomically
+	ctx       context.Context // halts execution once done
+
+	mu   sync.Mutex
+	err  error           // error that halted execution
+	read map[string]bool // tagsets that points were read for, if bucketed
+}
+
+// Err returns the error that halted execution, if any.
+// It should be checked once all rows have been read.
+func (e *Executor) Err() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
+}
+
+// setErr sets the execution error if one is not already set.
+func (e *Executor) setErr(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+// markRead records that points were read for a tagset.
+func (e *Executor) markRead(tagset string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.read == nil {
+		e.read = make(map[string]bool)
+	}
+	e.read[tagset] = true
+}
+
+// wasRead returns true if points were read for a tagset.
+func (e *Executor) wasRead(tagset string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.read[tagset]
+}
+
+// scan records that a point has been read.
+// Returns false once the point limit has been exceeded.
+func (e *Executor) scan() bool {
+	if e.maxPoints == 0 {
+		return true
+	} else if atomic.AddInt64(&e.scanned, 1) > e.maxPoints {
+		e.setErr(ErrPointLimitExceeded)
+		return false
+	}
+	return true
+}
+
+// TimeRange returns the time range the executor will read from.
+func (e *Executor) TimeRange() (min, max time.Time) { return e.min, e.max }
+
+// Interval returns the group by time interval, if any.
+func (e *Executor) Interval() time.Duration { return e.interval }
+
+// Plan returns the execution plan as a tree of stages.
+// If the executor has been run then each stage includes the number of values
+// it emitted and the time it spent running.
+func (e *Executor) Plan() *PlanNode {
+	n := &PlanNode{Name: "select", Detail: e.stmt.String()}
+	n.Rows, n.Duration = e.stats.get()
+	for _, p := range e.processors {
+		n.Children = append(n.Children, p.plan())
+	}
+	return n
 }
 
 // Execute begins execution of the query and returns a channel to receive rows.
@@ -326,6 +859,7 @@ func (e *Executor) Execute() (<-chan *Row, error) {
 // execute runs in a separate separate goroutine and streams data from processors.
 func (e *Executor) execute(out chan *Row) {
 	// TODO: Support multi-value rows.
+	start := time.Now()
 
 	// Initialize map of rows by encoded tagset.
 	rows := make(map[string]*Row)
@@ -357,14 +891,26 @@ loop:
 
 	// Normalize rows and values.
 	// This converts the timestamps from nanoseconds to microseconds.
+	// Buckets that no points were read for are left out.
 	a := make(Rows, 0, len(rows))
-	for _, row := range rows {
+	for tagset, row := range rows {
+		if e.buckets != nil && !e.wasRead(tagset) {
+			continue
+		}
 		for _, values := range row.Values {
 			values[0] = values[0].(int64) / int64(time.Microsecond)
 		}
 		a = append(a, row)
 	}
-	sort.Sort(a)
+	if e.buckets != nil {
+		sort.Sort(bucketRows{rows: a, tags: e.tags, buckets: e.buckets})
+	} else {
+		sort.Sort(a)
+	}
+
+	// Record statistics before the rows are sent so they are available as
+	// soon as the caller has read the last row.
+	e.stats.set(len(a), time.Since(start))
 
 	// Send rows to the channel.
 	for _, row := range a {
@@ -415,61 +961,291 @@ func (e *Executor) createRowValuesIfNotExists(rows map[string]*Row, name string,
 	return row.Values[len(row.Values)-1]
 }
 
-// dimensionKeys returns a list of tag key names for the dimensions.
-// Each dimension must be a VarRef.
-func dimensionKeys(dimensions Dimensions) (a []string) {
+// dimensionKeys returns a list of tag key names for the dimensions and the
+// buckets that their values are grouped into. Each dimension must be a
+// VarRef or a call to bucket(). Buckets are nil for a VarRef.
+func dimensionKeys(dimensions Dimensions) (keys []string, a []*buckets, err error) {
+	var bucketed bool
 	for _, d := range dimensions {
-		a = append(a, d.Expr.(*VarRef).Val)
+		switch expr := d.Expr.(type) {
+		case *VarRef:
+			keys, a = append(keys, expr.Val), append(a, nil)
+		case *Call:
+			if strings.ToLower(expr.Name) != "bucket" {
+				return nil, nil, fmt.Errorf("invalid dimension: %s", d)
+			}
+			key, b, err := parseBuckets(expr)
+			if err != nil {
+				return nil, nil, err
+			}
+			keys, a, bucketed = append(keys, key), append(a, b), true
+		default:
+			return nil, nil, fmt.Errorf("invalid dimension: %s", d)
+		}
 	}
-	return
+	if !bucketed {
+		a = nil
+	}
+	return keys, a, nil
 }
 
-// mapper represents an object for processing iterators.
-type mapper struct {
-	executor *Executor // parent executor
-	seriesID uint32    // series id
-	fieldID  uint8     // field id
-	typ      DataType  // field data type
-	itr      Iterator  // series iterator
-	min, max int64     // time range
-	interval int64     // group by interval
-	key      []byte    // encoded timestamp + dimensional values
-	fn       mapFunc   // map function
+// buckets represents labeled ranges that the numeric values of a tag or
+// field are grouped into. Values below the first bound are in the first
+// bucket, values from bounds[i-1] up to bounds[i] are in bucket i and the
+// rest are in the last bucket.
+type buckets struct {
+	bounds []float64
+	labels []string
+}
 
-	c    chan map[string]interface{}
-	done chan chan struct{}
+// parseBuckets parses a "bucket(key, bound, ...)" dimension. The bounds are
+// ascending numbers and can be followed by a label for each bucket. Buckets
+// are labeled with their range by default, such as "<100", "100-500" and
+// ">=500". Returns the key and its buckets.
+func parseBuckets(call *Call) (string, *buckets, error) {
+	if len(call.Args) < 2 {
+		return "", nil, fmt.Errorf("expected a tag or field and at least one bound for %s()", call.Name)
+	}
+	ref, ok := call.Args[0].(*VarRef)
+	if !ok {
+		return "", nil, fmt.Errorf("expected a tag or field for %s()", call.Name)
+	}
+
+	// Read the bounds and then the labels, if any.
+	b := &buckets{}
+	args := call.Args[1:]
+	for len(args) > 0 {
+		lit, ok := args[0].(*NumberLiteral)
+		if !ok {
+			break
+		} else if n := len(b.bounds); n > 0 && lit.Val <= b.bounds[n-1] {
+			return "", nil, fmt.Errorf("expected ascending bounds for %s()", call.Name)
+		}
+		b.bounds, args = append(b.bounds, lit.Val), args[1:]
+	}
+	if len(b.bounds) == 0 {
+		return "", nil, fmt.Errorf("expected at least one bound for %s()", call.Name)
+	}
+	for _, arg := range args {
+		lit, ok := arg.(*StringLiteral)
+		if !ok {
+			return "", nil, fmt.Errorf("expected number bounds followed by string labels for %s()", call.Name)
+		}
+		b.labels = append(b.labels, lit.Val)
+	}
+
+	// Label each bucket with its range if labels aren't set.
+	if len(b.labels) == 0 {
+		format := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+		b.labels = append(b.labels, "<"+format(b.bounds[0]))
+		for i := 1; i < len(b.bounds); i++ {
+			b.labels = append(b.labels, format(b.bounds[i-1])+"-"+format(b.bounds[i]))
+		}
+		b.labels = append(b.labels, ">="+format(b.bounds[len(b.bounds)-1]))
+	} else if len(b.labels) != len(b.bounds)+1 {
+		return "", nil, fmt.Errorf("expected %d labels for %s(), got %d", len(b.bounds)+1, call.Name, len(b.labels))
+	}
+
+	return ref.Val, b, nil
 }
 
-// newMapper returns a new instance of mapper.
-func newMapper(e *Executor, seriesID uint32, fieldID uint8, typ DataType) *mapper {
-	return &mapper{
-		executor: e,
-		seriesID: seriesID,
-		fieldID:  fieldID,
-		typ:      typ,
-		c:        make(chan map[string]interface{}, 0),
-		done:     make(chan chan struct{}, 0),
+// label returns the label of the bucket that a tag value is in. Returns a
+// blank label if the value is not a number.
+func (b *buckets) label(s string) string {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) {
+		return ""
 	}
+	return b.labels[sort.Search(len(b.bounds), func(i int) bool { return b.bounds[i] > v })]
 }
 
-// start begins processing the iterator.
-func (m *mapper) start() {
-	m.itr = m.executor.db.CreateIterator(m.seriesID, m.fieldID, m.typ,
-		m.executor.min, m.executor.max, m.executor.interval)
-	go m.run()
+// index returns the position of a bucket label. Blank labels are after
+// every bucket.
+func (b *buckets) index(label string) int {
+	for i, l := range b.labels {
+		if l == label {
+			return i
+		}
+	}
+	return len(b.labels)
 }
 
-// stop stops the mapper.
-func (m *mapper) stop() { syncClose(m.done) }
+// bucketRows sorts rows by the order of their buckets and then by the values
+// of their other group by tags.
+type bucketRows struct {
+	rows    Rows
+	tags    []string
+	buckets []*buckets
+}
+
+func (a bucketRows) Len() int      { return len(a.rows) }
+func (a bucketRows) Swap(i, j int) { a.rows[i], a.rows[j] = a.rows[j], a.rows[i] }
+func (a bucketRows) Less(i, j int) bool {
+	if a.rows[i].Name != a.rows[j].Name {
+		return a.rows[i].Name < a.rows[j].Name
+	}
+	for k, key := range a.tags {
+		vi, vj := a.rows[i].Tags[key], a.rows[j].Tags[key]
+		if vi == vj {
+			continue
+		} else if b := a.buckets[k]; b != nil {
+			return b.index(vi) < b.index(vj)
+		}
+		return vi < vj
+	}
+	return false
+}
+
+// cond returns a conditional that matches points whose field value is in
+// bucket i.
+func (b *buckets) cond(field string, i int) Expr {
+	var lower, upper Expr
+	if i > 0 {
+		lower = &BinaryExpr{Op: GTE, LHS: &VarRef{Val: field}, RHS: &NumberLiteral{Val: b.bounds[i-1]}}
+	}
+	if i < len(b.bounds) {
+		upper = &BinaryExpr{Op: LT, LHS: &VarRef{Val: field}, RHS: &NumberLiteral{Val: b.bounds[i]}}
+	}
+
+	if lower == nil {
+		return upper
+	} else if upper == nil {
+		return lower
+	}
+	return &BinaryExpr{Op: AND, LHS: lower, RHS: upper}
+}
+
+// bucketGroup represents the points of a series that are in one bucket of
+// each field the statement is grouped by.
+type bucketGroup struct {
+	labels map[int]string // bucket label by dimension index
+	cond   Expr
+}
+
+// bucketGroups returns a group for each combination of buckets of the fields
+// of a measurement that the statement is grouped by. cond is combined with
+// the conditional of each group. Returns a single group if no fields are
+// bucketed.
+func (p *Planner) bucketGroups(e *Executor, name string, cond Expr) ([]*bucketGroup, error) {
+	groups := []*bucketGroup{{cond: cond}}
+	for i, b := range e.buckets {
+		if b == nil {
+			continue
+		}
+
+		// Tags are bucketed with the tag values of each series.
+		fieldID, typ := p.DB.Field(name, e.tags[i])
+		if fieldID == 0 {
+			continue
+		} else if typ != Number && typ != Integer {
+			return nil, fmt.Errorf("bucket() requires a numeric field: %s", e.tags[i])
+		}
+
+		// Split each group into a group for every bucket of the field.
+		var other []*bucketGroup
+		for _, g := range groups {
+			for j, label := range b.labels {
+				labels := make(map[int]string, len(g.labels)+1)
+				for k, v := range g.labels {
+					labels[k] = v
+				}
+				labels[i] = label
+
+				c := b.cond(e.tags[i], j)
+				if g.cond != nil {
+					c = &BinaryExpr{Op: AND, LHS: g.cond, RHS: c}
+				}
+				other = append(other, &bucketGroup{labels: labels, cond: c})
+			}
+		}
+		groups = other
+	}
+	return groups, nil
+}
+
+// mapper represents an object for processing iterators.
+type mapper struct {
+	executor *Executor // parent executor
+	seriesID uint32    // series id
+	fieldID  uint8     // field id
+	typ      DataType  // field data type
+	itr      Iterator  // series iterator
+	min, max int64     // time range
+	interval int64     // group by interval
+	key      []byte    // encoded timestamp + dimensional values
+	cast     string    // type to cast values to, if set
+	cond     Expr      // field conditional, if set
+	fn       mapFunc   // map function
+	n        int       // number of values emitted
+	limit    int       // maximum number of rows, if set
+	rows     int       // number of distinct timestamps emitted
+	last     int64     // last timestamp emitted
+	stats    stageStats
+
+	c    chan map[string]interface{}
+	done chan chan struct{}
+}
+
+// newMapper returns a new instance of mapper.
+func newMapper(e *Executor, seriesID uint32, fieldID uint8, typ DataType) *mapper {
+	return &mapper{
+		executor: e,
+		seriesID: seriesID,
+		fieldID:  fieldID,
+		typ:      typ,
+		c:        make(chan map[string]interface{}, 0),
+		done:     make(chan chan struct{}, 0),
+	}
+}
+
+// start begins processing the iterator.
+func (m *mapper) start() {
+	m.itr = m.executor.db.CreateIterator(m.seriesID, m.fieldID, m.typ,
+		m.executor.min, m.executor.max, m.executor.interval, m.cond)
+	if m.executor.buckets != nil {
+		m.itr = &readIterator{Iterator: m.itr, mapper: m}
+	}
+	if m.executor.maxPoints > 0 {
+		m.itr = &limitIterator{Iterator: m.itr, executor: m.executor}
+	}
+	if done := m.executor.ctx.Done(); done != nil {
+		m.itr = &contextIterator{Iterator: m.itr, executor: m.executor, done: done}
+	}
+	if m.cast != "" {
+		m.itr = &castIterator{Iterator: m.itr, typ: m.cast}
+	}
+	if m.limit > 0 {
+		m.itr = &rowLimitIterator{Iterator: m.itr, mapper: m}
+	}
+	go m.run()
+}
+
+// stop stops the mapper.
+func (m *mapper) stop() { syncClose(m.done) }
 
 // C returns the streaming data channel.
 func (m *mapper) C() <-chan map[string]interface{} { return m.c }
 
+// plan returns the plan node for the mapper.
+func (m *mapper) plan() *PlanNode {
+	n := &PlanNode{Name: "iterator", Detail: fmt.Sprintf("series=%d field=%d type=%s", m.seriesID, m.fieldID, m.typ)}
+	if m.cast != "" {
+		n.Detail += " cast=" + m.cast
+	}
+	if m.cond != nil {
+		n.Detail += " filter=" + m.cond.String()
+	}
+	n.Rows, n.Duration = m.stats.get()
+	return n
+}
+
 // run executes the map function against the iterator.
 func (m *mapper) run() {
+	start := time.Now()
 	for m.itr.NextIterval() {
 		m.fn(m.itr, m)
 	}
+	m.stats.set(m.n, time.Since(start))
 	close(m.c)
 }
 
@@ -479,28 +1255,626 @@ func (m *mapper) emit(key int64, value interface{}) {
 	binary.BigEndian.PutUint64(m.key, uint64(key))
 
 	// OPTIMIZE: Collect emit calls and flush all at once.
+	m.n++
+	if m.rows == 0 || key != m.last {
+		m.rows, m.last = m.rows+1, key
+	}
 	m.c <- map[string]interface{}{string(m.key): value}
 }
 
+// full returns true once the mapper has emitted more rows than its limit.
+// One extra row is read so the caller knows the result was truncated.
+func (m *mapper) full() bool { return m.limit > 0 && m.rows > m.limit }
+
+// rowLimitIterator wraps an iterator and ends it once its mapper has
+// emitted more rows than its limit.
+type rowLimitIterator struct {
+	Iterator
+	mapper *mapper
+}
+
+// NextIterval moves to the next interval unless the mapper is full.
+func (itr *rowLimitIterator) NextIterval() bool {
+	if itr.mapper.full() {
+		return false
+	}
+	return itr.Iterator.NextIterval()
+}
+
+// Next returns the next point from the underlying iterator.
+// Returns a zero key once the mapper is full.
+func (itr *rowLimitIterator) Next() (key int64, value interface{}) {
+	if itr.mapper.full() {
+		return 0, nil
+	}
+	return itr.Iterator.Next()
+}
+
+// readIterator wraps an iterator and records with the executor that points
+// were read for its mapper's tagset. It is recorded before the first point's
+// value is emitted so the executor sees it before the row is built.
+type readIterator struct {
+	Iterator
+	mapper *mapper
+	read   bool
+}
+
+// Next returns the next point from the underlying iterator.
+func (itr *readIterator) Next() (key int64, value interface{}) {
+	key, value = itr.Iterator.Next()
+	if key != 0 && !itr.read {
+		itr.read = true
+		itr.mapper.executor.markRead(string(itr.mapper.key[8:]))
+	}
+	return
+}
+
+// limitIterator wraps an iterator and ends it once the executor has
+// scanned more points than it allows.
+type limitIterator struct {
+	Iterator
+	executor *Executor
+}
+
+// Next returns the next point from the underlying iterator.
+// Returns a zero key once the point limit has been exceeded.
+func (itr *limitIterator) Next() (key int64, value interface{}) {
+	key, value = itr.Iterator.Next()
+	if key != 0 && !itr.executor.scan() {
+		return 0, nil
+	}
+	return
+}
+
+// contextIterator wraps an iterator and ends it once the executor's
+// context is done.
+type contextIterator struct {
+	Iterator
+	executor *Executor
+	done     <-chan struct{}
+}
+
+// Next returns the next point from the underlying iterator.
+// Returns a zero key once the context is done.
+func (itr *contextIterator) Next() (key int64, value interface{}) {
+	select {
+	case <-itr.done:
+		itr.executor.setErr(itr.executor.ctx.Err())
+		return 0, nil
+	default:
+	}
+	return itr.Iterator.Next()
+}
+
+// castIterator wraps an iterator and casts each value to a data type.
+// Values that cannot be cast are returned as nil.
+type castIterator struct {
+	Iterator
+	typ string
+}
+
+// Next returns the next point from the underlying iterator with its value cast.
+func (itr *castIterator) Next() (key int64, value interface{}) {
+	key, value = itr.Iterator.Next()
+	if key == 0 {
+		return 0, nil
+	}
+	return key, castValue(value, itr.typ)
+}
+
+// castValue converts a value to the given data type ("integer", "float",
+// "string" or "boolean"). Returns nil if the value cannot be converted.
+//
+// Floats are truncated toward zero when cast to an integer and numbers are
+// true when cast to a boolean if they are non-zero. Strings are parsed.
+func castValue(v interface{}, typ string) interface{} {
+	switch typ {
+	case "integer":
+		switch v := v.(type) {
+		case int64:
+			return v
+		case uint64:
+			if v <= math.MaxInt64 {
+				return int64(v)
+			}
+		case float64:
+			return floatToInteger(v)
+		case string:
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return i
+			} else if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return floatToInteger(f)
+			}
+		case bool:
+			if v {
+				return int64(1)
+			}
+			return int64(0)
+		}
+
+	case "float":
+		switch v := v.(type) {
+		case float64:
+			return v
+		case int64:
+			return float64(v)
+		case uint64:
+			return float64(v)
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+				return f
+			}
+		case bool:
+			if v {
+				return float64(1)
+			}
+			return float64(0)
+		}
+
+	case "string":
+		switch v := v.(type) {
+		case string:
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		case int64:
+			return strconv.FormatInt(v, 10)
+		case uint64:
+			return strconv.FormatUint(v, 10)
+		case bool:
+			return strconv.FormatBool(v)
+		}
+
+	case "boolean":
+		switch v := v.(type) {
+		case bool:
+			return v
+		case float64:
+			return v != 0
+		case int64:
+			return v != 0
+		case uint64:
+			return v != 0
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b
+			}
+		}
+	}
+	return nil
+}
+
+// floatToInteger truncates a float to an integer.
+// Returns nil if the float is not finite or is out of range.
+func floatToInteger(f float64) interface{} {
+	if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return nil
+	}
+	return int64(f)
+}
+
+// asFloat returns a numeric value as a float.
+func asFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f, true
+	}
+	return 0, false
+}
+
 // mapFunc represents a function used for mapping iterators.
 type mapFunc func(Iterator, *mapper)
 
-// mapCount computes the number of values in an iterator.
+// mapCount computes the number of non-null values in an iterator.
 func mapCount(itr Iterator, m *mapper) {
-	n := 0
-	for k, _ := itr.Next(); k != 0; k, _ = itr.Next() {
-		n++
+	var n int64
+	for k, v := itr.Next(); k != 0; k, v = itr.Next() {
+		if v != nil {
+			n++
+		}
 	}
-	m.emit(itr.Time(), float64(n))
+	m.emit(itr.Time(), n)
 }
 
 // mapSum computes the summation of values in an iterator.
+// Non-numeric values are ignored.
 func mapSum(itr Iterator, m *mapper) {
-	n := float64(0)
+	v := &sumValue{}
+	for k, value := itr.Next(); k != 0; k, value = itr.Next() {
+		v.add(value)
+	}
+	m.emit(itr.Time(), v)
+}
+
+// mapMean computes the sum and count of numeric values in an iterator.
+// Non-numeric values are ignored.
+func mapMean(itr Iterator, m *mapper) {
+	v := &meanValue{}
+	for k, value := itr.Next(); k != 0; k, value = itr.Next() {
+		if v.add(value) {
+			v.count++
+		}
+	}
+	m.emit(itr.Time(), v)
+}
+
+// sumValue represents the partial sum of the values in a single series.
+// Integers are summed separately from floats so they don't lose precision.
+type sumValue struct {
+	float   float64
+	integer exactSum
+	floats  bool // true if any floats were added
+}
+
+// add adds a numeric value to the sum. Returns false for other values.
+func (s *sumValue) add(v interface{}) bool {
+	switch v := v.(type) {
+	case float64:
+		s.float += v
+		s.floats = true
+	case int64:
+		s.integer.addInt(v)
+	case uint64:
+		s.integer.addUint(v)
+	default:
+		return false
+	}
+	return true
+}
+
+// merge adds another partial sum to the sum.
+func (s *sumValue) merge(other *sumValue) {
+	s.float += other.float
+	s.floats = s.floats || other.floats
+	s.integer.merge(&other.integer)
+}
+
+// value returns the sum. Sums that include floats are floats.
+func (s *sumValue) value() interface{} {
+	if s.floats {
+		f, _ := asFloat(s.integer.value())
+		return s.float + f
+	} else if !s.integer.set {
+		return float64(0)
+	}
+	return s.integer.value()
+}
+
+// meanValue represents the partial mean of the values in a single series.
+type meanValue struct {
+	sumValue
+	count int64
+}
+
+// exactSum adds 64-bit integers without overflowing. The sum is kept in an
+// int64 until it overflows and then in an arbitrary precision integer.
+type exactSum struct {
+	n   int64
+	big *big.Int
+	set bool // true if any integers were added
+}
+
+// addInt adds a signed integer to the sum.
+func (s *exactSum) addInt(v int64) {
+	s.set = true
+	if s.big != nil {
+		s.big.Add(s.big, big.NewInt(v))
+		return
+	}
+
+	n := s.n + v
+	if (v > 0 && n < s.n) || (v < 0 && n > s.n) {
+		s.big = new(big.Int).Add(big.NewInt(s.n), big.NewInt(v))
+		return
+	}
+	s.n = n
+}
+
+// addUint adds an unsigned integer to the sum.
+func (s *exactSum) addUint(v uint64) {
+	if v <= math.MaxInt64 {
+		s.addInt(int64(v))
+		return
+	}
+	s.set = true
+	if s.big == nil {
+		s.big = big.NewInt(s.n)
+	}
+	s.big.Add(s.big, new(big.Int).SetUint64(v))
+}
+
+// merge adds another sum to the sum.
+func (s *exactSum) merge(other *exactSum) {
+	if !other.set {
+		return
+	} else if other.big == nil {
+		s.addInt(other.n)
+		return
+	}
+	s.set = true
+	if s.big == nil {
+		s.big = big.NewInt(s.n)
+	}
+	s.big.Add(s.big, other.big)
+}
+
+// value returns the sum as an int64 or, if it is out of range, as a uint64
+// or an arbitrary precision integer.
+func (s *exactSum) value() interface{} {
+	if s.big == nil {
+		return s.n
+	}
+	return bigIntValue(s.big)
+}
+
+// bigIntValue returns n as an int64 or, if it is out of range, as a uint64
+// or a copy of n.
+func bigIntValue(n *big.Int) interface{} {
+	if n.IsInt64() {
+		return n.Int64()
+	} else if n.IsUint64() {
+		return n.Uint64()
+	}
+	return new(big.Int).Set(n)
+}
+
+// bigInt returns the sum as an arbitrary precision integer.
+func (s *exactSum) bigInt() *big.Int {
+	if s.big == nil {
+		return big.NewInt(s.n)
+	}
+	return s.big
+}
+
+// mapHistogram merges the histogram values in an iterator.
+// Emits nil if there are no histograms.
+func mapHistogram(itr Iterator, m *mapper) {
+	var h *HistogramValue
 	for k, v := itr.Next(); k != 0; k, v = itr.Next() {
-		n += v.(float64)
+		if other, ok := v.(*HistogramValue); !ok {
+			continue
+		} else if h == nil {
+			h = other
+		} else {
+			h = MergeHistograms(h, other)
+		}
+	}
+	m.emit(itr.Time(), h)
+}
+
+// mapDistinct emits the set of distinct non-null values in an iterator.
+func mapDistinct(itr Iterator, m *mapper) {
+	set := make(map[interface{}]struct{})
+	for k, v := itr.Next(); k != 0; k, v = itr.Next() {
+		if v != nil {
+			set[v] = struct{}{}
+		}
+	}
+	m.emit(itr.Time(), set)
+}
+
+// mapCountDistinct emits the distinct non-null values in an iterator, or a
+// sketch of them if there are too many to count exactly.
+func mapCountDistinct(itr Iterator, m *mapper) {
+	d := &distinctCount{}
+	for k, v := itr.Next(); k != 0; k, v = itr.Next() {
+		if v != nil {
+			d.add(v)
+		}
+	}
+	m.emit(itr.Time(), d)
+}
+
+// distinctCountThreshold is the number of distinct values that are counted
+// exactly. Larger sets are replaced by a sketch and counted approximately.
+const distinctCountThreshold = 10000
+
+// distinctCount represents the partial distinct values of a count(distinct()).
+// Once there are more than distinctCountThreshold values they are replaced by
+// a sketch.
+type distinctCount struct {
+	set    map[interface{}]struct{}
+	sketch *hyperLogLog
+}
+
+// add adds a value to the set or sketch.
+func (d *distinctCount) add(v interface{}) {
+	if d.sketch != nil {
+		d.sketch.add(v)
+		return
+	} else if d.set == nil {
+		d.set = make(map[interface{}]struct{})
+	}
+	d.set[v] = struct{}{}
+	if len(d.set) > distinctCountThreshold {
+		d.toSketch()
+	}
+}
+
+// merge adds the values of another partial result. Sets become sketches
+// when they are merged with a sketch.
+func (d *distinctCount) merge(other *distinctCount) {
+	if other.sketch == nil {
+		for v := range other.set {
+			d.add(v)
+		}
+		return
+	} else if d.sketch == nil {
+		d.toSketch()
+	}
+	d.sketch.merge(other.sketch)
+}
+
+// toSketch replaces the set with a sketch of its values.
+func (d *distinctCount) toSketch() {
+	d.sketch = newHyperLogLog()
+	for v := range d.set {
+		d.sketch.add(v)
+	}
+	d.set = nil
+}
+
+// count returns the number of distinct values. The number is approximate
+// once the values are sketched.
+func (d *distinctCount) count() int64 {
+	if d.sketch != nil {
+		return d.sketch.count()
+	}
+	return int64(len(d.set))
+}
+
+// selectorValue represents a point chosen by a selector function.
+type selectorValue struct {
+	timestamp int64
+	value     interface{}
+}
+
+// selectFunc returns true if other should be selected instead of v.
+type selectFunc func(v, other *selectorValue) bool
+
+// selectors holds the select function of each selector function.
+var selectors = map[string]selectFunc{
+	"first": func(v, other *selectorValue) bool { return other.timestamp < v.timestamp },
+	"last":  func(v, other *selectorValue) bool { return other.timestamp > v.timestamp },
+	"min": func(v, other *selectorValue) bool {
+		cmp, _ := compareValues(other.value, v.value)
+		return cmp < 0 || (cmp == 0 && other.timestamp < v.timestamp)
+	},
+	"max": func(v, other *selectorValue) bool {
+		cmp, _ := compareValues(other.value, v.value)
+		return cmp > 0 || (cmp == 0 && other.timestamp < v.timestamp)
+	},
+}
+
+// mapSelector returns a map function that emits the point of an iterator
+// chosen by fn, or nil if there are no points. If numeric is set then only
+// numeric values are selected.
+func mapSelector(fn selectFunc, numeric bool) mapFunc {
+	return func(itr Iterator, m *mapper) {
+		var sel *selectorValue
+		for k, v := itr.Next(); k != 0; k, v = itr.Next() {
+			if v == nil {
+				continue
+			} else if _, ok := asFloat(v); numeric && !ok {
+				continue
+			}
+			if other := (&selectorValue{timestamp: k, value: v}); sel == nil || fn(sel, other) {
+				sel = other
+			}
+		}
+		if sel == nil {
+			m.emit(itr.Time(), nil)
+			return
+		}
+		m.emit(itr.Time(), sel)
+	}
+}
+
+// sampleValue represents a point in a sample with its random priority.
+type sampleValue struct {
+	timestamp int64
+	value     interface{}
+	priority  float64
+}
+
+// sampleValues represents a list of sampled points sortable by priority.
+type sampleValues []sampleValue
+
+func (a sampleValues) Len() int           { return len(a) }
+func (a sampleValues) Less(i, j int) bool { return a[i].priority < a[j].priority }
+func (a sampleValues) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// sampleValuesByTime represents a list of sampled points sortable by time.
+type sampleValuesByTime struct{ sampleValues }
+
+func (a sampleValuesByTime) Less(i, j int) bool {
+	return a.sampleValues[i].timestamp < a.sampleValues[j].timestamp
+}
+
+// mapSample returns a map function that emits a random sample of up to n
+// points of an iterator. Each point is given a random priority and the points
+// with the lowest priorities are kept, so samples can be merged.
+func mapSample(n int) mapFunc {
+	return func(itr Iterator, m *mapper) {
+		a := make(sampleValues, 0, n)
+		for k, v := itr.Next(); k != 0; k, v = itr.Next() {
+			if v == nil {
+				continue
+			}
+			p := rand.Float64()
+			if len(a) < n {
+				a = append(a, sampleValue{timestamp: k, value: v, priority: p})
+				continue
+			}
+
+			// Replace the point with the highest priority if it's higher.
+			max := 0
+			for i := range a {
+				if a[i].priority > a[max].priority {
+					max = i
+				}
+			}
+			if p < a[max].priority {
+				a[max] = sampleValue{timestamp: k, value: v, priority: p}
+			}
+		}
+		m.emit(itr.Time(), a)
+	}
+}
+
+// mapRaw emits every value in an iterator with its own timestamp.
+func mapRaw(itr Iterator, m *mapper) {
+	for k, v := itr.Next(); k != 0; k, v = itr.Next() {
+		m.emit(k, v)
+	}
+}
+
+// mapElapsed returns a map function that emits the time since the previous
+// point of the series, in units, for every point but the first.
+func mapElapsed(unit time.Duration) mapFunc {
+	var prev int64
+	return func(itr Iterator, m *mapper) {
+		for k, _ := itr.Next(); k != 0; k, _ = itr.Next() {
+			if prev != 0 {
+				m.emit(k, (k-prev)/int64(unit))
+			}
+			prev = k
+		}
+	}
+}
+
+// mapIntegral returns a map function that computes the area under the
+// numeric values in an iterator, in value-units, using the trapezoidal rule.
+// Only the points within an interval are used. Emits nil if there are no
+// numeric values.
+func mapIntegral(unit time.Duration) mapFunc {
+	return func(itr Iterator, m *mapper) {
+		var area float64
+		var prevTime int64
+		var prev float64
+		var ok bool
+		for k, v := itr.Next(); k != 0; k, v = itr.Next() {
+			f, isNumber := asFloat(v)
+			if !isNumber {
+				continue
+			} else if ok {
+				area += (prev + f) / 2 * float64(k-prevTime) / float64(unit)
+			}
+			prevTime, prev, ok = k, f, true
+		}
+		if !ok {
+			m.emit(itr.Time(), nil)
+			return
+		}
+		m.emit(itr.Time(), area)
 	}
-	m.emit(itr.Time(), n)
 }
 
 // processor represents an object for joining reducer output.
@@ -508,16 +1882,24 @@ type processor interface {
 	start()
 	stop()
 	name() string
+	plan() *PlanNode
 	C() <-chan map[string]interface{}
 }
 
 // reducer represents an object for processing mapper output.
 // Implements processor.
 type reducer struct {
-	executor *Executor        // parent executor
-	stmt     *SelectStatement // substatement
-	mappers  []*mapper        // child mappers
-	fn       reduceFunc       // reduce function
+	executor *Executor         // parent executor
+	stmt     *SelectStatement  // substatement
+	mappers  []*mapper         // child mappers
+	fn       reduceFunc        // reduce function
+	call     *Call             // function call being reduced
+	ref      *VarRef           // field being read
+	typ      DataType          // data type of the field being read
+	raw      bool              // if true, values are returned without reducing
+	tags     map[string]string // tag filters used to match series
+	n        int               // number of values emitted
+	stats    stageStats
 
 	c    chan map[string]interface{}
 	done chan chan struct{}
@@ -554,10 +1936,57 @@ func (r *reducer) C() <-chan map[string]interface{} { return r.c }
 // name returns the source name.
 func (r *reducer) name() string { return r.stmt.Source.(*Measurement).Name }
 
+// plan returns the plan node for the reducer and its mappers.
+func (r *reducer) plan() *PlanNode {
+	n := &PlanNode{Name: "reduce", Detail: r.ref.String()}
+	if r.raw {
+		n.Name = "raw"
+	}
+	if r.call != nil {
+		n.Detail = r.call.String()
+	}
+	n.Rows, n.Duration = r.stats.get()
+
+	// Describe how the series were looked up.
+	idx := &PlanNode{Name: "index", Detail: "measurement scan: " + r.name()}
+	if len(r.tags) > 0 {
+		keys := make([]string, 0, len(r.tags))
+		for k := range r.tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		var filters []string
+		for _, k := range keys {
+			filters = append(filters, fmt.Sprintf("%s=%s", k, Quote(r.tags[k])))
+		}
+		idx.Detail = "tag index: " + r.name() + " " + strings.Join(filters, ", ")
+	}
+	idx.Rows = len(r.mappers)
+	n.Children = append(n.Children, idx)
+
+	for _, m := range r.mappers {
+		n.Children = append(n.Children, m.plan())
+	}
+	return n
+}
+
 // run runs the reducer loop to read mapper output and reduce it.
 func (r *reducer) run() {
+	start := time.Now()
+	if r.raw {
+		r.runRaw()
+		r.stats.set(r.n, time.Since(start))
+		close(r.c)
+		return
+	}
 loop:
 	for {
+		// Exit immediately if there are no series to read from.
+		if len(r.mappers) == 0 {
+			break
+		}
+
 		// Combine all data from the mappers.
 		data := make(map[string][]interface{})
 		for _, m := range r.mappers {
@@ -577,31 +2006,254 @@ loop:
 	}
 
 	// Mark the channel as complete.
+	r.stats.set(r.n, time.Since(start))
 	close(r.c)
 }
 
+// runRaw reads all values from the mappers and emits them in time order.
+func (r *reducer) runRaw() {
+	var a rawValues
+	for _, m := range r.mappers {
+		for kv := range m.C() {
+			for k, v := range kv {
+				a = append(a, rawValue{key: k, value: v})
+			}
+		}
+	}
+	sort.Sort(a)
+
+	for _, v := range a {
+		r.emit(v.key, v.value)
+	}
+}
+
+// rawValue represents a single value emitted by a raw mapper.
+type rawValue struct {
+	key   string
+	value interface{}
+}
+
+// rawValues represents a list of raw values sortable by key.
+// Keys begin with the timestamp so they sort by time.
+type rawValues []rawValue
+
+func (a rawValues) Len() int           { return len(a) }
+func (a rawValues) Less(i, j int) bool { return a[i].key < a[j].key }
+func (a rawValues) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
 // emit sends a value to the reducer's output channel.
 func (r *reducer) emit(key string, value interface{}) {
+	r.n++
 	r.c <- map[string]interface{}{key: value}
 }
 
 // reduceFunc represents a function used for reducing mapper output.
 type reduceFunc func(string, []interface{}, *reducer)
 
+// reduceCount computes the total count of values for each key.
+func reduceCount(key string, values []interface{}, r *reducer) {
+	var n int64
+	for _, v := range values {
+		n += v.(int64)
+	}
+	r.emit(key, n)
+}
+
+// reduceCountDistinct computes the number of distinct values for each key.
+func reduceCountDistinct(key string, values []interface{}, r *reducer) {
+	var total distinctCount
+	for _, v := range values {
+		total.merge(v.(*distinctCount))
+	}
+	r.emit(key, total.count())
+}
+
+// reduceSelector returns a function that selects a point from the points
+// chosen by each mapper. If pointTime is set then the point is emitted with
+// its own timestamp instead of the interval's. Keys without any points are
+// reduced to nil.
+func reduceSelector(fn selectFunc, pointTime bool) reduceFunc {
+	return func(key string, values []interface{}, r *reducer) {
+		var sel *selectorValue
+		for _, v := range values {
+			if other, _ := v.(*selectorValue); other != nil && (sel == nil || fn(sel, other)) {
+				sel = other
+			}
+		}
+		if sel == nil {
+			r.emit(key, nil)
+			return
+		} else if pointTime {
+			key = withTimestamp(key, sel.timestamp)
+		}
+		r.emit(key, sel.value)
+	}
+}
+
+// reduceSample returns a function that merges the samples of each mapper and
+// emits up to n points, in time order, with their own timestamps.
+func reduceSample(n int) reduceFunc {
+	return func(key string, values []interface{}, r *reducer) {
+		var a sampleValues
+		for _, v := range values {
+			a = append(a, v.(sampleValues)...)
+		}
+		sort.Sort(a)
+		if len(a) > n {
+			a = a[:n]
+		}
+
+		sort.Sort(sampleValuesByTime{a})
+		for _, v := range a {
+			r.emit(withTimestamp(key, v.timestamp), v.value)
+		}
+	}
+}
+
+// withTimestamp returns a key with its timestamp replaced.
+func withTimestamp(key string, timestamp int64) string {
+	b := []byte(key)
+	binary.BigEndian.PutUint64(b, uint64(timestamp))
+	return string(b)
+}
+
 // reduceSum computes the sum of values for each key.
 func reduceSum(key string, values []interface{}, r *reducer) {
-	var n float64
+	var total sumValue
 	for _, v := range values {
-		n += v.(float64)
+		total.merge(v.(*sumValue))
 	}
-	r.emit(key, n)
+	r.emit(key, total.value())
 }
 
+// reduceMean computes the mean of values for each key. Integers are summed
+// exactly before they are divided and whole means of integers are integers. Keys without any values are reduced to nil.
+func reduceMean(key string, values []interface{}, r *reducer) {
+	var total meanValue
+	for _, v := range values {
+		v := v.(*meanValue)
+		total.merge(&v.sumValue)
+		total.count += v.count
+	}
+	if total.count == 0 {
+		r.emit(key, nil)
+		return
+	} else if !total.integer.set {
+		r.emit(key, total.float/float64(total.count))
+		return
+	} else if !total.floats {
+		// The mean of integers is returned exactly if it is a whole number.
+		// Otherwise it is rounded to the nearest float.
+		mean := new(big.Rat).SetFrac(total.integer.bigInt(), big.NewInt(total.count))
+		if mean.IsInt() {
+			r.emit(key, bigIntValue(mean.Num()))
+			return
+		}
+		f, _ := mean.Float64()
+		r.emit(key, f)
+		return
+	}
+
+	sum := new(big.Float).SetPrec(128).SetInt(total.integer.bigInt())
+	sum.Add(sum, big.NewFloat(total.float))
+	mean, _ := sum.Quo(sum, new(big.Float).SetInt64(total.count)).Float64()
+	r.emit(key, mean)
+}
+
+// reduceHistogram merges the histograms for each key.
+// Keys without any histograms are reduced to nil.
+func reduceHistogram(key string, values []interface{}, r *reducer) {
+	if h := mergeHistogramValues(values); h != nil {
+		r.emit(key, h)
+		return
+	}
+	r.emit(key, nil)
+}
+
+// reducePercentile returns a function that computes the approximate
+// percentile of the merged histograms for each key.
+// Keys without any histogram values are reduced to nil.
+func reducePercentile(p float64) reduceFunc {
+	return func(key string, values []interface{}, r *reducer) {
+		if h := mergeHistogramValues(values); h != nil {
+			if v, ok := h.Percentile(p); ok {
+				r.emit(key, v)
+				return
+			}
+		}
+		r.emit(key, nil)
+	}
+}
+
+// reduceIntegral adds the areas of every series for each key.
+// Keys without any values are reduced to nil.
+func reduceIntegral(key string, values []interface{}, r *reducer) {
+	var total interface{}
+	for _, v := range values {
+		if area, ok := v.(float64); ok {
+			sum, _ := total.(float64)
+			total = sum + area
+		}
+	}
+	r.emit(key, total)
+}
+
+// mergeHistogramValues merges the histograms emitted by mappers.
+// Returns nil if there are no histograms.
+func mergeHistogramValues(values []interface{}) *HistogramValue {
+	var h *HistogramValue
+	for _, v := range values {
+		if other, ok := v.(*HistogramValue); !ok || other == nil {
+			continue
+		} else if h == nil {
+			h = other
+		} else {
+			h = MergeHistograms(h, other)
+		}
+	}
+	return h
+}
+
+// reduceDistinct computes the sorted list of distinct values for each key.
+// Keys without any values are reduced to nil.
+func reduceDistinct(key string, values []interface{}, r *reducer) {
+	set := make(map[interface{}]struct{})
+	for _, v := range values {
+		other, _ := v.(map[interface{}]struct{})
+		for k := range other {
+			set[k] = struct{}{}
+		}
+	}
+	if len(set) == 0 {
+		r.emit(key, nil)
+		return
+	}
+
+	a := make(distinctValues, 0, len(set))
+	for k := range set {
+		a = append(a, k)
+	}
+	sort.Sort(a)
+	r.emit(key, []interface{}(a))
+}
+
+// distinctValues represents a list of values of a single field sortable by value.
+type distinctValues []interface{}
+
+func (a distinctValues) Len() int { return len(a) }
+func (a distinctValues) Less(i, j int) bool {
+	cmp, _ := compareValues(a[i], a[j])
+	return cmp < 0
+}
+func (a distinctValues) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
 // binaryExprEvaluator represents a processor for combining two processors.
 type binaryExprEvaluator struct {
 	executor *Executor // parent executor
 	lhs, rhs processor // processors
 	op       Token     // operation
+	n        int       // number of values emitted
+	stats    stageStats
 
 	c    chan map[string]interface{}
 	done chan chan struct{}
@@ -639,8 +2291,17 @@ func (e *binaryExprEvaluator) C() <-chan map[string]interface{} { return e.c }
 // name returns the source name.
 func (e *binaryExprEvaluator) name() string { return "" }
 
+// plan returns the plan node for the evaluator and its operands.
+func (e *binaryExprEvaluator) plan() *PlanNode {
+	n := &PlanNode{Name: "binary", Detail: e.op.String()}
+	n.Rows, n.Duration = e.stats.get()
+	n.Children = []*PlanNode{e.lhs.plan(), e.rhs.plan()}
+	return n
+}
+
 // run runs the processor loop to read subprocessor output and combine it.
 func (e *binaryExprEvaluator) run() {
+	start := time.Now()
 	for {
 		// Read LHS value.
 		lhs, ok := <-e.lhs.C()
@@ -668,34 +2329,212 @@ func (e *binaryExprEvaluator) run() {
 		}
 
 		// Return value.
+		e.n++
 		e.c <- m
 	}
 
 	// Mark the channel as complete.
+	e.stats.set(e.n, time.Since(start))
 	close(e.c)
 }
 
 // eval evaluates two values using the evaluator's operation.
+// Returns nil if either value is not numeric.
 func (e *binaryExprEvaluator) eval(lhs, rhs interface{}) interface{} {
 	switch e.op {
-	case ADD:
-		return lhs.(float64) + rhs.(float64)
-	case SUB:
-		return lhs.(float64) - rhs.(float64)
-	case MUL:
-		return lhs.(float64) * rhs.(float64)
-	case DIV:
-		rhs := rhs.(float64)
-		if rhs == 0 {
-			return float64(0)
-		}
-		return lhs.(float64) / rhs
+	case ADD, SUB, MUL, DIV:
+		return evalArithmetic(e.op, lhs, rhs)
 	default:
 		// TODO: Validate operation & data types.
 		panic("invalid operation: " + e.op.String())
 	}
 }
 
+// castProcessor represents a processor that casts the values of another processor.
+type castProcessor struct {
+	proc  processor // source processor
+	typ   string    // type to cast to
+	n     int       // number of values emitted
+	stats stageStats
+
+	c chan map[string]interface{}
+}
+
+// newCastProcessor returns a new instance of castProcessor.
+func newCastProcessor(proc processor, typ string) *castProcessor {
+	return &castProcessor{
+		proc: proc,
+		typ:  typ,
+		c:    make(chan map[string]interface{}, 0),
+	}
+}
+
+// start begins streaming values from the source processor.
+func (p *castProcessor) start() {
+	p.proc.start()
+	go p.run()
+}
+
+// stop stops the processor.
+func (p *castProcessor) stop() { p.proc.stop() }
+
+// C returns the streaming data channel.
+func (p *castProcessor) C() <-chan map[string]interface{} { return p.c }
+
+// name returns the source name.
+func (p *castProcessor) name() string { return p.proc.name() }
+
+// plan returns the plan node for the cast and its source.
+func (p *castProcessor) plan() *PlanNode {
+	n := &PlanNode{Name: "cast", Detail: p.typ}
+	n.Rows, n.Duration = p.stats.get()
+	n.Children = []*PlanNode{p.proc.plan()}
+	return n
+}
+
+// run reads values from the source processor and casts them.
+func (p *castProcessor) run() {
+	start := time.Now()
+	for m := range p.proc.C() {
+		for k, v := range m {
+			m[k] = castValue(v, p.typ)
+		}
+		p.n++
+		p.c <- m
+	}
+	p.stats.set(p.n, time.Since(start))
+	close(p.c)
+}
+
+// transformProcessor represents a processor that transforms the values of
+// another processor. Every value is read before any are emitted.
+type transformProcessor struct {
+	proc  processor     // source processor
+	call  *Call         // transform function call
+	fn    transformFunc // transform function
+	n     int           // number of values emitted
+	stats stageStats
+
+	c chan map[string]interface{}
+}
+
+// newTransformProcessor returns a new instance of transformProcessor.
+func newTransformProcessor(proc processor, call *Call, fn transformFunc) *transformProcessor {
+	return &transformProcessor{
+		proc: proc,
+		call: call,
+		fn:   fn,
+		c:    make(chan map[string]interface{}, 0),
+	}
+}
+
+// start begins reading values from the source processor.
+func (p *transformProcessor) start() {
+	p.proc.start()
+	go p.run()
+}
+
+// stop stops the processor.
+func (p *transformProcessor) stop() { p.proc.stop() }
+
+// C returns the streaming data channel.
+func (p *transformProcessor) C() <-chan map[string]interface{} { return p.c }
+
+// name returns the source name.
+func (p *transformProcessor) name() string { return p.proc.name() }
+
+// plan returns the plan node for the transform and its source.
+func (p *transformProcessor) plan() *PlanNode {
+	n := &PlanNode{Name: "transform", Detail: p.call.String()}
+	n.Rows, n.Duration = p.stats.get()
+	n.Children = []*PlanNode{p.proc.plan()}
+	return n
+}
+
+// run reads every value of the source processor and emits the transformed
+// values of each tagset. Values that aren't numeric are skipped.
+func (p *transformProcessor) run() {
+	start := time.Now()
+
+	// Group the values by tagset.
+	series := make(map[string]transformValues)
+	for m := range p.proc.C() {
+		for k, v := range m {
+			f, ok := asFloat(v)
+			if !ok {
+				continue
+			}
+			tagset := k[8:]
+			series[tagset] = append(series[tagset], transformValue{
+				timestamp: int64(binary.BigEndian.Uint64([]byte(k[0:8]))),
+				value:     f,
+			})
+		}
+	}
+
+	for tagset, a := range series {
+		sort.Stable(a)
+		for _, v := range p.fn(a) {
+			key := make([]byte, 8, 8+len(tagset))
+			binary.BigEndian.PutUint64(key, uint64(v.timestamp))
+			key = append(key, tagset...)
+
+			p.n++
+			p.c <- map[string]interface{}{string(key): v.value}
+		}
+	}
+
+	p.stats.set(p.n, time.Since(start))
+	close(p.c)
+}
+
+// transformFunc represents a function that transforms the values of a
+// tagset. Values are in time order.
+type transformFunc func([]transformValue) []transformValue
+
+// transformValue represents a single value read by a transformProcessor.
+type transformValue struct {
+	timestamp int64
+	value     float64
+}
+
+// transformValues represents a list of values sortable by timestamp.
+type transformValues []transformValue
+
+func (a transformValues) Len() int           { return len(a) }
+func (a transformValues) Less(i, j int) bool { return a[i].timestamp < a[j].timestamp }
+func (a transformValues) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// cumulativeSumTransform returns the running total of the values.
+func cumulativeSumTransform(a []transformValue) []transformValue {
+	other := make([]transformValue, len(a))
+	var sum float64
+	for i, v := range a {
+		sum += v.value
+		other[i] = transformValue{timestamp: v.timestamp, value: sum}
+	}
+	return other
+}
+
+// derivativeTransform returns a transform that computes the rate of change
+// per unit between each value and the previous one. Values with the same
+// timestamp are skipped. If nonNegative is set then decreases, such as when a
+// counter is reset, are skipped too.
+func derivativeTransform(unit time.Duration, nonNegative bool) transformFunc {
+	return func(a []transformValue) []transformValue {
+		var other []transformValue
+		for i := 1; i < len(a); i++ {
+			prev, v := a[i-1], a[i]
+			if (nonNegative && v.value < prev.value) || v.timestamp == prev.timestamp {
+				continue
+			}
+			rate := (v.value - prev.value) / (float64(v.timestamp-prev.timestamp) / float64(unit))
+			other = append(other, transformValue{timestamp: v.timestamp, value: rate})
+		}
+		return other
+	}
+}
+
 // literalProcessor represents a processor that continually sends a literal value.
 type literalProcessor struct {
 	val  interface{}
@@ -736,6 +2575,11 @@ func (p *literalProcessor) stop() { syncClose(p.done) }
 // name returns the source name.
 func (p *literalProcessor) name() string { return "" }
 
+// plan returns the plan node for the literal.
+func (p *literalProcessor) plan() *PlanNode {
+	return &PlanNode{Name: "literal", Detail: fmt.Sprintf("%v", p.val)}
+}
+
 // syncClose closes a "done" channel and waits for a response.
 func syncClose(done chan chan struct{}) {
 	ch := make(chan struct{}, 0)
@@ -743,6 +2587,61 @@ func syncClose(done chan chan struct{}) {
 	<-ch
 }
 
+// stageStats records the number of values emitted by a stage of the
+// execution plan and the time it spent running.
+type stageStats struct {
+	mu       sync.Mutex
+	rows     int
+	duration time.Duration
+}
+
+// set updates the statistics once the stage has completed.
+func (s *stageStats) set(rows int, d time.Duration) {
+	s.mu.Lock()
+	s.rows, s.duration = rows, d
+	s.mu.Unlock()
+}
+
+// get returns the recorded statistics.
+func (s *stageStats) get() (int, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rows, s.duration
+}
+
+// PlanNode represents a single stage in the execution plan of a query.
+type PlanNode struct {
+	Name     string        `json:"name"`
+	Detail   string        `json:"detail,omitempty"`
+	Rows     int           `json:"rows"`
+	Duration time.Duration `json:"duration"`
+	Children []*PlanNode   `json:"children,omitempty"`
+}
+
+// Row returns the plan as a flattened row. Each stage is indented by its depth.
+// If analyze is true then the row count and duration of each stage are included.
+func (n *PlanNode) Row(analyze bool) *Row {
+	row := &Row{Name: "plan", Columns: []string{"stage", "detail"}}
+	if analyze {
+		row.Columns = append(row.Columns, "rows", "duration")
+	}
+	n.appendValues(row, 0, analyze)
+	return row
+}
+
+// appendValues appends the node and its children to a row.
+func (n *PlanNode) appendValues(row *Row, depth int, analyze bool) {
+	values := []interface{}{strings.Repeat("  ", depth) + n.Name, n.Detail}
+	if analyze {
+		values = append(values, n.Rows, n.Duration.String())
+	}
+	row.Values = append(row.Values, values)
+
+	for _, c := range n.Children {
+		c.appendValues(row, depth+1, analyze)
+	}
+}
+
 // Iterator represents a forward-only iterator over a set of points.
 // The iterator groups points together in interval sets.
 type Iterator interface {
@@ -760,11 +2659,13 @@ type Iterator interface {
 }
 
 // Row represents a single row returned from the execution of a statement.
+// Partial is set if values were dropped because the row exceeded a limit.
 type Row struct {
 	Name    string            `json:"name,omitempty"`
 	Tags    map[string]string `json:"tags,omitempty"`
 	Columns []string          `json:"columns"`
 	Values  [][]interface{}   `json:"values,omitempty"`
+	Partial bool              `json:"partial,omitempty"`
 	Err     error             `json:"err,omitempty"`
 }
 
@@ -2,6 +2,7 @@ package influxql_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -136,6 +137,429 @@ func TestPlanner_Plan_GroupByIntervalAndTag(t *testing.T) {
 	}
 }
 
+// Ensure the planner can plan and execute a mean query across series.
+// Intervals without values have a null mean.
+func TestPlanner_Plan_Mean(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T10:00:00Z", map[string]interface{}{"value": float64(10)})
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T10:30:00Z", map[string]interface{}{"value": float64(20)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T10:15:00Z", map[string]interface{}{"value": float64(60)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T11:15:00Z", map[string]interface{}{"value": float64(5)})
+
+	rs := db.MustPlanAndExecute(`SELECT mean(value) FROM cpu WHERE time >= now() - 3h GROUP BY time(1h)`)
+
+	// Expected resultset.
+	exp := minify(`[{
+		"name":"cpu",
+		"columns":["time","mean"],
+		"values":[
+			[946717200000000,null],
+			[946720800000000,30],
+			[946724400000000,5]
+		]
+	}]`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
+// Ensure selectors return the time of the selected point when they are the
+// only field.
+func TestPlanner_Plan_Selectors(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T10:00:00Z", map[string]interface{}{"value": float64(10)})
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T10:30:00Z", map[string]interface{}{"value": float64(20)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T10:15:00Z", map[string]interface{}{"value": float64(60)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T11:15:00Z", map[string]interface{}{"value": float64(5)})
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{q: `SELECT max(value) FROM cpu`, exp: `[{"name":"cpu","columns":["time","max"],"values":[[946721700000000,60]]}]`},
+		{q: `SELECT last(value) FROM cpu`, exp: `[{"name":"cpu","columns":["time","last"],"values":[[946725300000000,5]]}]`},
+		{q: `SELECT first(value) FROM cpu GROUP BY host`, exp: `[{"name":"cpu","tags":{"host":"servera"},"columns":["time","first"],"values":[[946720800000000,10]]},{"name":"cpu","tags":{"host":"serverb"},"columns":["time","first"],"values":[[946721700000000,60]]}]`},
+		{q: `SELECT min(value) FROM cpu WHERE time >= now() - 3h GROUP BY time(1h)`, exp: `[{"name":"cpu","columns":["time","min"],"values":[[946717200000000,null],[946720800000000,10],[946725300000000,5]]}]`},
+		{q: `SELECT max(value), count(value) FROM cpu`, exp: `[{"name":"cpu","columns":["time","max","count"],"values":[[0,60,4]]}]`},
+		{q: `SELECT sample(value, 10) FROM cpu`, exp: `[{"name":"cpu","columns":["time","sample"],"values":[[946720800000000,10],[946721700000000,60],[946722600000000,20],[946725300000000,5]]}]`},
+	} {
+		if act := minify(jsonify(db.MustPlanAndExecute(tt.q))); act != tt.exp {
+			t.Errorf("%d. %s: unexpected resultset: %s", i, tt.q, act)
+		}
+	}
+
+	// Samples smaller than the number of points are random.
+	rs := db.MustPlanAndExecute(`SELECT sample(value, 2) FROM cpu`)
+	if len(rs) != 1 || len(rs[0].Values) != 2 {
+		t.Fatalf("unexpected resultset: %s", jsonify(rs))
+	}
+	exp := map[int64]float64{946720800000000: 10, 946721700000000: 60, 946722600000000: 20, 946725300000000: 5}
+	for _, values := range rs[0].Values {
+		if v, ok := exp[values[0].(int64)]; !ok || v != values[1] {
+			t.Fatalf("unexpected sampled point: %v", values)
+		}
+	}
+}
+
+// Ensure selectors and samples return an error for invalid arguments.
+func TestPlanner_Plan_Selectors_Err(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", nil, "2000-01-01T10:00:00Z", map[string]interface{}{"value": float64(1), "msg": "x"})
+
+	for i, tt := range []struct {
+		q   string
+		err string
+	}{
+		{q: `SELECT max(msg) FROM cpu`, err: `max() requires a numeric field`},
+		{q: `SELECT sample(value) FROM cpu`, err: `expected two arguments for sample()`},
+		{q: `SELECT sample(value, 1.5) FROM cpu`, err: `expected positive integer sample size in sample()`},
+		{q: `SELECT sample(value, 1), count(value) FROM cpu`, err: `sample() cannot be selected with other fields`},
+	} {
+		if _, err := db.PlanAndExecute(tt.q); err == nil || err.Error() != tt.err {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		}
+	}
+}
+
+// Ensure the planner can aggregate integers without losing precision.
+func TestPlanner_Plan_Integer(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("billing", map[string]string{"account": "a"}, "2000-01-01T00:00:00Z", map[string]interface{}{"bytes": int64(9223372036854775000), "small": int64(9007199254740993), "large": int64(9007199254740993), "credits": uint64(18446744073709551615)})
+	db.WriteSeries("billing", map[string]string{"account": "b"}, "2000-01-01T00:00:10Z", map[string]interface{}{"bytes": int64(1000), "small": int64(1), "large": int64(9007199254740997), "credits": uint64(1)})
+	db.WriteSeries("billing", map[string]string{"account": "a"}, "2000-01-01T00:00:20Z", map[string]interface{}{"delta": int64(9223372036854775000)})
+	db.WriteSeries("billing", map[string]string{"account": "a"}, "2000-01-01T00:00:30Z", map[string]interface{}{"delta": int64(1000)})
+	db.WriteSeries("billing", map[string]string{"account": "a"}, "2000-01-01T00:00:40Z", map[string]interface{}{"delta": int64(-1000)})
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{q: `SELECT count(delta) FROM billing`, exp: `[{"name":"billing","columns":["time","count"],"values":[[0,3]]}]`},
+		{q: `SELECT sum(small) FROM billing`, exp: `[{"name":"billing","columns":["time","sum"],"values":[[0,9007199254740994]]}]`},
+		{q: `SELECT sum(bytes) FROM billing`, exp: `[{"name":"billing","columns":["time","sum"],"values":[[0,9223372036854776000]]}]`},
+		{q: `SELECT sum(delta) FROM billing`, exp: `[{"name":"billing","columns":["time","sum"],"values":[[0,9223372036854775000]]}]`},
+		{q: `SELECT sum(credits) FROM billing`, exp: `[{"name":"billing","columns":["time","sum"],"values":[[0,18446744073709551616]]}]`},
+		{q: `SELECT mean(small) FROM billing`, exp: `[{"name":"billing","columns":["time","mean"],"values":[[0,4503599627370497]]}]`},
+		{q: `SELECT mean(large) FROM billing`, exp: `[{"name":"billing","columns":["time","mean"],"values":[[0,9007199254740995]]}]`},
+		{q: `SELECT sum(small::float) FROM billing`, exp: `[{"name":"billing","columns":["time","sum"],"values":[[0,9007199254740992]]}]`},
+		{q: `SELECT credits FROM billing`, exp: `[{"name":"billing","columns":["time","credits"],"values":[[946684800000000,18446744073709551615],[946684810000000,1]]}]`},
+	} {
+		if act := minify(jsonify(db.MustPlanAndExecute(tt.q))); act != tt.exp {
+			t.Errorf("%d. %s: unexpected resultset: %s", i, tt.q, act)
+		}
+	}
+}
+
+// Ensure the planner can plan and execute a query merging histograms.
+func TestPlanner_Plan_Histogram(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("http", map[string]string{"host": "servera"}, "2000-01-01T10:00:00Z", map[string]interface{}{"latency": &influxql.HistogramValue{Bounds: []float64{10, 100}, Counts: []float64{5, 3, 0}}})
+	db.WriteSeries("http", map[string]string{"host": "servera"}, "2000-01-01T11:00:00Z", map[string]interface{}{"latency": &influxql.HistogramValue{Bounds: []float64{10, 100}, Counts: []float64{1, 1, 1}}})
+	db.WriteSeries("http", map[string]string{"host": "serverb"}, "2000-01-01T11:30:00Z", map[string]interface{}{"latency": &influxql.HistogramValue{Bounds: []float64{10, 50, 100}, Counts: []float64{2, 4, 0, 0}}})
+
+	rs := db.MustPlanAndExecute(`SELECT histogram(latency) FROM http WHERE time >= now() - 3h GROUP BY time(1h)`)
+
+	// Expected resultset.
+	exp := minify(`[{
+		"name":"http",
+		"columns":["time","histogram"],
+		"values":[
+			[946717200000000,null],
+			[946720800000000,{"bounds":[10,100],"counts":[5,3,0]}],
+			[946724400000000,{"bounds":[10,50,100],"counts":[3,4,1,1]}]
+		]
+	}]`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
+// Ensure the planner can plan and execute a query for percentiles of histograms.
+func TestPlanner_Plan_Percentile(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("http", map[string]string{"host": "servera"}, "2000-01-01T10:00:00Z", map[string]interface{}{"latency": &influxql.HistogramValue{Bounds: []float64{10, 100}, Counts: []float64{6, 2, 0}}})
+	db.WriteSeries("http", map[string]string{"host": "serverb"}, "2000-01-01T10:30:00Z", map[string]interface{}{"latency": &influxql.HistogramValue{Bounds: []float64{10, 100}, Counts: []float64{0, 1, 1}}})
+	db.WriteSeries("http", map[string]string{"host": "serverb"}, "2000-01-01T11:00:00Z", map[string]interface{}{"count": float64(1)})
+
+	rs := db.MustPlanAndExecute(`SELECT percentile(latency, 60) FROM http WHERE time >= now() - 3h GROUP BY time(1h)`)
+
+	// Expected resultset.
+	exp := minify(`[{
+		"name":"http",
+		"columns":["time","percentile"],
+		"values":[
+			[946717200000000,null],
+			[946720800000000,10],
+			[946724400000000,null]
+		]
+	}]`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
+// Ensure histogram functions return an error for invalid arguments.
+func TestPlanner_Plan_Percentile_Err(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("http", nil, "2000-01-01T10:00:00Z", map[string]interface{}{"latency": &influxql.HistogramValue{Counts: []float64{1}}, "value": float64(1)})
+
+	for i, tt := range []struct {
+		q   string
+		err string
+	}{
+		{q: `SELECT percentile(latency) FROM http`, err: `expected two arguments for percentile()`},
+		{q: `SELECT percentile(latency, 101) FROM http`, err: `expected percentile between 0 and 100 in percentile()`},
+		{q: `SELECT percentile(latency, 'x') FROM http`, err: `expected percentile between 0 and 100 in percentile()`},
+		{q: `SELECT percentile(value, 50) FROM http`, err: `percentile() requires a histogram field`},
+		{q: `SELECT histogram(value) FROM http`, err: `histogram() requires a histogram field`},
+	} {
+		if _, err := db.PlanAndExecute(tt.q); err == nil || err.Error() != tt.err {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		}
+	}
+}
+
+// Ensure the planner can return the time between the points of each series.
+func TestPlanner_Plan_Elapsed(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T10:00:00Z", map[string]interface{}{"value": float64(10)})
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T10:30:00Z", map[string]interface{}{"value": float64(20)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T10:15:00Z", map[string]interface{}{"value": float64(60)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T11:15:00Z", map[string]interface{}{"value": float64(5)})
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{q: `SELECT elapsed(value, 1m) FROM cpu`, exp: `[{"name":"cpu","columns":["time","elapsed"],"values":[[946722600000000,30],[946725300000000,60]]}]`},
+		{q: `SELECT elapsed(value, 1h) FROM cpu GROUP BY host`, exp: `[{"name":"cpu","tags":{"host":"servera"},"columns":["time","elapsed"],"values":[[946722600000000,0]]},{"name":"cpu","tags":{"host":"serverb"},"columns":["time","elapsed"],"values":[[946725300000000,1]]}]`},
+		{q: `SELECT elapsed(value) FROM cpu WHERE host = 'servera'`, exp: `[{"name":"cpu","columns":["time","elapsed"],"values":[[946722600000000,1800000000000]]}]`},
+	} {
+		if act := minify(jsonify(db.MustPlanAndExecute(tt.q))); act != tt.exp {
+			t.Errorf("%d. %s: unexpected resultset: %s", i, tt.q, act)
+		}
+	}
+}
+
+// Ensure the planner can compute the area under the values of each interval.
+// The areas of each series are added together.
+func TestPlanner_Plan_Integral(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T10:00:00Z", map[string]interface{}{"value": float64(10)})
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T10:30:00Z", map[string]interface{}{"value": float64(20)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T10:15:00Z", map[string]interface{}{"value": float64(60)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T11:15:00Z", map[string]interface{}{"value": float64(5)})
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{q: `SELECT integral(value) FROM cpu WHERE host = 'servera'`, exp: `[{"name":"cpu","columns":["time","integral"],"values":[[0,27000]]}]`},
+		{q: `SELECT integral(value, 1h) FROM cpu`, exp: `[{"name":"cpu","columns":["time","integral"],"values":[[0,40]]}]`},
+		{q: `SELECT integral(value, 1h) FROM cpu WHERE time >= now() - 3h GROUP BY time(1h)`, exp: `[{"name":"cpu","columns":["time","integral"],"values":[[946717200000000,null],[946720800000000,7.5],[946724400000000,0]]}]`},
+	} {
+		if act := minify(jsonify(db.MustPlanAndExecute(tt.q))); act != tt.exp {
+			t.Errorf("%d. %s: unexpected resultset: %s", i, tt.q, act)
+		}
+	}
+}
+
+// Ensure the planner can forecast the intervals after an aggregate.
+func TestPlanner_Plan_HoltWinters(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	for i, ts := range []string{"06", "07", "08", "09", "10", "11"} {
+		db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T"+ts+":00:00Z", map[string]interface{}{"value": float64(i + 1)})
+		db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T"+ts+":30:00Z", map[string]interface{}{"value": float64(10 - 2*i)})
+	}
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{q: `SELECT holt_winters(mean(value), 2, 0) FROM cpu WHERE time >= now() - 6h AND host = 'servera' GROUP BY time(1h)`, exp: `[{"name":"cpu","columns":["time","holt_winters"],"values":[[946728000000000,7],[946731600000000,8]]}]`},
+		{q: `SELECT holt_winters(sum(value), 1, 0) FROM cpu WHERE time >= now() - 6h GROUP BY time(1h), host`, exp: `[{"name":"cpu","tags":{"host":"servera"},"columns":["time","holt_winters"],"values":[[946728000000000,7]]},{"name":"cpu","tags":{"host":"serverb"},"columns":["time","holt_winters"],"values":[[946728000000000,-2]]}]`},
+	} {
+		if act := minify(jsonify(db.MustPlanAndExecute(tt.q))); act != tt.exp {
+			t.Errorf("%d. %s: unexpected resultset: %s", i, tt.q, act)
+		}
+	}
+}
+
+// Ensure holt_winters() returns an error for invalid arguments.
+func TestPlanner_Plan_HoltWinters_Err(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", nil, "2000-01-01T10:00:00Z", map[string]interface{}{"value": float64(1)})
+
+	for i, tt := range []struct {
+		q   string
+		err string
+	}{
+		{q: `SELECT holt_winters(mean(value), 2) FROM cpu GROUP BY time(1h)`, err: `expected three arguments for holt_winters()`},
+		{q: `SELECT holt_winters(mean(value), 2, 0) FROM cpu`, err: `holt_winters() requires a GROUP BY time interval`},
+		{q: `SELECT holt_winters(value, 2, 0) FROM cpu GROUP BY time(1h)`, err: `expected aggregate argument in holt_winters()`},
+		{q: `SELECT holt_winters(mean(value), 0, 0) FROM cpu GROUP BY time(1h)`, err: `expected positive integer for the number of predictions in holt_winters()`},
+		{q: `SELECT holt_winters(mean(value), 2, 1.5) FROM cpu GROUP BY time(1h)`, err: `expected non-negative integer for the season in holt_winters()`},
+		{q: `SELECT holt_winters(mean(value), 2, 0), mean(value) FROM cpu GROUP BY time(1h)`, err: `holt_winters() cannot be selected with other fields`},
+	} {
+		if _, err := db.PlanAndExecute(tt.q); err == nil || err.Error() != tt.err {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		}
+	}
+}
+
+// Ensure the planner can transform fields and aggregates into running totals
+// and rates.
+func TestPlanner_Plan_Transforms(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("if_octets", map[string]string{"host": "servera"}, "2000-01-01T10:00:00Z", map[string]interface{}{"rx": float64(100)})
+	db.WriteSeries("if_octets", map[string]string{"host": "servera"}, "2000-01-01T10:00:10Z", map[string]interface{}{"rx": float64(300)})
+	db.WriteSeries("if_octets", map[string]string{"host": "servera"}, "2000-01-01T10:00:20Z", map[string]interface{}{"rx": float64(50)})
+	db.WriteSeries("if_octets", map[string]string{"host": "servera"}, "2000-01-01T10:00:30Z", map[string]interface{}{"rx": float64(250)})
+	db.WriteSeries("if_octets", map[string]string{"host": "serverb"}, "2000-01-01T10:00:00Z", map[string]interface{}{"rx": float64(10)})
+	db.WriteSeries("if_octets", map[string]string{"host": "serverb"}, "2000-01-01T10:00:30Z", map[string]interface{}{"rx": float64(40)})
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{q: `SELECT cumulative_sum(rx) FROM if_octets WHERE host = 'servera'`, exp: `[{"name":"if_octets","columns":["time","cumulative_sum"],"values":[[946720800000000,100],[946720810000000,400],[946720820000000,450],[946720830000000,700]]}]`},
+		{q: `SELECT non_negative_derivative(rx) FROM if_octets WHERE host = 'servera'`, exp: `[{"name":"if_octets","columns":["time","non_negative_derivative"],"values":[[946720810000000,20],[946720830000000,20]]}]`},
+		{q: `SELECT non_negative_derivative(rx, 1m) FROM if_octets GROUP BY host`, exp: `[{"name":"if_octets","tags":{"host":"servera"},"columns":["time","non_negative_derivative"],"values":[[946720810000000,1200],[946720830000000,1200]]},{"name":"if_octets","tags":{"host":"serverb"},"columns":["time","non_negative_derivative"],"values":[[946720830000000,60]]}]`},
+		{q: `SELECT cumulative_sum(count(rx)) FROM if_octets WHERE time >= '2000-01-01 10:00:00' AND time < '2000-01-01 10:01:00' GROUP BY time(20s)`, exp: `[{"name":"if_octets","columns":["time","cumulative_sum"],"values":[[946720800000000,3],[946720820000000,6],[946720840000000,6]]}]`},
+		{q: `SELECT non_negative_derivative(sum(rx), 20s) FROM if_octets WHERE time >= '2000-01-01 10:00:00' AND time < '2000-01-01 10:01:00' AND host = 'serverb' GROUP BY time(20s)`, exp: `[{"name":"if_octets","columns":["time","non_negative_derivative"],"values":[[946720820000000,30]]}]`},
+
+		// Transforms can be nested.
+		{q: `SELECT derivative(mean(rx), 1s) FROM if_octets WHERE time >= '2000-01-01 10:00:00' AND time < '2000-01-01 10:01:00' AND host = 'servera' GROUP BY time(20s)`, exp: `[{"name":"if_octets","columns":["time","derivative"],"values":[[946720820000000,-2.5]]}]`},
+		{q: `SELECT cumulative_sum(non_negative_derivative(rx)) FROM if_octets WHERE host = 'servera'`, exp: `[{"name":"if_octets","columns":["time","cumulative_sum"],"values":[[946720810000000,20],[946720830000000,40]]}]`},
+		{q: `SELECT cumulative_sum(derivative(sum(rx), 20s)) FROM if_octets WHERE time >= '2000-01-01 10:00:00' AND time < '2000-01-01 10:01:00' GROUP BY time(20s), host`, exp: `[{"name":"if_octets","tags":{"host":"servera"},"columns":["time","cumulative_sum"],"values":[[946720820000000,-100],[946720840000000,-400]]},{"name":"if_octets","tags":{"host":"serverb"},"columns":["time","cumulative_sum"],"values":[[946720820000000,30],[946720840000000,-10]]}]`},
+	} {
+		if act := minify(jsonify(db.MustPlanAndExecute(tt.q))); act != tt.exp {
+			t.Errorf("%d. %s: unexpected resultset: %s", i, tt.q, act)
+		}
+	}
+}
+
+// Ensure transforms return an error for invalid arguments.
+func TestPlanner_Plan_Transforms_Err(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", nil, "2000-01-01T10:00:00Z", map[string]interface{}{"value": float64(1)})
+
+	for i, tt := range []struct {
+		q   string
+		err string
+	}{
+		{q: `SELECT cumulative_sum(value, 1s) FROM cpu`, err: `expected one argument for cumulative_sum()`},
+		{q: `SELECT non_negative_derivative(value, 1) FROM cpu`, err: `expected positive duration unit in non_negative_derivative()`},
+		{q: `SELECT non_negative_derivative(1) FROM cpu`, err: `expected field or aggregate argument in non_negative_derivative()`},
+		{q: `SELECT cumulative_sum(elapsed(value)) FROM cpu`, err: `expected field or aggregate argument in cumulative_sum()`},
+		{q: `SELECT derivative(cumulative_sum(sum(value))) FROM cpu`, err: `cumulative_sum() of an aggregate requires a GROUP BY time interval`},
+		{q: `SELECT cumulative_sum(value) FROM cpu GROUP BY time(1h)`, err: `cumulative_sum() of a field cannot be grouped by time`},
+		{q: `SELECT cumulative_sum(sum(value)) FROM cpu`, err: `cumulative_sum() of an aggregate requires a GROUP BY time interval`},
+		{q: `SELECT cumulative_sum(value), count(value) FROM cpu`, err: `cumulative_sum() cannot be selected with other fields`},
+	} {
+		if _, err := db.PlanAndExecute(tt.q); err == nil || err.Error() != tt.err {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		}
+	}
+}
+
+// Ensure elapsed() and integral() return an error for invalid arguments.
+func TestPlanner_Plan_ElapsedIntegral_Err(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", nil, "2000-01-01T10:00:00Z", map[string]interface{}{"value": float64(1), "msg": "x"})
+
+	for i, tt := range []struct {
+		q   string
+		err string
+	}{
+		{q: `SELECT elapsed(value, 1m, 1) FROM cpu`, err: `expected one or two arguments for elapsed()`},
+		{q: `SELECT elapsed(value, 0s) FROM cpu`, err: `expected positive duration unit in elapsed()`},
+		{q: `SELECT integral(value, 10) FROM cpu`, err: `expected positive duration unit in integral()`},
+		{q: `SELECT integral(msg) FROM cpu`, err: `integral() requires a numeric field`},
+		{q: `SELECT elapsed(value), count(value) FROM cpu`, err: `elapsed() cannot be selected with other fields`},
+		{q: `SELECT elapsed(value) FROM cpu GROUP BY time(1h)`, err: `elapsed() cannot be grouped by time`},
+	} {
+		if _, err := db.PlanAndExecute(tt.q); err == nil || err.Error() != tt.err {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		}
+	}
+}
+
+// Ensure the planner can select, filter and aggregate string fields.
+func TestPlanner_Plan_StringField(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("events", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"level": "error", "msg": "disk full", "code": float64(500)})
+	db.WriteSeries("events", map[string]string{"host": "servera"}, "2000-01-01T00:00:10Z", map[string]interface{}{"level": "info", "msg": "started", "code": float64(200)})
+	db.WriteSeries("events", map[string]string{"host": "serverb"}, "2000-01-01T00:00:20Z", map[string]interface{}{"level": "warn", "msg": "disk slow", "code": float64(300)})
+	db.WriteSeries("events", map[string]string{"host": "serverb"}, "2000-01-01T00:00:30Z", map[string]interface{}{"level": "error", "msg": "timeout", "code": float64(504)})
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{q: `SELECT msg FROM events WHERE level = 'error'`, exp: `[{"name":"events","columns":["time","msg"],"values":[[946684800000000,"disk full"],[946684830000000,"timeout"]]}]`},
+		{q: `SELECT msg FROM events WHERE level != 'error' AND host = 'servera'`, exp: `[{"name":"events","columns":["time","msg"],"values":[[946684810000000,"started"]]}]`},
+		{q: `SELECT msg FROM events WHERE msg =~ /^disk/`, exp: `[{"name":"events","columns":["time","msg"],"values":[[946684800000000,"disk full"],[946684820000000,"disk slow"]]}]`},
+		{q: `SELECT msg FROM events WHERE msg !~ /^disk/ AND code >= 500`, exp: `[{"name":"events","columns":["time","msg"],"values":[[946684830000000,"timeout"]]}]`},
+		{q: `SELECT count(code) FROM events WHERE level = 'error' OR level = 'warn'`, exp: `[{"name":"events","columns":["time","count"],"values":[[0,3]]}]`},
+		{q: `SELECT distinct(level) FROM events`, exp: `[{"name":"events","columns":["time","distinct"],"values":[[0,["error","info","warn"]]]}]`},
+		{q: `SELECT distinct(code) FROM events WHERE code > 250`, exp: `[{"name":"events","columns":["time","distinct"],"values":[[0,[300,500,504]]]}]`},
+		{q: `SELECT count(DISTINCT level) FROM events`, exp: `[{"name":"events","columns":["time","count"],"values":[[0,3]]}]`},
+		{q: `SELECT count(distinct(level)) FROM events GROUP BY host`, exp: `[{"name":"events","tags":{"host":"servera"},"columns":["time","count"],"values":[[0,2]]},{"name":"events","tags":{"host":"serverb"},"columns":["time","count"],"values":[[0,2]]}]`},
+	} {
+		if act := minify(jsonify(db.MustPlanAndExecute(tt.q))); act != tt.exp {
+			t.Errorf("%d. %s: unexpected resultset: %s", i, tt.q, act)
+		}
+	}
+}
+
+// Ensure the planner approximates the number of distinct values once there
+// are too many to count exactly.
+func TestPlanner_Plan_CountDistinct_Sketch(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	start := mustParseTime("2000-01-01T00:00:00Z")
+	for i := 0; i < 30000; i++ {
+		host := fmt.Sprintf("server%d", i%3)
+		ts := start.Add(time.Duration(i) * time.Millisecond).Format(time.RFC3339Nano)
+		db.WriteSeries("logins", map[string]string{"host": host}, ts, map[string]interface{}{"account": fmt.Sprintf("account%d", i%25000)})
+	}
+
+	rs := db.MustPlanAndExecute(`SELECT count(DISTINCT account) FROM logins`)
+	if n := rs[0].Values[0][1].(int64); n < 24500 || n > 25500 {
+		t.Fatalf("unexpected count: %d", n)
+	}
+
+	// Small sets are counted exactly.
+	rs = db.MustPlanAndExecute(`SELECT count(DISTINCT account) FROM logins WHERE time >= '2000-01-01T00:00:00Z' AND time < '2000-01-01T00:00:03Z' GROUP BY time(1h)`)
+	if n := rs[0].Values[0][1].(int64); n != 3000 {
+		t.Fatalf("unexpected count: %d", n)
+	}
+}
+
+// Ensure the planner returns an error for invalid string field queries.
+func TestPlanner_Plan_StringField_Err(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("events", nil, "2000-01-01T10:00:00Z", map[string]interface{}{"msg": "disk full", "code": float64(500), "latency": &influxql.HistogramValue{Counts: []float64{1}}})
+
+	for i, tt := range []struct {
+		q   string
+		err string
+	}{
+		{q: `SELECT msg FROM events WHERE code =~ /5/`, err: `=~ requires a string field: code =~ /5/`},
+		{q: `SELECT distinct(latency) FROM events`, err: `distinct() does not support histogram fields`},
+	} {
+		if _, err := db.PlanAndExecute(tt.q); err == nil || err.Error() != tt.err {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		}
+	}
+}
+
 // Ensure the planner can plan and execute a query filtered by tag.
 func TestPlanner_Plan_FilterByTag(t *testing.T) {
 	db := NewDB("2000-01-01T12:00:00Z")
@@ -222,6 +646,222 @@ func TestPlanner_Plan_Join(t *testing.T) {
 	}
 }
 
+// Ensure the planner can select field values cast to another type.
+func TestPlanner_Plan_Cast(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": "10.9"})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": "20"})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:20Z", map[string]interface{}{"value": "true"})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:30Z", map[string]interface{}{"value": "bad"})
+	db.WriteSeries("mem", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1.5)})
+	db.WriteSeries("mem", map[string]string{}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(0)})
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{
+			q:   `SELECT value::integer FROM cpu`,
+			exp: `[{"name":"cpu","columns":["time","value"],"values":[[946684800000000,10],[946684810000000,20],[946684820000000,null],[946684830000000,null]]}]`,
+		},
+		{
+			q:   `SELECT value::boolean FROM cpu`,
+			exp: `[{"name":"cpu","columns":["time","value"],"values":[[946684800000000,null],[946684810000000,null],[946684820000000,true],[946684830000000,null]]}]`,
+		},
+		{
+			q:   `SELECT value::string FROM mem`,
+			exp: `[{"name":"mem","columns":["time","value"],"values":[[946684800000000,"1.5"],[946684810000000,"0"]]}]`,
+		},
+		{
+			q:   `SELECT value::boolean FROM mem`,
+			exp: `[{"name":"mem","columns":["time","value"],"values":[[946684800000000,true],[946684810000000,false]]}]`,
+		},
+		{
+			q:   `SELECT count(value::float) FROM cpu`,
+			exp: `[{"name":"cpu","columns":["time","count"],"values":[[0,2]]}]`,
+		},
+		{
+			q:   `SELECT sum(value::integer) FROM cpu`,
+			exp: `[{"name":"cpu","columns":["time","sum"],"values":[[0,30]]}]`,
+		},
+		{
+			q:   `SELECT sum(value::float)::integer FROM cpu`,
+			exp: `[{"name":"cpu","columns":["time","sum"],"values":[[0,30]]}]`,
+		},
+	} {
+		rs := db.MustPlanAndExecute(tt.q)
+		if act := jsonify(rs); tt.exp != act {
+			t.Errorf("%d. %s: unexpected resultset: %s", i, tt.q, act)
+		}
+	}
+}
+
+// Ensure the planner rejects raw fields mixed with aggregates.
+func TestPlanner_Plan_RawField_ErrMixed(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(1)})
+
+	p := influxql.NewPlanner(db)
+	if _, err := p.Plan(MustParseSelectStatement(`SELECT value::integer, count(value) FROM cpu`)); err == nil || err.Error() != `raw fields cannot be selected with other fields` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the executor stops once it has scanned more than the maximum number of points.
+func TestPlanner_Plan_MaxPointsScanned(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(100)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(90)})
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:20Z", map[string]interface{}{"value": float64(80)})
+
+	for i, tt := range []struct {
+		max int
+		err error
+	}{
+		{max: 0, err: nil},
+		{max: 3, err: nil},
+		{max: 2, err: influxql.ErrPointLimitExceeded},
+	} {
+		p := influxql.NewPlanner(db)
+		p.Now = func() time.Time { return db.Now }
+		p.MaxPointsScanned = tt.max
+		e, err := p.Plan(MustParseSelectStatement(`SELECT count(value) FROM cpu`))
+		if err != nil {
+			t.Fatalf("%d. plan: %s", i, err)
+		}
+		ch, err := e.Execute()
+		if err != nil {
+			t.Fatalf("%d. execute: %s", i, err)
+		}
+		for _ = range ch {
+		}
+		if err := e.Err(); err != tt.err {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		}
+	}
+}
+
+// Ensure series stop being read once they produce more rows than the limit.
+func TestPlanner_Plan_MaxRowLimit(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	for i := 0; i < 10; i++ {
+		db.WriteSeries("cpu", map[string]string{"host": "servera"}, fmt.Sprintf("2000-01-01T00:00:%02dZ", i*5), map[string]interface{}{"value": float64(i)})
+	}
+
+	for i, tt := range []struct {
+		stmt string
+		n    int
+	}{
+		{stmt: `SELECT value FROM cpu`, n: 3},
+		{stmt: `SELECT count(value) FROM cpu WHERE time >= '2000-01-01T00:00:00Z' AND time < '2000-01-01T00:01:00Z' GROUP BY time(10s)`, n: 3},
+	} {
+		// Reading more than the row limit plus one point per row would
+		// exceed the point limit.
+		p := influxql.NewPlanner(db)
+		p.Now = func() time.Time { return db.Now }
+		p.MaxPointsScanned = 6
+		p.MaxRowLimit = 2
+		e, err := p.Plan(MustParseSelectStatement(tt.stmt))
+		if err != nil {
+			t.Fatalf("%d. plan: %s", i, err)
+		}
+		ch, err := e.Execute()
+		if err != nil {
+			t.Fatalf("%d. execute: %s", i, err)
+		}
+		var n int
+		for row := range ch {
+			n += len(row.Values)
+		}
+		if err := e.Err(); err != nil {
+			t.Errorf("%d. unexpected error: %s", i, err)
+		} else if n != tt.n {
+			t.Errorf("%d. unexpected value count: %d", i, n)
+		}
+	}
+}
+
+// Ensure the executor stops reading once its context is done.
+func TestPlanner_Plan_Context(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(100)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(90)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	p := influxql.NewPlanner(db)
+	p.Now = func() time.Time { return db.Now }
+	p.Context = ctx
+	e, err := p.Plan(MustParseSelectStatement(`SELECT count(value) FROM cpu GROUP BY host`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := e.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _ = range ch {
+	}
+	if err := e.Err(); err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the executor can return its execution plan with per-stage statistics.
+func TestExecutor_Plan(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(100)})
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(90)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:20Z", map[string]interface{}{"value": float64(80)})
+
+	// Plan and execute the statement.
+	p := influxql.NewPlanner(db)
+	p.Now = func() time.Time { return db.Now }
+	e, err := p.Plan(MustParseSelectStatement(`SELECT count(value) FROM cpu WHERE host = 'servera'`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := e.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _ = range ch {
+	}
+
+	// Flatten the plan and strip the durations.
+	row := e.Plan().Row(true)
+	for _, values := range row.Values {
+		values[3] = ""
+	}
+
+	exp := minify(`[
+		["select","SELECT count(value) FROM cpu WHERE host = \"servera\"",1,""],
+		["  reduce","count(value)",1,""],
+		["    index","tag index: cpu host=\"servera\"",1,""],
+		["    iterator","series=1 field=1 type=number",1,""]
+	]`)
+	if act := jsonify(row.Values); exp != act {
+		t.Fatalf("unexpected plan: %s", act)
+	}
+}
+
+// Ensure a plan can be flattened into a row without statistics.
+func TestPlanNode_Row(t *testing.T) {
+	n := &influxql.PlanNode{
+		Name: "binary", Detail: "+", Rows: 10,
+		Children: []*influxql.PlanNode{
+			{Name: "literal", Detail: "1"},
+			{Name: "literal", Detail: "2"},
+		},
+	}
+
+	exp := minify(`{"name":"plan","columns":["stage","detail"],"values":[["binary","+"],["  literal","1"],["  literal","2"]]}`)
+	if act := jsonify(n.Row(false)); exp != act {
+		t.Fatalf("unexpected row: %s", act)
+	}
+}
+
 // DB represents an in-memory test database that implements methods for Planner.
 type DB struct {
 	measurements map[string]*Measurement
@@ -393,7 +1033,7 @@ func (db *DB) Field(name, field string) (fieldID uint8, typ influxql.DataType) {
 }
 
 // CreateIterator returns a new iterator for a given field.
-func (db *DB) CreateIterator(seriesID uint32, fieldID uint8, typ influxql.DataType, min, max time.Time, interval time.Duration) influxql.Iterator {
+func (db *DB) CreateIterator(seriesID uint32, fieldID uint8, typ influxql.DataType, min, max time.Time, interval time.Duration, cond influxql.Expr) influxql.Iterator {
 	s := db.series[seriesID]
 	if s == nil {
 		panic(fmt.Sprintf("series not found: %d", seriesID))
@@ -404,10 +1044,23 @@ func (db *DB) CreateIterator(seriesID uint32, fieldID uint8, typ influxql.DataTy
 		points:   s.points,
 		fieldID:  fieldID,
 		typ:      typ,
+		cond:     cond,
 		imin:     -1,
 		interval: int64(interval),
 	}
 
+	// Map field ids back to names to evaluate the conditional.
+	if cond != nil {
+		i.names = make(map[uint8]string)
+		for _, m := range db.measurements {
+			if m.series[seriesID] != nil {
+				for name, f := range m.fields {
+					i.names[f.id] = name
+				}
+			}
+		}
+	}
+
 	if !min.IsZero() {
 		i.min = min.UnixNano()
 	}
@@ -422,6 +1075,8 @@ func (db *DB) CreateIterator(seriesID uint32, fieldID uint8, typ influxql.DataTy
 type iterator struct {
 	fieldID uint8
 	typ     influxql.DataType
+	cond    influxql.Expr
+	names   map[uint8]string
 
 	index  int
 	points points
@@ -483,14 +1138,23 @@ func (i *iterator) Next() (timestamp int64, value interface{}) {
 			return 0, nil
 		}
 
-		// Return value if it is non-nil.
+		// Return value if it is non-nil and the point matches the conditional.
 		// Otherwise loop again and try the next point.
-		if v != nil {
+		if v != nil && (i.cond == nil || influxql.Eval(i.cond, i.fieldValues(p)) == true) {
 			return p.timestamp, v
 		}
 	}
 }
 
+// fieldValues returns the values of a point by field name.
+func (i *iterator) fieldValues(p *point) map[string]interface{} {
+	m := make(map[string]interface{})
+	for id, v := range p.values {
+		m[i.names[id]] = v
+	}
+	return m
+}
+
 // Time returns start time of the current interval.
 func (i *iterator) Time() int64 { return i.imin }
 
@@ -0,0 +1,31 @@
+package influxql_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Ensure the parser doesn't panic on arbitrary queries and that parsed
+// queries can be formatted.
+func FuzzParseQuery(f *testing.F) {
+	for _, s := range []string{
+		`SELECT value FROM cpu`,
+		`SELECT mean(value) FROM cpu WHERE host = 'servera' AND time > now() - 1h GROUP BY time(10m), region fill(0) LIMIT 10`,
+		`SELECT count(distinct(value)), percentile(latency, 99) FROM "db"."rp".cpu WHERE value =~ /^a.*$/`,
+		`SHOW SERIES FROM cpu WHERE host = 'servera'; SHOW MEASUREMENTS`,
+		`CREATE RETENTION POLICY rp ON db DURATION 1d REPLICATION 1 DEFAULT`,
+		`GRANT READ ON db TO "user"`,
+		`DROP SERIES FROM cpu WHERE region = 'uswest'`,
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		q, err := influxql.NewParser(strings.NewReader(s)).ParseQuery()
+		if err != nil {
+			return
+		}
+		_ = q.String()
+	})
+}
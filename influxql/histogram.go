@@ -0,0 +1,139 @@
+package influxql
+
+import (
+	"math"
+	"sort"
+)
+
+// HistogramValue represents a field value holding a pre-bucketed distribution
+// of values. Bounds are the increasing upper bounds of the buckets and
+// Counts[i] is the number of values greater than the previous bound and less
+// than or equal to Bounds[i]. The last count is the number of values greater
+// than every bound, so there is always one more count than bounds.
+type HistogramValue struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []float64 `json:"counts"`
+}
+
+// Valid returns true if the bounds are finite and increasing and the counts
+// are finite, non-negative and one more than the bounds.
+func (h *HistogramValue) Valid() bool {
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return false
+	}
+	for i, b := range h.Bounds {
+		if math.IsNaN(b) || math.IsInf(b, 0) || (i > 0 && b <= h.Bounds[i-1]) {
+			return false
+		}
+	}
+	for _, n := range h.Counts {
+		if math.IsNaN(n) || math.IsInf(n, 0) || n < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Total returns the number of values in the histogram.
+func (h *HistogramValue) Total() float64 {
+	var total float64
+	for _, n := range h.Counts {
+		total += n
+	}
+	return total
+}
+
+// MergeHistograms returns a histogram with the values of both histograms.
+// Histograms with different bounds are merged into the union of their bounds
+// and each bucket's count is added to the bucket with the same upper bound.
+func MergeHistograms(a, b *HistogramValue) *HistogramValue {
+	if floatsEqual(a.Bounds, b.Bounds) {
+		other := &HistogramValue{Bounds: a.Bounds, Counts: make([]float64, len(a.Counts))}
+		for i := range a.Counts {
+			other.Counts[i] = a.Counts[i] + b.Counts[i]
+		}
+		return other
+	}
+
+	// Find the union of the bounds.
+	bounds := append(append([]float64{}, a.Bounds...), b.Bounds...)
+	sort.Float64s(bounds)
+	n := 0
+	for i, v := range bounds {
+		if i == 0 || v != bounds[n-1] {
+			bounds[n] = v
+			n++
+		}
+	}
+	bounds = bounds[:n]
+
+	other := &HistogramValue{Bounds: bounds, Counts: make([]float64, len(bounds)+1)}
+	for _, h := range []*HistogramValue{a, b} {
+		for i, n := range h.Counts {
+			j := len(bounds)
+			if i < len(h.Bounds) {
+				j = sort.SearchFloat64s(bounds, h.Bounds[i])
+			}
+			other.Counts[j] += n
+		}
+	}
+	return other
+}
+
+// Percentile returns the approximate value below which p percent of the
+// values fall. Values are assumed to be spread evenly through their bucket.
+// The first bucket starts at zero if its bound is positive and values above
+// every bound are reported as the last bound. Returns false if the histogram
+// is empty or only has values above a bucket without bounds.
+func (h *HistogramValue) Percentile(p float64) (float64, bool) {
+	total := h.Total()
+	if total == 0 {
+		return 0, false
+	}
+	rank := p / 100 * total
+
+	var cum float64
+	for i, n := range h.Counts {
+		if n == 0 || cum+n < rank {
+			cum += n
+			continue
+		}
+
+		// Values above every bound are reported at the last bound.
+		if i == len(h.Bounds) {
+			if i == 0 {
+				return 0, false
+			}
+			return h.Bounds[i-1], true
+		}
+
+		// Interpolate within the bucket.
+		upper := h.Bounds[i]
+		lower := upper
+		if i > 0 {
+			lower = h.Bounds[i-1]
+		} else if upper > 0 {
+			lower = 0
+		}
+		return lower + (upper-lower)*(rank-cum)/n, true
+	}
+
+	// Rounding can leave the rank just above the total.
+	if len(h.Bounds) == 0 {
+		return 0, false
+	}
+	return h.Bounds[len(h.Bounds)-1], true
+}
+
+// floatsEqual returns true if two slices hold the same values.
+func floatsEqual(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package influxql_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Ensure histograms can be validated.
+func TestHistogramValue_Valid(t *testing.T) {
+	for i, tt := range []struct {
+		h     *influxql.HistogramValue
+		valid bool
+	}{
+		{h: &influxql.HistogramValue{Bounds: []float64{1, 2}, Counts: []float64{1, 0, 3}}, valid: true},
+		{h: &influxql.HistogramValue{Counts: []float64{1}}, valid: true},
+		{h: &influxql.HistogramValue{Bounds: []float64{1, 2}, Counts: []float64{1, 0}}, valid: false},
+		{h: &influxql.HistogramValue{Bounds: []float64{2, 1}, Counts: []float64{1, 0, 3}}, valid: false},
+		{h: &influxql.HistogramValue{Bounds: []float64{1, 1}, Counts: []float64{1, 0, 3}}, valid: false},
+		{h: &influxql.HistogramValue{Bounds: []float64{math.Inf(1)}, Counts: []float64{1, 0}}, valid: false},
+		{h: &influxql.HistogramValue{Bounds: []float64{1}, Counts: []float64{-1, 0}}, valid: false},
+		{h: &influxql.HistogramValue{Bounds: []float64{1}, Counts: []float64{math.NaN(), 0}}, valid: false},
+	} {
+		if valid := tt.h.Valid(); valid != tt.valid {
+			t.Errorf("%d. unexpected validity: %v", i, valid)
+		}
+	}
+}
+
+// Ensure histograms can be merged.
+func TestMergeHistograms(t *testing.T) {
+	for i, tt := range []struct {
+		a, b *influxql.HistogramValue
+		exp  *influxql.HistogramValue
+	}{
+		// Counts are added when the bounds match.
+		{
+			a:   &influxql.HistogramValue{Bounds: []float64{10, 20}, Counts: []float64{1, 2, 3}},
+			b:   &influxql.HistogramValue{Bounds: []float64{10, 20}, Counts: []float64{4, 5, 6}},
+			exp: &influxql.HistogramValue{Bounds: []float64{10, 20}, Counts: []float64{5, 7, 9}},
+		},
+
+		// Different bounds are merged into their union.
+		{
+			a:   &influxql.HistogramValue{Bounds: []float64{10, 20}, Counts: []float64{1, 2, 3}},
+			b:   &influxql.HistogramValue{Bounds: []float64{5, 20, 50}, Counts: []float64{4, 5, 6, 7}},
+			exp: &influxql.HistogramValue{Bounds: []float64{5, 10, 20, 50}, Counts: []float64{4, 1, 7, 6, 10}},
+		},
+	} {
+		if h := influxql.MergeHistograms(tt.a, tt.b); !reflect.DeepEqual(h, tt.exp) {
+			t.Errorf("%d. unexpected histogram: %#v", i, h)
+		}
+	}
+}
+
+// Ensure approximate percentiles can be computed from a histogram.
+func TestHistogramValue_Percentile(t *testing.T) {
+	h := &influxql.HistogramValue{Bounds: []float64{10, 20, 40}, Counts: []float64{10, 0, 20, 10}}
+	for i, tt := range []struct {
+		p   float64
+		v   float64
+		ok  bool
+		hst *influxql.HistogramValue
+	}{
+		{p: 0, v: 0, ok: true},
+		{p: 10, v: 4, ok: true},
+		{p: 25, v: 10, ok: true},
+		{p: 50, v: 30, ok: true},
+		{p: 75, v: 40, ok: true},
+		{p: 99, v: 40, ok: true},
+		{p: 100, v: 40, ok: true},
+		{p: 50, hst: &influxql.HistogramValue{Bounds: []float64{-10, 0}, Counts: []float64{2, 2, 0}}, v: -10, ok: true},
+		{p: 50, hst: &influxql.HistogramValue{Bounds: []float64{10}, Counts: []float64{0, 0}}, ok: false},
+		{p: 50, hst: &influxql.HistogramValue{Counts: []float64{3}}, ok: false},
+	} {
+		hst := h
+		if tt.hst != nil {
+			hst = tt.hst
+		}
+		if v, ok := hst.Percentile(tt.p); ok != tt.ok || v != tt.v {
+			t.Errorf("%d. unexpected percentile: %v (%v)", i, v, ok)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package influxql
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// holtWintersStep is the step of the grid searched for the smoothing
+// parameters of a Holt-Winters fit.
+const holtWintersStep = 0.05
+
+// HoltWinters fits additive Holt-Winters exponential smoothing to evenly
+// spaced values and returns the next n predicted values. Season is the number
+// of values in a season; zero or one fits a trend without seasonality.
+//
+// The level, trend and seasonal smoothing parameters are chosen to minimize
+// the squared error of the one step predictions. Returns nil if there are
+// fewer than two seasons (or two values without seasonality) to fit.
+func HoltWinters(values []float64, n, season int) []float64 {
+	if n <= 0 {
+		return nil
+	} else if season <= 1 {
+		season = 1
+	}
+	if len(values) < 2*season || len(values) < 2 {
+		return nil
+	}
+
+	// Search for the parameters with the smallest error. The seasonal
+	// parameter is unused without seasonality.
+	gammas := []float64{0}
+	if season > 1 {
+		gammas = holtWintersGrid()
+	}
+	var best holtWintersFit
+	var bestErr = math.Inf(1)
+	for _, alpha := range holtWintersGrid() {
+		for _, beta := range holtWintersGrid() {
+			for _, gamma := range gammas {
+				fit := newHoltWintersFit(values, season)
+				if err := fit.run(values, alpha, beta, gamma); err < bestErr {
+					best, bestErr = fit, err
+				}
+			}
+		}
+	}
+	if math.IsInf(bestErr, 1) {
+		return nil
+	}
+	return best.forecast(len(values), n)
+}
+
+// holtWintersGrid returns the smoothing parameters to search.
+func holtWintersGrid() []float64 {
+	var a []float64
+	for v := holtWintersStep; v < 1; v += holtWintersStep {
+		a = append(a, v)
+	}
+	return a
+}
+
+// holtWintersFit represents the state of a Holt-Winters model.
+type holtWintersFit struct {
+	level, trend float64
+	seasonal     []float64
+}
+
+// newHoltWintersFit returns the initial state for a set of values. The level
+// is the mean of the first season, the trend is the average change between the
+// first two seasons and the seasonal components are the first season's
+// differences from the level.
+func newHoltWintersFit(values []float64, season int) holtWintersFit {
+	if season == 1 {
+		return holtWintersFit{level: values[0], trend: values[1] - values[0], seasonal: []float64{0}}
+	}
+
+	var first, second float64
+	for i := 0; i < season; i++ {
+		first += values[i]
+		second += values[season+i]
+	}
+	first, second = first/float64(season), second/float64(season)
+
+	fit := holtWintersFit{level: first, trend: (second - first) / float64(season), seasonal: make([]float64, season)}
+	for i := range fit.seasonal {
+		fit.seasonal[i] = values[i] - first
+	}
+	return fit
+}
+
+// run updates the state with the values after the first season and returns
+// the sum of the squared errors of the one step predictions.
+func (f *holtWintersFit) run(values []float64, alpha, beta, gamma float64) float64 {
+	m := len(f.seasonal)
+	var sse float64
+	for t := m; t < len(values); t++ {
+		y, s := values[t], f.seasonal[t%m]
+		e := y - (f.level + f.trend + s)
+		sse += e * e
+
+		level := alpha*(y-s) + (1-alpha)*(f.level+f.trend)
+		f.trend = beta*(level-f.level) + (1-beta)*f.trend
+		f.level = level
+		if m > 1 {
+			f.seasonal[t%m] = gamma*(y-level) + (1-gamma)*s
+		}
+	}
+	if math.IsNaN(sse) {
+		return math.Inf(1)
+	}
+	return sse
+}
+
+// forecast returns the n values after the first count values.
+func (f *holtWintersFit) forecast(count, n int) []float64 {
+	m := len(f.seasonal)
+	a := make([]float64, n)
+	for h := 1; h <= n; h++ {
+		a[h-1] = f.level + float64(h)*f.trend + f.seasonal[(count-1+h)%m]
+	}
+	return a
+}
+
+// holtWintersTransform returns a transform that forecasts n values, one
+// interval apart after the last value.
+func holtWintersTransform(n, season int, interval time.Duration) transformFunc {
+	return func(a []transformValue) []transformValue {
+		values := make([]float64, len(a))
+		for i := range a {
+			values[i] = a[i].value
+		}
+
+		var other []transformValue
+		last := a[len(a)-1].timestamp
+		for i, v := range HoltWinters(values, n, season) {
+			other = append(other, transformValue{timestamp: last + int64(i+1)*int64(interval), value: v})
+		}
+		return other
+	}
+}
+
+// planHoltWinters generates a processor that forecasts an aggregate. Calls
+// are in the form holt_winters(aggregate, n, season).
+func (p *Planner) planHoltWinters(e *Executor, c *Call) (processor, error) {
+	if len(c.Args) != 3 {
+		return nil, fmt.Errorf("expected three arguments for %s()", c.Name)
+	} else if e.interval == 0 {
+		return nil, fmt.Errorf("%s() requires a GROUP BY time interval", c.Name)
+	}
+
+	// The first argument must be an aggregate or a transform of one.
+	call, ok := c.Args[0].(*Call)
+	if !ok || strings.ToLower(call.Name) == "elapsed" || strings.ToLower(call.Name) == "holt_winters" {
+		return nil, fmt.Errorf("expected aggregate argument in %s()", c.Name)
+	}
+
+	// The number of values to predict and the season must be whole numbers.
+	n, ok := c.Args[1].(*NumberLiteral)
+	if !ok || n.Val < 1 || n.Val != math.Trunc(n.Val) {
+		return nil, fmt.Errorf("expected positive integer for the number of predictions in %s()", c.Name)
+	}
+	season, ok := c.Args[2].(*NumberLiteral)
+	if !ok || season.Val < 0 || season.Val != math.Trunc(season.Val) {
+		return nil, fmt.Errorf("expected non-negative integer for the season in %s()", c.Name)
+	}
+
+	proc, err := p.planCall(e, call)
+	if err != nil {
+		return nil, err
+	}
+	return newTransformProcessor(proc, c, holtWintersTransform(int(n.Val), int(season.Val), e.interval)), nil
+}
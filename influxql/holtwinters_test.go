@@ -0,0 +1,39 @@
+package influxql_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Ensure Holt-Winters forecasts continue trends and seasons.
+func TestHoltWinters(t *testing.T) {
+	for i, tt := range []struct {
+		values []float64
+		n      int
+		season int
+		exp    []float64
+	}{
+		{values: []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, n: 3, season: 0, exp: []float64{11, 12, 13}},
+		{values: []float64{1, 2, 3, 1, 2, 3, 1, 2, 3}, n: 4, season: 3, exp: []float64{1, 2, 3, 1}},
+		{values: []float64{1, 3, 2, 4, 3, 5, 4, 6}, n: 2, season: 2, exp: []float64{5, 7}},
+
+		// Not enough values to fit.
+		{values: []float64{1}, n: 3, season: 0, exp: nil},
+		{values: []float64{1, 2, 3, 1, 2}, n: 3, season: 3, exp: nil},
+		{values: []float64{1, 2, 3}, n: 0, season: 0, exp: nil},
+	} {
+		a := influxql.HoltWinters(tt.values, tt.n, tt.season)
+		if len(a) != len(tt.exp) {
+			t.Errorf("%d. unexpected forecast: %v", i, a)
+			continue
+		}
+		for j := range a {
+			if math.Abs(a[j]-tt.exp[j]) > 0.01 {
+				t.Errorf("%d. unexpected forecast: %v", i, a)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package influxql
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"strconv"
+)
+
+// hllPrecision is the number of hash bits used to choose a register. The
+// sketches have 2^hllPrecision registers and a standard error of about 0.8%.
+const hllPrecision = 14
+
+// hyperLogLog represents a HyperLogLog sketch estimating the number of
+// distinct values added to it.
+type hyperLogLog struct {
+	registers []uint8
+}
+
+// newHyperLogLog returns an empty sketch.
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{registers: make([]uint8, 1<<hllPrecision)}
+}
+
+// add adds a value to the sketch.
+func (h *hyperLogLog) add(v interface{}) {
+	x := hashValue(v)
+	i := x >> (64 - hllPrecision)
+	w := x<<hllPrecision | 1<<(hllPrecision-1)
+	if rho := uint8(bits.LeadingZeros64(w) + 1); rho > h.registers[i] {
+		h.registers[i] = rho
+	}
+}
+
+// merge adds the values of another sketch to the sketch.
+func (h *hyperLogLog) merge(other *hyperLogLog) {
+	for i, v := range other.registers {
+		if v > h.registers[i] {
+			h.registers[i] = v
+		}
+	}
+}
+
+// count returns the estimated number of distinct values.
+func (h *hyperLogLog) count() int64 {
+	m := float64(len(h.registers))
+	var sum float64
+	var zeros int
+	for _, v := range h.registers {
+		sum += math.Ldexp(1, -int(v))
+		if v == 0 {
+			zeros++
+		}
+	}
+
+	// Use linear counting for small cardinalities.
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return int64(e + 0.5)
+}
+
+// hashValue returns a 64-bit hash of a field value. Values of different
+// types are hashed differently so they are counted as distinct values.
+func hashValue(v interface{}) uint64 {
+	h := fnv.New64a()
+	var buf [9]byte
+	switch v := v.(type) {
+	case float64:
+		buf[0] = 'f'
+		binary.BigEndian.PutUint64(buf[1:], math.Float64bits(v))
+		h.Write(buf[:])
+	case int64:
+		buf[0] = 'i'
+		binary.BigEndian.PutUint64(buf[1:], uint64(v))
+		h.Write(buf[:])
+	case uint64:
+		buf[0] = 'u'
+		binary.BigEndian.PutUint64(buf[1:], v)
+		h.Write(buf[:])
+	case bool:
+		h.Write([]byte("b" + strconv.FormatBool(v)))
+	case string:
+		h.Write([]byte("s" + v))
+	}
+
+	// Mix the bits since FNV doesn't spread short inputs over the high bits.
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -1,6 +1,7 @@
 package influxql
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -21,7 +22,8 @@ const (
 
 // Parser represents an InfluxQL parser.
 type Parser struct {
-	s *bufScanner
+	s      *bufScanner
+	params map[string]interface{}
 }
 
 // NewParser returns a new instance of Parsr.
@@ -29,6 +31,12 @@ func NewParser(r io.Reader) *Parser {
 	return &Parser{s: newBufScanner(r)}
 }
 
+// SetParams sets the values substituted for bound parameters ($name) in the
+// query. Values may be strings, numbers (float64) or booleans.
+func (p *Parser) SetParams(params map[string]interface{}) {
+	p.params = params
+}
+
 // ParseQuery parses an InfluxQL string and returns a Query AST object.
 func (p *Parser) ParseQuery() (*Query, error) {
 	// If there's only whitespace then return no statements.
@@ -80,11 +88,42 @@ func (p *Parser) ParseStatement() (Statement, error) {
 		return p.parseRevokeStatement()
 	case ALTER:
 		return p.parseAlterStatement()
+	case EXPLAIN:
+		return p.parseExplainStatement()
+	case SHOW:
+		return p.parseShowStatement()
 	default:
 		return nil, newParseError(tokstr(tok, lit), []string{"SELECT"}, pos)
 	}
 }
 
+// parseExplainStatement parses a string and returns an explain statement.
+// This function assumes the EXPLAIN token has already been consumed.
+func (p *Parser) parseExplainStatement() (*ExplainStatement, error) {
+	stmt := &ExplainStatement{}
+
+	// Check for the optional ANALYZE token.
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok == ANALYZE {
+		stmt.Analyze = true
+		tok, pos, lit = p.scanIgnoreWhitespace()
+	}
+
+	// Only select statements can be explained.
+	if tok != SELECT {
+		return nil, newParseError(tokstr(tok, lit), []string{"SELECT"}, pos)
+	}
+
+	// Parse the select statement.
+	source, err := p.parseSelectStatement(targetNotRequired)
+	if err != nil {
+		return nil, err
+	}
+	stmt.Statement = source
+
+	return stmt, nil
+}
+
 // parseListStatement parses a string and returns a list statement.
 // This function assumes the LIST token has already been consumed.
 func (p *Parser) parseListStatement() (Statement, error) {
@@ -118,6 +157,73 @@ func (p *Parser) parseListStatement() (Statement, error) {
 	return nil, newParseError(tokstr(tok, lit), []string{"SERIES", "CONTINUOUS", "MEASUREMENTS", "TAG", "FIELD"}, pos)
 }
 
+// parseShowStatement parses a string and returns a show statement.
+// This function assumes the SHOW token has already been consumed.
+func (p *Parser) parseShowStatement() (Statement, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok == STATS {
+		return p.parseShowStatsStatement()
+	} else if tok == QUERIES {
+		return &ShowQueriesStatement{}, nil
+	} else if tok == DELETIONS {
+		return &ShowDeletionsStatement{}, nil
+	} else if tok == AUDIT {
+		return p.parseShowAuditStatement()
+	} else if tok == SERIES {
+		return p.parseListSeriesStatement()
+	} else if tok == TAG {
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != VALUES {
+			return nil, newParseError(tokstr(tok, lit), []string{"VALUES"}, pos)
+		}
+		return p.parseListTagValuesStatement()
+	}
+
+	return nil, newParseError(tokstr(tok, lit), []string{"STATS", "QUERIES", "DELETIONS", "AUDIT", "SERIES", "TAG"}, pos)
+}
+
+// parseShowStatsStatement parses a string and returns a show stats statement.
+// This function assumes the SHOW STATS tokens have already been consumed.
+func (p *Parser) parseShowStatsStatement() (*ShowStatsStatement, error) {
+	stmt := &ShowStatsStatement{}
+
+	// Parse optional FOR DATABASE clause.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != FOR {
+		p.unscan()
+		return stmt, nil
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != DATABASE {
+		return nil, newParseError(tokstr(tok, lit), []string{"DATABASE"}, pos)
+	}
+
+	// Parse the database name.
+	ident, err := p.parseIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Database = ident
+
+	return stmt, nil
+}
+
+// parseShowAuditStatement parses a string and returns a show audit statement.
+// This function assumes the SHOW AUDIT tokens have already been consumed.
+func (p *Parser) parseShowAuditStatement() (*ShowAuditStatement, error) {
+	stmt := &ShowAuditStatement{}
+
+	// Parse optional LIMIT clause.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != LIMIT {
+		p.unscan()
+		return stmt, nil
+	}
+	n, err := p.parseInt(1, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	stmt.Limit = n
+
+	return stmt, nil
+}
+
 // parseCreateStatement parses a string and returns a create statement.
 // This function assumes the CREATE token has already been consumned.
 func (p *Parser) parseCreateStatement() (Statement, error) {
@@ -165,9 +271,62 @@ func (p *Parser) parseAlterStatement() (Statement, error) {
 			return nil, newParseError(tokstr(tok, lit), []string{"POLICY"}, pos)
 		}
 		return p.parseAlterRetentionPolicyStatement()
+	} else if tok == DATABASE {
+		return p.parseAlterDatabaseStatement()
 	}
 
-	return nil, newParseError(tokstr(tok, lit), []string{"RETENTION"}, pos)
+	return nil, newParseError(tokstr(tok, lit), []string{"RETENTION", "DATABASE"}, pos)
+}
+
+// parseAlterDatabaseStatement parses a string and returns an alter database statement.
+// This function assumes the ALTER DATABASE tokens have already been consumed.
+func (p *Parser) parseAlterDatabaseStatement() (*AlterDatabaseStatement, error) {
+	stmt := &AlterDatabaseStatement{}
+
+	// Parse the database name.
+	ident, err := p.parseIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Name = ident
+
+	// Parse the option (RENAME TO, READ ONLY, READ WRITE, DISABLE or ENABLE).
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	switch tok {
+	case RENAME:
+		p.unscan()
+		ident, err = p.parseRenameTo()
+		if err != nil {
+			return nil, err
+		}
+		stmt.NewName = ident
+	case READ:
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		if tok != ONLY && tok != WRITE {
+			return nil, newParseError(tokstr(tok, lit), []string{"ONLY", "WRITE"}, pos)
+		}
+		readOnly := tok == ONLY
+		stmt.ReadOnly = &readOnly
+	case DISABLE, ENABLE:
+		disabled := tok == DISABLE
+		stmt.Disabled = &disabled
+	default:
+		return nil, newParseError(tokstr(tok, lit), []string{"RENAME", "READ", "DISABLE", "ENABLE"}, pos)
+	}
+
+	return stmt, nil
+}
+
+// parseRenameTo parses the identifier following the RENAME TO tokens.
+// This function assumes the RENAME token has not been consumed.
+func (p *Parser) parseRenameTo() (string, error) {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RENAME {
+		return "", newParseError(tokstr(tok, lit), []string{"RENAME"}, pos)
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != TO {
+		return "", newParseError(tokstr(tok, lit), []string{"TO"}, pos)
+	}
+	return p.parseIdentifier()
 }
 
 // parseCreateRetentionPolicyStatement parses a string and returns a create retention policy statement.
@@ -254,7 +413,7 @@ func (p *Parser) parseAlterRetentionPolicyStatement() (*AlterRetentionPolicyStat
 	stmt.Database = ident
 
 	// Loop through option tokens (DURATION, REPLICATION, DEFAULT, etc.).
-	maxNumOptions := 3
+	maxNumOptions := 4
 Loop:
 	for i := 0; i < maxNumOptions; i++ {
 		tok, pos, lit := p.scanIgnoreWhitespace()
@@ -273,9 +432,16 @@ Loop:
 			stmt.Replication = &n
 		case DEFAULT:
 			stmt.Default = true
+		case RENAME:
+			p.unscan()
+			ident, err := p.parseRenameTo()
+			if err != nil {
+				return nil, err
+			}
+			stmt.NewName = ident
 		default:
 			if i < 1 {
-				return nil, newParseError(tokstr(tok, lit), []string{"DURATION", "RETENTION", "DEFAULT"}, pos)
+				return nil, newParseError(tokstr(tok, lit), []string{"DURATION", "RETENTION", "DEFAULT", "RENAME"}, pos)
 			}
 			p.unscan()
 			break Loop
@@ -565,15 +731,18 @@ func (p *Parser) parseTarget(tr targetRequirement) (*Target, error) {
 func (p *Parser) parseDeleteStatement() (*DeleteStatement, error) {
 	stmt := &DeleteStatement{}
 
-	// Parse source
-	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != FROM {
-		return nil, newParseError(tokstr(tok, lit), []string{"FROM"}, pos)
-	}
-	source, err := p.parseSource()
-	if err != nil {
-		return nil, err
+	// Parse optional source. Deletes without a source apply to every measurement.
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok == FROM {
+		source, err := p.parseSource()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Source = source
+	} else if tok == WHERE {
+		p.unscan()
+	} else {
+		return nil, newParseError(tokstr(tok, lit), []string{"FROM", "WHERE"}, pos)
 	}
-	stmt.Source = source
 
 	// Parse condition: "WHERE EXPR".
 	condition, err := p.parseCondition()
@@ -590,6 +759,16 @@ func (p *Parser) parseDeleteStatement() (*DeleteStatement, error) {
 func (p *Parser) parseListSeriesStatement() (*ListSeriesStatement, error) {
 	stmt := &ListSeriesStatement{}
 
+	// Parse optional estimate mode.
+	stmt.Estimate = p.parseEstimate()
+
+	// Parse optional source: "FROM SOURCE" or "FROM /REGEX/".
+	source, err := p.parseOptionalSource()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Source = source
+
 	// Parse condition: "WHERE EXPR".
 	condition, err := p.parseCondition()
 	if err != nil {
@@ -611,6 +790,13 @@ func (p *Parser) parseListSeriesStatement() (*ListSeriesStatement, error) {
 	}
 	stmt.Limit = limit
 
+	// Parse offset: "OFFSET INT".
+	offset, err := p.parseOffset()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Offset = offset
+
 	return stmt, nil
 }
 
@@ -683,20 +869,28 @@ func (p *Parser) parseListTagKeysStatement() (*ListTagKeysStatement, error) {
 }
 
 // parseListTagValuesStatement parses a string and returns a ListSeriesStatement.
-// This function assumes the "LIST TAG VALUES" tokens have already been consumed.
+// This function assumes the "LIST TAG VALUES" or "SHOW TAG VALUES" tokens have
+// already been consumed.
 func (p *Parser) parseListTagValuesStatement() (*ListTagValuesStatement, error) {
 	stmt := &ListTagValuesStatement{}
 
-	// Parse source.
-	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != FROM {
-		return nil, newParseError(tokstr(tok, lit), []string{"FROM"}, pos)
-	}
-	source, err := p.parseSource()
+	// Parse optional estimate mode.
+	stmt.Estimate = p.parseEstimate()
+
+	// Parse optional source: "FROM SOURCE" or "FROM /REGEX/".
+	source, err := p.parseOptionalSource()
 	if err != nil {
 		return nil, err
 	}
 	stmt.Source = source
 
+	// Parse optional tag keys: "WITH KEY = IDENT" or "WITH KEY IN (IDENT+)".
+	keys, err := p.parseTagKeys()
+	if err != nil {
+		return nil, err
+	}
+	stmt.TagKeys = keys
+
 	// Parse condition: "WHERE EXPR".
 	condition, err := p.parseCondition()
 	if err != nil {
@@ -718,6 +912,13 @@ func (p *Parser) parseListTagValuesStatement() (*ListTagValuesStatement, error)
 	}
 	stmt.Limit = limit
 
+	// Parse offset: "OFFSET INT".
+	offset, err := p.parseOffset()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Offset = offset
+
 	return stmt, nil
 }
 
@@ -1088,6 +1289,101 @@ func (p *Parser) parseAlias() (string, error) {
 	return lit, nil
 }
 
+// parseEstimate parses the optional "ESTIMATE" keyword of meta queries.
+func (p *Parser) parseEstimate() bool {
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != ESTIMATE {
+		p.unscan()
+		return false
+	}
+	return true
+}
+
+// parseTagKeys parses the "WITH KEY = IDENT" or "WITH KEY IN (IDENT+)"
+// clause. Returns nil if the clause is not present.
+func (p *Parser) parseTagKeys() ([]string, error) {
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != WITH {
+		p.unscan()
+		return nil, nil
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != KEY {
+		return nil, newParseError(tokstr(tok, lit), []string{"KEY"}, pos)
+	}
+
+	// A single key can be compared directly.
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok == EQ {
+		key, err := p.parseIdentifier()
+		if err != nil {
+			return nil, err
+		}
+		return []string{key}, nil
+	} else if tok != IN {
+		return nil, newParseError(tokstr(tok, lit), []string{"=", "IN"}, pos)
+	}
+
+	// Otherwise parse a parenthesized list of keys.
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != LPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+	var keys []string
+	for {
+		key, err := p.parseIdentifier()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			break
+		}
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+
+	return keys, nil
+}
+
+// parseOptionalSource parses a "FROM" clause that accepts measurement regexes.
+// Returns nil if the clause is not present.
+func (p *Parser) parseOptionalSource() (Source, error) {
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != FROM {
+		p.unscan()
+		return nil, nil
+	}
+	return p.parseRegexSource()
+}
+
+// parseRegexSource parses a measurement regex, "/REGEX/", or a regular source.
+func (p *Parser) parseRegexSource() (Source, error) {
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != DIV {
+		p.unscan()
+		return p.parseSource()
+	}
+
+	re, err := p.parseRegex()
+	if err != nil {
+		return nil, err
+	}
+	return &Measurement{Regex: re}, nil
+}
+
+// parseRegex parses a regular expression.
+// This function assumes the opening slash has already been consumed.
+func (p *Parser) parseRegex() (*RegexLiteral, error) {
+	tok, pos, lit := p.s.ScanRegex()
+	if tok == BADREGEX {
+		return nil, &ParseError{Message: "unterminated regex", Pos: pos}
+	}
+
+	re, err := regexp.Compile(lit)
+	if err != nil {
+		return nil, &ParseError{Message: "invalid regex: " + err.Error(), Pos: pos}
+	}
+	return &RegexLiteral{Val: re}, nil
+}
+
 // parseSource parses the "FROM" clause of the query.
 func (p *Parser) parseSource() (Source, error) {
 	// The first token can either be the series name or a join/merge call.
@@ -1231,6 +1527,17 @@ func (p *Parser) parseLimit() (int, error) {
 	return int(n), nil
 }
 
+// parseOffset parses the "OFFSET" clause of the query, if it exists.
+func (p *Parser) parseOffset() (int, error) {
+	// Check if the OFFSET token exists.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != OFFSET {
+		p.unscan()
+		return 0, nil
+	}
+
+	return p.parseInt(0, math.MaxInt32)
+}
+
 // parseOrderBy parses the "ORDER BY" clause of a query, if it exists.
 func (p *Parser) parseOrderBy() (SortFields, error) {
 	// Return nil result and nil error if no ORDER token at this position.
@@ -1326,26 +1633,65 @@ func (p *Parser) ParseExpr() (Expr, error) {
 		}
 
 		// Otherwise parse the next unary expression.
-		rhs, err := p.parseUnaryExpr()
+		// Regex operators must be followed by a regular expression.
+		var rhs Expr
+		var err error
+		if op == EQREGEX || op == NEQREGEX {
+			if tok, pos, lit := p.scanIgnoreWhitespace(); tok != DIV {
+				return nil, newParseError(tokstr(tok, lit), []string{"regex"}, pos)
+			}
+			rhs, err = p.parseRegex()
+		} else {
+			rhs, err = p.parseUnaryExpr()
+		}
 		if err != nil {
 			return nil, err
 		}
 
-		// Assign the new root based on the precendence of the LHS and RHS operators.
-		if lhs, ok := expr.(*BinaryExpr); ok && lhs.Op.Precedence() < op.Precedence() {
-			expr = &BinaryExpr{
-				LHS: lhs.LHS,
-				RHS: &BinaryExpr{LHS: lhs.RHS, RHS: rhs, Op: op},
-				Op:  lhs.Op,
+		// Walk down the right side of the tree while its operators have a
+		// lower precendence and attach the new operator there.
+		root := &BinaryExpr{RHS: expr}
+		for node := root; ; {
+			r, ok := node.RHS.(*BinaryExpr)
+			if !ok || r.Op.Precedence() >= op.Precedence() {
+				node.RHS = &BinaryExpr{LHS: node.RHS, RHS: rhs, Op: op}
+				break
 			}
-		} else {
-			expr = &BinaryExpr{LHS: expr, RHS: rhs, Op: op}
+			node = r
 		}
+		expr = root.RHS
 	}
 }
 
-// parseUnaryExpr parses an non-binary expression.
+// parseUnaryExpr parses an non-binary expression and any casts applied to it.
 func (p *Parser) parseUnaryExpr() (Expr, error) {
+	expr, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	// Wrap the expression for each "::type" that follows it.
+	for {
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != DOUBLECOLON {
+			p.unscan()
+			return expr, nil
+		}
+
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		if tok != IDENT {
+			return nil, newParseError(tokstr(tok, lit), []string{"type"}, pos)
+		}
+		typ := strings.ToLower(lit)
+		if !IsCastType(typ) {
+			return nil, &ParseError{Message: fmt.Sprintf("unknown cast type: %s", lit), Pos: pos}
+		}
+		expr = &CastExpr{Expr: expr, Type: typ}
+	}
+}
+
+// parseOperand parses a literal, variable reference, function call or
+// parenthesized expression.
+func (p *Parser) parseOperand() (Expr, error) {
 	// If the first token is a LPAREN then parse it as its own grouped expression.
 	if tok, _, _ := p.scanIgnoreWhitespace(); tok == LPAREN {
 		expr, err := p.ParseExpr()
@@ -1375,21 +1721,9 @@ func (p *Parser) parseUnaryExpr() (Expr, error) {
 			return &VarRef{Val: lit}, nil
 		}
 	case STRING:
-		// If literal looks like a date time then parse it as a time literal.
-		if isDateTimeString(lit) {
-			t, err := time.Parse(DateTimeFormat, lit)
-			if err != nil {
-				return nil, &ParseError{Message: "unable to parse datetime", Pos: pos}
-			}
-			return &TimeLiteral{Val: t}, nil
-		} else if isDateString(lit) {
-			t, err := time.Parse(DateFormat, lit)
-			if err != nil {
-				return nil, &ParseError{Message: "unable to parse date", Pos: pos}
-			}
-			return &TimeLiteral{Val: t}, nil
-		}
-		return &StringLiteral{Val: lit}, nil
+		return parseStringLiteral(lit, pos)
+	case BOUNDPARAM:
+		return p.parseBoundParam(lit, pos)
 	case NUMBER:
 		v, err := strconv.ParseFloat(lit, 64)
 		if err != nil {
@@ -1406,12 +1740,66 @@ func (p *Parser) parseUnaryExpr() (Expr, error) {
 	}
 }
 
+// parseStringLiteral returns a time literal if lit looks like a date, a date
+// time or an RFC3339 timestamp. Otherwise it returns a string literal.
+func parseStringLiteral(lit string, pos Pos) (Expr, error) {
+	if isRFC3339String(lit) {
+		t, err := time.Parse(time.RFC3339Nano, lit)
+		if err != nil {
+			return nil, &ParseError{Message: "unable to parse timestamp", Pos: pos}
+		}
+		return &TimeLiteral{Val: t.UTC()}, nil
+	} else if isDateTimeString(lit) {
+		t, err := time.Parse(DateTimeFormat, lit)
+		if err != nil {
+			return nil, &ParseError{Message: "unable to parse datetime", Pos: pos}
+		}
+		return &TimeLiteral{Val: t}, nil
+	} else if isDateString(lit) {
+		t, err := time.Parse(DateFormat, lit)
+		if err != nil {
+			return nil, &ParseError{Message: "unable to parse date", Pos: pos}
+		}
+		return &TimeLiteral{Val: t}, nil
+	}
+	return &StringLiteral{Val: lit}, nil
+}
+
+// parseBoundParam returns a literal for the value of a bound parameter.
+// The value is never parsed as InfluxQL so it cannot alter the query.
+func (p *Parser) parseBoundParam(name string, pos Pos) (Expr, error) {
+	v, ok := p.params[name]
+	if !ok {
+		return nil, &ParseError{Message: fmt.Sprintf("missing parameter: %s", name), Pos: pos}
+	}
+
+	switch v := v.(type) {
+	case string:
+		return parseStringLiteral(v, pos)
+	case float64:
+		return &NumberLiteral{Val: v}, nil
+	case bool:
+		return &BooleanLiteral{Val: v}, nil
+	default:
+		return nil, &ParseError{Message: fmt.Sprintf("unsupported parameter type: %s", name), Pos: pos}
+	}
+}
+
 // parseCall parses a function call.
 // This function assumes the function name and LPAREN have been consumed.
 func (p *Parser) parseCall(name string) (*Call, error) {
 	// If there's a right paren then just return immediately.
-	if tok, _, _ := p.scan(); tok == RPAREN {
+	// A "DISTINCT field" argument is short for distinct(field).
+	if tok, _, lit := p.scan(); tok == RPAREN {
 		return &Call{Name: name}, nil
+	} else if tok == IDENT && strings.ToLower(lit) == "distinct" {
+		tok0, _, _ := p.scan()
+		tok1, _, _ := p.scan()
+		p.unscan()
+		if tok0 == WS && tok1 == IDENT {
+			return p.parseDistinctCall(name)
+		}
+		p.unscan()
 	}
 	p.unscan()
 
@@ -1440,6 +1828,20 @@ func (p *Parser) parseCall(name string) (*Call, error) {
 	return &Call{Name: name, Args: args}, nil
 }
 
+// parseDistinctCall parses the field of a "name(DISTINCT field)" call. The
+// DISTINCT has already been consumed.
+func (p *Parser) parseDistinctCall(name string) (*Call, error) {
+	arg, err := p.parseUnaryExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+	return &Call{Name: name, Args: []Expr{&Call{Name: "distinct", Args: []Expr{arg}}}}, nil
+}
+
 // scan returns the next token from the underlying scanner.
 func (p *Parser) scan() (tok Token, pos Pos, lit string) { return p.s.Scan() }
 
@@ -1552,6 +1954,39 @@ func (p *Parser) parseTokens(toks []Token) error {
 	return nil
 }
 
+// NormalizeQuery returns a canonical form of a query string. Whitespace is
+// collapsed and keywords are upper-cased so that queries which only differ
+// in formatting share the same normalized form and parse identically.
+func NormalizeQuery(s string) string {
+	var buf bytes.Buffer
+	scanner := NewScanner(strings.NewReader(s))
+	for {
+		tok, _, lit := scanner.Scan()
+		if tok == EOF {
+			return buf.String()
+		} else if tok == WS {
+			continue
+		}
+
+		if buf.Len() > 0 {
+			_ = buf.WriteByte(' ')
+		}
+		switch tok {
+		case STRING:
+			_, _ = buf.WriteString(Quote(lit))
+		case BOUNDPARAM:
+			_, _ = buf.WriteString("$" + lit)
+		case IDENT, NUMBER, DURATION_VAL:
+			_, _ = buf.WriteString(lit)
+		case BADSTRING, BADESCAPE, ILLEGAL:
+			// Invalid tokens are marked so they never match a valid query.
+			_, _ = buf.WriteString("!" + Quote(lit))
+		default:
+			_, _ = buf.WriteString(tok.String())
+		}
+	}
+}
+
 // Quote returns a quoted string.
 func Quote(s string) string {
 	return `"` + strings.NewReplacer("\n", `\n`, `\`, `\\`, `"`, `\"`).Replace(s) + `"`
@@ -1580,8 +2015,12 @@ func isDateString(s string) bool { return dateStringRegexp.MatchString(s) }
 // isDateTimeString returns true if the string looks like a date+time time literal.
 func isDateTimeString(s string) bool { return dateTimeStringRegexp.MatchString(s) }
 
+// isRFC3339String returns true if the string looks like an RFC3339 timestamp.
+func isRFC3339String(s string) bool { return rfc3339StringRegexp.MatchString(s) }
+
 var dateStringRegexp = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
 var dateTimeStringRegexp = regexp.MustCompile(`^\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?$`)
+var rfc3339StringRegexp = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:\d{2})$`)
 
 // ErrInvalidDuration is returned when parsing a malformatted duration.
 var ErrInvalidDuration = errors.New("invalid duration")
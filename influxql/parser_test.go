@@ -2,6 +2,7 @@ package influxql_test
 
 import (
 	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -56,6 +57,17 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// SELECT statement with cast field
+		{
+			s: `SELECT value::integer FROM cpu`,
+			stmt: &influxql.SelectStatement{
+				Fields: influxql.Fields{
+					&influxql.Field{Expr: &influxql.CastExpr{Expr: &influxql.VarRef{Val: "value"}, Type: "integer"}},
+				},
+				Source: &influxql.Measurement{Name: "cpu"},
+			},
+		},
+
 		// SELECT statement
 		{
 			s: `SELECT field1, field2 ,field3 AS field_x FROM myseries WHERE host = 'hosta.influxdb.org' GROUP BY 10h ORDER BY ASC LIMIT 20;`,
@@ -147,6 +159,22 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// DELETE statement without a source
+		{
+			s: `DELETE WHERE time < now() - 90d`,
+			stmt: &influxql.DeleteStatement{
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.LT,
+					LHS: &influxql.VarRef{Val: "time"},
+					RHS: &influxql.BinaryExpr{
+						Op:  influxql.SUB,
+						LHS: &influxql.Call{Name: "now"},
+						RHS: &influxql.DurationLiteral{Val: 90 * 24 * time.Hour},
+					},
+				},
+			},
+		},
+
 		// LIST DATABASES
 		{
 			s:    `LIST DATABASES`,
@@ -233,6 +261,82 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// SHOW SERIES estimate with a measurement regex
+		{
+			s: `SHOW SERIES ESTIMATE FROM /^cpu/`,
+			stmt: &influxql.ListSeriesStatement{
+				Estimate: true,
+				Source:   &influxql.Measurement{Regex: &influxql.RegexLiteral{Val: regexp.MustCompile(`^cpu`)}},
+			},
+		},
+
+		// SHOW SERIES with a page of results
+		{
+			s: `SHOW SERIES FROM cpu WHERE region = 'uswest' LIMIT 10 OFFSET 20`,
+			stmt: &influxql.ListSeriesStatement{
+				Source: &influxql.Measurement{Name: "cpu"},
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.EQ,
+					LHS: &influxql.VarRef{Val: "region"},
+					RHS: &influxql.StringLiteral{Val: "uswest"},
+				},
+				Limit:  10,
+				Offset: 20,
+			},
+		},
+
+		// LIST TAG VALUES with a single tag key
+		{
+			s: `LIST TAG VALUES FROM cpu WITH KEY = host`,
+			stmt: &influxql.ListTagValuesStatement{
+				Source:  &influxql.Measurement{Name: "cpu"},
+				TagKeys: []string{"host"},
+			},
+		},
+
+		// SHOW TAG VALUES across all measurements
+		{
+			s:    `SHOW TAG VALUES`,
+			stmt: &influxql.ListTagValuesStatement{},
+		},
+
+		// SHOW TAG VALUES with a measurement regex, tag keys and time bounds
+		{
+			s: `SHOW TAG VALUES FROM /cpu\/.*/ WITH KEY IN (host, region) WHERE time > now() - 1h`,
+			stmt: &influxql.ListTagValuesStatement{
+				Source:  &influxql.Measurement{Regex: &influxql.RegexLiteral{Val: regexp.MustCompile(`cpu/.*`)}},
+				TagKeys: []string{"host", "region"},
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.GT,
+					LHS: &influxql.VarRef{Val: "time"},
+					RHS: &influxql.BinaryExpr{
+						Op:  influxql.SUB,
+						LHS: &influxql.Call{Name: "now"},
+						RHS: &influxql.DurationLiteral{Val: time.Hour},
+					},
+				},
+			},
+		},
+
+		// SHOW TAG VALUES estimate
+		{
+			s: `SHOW TAG VALUES ESTIMATE WITH KEY = host`,
+			stmt: &influxql.ListTagValuesStatement{
+				Estimate: true,
+				TagKeys:  []string{"host"},
+			},
+		},
+
+		// SHOW TAG VALUES with a page of results
+		{
+			s: `SHOW TAG VALUES WITH KEY = host LIMIT 10 OFFSET 10`,
+			stmt: &influxql.ListTagValuesStatement{
+				TagKeys: []string{"host"},
+				Limit:   10,
+				Offset:  10,
+			},
+		},
+
 		// LIST FIELD KEYS
 		{
 			s: `LIST FIELD KEYS FROM src WHERE region = 'uswest' ORDER BY ASC, field1, field2 DESC LIMIT 10`,
@@ -510,6 +614,109 @@ func TestParser_ParseStatement(t *testing.T) {
 			stmt: newAlterRetentionPolicyStatement("policy1", "testdb", -1, 4, false),
 		},
 
+		// ALTER RETENTION POLICY with RENAME TO
+		{
+			s: `ALTER RETENTION POLICY policy1 ON testdb REPLICATION 4 RENAME TO policy2`,
+			stmt: func() *influxql.AlterRetentionPolicyStatement {
+				stmt := newAlterRetentionPolicyStatement("policy1", "testdb", -1, 4, false)
+				stmt.NewName = "policy2"
+				return stmt
+			}(),
+		},
+
+		// ALTER DATABASE
+		{
+			s:    `ALTER DATABASE testdb RENAME TO newdb`,
+			stmt: &influxql.AlterDatabaseStatement{Name: "testdb", NewName: "newdb"},
+		},
+
+		// ALTER DATABASE READ ONLY
+		{
+			s:    `ALTER DATABASE testdb READ ONLY`,
+			stmt: &influxql.AlterDatabaseStatement{Name: "testdb", ReadOnly: boolptr(true)},
+		},
+
+		// ALTER DATABASE READ WRITE
+		{
+			s:    `ALTER DATABASE testdb READ WRITE`,
+			stmt: &influxql.AlterDatabaseStatement{Name: "testdb", ReadOnly: boolptr(false)},
+		},
+
+		// ALTER DATABASE DISABLE
+		{
+			s:    `ALTER DATABASE testdb DISABLE`,
+			stmt: &influxql.AlterDatabaseStatement{Name: "testdb", Disabled: boolptr(true)},
+		},
+
+		// ALTER DATABASE ENABLE
+		{
+			s:    `ALTER DATABASE testdb ENABLE`,
+			stmt: &influxql.AlterDatabaseStatement{Name: "testdb", Disabled: boolptr(false)},
+		},
+
+		// EXPLAIN statement
+		{
+			s: `EXPLAIN SELECT count(value) FROM cpu`,
+			stmt: &influxql.ExplainStatement{
+				Statement: &influxql.SelectStatement{
+					Fields: influxql.Fields{
+						&influxql.Field{Expr: &influxql.Call{Name: "count", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}},
+					},
+					Source: &influxql.Measurement{Name: "cpu"},
+				},
+			},
+		},
+
+		// EXPLAIN ANALYZE statement
+		{
+			s: `EXPLAIN ANALYZE SELECT count(value) FROM cpu`,
+			stmt: &influxql.ExplainStatement{
+				Statement: &influxql.SelectStatement{
+					Fields: influxql.Fields{
+						&influxql.Field{Expr: &influxql.Call{Name: "count", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}},
+					},
+					Source: &influxql.Measurement{Name: "cpu"},
+				},
+				Analyze: true,
+			},
+		},
+
+		// SHOW STATS statement
+		{
+			s:    `SHOW STATS`,
+			stmt: &influxql.ShowStatsStatement{},
+		},
+
+		// SHOW STATS FOR DATABASE statement
+		{
+			s:    `SHOW STATS FOR DATABASE testdb`,
+			stmt: &influxql.ShowStatsStatement{Database: "testdb"},
+		},
+
+		// SHOW AUDIT statement
+		{
+			s:    `SHOW AUDIT`,
+			stmt: &influxql.ShowAuditStatement{},
+		},
+
+		// SHOW AUDIT statement with LIMIT
+		{
+			s:    `SHOW AUDIT LIMIT 10`,
+			stmt: &influxql.ShowAuditStatement{Limit: 10},
+		},
+
+		// SHOW QUERIES statement
+		{
+			s:    `SHOW QUERIES`,
+			stmt: &influxql.ShowQueriesStatement{},
+		},
+
+		// SHOW DELETIONS statement
+		{
+			s:    `SHOW DELETIONS`,
+			stmt: &influxql.ShowDeletionsStatement{},
+		},
+
 		// Errors
 		{s: ``, err: `found EOF, expected SELECT at line 1, char 1`},
 		{s: `SELECT`, err: `found EOF, expected identifier, string, number, bool at line 1, char 8`},
@@ -528,7 +735,7 @@ func TestParser_ParseStatement(t *testing.T) {
 		{s: `SELECT field1 FROM myseries GROUP BY *`, err: `found *, expected identifier, string, number, bool at line 1, char 38`},
 		{s: `SELECT 1000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000 FROM myseries`, err: `unable to parse number at line 1, char 8`},
 		{s: `SELECT 10.5h FROM myseries`, err: `found h, expected FROM at line 1, char 12`},
-		{s: `DELETE`, err: `found EOF, expected FROM at line 1, char 8`},
+		{s: `DELETE`, err: `found EOF, expected FROM, WHERE at line 1, char 8`},
 		{s: `DELETE FROM`, err: `found EOF, expected identifier, string at line 1, char 13`},
 		{s: `DELETE FROM myseries WHERE`, err: `found EOF, expected identifier, string, number, bool at line 1, char 28`},
 		{s: `DROP SERIES`, err: `found EOF, expected identifier, string at line 1, char 13`},
@@ -569,12 +776,34 @@ func TestParser_ParseStatement(t *testing.T) {
 		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 3.14`, err: `number must be an integer at line 1, char 67`},
 		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 0`, err: `invalid value 0: must be 1 <= n <= 2147483647 at line 1, char 67`},
 		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION bad`, err: `found bad, expected number at line 1, char 67`},
-		{s: `ALTER`, err: `found EOF, expected RETENTION at line 1, char 7`},
+		{s: `EXPLAIN`, err: `found EOF, expected SELECT at line 1, char 9`},
+		{s: `EXPLAIN ANALYZE`, err: `found EOF, expected SELECT at line 1, char 17`},
+		{s: `EXPLAIN DROP SERIES cpu`, err: `found DROP, expected SELECT at line 1, char 9`},
+		{s: `SHOW`, err: `found EOF, expected STATS, QUERIES, DELETIONS, AUDIT, SERIES, TAG at line 1, char 6`},
+		{s: `SHOW DATABASES`, err: `found DATABASES, expected STATS, QUERIES, DELETIONS, AUDIT, SERIES, TAG at line 1, char 6`},
+		{s: `SHOW SERIES OFFSET`, err: `found EOF, expected number at line 1, char 20`},
+		{s: `SHOW SERIES OFFSET -1`, err: `invalid value -1: must be 0 <= n <= 2147483647 at line 1, char 20`},
+		{s: `SHOW TAG KEYS`, err: `found KEYS, expected VALUES at line 1, char 10`},
+		{s: `SHOW TAG VALUES WITH`, err: `found EOF, expected KEY at line 1, char 22`},
+		{s: `SHOW TAG VALUES WITH KEY`, err: `found EOF, expected =, IN at line 1, char 26`},
+		{s: `SHOW TAG VALUES WITH KEY IN host`, err: `found host, expected ( at line 1, char 29`},
+		{s: `SHOW TAG VALUES WITH KEY IN (host`, err: `found EOF, expected ) at line 1, char 35`},
+		{s: `SHOW TAG VALUES FROM /cpu`, err: `unterminated regex at line 1, char 22`},
+		{s: `SHOW TAG VALUES FROM /(/`, err: "invalid regex: error parsing regexp: missing closing ): `(` at line 1, char 22"},
+		{s: `SHOW AUDIT LIMIT`, err: `found EOF, expected number at line 1, char 18`},
+		{s: `SHOW STATS FOR`, err: `found EOF, expected DATABASE at line 1, char 16`},
+		{s: `SHOW STATS FOR DATABASE`, err: `found EOF, expected identifier at line 1, char 25`},
+		{s: `ALTER`, err: `found EOF, expected RETENTION, DATABASE at line 1, char 7`},
 		{s: `ALTER RETENTION`, err: `found EOF, expected POLICY at line 1, char 17`},
 		{s: `ALTER RETENTION POLICY`, err: `found EOF, expected identifier at line 1, char 24`},
 		{s: `ALTER RETENTION POLICY policy1`, err: `found EOF, expected ON at line 1, char 32`},
 		{s: `ALTER RETENTION POLICY policy1 ON`, err: `found EOF, expected identifier at line 1, char 35`},
-		{s: `ALTER RETENTION POLICY policy1 ON testdb`, err: `found EOF, expected DURATION, RETENTION, DEFAULT at line 1, char 42`},
+		{s: `ALTER RETENTION POLICY policy1 ON testdb`, err: `found EOF, expected DURATION, RETENTION, DEFAULT, RENAME at line 1, char 42`},
+		{s: `ALTER RETENTION POLICY policy1 ON testdb RENAME policy2`, err: `found policy2, expected TO at line 1, char 49`},
+		{s: `ALTER DATABASE`, err: `found EOF, expected identifier at line 1, char 16`},
+		{s: `ALTER DATABASE testdb`, err: `found EOF, expected RENAME, READ, DISABLE, ENABLE at line 1, char 23`},
+		{s: `ALTER DATABASE testdb READ`, err: `found EOF, expected ONLY, WRITE at line 1, char 28`},
+		{s: `ALTER DATABASE testdb RENAME TO`, err: `found EOF, expected identifier at line 1, char 33`},
 	}
 
 	for i, tt := range tests {
@@ -608,6 +837,9 @@ func TestParser_ParseExpr(t *testing.T) {
 		{s: `"2000-01-32 00:00:00"`, err: `unable to parse datetime at line 1, char 1`},
 		{s: `"2000-01-01"`, expr: &influxql.TimeLiteral{Val: mustParseTime("2000-01-01T00:00:00Z")}},
 		{s: `"2000-01-99"`, err: `unable to parse date at line 1, char 1`},
+		{s: `'2000-01-01T00:00:00Z'`, expr: &influxql.TimeLiteral{Val: mustParseTime("2000-01-01T00:00:00Z")}},
+		{s: `'2000-01-01T01:00:00.5+01:00'`, expr: &influxql.TimeLiteral{Val: mustParseTime("2000-01-01T00:00:00.5Z")}},
+		{s: `'2000-01-01T25:00:00Z'`, err: `unable to parse timestamp at line 1, char 1`},
 
 		// Simple binary expression
 		{
@@ -647,6 +879,28 @@ func TestParser_ParseExpr(t *testing.T) {
 			},
 		},
 
+		// Binary expression with RHS precedence below a lower operator.
+		{
+			s: `x = 1 AND y < 2 + 3`,
+			expr: &influxql.BinaryExpr{
+				Op: influxql.AND,
+				LHS: &influxql.BinaryExpr{
+					Op:  influxql.EQ,
+					LHS: &influxql.VarRef{Val: "x"},
+					RHS: &influxql.NumberLiteral{Val: 1},
+				},
+				RHS: &influxql.BinaryExpr{
+					Op:  influxql.LT,
+					LHS: &influxql.VarRef{Val: "y"},
+					RHS: &influxql.BinaryExpr{
+						Op:  influxql.ADD,
+						LHS: &influxql.NumberLiteral{Val: 2},
+						RHS: &influxql.NumberLiteral{Val: 3},
+					},
+				},
+			},
+		},
+
 		// Binary expression with LHS paren group.
 		{
 			s: `(1 + 2) * 3`,
@@ -726,6 +980,66 @@ func TestParser_ParseExpr(t *testing.T) {
 				},
 			},
 		},
+
+		// Function call with a DISTINCT argument
+		{
+			s: `count(DISTINCT value)`,
+			expr: &influxql.Call{
+				Name: "count",
+				Args: []influxql.Expr{&influxql.Call{Name: "distinct", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}},
+			},
+		},
+		{
+			s: `count(distinct(value))`,
+			expr: &influxql.Call{
+				Name: "count",
+				Args: []influxql.Expr{&influxql.Call{Name: "distinct", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}},
+			},
+		},
+		{
+			s:    `count(distinct)`,
+			expr: &influxql.Call{Name: "count", Args: []influxql.Expr{&influxql.VarRef{Val: "distinct"}}},
+		},
+		{s: `count(DISTINCT value, 1)`, err: `found ,, expected ) at line 1, char 21`},
+
+		// Cast expressions
+		{
+			s:    `value::integer`,
+			expr: &influxql.CastExpr{Expr: &influxql.VarRef{Val: "value"}, Type: "integer"},
+		},
+		{
+			s: `sum(value::FLOAT) * 2`,
+			expr: &influxql.BinaryExpr{
+				Op: influxql.MUL,
+				LHS: &influxql.Call{
+					Name: "sum",
+					Args: []influxql.Expr{&influxql.CastExpr{Expr: &influxql.VarRef{Val: "value"}, Type: "float"}},
+				},
+				RHS: &influxql.NumberLiteral{Val: 2},
+			},
+		},
+		{
+			s:    `(1 + 2)::string`,
+			expr: &influxql.CastExpr{Expr: &influxql.ParenExpr{Expr: &influxql.BinaryExpr{Op: influxql.ADD, LHS: &influxql.NumberLiteral{Val: 1}, RHS: &influxql.NumberLiteral{Val: 2}}}, Type: "string"},
+		},
+		{s: `value::int`, err: `unknown cast type: int at line 1, char 8`},
+		{s: `value::`, err: `found EOF, expected type at line 1, char 8`},
+
+		// Regex comparisons
+		{
+			s: `msg =~ /^disk/ AND level != 'info'`,
+			expr: &influxql.BinaryExpr{
+				Op:  influxql.AND,
+				LHS: &influxql.BinaryExpr{Op: influxql.EQREGEX, LHS: &influxql.VarRef{Val: "msg"}, RHS: &influxql.RegexLiteral{Val: regexp.MustCompile(`^disk`)}},
+				RHS: &influxql.BinaryExpr{Op: influxql.NEQ, LHS: &influxql.VarRef{Val: "level"}, RHS: &influxql.StringLiteral{Val: "info"}},
+			},
+		},
+		{
+			s:    `msg !~ /a\/b/`,
+			expr: &influxql.BinaryExpr{Op: influxql.NEQREGEX, LHS: &influxql.VarRef{Val: "msg"}, RHS: &influxql.RegexLiteral{Val: regexp.MustCompile(`a/b`)}},
+		},
+		{s: `msg =~ 'disk'`, err: `found disk, expected regex at line 1, char 8`},
+		{s: `msg =~ /(/`, err: "invalid regex: error parsing regexp: missing closing ): `(` at line 1, char 8"},
 	}
 
 	for i, tt := range tests {
@@ -738,6 +1052,51 @@ func TestParser_ParseExpr(t *testing.T) {
 	}
 }
 
+// Ensure the parser substitutes bound parameters with literals.
+func TestParser_ParseExpr_BoundParams(t *testing.T) {
+	params := map[string]interface{}{
+		"host":  "server01",
+		"evil":  "x' OR 1=1",
+		"n":     float64(10),
+		"ok":    true,
+		"start": "2000-01-01 00:00:00",
+		"bad":   []interface{}{},
+	}
+
+	var tests = []struct {
+		s    string
+		expr influxql.Expr
+		err  string
+	}{
+		{s: `$host`, expr: &influxql.StringLiteral{Val: "server01"}},
+		{s: `$evil`, expr: &influxql.StringLiteral{Val: "x' OR 1=1"}},
+		{s: `$n`, expr: &influxql.NumberLiteral{Val: 10}},
+		{s: `$ok`, expr: &influxql.BooleanLiteral{Val: true}},
+		{s: `$start`, expr: &influxql.TimeLiteral{Val: mustParseTime("2000-01-01T00:00:00Z")}},
+		{
+			s: `host = $host`,
+			expr: &influxql.BinaryExpr{
+				Op:  influxql.EQ,
+				LHS: &influxql.VarRef{Val: "host"},
+				RHS: &influxql.StringLiteral{Val: "server01"},
+			},
+		},
+		{s: `host = $missing`, err: `missing parameter: missing at line 1, char 8`},
+		{s: `$bad`, err: `unsupported parameter type: bad at line 1, char 1`},
+	}
+
+	for i, tt := range tests {
+		p := influxql.NewParser(strings.NewReader(tt.s))
+		p.SetParams(params)
+		expr, err := p.ParseExpr()
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.err, err)
+		} else if tt.err == "" && !reflect.DeepEqual(tt.expr, expr) {
+			t.Errorf("%d. %q\n\nexpr mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.s, tt.expr, expr)
+		}
+	}
+}
+
 // Ensure a time duration can be parsed.
 func TestParseDuration(t *testing.T) {
 	var tests = []struct {
@@ -833,6 +1192,27 @@ func TestQuoteIdent(t *testing.T) {
 	}
 }
 
+// Ensure queries that only differ in formatting normalize to the same string.
+func TestNormalizeQuery(t *testing.T) {
+	for i, tt := range []struct {
+		a, b  string
+		equal bool
+	}{
+		{`SELECT value FROM cpu`, `select  value   from cpu`, true},
+		{`SELECT value FROM cpu WHERE host = 'a'`, "SELECT value\nFROM cpu\tWHERE host='a'", true},
+		{`SELECT value FROM cpu WHERE time > $t`, `select value from cpu where time>$t`, true},
+		{`SELECT value FROM cpu`, `SELECT value FROM CPU`, false},
+		{`SELECT value FROM cpu WHERE host = 'a'`, `SELECT value FROM cpu WHERE host = 'b'`, false},
+		{`SELECT value FROM cpu WHERE host = 'a'`, `SELECT value FROM cpu WHERE host = a`, false},
+		{`SELECT value FROM cpu WHERE value > 1`, `SELECT value FROM cpu WHERE value > -1`, false},
+		{`SELECT value FROM cpu WHERE host = 'a'`, `SELECT value FROM cpu WHERE host = 'a`, false},
+	} {
+		if equal := influxql.NormalizeQuery(tt.a) == influxql.NormalizeQuery(tt.b); equal != tt.equal {
+			t.Errorf("%d. %q / %q: unexpected equality: %v", i, tt.a, tt.b, equal)
+		}
+	}
+}
+
 func BenchmarkParserParseStatement(b *testing.B) {
 	b.ReportAllocs()
 	s := `SELECT field FROM "series" WHERE value > 10`
@@ -890,3 +1270,6 @@ func newAlterRetentionPolicyStatement(name string, DB string, d time.Duration, r
 
 	return stmt
 }
+
+// boolptr returns a pointer to a bool.
+func boolptr(v bool) *bool { return &v }
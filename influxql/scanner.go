@@ -42,6 +42,8 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 		return EOF, pos, ""
 	case '"', '\'':
 		return s.scanString()
+	case '$':
+		return s.scanBoundParam()
 	case '.':
 		ch1, _ := s.r.read()
 		s.r.unread()
@@ -56,7 +58,18 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 	case '/':
 		return DIV, pos, ""
 	case '=':
+		if ch1, _ := s.r.read(); ch1 == '~' {
+			return EQREGEX, pos, ""
+		}
+		s.r.unread()
 		return EQ, pos, ""
+	case '!':
+		if ch1, _ := s.r.read(); ch1 == '=' {
+			return NEQ, pos, ""
+		} else if ch1 == '~' {
+			return NEQREGEX, pos, ""
+		}
+		s.r.unread()
 	case '>':
 		if ch1, _ := s.r.read(); ch1 == '=' {
 			return GTE, pos, ""
@@ -79,6 +92,11 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 		return COMMA, pos, ""
 	case ';':
 		return SEMICOLON, pos, ""
+	case ':':
+		if ch1, _ := s.r.read(); ch1 == ':' {
+			return DOUBLECOLON, pos, ""
+		}
+		s.r.unread()
 	}
 
 	return ILLEGAL, pos, string(ch0)
@@ -138,6 +156,36 @@ func (s *Scanner) scanIdent() (tok Token, pos Pos, lit string) {
 	return IDENT, pos, buf.String()
 }
 
+// scanBoundParam consumes a "$" followed by an identifier.
+// The returned literal does not include the "$".
+func (s *Scanner) scanBoundParam() (tok Token, pos Pos, lit string) {
+	_, pos = s.r.curr()
+
+	// The parameter name must start with a letter.
+	var buf bytes.Buffer
+	ch, _ := s.r.read()
+	if !isLetter(ch) {
+		s.r.unread()
+		return ILLEGAL, pos, "$"
+	}
+	_, _ = buf.WriteRune(ch)
+
+	// Read every subsequent name character into the buffer.
+	for {
+		ch, _ = s.r.read()
+		if ch == eof {
+			break
+		} else if !isLetter(ch) && !isDigit(ch) && ch != '_' {
+			s.r.unread()
+			break
+		} else {
+			_, _ = buf.WriteRune(ch)
+		}
+	}
+
+	return BOUNDPARAM, pos, buf.String()
+}
+
 // scanString consumes a contiguous string of non-quote characters.
 // Quote characters can be consumed if they're first escaped with a backslash.
 func (s *Scanner) scanString() (tok Token, pos Pos, lit string) {
@@ -168,6 +216,33 @@ func (s *Scanner) scanString() (tok Token, pos Pos, lit string) {
 	}
 }
 
+// ScanRegex consumes a regular expression delimited by slashes. This function
+// assumes the opening slash has already been consumed. Slashes can be included
+// in the expression if they're escaped with a backslash; other escapes are
+// passed through to the expression unchanged.
+func (s *Scanner) ScanRegex() (tok Token, pos Pos, lit string) {
+	_, pos = s.r.curr()
+	var buf bytes.Buffer
+	for {
+		ch0, _ := s.r.read()
+		if ch0 == '/' {
+			return REGEX, pos, buf.String()
+		} else if ch0 == eof || ch0 == '\n' {
+			return BADREGEX, pos, buf.String()
+		} else if ch0 == '\\' {
+			ch1, _ := s.r.read()
+			if ch1 == eof || ch1 == '\n' {
+				return BADREGEX, pos, buf.String()
+			} else if ch1 != '/' {
+				_, _ = buf.WriteRune(ch0)
+			}
+			_, _ = buf.WriteRune(ch1)
+		} else {
+			_, _ = buf.WriteRune(ch0)
+		}
+	}
+}
+
 // scanNumber consumes anything that looks like the start of a number.
 // Numbers start with a digit, full stop, plus sign or minus sign.
 // This function can return non-number tokens if a scan is a false positive.
@@ -300,6 +375,16 @@ func (s *bufScanner) Scan() (tok Token, pos Pos, lit string) {
 	return s.curr()
 }
 
+// ScanRegex reads a regular expression from the scanner. This function
+// assumes the opening slash was the last token read and was not unscanned.
+func (s *bufScanner) ScanRegex() (tok Token, pos Pos, lit string) {
+	s.i = (s.i + 1) % len(s.buf)
+	buf := &s.buf[s.i]
+	buf.tok, buf.pos, buf.lit = s.s.ScanRegex()
+
+	return s.curr()
+}
+
 // Unscan pushes the previously token back onto the buffer.
 func (s *bufScanner) Unscan() { s.n++ }
 
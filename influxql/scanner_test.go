@@ -43,6 +43,9 @@ func TestScanner_Scan(t *testing.T) {
 
 		{s: `=`, tok: influxql.EQ},
 		{s: `<>`, tok: influxql.NEQ},
+		{s: `!=`, tok: influxql.NEQ},
+		{s: `=~`, tok: influxql.EQREGEX},
+		{s: `!~`, tok: influxql.NEQREGEX},
 		{s: `! `, tok: influxql.ILLEGAL, lit: "!"},
 		{s: `<`, tok: influxql.LT},
 		{s: `<=`, tok: influxql.LTE},
@@ -55,11 +58,20 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `,`, tok: influxql.COMMA},
 		{s: `;`, tok: influxql.SEMICOLON},
 		{s: `.`, tok: influxql.DOT},
+		{s: `::`, tok: influxql.DOUBLECOLON},
+		{s: `:`, tok: influxql.ILLEGAL, lit: `:`},
 
 		// Identifiers
 		{s: `foo`, tok: influxql.IDENT, lit: `foo`},
 		{s: `Zx12_3U_-`, tok: influxql.IDENT, lit: `Zx12_3U_`},
 
+		// Bound parameters
+		{s: `$host`, tok: influxql.BOUNDPARAM, lit: `host`},
+		{s: `$host_1.x`, tok: influxql.BOUNDPARAM, lit: `host_1`},
+		{s: `$select`, tok: influxql.BOUNDPARAM, lit: `select`},
+		{s: `$`, tok: influxql.ILLEGAL, lit: `$`},
+		{s: `$1`, tok: influxql.ILLEGAL, lit: `$`},
+
 		{s: `true`, tok: influxql.TRUE},
 		{s: `false`, tok: influxql.FALSE},
 
@@ -99,6 +111,7 @@ func TestScanner_Scan(t *testing.T) {
 		// Keywords
 		{s: `ALL`, tok: influxql.ALL},
 		{s: `ALTER`, tok: influxql.ALTER},
+		{s: `ANALYZE`, tok: influxql.ANALYZE},
 		{s: `AS`, tok: influxql.AS},
 		{s: `ASC`, tok: influxql.ASC},
 		{s: `BEGIN`, tok: influxql.BEGIN},
@@ -113,6 +126,7 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `DROP`, tok: influxql.DROP},
 		{s: `DURATION`, tok: influxql.DURATION},
 		{s: `END`, tok: influxql.END},
+		{s: `ESTIMATE`, tok: influxql.ESTIMATE},
 		{s: `EXISTS`, tok: influxql.EXISTS},
 		{s: `EXPLAIN`, tok: influxql.EXPLAIN},
 		{s: `FIELD`, tok: influxql.FIELD},
@@ -120,14 +134,17 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `GRANT`, tok: influxql.GRANT},
 		{s: `GROUP`, tok: influxql.GROUP},
 		{s: `IF`, tok: influxql.IF},
+		{s: `IN`, tok: influxql.IN},
 		{s: `INNER`, tok: influxql.INNER},
 		{s: `INSERT`, tok: influxql.INSERT},
 		{s: `INTO`, tok: influxql.INTO},
+		{s: `KEY`, tok: influxql.KEY},
 		{s: `KEYS`, tok: influxql.KEYS},
 		{s: `LIMIT`, tok: influxql.LIMIT},
 		{s: `LIST`, tok: influxql.LIST},
 		{s: `MEASUREMENT`, tok: influxql.MEASUREMENT},
 		{s: `MEASUREMENTS`, tok: influxql.MEASUREMENTS},
+		{s: `OFFSET`, tok: influxql.OFFSET},
 		{s: `ON`, tok: influxql.ON},
 		{s: `ORDER`, tok: influxql.ORDER},
 		{s: `PASSWORD`, tok: influxql.PASSWORD},
@@ -140,6 +157,8 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `REVOKE`, tok: influxql.REVOKE},
 		{s: `SELECT`, tok: influxql.SELECT},
 		{s: `SERIES`, tok: influxql.SERIES},
+		{s: `SHOW`, tok: influxql.SHOW},
+		{s: `STATS`, tok: influxql.STATS},
 		{s: `TAG`, tok: influxql.TAG},
 		{s: `TO`, tok: influxql.TO},
 		{s: `USER`, tok: influxql.USER},
@@ -215,3 +234,31 @@ func TestScanner_Scan_Multi(t *testing.T) {
 		}
 	}
 }
+
+// Ensure the scanner can scan regular expressions.
+func TestScanner_ScanRegex(t *testing.T) {
+	var tests = []struct {
+		s   string
+		tok influxql.Token
+		lit string
+	}{
+		{s: `/cpu.*/`, tok: influxql.REGEX, lit: `cpu.*`},
+		{s: `/cpu\/.*/ foo`, tok: influxql.REGEX, lit: `cpu/.*`},
+		{s: `/\d+/`, tok: influxql.REGEX, lit: `\d+`},
+		{s: `/cpu`, tok: influxql.BADREGEX, lit: `cpu`},
+		{s: "/cpu\n/", tok: influxql.BADREGEX, lit: `cpu`},
+	}
+
+	for i, tt := range tests {
+		s := influxql.NewScanner(strings.NewReader(tt.s))
+		if tok, _, _ := s.Scan(); tok != influxql.DIV {
+			t.Fatalf("%d. %q unexpected opening token: %s", i, tt.s, tok)
+		}
+		tok, _, lit := s.ScanRegex()
+		if tt.tok != tok {
+			t.Errorf("%d. %q token mismatch: exp=%q got=%q <%q>", i, tt.s, tt.tok, tok, lit)
+		} else if tt.lit != lit {
+			t.Errorf("%d. %q literal mismatch: exp=%q got=%q", i, tt.s, tt.lit, lit)
+		}
+	}
+}
@@ -16,11 +16,14 @@ const (
 	literal_beg
 	// Literals
 	IDENT        // main
+	BOUNDPARAM   // $param
 	NUMBER       // 12345.67
 	DURATION_VAL // 13h
 	STRING       // "abc"
 	BADSTRING    // "abc
 	BADESCAPE    // \q
+	REGEX        // /abc/
+	BADREGEX     // /abc
 	TRUE         // true
 	FALSE        // false
 	literal_end
@@ -35,26 +38,31 @@ const (
 	AND // AND
 	OR  // OR
 
-	EQ  // =
-	NEQ // !=
-	LT  // <
-	LTE // <=
-	GT  // >
-	GTE // >=
+	EQ       // =
+	NEQ      // !=
+	EQREGEX  // =~
+	NEQREGEX // !~
+	LT       // <
+	LTE      // <=
+	GT       // >
+	GTE      // >=
 	operator_end
 
-	LPAREN    // (
-	RPAREN    // )
-	COMMA     // ,
-	SEMICOLON // ;
-	DOT       // .
+	LPAREN      // (
+	RPAREN      // )
+	COMMA       // ,
+	SEMICOLON   // ;
+	DOT         // .
+	DOUBLECOLON // ::
 
 	keyword_beg
 	// Keywords
 	ALL
 	ALTER
+	ANALYZE
 	AS
 	ASC
+	AUDIT
 	BEGIN
 	BY
 	CREATE
@@ -63,26 +71,35 @@ const (
 	DATABASES
 	DEFAULT
 	DELETE
+	DELETIONS
 	DESC
+	DISABLE
 	DROP
 	DURATION
+	ENABLE
 	END
+	ESTIMATE
 	EXISTS
 	EXPLAIN
 	FIELD
+	FOR
 	FROM
 	GRANT
 	GROUP
 	IF
+	IN
 	INNER
 	INSERT
 	INTO
+	KEY
 	KEYS
 	LIMIT
 	LIST
 	MEASUREMENT
 	MEASUREMENTS
+	OFFSET
 	ON
+	ONLY
 	ORDER
 	PASSWORD
 	POLICY
@@ -90,11 +107,14 @@ const (
 	QUERIES
 	QUERY
 	READ
+	RENAME
 	REPLICATION
 	RETENTION
 	REVOKE
 	SELECT
 	SERIES
+	SHOW
+	STATS
 	TAG
 	TO
 	USER
@@ -111,9 +131,11 @@ var tokens = [...]string{
 	WS:      "WS",
 
 	IDENT:        "IDENT",
+	BOUNDPARAM:   "BOUNDPARAM",
 	NUMBER:       "NUMBER",
 	DURATION_VAL: "DURATION_VAL",
 	STRING:       "STRING",
+	REGEX:        "REGEX",
 	TRUE:         "TRUE",
 	FALSE:        "FALSE",
 
@@ -125,23 +147,28 @@ var tokens = [...]string{
 	AND: "AND",
 	OR:  "OR",
 
-	EQ:  "=",
-	NEQ: "!=",
-	LT:  "<",
-	LTE: "<=",
-	GT:  ">",
-	GTE: ">=",
+	EQ:       "=",
+	NEQ:      "!=",
+	EQREGEX:  "=~",
+	NEQREGEX: "!~",
+	LT:       "<",
+	LTE:      "<=",
+	GT:       ">",
+	GTE:      ">=",
 
-	LPAREN:    "(",
-	RPAREN:    ")",
-	COMMA:     ",",
-	SEMICOLON: ";",
-	DOT:       ".",
+	LPAREN:      "(",
+	RPAREN:      ")",
+	COMMA:       ",",
+	SEMICOLON:   ";",
+	DOT:         ".",
+	DOUBLECOLON: "::",
 
 	ALL:          "ALL",
 	ALTER:        "ALTER",
+	ANALYZE:      "ANALYZE",
 	AS:           "AS",
 	ASC:          "ASC",
+	AUDIT:        "AUDIT",
 	BEGIN:        "BEGIN",
 	BY:           "BY",
 	CREATE:       "CREATE",
@@ -150,26 +177,35 @@ var tokens = [...]string{
 	DATABASES:    "DATABASES",
 	DEFAULT:      "DEFAULT",
 	DELETE:       "DELETE",
+	DELETIONS:    "DELETIONS",
 	DESC:         "DESC",
+	DISABLE:      "DISABLE",
 	DROP:         "DROP",
 	DURATION:     "DURATION",
+	ENABLE:       "ENABLE",
 	END:          "END",
+	ESTIMATE:     "ESTIMATE",
 	EXISTS:       "EXISTS",
 	EXPLAIN:      "EXPLAIN",
 	FIELD:        "FIELD",
+	FOR:          "FOR",
 	FROM:         "FROM",
 	GRANT:        "GRANT",
 	GROUP:        "GROUP",
 	IF:           "IF",
+	IN:           "IN",
 	INNER:        "INNER",
 	INSERT:       "INSERT",
 	INTO:         "INTO",
+	KEY:          "KEY",
 	KEYS:         "KEYS",
 	LIMIT:        "LIMIT",
 	LIST:         "LIST",
 	MEASUREMENT:  "MEASUREMENT",
 	MEASUREMENTS: "MEASUREMENTS",
+	OFFSET:       "OFFSET",
 	ON:           "ON",
+	ONLY:         "ONLY",
 	ORDER:        "ORDER",
 	PASSWORD:     "PASSWORD",
 	POLICY:       "POLICY",
@@ -177,11 +213,14 @@ var tokens = [...]string{
 	QUERIES:      "QUERIES",
 	QUERY:        "QUERY",
 	READ:         "READ",
+	RENAME:       "RENAME",
 	REPLICATION:  "REPLICATION",
 	RETENTION:    "RETENTION",
 	REVOKE:       "REVOKE",
 	SELECT:       "SELECT",
 	SERIES:       "SERIES",
+	SHOW:         "SHOW",
+	STATS:        "STATS",
 	TAG:          "TAG",
 	TO:           "TO",
 	USER:         "USER",
@@ -222,7 +261,7 @@ func (tok Token) Precedence() int {
 		return 1
 	case AND:
 		return 2
-	case EQ, NEQ, LT, LTE, GT, GTE:
+	case EQ, NEQ, EQREGEX, NEQREGEX, LT, LTE, GT, GTE:
 		return 3
 	case ADD, SUB:
 		return 4
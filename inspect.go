@@ -0,0 +1,518 @@
+package influxdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Inspector reads the metastore and shards in the data directory of a server
+// that is not running so that they can be dumped, verified and repaired.
+type Inspector struct {
+	path      string
+	writable  bool
+	meta      *metastore
+	databases []*database
+}
+
+// OpenInspector opens the data directory of a server. Files are opened
+// read-only unless writable is set. Returns an error if the server is
+// running as it holds a lock on its files.
+func OpenInspector(path string, writable bool) (*Inspector, error) {
+	metaPath := filepath.Join(path, "meta")
+	if _, err := os.Stat(metaPath); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(metaPath, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: !writable})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("metastore is locked, is the server running?")
+	} else if err != nil {
+		return nil, err
+	}
+	i := &Inspector{path: path, writable: writable, meta: &metastore{db: db}}
+
+	// Load the databases and their series index.
+	if err := i.meta.view(func(tx *metatx) error {
+		if b := tx.Bucket([]byte("Server")); b != nil {
+			if err := readFormat(b).check(MetaFormat); err != nil {
+				return err
+			}
+		}
+		for _, db := range tx.databases() {
+			if db.indexType == DiskIndex {
+				l, err := openIndexLog(filepath.Join(path, "indexes", db.name), tx.seriesN(db.name))
+				if err != nil {
+					return fmt.Errorf("open index: %s", err)
+				}
+				db.indexLog, db.idx = l, nil
+			} else {
+				tx.indexDatabase(db.name, db.idx)
+			}
+			i.databases = append(i.databases, db)
+		}
+		return nil
+	}); err != nil {
+		_ = i.Close()
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// Close closes the metastore and index logs.
+func (i *Inspector) Close() error {
+	for _, db := range i.databases {
+		if db.indexLog != nil {
+			_ = db.indexLog.close()
+		}
+	}
+	return i.meta.close()
+}
+
+// openShard opens the store of a shard.
+func (i *Inspector) openShard(id uint64) (*bolt.DB, error) {
+	path := filepath.Join(i.path, "shards", strconv.FormatUint(id, 10))
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: !i.writable})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("shard is locked, is the server running?")
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Ensure the shard is in a format that can be read.
+	if err := db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte("meta")); b != nil {
+			return readFormat(b).check(ShardFormat)
+		}
+		return nil
+	}); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// shardIDs returns the ids of a database's shards, sorted.
+func (db *database) shardIDs() []uint64 {
+	a := make(Shards, 0, len(db.shards))
+	for _, sh := range db.shards {
+		a = append(a, sh)
+	}
+	sort.Sort(a)
+	return a.IDs()
+}
+
+// databaseByShard returns the database that owns a shard.
+func (i *Inspector) databaseByShard(id uint64) *database {
+	for _, db := range i.databases {
+		if db.shards[id] != nil {
+			return db
+		}
+	}
+	return nil
+}
+
+// DumpShard writes each point in a shard to w as a JSON object on its own
+// line, ordered by series id and time. Points that cannot be decoded are
+// written with an error instead of their values.
+func (i *Inspector) DumpShard(id uint64, w io.Writer) error {
+	st, err := i.openShard(id)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	db := i.databaseByShard(id)
+	if db != nil {
+		if _, err := db.loadIndex(); err != nil {
+			return err
+		}
+	}
+	enc := json.NewEncoder(w)
+	return st.View(func(tx *bolt.Tx) error {
+		values := tx.Bucket([]byte("values"))
+		if values == nil {
+			return nil
+		}
+		dict := newStringDictionary(tx)
+		return values.ForEach(func(k, _ []byte) error {
+			p := &dumpedPoint{SeriesID: btou32(k)}
+			if db != nil {
+				if s := db.index().series[p.SeriesID]; s != nil {
+					p.Name, p.Tags = s.measurement.Name, s.Tags
+				}
+			}
+
+			return values.Bucket(k).ForEach(func(k, v []byte) error {
+				p.Time = time.Unix(0, int64(btou64(k))).UTC()
+				p.Values, p.Err = nil, ""
+				if values, err := unmarshalStoredValues(v, dict.lookup); err != nil {
+					p.Err = err.Error()
+				} else {
+					p.Values = values
+				}
+				return enc.Encode(p)
+			})
+		})
+	})
+}
+
+// dumpedPoint represents a point written by DumpShard.
+type dumpedPoint struct {
+	SeriesID uint32                 `json:"series"`
+	Name     string                 `json:"name,omitempty"`
+	Tags     map[string]string      `json:"tags,omitempty"`
+	Time     time.Time              `json:"time"`
+	Values   map[string]interface{} `json:"values,omitempty"`
+	Err      string                 `json:"error,omitempty"`
+}
+
+// Verify checks the pages of the metastore and every shard and that the
+// data in each shard matches the index. Returns a description of each
+// problem found, or an error if the files could not be read.
+func (i *Inspector) Verify() ([]string, error) {
+	var problems []string
+	report := newReporter(&problems)
+
+	// Check the metastore's pages.
+	if err := i.meta.db.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			report("meta: %s", err)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	// Check each shard against its database's index.
+	for _, db := range i.databases {
+		for _, id := range db.shardIDs() {
+			if err := i.verifyShard(db, id, report); os.IsNotExist(err) {
+				report("shard %d: file missing", id)
+			} else if err != nil {
+				return nil, fmt.Errorf("shard %d: %s", id, err)
+			}
+		}
+	}
+
+	// Report shard files that no database refers to.
+	ids, err := i.shardFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if i.databaseByShard(id) == nil {
+			report("shard %d: not in metastore", id)
+		}
+	}
+
+	return problems, nil
+}
+
+// verifyShard checks the pages, dictionary and points of a shard.
+func (i *Inspector) verifyShard(db *database, id uint64, report func(string, ...interface{})) error {
+	if _, err := db.loadIndex(); err != nil {
+		return err
+	}
+	st, err := i.openShard(id)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	return st.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			report("shard %d: %s", id, err)
+		}
+
+		// Ensure the dictionary maps strings and ids both ways.
+		dict := newStringDictionary(tx)
+		if dict.ids != nil && dict.strings != nil {
+			_ = dict.strings.ForEach(func(k, v []byte) error {
+				if other := dict.ids.Get(v); other == nil || !bytes.Equal(other, k) {
+					report("shard %d: dictionary string %d not indexed", id, btou64(k))
+				}
+				return nil
+			})
+			_ = dict.ids.ForEach(func(k, v []byte) error {
+				if other := dict.strings.Get(v); other == nil || !bytes.Equal(other, k) {
+					report("shard %d: dictionary id %d does not match its string", id, btou64(v))
+				}
+				return nil
+			})
+		}
+
+		values := tx.Bucket([]byte("values"))
+		if values == nil {
+			report("shard %d: values bucket missing", id)
+			return nil
+		}
+		return values.ForEach(func(k, _ []byte) error {
+			seriesID := btou32(k)
+			s := db.index().series[seriesID]
+			if s == nil {
+				report("shard %d: series %d not in index", id, seriesID)
+				return nil
+			}
+
+			// Ensure each point decodes and its fields match the measurement.
+			m := s.measurement
+			return values.Bucket(k).ForEach(func(k, v []byte) error {
+				if len(k) != 8 {
+					report("shard %d: series %d: invalid timestamp", id, seriesID)
+					return nil
+				} else if _, err := unmarshalStoredValues(v, dict.lookup); err != nil {
+					report("shard %d: series %d: point %d: %s", id, seriesID, int64(btou64(k)), err)
+					return nil
+				}
+				return valueTypes(v, func(key string, typ influxql.DataType) error {
+					if f := m.field(key); f == nil {
+						report("shard %d: series %d: field %q not in index", id, seriesID, key)
+					} else if f.Type != typ {
+						report("shard %d: series %d: field %q is %s, index has %s", id, seriesID, key, typ, f.Type)
+					}
+					return nil
+				})
+			})
+		})
+	})
+}
+
+// shardFiles returns the ids of the shard files in the data directory.
+func (i *Inspector) shardFiles() ([]uint64, error) {
+	fis, err := ioutil.ReadDir(filepath.Join(i.path, "shards"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var a []uint64
+	for _, fi := range fis {
+		if id, err := strconv.ParseUint(fi.Name(), 10, 64); err == nil && !fi.IsDir() {
+			a = append(a, id)
+		}
+	}
+	return a, nil
+}
+
+// MeasurementUsage represents the number of series, points and bytes stored
+// for a measurement across the shards of its database.
+type MeasurementUsage struct {
+	Database    string `json:"database"`
+	Measurement string `json:"measurement"`
+	SeriesN     int    `json:"seriesN"`
+	PointN      int    `json:"pointN"`
+	Bytes       int64  `json:"bytes"` // size of the stored keys and values
+}
+
+// Usage returns the usage of every measurement, sorted by database and measurement.
+func (i *Inspector) Usage() ([]*MeasurementUsage, error) {
+	var a []*MeasurementUsage
+	for _, db := range i.databases {
+		usage := make(map[string]*MeasurementUsage)
+		idx, err := db.loadIndex()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range idx.names {
+			usage[name] = &MeasurementUsage{Database: db.name, Measurement: name, SeriesN: len(idx.measurements[name].seriesByID)}
+		}
+
+		for _, id := range db.shardIDs() {
+			st, err := i.openShard(id)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("shard %d: %s", id, err)
+			}
+
+			err = st.View(func(tx *bolt.Tx) error {
+				values := tx.Bucket([]byte("values"))
+				if values == nil {
+					return nil
+				}
+				return values.ForEach(func(k, _ []byte) error {
+					s := db.index().series[btou32(k)]
+					if s == nil {
+						return nil
+					}
+					u := usage[s.measurement.Name]
+					return values.Bucket(k).ForEach(func(k, v []byte) error {
+						u.PointN++
+						u.Bytes += int64(len(k) + len(v))
+						return nil
+					})
+				})
+			})
+			_ = st.Close()
+			if err != nil {
+				return nil, fmt.Errorf("shard %d: %s", id, err)
+			}
+		}
+
+		for _, name := range db.index().names {
+			a = append(a, usage[name])
+		}
+	}
+	return a, nil
+}
+
+// RebuildIndex repairs the index from the data in the shards. Fields found
+// in a shard that are missing from their measurement are added and the
+// dictionary of each shard is rebuilt from its strings. Series that are
+// missing from the index cannot be recovered as their names and tags are
+// only stored in the metastore. Returns a description of each change made.
+func (i *Inspector) RebuildIndex() ([]string, error) {
+	if !i.writable {
+		return nil, fmt.Errorf("inspector is read-only")
+	}
+
+	var changes []string
+	report := newReporter(&changes)
+	for _, db := range i.databases {
+		// Track the measurements that gain fields.
+		n := make(map[*Measurement]int)
+		idx, err := db.loadIndex()
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range idx.measurements {
+			n[m] = len(m.Fields)
+		}
+
+		for _, id := range db.shardIDs() {
+			st, err := i.openShard(id)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("shard %d: %s", id, err)
+			}
+
+			err = st.Update(func(tx *bolt.Tx) error {
+				if ok, err := rebuildDictionary(tx); err != nil {
+					return err
+				} else if ok {
+					report("shard %d: rebuilt dictionary", id)
+				}
+
+				values := tx.Bucket([]byte("values"))
+				if values == nil {
+					return nil
+				}
+				return values.ForEach(func(k, _ []byte) error {
+					s := db.index().series[btou32(k)]
+					if s == nil {
+						return nil
+					}
+					return values.Bucket(k).ForEach(func(_, v []byte) error {
+						_ = valueTypes(v, func(key string, typ influxql.DataType) error {
+							if _, err := s.measurement.createFieldIfNotExists(key, typ); err != nil {
+								report("%s.%s: field %q not added: %s", db.name, s.measurement.Name, key, err)
+							}
+							return nil
+						})
+						return nil
+					})
+				})
+			})
+			_ = st.Close()
+			if err != nil {
+				return nil, fmt.Errorf("shard %d: %s", id, err)
+			}
+		}
+
+		// Persist the measurements that gained fields.
+		for _, name := range idx.names {
+			m := idx.measurements[name]
+			if len(m.Fields) == n[m] {
+				continue
+			}
+			for _, f := range m.Fields[n[m]:] {
+				report("%s.%s: added field %q (%s)", db.name, name, f.Name, f.Type)
+			}
+			if db.indexLog != nil {
+				if err := db.indexLog.appendMeasurement(m); err != nil {
+					return nil, err
+				}
+			} else if err := i.meta.update(func(tx *metatx) error {
+				return tx.saveMeasurement(db.name, m)
+			}); err != nil {
+				return nil, err
+			}
+		}
+		if db.indexLog != nil {
+			if err := db.indexLog.sync(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return changes, nil
+}
+
+// rebuildDictionary recreates the string to id bucket of a shard's
+// dictionary from its id to string bucket, which points are decoded with.
+// Returns true if the bucket was out of date.
+func rebuildDictionary(tx *bolt.Tx) (bool, error) {
+	b := tx.Bucket([]byte("dictionary"))
+	if b == nil || b.Bucket([]byte("strings")) == nil {
+		return false, nil
+	}
+	strs, ids := b.Bucket([]byte("strings")), b.Bucket([]byte("ids"))
+
+	// Check if every string maps back to its id.
+	ok := ids != nil && ids.Stats().KeyN == strs.Stats().KeyN
+	if ok {
+		_ = strs.ForEach(func(k, v []byte) error {
+			if other := ids.Get(v); other == nil || !bytes.Equal(other, k) {
+				ok = false
+			}
+			return nil
+		})
+	}
+	if ok {
+		return false, nil
+	}
+
+	// Recreate the bucket.
+	if ids != nil {
+		if err := b.DeleteBucket([]byte("ids")); err != nil {
+			return false, err
+		}
+	}
+	ids, err := b.CreateBucket([]byte("ids"))
+	if err != nil {
+		return false, err
+	}
+	if err := strs.ForEach(func(k, v []byte) error {
+		return ids.Put(append([]byte(nil), v...), append([]byte(nil), k...))
+	}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// newReporter returns a function that appends a formatted message to a,
+// ignoring messages that were already appended.
+func newReporter(a *[]string) func(format string, v ...interface{}) {
+	reported := make(map[string]bool)
+	return func(format string, v ...interface{}) {
+		if s := fmt.Sprintf(format, v...); !reported[s] {
+			reported[s] = true
+			*a = append(*a, s)
+		}
+	}
+}
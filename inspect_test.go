@@ -0,0 +1,257 @@
+package influxdb_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/influxdb/influxdb"
+)
+
+// Ensure the inspector can verify, repair and report on a server's data.
+func TestInspector(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "myspace")
+	if err := s.WritePoints("foo", "myspace", []*influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": 1.0, "status": "ok-status"}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverb"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": 2.0}},
+		{Name: "mem", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"free": 3.0}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.Sync(c.index)
+	shards, _ := s.Shards("foo")
+	id := shards[0].ID
+	path := s.Path()
+	s.Server.Close()
+
+	// Remove the fields of the "cpu" measurement from the index.
+	db, err := bolt.Open(filepath.Join(path, "meta"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("Databases")).Bucket([]byte("foo")).Bucket([]byte("Measurements")).Delete([]byte("cpu"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// Verify the missing fields are reported.
+	i, err := influxdb.OpenInspector(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems, err := i.Verify(); err != nil {
+		t.Fatal(err)
+	} else if sort.Strings(problems); !reflect.DeepEqual(problems, []string{
+		fmt.Sprintf(`shard %d: series 1: field "status" not in index`, id),
+		fmt.Sprintf(`shard %d: series 1: field "value" not in index`, id),
+		fmt.Sprintf(`shard %d: series 2: field "value" not in index`, id),
+	}) {
+		t.Fatalf("unexpected problems: %#v", problems)
+	}
+
+	// Ensure the index cannot be rebuilt while read-only.
+	if _, err := i.RebuildIndex(); err == nil {
+		t.Fatal("expected error")
+	}
+	i.Close()
+
+	// Rebuild the index and verify it again.
+	i, err = influxdb.OpenInspector(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes, err := i.RebuildIndex(); err != nil {
+		t.Fatal(err)
+	} else if sort.Strings(changes); !reflect.DeepEqual(changes, []string{
+		`foo.cpu: added field "status" (string)`,
+		`foo.cpu: added field "value" (number)`,
+	}) {
+		t.Fatalf("unexpected changes: %#v", changes)
+	}
+	i.Close()
+
+	i, err = influxdb.OpenInspector(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+	if problems, err := i.Verify(); err != nil {
+		t.Fatal(err)
+	} else if len(problems) != 0 {
+		t.Fatalf("unexpected problems: %#v", problems)
+	}
+
+	// Verify the usage of each measurement.
+	if a, err := i.Usage(); err != nil {
+		t.Fatal(err)
+	} else if len(a) != 2 {
+		t.Fatalf("unexpected usage: %s", mustMarshalJSON(a))
+	} else if a[0].Measurement != "cpu" || a[0].SeriesN != 2 || a[0].PointN != 2 || a[0].Bytes == 0 {
+		t.Fatalf("unexpected usage(0): %s", mustMarshalJSON(a[0]))
+	} else if a[1].Measurement != "mem" || a[1].SeriesN != 1 || a[1].PointN != 1 {
+		t.Fatalf("unexpected usage(1): %s", mustMarshalJSON(a[1]))
+	}
+
+	// Dump the shard.
+	var buf bytes.Buffer
+	if err := i.DumpShard(id, &buf); err != nil {
+		t.Fatal(err)
+	} else if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 3 {
+		t.Fatalf("unexpected dump: %s", buf.String())
+	} else if lines[0] != `{"series":1,"name":"cpu","tags":{"host":"servera"},"time":"2000-01-01T00:00:00Z","values":{"status":"ok-status","value":1}}` {
+		t.Fatalf("unexpected line(0): %s", lines[0])
+	}
+
+	// Ensure a missing shard is reported.
+	if err := i.DumpShard(id+100, &buf); !os.IsNotExist(err) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure stores written before formats were versioned are migrated by an
+// upgrade and that stores in a format that is too new are refused.
+func TestInspector_Upgrade(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "myspace")
+	if err := s.WritePoints("foo", "myspace", []*influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": 1.0}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverb"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": 2.0, "status": "ok-status"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.Sync(c.index)
+	shards, _ := s.Shards("foo")
+	id := shards[0].ID
+	path := s.Path()
+	s.Server.Close()
+
+	// Remove the formats and store one point as JSON and one without a
+	// checksum, as written before formats were versioned.
+	shardPath := filepath.Join(path, "shards", strconv.FormatUint(id, 10))
+	mustUpdateBolt(filepath.Join(path, "meta"), func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("Server")).Delete([]byte("format"))
+	})
+	mustUpdateBolt(shardPath, func(tx *bolt.Tx) error {
+		values := tx.Bucket([]byte("values"))
+		k := make([]byte, 8)
+		binary.BigEndian.PutUint64(k, uint64(mustParseTime("2000-01-01T00:00:00Z").UnixNano()))
+		if err := values.Bucket([]byte{0, 0, 0, 1}).Put(k, []byte(`{"value":1}`)); err != nil {
+			return err
+		}
+		b := values.Bucket([]byte{0, 0, 0, 2})
+		if err := b.Put(k, append([]byte(nil), b.Get(k)[5:]...)); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("meta")).Delete([]byte("format"))
+	})
+
+	i, err := influxdb.OpenInspector(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{
+		"meta: format 0 -> 1",
+		fmt.Sprintf("shard %d: format 0 -> 1", id),
+		fmt.Sprintf("shard %d: rewrote 2 blocks", id),
+	}
+
+	// A dry run reports the changes without making them.
+	for _, dryRun := range []bool{true, true, false} {
+		if changes, err := i.Upgrade(dryRun); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(changes, exp) {
+			t.Fatalf("unexpected changes (dry run %v): %#v", dryRun, changes)
+		}
+	}
+	if changes, err := i.Upgrade(false); err != nil {
+		t.Fatal(err)
+	} else if len(changes) != 0 {
+		t.Fatalf("unexpected changes after upgrade: %#v", changes)
+	}
+
+	// The upgraded points are checksummed and decode as before.
+	var buf bytes.Buffer
+	if problems, err := i.Verify(); err != nil {
+		t.Fatal(err)
+	} else if len(problems) != 0 {
+		t.Fatalf("unexpected problems: %#v", problems)
+	} else if err := i.DumpShard(id, &buf); err != nil {
+		t.Fatal(err)
+	} else if buf.String() != `{"series":1,"name":"cpu","tags":{"host":"servera"},"time":"2000-01-01T00:00:00Z","values":{"value":1}}`+"\n"+
+		`{"series":2,"name":"cpu","tags":{"host":"serverb"},"time":"2000-01-01T00:00:00Z","values":{"status":"ok-status","value":2}}`+"\n" {
+		t.Fatalf("unexpected dump: %s", buf.String())
+	}
+	i.Close()
+	mustViewBolt(shardPath, func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("values")).ForEach(func(k, _ []byte) error {
+			return tx.Bucket([]byte("values")).Bucket(k).ForEach(func(_, v []byte) error {
+				if v[0] != 2 {
+					t.Errorf("series %x: block not checksummed: %x", k, v)
+				}
+				return nil
+			})
+		})
+	})
+
+	// Ensure stores that need a newer reader are refused.
+	tooNew := make([]byte, 16)
+	binary.BigEndian.PutUint64(tooNew[:8], 100)
+	binary.BigEndian.PutUint64(tooNew[8:], 99)
+	mustUpdateBolt(shardPath, func(tx *bolt.Tx) error { return tx.Bucket([]byte("meta")).Put([]byte("format"), tooNew) })
+	if i, err = influxdb.OpenInspector(path, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := i.Upgrade(false); err == nil || err.Error() != fmt.Sprintf("shard %d: format version 100 requires version 99 or later, this node reads up to 1", id) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	i.Close()
+
+	mustUpdateBolt(filepath.Join(path, "meta"), func(tx *bolt.Tx) error { return tx.Bucket([]byte("Server")).Put([]byte("format"), tooNew) })
+	if err := s.Server.Open(path); err == nil || err.Error() != "meta: format version 100 requires version 99 or later, this node reads up to 1" {
+		t.Fatalf("unexpected open error: %v", err)
+	}
+}
+
+// mustUpdateBolt runs fn in a writable transaction on the bolt file at path.
+func mustUpdateBolt(path string, fn func(*bolt.Tx) error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	if err := db.Update(fn); err != nil {
+		panic(err)
+	}
+}
+
+// mustViewBolt runs fn in a read-only transaction on the bolt file at path.
+func mustViewBolt(path string, fn func(*bolt.Tx) error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	if err := db.View(fn); err != nil {
+		panic(err)
+	}
+}
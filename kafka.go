@@ -0,0 +1,310 @@
+package influxdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/influxdb/influxdb/kafka"
+)
+
+const (
+	// DefaultKafkaBatchSize is the default number of points written at once
+	// from a partition.
+	DefaultKafkaBatchSize = 1000
+
+	// DefaultKafkaBatchTimeout is the default time points wait for a batch
+	// to fill before they're written.
+	DefaultKafkaBatchTimeout = time.Second
+
+	// DefaultKafkaRetryInterval is the default time between retries of a
+	// failed fetch or write.
+	DefaultKafkaRetryInterval = time.Second
+)
+
+// KafkaConsumer reads messages from the partitions of Kafka topics and stores
+// the offsets of consumer groups. It is implemented by *kafka.Client.
+type KafkaConsumer interface {
+	Partitions(topic string) ([]int32, error)
+	Offset(topic string, partition int32, t int64) (int64, error)
+	Fetch(topic string, partition int32, offset int64) ([]kafka.Message, error)
+	FetchOffset(group, topic string, partition int32) (int64, error)
+	CommitOffset(group, topic string, partition int32, offset int64) error
+}
+
+// KafkaTopic routes the messages of a Kafka topic to a database.
+type KafkaTopic struct {
+	Name string
+
+	// The database and retention policy to write into.
+	// Uses the database's default retention policy if blank.
+	Database        string
+	RetentionPolicy string
+
+	// The format of messages: "line" for line protocol, one point per line,
+	// or "json" for the JSON write format. Defaults to line protocol.
+	Format string
+
+	// The precision of timestamps in messages. Defaults to nanoseconds.
+	Precision TimePrecision
+
+	// How timestamps are assigned to points in messages.
+	Timestamps TimestampPolicy
+}
+
+// KafkaInput consumes points from Kafka topics and writes them in batches.
+// Each partition is read separately and the offset of the consumer group is
+// only committed once the points before it have been written, so messages
+// are written at least once. Messages that cannot be parsed and points that
+// are invalid are logged and skipped.
+type KafkaInput struct {
+	server   *Server
+	consumer KafkaConsumer
+
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	closing chan struct{}
+
+	// The consumer group that offsets are committed for.
+	Group string
+
+	// The topics to read and where to write their points.
+	Topics []*KafkaTopic
+
+	// The maximum number of points written at once from a partition and
+	// the time points wait for a batch to fill.
+	BatchSize    int
+	BatchTimeout time.Duration
+
+	// The time between retries of a failed fetch or write.
+	RetryInterval time.Duration
+
+	// Where partitions without a committed offset start reading:
+	// kafka.OffsetOldest or kafka.OffsetNewest.
+	StartOffset int64
+}
+
+// NewKafkaInput returns a new instance of KafkaInput attached to a Server.
+func NewKafkaInput(s *Server, c KafkaConsumer) *KafkaInput {
+	return &KafkaInput{
+		server:        s,
+		consumer:      c,
+		BatchSize:     DefaultKafkaBatchSize,
+		BatchTimeout:  DefaultKafkaBatchTimeout,
+		RetryInterval: DefaultKafkaRetryInterval,
+		StartOffset:   kafka.OffsetOldest,
+	}
+}
+
+// Open looks up the partitions of each topic and starts reading them.
+func (k *KafkaInput) Open() error {
+	if len(k.Topics) == 0 {
+		return ErrKafkaTopicRequired
+	}
+	for _, t := range k.Topics {
+		if t.Format != "" && t.Format != "line" && t.Format != "json" {
+			return ErrInvalidKafkaFormat
+		}
+	}
+
+	// Look up every partition before reading any.
+	partitions := make([][]int32, len(k.Topics))
+	for i, t := range k.Topics {
+		a, err := k.consumer.Partitions(t.Name)
+		if err != nil {
+			return err
+		}
+		partitions[i] = a
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.closing = make(chan struct{})
+	for i, t := range k.Topics {
+		for _, p := range partitions[i] {
+			k.wg.Add(1)
+			go k.consume(t, p, k.closing)
+		}
+	}
+	return nil
+}
+
+// Close stops reading and waits for the partitions to finish. Points that
+// have been read but not written are read again when the input is reopened.
+func (k *KafkaInput) Close() error {
+	k.mu.Lock()
+	if k.closing == nil {
+		k.mu.Unlock()
+		return ErrServerClosed
+	}
+	close(k.closing)
+	k.closing = nil
+	k.mu.Unlock()
+
+	k.wg.Wait()
+	return nil
+}
+
+// consume reads a partition and writes its points until closing is closed.
+func (k *KafkaInput) consume(t *KafkaTopic, partition int32, closing <-chan struct{}) {
+	defer k.wg.Done()
+
+	// Find where the group left off.
+	var committed int64
+	for {
+		offset, err := k.offset(t, partition)
+		if err == nil {
+			committed = offset
+			break
+		}
+		k.server.Logger.Printf("kafka: %s/%d: offset: %s", t.Name, partition, err)
+		if !k.wait(closing) {
+			return
+		}
+	}
+
+	var batch []*Point
+	var started time.Time
+	next := committed
+	for {
+		select {
+		case <-closing:
+			return
+		default:
+		}
+
+		messages, err := k.consumer.Fetch(t.Name, partition, next)
+		if err == kafka.ErrOffsetOutOfRange {
+			// The messages were removed before they were read so skip ahead.
+			k.server.Logger.Printf("kafka: %s/%d: offset %d out of range", t.Name, partition, next)
+			if next, err = k.consumer.Offset(t.Name, partition, kafka.OffsetOldest); err == nil {
+				continue
+			}
+		}
+		if err != nil {
+			k.server.Logger.Printf("kafka: %s/%d: fetch: %s", t.Name, partition, err)
+			if !k.wait(closing) {
+				return
+			}
+			continue
+		}
+
+		for _, m := range messages {
+			points, err := t.parse(m.Value)
+			if err != nil {
+				k.server.Logger.Printf("kafka: %s/%d: offset %d: %s", t.Name, partition, m.Offset, err)
+			}
+			batch = append(batch, points...)
+			next = m.Offset + 1
+		}
+		if len(batch) > 0 && started.IsZero() {
+			started = time.Now()
+		}
+
+		// Write the batch once it's full or has waited long enough. Offsets
+		// of skipped messages are committed even if there is nothing to write.
+		if len(batch) >= k.BatchSize || (len(batch) > 0 && time.Since(started) >= k.BatchTimeout) || (len(batch) == 0 && next > committed) {
+			if !k.write(t, batch, closing) {
+				return
+			}
+			batch, started = nil, time.Time{}
+
+			if err := k.consumer.CommitOffset(k.Group, t.Name, partition, next); err != nil {
+				k.server.Logger.Printf("kafka: %s/%d: commit: %s", t.Name, partition, err)
+			} else {
+				committed = next
+			}
+		}
+	}
+}
+
+// offset returns the offset of the next message to read from a partition.
+func (k *KafkaInput) offset(t *KafkaTopic, partition int32) (int64, error) {
+	offset, err := k.consumer.FetchOffset(k.Group, t.Name, partition)
+	if err != nil {
+		return 0, err
+	} else if offset >= 0 {
+		return offset, nil
+	}
+	return k.consumer.Offset(t.Name, partition, k.StartOffset)
+}
+
+// write writes a batch of points, retrying until it succeeds or closing is
+// closed. Invalid points are removed from the batch. Returns false if the
+// input is closing.
+func (k *KafkaInput) write(t *KafkaTopic, batch []*Point, closing <-chan struct{}) bool {
+	k.server.CorrectTimestamps(t.Database, t.Timestamps, batch)
+	for len(batch) > 0 {
+		err := k.server.WritePoints(t.Database, t.RetentionPolicy, batch)
+		if err == nil {
+			return true
+		}
+
+		if errs, ok := err.(PointErrors); ok {
+			k.server.Logger.Printf("kafka: %s: %d invalid points: %s", t.Name, len(errs), err)
+			invalid := make(map[int]bool)
+			for _, e := range errs {
+				invalid[e.Index] = true
+			}
+			var valid []*Point
+			for i, p := range batch {
+				if !invalid[i] {
+					valid = append(valid, p)
+				}
+			}
+			batch = valid
+			continue
+		}
+
+		k.server.Logger.Printf("kafka: %s: write: %s", t.Name, err)
+		if !k.wait(closing) {
+			return false
+		}
+	}
+	return true
+}
+
+// wait waits for the retry interval. Returns false if closing is closed first.
+func (k *KafkaInput) wait(closing <-chan struct{}) bool {
+	select {
+	case <-closing:
+		return false
+	case <-time.After(k.RetryInterval):
+		return true
+	}
+}
+
+// parse returns the points in a message.
+func (t *KafkaTopic) parse(data []byte) ([]*Point, error) {
+	if t.Format == "json" {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var a []*serializedSeries
+		if err := dec.Decode(&a); err != nil {
+			return nil, err
+		}
+		return serializedSeriesSlice(a).points(t.Precision)
+	}
+
+	var points []*Point
+	now := time.Now().UTC()
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		p, err := ParseLineBytes(line, t.Precision, now)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
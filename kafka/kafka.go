@@ -0,0 +1,416 @@
+// Package kafka implements a minimal Kafka consumer client.
+//
+// The client speaks the version 0 and 1 wire protocol supported by Kafka 0.8.2
+// and later. It reads messages from single partitions and stores offsets for
+// consumer groups with the group coordinator, without joining the group.
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultClientID is the client id sent with each request.
+	DefaultClientID = "influxdb"
+
+	// DefaultTimeout is the default timeout to connect to a broker and for
+	// a broker to respond.
+	DefaultTimeout = 10 * time.Second
+
+	// DefaultMaxWait is the default time a broker waits for messages before
+	// responding to a fetch.
+	DefaultMaxWait = 500 * time.Millisecond
+
+	// DefaultMaxBytes is the default maximum size of a fetched message set.
+	DefaultMaxBytes = 1 << 20
+
+	// maxResponseSize is the largest response read from a broker.
+	maxResponseSize = 100 << 20
+)
+
+// Special times used to request offsets.
+const (
+	OffsetNewest = -1 // the offset of the next message
+	OffsetOldest = -2 // the offset of the oldest available message
+)
+
+var (
+	// ErrNoBrokers is returned when none of the client's brokers can be reached.
+	ErrNoBrokers = errors.New("kafka: no brokers available")
+
+	// ErrMessageTooLarge is returned when a message is larger than the
+	// maximum size of a fetch.
+	ErrMessageTooLarge = errors.New("kafka: message larger than max bytes")
+
+	// ErrClientClosed is returned when using a closed client.
+	ErrClientClosed = errors.New("kafka: client closed")
+)
+
+// Message represents a message read from a partition.
+type Message struct {
+	Offset int64
+	Key    []byte
+	Value  []byte
+}
+
+// Client reads messages from Kafka brokers. Connections to brokers are shared
+// and requests on the same connection are serialized. Partition leaders and
+// group coordinators are looked up when first used and again after a broker
+// fails or reports that it no longer leads a partition.
+type Client struct {
+	mu           sync.Mutex
+	addrs        []string
+	conns        map[string]*conn
+	leaders      map[string]map[int32]string // leader address by topic and partition
+	coordinators map[string]string           // coordinator address by group
+	closed       bool
+
+	// The id sent with each request.
+	ClientID string
+
+	// The timeout to connect to a broker and for a broker to respond.
+	Timeout time.Duration
+
+	// The time a broker waits for messages before responding to a fetch
+	// and the maximum size of the fetched messages.
+	MaxWait  time.Duration
+	MaxBytes int
+}
+
+// NewClient returns a new instance of Client that bootstraps from addrs.
+func NewClient(addrs []string) *Client {
+	return &Client{
+		addrs:        addrs,
+		conns:        make(map[string]*conn),
+		leaders:      make(map[string]map[int32]string),
+		coordinators: make(map[string]string),
+		ClientID:     DefaultClientID,
+		Timeout:      DefaultTimeout,
+		MaxWait:      DefaultMaxWait,
+		MaxBytes:     DefaultMaxBytes,
+	}
+}
+
+// Close closes the connections to the brokers.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for addr, cn := range c.conns {
+		cn.close()
+		delete(c.conns, addr)
+	}
+	return nil
+}
+
+// Partitions returns the sorted partitions of a topic.
+func (c *Client) Partitions(topic string) ([]int32, error) {
+	leaders, err := c.refreshMetadata(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	var a []int32
+	for p := range leaders {
+		a = append(a, p)
+	}
+	sort.Sort(int32Slice(a))
+	return a, nil
+}
+
+// Fetch returns the messages in a partition starting at offset. Returns no
+// messages if none arrive within MaxWait.
+func (c *Client) Fetch(topic string, partition int32, offset int64) ([]Message, error) {
+	data, err := c.requestLeader(topic, partition, func(id int32) []byte {
+		return encodeFetchRequest(id, c.ClientID, topic, partition, offset, int32(c.MaxWait/time.Millisecond), 1, int32(c.MaxBytes))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := decodeFetchResponse(data, topic, partition)
+	if err != nil {
+		c.checkLeader(topic, err)
+		return nil, err
+	}
+
+	// Compressed message sets can start before the requested offset.
+	for len(messages) > 0 && messages[0].Offset < offset {
+		messages = messages[1:]
+	}
+	return messages, nil
+}
+
+// Offset returns the offset of the oldest or next message in a partition.
+// t is OffsetOldest or OffsetNewest.
+func (c *Client) Offset(topic string, partition int32, t int64) (int64, error) {
+	data, err := c.requestLeader(topic, partition, func(id int32) []byte {
+		return encodeOffsetsRequest(id, c.ClientID, topic, partition, t)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	offset, err := decodeOffsetsResponse(data, topic, partition)
+	if err != nil {
+		c.checkLeader(topic, err)
+	}
+	return offset, err
+}
+
+// FetchOffset returns the offset committed by a consumer group for a
+// partition. Returns -1 if the group has not committed an offset.
+func (c *Client) FetchOffset(group, topic string, partition int32) (int64, error) {
+	data, err := c.requestCoordinator(group, func(id int32) []byte {
+		return encodeOffsetFetchRequest(id, c.ClientID, group, topic, partition)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	offset, err := decodeOffsetFetchResponse(data, topic, partition)
+	if err != nil {
+		c.checkCoordinator(group, err)
+	}
+	return offset, err
+}
+
+// CommitOffset commits the offset of the next message a consumer group
+// should read from a partition.
+func (c *Client) CommitOffset(group, topic string, partition int32, offset int64) error {
+	data, err := c.requestCoordinator(group, func(id int32) []byte {
+		return encodeOffsetCommitRequest(id, c.ClientID, group, topic, partition, offset)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = decodeOffsetCommitResponse(data, topic, partition)
+	if err != nil {
+		c.checkCoordinator(group, err)
+	}
+	return err
+}
+
+// refreshMetadata looks up the partition leaders of a topic from the first
+// bootstrap broker that responds.
+func (c *Client) refreshMetadata(topic string) (map[int32]string, error) {
+	err := error(ErrNoBrokers)
+	for _, addr := range c.addrs {
+		var data []byte
+		data, err = c.request(addr, func(id int32) []byte {
+			return encodeMetadataRequest(id, c.ClientID, []string{topic})
+		})
+		if err != nil {
+			continue
+		}
+
+		brokers, topics, err := decodeMetadataResponse(data)
+		if err != nil {
+			return nil, err
+		}
+		addrs := make(map[int32]string)
+		for _, b := range brokers {
+			addrs[b.id] = b.addr
+		}
+
+		for _, t := range topics {
+			if t.name != topic {
+				continue
+			} else if t.err != nil {
+				return nil, t.err
+			}
+
+			leaders := make(map[int32]string)
+			for _, p := range t.partitions {
+				if p.err != nil && p.err != ErrLeaderNotAvailable {
+					return nil, p.err
+				}
+				leaders[p.partition] = addrs[p.leader]
+			}
+
+			c.mu.Lock()
+			c.leaders[topic] = leaders
+			c.mu.Unlock()
+			return leaders, nil
+		}
+		return nil, ErrUnknownTopicOrPartition
+	}
+	return nil, err
+}
+
+// requestLeader sends a request to the leader of a partition.
+func (c *Client) requestLeader(topic string, partition int32, fn func(int32) []byte) ([]byte, error) {
+	c.mu.Lock()
+	addr := c.leaders[topic][partition]
+	c.mu.Unlock()
+
+	if addr == "" {
+		leaders, err := c.refreshMetadata(topic)
+		if err != nil {
+			return nil, err
+		} else if addr = leaders[partition]; addr == "" {
+			return nil, ErrLeaderNotAvailable
+		}
+	}
+
+	data, err := c.request(addr, fn)
+	if err != nil {
+		c.checkLeader(topic, err)
+	}
+	return data, err
+}
+
+// checkLeader forgets the leaders of a topic if err shows they have changed.
+func (c *Client) checkLeader(topic string, err error) {
+	switch err {
+	case ErrLeaderNotAvailable, ErrNotLeaderForPartition, ErrUnknownTopicOrPartition, ErrRequestTimedOut:
+	default:
+		if _, ok := err.(Error); ok {
+			return
+		}
+	}
+	c.mu.Lock()
+	delete(c.leaders, topic)
+	c.mu.Unlock()
+}
+
+// requestCoordinator sends a request to the coordinator of a consumer group.
+func (c *Client) requestCoordinator(group string, fn func(int32) []byte) ([]byte, error) {
+	c.mu.Lock()
+	addr := c.coordinators[group]
+	c.mu.Unlock()
+
+	if addr == "" {
+		err := error(ErrNoBrokers)
+		for _, a := range c.addrs {
+			var data []byte
+			if data, err = c.request(a, func(id int32) []byte {
+				return encodeGroupCoordinatorRequest(id, c.ClientID, group)
+			}); err != nil {
+				continue
+			}
+			if addr, err = decodeGroupCoordinatorResponse(data); err != nil {
+				return nil, err
+			}
+			break
+		}
+		if addr == "" {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		c.coordinators[group] = addr
+		c.mu.Unlock()
+	}
+
+	data, err := c.request(addr, fn)
+	if err != nil {
+		c.checkCoordinator(group, err)
+	}
+	return data, err
+}
+
+// checkCoordinator forgets the coordinator of a group if err shows it has changed.
+func (c *Client) checkCoordinator(group string, err error) {
+	switch err {
+	case ErrCoordinatorNotAvailable, ErrNotCoordinatorForConsumer:
+	default:
+		if _, ok := err.(Error); ok {
+			return
+		}
+	}
+	c.mu.Lock()
+	delete(c.coordinators, group)
+	c.mu.Unlock()
+}
+
+// request sends a request to a broker and returns the body of its response.
+// fn encodes the request with a correlation id. Connections are closed after
+// a network error so the next request reconnects.
+func (c *Client) request(addr string, fn func(int32) []byte) ([]byte, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClientClosed
+	}
+	cn := c.conns[addr]
+	if cn == nil {
+		cn = &conn{addr: addr}
+		c.conns[addr] = cn
+	}
+	c.mu.Unlock()
+
+	data, err := cn.roundTrip(fn, c.Timeout)
+	if err != nil {
+		cn.close()
+	}
+	return data, err
+}
+
+// conn represents a connection to a broker.
+type conn struct {
+	mu            sync.Mutex
+	addr          string
+	c             net.Conn
+	correlationID int32
+}
+
+// roundTrip sends a request and reads its response, connecting if necessary.
+func (cn *conn) roundTrip(fn func(int32) []byte, timeout time.Duration) ([]byte, error) {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+
+	if cn.c == nil {
+		c, err := net.DialTimeout("tcp", cn.addr, timeout)
+		if err != nil {
+			return nil, err
+		}
+		cn.c = c
+	}
+	cn.correlationID++
+	id := cn.correlationID
+
+	// Write the request and read the response size and correlation id.
+	if err := cn.c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	} else if _, err := cn.c.Write(fn(id)); err != nil {
+		return nil, err
+	}
+	var hdr [8]byte
+	if _, err := io.ReadFull(cn.c, hdr[:]); err != nil {
+		return nil, err
+	}
+	size := int(int32(binary.BigEndian.Uint32(hdr[:])))
+	if size < 4 || size > maxResponseSize || int32(binary.BigEndian.Uint32(hdr[4:])) != id {
+		return nil, errMalformed
+	}
+
+	data := make([]byte, size-4)
+	if _, err := io.ReadFull(cn.c, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// close closes the connection. The next request reconnects.
+func (cn *conn) close() {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	if cn.c != nil {
+		cn.c.Close()
+		cn.c = nil
+	}
+}
+
+type int32Slice []int32
+
+func (a int32Slice) Len() int           { return len(a) }
+func (a int32Slice) Less(i, j int) bool { return a[i] < a[j] }
+func (a int32Slice) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
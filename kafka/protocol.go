@@ -0,0 +1,515 @@
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+)
+
+// API keys of the requests used by the client.
+const (
+	apiFetch            = 1
+	apiOffsets          = 2
+	apiMetadata         = 3
+	apiOffsetCommit     = 8
+	apiOffsetFetch      = 9
+	apiGroupCoordinator = 10
+)
+
+// Compression codecs, stored in the low bits of a message's attributes.
+const (
+	codecNone = 0
+	codecGzip = 1
+)
+
+// errMalformed is returned when a response cannot be decoded.
+var errMalformed = errors.New("kafka: malformed response")
+
+// Error represents an error code returned by a broker.
+type Error int16
+
+// Error codes returned by brokers that the client handles.
+const (
+	ErrOffsetOutOfRange          Error = 1
+	ErrUnknownTopicOrPartition   Error = 3
+	ErrLeaderNotAvailable        Error = 5
+	ErrNotLeaderForPartition     Error = 6
+	ErrRequestTimedOut           Error = 7
+	ErrOffsetsLoadInProgress     Error = 14
+	ErrCoordinatorNotAvailable   Error = 15
+	ErrNotCoordinatorForConsumer Error = 16
+)
+
+// Error returns the description of the error code.
+func (e Error) Error() string {
+	switch e {
+	case ErrOffsetOutOfRange:
+		return "kafka: offset out of range"
+	case ErrUnknownTopicOrPartition:
+		return "kafka: unknown topic or partition"
+	case ErrLeaderNotAvailable:
+		return "kafka: leader not available"
+	case ErrNotLeaderForPartition:
+		return "kafka: not leader for partition"
+	case ErrRequestTimedOut:
+		return "kafka: request timed out"
+	case ErrOffsetsLoadInProgress:
+		return "kafka: offsets load in progress"
+	case ErrCoordinatorNotAvailable:
+		return "kafka: coordinator not available"
+	case ErrNotCoordinatorForConsumer:
+		return "kafka: not coordinator for consumer"
+	}
+	return fmt.Sprintf("kafka: error code %d", int16(e))
+}
+
+// errorCode returns an error for a non-zero error code.
+func errorCode(code int16) error {
+	if code == 0 {
+		return nil
+	}
+	return Error(code)
+}
+
+// encoder builds a request in the Kafka wire format. Integers are big endian,
+// strings and bytes are prefixed by their length and arrays by their count.
+type encoder struct {
+	b []byte
+}
+
+// newRequest returns an encoder with the header of a request. The size of
+// the request is filled in by bytes.
+func newRequest(apiKey, apiVersion int16, correlationID int32, clientID string) *encoder {
+	e := &encoder{b: make([]byte, 4, 64)}
+	e.int16(apiKey)
+	e.int16(apiVersion)
+	e.int32(correlationID)
+	e.string(clientID)
+	return e
+}
+
+func (e *encoder) int16(v int16) { e.b = append(e.b, byte(v>>8), byte(v)) }
+func (e *encoder) int32(v int32) {
+	e.b = append(e.b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+func (e *encoder) int64(v int64) {
+	e.int32(int32(v >> 32))
+	e.int32(int32(v))
+}
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.b = append(e.b, s...)
+}
+
+// bytes returns the encoded request with its size.
+func (e *encoder) bytes() []byte {
+	binary.BigEndian.PutUint32(e.b, uint32(len(e.b)-4))
+	return e.b
+}
+
+// decoder reads a response in the Kafka wire format. Reads past the end of
+// the data return zero values and set err.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+// next returns the next n bytes or nil if there are not enough.
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	} else if n < 0 || n > len(d.b) {
+		d.err = errMalformed
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *decoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *decoder) string() string {
+	return string(d.next(int(d.int16())))
+}
+
+// bytes returns a length prefixed byte slice. A length of -1 returns nil.
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if n == -1 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+// count returns the number of elements in an array. Counts larger than the
+// remaining data are invalid since every element is at least a byte.
+func (d *decoder) count() int {
+	n := int(d.int32())
+	if d.err == nil && (n < 0 || n > len(d.b)) {
+		d.err = errMalformed
+		return 0
+	}
+	return n
+}
+
+// broker represents the address of a broker in a metadata response.
+type broker struct {
+	id   int32
+	addr string
+}
+
+// partitionMetadata represents the leader of a partition.
+type partitionMetadata struct {
+	err       error
+	partition int32
+	leader    int32
+}
+
+// topicMetadata represents the partitions of a topic.
+type topicMetadata struct {
+	err        error
+	name       string
+	partitions []partitionMetadata
+}
+
+// encodeMetadataRequest encodes a version 0 metadata request for topics.
+func encodeMetadataRequest(correlationID int32, clientID string, topics []string) []byte {
+	e := newRequest(apiMetadata, 0, correlationID, clientID)
+	e.int32(int32(len(topics)))
+	for _, t := range topics {
+		e.string(t)
+	}
+	return e.bytes()
+}
+
+// decodeMetadataResponse decodes the body of a version 0 metadata response.
+func decodeMetadataResponse(data []byte) ([]broker, []topicMetadata, error) {
+	d := &decoder{b: data}
+
+	brokers := make([]broker, d.count())
+	for i := range brokers {
+		brokers[i].id = d.int32()
+		host := d.string()
+		brokers[i].addr = fmt.Sprintf("%s:%d", host, d.int32())
+	}
+
+	topics := make([]topicMetadata, d.count())
+	for i := range topics {
+		t := &topics[i]
+		t.err = errorCode(d.int16())
+		t.name = d.string()
+		t.partitions = make([]partitionMetadata, d.count())
+		for j := range t.partitions {
+			p := &t.partitions[j]
+			p.err = errorCode(d.int16())
+			p.partition = d.int32()
+			p.leader = d.int32()
+
+			// Skip the replicas and in-sync replicas.
+			d.next(4 * d.count())
+			d.next(4 * d.count())
+		}
+	}
+	return brokers, topics, d.err
+}
+
+// encodeFetchRequest encodes a version 0 fetch request for one partition.
+func encodeFetchRequest(correlationID int32, clientID, topic string, partition int32, offset int64, maxWait, minBytes, maxBytes int32) []byte {
+	e := newRequest(apiFetch, 0, correlationID, clientID)
+	e.int32(-1) // replica id
+	e.int32(maxWait)
+	e.int32(minBytes)
+	e.int32(1)
+	e.string(topic)
+	e.int32(1)
+	e.int32(partition)
+	e.int64(offset)
+	e.int32(maxBytes)
+	return e.bytes()
+}
+
+// decodeFetchResponse decodes the message set of a partition in the body of
+// a version 0 fetch response.
+func decodeFetchResponse(data []byte, topic string, partition int32) ([]Message, error) {
+	d := &decoder{b: data}
+	for i, n := 0, d.count(); i < n; i++ {
+		name := d.string()
+		for j, m := 0, d.count(); j < m; j++ {
+			p := d.int32()
+			code := d.int16()
+			d.int64() // high watermark
+			set := d.bytes()
+			if d.err != nil {
+				return nil, d.err
+			} else if name != topic || p != partition {
+				continue
+			} else if err := errorCode(code); err != nil {
+				return nil, err
+			}
+			return decodeMessageSet(set)
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return nil, ErrUnknownTopicOrPartition
+}
+
+// decodeMessageSet decodes the messages in a message set. Brokers may end a
+// set with a partial message, which is skipped. Returns ErrMessageTooLarge if
+// the set only holds a partial message. Compressed messages wrap a message
+// set in their value.
+func decodeMessageSet(data []byte) ([]Message, error) {
+	var messages []Message
+	for len(data) >= 12 {
+		offset := int64(binary.BigEndian.Uint64(data))
+		size := int(int32(binary.BigEndian.Uint32(data[8:])))
+		if size < 0 {
+			return nil, errMalformed
+		} else if len(data) < 12+size {
+			break
+		}
+		m := data[12 : 12+size]
+		data = data[12+size:]
+
+		// Verify the checksum of the rest of the message.
+		d := &decoder{b: m}
+		crc := uint32(d.int32())
+		if d.err != nil || crc32.ChecksumIEEE(d.b) != crc {
+			return nil, errMalformed
+		}
+
+		magic := d.int8()
+		attributes := d.int8()
+		if magic > 0 {
+			d.int64() // timestamp
+		}
+		key := d.bytes()
+		value := d.bytes()
+		if d.err != nil {
+			return nil, d.err
+		}
+
+		switch codec := attributes & 0x7; codec {
+		case codecNone:
+			messages = append(messages, Message{Offset: offset, Key: key, Value: value})
+		case codecGzip:
+			inner, err := decodeGzipMessageSet(value)
+			if err != nil {
+				return nil, err
+			}
+
+			// Since magic 1, inner offsets are relative to the first message
+			// and the wrapper has the offset of the last.
+			if magic > 0 && len(inner) > 0 {
+				base := offset - inner[len(inner)-1].Offset
+				for i := range inner {
+					inner[i].Offset += base
+				}
+			}
+			messages = append(messages, inner...)
+		default:
+			return nil, fmt.Errorf("kafka: unsupported compression codec: %d", codec)
+		}
+	}
+
+	if len(messages) == 0 && len(data) > 0 {
+		return nil, ErrMessageTooLarge
+	}
+	return messages, nil
+}
+
+// decodeGzipMessageSet decompresses and decodes a message set.
+func decodeGzipMessageSet(data []byte) ([]Message, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMessageSet(b)
+}
+
+// encodeOffsetsRequest encodes a version 0 offsets request for the last
+// offset before a time in one partition.
+func encodeOffsetsRequest(correlationID int32, clientID, topic string, partition int32, t int64) []byte {
+	e := newRequest(apiOffsets, 0, correlationID, clientID)
+	e.int32(-1) // replica id
+	e.int32(1)
+	e.string(topic)
+	e.int32(1)
+	e.int32(partition)
+	e.int64(t)
+	e.int32(1) // max number of offsets
+	return e.bytes()
+}
+
+// decodeOffsetsResponse decodes the offset of a partition in the body of a
+// version 0 offsets response.
+func decodeOffsetsResponse(data []byte, topic string, partition int32) (int64, error) {
+	d := &decoder{b: data}
+	for i, n := 0, d.count(); i < n; i++ {
+		name := d.string()
+		for j, m := 0, d.count(); j < m; j++ {
+			p := d.int32()
+			code := d.int16()
+			offsets := make([]int64, d.count())
+			for k := range offsets {
+				offsets[k] = d.int64()
+			}
+			if d.err != nil {
+				return 0, d.err
+			} else if name != topic || p != partition {
+				continue
+			} else if err := errorCode(code); err != nil {
+				return 0, err
+			} else if len(offsets) == 0 {
+				return 0, ErrOffsetOutOfRange
+			}
+			return offsets[0], nil
+		}
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return 0, ErrUnknownTopicOrPartition
+}
+
+// encodeGroupCoordinatorRequest encodes a version 0 request for the broker
+// that stores the offsets of a consumer group.
+func encodeGroupCoordinatorRequest(correlationID int32, clientID, group string) []byte {
+	e := newRequest(apiGroupCoordinator, 0, correlationID, clientID)
+	e.string(group)
+	return e.bytes()
+}
+
+// decodeGroupCoordinatorResponse decodes the address of the coordinator.
+func decodeGroupCoordinatorResponse(data []byte) (string, error) {
+	d := &decoder{b: data}
+	code := d.int16()
+	d.int32() // coordinator id
+	host := d.string()
+	port := d.int32()
+	if d.err != nil {
+		return "", d.err
+	} else if err := errorCode(code); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d", host, port), nil
+}
+
+// encodeOffsetCommitRequest encodes a version 1 request that commits the
+// offset of one partition for a consumer group. The group's generation is
+// -1 since the client doesn't join the group.
+func encodeOffsetCommitRequest(correlationID int32, clientID, group, topic string, partition int32, offset int64) []byte {
+	e := newRequest(apiOffsetCommit, 1, correlationID, clientID)
+	e.string(group)
+	e.int32(-1) // generation
+	e.string("")
+	e.int32(1)
+	e.string(topic)
+	e.int32(1)
+	e.int32(partition)
+	e.int64(offset)
+	e.int64(-1) // timestamp, set by the broker
+	e.string("")
+	return e.bytes()
+}
+
+// decodeOffsetCommitResponse decodes the result of committing an offset.
+func decodeOffsetCommitResponse(data []byte, topic string, partition int32) error {
+	d := &decoder{b: data}
+	for i, n := 0, d.count(); i < n; i++ {
+		name := d.string()
+		for j, m := 0, d.count(); j < m; j++ {
+			p := d.int32()
+			code := d.int16()
+			if d.err != nil {
+				return d.err
+			} else if name == topic && p == partition {
+				return errorCode(code)
+			}
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+	return ErrUnknownTopicOrPartition
+}
+
+// encodeOffsetFetchRequest encodes a version 1 request for the committed
+// offset of one partition for a consumer group.
+func encodeOffsetFetchRequest(correlationID int32, clientID, group, topic string, partition int32) []byte {
+	e := newRequest(apiOffsetFetch, 1, correlationID, clientID)
+	e.string(group)
+	e.int32(1)
+	e.string(topic)
+	e.int32(1)
+	e.int32(partition)
+	return e.bytes()
+}
+
+// decodeOffsetFetchResponse decodes the committed offset of a partition.
+// Returns -1 if the group has not committed an offset.
+func decodeOffsetFetchResponse(data []byte, topic string, partition int32) (int64, error) {
+	d := &decoder{b: data}
+	for i, n := 0, d.count(); i < n; i++ {
+		name := d.string()
+		for j, m := 0, d.count(); j < m; j++ {
+			p := d.int32()
+			offset := d.int64()
+			d.string() // metadata
+			code := d.int16()
+			if d.err != nil {
+				return 0, d.err
+			} else if name != topic || p != partition {
+				continue
+			} else if err := errorCode(code); err != nil && err != ErrUnknownTopicOrPartition {
+				return 0, err
+			} else if err != nil {
+				return -1, nil
+			}
+			return offset, nil
+		}
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return -1, nil
+}
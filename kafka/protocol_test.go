@@ -0,0 +1,377 @@
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// Ensure requests are encoded with a size and header.
+func TestEncodeMetadataRequest(t *testing.T) {
+	b := encodeMetadataRequest(7, "c", []string{"cpu"})
+	exp := []byte{
+		0, 0, 0, 20, // size
+		0, 3, 0, 0, // api key, version
+		0, 0, 0, 7, // correlation id
+		0, 1, 'c', // client id
+		0, 0, 0, 1, 0, 3, 'c', 'p', 'u', // topics
+	}
+	if !bytes.Equal(b, exp) {
+		t.Fatalf("unexpected request:\n\nexp=%v\n\ngot=%v", exp, b)
+	}
+}
+
+// Ensure brokers and partition leaders are decoded from a metadata response.
+func TestDecodeMetadataResponse(t *testing.T) {
+	e := &encoder{}
+	e.int32(2)
+	e.int32(1)
+	e.string("kafka1")
+	e.int32(9092)
+	e.int32(2)
+	e.string("kafka2")
+	e.int32(9093)
+	e.int32(1)
+	e.int16(0)
+	e.string("cpu")
+	e.int32(2)
+	for _, p := range []struct{ code, partition, leader int32 }{{0, 0, 1}, {5, 1, -1}} {
+		e.int16(int16(p.code))
+		e.int32(p.partition)
+		e.int32(p.leader)
+		e.int32(1) // replicas
+		e.int32(2)
+		e.int32(0) // isr
+	}
+
+	brokers, topics, err := decodeMetadataResponse(e.b)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(brokers, []broker{{1, "kafka1:9092"}, {2, "kafka2:9093"}}) {
+		t.Fatalf("unexpected brokers: %v", brokers)
+	} else if !reflect.DeepEqual(topics, []topicMetadata{{name: "cpu", partitions: []partitionMetadata{{partition: 0, leader: 1}, {err: ErrLeaderNotAvailable, partition: 1, leader: -1}}}}) {
+		t.Fatalf("unexpected topics: %v", topics)
+	}
+
+	// Truncated responses are rejected.
+	for i := 0; i < len(e.b); i++ {
+		if _, _, err := decodeMetadataResponse(e.b[:i]); err != errMalformed {
+			t.Fatalf("%d. unexpected error: %v", i, err)
+		}
+	}
+}
+
+// Ensure messages are decoded from message sets.
+func TestDecodeMessageSet(t *testing.T) {
+	var set []byte
+	set = appendMessage(set, 5, 0, 0, nil, []byte("a"))
+	set = appendMessage(set, 6, 1, 0, []byte("k"), []byte("b"))
+
+	// The inner offsets of compressed messages are relative since magic 1.
+	var inner []byte
+	inner = appendMessage(inner, 0, 1, 0, nil, []byte("c"))
+	inner = appendMessage(inner, 1, 1, 0, nil, []byte("d"))
+	set = appendMessage(set, 8, 1, codecGzip, nil, gzipBytes(inner))
+
+	// Sets can end with a partial message.
+	partial := appendMessage(nil, 9, 0, 0, nil, []byte("e"))
+	set = append(set, partial[:len(partial)-1]...)
+
+	messages, err := decodeMessageSet(set)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(messages, []Message{
+		{Offset: 5, Value: []byte("a")},
+		{Offset: 6, Key: []byte("k"), Value: []byte("b")},
+		{Offset: 7, Value: []byte("c")},
+		{Offset: 8, Value: []byte("d")},
+	}) {
+		t.Fatalf("unexpected messages: %v", messages)
+	}
+
+	// A partial message alone is larger than the fetch allows.
+	if _, err := decodeMessageSet(partial[:len(partial)-1]); err != ErrMessageTooLarge {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Corrupt messages are rejected.
+	corrupt := appendMessage(nil, 0, 0, 0, nil, []byte("a"))
+	corrupt[len(corrupt)-1] = 'b'
+	if _, err := decodeMessageSet(corrupt); err != errMalformed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Unsupported codecs are rejected.
+	if _, err := decodeMessageSet(appendMessage(nil, 0, 0, 2, nil, []byte("a"))); err == nil || err.Error() != "kafka: unsupported compression codec: 2" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the message set of a partition is decoded from a fetch response.
+func TestDecodeFetchResponse(t *testing.T) {
+	set := appendMessage(nil, 3, 0, 0, nil, []byte("a"))
+	data := fetchResponse("cpu", 0, 0, set)
+
+	if messages, err := decodeFetchResponse(data, "cpu", 0); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(messages, []Message{{Offset: 3, Value: []byte("a")}}) {
+		t.Fatalf("unexpected messages: %v", messages)
+	}
+	if _, err := decodeFetchResponse(data, "cpu", 1); err != ErrUnknownTopicOrPartition {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := decodeFetchResponse(fetchResponse("cpu", 0, 1, nil), "cpu", 0); err != ErrOffsetOutOfRange {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure offsets are decoded from offset and offset fetch responses.
+func TestDecodeOffsetResponses(t *testing.T) {
+	e := &encoder{}
+	e.int32(1)
+	e.string("cpu")
+	e.int32(1)
+	e.int32(0)
+	e.int16(0)
+	e.int32(1)
+	e.int64(42)
+	if offset, err := decodeOffsetsResponse(e.b, "cpu", 0); err != nil || offset != 42 {
+		t.Fatalf("unexpected offset: %d, %v", offset, err)
+	}
+
+	// Offset fetch responses return -1 for groups without an offset.
+	for _, tt := range []struct {
+		code   int16
+		offset int64
+		exp    int64
+		err    error
+	}{
+		{code: 0, offset: 10, exp: 10},
+		{code: 0, offset: -1, exp: -1},
+		{code: 3, offset: -1, exp: -1},
+		{code: 16, err: ErrNotCoordinatorForConsumer},
+	} {
+		e := &encoder{}
+		e.int32(1)
+		e.string("cpu")
+		e.int32(1)
+		e.int32(0)
+		e.int64(tt.offset)
+		e.string("")
+		e.int16(tt.code)
+		if offset, err := decodeOffsetFetchResponse(e.b, "cpu", 0); err != tt.err || offset != tt.exp {
+			t.Errorf("%d: unexpected offset: %d, %v", tt.code, offset, err)
+		}
+	}
+}
+
+// Ensure the client reads messages and commits offsets through a broker.
+func TestClient(t *testing.T) {
+	b := newTestBroker(t)
+	defer b.Close()
+
+	c := NewClient([]string{b.Addr().String()})
+	defer c.Close()
+
+	if partitions, err := c.Partitions("cpu"); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(partitions, []int32{0, 1}) {
+		t.Fatalf("unexpected partitions: %v", partitions)
+	}
+
+	if offset, err := c.Offset("cpu", 1, OffsetOldest); err != nil || offset != 5 {
+		t.Fatalf("unexpected offset: %d, %v", offset, err)
+	}
+
+	if messages, err := c.Fetch("cpu", 1, 6); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(messages, []Message{{Offset: 6, Value: []byte("b")}}) {
+		t.Fatalf("unexpected messages: %v", messages)
+	}
+
+	if offset, err := c.FetchOffset("influxdb", "cpu", 1); err != nil || offset != -1 {
+		t.Fatalf("unexpected offset: %d, %v", offset, err)
+	}
+	if err := c.CommitOffset("influxdb", "cpu", 1, 7); err != nil {
+		t.Fatal(err)
+	} else if offset, err := c.FetchOffset("influxdb", "cpu", 1); err != nil || offset != 7 {
+		t.Fatalf("unexpected offset: %d, %v", offset, err)
+	}
+
+	// Requests after closing fail.
+	c.Close()
+	if _, err := c.Fetch("cpu", 1, 6); err != ErrClientClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// testBroker is a broker with a "cpu" topic with two partitions. Partition 1
+// holds messages at offsets 5 and 6. Committed offsets are kept in memory.
+type testBroker struct {
+	net.Listener
+	t         *testing.T
+	committed int64
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &testBroker{Listener: l, t: t, committed: -1}
+	go b.serve()
+	return b
+}
+
+func (b *testBroker) serve() {
+	for {
+		conn, err := b.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+// handle responds to each request on a connection.
+func (b *testBroker) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(hdr[:]))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+
+		d := &decoder{b: req}
+		apiKey := d.int16()
+		d.int16() // version
+		id := d.int32()
+		d.string() // client id
+
+		host, port, _ := net.SplitHostPort(b.Addr().String())
+		n, _ := strconv.Atoi(port)
+
+		resp := &encoder{b: make([]byte, 4)}
+		resp.int32(id)
+		switch apiKey {
+		case apiMetadata:
+			resp.int32(1)
+			resp.int32(1)
+			resp.string(host)
+			resp.int32(int32(n))
+			resp.int32(1)
+			resp.int16(0)
+			resp.string("cpu")
+			resp.int32(2)
+			for p := int32(0); p < 2; p++ {
+				resp.int16(0)
+				resp.int32(p)
+				resp.int32(1)
+				resp.int32(0)
+				resp.int32(0)
+			}
+		case apiOffsets:
+			resp.int32(1)
+			resp.string("cpu")
+			resp.int32(1)
+			resp.int32(1)
+			resp.int16(0)
+			resp.int32(1)
+			resp.int64(5)
+		case apiFetch:
+			set := appendMessage(nil, 5, 0, 0, nil, []byte("a"))
+			set = appendMessage(set, 6, 0, 0, nil, []byte("b"))
+			resp.b = append(resp.b, fetchResponse("cpu", 1, 0, set)...)
+		case apiGroupCoordinator:
+			resp.int16(0)
+			resp.int32(1)
+			resp.string(host)
+			resp.int32(int32(n))
+		case apiOffsetCommit:
+			d.string() // group
+			d.int32()  // generation
+			d.string() // member
+			d.int32()
+			d.string()
+			d.int32()
+			d.int32()
+			b.committed = d.int64()
+
+			resp.int32(1)
+			resp.string("cpu")
+			resp.int32(1)
+			resp.int32(1)
+			resp.int16(0)
+		case apiOffsetFetch:
+			resp.int32(1)
+			resp.string("cpu")
+			resp.int32(1)
+			resp.int32(1)
+			resp.int64(b.committed)
+			resp.string("")
+			resp.int16(0)
+		default:
+			b.t.Errorf("unexpected api key: %d", apiKey)
+			return
+		}
+		if _, err := conn.Write(resp.bytes()); err != nil {
+			return
+		}
+	}
+}
+
+// fetchResponse returns a fetch response body with a single partition.
+func fetchResponse(topic string, partition int32, code int16, set []byte) []byte {
+	e := &encoder{}
+	e.int32(1)
+	e.string(topic)
+	e.int32(1)
+	e.int32(partition)
+	e.int16(code)
+	e.int64(0) // high watermark
+	e.int32(int32(len(set)))
+	e.b = append(e.b, set...)
+	return e.b
+}
+
+// appendMessage appends a message with its offset and size to a message set.
+func appendMessage(b []byte, offset int64, magic, attributes int8, key, value []byte) []byte {
+	m := &encoder{b: make([]byte, 4)}
+	m.b = append(m.b, byte(magic), byte(attributes))
+	if magic > 0 {
+		m.int64(0) // timestamp
+	}
+	for _, v := range [][]byte{key, value} {
+		if v == nil {
+			m.int32(-1)
+		} else {
+			m.int32(int32(len(v)))
+			m.b = append(m.b, v...)
+		}
+	}
+	binary.BigEndian.PutUint32(m.b, crc32.ChecksumIEEE(m.b[4:]))
+
+	e := &encoder{b: b}
+	e.int64(offset)
+	e.int32(int32(len(m.b)))
+	return append(e.b, m.b...)
+}
+
+// gzipBytes returns b compressed with gzip.
+func gzipBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
@@ -0,0 +1,196 @@
+package influxdb_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/kafka"
+)
+
+// Ensure the Kafka input writes the points of each topic to its database and
+// commits the offsets of written messages.
+func TestKafkaInput(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateDatabase("bar")
+	s.CreateRetentionPolicy("bar", &influxdb.RetentionPolicy{Name: "raw", Duration: time.Hour})
+
+	consumer := NewKafkaConsumer()
+	consumer.messages["cpu"] = []string{
+		"cpu,host=serverA value=1 946684800000000000\ncpu,host=serverB value=2 946684800000000000",
+		"cpu,host=serverA value=bad",
+		"cpu,host=serverA value=3 946684801000000000",
+	}
+	consumer.messages["mem"] = []string{
+		`[{"name":"mem","columns":["time","value"],"points":[[946684800,10]]}]`,
+	}
+	consumer.committed["mem"] = -1
+
+	k := influxdb.NewKafkaInput(s.Server, consumer)
+	k.Group = "influxdb"
+	k.Topics = []*influxdb.KafkaTopic{
+		{Name: "cpu", Database: "foo"},
+		{Name: "mem", Database: "bar", RetentionPolicy: "raw", Format: "json", Precision: influxdb.SecondPrecision},
+	}
+	k.BatchTimeout = 10 * time.Millisecond
+	k.RetryInterval = 10 * time.Millisecond
+	if err := k.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for every message to be committed.
+	consumer.waitCommitted(t, map[string]int64{"cpu": 3, "mem": 1})
+	if err := k.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s.Sync(c.index)
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu GROUP BY host`), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if s := mustMarshalJSON(results[0].Rows); s != `[{"name":"cpu","tags":{"host":"serverA"},"columns":["time","sum"],"values":[[0,4]]},{"name":"cpu","tags":{"host":"serverB"},"columns":["time","sum"],"values":[[0,2]]}]` {
+		t.Fatalf("unexpected rows: %s", s)
+	}
+
+	results = s.ExecuteQuery(MustParseQuery(`SELECT value FROM mem`), "bar", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if s := mustMarshalJSON(results[0].Rows); s != `[{"name":"mem","columns":["time","value"],"values":[[946684800000000,10]]}]` {
+		t.Fatalf("unexpected rows: %s", s)
+	}
+}
+
+// Ensure the Kafka input resumes from the committed offset of its group.
+func TestKafkaInput_CommittedOffset(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	consumer := NewKafkaConsumer()
+	consumer.messages["cpu"] = []string{
+		"cpu value=1 946684800000000000",
+		"cpu value=2 946684800000000000",
+	}
+	consumer.committed["cpu"] = 1
+
+	k := influxdb.NewKafkaInput(s.Server, consumer)
+	k.Topics = []*influxdb.KafkaTopic{{Name: "cpu", Database: "foo"}}
+	k.BatchTimeout = 10 * time.Millisecond
+	if err := k.Open(); err != nil {
+		t.Fatal(err)
+	}
+	consumer.waitCommitted(t, map[string]int64{"cpu": 2})
+	k.Close()
+	s.Sync(c.index)
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if s := mustMarshalJSON(results[0].Rows); s != `[{"name":"cpu","columns":["time","sum"],"values":[[0,2]]}]` {
+		t.Fatalf("unexpected rows: %s", s)
+	}
+}
+
+// Ensure the Kafka input rejects invalid configurations.
+func TestKafkaInput_Open_Invalid(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	k := influxdb.NewKafkaInput(s.Server, NewKafkaConsumer())
+	if err := k.Open(); err != influxdb.ErrKafkaTopicRequired {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	k.Topics = []*influxdb.KafkaTopic{{Name: "cpu", Database: "foo", Format: "csv"}}
+	if err := k.Open(); err != influxdb.ErrInvalidKafkaFormat {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := k.Close(); err != influxdb.ErrServerClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// KafkaConsumer is a mock consumer with a single partition for each topic.
+type KafkaConsumer struct {
+	mu        sync.Mutex
+	messages  map[string][]string
+	committed map[string]int64
+}
+
+// NewKafkaConsumer returns a new instance of KafkaConsumer.
+func NewKafkaConsumer() *KafkaConsumer {
+	return &KafkaConsumer{messages: make(map[string][]string), committed: make(map[string]int64)}
+}
+
+func (c *KafkaConsumer) Partitions(topic string) ([]int32, error) { return []int32{0}, nil }
+
+func (c *KafkaConsumer) Offset(topic string, partition int32, t int64) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t == kafka.OffsetNewest {
+		return int64(len(c.messages[topic])), nil
+	}
+	return 0, nil
+}
+
+// Fetch returns the messages after offset. Waits briefly if there are none,
+// like a broker.
+func (c *KafkaConsumer) Fetch(topic string, partition int32, offset int64) ([]kafka.Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var a []kafka.Message
+	for i := offset; i < int64(len(c.messages[topic])); i++ {
+		a = append(a, kafka.Message{Offset: i, Value: []byte(c.messages[topic][i])})
+	}
+	if len(a) == 0 {
+		c.mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		c.mu.Lock()
+	}
+	return a, nil
+}
+
+func (c *KafkaConsumer) FetchOffset(group, topic string, partition int32) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if offset, ok := c.committed[topic]; ok {
+		return offset, nil
+	}
+	return -1, nil
+}
+
+func (c *KafkaConsumer) CommitOffset(group, topic string, partition int32, offset int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.committed[topic] = offset
+	return nil
+}
+
+// waitCommitted waits for the committed offsets of topics to reach their
+// expected values.
+func (c *KafkaConsumer) waitCommitted(t *testing.T, exp map[string]int64) {
+	for i := 0; i < 500; i++ {
+		c.mu.Lock()
+		done := true
+		for topic, offset := range exp {
+			if c.committed[topic] != offset {
+				done = false
+			}
+		}
+		c.mu.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("offsets not committed: %v", c.committed)
+}
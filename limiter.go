@@ -0,0 +1,84 @@
+package influxdb
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// UserLimits represents the request limits enforced by the handler.
+// Rates are tracked separately for each authenticated user.
+// A value of zero means that there is no limit.
+type UserLimits struct {
+	// The number of statements a user can execute per minute.
+	QueriesPerMinute int
+
+	// The number of points a user can write per second.
+	PointsPerSecond int
+
+	// The number of points a single statement can read.
+	MaxPointsScanned int
+
+	// The number of bytes a single statement can allocate.
+	MaxQueryMemory int64
+
+	// The number of values returned for each series of a statement.
+	// Series with more values are truncated and marked as partial.
+	MaxRowLimit int
+
+	// The time the statements of a single request can run. Statements
+	// still running once it passes return ErrQueryTimeout.
+	QueryTimeout time.Duration
+}
+
+// rateLimiter tracks a token bucket for each key.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// bucket represents the available tokens for a key.
+type bucket struct {
+	tokens float64   // tokens available as of last
+	last   time.Time // last time the bucket was refilled
+}
+
+// newRateLimiter returns a new instance of rateLimiter.
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*bucket)}
+}
+
+// take removes n tokens from the bucket for key. Buckets refill at rate
+// tokens per second and hold at most burst tokens. A full bucket always
+// allows a request, even one larger than the burst, so large batches are
+// throttled instead of rejected forever.
+//
+// Returns zero if the tokens were taken. Otherwise returns how long to wait
+// before the tokens will be available.
+func (l *rateLimiter) take(key string, n, rate, burst float64, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Create a full bucket for new keys.
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+
+	// Refill the bucket based on the time elapsed.
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed.Seconds()*rate)
+		b.last = now
+	}
+
+	// Take the tokens if enough are available.
+	if b.tokens >= n || b.tokens >= burst {
+		b.tokens -= n
+		return 0
+	}
+
+	// Otherwise determine how long until enough tokens are available.
+	need := math.Min(n, burst) - b.tokens
+	return time.Duration(need / rate * float64(time.Second))
+}
@@ -0,0 +1,34 @@
+package influxdb
+
+import (
+	"testing"
+	"time"
+)
+
+// Ensure the rate limiter allows requests within the rate and reports how long to wait otherwise.
+func TestRateLimiter_Take(t *testing.T) {
+	l := newRateLimiter()
+	now := time.Unix(0, 0)
+
+	for i, tt := range []struct {
+		key     string
+		n       float64
+		elapsed time.Duration
+		exp     time.Duration
+	}{
+		{key: "foo", n: 5, exp: 0},                            // full bucket
+		{key: "foo", n: 6, exp: 1 * time.Second},              // wait for one token
+		{key: "foo", n: 6, elapsed: 1 * time.Second, exp: 0},  // refilled
+		{key: "foo", n: 1, exp: 1 * time.Second},              // empty bucket
+		{key: "bar", n: 1, exp: 0},                            // separate bucket
+		{key: "foo", n: 20, elapsed: time.Hour, exp: 0},       // oversized batch on full bucket
+		{key: "foo", n: 20, exp: 20 * time.Second},            // wait for a full bucket
+		{key: "foo", n: 1, elapsed: 11 * time.Second, exp: 0}, // bucket back above zero
+		{key: "foo", n: 1, elapsed: 100 * time.Millisecond, exp: 900 * time.Millisecond},
+	} {
+		now = now.Add(tt.elapsed)
+		if d := l.take(tt.key, tt.n, 1, 10, now); d != tt.exp {
+			t.Errorf("%d. unexpected wait: %s, expected %s", i, d, tt.exp)
+		}
+	}
+}
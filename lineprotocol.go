@@ -0,0 +1,217 @@
+package influxdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// errDanglingEscape is returned when a line ends with an unpaired backslash.
+var errDanglingEscape = errors.New("dangling escape")
+
+// ParseLine parses a point written in line protocol:
+//
+//	measurement[,tag=value...] field=value[,field=value...] [timestamp]
+//
+// Commas, spaces and equal signs in names, keys and tag values are escaped
+// with a backslash. String field values are double quoted, integers have an
+// "i" suffix, unsigned integers have a "u" suffix and booleans are t, true, f
+// or false in any case. The timestamp is an integer in the given precision and
+// points without a timestamp are assigned now.
+func ParseLine(line string, precision TimePrecision, now time.Time) (*Point, error) {
+	return ParseLineBytes([]byte(line), precision, now)
+}
+
+// ParseLineBytes parses a point written in line protocol from a byte slice.
+// The line is scanned in place and values are only copied when they are
+// stored in the point. The name and tags share a single copy of the series
+// key unless they are escaped. The slice is not retained.
+func ParseLineBytes(buf []byte, precision TimePrecision, now time.Time) (*Point, error) {
+	buf = bytes.TrimSpace(buf)
+
+	// Escapes pair a backslash with the next byte so only a backslash at the
+	// end of the line can be unpaired.
+	if danglingEscape(buf) {
+		return nil, fmt.Errorf("invalid line: %q: %s", buf, errDanglingEscape)
+	}
+
+	// Split the line into the key, fields and optional timestamp.
+	i := scanUnescaped(buf, 0, ' ', false)
+	if i == len(buf) {
+		return nil, fmt.Errorf("missing fields: %q", buf)
+	}
+	key := buf[:i]
+	for i < len(buf) && buf[i] == ' ' {
+		i++
+	}
+	j := scanUnescaped(buf, i, ' ', true)
+	fields, ts := buf[i:j], bytes.TrimSpace(buf[j:])
+
+	// Parse the measurement name and tags. The key is copied once and the
+	// name and tags are sliced from the copy.
+	p := &Point{Timestamp: now, Values: make(map[string]interface{})}
+	keyString := string(key)
+	i = scanUnescaped(key, 0, ',', false)
+	p.Name = unescapeKey(keyString[:i])
+	if p.Name == "" {
+		return nil, fmt.Errorf("missing measurement: %q", buf)
+	}
+	for start := i + 1; start <= len(key); {
+		end := scanUnescaped(key, start, ',', false)
+		eq := scanUnescaped(key[:end], start, '=', false)
+		if eq == end || eq == start || eq == end-1 {
+			return nil, fmt.Errorf("invalid tag: %q", key[start:end])
+		}
+		if p.Tags == nil {
+			p.Tags = make(map[string]string)
+		}
+
+		k, v := unescapeKey(keyString[start:eq]), keyString[eq+1:end]
+		if v[0] != '"' {
+			v = unescapeKey(v)
+		}
+		p.Tags[k] = v
+		start = end + 1
+	}
+
+	// Parse the field values.
+	for start := 0; start <= len(fields); {
+		end := scanUnescaped(fields, start, ',', true)
+		eq := scanUnescaped(fields[:end], start, '=', false)
+		if eq == end || eq == start {
+			return nil, fmt.Errorf("invalid field: %q", fields[start:end])
+		}
+		value, err := parseFieldValue(fields[eq+1 : end])
+		if err != nil {
+			return nil, fmt.Errorf("invalid field: %q: %s", fields[start:end], err)
+		}
+		p.Values[unescapeKey(string(fields[start:eq]))] = value
+		start = end + 1
+	}
+
+	// Parse the timestamp, if present.
+	if len(ts) > 0 {
+		n, err := strconv.ParseInt(unsafeString(ts), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp: %q", ts)
+		}
+		p.Timestamp = precision.Time(n)
+	}
+	return p, nil
+}
+
+// parseFieldValue parses a quoted string, integer, unsigned integer, boolean
+// or float value.
+func parseFieldValue(b []byte) (interface{}, error) {
+	switch {
+	case len(b) == 0:
+		return nil, fmt.Errorf("missing value")
+	case b[0] == '"':
+		if len(b) < 2 || b[len(b)-1] != '"' || scanUnescaped(b, 1, '"', false) != len(b)-1 {
+			return nil, fmt.Errorf("unterminated string")
+		}
+		return unescapeString(b[1 : len(b)-1]), nil
+	case b[len(b)-1] == 'i':
+		n, err := strconv.ParseInt(unsafeString(b[:len(b)-1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer")
+		}
+		return n, nil
+	case b[len(b)-1] == 'u':
+		n, err := strconv.ParseUint(unsafeString(b[:len(b)-1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid unsigned integer")
+		}
+		return n, nil
+	}
+
+	switch string(b) {
+	case "t", "T", "true", "True", "TRUE":
+		return true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, nil
+	}
+
+	f, err := strconv.ParseFloat(unsafeString(b), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number")
+	}
+	return f, nil
+}
+
+// scanUnescaped returns the index of the first c in b that is not escaped
+// with a backslash, starting at i. Bytes inside double quotes are skipped if
+// quoted is true. Returns len(b) if none is found.
+func scanUnescaped(b []byte, i int, c byte, quoted bool) int {
+	var inQuote bool
+	for ; i < len(b); i++ {
+		switch ch := b[i]; {
+		case ch == '\\':
+			i++
+		case ch == '"' && quoted:
+			inQuote = !inQuote
+		case ch == c && !inQuote:
+			return i
+		}
+	}
+	return len(b)
+}
+
+// danglingEscape returns true if b ends with an unpaired backslash.
+func danglingEscape(b []byte) bool {
+	n := 0
+	for i := len(b) - 1; i >= 0 && b[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// unescapeKey returns a name, key or tag value with its escaping removed.
+// Backslashes that do not escape a comma, space or equal sign are kept.
+func unescapeKey(s string) string {
+	if strings.IndexByte(s, '\\') == -1 {
+		return s
+	}
+
+	a := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case ',', ' ', '=':
+				i++
+			}
+		}
+		a = append(a, s[i])
+	}
+	return string(a)
+}
+
+// unescapeString returns a string field value with its escaping removed.
+// Backslashes that do not escape a quote or backslash are kept.
+func unescapeString(b []byte) string {
+	if bytes.IndexByte(b, '\\') == -1 {
+		return string(b)
+	}
+
+	a := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] == '\\' && i+1 < len(b) {
+			switch b[i+1] {
+			case '"', '\\':
+				i++
+			}
+		}
+		a = append(a, b[i])
+	}
+	return string(a)
+}
+
+// unsafeString returns b as a string without copying it. The string must not
+// be retained, so it's only used to pass numbers to strconv.
+func unsafeString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
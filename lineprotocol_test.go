@@ -0,0 +1,266 @@
+package influxdb_test
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/influxdb/influxdb"
+)
+
+// Ensure lines of line protocol can be parsed into points.
+func TestParseLine(t *testing.T) {
+	now := time.Unix(100, 0).UTC()
+
+	for i, tt := range []struct {
+		line  string
+		point *influxdb.Point
+		err   string
+	}{
+		{
+			line:  `cpu value=1`,
+			point: &influxdb.Point{Name: "cpu", Timestamp: now, Values: map[string]interface{}{"value": float64(1)}},
+		},
+		{
+			line: `cpu,host=serverA,region=us-west value=1.5,count=3i,bytes=18446744073709551615u,ok=t,msg="hello, world" 1000000000`,
+			point: &influxdb.Point{
+				Name:      "cpu",
+				Tags:      map[string]string{"host": "serverA", "region": "us-west"},
+				Timestamp: time.Unix(1, 0).UTC(),
+				Values:    map[string]interface{}{"value": 1.5, "count": int64(3), "bytes": uint64(18446744073709551615), "ok": true, "msg": "hello, world"},
+			},
+		},
+		{
+			line: `disk\ usage,path=/var\,log,a\=b=c free=FALSE,note="say \"hi\" \\ bye"`,
+			point: &influxdb.Point{
+				Name:      "disk usage",
+				Tags:      map[string]string{"path": "/var,log", "a=b": "c"},
+				Timestamp: now,
+				Values:    map[string]interface{}{"free": false, "note": `say "hi" \ bye`},
+			},
+		},
+		{
+			line:  `  cpu  value=-2e3   5  `,
+			point: &influxdb.Point{Name: "cpu", Timestamp: time.Unix(0, 5).UTC(), Values: map[string]interface{}{"value": -2e3}},
+		},
+
+		{line: `cpu`, err: `missing fields: "cpu"`},
+		{line: `,host=a value=1`, err: `missing measurement: ",host=a value=1"`},
+		{line: `cpu,host value=1`, err: `invalid tag: "host"`},
+		{line: `cpu,host= value=1`, err: `invalid tag: "host="`},
+		{line: `cpu value`, err: `invalid field: "value"`},
+		{line: `cpu value=`, err: `invalid field: "value=": missing value`},
+		{line: `cpu value=abc`, err: `invalid field: "value=abc": invalid number`},
+		{line: `cpu value=1.5i`, err: `invalid field: "value=1.5i": invalid integer`},
+		{line: `cpu value=9223372036854775808i`, err: `invalid field: "value=9223372036854775808i": invalid integer`},
+		{line: `cpu value=-1u`, err: `invalid field: "value=-1u": invalid unsigned integer`},
+		{line: `cpu value="abc`, err: `invalid field: "value=\"abc": unterminated string`},
+		{line: `cpu value=1 abc`, err: `invalid timestamp: "abc"`},
+		{line: `cpu value="abc\"`, err: `invalid field: "value=\"abc\\\"": unterminated string`},
+		{line: `cpu value=1\`, err: `invalid line: "cpu value=1\\": dangling escape`},
+		{line: `cpu,host=a value=1 1\\`, err: `invalid timestamp: "1\\\\"`},
+	} {
+		p, err := influxdb.ParseLine(tt.line, influxdb.NanosecondPrecision, now)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%v", i, tt.line, tt.err, err)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%d. %s: unexpected error: %s", i, tt.line, err)
+		} else if !reflect.DeepEqual(p, tt.point) {
+			t.Errorf("%d. %s: point mismatch:\n  exp=%#v\n  got=%#v", i, tt.line, tt.point, p)
+		}
+	}
+}
+
+// Ensure line timestamps are read in the given precision.
+func TestParseLine_Precision(t *testing.T) {
+	p, err := influxdb.ParseLine(`cpu value=1 1420070400`, influxdb.SecondPrecision, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	} else if !p.Timestamp.Equal(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected timestamp: %s", p.Timestamp)
+	}
+}
+
+// Ensure escaped separators inside quoted strings do not split fields.
+func TestParseLine_QuotedSeparators(t *testing.T) {
+	p, err := influxdb.ParseLine(`log msg="a b,c=d",n=1`, influxdb.NanosecondPrecision, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	} else if p.Values["msg"] != "a b,c=d" || p.Values["n"] != float64(1) {
+		t.Fatalf("unexpected values: %v", p.Values)
+	} else if strings.Contains(p.Name, " ") {
+		t.Fatalf("unexpected name: %s", p.Name)
+	}
+}
+
+// Ensure lines can be parsed from byte slices without retaining them.
+func TestParseLineBytes(t *testing.T) {
+	buf := []byte(`cpu,host=serverA value=1,msg="ok" 10`)
+	p, err := influxdb.ParseLineBytes(buf, influxdb.NanosecondPrecision, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Overwrite the buffer to ensure the point doesn't reference it.
+	for i := range buf {
+		buf[i] = 'x'
+	}
+	if !reflect.DeepEqual(p, &influxdb.Point{
+		Name:      "cpu",
+		Tags:      map[string]string{"host": "serverA"},
+		Timestamp: time.Unix(0, 10).UTC(),
+		Values:    map[string]interface{}{"value": float64(1), "msg": "ok"},
+	}) {
+		t.Fatalf("unexpected point: %#v", p)
+	}
+}
+
+// Ensure that random points can be written as lines and parsed back.
+func TestParseLine_Quick(t *testing.T) {
+	f := func(tp testLinePoint) bool {
+		line := tp.Line()
+		p, err := influxdb.ParseLineBytes([]byte(line), influxdb.NanosecondPrecision, time.Now())
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", line, err)
+		} else if !reflect.DeepEqual(p, tp.Point) {
+			t.Fatalf("%s: mismatch:\n\nexp: %#v\n\ngot: %#v\n\n", line, tp.Point, p)
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// Ensure that corrupt lines return an error instead of panicking.
+func TestParseLine_Quick_Corrupt(t *testing.T) {
+	f := func(tp testLinePoint, n uint8, c byte) bool {
+		// Truncate the line and overwrite one of its bytes.
+		buf := []byte(tp.Line())
+		buf = buf[:int(n)%len(buf)+1]
+		buf[int(n)%len(buf)] = c
+		influxdb.ParseLineBytes(buf, influxdb.NanosecondPrecision, time.Now())
+		return true
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 10000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func BenchmarkParseLine_Simple(b *testing.B) {
+	benchmarkParseLine(b, `cpu value=1 1420070400000000000`)
+}
+
+func BenchmarkParseLine_Tags(b *testing.B) {
+	benchmarkParseLine(b, `cpu,host=serverA,region=us-west,dc=dc1 value=1.5,count=3i,ok=t 1420070400000000000`)
+}
+
+func BenchmarkParseLine_String(b *testing.B) {
+	benchmarkParseLine(b, `log,host=serverA msg="GET /index.html HTTP/1.1 200",bytes=1024i 1420070400000000000`)
+}
+
+func BenchmarkParseLine_Escaped(b *testing.B) {
+	benchmarkParseLine(b, `disk\ usage,path=/var\,log free=1,note="say \"hi\"" 1420070400000000000`)
+}
+
+func benchmarkParseLine(b *testing.B, line string) {
+	buf := []byte(line)
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := influxdb.ParseLineBytes(buf, influxdb.NanosecondPrecision, time.Time{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// testLinePoint is a randomly generated point that can be written as a line.
+type testLinePoint struct {
+	*influxdb.Point
+}
+
+// Generate returns a random point. Names, keys and tag values include the
+// characters that must be escaped.
+func (testLinePoint) Generate(rand *rand.Rand, size int) reflect.Value {
+	p := &influxdb.Point{
+		Name:      randLineKey(rand),
+		Timestamp: time.Unix(0, rand.Int63()).UTC(),
+		Values:    make(map[string]interface{}),
+	}
+	for i, n := 0, rand.Intn(4); i < n; i++ {
+		if p.Tags == nil {
+			p.Tags = make(map[string]string)
+		}
+		p.Tags[randLineKey(rand)] = randLineKey(rand)
+	}
+	for i, n := 0, rand.Intn(4)+1; i < n; i++ {
+		switch rand.Intn(4) {
+		case 0:
+			p.Values[randLineKey(rand)] = rand.NormFloat64() * 1e6
+		case 1:
+			p.Values[randLineKey(rand)] = float64(rand.Int63n(1 << 53))
+		case 2:
+			p.Values[randLineKey(rand)] = rand.Intn(2) == 0
+		default:
+			v, _ := quick.Value(reflect.TypeOf(""), rand)
+			p.Values[randLineKey(rand)] = v.String() + `"\ ,=`
+		}
+	}
+	return reflect.ValueOf(testLinePoint{p})
+}
+
+// Line returns the point written in line protocol.
+func (tp testLinePoint) Line() string {
+	var buf bytes.Buffer
+	buf.WriteString(escapeLineKey(tp.Name))
+	for k, v := range tp.Tags {
+		buf.WriteString("," + escapeLineKey(k) + "=" + escapeLineKey(v))
+	}
+
+	i := 0
+	for k, v := range tp.Values {
+		if i > 0 {
+			buf.WriteByte(',')
+		} else {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(escapeLineKey(k) + "=")
+		switch v := v.(type) {
+		case float64:
+			buf.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+		case bool:
+			buf.WriteString(strconv.FormatBool(v))
+		case string:
+			buf.WriteString(`"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`)
+		}
+		i++
+	}
+
+	buf.WriteString(" " + strconv.FormatInt(tp.Timestamp.UnixNano(), 10))
+	return buf.String()
+}
+
+// randLineKey returns a random name, key or tag value.
+func randLineKey(rand *rand.Rand) string {
+	const chars = "abcxyzABC0189._- ,="
+	b := make([]byte, rand.Intn(8)+1)
+	for i := range b {
+		b[i] = chars[rand.Intn(len(chars))]
+	}
+	return string(b)
+}
+
+// escapeLineKey escapes the commas, spaces and equal signs in a name, key or
+// tag value.
+func escapeLineKey(s string) string {
+	return strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`).Replace(s)
+}
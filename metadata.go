@@ -0,0 +1,334 @@
+package influxdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Metadata represents a declarative description of the databases, retention
+// policies and users of a cluster. It can be dumped from a server, kept under
+// version control and applied to bring a server in line with it.
+type Metadata struct {
+	Databases []*DatabaseMetadata `json:"databases"`
+	Users     []*UserMetadata     `json:"users"`
+}
+
+// DatabaseMetadata represents a database in a metadata description.
+type DatabaseMetadata struct {
+	Name                   string                     `json:"name"`
+	ReadOnly               bool                       `json:"readOnly,omitempty"`
+	Disabled               bool                       `json:"disabled,omitempty"`
+	DefaultRetentionPolicy string                     `json:"defaultRetentionPolicy,omitempty"`
+	RetentionPolicies      []*RetentionPolicyMetadata `json:"retentionPolicies,omitempty"`
+}
+
+// RetentionPolicyMetadata represents a retention policy in a metadata description.
+type RetentionPolicyMetadata struct {
+	Name     string `json:"name"`
+	Duration string `json:"duration"` // such as "7d"
+	ReplicaN uint32 `json:"replicaN,omitempty"`
+}
+
+// UserMetadata represents a user in a metadata description. Passwords are
+// never dumped and are only used when creating a user.
+type UserMetadata struct {
+	Name     string `json:"name"`
+	Admin    bool   `json:"admin,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// MetadataChange represents a change needed to bring a server in line with a
+// metadata description.
+type MetadataChange struct {
+	Op   string `json:"op"`   // "create", "update" or "delete"
+	Kind string `json:"kind"` // "database", "retention policy" or "user"
+	Name string `json:"name"`
+	Diff string `json:"diff,omitempty"` // description of an update
+
+	apply func() error
+}
+
+// String returns a line describing the change, such as "+ database foo".
+func (c *MetadataChange) String() string {
+	var prefix string
+	switch c.Op {
+	case "create":
+		prefix = "+"
+	case "update":
+		prefix = "~"
+	case "delete":
+		prefix = "-"
+	}
+	s := prefix + " " + c.Kind + " " + c.Name
+	if c.Diff != "" {
+		s += " (" + c.Diff + ")"
+	}
+	return s
+}
+
+// Metadata returns a description of the server's databases, retention
+// policies and users. Each list is sorted by name.
+func (s *Server) Metadata() *Metadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m := &Metadata{Databases: []*DatabaseMetadata{}, Users: []*UserMetadata{}}
+	for _, db := range s.databases {
+		dm := &DatabaseMetadata{
+			Name:                   db.name,
+			ReadOnly:               db.readOnly,
+			Disabled:               db.disabled,
+			DefaultRetentionPolicy: db.defaultRetentionPolicy,
+		}
+		for _, rp := range db.policies {
+			dm.RetentionPolicies = append(dm.RetentionPolicies, &RetentionPolicyMetadata{
+				Name:     rp.Name,
+				Duration: influxql.FormatDuration(rp.Duration),
+				ReplicaN: rp.ReplicaN,
+			})
+		}
+		sort.Sort(retentionPolicyMetadatas(dm.RetentionPolicies))
+		m.Databases = append(m.Databases, dm)
+	}
+	sort.Sort(databaseMetadatas(m.Databases))
+
+	for _, u := range s.users {
+		m.Users = append(m.Users, &UserMetadata{Name: u.Name, Admin: u.Admin})
+	}
+	sort.Sort(userMetadatas(m.Users))
+	return m
+}
+
+// DiffMetadata returns the changes needed to bring the server in line with a
+// description. Databases, retention policies and users that aren't described
+// are only deleted if prune is true. Users that don't exist must have a password.
+func (s *Server) DiffMetadata(m *Metadata, prune bool) ([]*MetadataChange, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	current := s.Metadata()
+
+	var changes []*MetadataChange
+	add := func(op, kind, name, diff string, fn func() error) {
+		changes = append(changes, &MetadataChange{Op: op, Kind: kind, Name: name, Diff: diff, apply: fn})
+	}
+
+	// Create or update each described database and its retention policies.
+	for _, dm := range m.Databases {
+		dm := dm
+		cur := current.database(dm.Name)
+		if cur == nil {
+			add("create", "database", dm.Name, "", func() error { return s.CreateDatabase(dm.Name) })
+			cur = &DatabaseMetadata{Name: dm.Name}
+		}
+
+		for _, rpm := range dm.RetentionPolicies {
+			rpm := rpm
+			duration, _ := influxql.ParseDuration(rpm.Duration)
+			name := dm.Name + "." + rpm.Name
+
+			curRP := cur.retentionPolicy(rpm.Name)
+			if curRP == nil {
+				add("create", "retention policy", name, "", func() error {
+					rp := NewRetentionPolicy(rpm.Name)
+					rp.Duration = duration
+					if rpm.ReplicaN > 0 {
+						rp.ReplicaN = rpm.ReplicaN
+					}
+					return s.CreateRetentionPolicy(dm.Name, rp)
+				})
+				continue
+			}
+
+			// Only the fields that differ are updated.
+			c := &updateRetentionPolicyCommand{Database: dm.Name, Name: rpm.Name}
+			var diff []string
+			if d, _ := influxql.ParseDuration(curRP.Duration); d != duration {
+				c.Duration = &duration
+				diff = append(diff, fmt.Sprintf("duration: %s -> %s", curRP.Duration, influxql.FormatDuration(duration)))
+			}
+			if rpm.ReplicaN > 0 && rpm.ReplicaN != curRP.ReplicaN {
+				c.ReplicaN = &rpm.ReplicaN
+				diff = append(diff, fmt.Sprintf("replicaN: %d -> %d", curRP.ReplicaN, rpm.ReplicaN))
+			}
+			if len(diff) > 0 {
+				add("update", "retention policy", name, strings.Join(diff, ", "), func() error {
+					_, err := s.broadcast(updateRetentionPolicyMessageType, c)
+					return err
+				})
+			}
+		}
+
+		// Update the default policy and flags.
+		if dm.DefaultRetentionPolicy != "" && dm.DefaultRetentionPolicy != cur.DefaultRetentionPolicy {
+			add("update", "database", dm.Name, fmt.Sprintf("defaultRetentionPolicy: %q -> %q", cur.DefaultRetentionPolicy, dm.DefaultRetentionPolicy), func() error {
+				return s.SetDefaultRetentionPolicy(dm.Name, dm.DefaultRetentionPolicy)
+			})
+		}
+		var u DatabaseUpdate
+		var diff []string
+		if dm.ReadOnly != cur.ReadOnly {
+			u.ReadOnly = &dm.ReadOnly
+			diff = append(diff, fmt.Sprintf("readOnly: %v -> %v", cur.ReadOnly, dm.ReadOnly))
+		}
+		if dm.Disabled != cur.Disabled {
+			u.Disabled = &dm.Disabled
+			diff = append(diff, fmt.Sprintf("disabled: %v -> %v", cur.Disabled, dm.Disabled))
+		}
+		if len(diff) > 0 {
+			add("update", "database", dm.Name, strings.Join(diff, ", "), func() error { return s.UpdateDatabase(dm.Name, &u) })
+		}
+
+		// Remove policies that aren't described.
+		if prune {
+			for _, rpm := range cur.RetentionPolicies {
+				rpm := rpm
+				if dm.retentionPolicy(rpm.Name) == nil {
+					add("delete", "retention policy", dm.Name+"."+rpm.Name, "", func() error { return s.DeleteRetentionPolicy(dm.Name, rpm.Name) })
+				}
+			}
+		}
+	}
+
+	// Create or update each described user.
+	for _, um := range m.Users {
+		um := um
+		cur := current.user(um.Name)
+		if cur == nil && um.Password == "" {
+			return nil, ErrUserPasswordRequired
+		} else if cur == nil {
+			add("create", "user", um.Name, "", func() error { return s.CreateUser(um.Name, um.Password, um.Admin) })
+		} else if cur.Admin != um.Admin {
+			c := &updateUserCommand{Username: um.Name, Admin: &um.Admin}
+			add("update", "user", um.Name, fmt.Sprintf("admin: %v -> %v", cur.Admin, um.Admin), func() error {
+				_, err := s.broadcast(updateUserMessageType, c)
+				return err
+			})
+		}
+	}
+
+	// Remove users and databases that aren't described.
+	if prune {
+		for _, um := range current.Users {
+			um := um
+			if m.user(um.Name) == nil {
+				add("delete", "user", um.Name, "", func() error { return s.DeleteUser(um.Name) })
+			}
+		}
+		for _, dm := range current.Databases {
+			dm := dm
+			if m.database(dm.Name) == nil {
+				add("delete", "database", dm.Name, "", func() error { return s.DeleteDatabase(dm.Name) })
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+// ApplyMetadata makes the changes needed to bring the server in line with a
+// description and returns them. If a change fails then the changes made so
+// far are returned with the error.
+func (s *Server) ApplyMetadata(m *Metadata, prune bool) ([]*MetadataChange, error) {
+	changes, err := s.DiffMetadata(m, prune)
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range changes {
+		if err := c.apply(); err != nil {
+			return changes[:i], fmt.Errorf("%s: %s", c, err)
+		}
+	}
+	return changes, nil
+}
+
+// validate returns an error if the description has blank or duplicate names,
+// invalid durations, or users that can't be created.
+func (m *Metadata) validate() error {
+	databases := make(map[string]bool)
+	for _, dm := range m.Databases {
+		if dm.Name == "" {
+			return ErrDatabaseNameRequired
+		} else if databases[dm.Name] {
+			return ErrDatabaseExists
+		}
+		databases[dm.Name] = true
+
+		policies := make(map[string]bool)
+		for _, rpm := range dm.RetentionPolicies {
+			if rpm.Name == "" {
+				return ErrRetentionPolicyNameRequired
+			} else if policies[rpm.Name] {
+				return ErrRetentionPolicyExists
+			} else if _, err := influxql.ParseDuration(rpm.Duration); err != nil {
+				return ErrInvalidRetentionDuration
+			}
+			policies[rpm.Name] = true
+		}
+		if dm.DefaultRetentionPolicy != "" && !policies[dm.DefaultRetentionPolicy] {
+			return ErrRetentionPolicyNotFound
+		}
+	}
+
+	users := make(map[string]bool)
+	for _, um := range m.Users {
+		if um.Name == "" {
+			return ErrUsernameRequired
+		} else if users[um.Name] {
+			return ErrUserExists
+		}
+		users[um.Name] = true
+	}
+	return nil
+}
+
+// database returns the description of a database by name.
+func (m *Metadata) database(name string) *DatabaseMetadata {
+	for _, dm := range m.Databases {
+		if dm.Name == name {
+			return dm
+		}
+	}
+	return nil
+}
+
+// user returns the description of a user by name.
+func (m *Metadata) user(name string) *UserMetadata {
+	for _, um := range m.Users {
+		if um.Name == name {
+			return um
+		}
+	}
+	return nil
+}
+
+// retentionPolicy returns the description of a retention policy by name.
+func (dm *DatabaseMetadata) retentionPolicy(name string) *RetentionPolicyMetadata {
+	for _, rpm := range dm.RetentionPolicies {
+		if rpm.Name == name {
+			return rpm
+		}
+	}
+	return nil
+}
+
+type databaseMetadatas []*DatabaseMetadata
+
+func (p databaseMetadatas) Len() int           { return len(p) }
+func (p databaseMetadatas) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p databaseMetadatas) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+type retentionPolicyMetadatas []*RetentionPolicyMetadata
+
+func (p retentionPolicyMetadatas) Len() int           { return len(p) }
+func (p retentionPolicyMetadatas) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p retentionPolicyMetadatas) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+type userMetadatas []*UserMetadata
+
+func (p userMetadatas) Len() int           { return len(p) }
+func (p userMetadatas) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p userMetadatas) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,152 @@
+package influxdb_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb"
+)
+
+// Ensure the server can describe its metadata.
+func TestServer_Metadata(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 7 * 24 * time.Hour, ReplicaN: 1})
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "archive", Duration: 90 * time.Minute, ReplicaN: 2})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	readOnly := true
+	s.UpdateDatabase("foo", &influxdb.DatabaseUpdate{ReadOnly: &readOnly})
+	s.CreateDatabase("bar")
+	s.CreateUser("susy", "pass", true)
+	s.CreateUser("bob", "pass", false)
+
+	m := s.Metadata()
+	if !reflect.DeepEqual(m, &influxdb.Metadata{
+		Databases: []*influxdb.DatabaseMetadata{
+			{Name: "bar"},
+			{Name: "foo", ReadOnly: true, DefaultRetentionPolicy: "raw", RetentionPolicies: []*influxdb.RetentionPolicyMetadata{
+				{Name: "archive", Duration: "90m", ReplicaN: 2},
+				{Name: "raw", Duration: "1w", ReplicaN: 1},
+			}},
+		},
+		Users: []*influxdb.UserMetadata{
+			{Name: "bob"},
+			{Name: "susy", Admin: true},
+		},
+	}) {
+		t.Fatalf("unexpected metadata: %s", mustMarshalJSON(m))
+	}
+}
+
+// Ensure a description can be diffed against and applied to the server.
+func TestServer_ApplyMetadata(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 24 * time.Hour, ReplicaN: 1})
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "old", Duration: time.Hour, ReplicaN: 1})
+	s.CreateDatabase("stale")
+	s.CreateUser("susy", "pass", false)
+	s.CreateUser("bob", "pass", false)
+
+	m := &influxdb.Metadata{
+		Databases: []*influxdb.DatabaseMetadata{
+			{Name: "foo", Disabled: true, DefaultRetentionPolicy: "raw", RetentionPolicies: []*influxdb.RetentionPolicyMetadata{
+				{Name: "raw", Duration: "7d", ReplicaN: 2},
+				{Name: "old", Duration: "1h"},
+			}},
+			{Name: "bar", RetentionPolicies: []*influxdb.RetentionPolicyMetadata{
+				{Name: "raw", Duration: "30d"},
+			}},
+		},
+		Users: []*influxdb.UserMetadata{
+			{Name: "susy", Admin: true},
+			{Name: "alice", Password: "pass"},
+		},
+	}
+
+	// Diff without pruning.
+	changes, err := s.DiffMetadata(m, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if a := changeStrings(changes); !reflect.DeepEqual(a, []string{
+		`~ retention policy foo.raw (duration: 1d -> 1w, replicaN: 1 -> 2)`,
+		`~ database foo (defaultRetentionPolicy: "" -> "raw")`,
+		`~ database foo (disabled: false -> true)`,
+		`+ database bar`,
+		`+ retention policy bar.raw`,
+		`~ user susy (admin: false -> true)`,
+		`+ user alice`,
+	}) {
+		t.Fatalf("unexpected changes: %q", a)
+	} else if s.DatabaseExists("bar") {
+		t.Fatal("unexpected database")
+	}
+
+	// Remove "old" from the description and apply with pruning.
+	m.Databases[0].RetentionPolicies = m.Databases[0].RetentionPolicies[:1]
+	if changes, err := s.ApplyMetadata(m, true); err != nil {
+		t.Fatal(err)
+	} else if a := changeStrings(changes); !reflect.DeepEqual(a, []string{
+		`~ retention policy foo.raw (duration: 1d -> 1w, replicaN: 1 -> 2)`,
+		`~ database foo (defaultRetentionPolicy: "" -> "raw")`,
+		`~ database foo (disabled: false -> true)`,
+		`- retention policy foo.old`,
+		`+ database bar`,
+		`+ retention policy bar.raw`,
+		`~ user susy (admin: false -> true)`,
+		`+ user alice`,
+		`- user bob`,
+		`- database stale`,
+	}) {
+		t.Fatalf("unexpected changes: %q", a)
+	}
+	s.Restart()
+
+	// The server now matches the description.
+	if changes, err := s.DiffMetadata(m, true); err != nil {
+		t.Fatal(err)
+	} else if len(changes) != 0 {
+		t.Fatalf("unexpected changes: %q", changeStrings(changes))
+	} else if u, err := s.Authenticate("alice", "pass"); err != nil || u.Admin {
+		t.Fatalf("unexpected user: %#v, %v", u, err)
+	} else if !s.DatabaseDisabled("foo") {
+		t.Fatal("expected disabled database")
+	}
+}
+
+// Ensure invalid descriptions are rejected.
+func TestServer_DiffMetadata_Invalid(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	for i, tt := range []struct {
+		m   *influxdb.Metadata
+		err error
+	}{
+		{m: &influxdb.Metadata{Databases: []*influxdb.DatabaseMetadata{{}}}, err: influxdb.ErrDatabaseNameRequired},
+		{m: &influxdb.Metadata{Databases: []*influxdb.DatabaseMetadata{{Name: "foo"}, {Name: "foo"}}}, err: influxdb.ErrDatabaseExists},
+		{m: &influxdb.Metadata{Databases: []*influxdb.DatabaseMetadata{{Name: "foo", RetentionPolicies: []*influxdb.RetentionPolicyMetadata{{Duration: "1d"}}}}}, err: influxdb.ErrRetentionPolicyNameRequired},
+		{m: &influxdb.Metadata{Databases: []*influxdb.DatabaseMetadata{{Name: "foo", RetentionPolicies: []*influxdb.RetentionPolicyMetadata{{Name: "raw", Duration: "1d"}, {Name: "raw", Duration: "1d"}}}}}, err: influxdb.ErrRetentionPolicyExists},
+		{m: &influxdb.Metadata{Databases: []*influxdb.DatabaseMetadata{{Name: "foo", RetentionPolicies: []*influxdb.RetentionPolicyMetadata{{Name: "raw", Duration: "forever"}}}}}, err: influxdb.ErrInvalidRetentionDuration},
+		{m: &influxdb.Metadata{Databases: []*influxdb.DatabaseMetadata{{Name: "foo", DefaultRetentionPolicy: "raw"}}}, err: influxdb.ErrRetentionPolicyNotFound},
+		{m: &influxdb.Metadata{Users: []*influxdb.UserMetadata{{Password: "pass"}}}, err: influxdb.ErrUsernameRequired},
+		{m: &influxdb.Metadata{Users: []*influxdb.UserMetadata{{Name: "susy", Password: "pass"}, {Name: "susy", Password: "pass"}}}, err: influxdb.ErrUserExists},
+		{m: &influxdb.Metadata{Users: []*influxdb.UserMetadata{{Name: "susy"}}}, err: influxdb.ErrUserPasswordRequired},
+	} {
+		if _, err := s.DiffMetadata(tt.m, false); err != tt.err {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		}
+	}
+}
+
+// changeStrings returns the description of each change.
+func changeStrings(changes []*influxdb.MetadataChange) []string {
+	a := make([]string, len(changes))
+	for i, c := range changes {
+		a[i] = c.String()
+	}
+	return a
+}
@@ -10,7 +10,8 @@ import (
 
 // metastore represents the low-level data store for metadata.
 type metastore struct {
-	db *bolt.DB
+	db     *bolt.DB
+	format storeFormat // format that the metastore was opened in
 }
 
 // open initializes the metastore.
@@ -24,6 +25,7 @@ func (m *metastore) open(path string) error {
 
 	// Initialize the metastore.
 	if err := m.init(); err != nil {
+		_ = db.Close()
 		return err
 	}
 
@@ -39,8 +41,26 @@ func (m *metastore) close() error {
 }
 
 // init initializes the metastore to ensure all top-level buckets are created.
+// New metastores are written in the current format. Returns an error if the
+// metastore is in a format that this node can't read.
 func (m *metastore) init() error {
 	return m.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("Databases")) == nil {
+			b, err := tx.CreateBucketIfNotExists([]byte("Server"))
+			if err != nil {
+				return err
+			}
+			m.format = storeFormat{version: MetaFormat, minReader: metaMinReader}
+			if err := writeFormat(b, m.format); err != nil {
+				return err
+			}
+		} else if b := tx.Bucket([]byte("Server")); b != nil {
+			m.format = readFormat(b)
+			if err := m.format.check(MetaFormat); err != nil {
+				return err
+			}
+		}
+
 		_, _ = tx.CreateBucketIfNotExists([]byte("Server"))
 		_, _ = tx.CreateBucketIfNotExists([]byte("DataNodes"))
 		_, _ = tx.CreateBucketIfNotExists([]byte("Databases"))
@@ -107,6 +127,11 @@ func (tx *metatx) dataNodes() (a []*DataNode) {
 	for k, v := c.First(); k != nil; k, v = c.Next() {
 		n := newDataNode()
 		mustUnmarshalJSON(v, &n)
+
+		// Nodes saved before roles were added accept writes.
+		if n.Role == "" {
+			n.Role = DataNodeRoleData
+		}
 		a = append(a, n)
 	}
 	return
@@ -155,6 +180,10 @@ func (tx *metatx) saveDatabase(db *database) error {
 	if err != nil {
 		return err
 	}
+	_, err = b.CreateBucketIfNotExists([]byte("Measurements"))
+	if err != nil {
+		return err
+	}
 	return b.Put([]byte("meta"), mustMarshalJSON(db))
 }
 
@@ -163,39 +192,115 @@ func (tx *metatx) deleteDatabase(name string) error {
 	return tx.Bucket([]byte("Databases")).DeleteBucket([]byte(name))
 }
 
+// renameDatabase moves a database's metadata and series index to a new name.
+func (tx *metatx) renameDatabase(name, newName string) error {
+	b := tx.Bucket([]byte("Databases"))
+	dst, err := b.CreateBucket([]byte(newName))
+	if err != nil {
+		return err
+	}
+	if err := copyBucket(b.Bucket([]byte(name)), dst); err != nil {
+		return err
+	}
+	return b.DeleteBucket([]byte(name))
+}
+
+// copyBucket recursively copies the keys and nested buckets of src into dst.
+func copyBucket(src, dst *bolt.Bucket) error {
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+		b, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(src.Bucket(k), b)
+	})
+}
+
 // sets the series id for the database, name, and tags.
 func (tx *metatx) createSeries(database, name string, tags map[string]string) (*Series, error) {
-	// create the buckets to store tag indexes for the series and give it a unique ID in the DB
-	db := tx.Bucket([]byte("Databases")).Bucket([]byte(database))
-	t := db.Bucket([]byte("Series"))
-	b, err := t.CreateBucketIfNotExists([]byte(name))
+	// give the series a unique ID
+	id, err := tx.nextSeriesID(database)
 	if err != nil {
 		return nil, err
 	}
 
-	// give the series a unique ID
-	id, _ := t.NextSequence()
-
 	// store the tag map for the series
-	b, err = db.Bucket([]byte("Series")).CreateBucketIfNotExists([]byte(name))
-	if err != nil {
+	s := &Series{ID: id, Tags: tags}
+	if err := tx.saveSeries(database, name, s); err != nil {
 		return nil, err
 	}
+	return s, nil
+}
+
+// nextSeriesID returns a new series id that is unique within the database.
+// Ids are assigned by the metastore whichever kind of index the database uses.
+func (tx *metatx) nextSeriesID(database string) (uint32, error) {
+	id, err := tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("Series")).NextSequence()
+	return uint32(id), err
+}
+
+// seriesN returns the number of series ids assigned in the database.
+func (tx *metatx) seriesN(database string) int {
+	return int(tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("Series")).Sequence())
+}
+
+// saveSeries persists the tags of a series in a measurement to the metastore.
+func (tx *metatx) saveSeries(database, name string, s *Series) error {
+	b, err := tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("Series")).CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		return err
+	}
 
-	s := &Series{ID: uint32(id), Tags: tags}
 	idBytes := make([]byte, 4)
-	*(*uint32)(unsafe.Pointer(&idBytes[0])) = uint32(id)
+	*(*uint32)(unsafe.Pointer(&idBytes[0])) = s.ID
+	return b.Put(idBytes, mustMarshalJSON(s))
+}
 
-	if err := b.Put(idBytes, mustMarshalJSON(s)); err != nil {
-		return nil, err
+// clearIndex removes the measurements and series of a database from the
+// metastore once they're stored in an index log. Series ids continue to be
+// assigned from the metastore.
+func (tx *metatx) clearIndex(database string) error {
+	db := tx.Bucket([]byte("Databases")).Bucket([]byte(database))
+	seq := db.Bucket([]byte("Series")).Sequence()
+	for _, name := range []string{"Series", "Measurements"} {
+		if err := db.DeleteBucket([]byte(name)); err != nil {
+			return err
+		} else if _, err := db.CreateBucket([]byte(name)); err != nil {
+			return err
+		}
 	}
-	return s, nil
+	return db.Bucket([]byte("Series")).SetSequence(seq)
+}
+
+// saveMeasurement persists the fields of a measurement to the metastore.
+func (tx *metatx) saveMeasurement(database string, m *Measurement) error {
+	b, err := tx.Bucket([]byte("Databases")).Bucket([]byte(database)).CreateBucketIfNotExists([]byte("Measurements"))
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(m.Name), mustMarshalJSON(m))
 }
 
 // loops through all the measurements and series in a database
-func (tx *metatx) indexDatabase(db *database) {
+func (tx *metatx) indexDatabase(database string, idx *seriesIndex) {
+	// load the fields for each measurement
+	if b := tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("Measurements")); b != nil {
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var m Measurement
+			mustUnmarshalJSON(v, &m)
+			idx.createMeasurementIfNotExists(string(k)).Fields = m.Fields
+		}
+	}
+
 	// get the bucket that holds series data for the database
-	b := tx.Bucket([]byte("Databases")).Bucket([]byte(db.name)).Bucket([]byte("Series"))
+	b := tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("Series"))
 	c := b.Cursor()
 
 	for k, _ := c.First(); k != nil; k, _ = c.Next() {
@@ -204,7 +309,7 @@ func (tx *metatx) indexDatabase(db *database) {
 		for id, v := mc.First(); id != nil; id, v = mc.Next() {
 			var s *Series
 			mustUnmarshalJSON(v, &s)
-			db.addSeriesToIndex(name, s)
+			idx.addSeries(name, s)
 		}
 	}
 }
@@ -0,0 +1,146 @@
+package influxdb
+
+import (
+	"bufio"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// metricsContentType is the content type of the Prometheus text exposition format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// databaseStatHelp is the description of each database statistic.
+var databaseStatHelp = map[string]string{
+	StatPointsWritten:       "Number of points written.",
+	StatBytesIn:             "Number of encoded point bytes written.",
+	StatWriteErrors:         "Number of failed writes.",
+	StatQueriesExecuted:     "Number of statements executed.",
+	StatQueryErrors:         "Number of statements that returned an error.",
+	StatQueryCacheHits:      "Number of queries served from the parsed query cache.",
+	StatQueryCacheMisses:    "Number of queries that had to be parsed.",
+	StatResultCacheHits:     "Number of statements served from the result cache.",
+	StatResultCacheMisses:   "Number of cacheable statements that had to be executed.",
+	StatDuplicatesDropped:   "Number of points dropped by a reject duplicate policy.",
+	StatTimestampsCorrected: "Number of points whose timestamps were outside an input's skew bounds.",
+}
+
+// WriteMetrics writes the server's statistics to w in the Prometheus text
+// exposition format. Database statistics are counters named after the
+// statistic and labeled with the database name, for example:
+//
+//	influxdb_database_points_written_total{database="foo"} 100
+//
+// Gauges report the size of each database, the load on the write path and
+// the Go runtime.
+func (s *Server) WriteMetrics(w io.Writer) error {
+	mw := &metricWriter{w: bufio.NewWriter(w)}
+	names := s.Databases()
+
+	// Write a counter family for each database statistic.
+	for _, stat := range databaseStatNames {
+		name := "influxdb_database_" + metricName(stat) + "_total"
+		mw.family(name, "counter", databaseStatHelp[stat])
+		for _, db := range names {
+			if st := s.DatabaseStats(db); st != nil {
+				mw.sample(name, float64(st.Get(stat)), "database", db)
+			}
+		}
+	}
+
+	// Write the size of each database.
+	series, disk := make([]float64, len(names)), make([]float64, len(names))
+	s.mu.RLock()
+	for i, name := range names {
+		if db := s.databases[name]; db != nil {
+			series[i], disk[i] = float64(db.seriesN()), float64(s.diskBytes(db))
+		}
+	}
+	s.mu.RUnlock()
+
+	mw.family("influxdb_database_series", "gauge", "Number of series.")
+	for i, name := range names {
+		mw.sample("influxdb_database_series", series[i], "database", name)
+	}
+	mw.family("influxdb_database_disk_bytes", "gauge", "Size of the shard files stored on this server.")
+	for i, name := range names {
+		mw.sample("influxdb_database_disk_bytes", disk[i], "database", name)
+	}
+
+	// Write the load on the write path.
+	st := s.WriteState()
+	var backpressure float64
+	if st.Backpressure != "" {
+		backpressure = 1
+	}
+	mw.gauge("influxdb_write_pending_points", "Number of points waiting to be published to the broker.", float64(st.PendingPoints))
+	mw.gauge("influxdb_write_unapplied_writes", "Number of published writes that have not been applied.", float64(st.UnappliedWrites))
+	mw.gauge("influxdb_write_backpressure", "Whether writes are being rejected.", backpressure)
+
+	// Write the Go runtime statistics.
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	mw.gauge("go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	mw.gauge("go_memstats_heap_alloc_bytes", "Number of heap bytes allocated and still in use.", float64(m.HeapAlloc))
+	mw.gauge("go_memstats_sys_bytes", "Number of bytes obtained from the system.", float64(m.Sys))
+
+	if mw.err != nil {
+		return mw.err
+	}
+	return mw.w.Flush()
+}
+
+// metricName converts a camel case statistic name to snake case.
+func metricName(s string) string {
+	a := make([]byte, 0, len(s)+4)
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= 'A' && c <= 'Z' {
+			a = append(a, '_', c+('a'-'A'))
+		} else {
+			a = append(a, c)
+		}
+	}
+	return string(a)
+}
+
+// metricWriter writes metric families in the Prometheus text exposition format.
+// The first write error is retained and later writes are skipped.
+type metricWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// family writes the help and type lines of a metric family.
+func (mw *metricWriter) family(name, typ, help string) {
+	mw.write("# HELP " + name + " " + help + "\n# TYPE " + name + " " + typ + "\n")
+}
+
+// gauge writes a metric family with a single unlabeled gauge.
+func (mw *metricWriter) gauge(name, help string, v float64) {
+	mw.family(name, "gauge", help)
+	mw.sample(name, v)
+}
+
+// sample writes a sample with label name and value pairs.
+func (mw *metricWriter) sample(name string, v float64, labels ...string) {
+	line := name
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, labels[i]+`="`+metricLabelReplacer.Replace(labels[i+1])+`"`)
+		}
+		line += "{" + strings.Join(pairs, ",") + "}"
+	}
+	mw.write(line + " " + strconv.FormatFloat(v, 'g', -1, 64) + "\n")
+}
+
+// write writes s unless a previous write failed.
+func (mw *metricWriter) write(s string) {
+	if mw.err == nil {
+		_, mw.err = mw.w.WriteString(s)
+	}
+}
+
+// metricLabelReplacer escapes label values.
+var metricLabelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
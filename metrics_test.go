@@ -0,0 +1,54 @@
+package influxdb
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+// Ensure statistic names are converted to snake case metric names.
+func TestMetricName(t *testing.T) {
+	for i, tt := range []struct {
+		s   string
+		exp string
+	}{
+		{s: "pointsWritten", exp: "points_written"},
+		{s: "queryCacheHits", exp: "query_cache_hits"},
+		{s: "series", exp: "series"},
+	} {
+		if name := metricName(tt.s); name != tt.exp {
+			t.Errorf("%d. %s: unexpected name: %s", i, tt.s, name)
+		}
+	}
+}
+
+// Ensure every database statistic has a description.
+func TestDatabaseStatHelp(t *testing.T) {
+	for _, stat := range databaseStatNames {
+		if databaseStatHelp[stat] == "" {
+			t.Errorf("missing help: %s", stat)
+		}
+	}
+}
+
+// Ensure samples are written with escaped label values.
+func TestMetricWriter(t *testing.T) {
+	var buf bytes.Buffer
+	mw := &metricWriter{w: bufio.NewWriter(&buf)}
+	mw.family("requests_total", "counter", "Number of requests.")
+	mw.sample("requests_total", 10, "path", `/a "b"`, "code", "200")
+	mw.sample("requests_total", 0.5, "path", "c\\d\ne")
+	mw.gauge("temperature", "Current temperature.", -1.5e10)
+	mw.w.Flush()
+
+	if s := buf.String(); s != `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{path="/a \"b\"",code="200"} 10
+requests_total{path="c\\d\ne"} 0.5
+# HELP temperature Current temperature.
+# TYPE temperature gauge
+temperature -1.5e+10
+` {
+		t.Fatalf("unexpected output:\n%s", s)
+	}
+}
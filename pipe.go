@@ -0,0 +1,52 @@
+package influxdb
+
+import (
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/pipeql"
+)
+
+// ExecutePipeQuery runs a program in the experimental pipe query language
+// as a user. Its reads are compiled to select statements that are executed
+// like any other query. The retention policy of the options is ignored since
+// each read names its own.
+func (s *Server) ExecutePipeQuery(prog *pipeql.Program, user *User, opt QueryOptions) ([]*pipeql.Result, error) {
+	interp := pipeql.NewInterpreter(&pipeExecutor{server: s, user: user, opt: opt})
+	return interp.Execute(prog)
+}
+
+// pipeExecutor reads the data of pipe queries as a user.
+type pipeExecutor struct {
+	server *Server
+	user   *User
+	opt    QueryOptions
+}
+
+// ExecuteSelect executes a select statement against a database and retention policy.
+func (e *pipeExecutor) ExecuteSelect(stmt *influxql.SelectStatement, database, retentionPolicy string) ([]*influxql.Row, error) {
+	if e.server.DatabaseDisabled(database) {
+		return nil, ErrDatabaseDisabled
+	}
+
+	opt := e.opt
+	opt.RetentionPolicy = retentionPolicy
+	results := e.server.ExecuteQuery(&influxql.Query{Statements: influxql.Statements{stmt}}, database, e.user, opt)
+	if err := results[0].Err; err != nil {
+		return nil, err
+	}
+	return results[0].Rows, nil
+}
+
+// TagKeys returns the sorted tag keys of a measurement.
+func (e *pipeExecutor) TagKeys(database, measurement string) ([]string, error) {
+	if !e.user.AuthorizeMeasurement(influxql.ReadPrivilege, database, measurement) {
+		return nil, ErrReadAccessDenied
+	}
+
+	e.server.mu.RLock()
+	defer e.server.mu.RUnlock()
+	db := e.server.databases[database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+	return db.TagKeys([]string{measurement}), nil
+}
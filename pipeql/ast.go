@@ -0,0 +1,255 @@
+package pipeql
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Node represents a node in the abstract syntax tree.
+type Node interface {
+	node()
+	String() string
+}
+
+func (*Program) node()         {}
+func (*Assignment) node()      {}
+func (*ExprStatement) node()   {}
+func (*PipeExpr) node()        {}
+func (*CallExpr) node()        {}
+func (*ObjectExpr) node()      {}
+func (*ArrayExpr) node()       {}
+func (*FunctionExpr) node()    {}
+func (*MemberExpr) node()      {}
+func (*BinaryExpr) node()      {}
+func (*ParenExpr) node()       {}
+func (*Identifier) node()      {}
+func (*StringLiteral) node()   {}
+func (*IntegerLiteral) node()  {}
+func (*NumberLiteral) node()   {}
+func (*DurationLiteral) node() {}
+func (*TimeLiteral) node()     {}
+
+// Statement represents a single statement of a program.
+type Statement interface {
+	Node
+	stmt()
+}
+
+func (*Assignment) stmt()    {}
+func (*ExprStatement) stmt() {}
+
+// Expr represents an expression that can be evaluated to a value.
+type Expr interface {
+	Node
+	expr()
+}
+
+func (*PipeExpr) expr()        {}
+func (*CallExpr) expr()        {}
+func (*ObjectExpr) expr()      {}
+func (*ArrayExpr) expr()       {}
+func (*FunctionExpr) expr()    {}
+func (*MemberExpr) expr()      {}
+func (*BinaryExpr) expr()      {}
+func (*ParenExpr) expr()       {}
+func (*Identifier) expr()      {}
+func (*StringLiteral) expr()   {}
+func (*IntegerLiteral) expr()  {}
+func (*NumberLiteral) expr()   {}
+func (*DurationLiteral) expr() {}
+func (*TimeLiteral) expr()     {}
+
+// Program represents a list of statements.
+type Program struct {
+	Statements []Statement
+}
+
+// String returns a string representation of the program.
+func (p *Program) String() string {
+	var a []string
+	for _, stmt := range p.Statements {
+		a = append(a, stmt.String())
+	}
+	return strings.Join(a, "\n")
+}
+
+// Assignment represents a statement that names the value of an expression.
+type Assignment struct {
+	Name  string
+	Value Expr
+}
+
+// String returns a string representation of the assignment.
+func (s *Assignment) String() string { return s.Name + " = " + s.Value.String() }
+
+// ExprStatement represents a statement whose value is a result of the program.
+type ExprStatement struct {
+	Expr Expr
+}
+
+// String returns a string representation of the statement.
+func (s *ExprStatement) String() string { return s.Expr.String() }
+
+// PipeExpr represents a call whose piped argument is the value of an input
+// expression.
+type PipeExpr struct {
+	Input Expr
+	Call  *CallExpr
+}
+
+// String returns a string representation of the pipe.
+func (e *PipeExpr) String() string { return e.Input.String() + " |> " + e.Call.String() }
+
+// CallExpr represents a function call with named arguments.
+type CallExpr struct {
+	Name string
+	Args []*Property
+}
+
+// String returns a string representation of the call.
+func (e *CallExpr) String() string {
+	return e.Name + "(" + propertiesString(e.Args) + ")"
+}
+
+// Arg returns the value of a named argument or nil if it isn't set.
+func (e *CallExpr) Arg(name string) Expr {
+	for _, p := range e.Args {
+		if p.Key == name {
+			return p.Value
+		}
+	}
+	return nil
+}
+
+// ObjectExpr represents a set of named values.
+type ObjectExpr struct {
+	Properties []*Property
+}
+
+// String returns a string representation of the object.
+func (e *ObjectExpr) String() string { return "{" + propertiesString(e.Properties) + "}" }
+
+// Property represents a named value of an object or call.
+type Property struct {
+	Key   string
+	Value Expr
+}
+
+// propertiesString returns a string representation of a list of properties.
+func propertiesString(a []*Property) string {
+	var buf bytes.Buffer
+	for i, p := range a {
+		if i > 0 {
+			_, _ = buf.WriteString(", ")
+		}
+		_, _ = buf.WriteString(p.Key)
+		_, _ = buf.WriteString(": ")
+		_, _ = buf.WriteString(p.Value.String())
+	}
+	return buf.String()
+}
+
+// ArrayExpr represents a list of values.
+type ArrayExpr struct {
+	Elements []Expr
+}
+
+// String returns a string representation of the array.
+func (e *ArrayExpr) String() string {
+	var a []string
+	for _, elem := range e.Elements {
+		a = append(a, elem.String())
+	}
+	return "[" + strings.Join(a, ", ") + "]"
+}
+
+// FunctionExpr represents a function literal, such as the predicate of filter().
+type FunctionExpr struct {
+	Params []string
+	Body   Expr
+}
+
+// String returns a string representation of the function.
+func (e *FunctionExpr) String() string {
+	return "(" + strings.Join(e.Params, ", ") + ") => " + e.Body.String()
+}
+
+// MemberExpr represents a property of an object, such as a column of a record.
+type MemberExpr struct {
+	Object   Expr
+	Property string
+}
+
+// String returns a string representation of the member.
+func (e *MemberExpr) String() string { return e.Object.String() + "." + e.Property }
+
+// BinaryExpr represents a comparison or logical operation.
+type BinaryExpr struct {
+	Op  Token
+	LHS Expr
+	RHS Expr
+}
+
+// String returns a string representation of the binary expression.
+func (e *BinaryExpr) String() string {
+	return e.LHS.String() + " " + e.Op.String() + " " + e.RHS.String()
+}
+
+// ParenExpr represents a parenthesized expression.
+type ParenExpr struct {
+	Expr Expr
+}
+
+// String returns a string representation of the parenthesized expression.
+func (e *ParenExpr) String() string { return "(" + e.Expr.String() + ")" }
+
+// Identifier represents a reference to a variable or function.
+type Identifier struct {
+	Name string
+}
+
+// String returns a string representation of the identifier.
+func (e *Identifier) String() string { return e.Name }
+
+// StringLiteral represents a string literal.
+type StringLiteral struct {
+	Val string
+}
+
+// String returns a string representation of the literal.
+func (e *StringLiteral) String() string { return strconv.Quote(e.Val) }
+
+// IntegerLiteral represents an integer literal.
+type IntegerLiteral struct {
+	Val int64
+}
+
+// String returns a string representation of the literal.
+func (e *IntegerLiteral) String() string { return strconv.FormatInt(e.Val, 10) }
+
+// NumberLiteral represents a floating point literal.
+type NumberLiteral struct {
+	Val float64
+}
+
+// String returns a string representation of the literal.
+func (e *NumberLiteral) String() string { return strconv.FormatFloat(e.Val, 'f', -1, 64) }
+
+// DurationLiteral represents a duration literal. Negative durations are
+// relative to now when used as times.
+type DurationLiteral struct {
+	Val time.Duration
+}
+
+// String returns a string representation of the literal.
+func (e *DurationLiteral) String() string { return e.Val.String() }
+
+// TimeLiteral represents an RFC3339 time literal.
+type TimeLiteral struct {
+	Val time.Time
+}
+
+// String returns a string representation of the literal.
+func (e *TimeLiteral) String() string { return e.Val.UTC().Format(time.RFC3339Nano) }
@@ -0,0 +1,1111 @@
+package pipeql
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// DefaultResultName is the name of a result that isn't named with yield().
+const DefaultResultName = "_result"
+
+// Columns with special meanings. Tags are columns named after the tag.
+const (
+	MeasurementColumn = "_measurement"
+	FieldColumn       = "_field"
+	TimeColumn        = "_time"
+	ValueColumn       = "_value"
+)
+
+// aggregates are the functions supported by the query engine that can be
+// passed to aggregateWindow() or called directly.
+var aggregates = map[string]bool{"count": true, "sum": true, "mean": true}
+
+// Executor reads the data that programs are compiled to.
+type Executor interface {
+	// Executes a select statement against a database and retention policy.
+	// A blank retention policy uses the database's default.
+	ExecuteSelect(stmt *influxql.SelectStatement, database, retentionPolicy string) ([]*influxql.Row, error)
+
+	// Returns the sorted tag keys of a measurement.
+	TagKeys(database, measurement string) ([]string, error)
+}
+
+// Table represents records that share the values of a group key.
+type Table struct {
+	Key     map[string]string `json:"key"`
+	Columns []string          `json:"columns"`
+	Values  [][]interface{}   `json:"values,omitempty"`
+}
+
+// Result represents the tables returned by a statement.
+type Result struct {
+	Name   string   `json:"name"`
+	Tables []*Table `json:"tables"`
+}
+
+// Interpreter executes programs. Reads from databases are compiled to select
+// statements run by the executor. Functions that can't be compiled, such as
+// pivot() and join(), are applied to the tables the statements return.
+type Interpreter struct {
+	Executor Executor
+
+	// Returns the current time. Defaults to time.Now().
+	Now func() time.Time
+}
+
+// NewInterpreter returns a new instance of Interpreter.
+func NewInterpreter(e Executor) *Interpreter {
+	return &Interpreter{Executor: e, Now: time.Now}
+}
+
+// Execute runs a program and returns the results of its expression statements.
+// Only one result can be returned without naming it with yield().
+func (i *Interpreter) Execute(prog *Program) ([]*Result, error) {
+	x := &execution{interp: i, now: i.Now().UTC(), scope: make(map[string]interface{})}
+
+	var results []*Result
+	names := make(map[string]bool)
+	for _, stmt := range prog.Statements {
+		switch stmt := stmt.(type) {
+		case *Assignment:
+			v, err := x.eval(stmt.Value)
+			if err != nil {
+				return nil, err
+			}
+			x.scope[stmt.Name] = v
+
+		case *ExprStatement:
+			v, err := x.eval(stmt.Expr)
+			if err != nil {
+				return nil, err
+			}
+
+			name := DefaultResultName
+			if y, ok := v.(*yielded); ok {
+				name, v = y.name, y.value
+			}
+			if names[name] {
+				return nil, fmt.Errorf("duplicate result name %q: name results with yield()", name)
+			}
+			names[name] = true
+
+			tables, err := x.tables(v, stmt.Expr)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, &Result{Name: name, Tables: tables})
+		}
+	}
+	return results, nil
+}
+
+// execution holds the state of a running program.
+type execution struct {
+	interp *Interpreter
+	now    time.Time
+	scope  map[string]interface{}
+}
+
+// Values of expressions that aren't literals.
+type (
+	// object is the value of an object expression.
+	object struct {
+		keys   []string
+		values []interface{}
+	}
+
+	// aggregate is the value of an identifier naming an aggregate function.
+	aggregate string
+
+	// yielded is the value of a yield() call.
+	yielded struct {
+		name  string
+		value interface{}
+	}
+)
+
+// eval returns the value of an expression.
+func (x *execution) eval(expr Expr) (interface{}, error) {
+	switch expr := expr.(type) {
+	case *PipeExpr:
+		in, err := x.eval(expr.Input)
+		if err != nil {
+			return nil, err
+		}
+		return x.call(expr.Call, in)
+	case *CallExpr:
+		return x.call(expr, nil)
+	case *Identifier:
+		if v, ok := x.scope[expr.Name]; ok {
+			return v, nil
+		} else if aggregates[expr.Name] {
+			return aggregate(expr.Name), nil
+		}
+		return nil, fmt.Errorf("undefined: %s", expr.Name)
+	case *ObjectExpr:
+		obj := &object{}
+		for _, p := range expr.Properties {
+			v, err := x.eval(p.Value)
+			if err != nil {
+				return nil, err
+			}
+			obj.keys = append(obj.keys, p.Key)
+			obj.values = append(obj.values, v)
+		}
+		return obj, nil
+	case *ArrayExpr:
+		a := make([]interface{}, len(expr.Elements))
+		for i, elem := range expr.Elements {
+			v, err := x.eval(elem)
+			if err != nil {
+				return nil, err
+			}
+			a[i] = v
+		}
+		return a, nil
+	case *ParenExpr:
+		return x.eval(expr.Expr)
+	case *FunctionExpr:
+		return expr, nil
+	case *StringLiteral:
+		return expr.Val, nil
+	case *IntegerLiteral:
+		return expr.Val, nil
+	case *NumberLiteral:
+		return expr.Val, nil
+	case *DurationLiteral:
+		return expr.Val, nil
+	case *TimeLiteral:
+		return expr.Val, nil
+	}
+	return nil, fmt.Errorf("unexpected expression: %s", expr)
+}
+
+// call calls a function. in is the piped value or nil if the call isn't piped.
+func (x *execution) call(c *CallExpr, in interface{}) (interface{}, error) {
+	args, err := x.args(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// Functions without a piped input.
+	switch c.Name {
+	case "from":
+		if in != nil {
+			return nil, errors.New("from() cannot be piped")
+		}
+		return x.from(args)
+	case "join":
+		if in != nil {
+			return nil, errors.New("join() cannot be piped")
+		}
+		return x.join(args)
+	}
+
+	// Functions of tables.
+	if in == nil {
+		return nil, fmt.Errorf("%s() requires piped tables", c.Name)
+	}
+	if y, ok := in.(*yielded); ok {
+		in = y.value
+	}
+	switch c.Name {
+	case "range", "filter", "group", "aggregateWindow":
+		q, ok := in.(*query)
+		if !ok {
+			return nil, fmt.Errorf("%s() must be called before pivot(), join() and limit()", c.Name)
+		}
+		switch c.Name {
+		case "range":
+			return q.withRange(args, x.now)
+		case "filter":
+			return q.withFilter(args)
+		case "group":
+			return q.withGroup(args)
+		default:
+			return q.withWindow(args)
+		}
+	case "pivot":
+		tables, err := x.tables(in, c)
+		if err != nil {
+			return nil, err
+		}
+		return pivot(tables, args)
+	case "limit":
+		tables, err := x.tables(in, c)
+		if err != nil {
+			return nil, err
+		}
+		return limit(tables, args)
+	case "yield":
+		name := DefaultResultName
+		if v, ok := args["name"]; ok {
+			if name, ok = v.(string); !ok {
+				return nil, errors.New("yield() name must be a string")
+			}
+		}
+		return &yielded{name: name, value: in}, nil
+	}
+
+	if aggregates[c.Name] {
+		q, ok := in.(*query)
+		if !ok {
+			return nil, fmt.Errorf("%s() must be called before pivot(), join() and limit()", c.Name)
+		}
+		return q.withAggregate(c.Name, 0)
+	}
+	return nil, fmt.Errorf("undefined function: %s()", c.Name)
+}
+
+// args returns the values of a call's arguments by name.
+func (x *execution) args(c *CallExpr) (map[string]interface{}, error) {
+	args := make(map[string]interface{})
+	for _, p := range c.Args {
+		v, err := x.eval(p.Value)
+		if err != nil {
+			return nil, err
+		}
+		args[p.Key] = v
+	}
+	return args, nil
+}
+
+// from returns a query of a database. The bucket argument is a database
+// name, optionally followed by a slash and a retention policy.
+func (x *execution) from(args map[string]interface{}) (*query, error) {
+	bucket, ok := args["bucket"].(string)
+	if !ok || bucket == "" {
+		return nil, errors.New("from() requires a bucket")
+	}
+
+	q := &query{}
+	if i := strings.Index(bucket, "/"); i >= 0 {
+		q.database, q.retentionPolicy = bucket[:i], bucket[i+1:]
+	} else {
+		q.database = bucket
+	}
+	return q, nil
+}
+
+// tables returns the tables of a value, executing it if it is a query.
+func (x *execution) tables(v interface{}, expr Node) ([]*Table, error) {
+	switch v := v.(type) {
+	case *query:
+		return v.execute(x.interp.Executor)
+	case []*Table:
+		return v, nil
+	case *yielded:
+		return x.tables(v.value, expr)
+	}
+	return nil, fmt.Errorf("expected tables: %s", expr)
+}
+
+// join returns the records of two streams of tables with equal values in
+// the "on" columns. Other columns are suffixed with the name of their table.
+// Tables are grouped by the "on" columns in the group key of the first table.
+func (x *execution) join(args map[string]interface{}) ([]*Table, error) {
+	obj, ok := args["tables"].(*object)
+	if !ok || len(obj.keys) != 2 {
+		return nil, errors.New("join() requires two tables")
+	}
+	on, err := stringsArg(args, "on")
+	if err != nil {
+		return nil, err
+	} else if len(on) == 0 {
+		return nil, errors.New("join() requires on columns")
+	}
+
+	var inputs [2][]*Table
+	for i := range inputs {
+		if inputs[i], err = x.tables(obj.values[i], &Identifier{Name: obj.keys[i]}); err != nil {
+			return nil, err
+		}
+	}
+
+	right := make([][]record, len(inputs[1]))
+	for i, t := range inputs[1] {
+		right[i] = t.records()
+	}
+
+	g := newGrouper()
+	for _, lt := range inputs[0] {
+		for _, lrec := range lt.records() {
+			for _, rrecs := range right {
+				for _, rrec := range rrecs {
+					if !recordsEqual(lrec, rrec, on) {
+						continue
+					}
+
+					key := make(map[string]string)
+					var cols []string
+					var values []interface{}
+					for _, c := range on {
+						if v, ok := lt.Key[c]; ok {
+							key[c] = v
+						} else {
+							cols, values = append(cols, c), append(values, lrec.value(c))
+						}
+					}
+					for i, rec := range []record{lrec, rrec} {
+						for j, c := range rec.columns {
+							if !contains(on, c) {
+								cols, values = append(cols, c+"_"+obj.keys[i]), append(values, rec.values[j])
+							}
+						}
+					}
+					g.add(key, record{columns: cols, values: values})
+				}
+			}
+		}
+	}
+	return g.tables(), nil
+}
+
+// query represents a read from a database that hasn't been executed yet.
+type query struct {
+	database        string
+	retentionPolicy string
+
+	start, stop time.Time
+
+	measurement string
+	fields      []string
+	tags        []*tagFilter
+	predicates  []Expr // evaluated against records after reading
+	param       string // the record parameter of the predicates
+
+	grouped bool
+	columns []string
+
+	fn    string
+	every time.Duration
+}
+
+// tagFilter represents a tag that must equal a value.
+type tagFilter struct {
+	key, value string
+}
+
+// clone returns a copy of the query.
+func (q *query) clone() *query {
+	other := *q
+	other.fields = append([]string(nil), q.fields...)
+	other.tags = append([]*tagFilter(nil), q.tags...)
+	other.predicates = append([]Expr(nil), q.predicates...)
+	other.columns = append([]string(nil), q.columns...)
+	return &other
+}
+
+// withRange returns a copy of the query limited to a time range. Times are
+// durations relative to now, times, or integer Unix timestamps in seconds.
+// The range includes its start and excludes its stop, which defaults to now.
+func (q *query) withRange(args map[string]interface{}, now time.Time) (*query, error) {
+	start, ok := args["start"]
+	if !ok {
+		return nil, errors.New("range() requires a start")
+	}
+
+	other := q.clone()
+	var err error
+	if other.start, err = rangeTime(start, now); err != nil {
+		return nil, err
+	}
+	other.stop = now
+	if stop, ok := args["stop"]; ok {
+		if other.stop, err = rangeTime(stop, now); err != nil {
+			return nil, err
+		}
+	}
+	if !other.start.Before(other.stop) {
+		return nil, errors.New("range() start must be before stop")
+	}
+	return other, nil
+}
+
+// rangeTime returns the time of a range() argument.
+func rangeTime(v interface{}, now time.Time) (time.Time, error) {
+	switch v := v.(type) {
+	case time.Duration:
+		return now.Add(v), nil
+	case time.Time:
+		return v, nil
+	case int64:
+		return time.Unix(v, 0).UTC(), nil
+	}
+	return time.Time{}, errors.New("range() times must be durations, times or integers")
+}
+
+// withFilter returns a copy of the query limited to the records matching a
+// predicate. Comparisons of the measurement, fields and tags to strings that
+// are joined by "and" are compiled into the query. Other comparisons are
+// evaluated against each record read.
+func (q *query) withFilter(args map[string]interface{}) (*query, error) {
+	fn, ok := args["fn"].(*FunctionExpr)
+	if !ok || len(fn.Params) != 1 {
+		return nil, errors.New("filter() requires a function with one parameter")
+	} else if q.fn != "" {
+		return nil, errors.New("filter() must be called before aggregates")
+	} else if q.param != "" && q.param != fn.Params[0] {
+		return nil, errors.New("filter() functions must use the same parameter name")
+	}
+
+	other := q.clone()
+	other.param = fn.Params[0]
+	for _, expr := range conjunction(fn.Body) {
+		if ok, err := other.compile(expr); err != nil {
+			return nil, err
+		} else if !ok {
+			other.predicates = append(other.predicates, expr)
+		}
+	}
+	return other, nil
+}
+
+// compile adds a condition to the query. Returns false if it can't be compiled.
+func (q *query) compile(expr Expr) (bool, error) {
+	// A disjunction can only be compiled if it lists fields.
+	if e, ok := unparen(expr).(*BinaryExpr); ok && e.Op == OR {
+		var fields []string
+		for _, expr := range disjunction(e) {
+			key, value, ok := q.equality(expr)
+			if !ok || key != FieldColumn {
+				return false, nil
+			}
+			fields = append(fields, value)
+		}
+		q.restrictFields(fields)
+		return true, nil
+	}
+
+	key, value, ok := q.equality(expr)
+	if !ok {
+		return false, nil
+	}
+	switch key {
+	case MeasurementColumn:
+		if q.measurement != "" && q.measurement != value {
+			return false, errors.New("filter() can only read one measurement")
+		}
+		q.measurement = value
+	case FieldColumn:
+		q.restrictFields([]string{value})
+	case TimeColumn, ValueColumn:
+		return false, nil
+	default:
+		q.tags = append(q.tags, &tagFilter{key: key, value: value})
+	}
+	return true, nil
+}
+
+// restrictFields limits the fields read to those also in fields.
+func (q *query) restrictFields(fields []string) {
+	if q.fields == nil {
+		q.fields = fields
+		return
+	}
+	var a []string
+	for _, f := range q.fields {
+		if contains(fields, f) {
+			a = append(a, f)
+		}
+	}
+	q.fields = append([]string{}, a...)
+}
+
+// equality returns the column and string of an expression comparing a
+// column of the record parameter to a string: r.host == "serverA".
+func (q *query) equality(expr Expr) (key, value string, ok bool) {
+	e, ok := unparen(expr).(*BinaryExpr)
+	if !ok || e.Op != EQ {
+		return "", "", false
+	}
+	lhs, rhs := unparen(e.LHS), unparen(e.RHS)
+	if _, ok := lhs.(*StringLiteral); ok {
+		lhs, rhs = rhs, lhs
+	}
+
+	m, ok := lhs.(*MemberExpr)
+	if !ok {
+		return "", "", false
+	} else if ident, ok := m.Object.(*Identifier); !ok || ident.Name != q.param {
+		return "", "", false
+	}
+	s, ok := rhs.(*StringLiteral)
+	if !ok {
+		return "", "", false
+	}
+	return m.Property, s.Val, true
+}
+
+// withGroup returns a copy of the query grouped by tag columns.
+func (q *query) withGroup(args map[string]interface{}) (*query, error) {
+	columns, err := stringsArg(args, "columns")
+	if err != nil {
+		return nil, err
+	} else if q.fn != "" {
+		return nil, errors.New("group() must be called before aggregates")
+	}
+	for _, c := range columns {
+		if strings.HasPrefix(c, "_") {
+			return nil, fmt.Errorf("group() can only group by tags: %s", c)
+		}
+	}
+
+	other := q.clone()
+	other.grouped, other.columns = true, columns
+	return other, nil
+}
+
+// withWindow returns a copy of the query aggregated over windows of time.
+func (q *query) withWindow(args map[string]interface{}) (*query, error) {
+	every, ok := args["every"].(time.Duration)
+	if !ok || every <= 0 {
+		return nil, errors.New("aggregateWindow() requires a positive every duration")
+	}
+	fn, ok := args["fn"].(aggregate)
+	if !ok {
+		return nil, errors.New("aggregateWindow() requires an aggregate fn")
+	}
+	return q.withAggregate(string(fn), every)
+}
+
+// withAggregate returns a copy of the query aggregated by a function, over
+// windows of time if every is set.
+func (q *query) withAggregate(fn string, every time.Duration) (*query, error) {
+	if q.fn != "" {
+		return nil, errors.New("tables can only be aggregated once")
+	} else if len(q.predicates) > 0 {
+		return nil, errors.New("only filters on the measurement, fields and tags can be aggregated")
+	}
+	other := q.clone()
+	other.fn, other.every = fn, every
+	return other, nil
+}
+
+// execute reads the query's tables. Each field is read separately and each
+// series is a table unless the query is grouped.
+func (q *query) execute(e Executor) ([]*Table, error) {
+	if q.measurement == "" {
+		return nil, errors.New("filter() on _measurement required")
+	} else if q.fields == nil {
+		return nil, errors.New("filter() on _field required")
+	}
+
+	dimensions := q.columns
+	if !q.grouped {
+		keys, err := e.TagKeys(q.database, q.measurement)
+		if err != nil {
+			return nil, err
+		}
+		dimensions = keys
+	}
+
+	var tables []*Table
+	for _, field := range q.fields {
+		rows, err := e.ExecuteSelect(q.selectStatement(field, dimensions), q.database, q.retentionPolicy)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, row := range rows {
+			t := &Table{Key: map[string]string{MeasurementColumn: row.Name, FieldColumn: field}}
+			for k, v := range row.Tags {
+				t.Key[k] = v
+			}
+
+			// Aggregates over the whole range don't have a time. Row
+			// timestamps are in microseconds.
+			if q.fn != "" && q.every == 0 {
+				t.Columns = []string{ValueColumn}
+			} else {
+				t.Columns = []string{TimeColumn, ValueColumn}
+			}
+			for _, values := range row.Values {
+				if len(t.Columns) == 1 {
+					t.Values = append(t.Values, []interface{}{values[1]})
+				} else if timestamp, ok := values[0].(int64); ok {
+					t.Values = append(t.Values, []interface{}{time.Unix(0, timestamp*int64(time.Microsecond)).UTC(), values[1]})
+				}
+			}
+			if t, err = q.filter(t); err != nil {
+				return nil, err
+			} else if len(t.Values) > 0 {
+				tables = append(tables, t)
+			}
+		}
+	}
+	sort.Sort(tableSlice(tables))
+	return tables, nil
+}
+
+// selectStatement returns the statement that reads a field.
+func (q *query) selectStatement(field string, dimensions []string) *influxql.SelectStatement {
+	var expr influxql.Expr = &influxql.VarRef{Val: field}
+	if q.fn != "" {
+		expr = &influxql.Call{Name: q.fn, Args: []influxql.Expr{expr}}
+	}
+
+	var cond influxql.Expr
+	and := func(expr influxql.Expr) {
+		if cond == nil {
+			cond = expr
+		} else {
+			cond = &influxql.BinaryExpr{Op: influxql.AND, LHS: cond, RHS: expr}
+		}
+	}
+	if !q.start.IsZero() {
+		and(&influxql.BinaryExpr{Op: influxql.GTE, LHS: &influxql.VarRef{Val: "time"}, RHS: &influxql.TimeLiteral{Val: q.start}})
+		and(&influxql.BinaryExpr{Op: influxql.LT, LHS: &influxql.VarRef{Val: "time"}, RHS: &influxql.TimeLiteral{Val: q.stop}})
+	}
+	for _, t := range q.tags {
+		and(&influxql.BinaryExpr{Op: influxql.EQ, LHS: &influxql.VarRef{Val: t.key}, RHS: &influxql.StringLiteral{Val: t.value}})
+	}
+
+	stmt := &influxql.SelectStatement{
+		Fields:    influxql.Fields{{Expr: expr}},
+		Source:    &influxql.Measurement{Name: q.measurement},
+		Condition: cond,
+	}
+	if q.every > 0 {
+		stmt.Dimensions = append(stmt.Dimensions, &influxql.Dimension{Expr: &influxql.Call{Name: "time", Args: []influxql.Expr{&influxql.DurationLiteral{Val: q.every}}}})
+	}
+	for _, k := range dimensions {
+		stmt.Dimensions = append(stmt.Dimensions, &influxql.Dimension{Expr: &influxql.VarRef{Val: k}})
+	}
+	return stmt
+}
+
+// filter removes the records of a table that don't match the predicates.
+func (q *query) filter(t *Table) (*Table, error) {
+	if len(q.predicates) == 0 {
+		return t, nil
+	}
+
+	other := &Table{Key: t.Key, Columns: t.Columns}
+	for i, rec := range t.records() {
+		if ok, err := q.matches(rec); err != nil {
+			return nil, err
+		} else if ok {
+			other.Values = append(other.Values, t.Values[i])
+		}
+	}
+	return other, nil
+}
+
+// matches returns true if a record matches every predicate.
+func (q *query) matches(rec record) (bool, error) {
+	for _, expr := range q.predicates {
+		if v, err := evalPredicate(expr, q.param, rec); err != nil {
+			return false, err
+		} else if v != true {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// evalPredicate evaluates a filter expression against a record.
+func evalPredicate(expr Expr, param string, rec record) (interface{}, error) {
+	switch expr := expr.(type) {
+	case *ParenExpr:
+		return evalPredicate(expr.Expr, param, rec)
+	case *MemberExpr:
+		if ident, ok := expr.Object.(*Identifier); !ok || ident.Name != param {
+			return nil, fmt.Errorf("unsupported filter expression: %s", expr)
+		}
+		return rec.value(expr.Property), nil
+	case *StringLiteral:
+		return expr.Val, nil
+	case *IntegerLiteral:
+		return float64(expr.Val), nil
+	case *NumberLiteral:
+		return expr.Val, nil
+	case *TimeLiteral:
+		return expr.Val, nil
+	case *BinaryExpr:
+		lhs, err := evalPredicate(expr.LHS, param, rec)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := evalPredicate(expr.RHS, param, rec)
+		if err != nil {
+			return nil, err
+		}
+
+		switch expr.Op {
+		case AND:
+			return lhs == true && rhs == true, nil
+		case OR:
+			return lhs == true || rhs == true, nil
+		}
+		cmp, ok := compare(lhs, rhs)
+		if !ok {
+			return expr.Op == NEQ, nil
+		}
+		switch expr.Op {
+		case EQ:
+			return cmp == 0, nil
+		case NEQ:
+			return cmp != 0, nil
+		case LT:
+			return cmp < 0, nil
+		case LTE:
+			return cmp <= 0, nil
+		case GT:
+			return cmp > 0, nil
+		case GTE:
+			return cmp >= 0, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported filter expression: %s", expr)
+}
+
+// conjunction returns the expressions joined by "and" in expr.
+func conjunction(expr Expr) []Expr {
+	if e, ok := unparen(expr).(*BinaryExpr); ok && e.Op == AND {
+		return append(conjunction(e.LHS), conjunction(e.RHS)...)
+	}
+	return []Expr{expr}
+}
+
+// disjunction returns the expressions joined by "or" in expr.
+func disjunction(expr Expr) []Expr {
+	if e, ok := unparen(expr).(*BinaryExpr); ok && e.Op == OR {
+		return append(disjunction(e.LHS), disjunction(e.RHS)...)
+	}
+	return []Expr{expr}
+}
+
+// unparen returns the expression inside any parentheses.
+func unparen(expr Expr) Expr {
+	for {
+		e, ok := expr.(*ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = e.Expr
+	}
+}
+
+// pivot turns the values of columns into columns. Records with the same row
+// key values become one record with a column for each column key value.
+// The column key columns are removed from the group key.
+func pivot(tables []*Table, args map[string]interface{}) ([]*Table, error) {
+	rowKey, err := stringsArg(args, "rowKey")
+	if err != nil {
+		return nil, err
+	}
+	columnKey, err := stringsArg(args, "columnKey")
+	if err != nil {
+		return nil, err
+	}
+	valueColumn, ok := args["valueColumn"].(string)
+	if !ok || len(rowKey) == 0 || len(columnKey) == 0 {
+		return nil, errors.New("pivot() requires rowKey, columnKey and valueColumn")
+	}
+
+	// Merge the records of each group by their row key values.
+	rows := make(map[string]record)
+	keys := make(map[string]map[string]string)
+	var order []string
+	for _, t := range tables {
+		key := make(map[string]string)
+		for k, v := range t.Key {
+			if !contains(columnKey, k) {
+				key[k] = v
+			}
+		}
+
+		for _, rec := range t.records() {
+			var names, ids []string
+			for _, c := range columnKey {
+				names = append(names, fmt.Sprint(rec.value(c)))
+			}
+			for _, c := range rowKey {
+				ids = append(ids, fmt.Sprint(rec.value(c)))
+			}
+			id := keyString(key) + "\x00" + strings.Join(ids, "\x00")
+
+			row, ok := rows[id]
+			if !ok {
+				for _, c := range rowKey {
+					row.columns, row.values = append(row.columns, c), append(row.values, rec.value(c))
+				}
+				order = append(order, id)
+			}
+			row.set(strings.Join(names, "_"), rec.value(valueColumn))
+			rows[id], keys[id] = row, key
+		}
+	}
+
+	// Pivoted records are sorted by their row key values.
+	a := make([]record, len(order))
+	for i, id := range order {
+		a[i] = rows[id]
+	}
+	sort.Stable(recordSlice{records: a, ids: order, columns: rowKey})
+
+	g := newGrouper()
+	for i, id := range order {
+		g.add(keys[id], a[i])
+	}
+	return g.tables(), nil
+}
+
+// limit returns the first n records of each table.
+func limit(tables []*Table, args map[string]interface{}) ([]*Table, error) {
+	n, ok := args["n"].(int64)
+	if !ok || n < 0 {
+		return nil, errors.New("limit() requires a non-negative n")
+	}
+
+	a := make([]*Table, len(tables))
+	for i, t := range tables {
+		other := *t
+		if int64(len(other.Values)) > n {
+			other.Values = other.Values[:n]
+		}
+		a[i] = &other
+	}
+	return a, nil
+}
+
+// stringsArg returns an argument that is a list of strings.
+func stringsArg(args map[string]interface{}, name string) ([]string, error) {
+	v, ok := args[name]
+	if !ok {
+		return nil, nil
+	}
+	a, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a list of strings", name)
+	}
+	strs := make([]string, len(a))
+	for i := range a {
+		if strs[i], ok = a[i].(string); !ok {
+			return nil, fmt.Errorf("%s must be a list of strings", name)
+		}
+	}
+	return strs, nil
+}
+
+// record represents the columns and values of a record, including the
+// columns of its table's group key.
+type record struct {
+	columns []string
+	values  []interface{}
+}
+
+// records returns the records of a table. Group key columns are sorted and
+// come first.
+func (t *Table) records() []record {
+	var keys []string
+	for k := range t.Key {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	columns := append(keys, t.Columns...)
+	a := make([]record, len(t.Values))
+	for i, values := range t.Values {
+		rec := record{columns: columns}
+		for _, k := range keys {
+			rec.values = append(rec.values, t.Key[k])
+		}
+		rec.values = append(rec.values, values...)
+		a[i] = rec
+	}
+	return a
+}
+
+// value returns the value of a column or nil if the record doesn't have it.
+func (r *record) value(column string) interface{} {
+	for i, c := range r.columns {
+		if c == column {
+			return r.values[i]
+		}
+	}
+	return nil
+}
+
+// set sets the value of a column, adding it if the record doesn't have it.
+func (r *record) set(column string, v interface{}) {
+	for i, c := range r.columns {
+		if c == column {
+			r.values[i] = v
+			return
+		}
+	}
+	r.columns, r.values = append(r.columns, column), append(r.values, v)
+}
+
+// recordsEqual returns true if two records have equal values in columns.
+func recordsEqual(a, b record, columns []string) bool {
+	for _, c := range columns {
+		if cmp, ok := compare(a.value(c), b.value(c)); !ok || cmp != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// compare compares two values of the same type. Integers are compared as
+// floats. Returns false if the values can't be compared.
+func compare(a, b interface{}) (int, bool) {
+	a, b = toFloat(a), toFloat(b)
+
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1, true
+			case a > b:
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			if a == b {
+				return 0, true
+			}
+			return 1, true
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			switch {
+			case a.Before(b):
+				return -1, true
+			case a.After(b):
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+// toFloat returns integers as floats and other values unchanged.
+func toFloat(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	}
+	return v
+}
+
+// grouper collects records into tables by group key. Table columns are the
+// columns of their records in the order they're first seen.
+type grouper struct {
+	m     map[string]*Table
+	order []string
+}
+
+func newGrouper() *grouper {
+	return &grouper{m: make(map[string]*Table)}
+}
+
+// add adds a record to the table of a group key.
+func (g *grouper) add(key map[string]string, rec record) {
+	id := keyString(key)
+	t := g.m[id]
+	if t == nil {
+		t = &Table{Key: key}
+		g.m[id] = t
+		g.order = append(g.order, id)
+	}
+
+	values := make([]interface{}, len(t.Columns))
+	for i, c := range rec.columns {
+		j := indexOf(t.Columns, c)
+		if j < 0 {
+			t.Columns = append(t.Columns, c)
+			for k := range t.Values {
+				t.Values[k] = append(t.Values[k], nil)
+			}
+			values = append(values, nil)
+			j = len(t.Columns) - 1
+		}
+		values[j] = rec.values[i]
+	}
+	t.Values = append(t.Values, values)
+}
+
+// tables returns the tables sorted by group key.
+func (g *grouper) tables() []*Table {
+	a := make([]*Table, 0, len(g.order))
+	for _, id := range g.order {
+		a = append(a, g.m[id])
+	}
+	sort.Sort(tableSlice(a))
+	return a
+}
+
+// keyString returns a string that uniquely identifies a group key.
+func keyString(key map[string]string) string {
+	var keys []string
+	for k := range key {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf []string
+	for _, k := range keys {
+		buf = append(buf, k+"\x00"+key[k])
+	}
+	return strings.Join(buf, "\x00")
+}
+
+// recordSlice sorts records by the values of columns. Each record has an id
+// that is sorted with it.
+type recordSlice struct {
+	records []record
+	ids     []string
+	columns []string
+}
+
+func (a recordSlice) Len() int { return len(a.records) }
+func (a recordSlice) Swap(i, j int) {
+	a.records[i], a.records[j] = a.records[j], a.records[i]
+	a.ids[i], a.ids[j] = a.ids[j], a.ids[i]
+}
+func (a recordSlice) Less(i, j int) bool {
+	for _, c := range a.columns {
+		if cmp, ok := compare(a.records[i].value(c), a.records[j].value(c)); ok && cmp != 0 {
+			return cmp < 0
+		}
+	}
+	return false
+}
+
+// tableSlice sorts tables by their group keys.
+type tableSlice []*Table
+
+func (a tableSlice) Len() int           { return len(a) }
+func (a tableSlice) Less(i, j int) bool { return keyString(a[i].Key) < keyString(a[j].Key) }
+func (a tableSlice) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// contains returns true if a contains s.
+func contains(a []string, s string) bool { return indexOf(a, s) >= 0 }
+
+// indexOf returns the index of s in a or -1 if a doesn't contain it.
+func indexOf(a []string, s string) int {
+	for i := range a {
+		if a[i] == s {
+			return i
+		}
+	}
+	return -1
+}
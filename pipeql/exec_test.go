@@ -0,0 +1,226 @@
+package pipeql_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/pipeql"
+)
+
+// Ensure reads are compiled to select statements.
+func TestInterpreter_Execute_Statements(t *testing.T) {
+	var tests = []struct {
+		s     string
+		stmts []string
+	}{
+		// Every series is a table unless tables are grouped.
+		{
+			s:     `from(bucket: "db") |> range(start: -1h) |> filter(fn: (r) => r._measurement == "cpu" and r._field == "value")`,
+			stmts: []string{`SELECT value FROM cpu WHERE time >= "1999-12-31 23:00:00" AND time < "2000-01-01 00:00:00" GROUP BY host, region`},
+		},
+		{
+			s: `from(bucket: "db/rp")
+				|> range(start: 2000-01-01T00:00:00Z, stop: 946688400)
+				|> filter(fn: (r) => (r._field == "user" or r._field == "system") and r["_measurement"] == "cpu" and "a" == r.host)
+				|> group(columns: ["region"])
+				|> aggregateWindow(every: 10m, fn: mean)`,
+			stmts: []string{
+				`SELECT mean(user) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00" AND host = "a" GROUP BY time(10m), region`,
+				`SELECT mean(system) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00" AND host = "a" GROUP BY time(10m), region`,
+			},
+		},
+		{
+			s:     `from(bucket: "db") |> filter(fn: (r) => r._measurement == "cpu" and r._field == "value") |> group() |> count()`,
+			stmts: []string{`SELECT count(value) FROM cpu`},
+		},
+
+		// Filters on the same column narrow the fields read.
+		{
+			s:     `from(bucket: "db") |> filter(fn: (r) => r._measurement == "cpu" and (r._field == "a" or r._field == "b")) |> filter(fn: (r) => r._field == "b") |> group()`,
+			stmts: []string{`SELECT b FROM cpu`},
+		},
+		{
+			s:     `from(bucket: "db") |> filter(fn: (r) => r._measurement == "cpu" and r._field == "a" and r._field == "b")`,
+			stmts: nil,
+		},
+	}
+
+	for i, tt := range tests {
+		e := NewExecutor()
+		if _, err := e.Execute(tt.s); err != nil {
+			t.Errorf("%d. unexpected error: %s", i, err)
+		} else if !reflect.DeepEqual(e.stmts, tt.stmts) {
+			t.Errorf("%d. statements mismatch:\n\nexp=%q\n\ngot=%q", i, tt.stmts, e.stmts)
+		} else if len(tt.stmts) > 0 && e.rps[0] != map[bool]string{true: "rp", false: ""}[i == 1] {
+			t.Errorf("%d. unexpected retention policy: %s", i, e.rps[0])
+		}
+	}
+}
+
+// Ensure rows are returned as tables and transformed in memory.
+func TestInterpreter_Execute_Tables(t *testing.T) {
+	t0 := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	us := func(d time.Duration) int64 { return t0.Add(d).UnixNano() / int64(time.Microsecond) }
+
+	var tests = []struct {
+		s    string
+		rows map[string][]*influxql.Row
+		exp  string
+	}{
+		// Series are tables with the measurement and field in their key.
+		{
+			s: `from(bucket: "db") |> filter(fn: (r) => r._measurement == "cpu" and r._field == "value")`,
+			rows: map[string][]*influxql.Row{
+				"value": {
+					{Name: "cpu", Tags: map[string]string{"host": "b", "region": ""}, Columns: []string{"time", "value"}, Values: [][]interface{}{{us(0), 2.0}}},
+					{Name: "cpu", Tags: map[string]string{"host": "a", "region": ""}, Columns: []string{"time", "value"}, Values: [][]interface{}{{us(0), 1.0}, {us(time.Second), 3.0}}},
+				},
+			},
+			exp: `[{"name":"_result","tables":[{"key":{"_field":"value","_measurement":"cpu","host":"a","region":""},"columns":["_time","_value"],"values":[["2000-01-01T00:00:00Z",1],["2000-01-01T00:00:01Z",3]]},{"key":{"_field":"value","_measurement":"cpu","host":"b","region":""},"columns":["_time","_value"],"values":[["2000-01-01T00:00:00Z",2]]}]}]`,
+		},
+
+		// Other filters are evaluated against each record.
+		{
+			s: `from(bucket: "db") |> filter(fn: (r) => r._measurement == "cpu" and r._field == "value" and (r._value > 1 or r._time == 2000-01-01T00:00:00Z)) |> filter(fn: (r) => r._value != 3) |> group()`,
+			rows: map[string][]*influxql.Row{
+				"value": {{Name: "cpu", Columns: []string{"time", "value"}, Values: [][]interface{}{{us(0), 1.0}, {us(time.Second), 2.0}, {us(2 * time.Second), 3.0}, {us(3 * time.Second), 0.5}}}},
+			},
+			exp: `[{"name":"_result","tables":[{"key":{"_field":"value","_measurement":"cpu"},"columns":["_time","_value"],"values":[["2000-01-01T00:00:00Z",1],["2000-01-01T00:00:01Z",2]]}]}]`,
+		},
+
+		// Aggregates over the whole range have no time.
+		{
+			s: `from(bucket: "db") |> filter(fn: (r) => r._measurement == "cpu" and r._field == "value") |> group() |> sum() |> yield(name: "total")`,
+			rows: map[string][]*influxql.Row{
+				"sum(value)": {{Name: "cpu", Columns: []string{"time", "sum"}, Values: [][]interface{}{{int64(0), 6.0}}}},
+			},
+			exp: `[{"name":"total","tables":[{"key":{"_field":"value","_measurement":"cpu"},"columns":["_value"],"values":[[6]]}]}]`,
+		},
+
+		// Pivoting turns fields into columns.
+		{
+			s: `from(bucket: "db") |> filter(fn: (r) => r._measurement == "cpu" and (r._field == "user" or r._field == "system")) |> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value") |> limit(n: 2)`,
+			rows: map[string][]*influxql.Row{
+				"user":   {{Name: "cpu", Tags: map[string]string{"host": "a", "region": "west"}, Columns: []string{"time", "user"}, Values: [][]interface{}{{us(0), 1.0}, {us(time.Second), 2.0}, {us(2 * time.Second), 3.0}}}},
+				"system": {{Name: "cpu", Tags: map[string]string{"host": "a", "region": "west"}, Columns: []string{"time", "system"}, Values: [][]interface{}{{us(time.Second), 20.0}}}},
+			},
+			exp: `[{"name":"_result","tables":[{"key":{"_measurement":"cpu","host":"a","region":"west"},"columns":["_time","user","system"],"values":[["2000-01-01T00:00:00Z",1,null],["2000-01-01T00:00:01Z",2,20]]}]}]`,
+		},
+
+		// Joins match records of two streams.
+		{
+			s: `
+				cpu = from(bucket: "db") |> filter(fn: (r) => r._measurement == "cpu" and r._field == "value")
+				mem = from(bucket: "db") |> filter(fn: (r) => r._measurement == "mem" and r._field == "free")
+				join(tables: {cpu: cpu, mem: mem}, on: ["_time", "host"])`,
+			rows: map[string][]*influxql.Row{
+				"value": {
+					{Name: "cpu", Tags: map[string]string{"host": "a", "region": ""}, Columns: []string{"time", "value"}, Values: [][]interface{}{{us(0), 1.0}, {us(time.Second), 2.0}}},
+					{Name: "cpu", Tags: map[string]string{"host": "b", "region": ""}, Columns: []string{"time", "value"}, Values: [][]interface{}{{us(0), 3.0}}},
+				},
+				"free": {{Name: "mem", Tags: map[string]string{"host": "a", "region": ""}, Columns: []string{"time", "free"}, Values: [][]interface{}{{us(time.Second), 100.0}}}},
+			},
+			exp: `[{"name":"_result","tables":[{"key":{"host":"a"},"columns":["_time","_field_cpu","_measurement_cpu","region_cpu","_value_cpu","_field_mem","_measurement_mem","region_mem","_value_mem"],"values":[["2000-01-01T00:00:01Z","value","cpu","",2,"free","mem","",100]]}]}]`,
+		},
+	}
+
+	for i, tt := range tests {
+		e := NewExecutor()
+		e.rows = tt.rows
+		results, err := e.Execute(tt.s)
+		if err != nil {
+			t.Errorf("%d. unexpected error: %s", i, err)
+		} else if s := mustMarshalJSON(results); s != tt.exp {
+			t.Errorf("%d. results mismatch:\n\nexp=%s\n\ngot=%s", i, tt.exp, s)
+		}
+	}
+}
+
+// Ensure invalid programs return errors.
+func TestInterpreter_Execute_Err(t *testing.T) {
+	var tests = []struct {
+		s   string
+		err string
+	}{
+		{s: `x`, err: `undefined: x`},
+		{s: `foo()`, err: `foo() requires piped tables`},
+		{s: `from(bucket: "db") |> foo()`, err: `undefined function: foo()`},
+		{s: `from(bucket: "db") |> from(bucket: "db")`, err: `from() cannot be piped`},
+		{s: `from()`, err: `from() requires a bucket`},
+		{s: `1`, err: `expected tables: 1`},
+		{s: `from(bucket: "db")`, err: `filter() on _measurement required`},
+		{s: `from(bucket: "db") |> filter(fn: (r) => r._measurement == "cpu")`, err: `filter() on _field required`},
+		{s: `from(bucket: "db") |> filter(fn: (r) => r._measurement == "cpu" and r._measurement == "mem")`, err: `filter() can only read one measurement`},
+		{s: `from(bucket: "db") |> filter(fn: 1)`, err: `filter() requires a function with one parameter`},
+		{s: `from(bucket: "db") |> filter(fn: (r) => r._value > 1) |> mean()`, err: `only filters on the measurement, fields and tags can be aggregated`},
+		{s: `from(bucket: "db") |> mean() |> filter(fn: (r) => r.host == "a")`, err: `filter() must be called before aggregates`},
+		{s: `from(bucket: "db") |> mean() |> sum()`, err: `tables can only be aggregated once`},
+		{s: `from(bucket: "db") |> range()`, err: `range() requires a start`},
+		{s: `from(bucket: "db") |> range(start: "now")`, err: `range() times must be durations, times or integers`},
+		{s: `from(bucket: "db") |> range(start: 0s)`, err: `range() start must be before stop`},
+		{s: `from(bucket: "db") |> group(columns: ["_field"])`, err: `group() can only group by tags: _field`},
+		{s: `from(bucket: "db") |> group(columns: "host")`, err: `columns must be a list of strings`},
+		{s: `from(bucket: "db") |> aggregateWindow(every: 1m, fn: median)`, err: `undefined: median`},
+		{s: `from(bucket: "db") |> aggregateWindow(every: 1m)`, err: `aggregateWindow() requires an aggregate fn`},
+		{s: `from(bucket: "db") |> aggregateWindow(every: -1m, fn: mean)`, err: `aggregateWindow() requires a positive every duration`},
+		{s: `join(tables: {a: 1}, on: ["_time"])`, err: `join() requires two tables`},
+		{s: `from(bucket: "db") |> filter(fn: (r) => r._measurement == "cpu" and r._field == "value") |> yield() 1`, err: `duplicate result name "_result": name results with yield()`},
+	}
+
+	for i, tt := range tests {
+		_, err := NewExecutor().Execute(tt.s)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%v", i, tt.s, tt.err, err)
+		}
+	}
+}
+
+// Executor is a mock executor that records statements and returns the rows
+// of the field or call they select.
+type Executor struct {
+	stmts []string
+	rps   []string
+	rows  map[string][]*influxql.Row
+}
+
+// NewExecutor returns a new instance of Executor.
+func NewExecutor() *Executor {
+	return &Executor{rows: make(map[string][]*influxql.Row)}
+}
+
+// Execute parses and executes a program at 2000-01-01T00:00:00Z.
+func (e *Executor) Execute(s string) ([]*pipeql.Result, error) {
+	prog, err := pipeql.ParseProgram(s)
+	if err != nil {
+		return nil, err
+	}
+	interp := pipeql.NewInterpreter(e)
+	interp.Now = func() time.Time { return time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC) }
+	return interp.Execute(prog)
+}
+
+func (e *Executor) ExecuteSelect(stmt *influxql.SelectStatement, database, retentionPolicy string) ([]*influxql.Row, error) {
+	if database != "db" {
+		return nil, fmt.Errorf("unexpected database: %s", database)
+	}
+	e.stmts = append(e.stmts, stmt.String())
+	e.rps = append(e.rps, retentionPolicy)
+	return e.rows[stmt.Fields[0].Expr.String()], nil
+}
+
+func (e *Executor) TagKeys(database, measurement string) ([]string, error) {
+	return []string{"host", "region"}, nil
+}
+
+// mustMarshalJSON encodes a value to JSON.
+func mustMarshalJSON(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,411 @@
+package pipeql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parser represents a parser for the pipe query language.
+type Parser struct {
+	toks []item
+	i    int
+}
+
+// item represents a scanned token.
+type item struct {
+	tok Token
+	pos Pos
+	lit string
+}
+
+// NewParser returns a new instance of Parser for a query.
+func NewParser(s string) *Parser {
+	p := &Parser{}
+	scanner := NewScanner(s)
+	for {
+		tok, pos, lit := scanner.Scan()
+		p.toks = append(p.toks, item{tok: tok, pos: pos, lit: lit})
+		if tok == EOF {
+			break
+		}
+	}
+	return p
+}
+
+// ParseProgram parses a query string and returns its AST representation.
+func ParseProgram(s string) (*Program, error) { return NewParser(s).ParseProgram() }
+
+// ParseProgram parses a list of statements. Statements end where their
+// expression does, so they don't need separators.
+func (p *Parser) ParseProgram() (*Program, error) {
+	prog := &Program{}
+	for p.peek().tok != EOF {
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		prog.Statements = append(prog.Statements, stmt)
+	}
+	if len(prog.Statements) == 0 {
+		return nil, newParseError("EOF", []string{"statement"}, p.peek().pos)
+	}
+	return prog, nil
+}
+
+// parseStatement parses an assignment or an expression statement.
+func (p *Parser) parseStatement() (Statement, error) {
+	if p.peek().tok == IDENT && p.peekN(1).tok == ASSIGN {
+		name := p.scan().lit
+		p.scan()
+		expr, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return &Assignment{Name: name, Value: expr}, nil
+	}
+
+	expr, err := p.ParseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return &ExprStatement{Expr: expr}, nil
+}
+
+// ParseExpr parses an expression.
+func (p *Parser) ParseExpr() (Expr, error) { return p.parseBinaryExpr(0) }
+
+// parseBinaryExpr parses operators with a precedence above min.
+func (p *Parser) parseBinaryExpr(min int) (Expr, error) {
+	expr, err := p.parsePipeExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek().tok
+		if !op.isOperator() || op.Precedence() <= min {
+			return expr, nil
+		}
+		p.scan()
+
+		rhs, err := p.parseBinaryExpr(op.Precedence())
+		if err != nil {
+			return nil, err
+		}
+		expr = &BinaryExpr{Op: op, LHS: expr, RHS: rhs}
+	}
+}
+
+// parsePipeExpr parses an expression followed by any number of piped calls.
+func (p *Parser) parsePipeExpr() (Expr, error) {
+	expr, err := p.parseUnaryExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().tok == PIPE {
+		p.scan()
+		it := p.scan()
+		if it.tok != IDENT || p.peek().tok != LPAREN {
+			return nil, newParseError(tokstr(it.tok, it.lit), []string{"function call"}, it.pos)
+		}
+		call, err := p.parseCallExpr(it.lit)
+		if err != nil {
+			return nil, err
+		}
+		expr = &PipeExpr{Input: expr, Call: call}
+	}
+	return expr, nil
+}
+
+// parseUnaryExpr parses a literal, identifier, call, object, array, function
+// or parenthesized expression. Identifiers can be followed by members.
+func (p *Parser) parseUnaryExpr() (Expr, error) {
+	it := p.scan()
+	switch it.tok {
+	case IDENT:
+		if p.peek().tok == LPAREN {
+			return p.parseCallExpr(it.lit)
+		}
+		return p.parseMembers(&Identifier{Name: it.lit})
+	case STRING:
+		return &StringLiteral{Val: it.lit}, nil
+	case INTEGER, NUMBER, DURATION:
+		return parseNumber(it, false)
+	case SUB:
+		if next := p.peek(); next.tok == INTEGER || next.tok == NUMBER || next.tok == DURATION {
+			return parseNumber(p.scan(), true)
+		}
+	case TIME:
+		t, err := time.Parse(time.RFC3339Nano, it.lit)
+		if err != nil {
+			return nil, &ParseError{Message: "invalid time: " + it.lit, Pos: it.pos}
+		}
+		return &TimeLiteral{Val: t.UTC()}, nil
+	case LBRACE:
+		props, err := p.parseProperties(RBRACE)
+		if err != nil {
+			return nil, err
+		}
+		return &ObjectExpr{Properties: props}, nil
+	case LBRACKET:
+		return p.parseArrayExpr()
+	case LPAREN:
+		if p.isFunction() {
+			return p.parseFunctionExpr()
+		}
+		expr, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if it := p.scan(); it.tok != RPAREN {
+			return nil, newParseError(tokstr(it.tok, it.lit), []string{")"}, it.pos)
+		}
+		return &ParenExpr{Expr: expr}, nil
+	}
+	return nil, newParseError(tokstr(it.tok, it.lit), []string{"identifier", "string", "number", "duration", "time", "{", "[", "("}, it.pos)
+}
+
+// parseMembers parses member accesses of an expression: "r.host" or r["host"].
+func (p *Parser) parseMembers(expr Expr) (Expr, error) {
+	for {
+		switch p.peek().tok {
+		case DOT:
+			p.scan()
+			it := p.scan()
+			if it.tok != IDENT {
+				return nil, newParseError(tokstr(it.tok, it.lit), []string{"identifier"}, it.pos)
+			}
+			expr = &MemberExpr{Object: expr, Property: it.lit}
+		case LBRACKET:
+			p.scan()
+			it := p.scan()
+			if it.tok != STRING {
+				return nil, newParseError(tokstr(it.tok, it.lit), []string{"string"}, it.pos)
+			} else if end := p.scan(); end.tok != RBRACKET {
+				return nil, newParseError(tokstr(end.tok, end.lit), []string{"]"}, end.pos)
+			}
+			expr = &MemberExpr{Object: expr, Property: it.lit}
+		default:
+			return expr, nil
+		}
+	}
+}
+
+// parseCallExpr parses the arguments of a call to a named function.
+func (p *Parser) parseCallExpr(name string) (*CallExpr, error) {
+	p.scan()
+	args, err := p.parseProperties(RPAREN)
+	if err != nil {
+		return nil, err
+	}
+	return &CallExpr{Name: name, Args: args}, nil
+}
+
+// parseProperties parses comma separated "key: value" pairs up to and
+// including an end token. A trailing comma is allowed.
+func (p *Parser) parseProperties(end Token) ([]*Property, error) {
+	var props []*Property
+	for {
+		if p.peek().tok == end {
+			p.scan()
+			return props, nil
+		}
+
+		key := p.scan()
+		if key.tok != IDENT && key.tok != STRING {
+			return nil, newParseError(tokstr(key.tok, key.lit), []string{"identifier", end.String()}, key.pos)
+		} else if it := p.scan(); it.tok != COLON {
+			return nil, newParseError(tokstr(it.tok, it.lit), []string{":"}, it.pos)
+		}
+		value, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		props = append(props, &Property{Key: key.lit, Value: value})
+
+		if it := p.peek(); it.tok == COMMA {
+			p.scan()
+		} else if it.tok != end {
+			return nil, newParseError(tokstr(it.tok, it.lit), []string{",", end.String()}, it.pos)
+		}
+	}
+}
+
+// parseArrayExpr parses comma separated values up to and including "]".
+func (p *Parser) parseArrayExpr() (*ArrayExpr, error) {
+	expr := &ArrayExpr{}
+	for {
+		if p.peek().tok == RBRACKET {
+			p.scan()
+			return expr, nil
+		}
+
+		elem, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		expr.Elements = append(expr.Elements, elem)
+
+		if it := p.peek(); it.tok == COMMA {
+			p.scan()
+		} else if it.tok != RBRACKET {
+			return nil, newParseError(tokstr(it.tok, it.lit), []string{",", "]"}, it.pos)
+		}
+	}
+}
+
+// isFunction returns true if the tokens after an opening parenthesis are
+// the parameters of a function: "(r) =>".
+func (p *Parser) isFunction() bool {
+	for i := 0; ; i++ {
+		switch p.peekN(i).tok {
+		case IDENT, COMMA:
+		case RPAREN:
+			return p.peekN(i+1).tok == ARROW
+		default:
+			return false
+		}
+	}
+}
+
+// parseFunctionExpr parses the parameters and body of a function.
+func (p *Parser) parseFunctionExpr() (*FunctionExpr, error) {
+	expr := &FunctionExpr{}
+	for {
+		it := p.scan()
+		if it.tok == RPAREN {
+			break
+		} else if it.tok == IDENT {
+			expr.Params = append(expr.Params, it.lit)
+		}
+	}
+	p.scan()
+
+	body, err := p.ParseExpr()
+	if err != nil {
+		return nil, err
+	}
+	expr.Body = body
+	return expr, nil
+}
+
+// scan returns the next token and advances. EOF is returned repeatedly at
+// the end of the query.
+func (p *Parser) scan() item {
+	it := p.toks[p.i]
+	if p.i < len(p.toks)-1 {
+		p.i++
+	}
+	return it
+}
+
+// peek returns the next token without advancing.
+func (p *Parser) peek() item { return p.peekN(0) }
+
+// peekN returns the token n tokens after the next one without advancing.
+func (p *Parser) peekN(n int) item {
+	if p.i+n >= len(p.toks) {
+		return p.toks[len(p.toks)-1]
+	}
+	return p.toks[p.i+n]
+}
+
+// parseNumber returns the literal for an integer, number or duration token.
+func parseNumber(it item, negative bool) (Expr, error) {
+	switch it.tok {
+	case INTEGER:
+		v, err := strconv.ParseInt(it.lit, 10, 64)
+		if err != nil {
+			return nil, &ParseError{Message: "invalid integer: " + it.lit, Pos: it.pos}
+		}
+		if negative {
+			v = -v
+		}
+		return &IntegerLiteral{Val: v}, nil
+	case NUMBER:
+		v, err := strconv.ParseFloat(it.lit, 64)
+		if err != nil {
+			return nil, &ParseError{Message: "invalid number: " + it.lit, Pos: it.pos}
+		}
+		if negative {
+			v = -v
+		}
+		return &NumberLiteral{Val: v}, nil
+	default:
+		d, err := ParseDuration(it.lit)
+		if err != nil {
+			return nil, &ParseError{Message: err.Error(), Pos: it.pos}
+		}
+		if negative {
+			d = -d
+		}
+		return &DurationLiteral{Val: d}, nil
+	}
+}
+
+// durationUnits are the units of duration literals.
+var durationUnits = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"µs": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+}
+
+// ParseDuration parses a duration made of one or more numbers followed by
+// units, such as "1h30m". Days ("d") and weeks ("w") are supported.
+func ParseDuration(s string) (time.Duration, error) {
+	var d time.Duration
+	rest := s
+	for rest != "" {
+		i := strings.IndexFunc(rest, func(ch rune) bool { return !isDigit(ch) })
+		if i <= 0 {
+			return 0, fmt.Errorf("invalid duration: %s", s)
+		}
+		n, err := strconv.ParseInt(rest[:i], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %s", s)
+		}
+		rest = rest[i:]
+
+		j := strings.IndexFunc(rest, isDigit)
+		if j < 0 {
+			j = len(rest)
+		}
+		unit, ok := durationUnits[rest[:j]]
+		if !ok {
+			return 0, fmt.Errorf("invalid duration: %s", s)
+		}
+		d += time.Duration(n) * unit
+		rest = rest[j:]
+	}
+	return d, nil
+}
+
+// ParseError represents an error that occurred during parsing.
+type ParseError struct {
+	Message  string
+	Found    string
+	Expected []string
+	Pos      Pos
+}
+
+// newParseError returns a new instance of ParseError.
+func newParseError(found string, expected []string, pos Pos) *ParseError {
+	return &ParseError{Found: found, Expected: expected, Pos: pos}
+}
+
+// Error returns the string representation of the error.
+func (e *ParseError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s at line %d, char %d", e.Message, e.Pos.Line+1, e.Pos.Char+1)
+	}
+	return fmt.Sprintf("found %s, expected %s at line %d, char %d", e.Found, strings.Join(e.Expected, ", "), e.Pos.Line+1, e.Pos.Char+1)
+}
@@ -0,0 +1,121 @@
+package pipeql_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/pipeql"
+)
+
+// Ensure the parser can parse programs into an AST.
+func TestParser_ParseProgram(t *testing.T) {
+	var tests = []struct {
+		s   string
+		exp string
+	}{
+		{
+			s:   `from(bucket: "db/rp")`,
+			exp: `from(bucket: "db/rp")`,
+		},
+
+		// Pipes can span lines and end with a comment.
+		{
+			s: `from(bucket:"db")
+				|> range(start: -1h, stop: 2000-01-01T00:00:00Z) // last hour
+				|> filter(fn: (r) => r._measurement == "cpu" and (r["host"] == "a" or r.host != "b"))
+				|> aggregateWindow(every: 1m30s, fn: mean,)`,
+			exp: `from(bucket: "db") |> range(start: -1h0m0s, stop: 2000-01-01T00:00:00Z) |> filter(fn: (r) => r._measurement == "cpu" and (r.host == "a" or r.host != "b")) |> aggregateWindow(every: 1m30s, fn: mean)`,
+		},
+
+		// Statements end with their expression.
+		{
+			s: `a = from(bucket: "db") b = from(bucket: "db")
+				join(tables: {a: a, "b": b}, on: ["_time", "host"]) |> limit(n: 10) |> yield(name: "joined")`,
+			exp: "a = from(bucket: \"db\")\nb = from(bucket: \"db\")\njoin(tables: {a: a, b: b}, on: [\"_time\", \"host\"]) |> limit(n: 10) |> yield(name: \"joined\")",
+		},
+
+		// Operators bind by precedence.
+		{
+			s:   `f(fn: (r) => r._value > 1.5 and r._value <= -2 or r.x >= 3 and r.y < 4)`,
+			exp: `f(fn: (r) => r._value > 1.5 and r._value <= -2 or r.x >= 3 and r.y < 4)`,
+		},
+		{
+			s:   `f(fn: () => 1, x: [], y: {})`,
+			exp: `f(fn: () => 1, x: [], y: {})`,
+		},
+	}
+
+	for i, tt := range tests {
+		prog, err := pipeql.ParseProgram(tt.s)
+		if err != nil {
+			t.Errorf("%d. %q: unexpected error: %s", i, tt.s, err)
+		} else if s := prog.String(); s != tt.exp {
+			t.Errorf("%d. %q: mismatch:\n\nexp=%s\n\ngot=%s", i, tt.s, tt.exp, s)
+		}
+	}
+}
+
+// Ensure the parser returns errors for invalid programs.
+func TestParser_ParseProgram_Err(t *testing.T) {
+	var tests = []struct {
+		s   string
+		err string
+	}{
+		{s: ``, err: `found EOF, expected statement at line 1, char 1`},
+		{s: `from(bucket "db")`, err: `found db, expected : at line 1, char 13`},
+		{s: `from(bucket: "db"`, err: `found EOF, expected ,, ) at line 1, char 18`},
+		{s: `from(bucket: "db") |> range`, err: `found range, expected function call at line 1, char 23`},
+		{s: `from(bucket: "db") |> 1`, err: `found 1, expected function call at line 1, char 23`},
+		{s: `f(x: [1 2])`, err: `found 2, expected ,, ] at line 1, char 9`},
+		{s: `f(x: r.)`, err: `found ), expected identifier at line 1, char 8`},
+		{s: `f(x: r[1])`, err: `found 1, expected string at line 1, char 8`},
+		{s: `f(x: (1)`, err: `found EOF, expected ,, ) at line 1, char 9`},
+		{s: `f(x: 2000-13-01T00:00:00Z)`, err: `invalid time: 2000-13-01T00:00:00Z at line 1, char 6`},
+		{s: `f(x: 5y)`, err: `invalid duration: 5y at line 1, char 6`},
+		{s: `f(x: #)`, err: `found #, expected identifier, string, number, duration, time, {, [, ( at line 1, char 6`},
+	}
+
+	for i, tt := range tests {
+		_, err := pipeql.ParseProgram(tt.s)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%v", i, tt.s, tt.err, err)
+		}
+	}
+}
+
+// Ensure durations can be parsed.
+func TestParseDuration(t *testing.T) {
+	var tests = []struct {
+		s   string
+		d   time.Duration
+		err string
+	}{
+		{s: `10ns`, d: 10},
+		{s: `10us`, d: 10 * time.Microsecond},
+		{s: `10µs`, d: 10 * time.Microsecond},
+		{s: `10ms`, d: 10 * time.Millisecond},
+		{s: `1h30m5s`, d: time.Hour + 30*time.Minute + 5*time.Second},
+		{s: `2d`, d: 48 * time.Hour},
+		{s: `1w`, d: 7 * 24 * time.Hour},
+		{s: `m`, err: `invalid duration: m`},
+		{s: `10`, err: `invalid duration: 10`},
+		{s: `10x`, err: `invalid duration: 10x`},
+	}
+
+	for i, tt := range tests {
+		d, err := pipeql.ParseDuration(tt.s)
+		if errstr(err) != tt.err {
+			t.Errorf("%d. %q: error mismatch: exp=%s got=%v", i, tt.s, tt.err, err)
+		} else if d != tt.d {
+			t.Errorf("%d. %q: duration mismatch: exp=%s got=%s", i, tt.s, tt.d, d)
+		}
+	}
+}
+
+// errstr returns the string representation of an error.
+func errstr(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
@@ -0,0 +1,229 @@
+package pipeql
+
+import (
+	"bytes"
+	"unicode"
+)
+
+// eof represents a marker rune for the end of the source.
+const eof = rune(0)
+
+// Scanner represents a lexical scanner for the pipe query language.
+// Whitespace and comments are skipped.
+type Scanner struct {
+	src []rune
+	i   int
+	pos Pos
+}
+
+// NewScanner returns a new instance of Scanner.
+func NewScanner(s string) *Scanner {
+	return &Scanner{src: []rune(s)}
+}
+
+// Scan returns the next token and position from the source. Also returns the
+// literal text read for identifiers, strings, numbers, durations and times.
+func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
+	s.skip()
+
+	pos = s.pos
+	ch0 := s.read()
+	if isLetter(ch0) {
+		return s.scanIdent(pos, ch0)
+	} else if isDigit(ch0) {
+		return s.scanNumber(pos, ch0)
+	}
+
+	switch ch0 {
+	case eof:
+		return EOF, pos, ""
+	case '"':
+		return s.scanString(pos)
+	case '=':
+		if s.peek() == '=' {
+			s.read()
+			return EQ, pos, ""
+		} else if s.peek() == '>' {
+			s.read()
+			return ARROW, pos, ""
+		}
+		return ASSIGN, pos, ""
+	case '!':
+		if s.peek() == '=' {
+			s.read()
+			return NEQ, pos, ""
+		}
+	case '<':
+		if s.peek() == '=' {
+			s.read()
+			return LTE, pos, ""
+		}
+		return LT, pos, ""
+	case '>':
+		if s.peek() == '=' {
+			s.read()
+			return GTE, pos, ""
+		}
+		return GT, pos, ""
+	case '|':
+		if s.peek() == '>' {
+			s.read()
+			return PIPE, pos, ""
+		}
+	case '-':
+		return SUB, pos, ""
+	case '(':
+		return LPAREN, pos, ""
+	case ')':
+		return RPAREN, pos, ""
+	case '{':
+		return LBRACE, pos, ""
+	case '}':
+		return RBRACE, pos, ""
+	case '[':
+		return LBRACKET, pos, ""
+	case ']':
+		return RBRACKET, pos, ""
+	case ',':
+		return COMMA, pos, ""
+	case ':':
+		return COLON, pos, ""
+	case '.':
+		return DOT, pos, ""
+	}
+	return ILLEGAL, pos, string(ch0)
+}
+
+// skip consumes whitespace and comments, which run from "//" to the end of
+// the line.
+func (s *Scanner) skip() {
+	for {
+		if ch := s.peek(); isWhitespace(ch) {
+			s.read()
+		} else if ch == '/' && s.i+1 < len(s.src) && s.src[s.i+1] == '/' {
+			for ch := s.peek(); ch != '\n' && ch != eof; ch = s.peek() {
+				s.read()
+			}
+		} else {
+			return
+		}
+	}
+}
+
+// scanIdent consumes an identifier. Keywords are returned as their token.
+func (s *Scanner) scanIdent(pos Pos, ch rune) (tok Token, _ Pos, lit string) {
+	var buf bytes.Buffer
+	_, _ = buf.WriteRune(ch)
+	for ch := s.peek(); isLetter(ch) || isDigit(ch); ch = s.peek() {
+		_, _ = buf.WriteRune(s.read())
+	}
+
+	if tok = lookup(buf.String()); tok != IDENT {
+		return tok, pos, ""
+	}
+	return IDENT, pos, buf.String()
+}
+
+// scanNumber consumes an integer, number, duration or time. Durations are
+// numbers followed by a unit and can have several parts, such as "1h30m".
+// Times are RFC3339 and start with a four digit year.
+func (s *Scanner) scanNumber(pos Pos, ch rune) (tok Token, _ Pos, lit string) {
+	var buf bytes.Buffer
+	_, _ = buf.WriteRune(ch)
+	s.scanDigits(&buf)
+
+	// A dash after a year starts a time.
+	if buf.Len() == 4 && s.peek() == '-' {
+		for ch := s.peek(); isDigit(ch) || isLetter(ch) || ch == '-' || ch == ':' || ch == '.' || ch == '+'; ch = s.peek() {
+			_, _ = buf.WriteRune(s.read())
+		}
+		return TIME, pos, buf.String()
+	}
+
+	// Read the fractional part of a number.
+	if s.peek() == '.' && s.i+1 < len(s.src) && isDigit(s.src[s.i+1]) {
+		_, _ = buf.WriteRune(s.read())
+		s.scanDigits(&buf)
+		return NUMBER, pos, buf.String()
+	}
+
+	// Read each unit and number of a duration.
+	if !isLetter(s.peek()) {
+		return INTEGER, pos, buf.String()
+	}
+	for isLetter(s.peek()) {
+		for isLetter(s.peek()) {
+			_, _ = buf.WriteRune(s.read())
+		}
+		s.scanDigits(&buf)
+	}
+	return DURATION, pos, buf.String()
+}
+
+// scanDigits consumes contiguous digits into buf.
+func (s *Scanner) scanDigits(buf *bytes.Buffer) {
+	for isDigit(s.peek()) {
+		_, _ = buf.WriteRune(s.read())
+	}
+}
+
+// scanString consumes a double quoted string. Quotes and backslashes can be
+// escaped with a backslash, as can newlines and tabs.
+func (s *Scanner) scanString(pos Pos) (tok Token, _ Pos, lit string) {
+	var buf bytes.Buffer
+	for {
+		switch ch := s.read(); ch {
+		case '"':
+			return STRING, pos, buf.String()
+		case eof:
+			return ILLEGAL, pos, `"` + buf.String()
+		case '\\':
+			switch ch1 := s.read(); ch1 {
+			case 'n':
+				_ = buf.WriteByte('\n')
+			case 't':
+				_ = buf.WriteByte('\t')
+			case '"', '\\':
+				_, _ = buf.WriteRune(ch1)
+			default:
+				return ILLEGAL, pos, `\` + string(ch1)
+			}
+		default:
+			_, _ = buf.WriteRune(ch)
+		}
+	}
+}
+
+// read returns the next rune and advances the position.
+// Returns eof at the end of the source.
+func (s *Scanner) read() rune {
+	if s.i >= len(s.src) {
+		return eof
+	}
+	ch := s.src[s.i]
+	s.i++
+	if ch == '\n' {
+		s.pos.Line++
+		s.pos.Char = 0
+	} else {
+		s.pos.Char++
+	}
+	return ch
+}
+
+// peek returns the next rune without advancing.
+func (s *Scanner) peek() rune {
+	if s.i >= len(s.src) {
+		return eof
+	}
+	return s.src[s.i]
+}
+
+// isWhitespace returns true if the rune is a space, tab, or newline.
+func isWhitespace(ch rune) bool { return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' }
+
+// isLetter returns true if the rune is a letter or an underscore.
+func isLetter(ch rune) bool { return ch == '_' || unicode.IsLetter(ch) }
+
+// isDigit returns true if the rune is a digit.
+func isDigit(ch rune) bool { return ch >= '0' && ch <= '9' }
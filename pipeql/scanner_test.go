@@ -0,0 +1,83 @@
+package pipeql_test
+
+import (
+	"testing"
+
+	"github.com/influxdb/influxdb/pipeql"
+)
+
+// Ensure the scanner can scan tokens correctly.
+func TestScanner_Scan(t *testing.T) {
+	var tests = []struct {
+		s   string
+		tok pipeql.Token
+		lit string
+		pos pipeql.Pos
+	}{
+		// Special tokens
+		{s: ``, tok: pipeql.EOF},
+		{s: `#`, tok: pipeql.ILLEGAL, lit: `#`},
+		{s: `!`, tok: pipeql.ILLEGAL, lit: `!`},
+		{s: `|`, tok: pipeql.ILLEGAL, lit: `|`},
+		{s: " \n\tfoo", tok: pipeql.IDENT, lit: "foo", pos: pipeql.Pos{Line: 1, Char: 1}},
+		{s: "// comment\nfoo", tok: pipeql.IDENT, lit: "foo", pos: pipeql.Pos{Line: 1, Char: 0}},
+
+		// Operators
+		{s: `==`, tok: pipeql.EQ},
+		{s: `!=`, tok: pipeql.NEQ},
+		{s: `<`, tok: pipeql.LT},
+		{s: `<=`, tok: pipeql.LTE},
+		{s: `>`, tok: pipeql.GT},
+		{s: `>=`, tok: pipeql.GTE},
+		{s: `-`, tok: pipeql.SUB},
+		{s: `and`, tok: pipeql.AND},
+		{s: `or`, tok: pipeql.OR},
+		{s: `|>`, tok: pipeql.PIPE},
+		{s: `=>`, tok: pipeql.ARROW},
+
+		// Misc tokens
+		{s: `=`, tok: pipeql.ASSIGN},
+		{s: `(`, tok: pipeql.LPAREN},
+		{s: `)`, tok: pipeql.RPAREN},
+		{s: `{`, tok: pipeql.LBRACE},
+		{s: `}`, tok: pipeql.RBRACE},
+		{s: `[`, tok: pipeql.LBRACKET},
+		{s: `]`, tok: pipeql.RBRACKET},
+		{s: `,`, tok: pipeql.COMMA},
+		{s: `:`, tok: pipeql.COLON},
+		{s: `.`, tok: pipeql.DOT},
+
+		// Identifiers
+		{s: `aggregateWindow`, tok: pipeql.IDENT, lit: `aggregateWindow`},
+		{s: `_measurement`, tok: pipeql.IDENT, lit: `_measurement`},
+		{s: `r.host`, tok: pipeql.IDENT, lit: `r`},
+
+		// Strings
+		{s: `"cpu"`, tok: pipeql.STRING, lit: `cpu`},
+		{s: `"a \"b\" \\ \n"`, tok: pipeql.STRING, lit: "a \"b\" \\ \n"},
+		{s: `"cpu`, tok: pipeql.ILLEGAL, lit: `"cpu`},
+		{s: `"\q"`, tok: pipeql.ILLEGAL, lit: `\q`},
+
+		// Numbers, durations and times
+		{s: `100`, tok: pipeql.INTEGER, lit: `100`},
+		{s: `10.5`, tok: pipeql.NUMBER, lit: `10.5`},
+		{s: `10.`, tok: pipeql.INTEGER, lit: `10`},
+		{s: `5m`, tok: pipeql.DURATION, lit: `5m`},
+		{s: `1h30m`, tok: pipeql.DURATION, lit: `1h30m`},
+		{s: `10µs`, tok: pipeql.DURATION, lit: `10µs`},
+		{s: `2000-01-01T00:00:00Z`, tok: pipeql.TIME, lit: `2000-01-01T00:00:00Z`},
+		{s: `2000-01-01T00:00:00.5+01:00`, tok: pipeql.TIME, lit: `2000-01-01T00:00:00.5+01:00`},
+		{s: `200-1`, tok: pipeql.INTEGER, lit: `200`},
+	}
+
+	for i, tt := range tests {
+		tok, pos, lit := pipeql.NewScanner(tt.s).Scan()
+		if tt.tok != tok {
+			t.Errorf("%d. %q token mismatch: exp=%q got=%q <%q>", i, tt.s, tt.tok, tok, lit)
+		} else if tt.pos.Line != pos.Line || tt.pos.Char != pos.Char {
+			t.Errorf("%d. %q pos mismatch: exp=%#v got=%#v", i, tt.s, tt.pos, pos)
+		} else if tt.lit != lit {
+			t.Errorf("%d. %q literal mismatch: exp=%q got=%q", i, tt.s, tt.lit, lit)
+		}
+	}
+}
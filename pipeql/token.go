@@ -0,0 +1,131 @@
+package pipeql
+
+// Token is a lexical token of the pipe query language.
+type Token int
+
+const (
+	// Special tokens
+	ILLEGAL Token = iota
+	EOF
+
+	literal_beg
+	// Literals
+	IDENT    // from
+	STRING   // "cpu"
+	INTEGER  // 10
+	NUMBER   // 10.5
+	DURATION // 5m
+	TIME     // 2000-01-01T00:00:00Z
+	literal_end
+
+	operator_beg
+	// Operators
+	EQ    // ==
+	NEQ   // !=
+	LT    // <
+	LTE   // <=
+	GT    // >
+	GTE   // >=
+	SUB   // -
+	AND   // and
+	OR    // or
+	PIPE  // |>
+	ARROW // =>
+	operator_end
+
+	ASSIGN   // =
+	LPAREN   // (
+	RPAREN   // )
+	LBRACE   // {
+	RBRACE   // }
+	LBRACKET // [
+	RBRACKET // ]
+	COMMA    // ,
+	COLON    // :
+	DOT      // .
+)
+
+var tokens = [...]string{
+	ILLEGAL: "ILLEGAL",
+	EOF:     "EOF",
+
+	IDENT:    "IDENT",
+	STRING:   "STRING",
+	INTEGER:  "INTEGER",
+	NUMBER:   "NUMBER",
+	DURATION: "DURATION",
+	TIME:     "TIME",
+
+	EQ:    "==",
+	NEQ:   "!=",
+	LT:    "<",
+	LTE:   "<=",
+	GT:    ">",
+	GTE:   ">=",
+	SUB:   "-",
+	AND:   "and",
+	OR:    "or",
+	PIPE:  "|>",
+	ARROW: "=>",
+
+	ASSIGN:   "=",
+	LPAREN:   "(",
+	RPAREN:   ")",
+	LBRACE:   "{",
+	RBRACE:   "}",
+	LBRACKET: "[",
+	RBRACKET: "]",
+	COMMA:    ",",
+	COLON:    ":",
+	DOT:      ".",
+}
+
+// String returns the string representation of the token.
+func (tok Token) String() string {
+	if tok >= 0 && tok < Token(len(tokens)) {
+		return tokens[tok]
+	}
+	return ""
+}
+
+// Precedence returns the operator precedence of a binary operator token.
+func (tok Token) Precedence() int {
+	switch tok {
+	case OR:
+		return 1
+	case AND:
+		return 2
+	case EQ, NEQ, LT, LTE, GT, GTE:
+		return 3
+	}
+	return 0
+}
+
+// isOperator returns true for operator tokens.
+func (tok Token) isOperator() bool { return tok > operator_beg && tok < operator_end }
+
+// tokstr returns a literal if provided, otherwise returns the token string.
+func tokstr(tok Token, lit string) string {
+	if lit != "" {
+		return lit
+	}
+	return tok.String()
+}
+
+// lookup returns the token associated with a given identifier.
+func lookup(ident string) Token {
+	switch ident {
+	case "and":
+		return AND
+	case "or":
+		return OR
+	}
+	return IDENT
+}
+
+// Pos specifies the line and character position of a token.
+// The Char and Line are both zero-based indexes.
+type Pos struct {
+	Line int
+	Char int
+}
@@ -0,0 +1,496 @@
+package influxdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// DefaultPostgresPort is the default port of the Postgres gateway.
+const DefaultPostgresPort = 5432
+
+// Postgres protocol codes sent in place of a protocol version.
+const (
+	postgresProtocolVersion = 196608 // 3.0
+	postgresSSLRequest      = 80877103
+	postgresCancelRequest   = 80877102
+)
+
+// Postgres type OIDs of result columns.
+const (
+	postgresBool        = 16
+	postgresInt8        = 20
+	postgresText        = 25
+	postgresFloat8      = 701
+	postgresTimestampTZ = 1184
+	postgresNumeric     = 1700
+)
+
+// maxPostgresMessageSize is the largest message read from a client.
+const maxPostgresMessageSize = 1 << 20
+
+// PostgresServer serves read-only SELECT statements over the Postgres wire
+// protocol so that SQL clients and BI tools can query measurements as
+// tables. Statements are parsed as InfluxQL, which accepts the SELECT
+// syntax these tools generate, and time and tag conditions are pushed down
+// to series and shard selection as for any other query. Only the simple
+// query protocol is supported.
+type PostgresServer struct {
+	server *Server
+
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+
+	// Asks clients for a password and authenticates them if set.
+	RequireAuthentication bool
+}
+
+// NewPostgresServer returns an instance of PostgresServer attached to a Server.
+func NewPostgresServer(s *Server) *PostgresServer {
+	return &PostgresServer{server: s}
+}
+
+// ListenAndServe opens a TCP listener on addr and accepts connections in a
+// separate goroutine.
+func (s *PostgresServer) ListenAndServe(addr string) error {
+	if addr == "" {
+		return ErrBindAddressRequired
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.listener = l
+	s.conns = make(map[net.Conn]struct{})
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go s.serve(l)
+	return nil
+}
+
+// Addr returns the address the server is listening on.
+// Returns nil if the server is not listening.
+func (s *PostgresServer) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close stops accepting connections and closes open connections.
+func (s *PostgresServer) Close() error {
+	s.mu.Lock()
+	l := s.listener
+	if l != nil {
+		for conn := range s.conns {
+			_ = conn.Close()
+		}
+	}
+	s.listener = nil
+	s.mu.Unlock()
+
+	if l == nil {
+		return ErrServerClosed
+	}
+	err := l.Close()
+	s.wg.Wait()
+	return err
+}
+
+// serve accepts connections until the listener is closed.
+func (s *PostgresServer) serve(l net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.listener == nil {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.handleConn(conn)
+	}
+}
+
+// handleConn runs a session until the client terminates it or the
+// connection is closed.
+func (s *PostgresServer) handleConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	c := &postgresConn{
+		server: s,
+		r:      bufio.NewReader(conn),
+		w:      bufio.NewWriter(conn),
+	}
+	if host, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil {
+		c.remoteAddr = host
+	}
+	if err := c.startup(); err != nil {
+		c.writeError("FATAL", postgresErrorCode(err), err.Error())
+		_ = c.w.Flush()
+		return
+	}
+	c.serve()
+}
+
+// postgresConn is a session on the Postgres gateway.
+type postgresConn struct {
+	server     *PostgresServer
+	r          *bufio.Reader
+	w          *bufio.Writer
+	remoteAddr string
+
+	user     *User
+	database string
+}
+
+// errPostgresStartup is returned for connections that don't start with a
+// protocol 3.0 startup message.
+var errPostgresStartup = errors.New("unsupported startup message")
+
+// errPostgresCanceled is returned for cancel requests, which are not
+// supported and just close the connection.
+var errPostgresCanceled = errors.New("cancel request")
+
+// errPostgresAuthenticate is returned when a client's password is missing or
+// does not authenticate the user.
+var errPostgresAuthenticate = errors.New("password authentication failed")
+
+// errPostgresExtendedQuery is returned for the extended query protocol.
+var errPostgresExtendedQuery = errors.New("extended query protocol is not supported")
+
+// errPostgresReadOnly is returned for statements other than SELECT.
+var errPostgresReadOnly = errors.New("only SELECT statements are supported")
+
+// startup reads the startup message, authenticates the user and selects the
+// database. The database defaults to the user's name as in Postgres.
+func (c *postgresConn) startup() error {
+	var params map[string]string
+	for params == nil {
+		var hdr [8]byte
+		if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+			return err
+		}
+		n := int(binary.BigEndian.Uint32(hdr[0:]))
+		if n < 8 || n > maxPostgresMessageSize {
+			return errPostgresStartup
+		}
+		body := make([]byte, n-8)
+		if _, err := io.ReadFull(c.r, body); err != nil {
+			return err
+		}
+
+		switch binary.BigEndian.Uint32(hdr[4:]) {
+		case postgresSSLRequest:
+			// Decline SSL; the client continues without it.
+			if _, err := c.w.Write([]byte{'N'}); err != nil {
+				return err
+			} else if err := c.w.Flush(); err != nil {
+				return err
+			}
+		case postgresCancelRequest:
+			return errPostgresCanceled
+		case postgresProtocolVersion:
+			params = make(map[string]string)
+			a := strings.Split(string(body), "\x00")
+			for i := 0; i+1 < len(a); i += 2 {
+				params[a[i]] = a[i+1]
+			}
+		default:
+			return errPostgresStartup
+		}
+	}
+
+	// Ask for a cleartext password and authenticate the user.
+	if c.server.RequireAuthentication {
+		c.writeMessage('R', appendPostgresInt32(nil, 3))
+		if err := c.w.Flush(); err != nil {
+			return err
+		}
+		typ, body, err := c.readMessage()
+		if err != nil {
+			return err
+		} else if typ != 'p' {
+			return errPostgresAuthenticate
+		}
+		u, err := c.server.server.Authenticate(params["user"], strings.TrimSuffix(string(body), "\x00"))
+		if err != nil {
+			return errPostgresAuthenticate
+		}
+		c.user = u
+	}
+
+	c.database = params["database"]
+	if c.database == "" {
+		c.database = params["user"]
+	}
+	if !c.server.server.DatabaseExists(c.database) {
+		return ErrDatabaseNotFound
+	} else if c.server.server.DatabaseDisabled(c.database) {
+		return ErrDatabaseDisabled
+	}
+
+	c.writeMessage('R', appendPostgresInt32(nil, 0))
+	for _, kv := range [][2]string{
+		{"server_version", "9.0.0"},
+		{"server_encoding", "UTF8"},
+		{"client_encoding", "UTF8"},
+		{"DateStyle", "ISO, MDY"},
+		{"TimeZone", "UTC"},
+		{"integer_datetimes", "on"},
+		{"standard_conforming_strings", "on"},
+	} {
+		c.writeMessage('S', append(appendPostgresString(nil, kv[0]), appendPostgresString(nil, kv[1])...))
+	}
+	c.writeMessage('Z', []byte{'I'})
+	return c.w.Flush()
+}
+
+// serve reads and executes queries until the client terminates the session.
+func (c *postgresConn) serve() {
+	var failed bool
+	for {
+		typ, body, err := c.readMessage()
+		if err != nil {
+			return
+		}
+
+		switch typ {
+		case 'Q':
+			c.query(strings.TrimSuffix(string(body), "\x00"))
+			c.writeMessage('Z', []byte{'I'})
+		case 'P', 'B', 'D', 'E', 'C', 'H':
+			// Reject the extended query protocol once and skip its
+			// messages until the client syncs.
+			if !failed {
+				c.writeError("ERROR", "0A000", errPostgresExtendedQuery.Error())
+				failed = true
+			}
+		case 'S':
+			failed = false
+			c.writeMessage('Z', []byte{'I'})
+		case 'X':
+			return
+		default:
+			c.writeError("FATAL", "08P01", "unexpected message type: "+string(typ))
+			_ = c.w.Flush()
+			return
+		}
+
+		if err := c.w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// query executes the statements of a simple query. Statements run in order
+// until one fails. SET statements are accepted and ignored since clients
+// send them to configure the session. Trailing semicolons are removed since
+// InfluxQL expects a statement after each one.
+func (c *postgresConn) query(text string) {
+	text = strings.TrimRight(strings.TrimSpace(text), "; \t\r\n")
+	if text == "" {
+		c.writeMessage('I', nil)
+		return
+	} else if strings.HasPrefix(strings.ToUpper(text), "SET ") {
+		c.writeMessage('C', appendPostgresString(nil, "SET"))
+		return
+	}
+
+	q, err := c.server.server.ParseQuery(c.database, text, nil)
+	if err != nil {
+		c.writeError("ERROR", "42601", err.Error())
+		return
+	}
+	for _, stmt := range q.Statements {
+		if _, ok := stmt.(*influxql.SelectStatement); !ok {
+			c.writeError("ERROR", "25006", errPostgresReadOnly.Error())
+			return
+		}
+	}
+
+	for _, stmt := range q.Statements {
+		results := c.server.server.ExecuteQuery(&influxql.Query{Statements: influxql.Statements{stmt}}, c.database, c.user, QueryOptions{RemoteAddr: c.remoteAddr})
+		if err := results[0].Err; err != nil {
+			c.writeError("ERROR", postgresErrorCode(err), err.Error())
+			return
+		}
+		c.writeRows(results[0].Rows)
+	}
+}
+
+// writeRows writes the description of the result columns, a data row for
+// each value and the completion of the statement. Columns are the flattened
+// result columns without the series name.
+func (c *postgresConn) writeRows(rows []*influxql.Row) {
+	var columns []*resultColumn
+	if len(rows) > 0 {
+		columns = resultColumns(rows)[1:]
+	}
+
+	desc := appendPostgresInt16(nil, len(columns))
+	for _, col := range columns {
+		oid, size := postgresType(col.kind)
+		desc = appendPostgresString(desc, col.name)
+		desc = appendPostgresInt32(desc, 0) // table oid
+		desc = appendPostgresInt16(desc, 0) // column number
+		desc = appendPostgresInt32(desc, oid)
+		desc = appendPostgresInt16(desc, size)
+		desc = appendPostgresInt32(desc, -1) // type modifier
+		desc = appendPostgresInt16(desc, 0)  // text format
+	}
+	c.writeMessage('T', desc)
+
+	var n int
+	for _, row := range rows {
+		index := make(map[string]int, len(row.Columns))
+		for i, name := range row.Columns {
+			index[name] = i
+		}
+
+		for _, values := range row.Values {
+			data := appendPostgresInt16(nil, len(columns))
+			for _, col := range columns {
+				v := col.value(row, index, values)
+				if v == nil {
+					data = appendPostgresInt32(data, -1)
+					continue
+				}
+				s := formatPostgresValue(col.kind, v)
+				data = appendPostgresInt32(data, len(s))
+				data = append(data, s...)
+			}
+			c.writeMessage('D', data)
+			n++
+		}
+	}
+	c.writeMessage('C', appendPostgresString(nil, "SELECT "+strconv.Itoa(n)))
+}
+
+// postgresType returns the type OID and size of a column type.
+func postgresType(kind columnKind) (oid, size int) {
+	switch kind {
+	case columnInteger:
+		return postgresInt8, 8
+	case columnUnsigned:
+		return postgresNumeric, -1
+	case columnFloat:
+		return postgresFloat8, 8
+	case columnBoolean:
+		return postgresBool, 1
+	case columnTimestamp:
+		return postgresTimestampTZ, 8
+	}
+	return postgresText, -1
+}
+
+// formatPostgresValue returns the text format of a value. Timestamps are
+// microseconds since the epoch.
+func formatPostgresValue(kind columnKind, v interface{}) string {
+	if kind == columnTimestamp {
+		return time.Unix(0, v.(int64)*int64(time.Microsecond)).UTC().Format("2006-01-02 15:04:05.999999-07")
+	}
+
+	switch v := v.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		if v {
+			return "t"
+		}
+		return "f"
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// postgresErrorCode returns the SQLSTATE code of an error.
+func postgresErrorCode(err error) string {
+	switch err {
+	case errPostgresAuthenticate:
+		return "28P01"
+	case ErrDatabaseNotFound:
+		return "3D000"
+	case ErrReadAccessDenied, ErrDatabaseDisabled:
+		return "42501"
+	case errPostgresStartup:
+		return "08P01"
+	}
+	return "XX000"
+}
+
+// readMessage reads a message's type and body.
+func (c *postgresConn) readMessage() (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := int(binary.BigEndian.Uint32(hdr[1:]))
+	if n < 4 || n > maxPostgresMessageSize {
+		return 0, nil, errPostgresStartup
+	}
+	body := make([]byte, n-4)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return 0, nil, err
+	}
+	return hdr[0], body, nil
+}
+
+// writeMessage buffers a message. Write errors are returned on flush.
+func (c *postgresConn) writeMessage(typ byte, body []byte) {
+	_ = c.w.WriteByte(typ)
+	_, _ = c.w.Write(appendPostgresInt32(nil, len(body)+4))
+	_, _ = c.w.Write(body)
+}
+
+// writeError buffers an error response.
+func (c *postgresConn) writeError(severity, code, msg string) {
+	var b []byte
+	b = appendPostgresString(append(b, 'S'), severity)
+	b = appendPostgresString(append(b, 'V'), severity)
+	b = appendPostgresString(append(b, 'C'), code)
+	b = appendPostgresString(append(b, 'M'), msg)
+	c.writeMessage('E', append(b, 0))
+}
+
+func appendPostgresInt16(b []byte, v int) []byte { return append(b, byte(v>>8), byte(v)) }
+func appendPostgresInt32(b []byte, v int) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+func appendPostgresString(b []byte, s string) []byte { return append(append(b, s...), 0) }
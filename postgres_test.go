@@ -0,0 +1,218 @@
+package influxdb_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb"
+)
+
+// Ensure SELECT statements are answered over the Postgres wire protocol.
+func TestPostgresServer_Query(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.WriteSeries("foo", "raw", "cpu", map[string]string{"host": "serverA"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 1.5})
+	s.WriteSeries("foo", "raw", "cpu", map[string]string{"host": "serverB"}, mustParseTime("2000-01-01T00:00:10Z"), map[string]interface{}{"value": 2.0})
+	s.WriteSeries("foo", "raw", "cpu", map[string]string{"host": "serverA"}, mustParseTime("2000-01-01T00:01:00Z"), map[string]interface{}{"value": 3.0})
+	s.Sync(c.index)
+
+	pg := influxdb.NewPostgresServer(s.Server)
+	if err := pg.ListenAndServe("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer pg.Close()
+
+	conn, r, msgs := MustPostgresConnect(t, pg.Addr().String(), "user", "susy", "database", "foo")
+	defer conn.Close()
+	if msgs[0] != "R 0" || msgs[len(msgs)-1] != "Z I" {
+		t.Fatalf("unexpected startup: %v", msgs)
+	}
+
+	for i, tt := range []struct {
+		query string
+		msgs  []string
+	}{
+		{
+			query: `SELECT value FROM cpu WHERE host = 'serverA' AND time < '2000-01-01 00:00:30';`,
+			msgs:  []string{"T time:1184 value:701", "D 2000-01-01 00:00:00+00|1.5", "C SELECT 1", "Z I"},
+		},
+		{
+			query: `SELECT sum(value) FROM cpu GROUP BY host`,
+			msgs:  []string{"T host:25 time:1184 sum:701", "D serverA|1970-01-01 00:00:00+00|4.5", "D serverB|1970-01-01 00:00:00+00|2", "C SELECT 2", "Z I"},
+		},
+		{
+			query: `SELECT value FROM cpu WHERE time > '2001-01-01'`,
+			msgs:  []string{"T", "C SELECT 0", "Z I"},
+		},
+		{
+			query: `SET extra_float_digits = 3`,
+			msgs:  []string{"C SET", "Z I"},
+		},
+		{
+			query: ``,
+			msgs:  []string{"I", "Z I"},
+		},
+		{
+			query: `CREATE DATABASE bar`,
+			msgs:  []string{"E ERROR 25006 only SELECT statements are supported", "Z I"},
+		},
+		{
+			query: `SELECT FROM`,
+			msgs:  []string{"E ERROR 42601 found FROM, expected identifier, string, number, bool at line 1, char 8", "Z I"},
+		},
+	} {
+		if msgs := MustPostgresQuery(t, conn, r, tt.query); !reflect.DeepEqual(msgs, tt.msgs) {
+			t.Errorf("%d. unexpected messages: %q", i, msgs)
+		}
+	}
+}
+
+// Ensure clients must authenticate and connect to an existing database.
+func TestPostgresServer_Startup(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateUser("susy", "pass", true)
+
+	pg := influxdb.NewPostgresServer(s.Server)
+	pg.RequireAuthentication = true
+	if err := pg.ListenAndServe("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer pg.Close()
+
+	for i, tt := range []struct {
+		password string
+		database string
+		msg      string
+	}{
+		{password: "pass", database: "foo", msg: "Z I"},
+		{password: "wrong", database: "foo", msg: "E FATAL 28P01 password authentication failed"},
+		{password: "pass", database: "bar", msg: "E FATAL 3D000 database not found"},
+	} {
+		conn, r, msgs := MustPostgresConnect(t, pg.Addr().String(), "user", "susy", "database", tt.database)
+		if len(msgs) != 1 || msgs[0] != "R 3" {
+			t.Fatalf("%d. expected password request: %v", i, msgs)
+		}
+		MustWritePostgresMessage(t, conn, 'p', []byte(tt.password+"\x00"))
+		msgs = MustReadPostgresMessages(t, r)
+		if msgs[len(msgs)-1] != tt.msg {
+			t.Errorf("%d. unexpected messages: %q", i, msgs)
+		}
+		conn.Close()
+	}
+}
+
+// MustPostgresConnect connects to a Postgres server with startup parameters
+// and returns the messages sent in reply up to the first one that needs an
+// answer from the client.
+func MustPostgresConnect(t *testing.T, addr string, params ...string) (net.Conn, *bufio.Reader, []string) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+
+	// Request SSL first as most clients do.
+	if _, err := conn.Write([]byte{0, 0, 0, 8, 0x04, 0xd2, 0x16, 0x2f}); err != nil {
+		t.Fatal(err)
+	} else if b, err := r.ReadByte(); err != nil || b != 'N' {
+		t.Fatalf("unexpected SSL reply: %c, %v", b, err)
+	}
+
+	body := []byte{0, 3, 0, 0}
+	for _, p := range params {
+		body = append(append(body, p...), 0)
+	}
+	body = append(body, 0)
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(body)+4))
+	if _, err := conn.Write(append(n[:], body...)); err != nil {
+		t.Fatal(err)
+	}
+	return conn, r, MustReadPostgresMessages(t, r)
+}
+
+// MustPostgresQuery sends a simple query and returns the messages sent in reply.
+func MustPostgresQuery(t *testing.T, conn net.Conn, r *bufio.Reader, q string) []string {
+	MustWritePostgresMessage(t, conn, 'Q', []byte(q+"\x00"))
+	return MustReadPostgresMessages(t, r)
+}
+
+// MustWritePostgresMessage writes a message to a Postgres server.
+func MustWritePostgresMessage(t *testing.T, conn net.Conn, typ byte, body []byte) {
+	var hdr [5]byte
+	hdr[0] = typ
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(body)+4))
+	if _, err := conn.Write(append(hdr[:], body...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// MustReadPostgresMessages reads messages until one that ends a reply: ready
+// for query, a password request or an error that closes the connection.
+// Messages are formatted as their type followed by their contents.
+func MustReadPostgresMessages(t *testing.T, r *bufio.Reader) []string {
+	var msgs []string
+	for {
+		var hdr [5]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			t.Fatal(err)
+		}
+		body := make([]byte, binary.BigEndian.Uint32(hdr[1:])-4)
+		if _, err := io.ReadFull(r, body); err != nil {
+			t.Fatal(err)
+		}
+
+		msg := string(hdr[0])
+		switch hdr[0] {
+		case 'R':
+			msg += fmt.Sprintf(" %d", binary.BigEndian.Uint32(body))
+		case 'Z':
+			msg += " " + string(body)
+		case 'C':
+			msg += " " + strings.TrimSuffix(string(body), "\x00")
+		case 'T':
+			body = body[2:]
+			for len(body) > 0 {
+				i := strings.IndexByte(string(body), 0)
+				msg += fmt.Sprintf(" %s:%d", body[:i], binary.BigEndian.Uint32(body[i+7:]))
+				body = body[i+19:]
+			}
+		case 'D':
+			var values []string
+			for body = body[2:]; len(body) > 0; {
+				n := int32(binary.BigEndian.Uint32(body))
+				if n < 0 {
+					values, body = append(values, "NULL"), body[4:]
+				} else {
+					values, body = append(values, string(body[4:4+n])), body[4+n:]
+				}
+			}
+			msg += " " + strings.Join(values, "|")
+		case 'E':
+			// Keep the severity, code and message fields.
+			for _, f := range strings.Split(strings.TrimRight(string(body), "\x00"), "\x00") {
+				if f[0] == 'S' || f[0] == 'C' || f[0] == 'M' {
+					msg += " " + f[1:]
+				}
+			}
+		}
+		msgs = append(msgs, msg)
+
+		if hdr[0] == 'Z' || (hdr[0] == 'R' && msg != "R 0") || strings.HasPrefix(msg, "E FATAL") {
+			return msgs
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package influxdb
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimePrecision represents the unit of an integer timestamp.
+type TimePrecision int
+
+const (
+	NanosecondPrecision TimePrecision = iota
+	MicrosecondPrecision
+	MillisecondPrecision
+	SecondPrecision
+	MinutePrecision
+	HourPrecision
+)
+
+// ParseTimePrecision parses a precision name: "n", "u", "ms", "s", "m" or "h".
+// A blank string returns millisecond precision.
+func ParseTimePrecision(s string) (TimePrecision, error) {
+	switch s {
+	case "n":
+		return NanosecondPrecision, nil
+	case "u":
+		return MicrosecondPrecision, nil
+	case "ms", "":
+		return MillisecondPrecision, nil
+	case "s":
+		return SecondPrecision, nil
+	case "m":
+		return MinutePrecision, nil
+	case "h":
+		return HourPrecision, nil
+	}
+	return 0, fmt.Errorf("Unknown time precision %s", s)
+}
+
+// String returns the name of the precision.
+func (p TimePrecision) String() string {
+	switch p {
+	case NanosecondPrecision:
+		return "n"
+	case MicrosecondPrecision:
+		return "u"
+	case MillisecondPrecision:
+		return "ms"
+	case SecondPrecision:
+		return "s"
+	case MinutePrecision:
+		return "m"
+	case HourPrecision:
+		return "h"
+	}
+	return fmt.Sprintf("TimePrecision(%d)", int(p))
+}
+
+// Duration returns the length of one unit of the precision.
+func (p TimePrecision) Duration() time.Duration {
+	switch p {
+	case MicrosecondPrecision:
+		return time.Microsecond
+	case MillisecondPrecision:
+		return time.Millisecond
+	case SecondPrecision:
+		return time.Second
+	case MinutePrecision:
+		return time.Minute
+	case HourPrecision:
+		return time.Hour
+	default:
+		return time.Nanosecond
+	}
+}
+
+// Time returns the UTC time for a timestamp given in the precision's units.
+func (p TimePrecision) Time(n int64) time.Time {
+	return time.Unix(0, n*int64(p.Duration())).UTC()
+}
+
+// Timestamp returns the time as a number of the precision's units since the
+// epoch. Times are truncated to the precision.
+func (p TimePrecision) Timestamp(t time.Time) int64 {
+	return t.UnixNano() / int64(p.Duration())
+}
+
+// convertResultTimes converts the timestamps in the time column of each row
+// from microseconds to the given precision.
+func convertResultTimes(results Results, p TimePrecision) {
+	for _, r := range results {
+		for _, row := range r.Rows {
+			if len(row.Columns) == 0 || row.Columns[0] != "time" {
+				continue
+			}
+			for _, values := range row.Values {
+				if us, ok := values[0].(int64); ok {
+					values[0] = p.Timestamp(time.Unix(0, us*int64(time.Microsecond)))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,430 @@
+package influxdb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+const (
+	// promNameLabel is the label holding the name of a Prometheus metric.
+	// It is stored as the measurement name and the other labels as tags.
+	promNameLabel = "__name__"
+
+	// promValueField is the field storing the value of each sample.
+	promValueField = "value"
+)
+
+// Prometheus label matcher types.
+const (
+	promMatchEqual    = 0
+	promMatchNotEqual = 1
+	promMatchRegex    = 2
+	promMatchNotRegex = 3
+)
+
+// errPromMissingName is returned when a written time series has no name label.
+var errPromMissingName = errors.New("time series has no " + promNameLabel + " label")
+
+// promTimeSeries is a Prometheus time series: a set of labels and samples.
+type promTimeSeries struct {
+	labels  promLabels
+	samples []promSample
+}
+
+// promLabel is a name and value pair identifying a time series.
+type promLabel struct {
+	name, value string
+}
+
+// promLabels is a sortable list of labels.
+type promLabels []promLabel
+
+func (a promLabels) Len() int           { return len(a) }
+func (a promLabels) Less(i, j int) bool { return a[i].name < a[j].name }
+func (a promLabels) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// promSample is a value at a timestamp in milliseconds.
+type promSample struct {
+	value     float64
+	timestamp int64
+}
+
+// promQuery selects the samples of matching time series between two
+// timestamps in milliseconds, inclusive.
+type promQuery struct {
+	start, end int64
+	matchers   []*promMatcher
+}
+
+// promMatcher matches the value of a label. Labels that aren't set match an
+// empty value.
+type promMatcher struct {
+	typ   int
+	name  string
+	value string
+	regex *regexp.Regexp
+}
+
+// matches returns true if the label value matches.
+func (m *promMatcher) matches(v string) bool {
+	switch m.typ {
+	case promMatchEqual:
+		return v == m.value
+	case promMatchNotEqual:
+		return v != m.value
+	case promMatchRegex:
+		return m.regex.MatchString(v)
+	default:
+		return !m.regex.MatchString(v)
+	}
+}
+
+// unmarshalPromWriteRequest decodes a remote storage WriteRequest into points.
+// Samples that are NaN, such as the markers Prometheus writes when a series
+// goes stale, are skipped since they cannot be stored.
+func unmarshalPromWriteRequest(data []byte) ([]*Point, error) {
+	var points []*Point
+	err := decodeProtobuf(data, func(field, wire int, b *protoBuffer) error {
+		if field != 1 || wire != protoBytes {
+			return b.skip(wire)
+		}
+		buf, err := b.bytes()
+		if err != nil {
+			return err
+		}
+		ts, err := unmarshalPromTimeSeries(buf)
+		if err != nil {
+			return err
+		}
+
+		// Convert the labels to a measurement name and tags.
+		var name string
+		var tags map[string]string
+		for _, l := range ts.labels {
+			if l.name == promNameLabel {
+				name = l.value
+				continue
+			} else if tags == nil {
+				tags = make(map[string]string)
+			}
+			tags[l.name] = l.value
+		}
+		if name == "" {
+			return errPromMissingName
+		}
+
+		for _, s := range ts.samples {
+			if math.IsNaN(s.value) {
+				continue
+			}
+			points = append(points, &Point{
+				Name:      name,
+				Tags:      tags,
+				Timestamp: time.Unix(0, s.timestamp*int64(time.Millisecond)).UTC(),
+				Values:    map[string]interface{}{promValueField: s.value},
+			})
+		}
+		return nil
+	})
+	return points, err
+}
+
+// unmarshalPromTimeSeries decodes a TimeSeries message.
+func unmarshalPromTimeSeries(data []byte) (*promTimeSeries, error) {
+	ts := &promTimeSeries{}
+	err := decodeProtobuf(data, func(field, wire int, b *protoBuffer) error {
+		if wire != protoBytes {
+			return b.skip(wire)
+		}
+		buf, err := b.bytes()
+		if err != nil {
+			return err
+		}
+
+		switch field {
+		case 1:
+			var l promLabel
+			err := decodeProtobuf(buf, func(field, wire int, b *protoBuffer) error {
+				if wire != protoBytes {
+					return b.skip(wire)
+				}
+				v, err := b.bytes()
+				if field == 1 {
+					l.name = string(v)
+				} else if field == 2 {
+					l.value = string(v)
+				}
+				return err
+			})
+			ts.labels = append(ts.labels, l)
+			return err
+		case 2:
+			var s promSample
+			err := decodeProtobuf(buf, func(field, wire int, b *protoBuffer) error {
+				switch {
+				case field == 1 && wire == protoFixed64:
+					v, err := b.fixed64()
+					s.value = math.Float64frombits(v)
+					return err
+				case field == 2 && wire == protoVarint:
+					v, err := b.varint()
+					s.timestamp = int64(v)
+					return err
+				default:
+					return b.skip(wire)
+				}
+			})
+			ts.samples = append(ts.samples, s)
+			return err
+		}
+		return nil
+	})
+	return ts, err
+}
+
+// unmarshalPromReadRequest decodes the queries of a remote storage ReadRequest.
+func unmarshalPromReadRequest(data []byte) ([]*promQuery, error) {
+	var queries []*promQuery
+	err := decodeProtobuf(data, func(field, wire int, b *protoBuffer) error {
+		if field != 1 || wire != protoBytes {
+			return b.skip(wire)
+		}
+		buf, err := b.bytes()
+		if err != nil {
+			return err
+		}
+
+		q := &promQuery{}
+		if err := decodeProtobuf(buf, func(field, wire int, b *protoBuffer) error {
+			switch {
+			case field == 1 && wire == protoVarint:
+				v, err := b.varint()
+				q.start = int64(v)
+				return err
+			case field == 2 && wire == protoVarint:
+				v, err := b.varint()
+				q.end = int64(v)
+				return err
+			case field == 3 && wire == protoBytes:
+				buf, err := b.bytes()
+				if err != nil {
+					return err
+				}
+				m, err := unmarshalPromMatcher(buf)
+				q.matchers = append(q.matchers, m)
+				return err
+			default:
+				return b.skip(wire)
+			}
+		}); err != nil {
+			return err
+		}
+		queries = append(queries, q)
+		return nil
+	})
+	return queries, err
+}
+
+// unmarshalPromMatcher decodes a LabelMatcher message. Regular expressions
+// are anchored at both ends, as they are in Prometheus.
+func unmarshalPromMatcher(data []byte) (*promMatcher, error) {
+	m := &promMatcher{}
+	err := decodeProtobuf(data, func(field, wire int, b *protoBuffer) error {
+		switch {
+		case field == 1 && wire == protoVarint:
+			v, err := b.varint()
+			m.typ = int(v)
+			return err
+		case field == 2 && wire == protoBytes:
+			v, err := b.bytes()
+			m.name = string(v)
+			return err
+		case field == 3 && wire == protoBytes:
+			v, err := b.bytes()
+			m.value = string(v)
+			return err
+		default:
+			return b.skip(wire)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch m.typ {
+	case promMatchEqual, promMatchNotEqual:
+	case promMatchRegex, promMatchNotRegex:
+		if m.regex, err = regexp.Compile("^(?:" + m.value + ")$"); err != nil {
+			return nil, fmt.Errorf("invalid regex: %s", err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid matcher type: %d", m.typ)
+	}
+	return m, nil
+}
+
+// marshalPromReadResponse encodes a ReadResponse with the time series
+// returned by each query.
+func marshalPromReadResponse(results [][]*promTimeSeries) []byte {
+	var b []byte
+	for _, result := range results {
+		var rb []byte
+		for _, ts := range result {
+			var tb []byte
+			for _, l := range ts.labels {
+				var lb []byte
+				lb = appendProtobufBytes(lb, 1, []byte(l.name))
+				lb = appendProtobufBytes(lb, 2, []byte(l.value))
+				tb = appendProtobufBytes(tb, 1, lb)
+			}
+			for _, s := range ts.samples {
+				var sb []byte
+				sb = appendProtobufFixed64(sb, 1, math.Float64bits(s.value))
+				sb = appendProtobufVarint(sb, 2, uint64(s.timestamp))
+				tb = appendProtobufBytes(tb, 2, sb)
+			}
+			rb = appendProtobufBytes(rb, 1, tb)
+		}
+		b = appendProtobufBytes(b, 1, rb)
+	}
+	return b
+}
+
+// readPrometheus returns the time series matching a remote storage query.
+// Measurements matching the name are queried for their values grouped by
+// every tag. Equality matchers are applied by the query and the rest to the
+// tags of the returned series.
+func (s *Server) readPrometheus(database string, user *User, q *promQuery, opt QueryOptions) ([]*promTimeSeries, error) {
+	// Find the measurements matching the name that store sample values.
+	s.mu.RLock()
+	db := s.databases[database]
+	if db == nil {
+		s.mu.RUnlock()
+		return nil, ErrDatabaseNotFound
+	}
+	idx, err := db.loadIndex()
+	if err != nil {
+		s.mu.RUnlock()
+		return nil, err
+	}
+	var names []string
+	tagKeys := make(map[string][]string)
+	for _, name := range idx.names {
+		if m := idx.measurements[name]; m.field(promValueField) == nil || !promNameMatches(q.matchers, name) {
+			continue
+		}
+		names = append(names, name)
+		tagKeys[name] = db.TagKeys([]string{name})
+	}
+	s.mu.RUnlock()
+
+	var a []*promTimeSeries
+	for _, name := range names {
+		stmt, ok := promSelectStatement(name, tagKeys[name], q)
+		if !ok {
+			continue
+		}
+		results := s.ExecuteQuery(&influxql.Query{Statements: influxql.Statements{stmt}}, database, user, opt)
+		if err := results[0].Err; err != nil {
+			return nil, err
+		}
+
+		for _, row := range results[0].Rows {
+			if ts := promRowTimeSeries(row, q.matchers); ts != nil {
+				a = append(a, ts)
+			}
+		}
+	}
+	return a, nil
+}
+
+// promNameMatches returns true if a measurement name matches the matchers
+// of the name label.
+func promNameMatches(matchers []*promMatcher, name string) bool {
+	for _, m := range matchers {
+		if m.name == promNameLabel && !m.matches(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// promSelectStatement returns a statement selecting the values of a
+// measurement in a query's time range, grouped by every tag. Matchers that
+// require a tag to equal a value are added to the condition. Returns false
+// if one of them requires a tag that the measurement does not have.
+func promSelectStatement(name string, tagKeys []string, q *promQuery) (*influxql.SelectStatement, bool) {
+	// The query range includes its end millisecond but the engine reads up to,
+	// and not including, the end of a range so it's bounded by the next one.
+	cond := influxql.Expr(&influxql.BinaryExpr{
+		Op:  influxql.AND,
+		LHS: &influxql.BinaryExpr{Op: influxql.GTE, LHS: &influxql.VarRef{Val: "time"}, RHS: &influxql.TimeLiteral{Val: time.Unix(0, q.start*int64(time.Millisecond)).UTC()}},
+		RHS: &influxql.BinaryExpr{Op: influxql.LT, LHS: &influxql.VarRef{Val: "time"}, RHS: &influxql.TimeLiteral{Val: time.Unix(0, (q.end+1)*int64(time.Millisecond)).UTC()}},
+	})
+	for _, m := range q.matchers {
+		if m.typ != promMatchEqual || m.name == promNameLabel || m.value == "" {
+			continue
+		} else if i := sort.SearchStrings(tagKeys, m.name); i == len(tagKeys) || tagKeys[i] != m.name {
+			return nil, false
+		}
+		cond = &influxql.BinaryExpr{
+			Op:  influxql.AND,
+			LHS: cond,
+			RHS: &influxql.BinaryExpr{Op: influxql.EQ, LHS: &influxql.VarRef{Val: m.name}, RHS: &influxql.StringLiteral{Val: m.value}},
+		}
+	}
+
+	stmt := &influxql.SelectStatement{
+		Fields:    influxql.Fields{{Expr: &influxql.VarRef{Val: promValueField}}},
+		Source:    &influxql.Measurement{Name: name},
+		Condition: cond,
+	}
+	for _, k := range tagKeys {
+		stmt.Dimensions = append(stmt.Dimensions, &influxql.Dimension{Expr: &influxql.VarRef{Val: k}})
+	}
+	return stmt, true
+}
+
+// promRowTimeSeries converts a row of values to a time series. Returns nil
+// if the row's tags don't match or it has no numeric values.
+func promRowTimeSeries(row *influxql.Row, matchers []*promMatcher) *promTimeSeries {
+	for _, m := range matchers {
+		if m.name != promNameLabel && !m.matches(row.Tags[m.name]) {
+			return nil
+		}
+	}
+
+	// Labels are sorted by name, as Prometheus expects.
+	ts := &promTimeSeries{labels: promLabels{{name: promNameLabel, value: row.Name}}}
+	for k, v := range row.Tags {
+		if v != "" {
+			ts.labels = append(ts.labels, promLabel{name: k, value: v})
+		}
+	}
+	sort.Sort(ts.labels)
+
+	// Row timestamps are in microseconds.
+	for _, values := range row.Values {
+		timestamp, ok := values[0].(int64)
+		value, ok2 := values[1].(float64)
+		switch v := values[1].(type) {
+		case int64:
+			value, ok2 = float64(v), true
+		case uint64:
+			value, ok2 = float64(v), true
+		}
+		if ok && ok2 {
+			ts.samples = append(ts.samples, promSample{value: value, timestamp: timestamp / 1000})
+		}
+	}
+	if len(ts.samples) == 0 {
+		return nil
+	}
+	return ts
+}
@@ -0,0 +1,195 @@
+package influxdb
+
+import (
+	"math"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Ensure remote storage write requests are decoded into points.
+func TestUnmarshalPromWriteRequest(t *testing.T) {
+	data := appendProtobufBytes(nil, 1, marshalPromTimeSeries(&promTimeSeries{
+		labels: promLabels{{"__name__", "cpu"}, {"host", "serverA"}},
+		samples: []promSample{
+			{value: 1.5, timestamp: 1000},
+			{value: math.NaN(), timestamp: 2000},
+			{value: 3, timestamp: 3000},
+		},
+	}))
+
+	points, err := unmarshalPromWriteRequest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := map[string]string{"host": "serverA"}
+	if !reflect.DeepEqual(points, []*Point{
+		{Name: "cpu", Tags: tags, Timestamp: time.Unix(1, 0).UTC(), Values: map[string]interface{}{"value": 1.5}},
+		{Name: "cpu", Tags: tags, Timestamp: time.Unix(3, 0).UTC(), Values: map[string]interface{}{"value": float64(3)}},
+	}) {
+		t.Fatalf("unexpected points: %#v", points)
+	}
+}
+
+// Ensure time series without a name cannot be written.
+func TestUnmarshalPromWriteRequest_MissingName(t *testing.T) {
+	data := appendProtobufBytes(nil, 1, marshalPromTimeSeries(&promTimeSeries{
+		labels:  promLabels{{"host", "serverA"}},
+		samples: []promSample{{value: 1, timestamp: 1000}},
+	}))
+	if _, err := unmarshalPromWriteRequest(data); err != errPromMissingName {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure remote storage read requests are decoded into queries.
+func TestUnmarshalPromReadRequest(t *testing.T) {
+	var q []byte
+	q = appendProtobufVarint(q, 1, 1000)
+	q = appendProtobufVarint(q, 2, 2000)
+	q = appendProtobufBytes(q, 3, marshalPromMatcher(promMatchEqual, "__name__", "cpu"))
+	q = appendProtobufBytes(q, 3, marshalPromMatcher(promMatchRegex, "host", "server.*"))
+
+	queries, err := unmarshalPromReadRequest(appendProtobufBytes(nil, 1, q))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(queries) != 1 || queries[0].start != 1000 || queries[0].end != 2000 || len(queries[0].matchers) != 2 {
+		t.Fatalf("unexpected queries: %#v", queries)
+	}
+
+	// Regexes must match the whole value.
+	m := queries[0].matchers[1]
+	if !m.matches("serverA") || m.matches("xserverA") {
+		t.Fatalf("unexpected regex: %s", m.regex)
+	}
+}
+
+// Ensure invalid matchers return an error.
+func TestUnmarshalPromReadRequest_InvalidMatcher(t *testing.T) {
+	for i, tt := range []struct {
+		matcher []byte
+		err     string
+	}{
+		{matcher: marshalPromMatcher(4, "host", "a"), err: "invalid matcher type: 4"},
+		{matcher: marshalPromMatcher(promMatchRegex, "host", "("), err: "invalid regex: error parsing regexp: missing closing ): `^(?:()$`"},
+	} {
+		data := appendProtobufBytes(nil, 1, appendProtobufBytes(nil, 3, tt.matcher))
+		if _, err := unmarshalPromReadRequest(data); err == nil || err.Error() != tt.err {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		}
+	}
+}
+
+// Ensure read responses encode each query's time series.
+func TestMarshalPromReadResponse(t *testing.T) {
+	ts := &promTimeSeries{labels: promLabels{{"__name__", "cpu"}}, samples: []promSample{{value: 2, timestamp: -1}}}
+	data := marshalPromReadResponse([][]*promTimeSeries{{ts}, nil})
+
+	// Decode the time series of each result.
+	var results [][]*promTimeSeries
+	if err := decodeProtobuf(data, func(field, wire int, b *protoBuffer) error {
+		buf, err := b.bytes()
+		if err != nil {
+			return err
+		}
+		var result []*promTimeSeries
+		err = decodeProtobuf(buf, func(field, wire int, b *protoBuffer) error {
+			buf, err := b.bytes()
+			if err != nil {
+				return err
+			}
+			ts, err := unmarshalPromTimeSeries(buf)
+			result = append(result, ts)
+			return err
+		})
+		results = append(results, result)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(results, [][]*promTimeSeries{{ts}, nil}) {
+		t.Fatalf("unexpected results: %#v", results)
+	}
+}
+
+// Ensure rows are converted to time series with sorted labels.
+func TestPromRowTimeSeries(t *testing.T) {
+	row := &influxql.Row{
+		Name:    "cpu",
+		Tags:    map[string]string{"host": "serverA", "Zone": "a", "dc": ""},
+		Columns: []string{"time", "value"},
+		Values:  [][]interface{}{{int64(1000000), 1.5}, {int64(2000000), "bad"}},
+	}
+
+	ts := promRowTimeSeries(row, nil)
+	if !reflect.DeepEqual(ts, &promTimeSeries{
+		labels:  promLabels{{"Zone", "a"}, {"__name__", "cpu"}, {"host", "serverA"}},
+		samples: []promSample{{value: 1.5, timestamp: 1000}},
+	}) {
+		t.Fatalf("unexpected time series: %#v", ts)
+	}
+
+	// Matchers are applied to tags, with missing tags matching an empty value.
+	for i, tt := range []struct {
+		matcher *promMatcher
+		match   bool
+	}{
+		{matcher: &promMatcher{typ: promMatchEqual, name: "dc", value: ""}, match: true},
+		{matcher: &promMatcher{typ: promMatchNotEqual, name: "host", value: "serverA"}, match: false},
+		{matcher: &promMatcher{typ: promMatchNotRegex, name: "missing", regex: regexp.MustCompile("^(?:x)$")}, match: true},
+		{matcher: &promMatcher{typ: promMatchEqual, name: "__name__", value: "other"}, match: true},
+	} {
+		if ts := promRowTimeSeries(row, []*promMatcher{tt.matcher}); (ts != nil) != tt.match {
+			t.Errorf("%d. unexpected match: %v", i, ts != nil)
+		}
+	}
+}
+
+// Ensure equality matchers on tags are added to the query's condition.
+func TestPromSelectStatement(t *testing.T) {
+	q := &promQuery{start: 0, end: 1000, matchers: []*promMatcher{
+		{typ: promMatchEqual, name: "__name__", value: "cpu"},
+		{typ: promMatchEqual, name: "host", value: "serverA"},
+		{typ: promMatchEqual, name: "dc", value: ""},
+		{typ: promMatchNotEqual, name: "region", value: "uswest"},
+	}}
+
+	stmt, ok := promSelectStatement("cpu", []string{"dc", "host", "region"}, q)
+	if !ok {
+		t.Fatal("expected statement")
+	} else if s := stmt.String(); s != `SELECT value FROM cpu WHERE time >= "1970-01-01 00:00:00" AND time < "1970-01-01 00:00:01.001" AND host = "serverA" GROUP BY dc, host, region` {
+		t.Fatalf("unexpected statement: %s", s)
+	}
+
+	// Measurements without a required tag are skipped.
+	if _, ok := promSelectStatement("cpu", []string{"dc"}, q); ok {
+		t.Fatal("expected no statement")
+	}
+}
+
+// marshalPromTimeSeries encodes a TimeSeries message.
+func marshalPromTimeSeries(ts *promTimeSeries) []byte {
+	data := marshalPromReadResponse([][]*promTimeSeries{{ts}})
+
+	// Unwrap the time series from the result of the response.
+	var buf []byte
+	decodeProtobuf(data, func(field, wire int, b *protoBuffer) error {
+		result, err := b.bytes()
+		decodeProtobuf(result, func(field, wire int, b *protoBuffer) error {
+			buf, err = b.bytes()
+			return err
+		})
+		return err
+	})
+	return buf
+}
+
+// marshalPromMatcher encodes a LabelMatcher message.
+func marshalPromMatcher(typ int, name, value string) []byte {
+	var b []byte
+	b = appendProtobufVarint(b, 1, uint64(typ))
+	b = appendProtobufBytes(b, 2, []byte(name))
+	return appendProtobufBytes(b, 3, []byte(value))
+}
@@ -0,0 +1,242 @@
+package influxdb
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// runningQuery represents a statement that is being executed by the server.
+// It tracks the memory allocated by the statement's iterators and buffers.
+type runningQuery struct {
+	id       uint64
+	database string
+	user     string
+	query    string
+	start    time.Time
+
+	limit    int64 // maximum bytes allocated, zero is unlimited
+	bytes    int64 // bytes allocated so far, updated atomically
+	exceeded int32 // set to 1 once the limit is exceeded, updated atomically
+
+	spillDir string       // directory for points that exceed the limit, empty disables spilling
+	spillMu  sync.Mutex   // protects spills
+	spills   []*spillFile // files written by the statement
+}
+
+// alloc records that the query has allocated n bytes.
+// Returns false once the query has allocated more than its limit.
+func (q *runningQuery) alloc(n int64) bool {
+	if q == nil {
+		return true
+	}
+	if b := atomic.AddInt64(&q.bytes, n); q.limit > 0 && b > q.limit {
+		atomic.StoreInt32(&q.exceeded, 1)
+	}
+	return !q.limitExceeded()
+}
+
+// reserve records that the query has allocated n bytes if it stays within
+// its limit. Returns false without recording the bytes otherwise.
+func (q *runningQuery) reserve(n int64) bool {
+	if q == nil {
+		return true
+	}
+	for {
+		b := atomic.LoadInt64(&q.bytes)
+		if q.limit > 0 && b+n > q.limit {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&q.bytes, b, b+n) {
+			return true
+		}
+	}
+}
+
+// pointLoader accounts for the points of a series as they are read by a
+// query. Points are held in memory while the query stays within its limit.
+// Once a point would exceed the limit, the points loaded so far and every
+// later point are spilled to disk and read back as they are iterated.
+type pointLoader struct {
+	q      *runningQuery
+	points []*seriesPoint
+	n      int64      // bytes reserved for points
+	spill  *spillFile // set once points are spilled
+}
+
+// loader returns a new loader for points read by the query.
+func (q *runningQuery) loader() *pointLoader { return &pointLoader{q: q} }
+
+// add loads a point. Returns ErrQueryMemoryExceeded, and marks the limit as
+// exceeded, if the point would exceed the query's limit and spilling is
+// disabled. Returns the error if the point can't be spilled.
+func (l *pointLoader) add(p *seriesPoint) error {
+	if l.spill != nil {
+		return l.write(p)
+	}
+
+	n := seriesPointSize(p)
+	if l.q.reserve(n) {
+		l.points = append(l.points, p)
+		l.n += n
+		return nil
+	} else if l.q.spillDir == "" {
+		l.q.alloc(n)
+		return ErrQueryMemoryExceeded
+	}
+
+	// Move the points held in memory to a new spill file.
+	f, err := createSpillFile(l.q.spillDir)
+	if err != nil {
+		return fmt.Errorf("write spill: %s", err)
+	}
+	l.q.spillMu.Lock()
+	l.q.spills = append(l.q.spills, f)
+	l.q.spillMu.Unlock()
+	l.spill = f
+
+	for _, p := range l.points {
+		if err := l.write(p); err != nil {
+			return err
+		}
+	}
+	l.q.alloc(-l.n)
+	l.points, l.n = nil, 0
+	return l.write(p)
+}
+
+// write appends a point to the loader's spill file.
+func (l *pointLoader) write(p *seriesPoint) error {
+	if err := l.spill.write(p); err != nil {
+		return fmt.Errorf("write spill: %s", err)
+	}
+	return nil
+}
+
+// reader returns a reader over the loaded points.
+func (l *pointLoader) reader() (pointReader, error) {
+	if l.spill == nil {
+		return &sliceReader{points: l.points}, nil
+	} else if err := l.spill.rewind(); err != nil {
+		return nil, fmt.Errorf("write spill: %s", err)
+	}
+	return l.spill, nil
+}
+
+// close removes any files spilled by the query.
+func (q *runningQuery) close() {
+	q.spillMu.Lock()
+	defer q.spillMu.Unlock()
+	for _, f := range q.spills {
+		f.close()
+	}
+	q.spills = nil
+}
+
+// limitExceeded returns true if the query has allocated more than its limit.
+func (q *runningQuery) limitExceeded() bool {
+	return q != nil && atomic.LoadInt32(&q.exceeded) == 1
+}
+
+// memory returns the number of bytes the query has allocated.
+func (q *runningQuery) memory() int64 { return atomic.LoadInt64(&q.bytes) }
+
+// startQuery registers a statement as running and returns it.
+// The statement must be removed with finishQuery once it completes.
+func (s *Server) startQuery(database string, user *User, stmt string, limit int64) *runningQuery {
+	q := &runningQuery{
+		database: database,
+		query:    stmt,
+		start:    time.Now(),
+		limit:    limit,
+	}
+	if user != nil {
+		q.user = user.Name
+	}
+
+	s.queriesMu.Lock()
+	defer s.queriesMu.Unlock()
+	s.queryID++
+	q.id = s.queryID
+	q.spillDir = s.spillDir
+	s.queries[q.id] = q
+	return q
+}
+
+// finishQuery removes a statement from the list of running queries.
+func (s *Server) finishQuery(q *runningQuery) {
+	s.queriesMu.Lock()
+	delete(s.queries, q.id)
+	s.queriesMu.Unlock()
+	q.close()
+}
+
+// SetQuerySpillDir sets the directory that statements write points to when
+// they would exceed their memory limit. An empty dir disables spilling and
+// statements that exceed their limit return ErrQueryMemoryExceeded.
+func (s *Server) SetQuerySpillDir(dir string) {
+	s.queriesMu.Lock()
+	defer s.queriesMu.Unlock()
+	s.spillDir = dir
+}
+
+// executeShowQueriesStatement returns a row for each running statement.
+// Non-admin users only see their own statements.
+func (s *Server) executeShowQueriesStatement(user *User) *Result {
+	s.queriesMu.Lock()
+	a := make([]*runningQuery, 0, len(s.queries))
+	for _, q := range s.queries {
+		if user == nil || user.Admin || q.user == user.Name {
+			a = append(a, q)
+		}
+	}
+	s.queriesMu.Unlock()
+	sort.Sort(runningQueries(a))
+
+	row := &influxql.Row{
+		Name:    "queries",
+		Columns: []string{"id", "database", "user", "query", "duration", "memory"},
+	}
+	for _, q := range a {
+		row.Values = append(row.Values, []interface{}{q.id, q.database, q.user, q.query, time.Since(q.start).String(), q.memory()})
+	}
+	return &Result{Rows: []*influxql.Row{row}}
+}
+
+// runningQueries represents a list of running queries, sortable by id.
+type runningQueries []*runningQuery
+
+func (a runningQueries) Len() int           { return len(a) }
+func (a runningQueries) Less(i, j int) bool { return a[i].id < a[j].id }
+func (a runningQueries) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// seriesPointSize returns the estimated number of bytes used by a point
+// read from a shard.
+func seriesPointSize(p *seriesPoint) int64 {
+	n := int64(64) // point, timestamp and map header
+	for k, v := range p.values {
+		n += 32 + int64(len(k))
+		if s, ok := v.(string); ok {
+			n += int64(len(s))
+		}
+	}
+	return n
+}
+
+// rowSize returns the estimated number of bytes used by a result row.
+func rowSize(row *influxql.Row) int64 {
+	n := int64(64)
+	for _, values := range row.Values {
+		n += 24 + 16*int64(len(values))
+		for _, v := range values {
+			if s, ok := v.(string); ok {
+				n += int64(len(s))
+			}
+		}
+	}
+	return n
+}
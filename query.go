@@ -0,0 +1,459 @@
+package influxdb
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// dbq implements influxql.DB for a database on the server.
+// Callers must hold the server lock while planning and starting execution.
+// Iterators hold the stores of the shards they read and read their points
+// after the lock is released.
+type dbq struct {
+	db *database
+	rp *RetentionPolicy // if set, only shards in this policy are read
+
+	concurrency int             // maximum number of shards read at once
+	query       *runningQuery   // statement that memory is accounted to, if set
+	ctx         context.Context // stops reads once done
+	snapshot    uint64          // if set, only points written at or before this index are read
+
+	mu    sync.Mutex
+	err   error                 // first error reading a shard
+	spans map[uint64]*shardSpan // time spent and data read by shard id
+}
+
+// shardSpan represents the time a statement spent reading a shard and the
+// data it read.
+type shardSpan struct {
+	series int           // number of series read
+	points int           // number of points read
+	bytes  int64         // size of the encoded points read
+	queue  time.Duration // time series waited for a worker before being read
+	scan   time.Duration // time spent reading series
+}
+
+// Err returns the first error that occurred reading a shard, if any.
+func (q *dbq) Err() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err
+}
+
+// span returns a copy of the statistics for reading a shard, if it was read.
+func (q *dbq) span(shardID uint64) *shardSpan {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if sp := q.spans[shardID]; sp != nil {
+		other := *sp
+		return &other
+	}
+	return nil
+}
+
+// addSpan adds the statistics for reading a series to a shard's span.
+func (q *dbq) addSpan(shardID uint64, points int, bytes int64, queue, scan time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.spans == nil {
+		q.spans = make(map[uint64]*shardSpan)
+	}
+	sp := q.spans[shardID]
+	if sp == nil {
+		sp = &shardSpan{}
+		q.spans[shardID] = sp
+	}
+	sp.series++
+	sp.points += points
+	sp.bytes += bytes
+	sp.queue += queue
+	sp.scan += scan
+}
+
+// setErr records an error reading a shard if one hasn't already occurred.
+func (q *dbq) setErr(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err == nil {
+		q.err = err
+	}
+}
+
+// MatchSeries returns the ids of the series in a measurement matching a tagset.
+func (q *dbq) MatchSeries(name string, tags map[string]string) []uint32 {
+	m := q.db.index().measurements[name]
+	if m == nil {
+		return nil
+	}
+
+	// Return all series if there is no tag filter.
+	if len(tags) == 0 {
+		return m.ids
+	}
+
+	// Otherwise intersect the series for each tag key/value pair.
+	var filters []*TagFilter
+	for k, v := range tags {
+		filters = append(filters, &TagFilter{Key: k, Value: v})
+	}
+	return q.db.seriesIDsByName(name, filters)
+}
+
+// SeriesTagValues returns a slice of tag values for a series.
+func (q *dbq) SeriesTagValues(seriesID uint32, keys []string) []string {
+	values := make([]string, len(keys))
+	if s := q.db.index().series[seriesID]; s != nil {
+		for i, k := range keys {
+			values[i] = s.Tags[k]
+		}
+	}
+	return values
+}
+
+// Field returns the id and data type for a measurement field.
+// Returns an id of zero if the field does not exist.
+func (q *dbq) Field(name, field string) (fieldID uint8, typ influxql.DataType) {
+	if m := q.db.index().measurements[name]; m != nil {
+		if f := m.field(field); f != nil {
+			return f.ID, f.Type
+		}
+	}
+	return
+}
+
+// CreateIterator returns an iterator over a series field for a time range.
+// Points whose field values don't match cond are skipped.
+func (q *dbq) CreateIterator(seriesID uint32, fieldID uint8, typ influxql.DataType, min, max time.Time, interval time.Duration, cond influxql.Expr) influxql.Iterator {
+	itr := &seriesIterator{imin: -1, interval: int64(interval), cond: cond, setErr: q.setErr}
+	influxql.WalkFunc(cond, func(n influxql.Node) {
+		if ref, ok := n.(*influxql.VarRef); ok && ref.Val == "time" {
+			itr.condTime = true
+		}
+	})
+	if !min.IsZero() {
+		itr.min = min.UnixNano()
+	}
+	if !max.IsZero() {
+		itr.max = max.UnixNano()
+	}
+
+	// Lookup the field name.
+	m := q.db.MeasurementBySeriesID(seriesID)
+	if m == nil {
+		return itr
+	}
+	f := m.fieldByID(fieldID)
+	if f == nil {
+		return itr
+	}
+	itr.field = f.Name
+
+	// Only read the shards that the series can be written to.
+	candidates := q.shards(min, max)
+	if s := q.db.index().series[seriesID]; s != nil {
+		candidates = q.db.seriesShards(candidates, seriesKey(m.Name, s.Tags))
+	}
+
+	// Hold the stores of the shards overlapping the time range so they stay
+	// open if the shards are compacted or dropped before the points are read.
+	var shards []*Shard
+	var stores []*shardStore
+	var masks [][]deleteMask
+	for _, sh := range candidates {
+		// Shards are created with the index of their create command, so
+		// shards created after the snapshot hold no points for it.
+		if q.snapshot != 0 && sh.ID > q.snapshot {
+			continue
+		}
+		st, err := sh.acquire()
+		if err == ErrShardNotFound {
+			continue
+		} else if err != nil {
+			q.setErr(err)
+			continue
+		}
+		shards, stores = append(shards, sh), append(stores, st)
+		masks = append(masks, q.db.deleteMasks(m.Name, sh))
+	}
+
+	// Read the points when the iterator is first used, after the server lock
+	// has been released, and then release the stores.
+	itr.load = func() pointReader {
+		defer func() {
+			for i, st := range stores {
+				shards[i].release(st)
+			}
+		}()
+
+		// Stop reading once the statement has exceeded its memory limit.
+		// The statement returns an error so the empty iterator is never reported.
+		if q.query.limitExceeded() {
+			return &sliceReader{}
+		}
+
+		// Read the points from each shard and merge them. Points that would
+		// exceed the statement's memory limit are spilled to disk.
+		return mergeReaders(q.readSeries(shards, stores, masks, seriesID, itr.min, itr.max))
+	}
+
+	return itr
+}
+
+// readSeries reads a series from the store of each shard, skipping the points
+// masked by deletions in the shard. Stores are read concurrently, up to the
+// dbq's concurrency limit. Returns a reader for each store in the same order
+// as the stores. Stores that can't be read, or aren't read before the dbq's
+// context is done, return no points and their error is reported by Err. The time and data read is added to each shard's span.
+func (q *dbq) readSeries(shards []*Shard, stores []*shardStore, masks [][]deleteMask, seriesID uint32, min, max int64) []pointReader {
+	a := make([]pointReader, len(stores))
+
+	// Determine the number of workers.
+	n := q.concurrency
+	if n > len(stores) {
+		n = len(stores)
+	}
+	if n < 1 {
+		n = 1
+	}
+
+	// Read stores from a shared queue.
+	ch := make(chan int, len(stores))
+	for i := range stores {
+		ch <- i
+	}
+	close(ch)
+	queued := time.Now()
+
+	var wg sync.WaitGroup
+	for j := 0; j < n; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				// Points that would exceed the statement's memory limit are
+				// spilled to disk as they are read.
+				start := time.Now()
+				l := q.query.loader()
+				n, size, err := stores[i].scanSeries(q.ctx, seriesID, min, max, q.snapshot, masks[i], l.add)
+				q.addSpan(shards[i].ID, n, size, start.Sub(queued), time.Since(start))
+				if err == nil {
+					a[i], err = l.reader()
+				}
+				if err != nil {
+					q.setErr(err)
+					a[i] = &sliceReader{}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return a
+}
+
+// mergeReaders returns a single reader over a list of readers. Lists held in
+// memory are merged up front and spilled lists are merged as they are read.
+func mergeReaders(readers []pointReader) pointReader {
+	lists := make([][]*seriesPoint, 0, len(readers))
+	for _, r := range readers {
+		sr, ok := r.(*sliceReader)
+		if !ok {
+			return newMergeReader(readers)
+		}
+		lists = append(lists, sr.points)
+	}
+	return &sliceReader{points: mergeSeriesPoints(lists)}
+}
+
+// mergeSeriesPoints merges lists of points that are each sorted by timestamp
+// into a single sorted list. Points with the same timestamp are ordered by
+// the index of their list.
+func mergeSeriesPoints(lists [][]*seriesPoint) []*seriesPoint {
+	// Return the only list without copying.
+	n := 0
+	h := make(seriesPointHeap, 0, len(lists))
+	for i, points := range lists {
+		if len(points) > 0 {
+			h = append(h, &seriesPointCursor{points: points, index: i})
+			n += len(points)
+		}
+	}
+	if len(h) == 1 {
+		return h[0].points
+	}
+	heap.Init(&h)
+
+	// Pop the earliest point until all lists are exhausted.
+	a := make([]*seriesPoint, 0, n)
+	for len(h) > 0 {
+		c := h[0]
+		a = append(a, c.points[0])
+		if c.points = c.points[1:]; len(c.points) > 0 {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return a
+}
+
+// seriesPointCursor represents the remaining points in a sorted list.
+type seriesPointCursor struct {
+	points []*seriesPoint
+	index  int // position of the list, used to break ties
+}
+
+// seriesPointHeap is a min-heap of cursors ordered by their next point.
+type seriesPointHeap []*seriesPointCursor
+
+func (h seriesPointHeap) Len() int { return len(h) }
+func (h seriesPointHeap) Less(i, j int) bool {
+	if ti, tj := h[i].points[0].timestamp, h[j].points[0].timestamp; ti != tj {
+		return ti < tj
+	}
+	return h[i].index < h[j].index
+}
+func (h seriesPointHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *seriesPointHeap) Push(x interface{}) { *h = append(*h, x.(*seriesPointCursor)) }
+
+func (h *seriesPointHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// shards returns the shards in the database that overlap a time range.
+// Only shards in the retention policy are returned if one is set.
+func (q *dbq) shards(min, max time.Time) (a []*Shard) {
+	shards := q.db.shards
+	if q.rp != nil {
+		shards = make(map[uint64]*Shard, len(q.rp.Shards))
+		for _, sh := range q.rp.Shards {
+			shards[sh.ID] = sh
+		}
+	}
+
+	for _, sh := range shards {
+		if !max.IsZero() && sh.StartTime.After(max) {
+			continue
+		} else if sh.EndTime.Before(min) {
+			continue
+		}
+		a = append(a, sh)
+	}
+	sort.Sort(Shards(a))
+	return
+}
+
+// seriesIterator represents an iterator over a single field of a series.
+type seriesIterator struct {
+	field    string
+	cond     influxql.Expr      // field conditional, if set
+	condTime bool               // conditional references the point's time
+	load     func() pointReader // reads the points on first use, if set
+	points   pointReader
+	setErr   func(error) // reports an error reading the points, if set
+
+	min, max   int64 // time range
+	imin, imax int64 // interval time range
+	interval   int64 // interval duration
+}
+
+// NextIterval moves the iterator to the next available interval.
+// Returns true if another iterval is available.
+func (i *seriesIterator) NextIterval() bool {
+	// Read the points before the first interval.
+	if i.load != nil {
+		i.points, i.load = i.load(), nil
+	}
+
+	// Initialize interval start time if not set.
+	// If there's no duration then there's only one interval.
+	// Otherwise increment it by the interval.
+	if i.imin == -1 {
+		i.imin = i.min
+	} else if i.interval == 0 {
+		return false
+	} else if imin := i.imin + i.interval; i.max == 0 || imin < i.max {
+		i.imin = imin
+	} else {
+		return false
+	}
+
+	// Interval end time should be the start time plus interval duration.
+	// If the end time is beyond the iterator end time then shorten it.
+	// Without an interval the end time is the iterator end time.
+	i.imax = i.imin + i.interval
+	if i.interval == 0 || (i.max != 0 && i.imax > i.max) {
+		i.imax = i.max
+	}
+
+	return true
+}
+
+// Next returns the next point's timestamp and field value.
+func (i *seriesIterator) Next() (timestamp int64, value interface{}) {
+	for {
+		// If there are no more points then return nil.
+		if i.points == nil {
+			return 0, nil
+		}
+		p := i.points.peek()
+		if p == nil {
+			if err := i.Err(); err != nil && i.setErr != nil {
+				i.setErr(err)
+			}
+			return 0, nil
+		}
+
+		// Return nil if the point is beyond the interval's time range.
+		if p.timestamp >= i.imax && i.imax != 0 {
+			return 0, nil
+		}
+		i.points.next()
+
+		// Return value if it is non-nil and the point matches the conditional.
+		// Otherwise loop again and try the next point.
+		if v := p.values[i.field]; v != nil && i.match(p) {
+			return p.timestamp, v
+		}
+	}
+}
+
+// Err returns the error that stopped the iterator reading its points, if any.
+func (i *seriesIterator) Err() error {
+	if i.points == nil {
+		return nil
+	}
+	return i.points.err()
+}
+
+// match returns true if a point matches the iterator's conditional. The
+// point's values are copied when the conditional needs its time.
+func (i *seriesIterator) match(p *seriesPoint) bool {
+	if i.cond == nil {
+		return true
+	}
+	values := p.values
+	if i.condTime {
+		values = make(map[string]interface{}, len(p.values)+1)
+		for k, v := range p.values {
+			values[k] = v
+		}
+		values["time"] = time.Unix(0, p.timestamp).UTC()
+	}
+	return influxql.Eval(i.cond, values) == true
+}
+
+// Time returns start time of the current interval.
+func (i *seriesIterator) Time() int64 { return i.imin }
+
+// Interval returns the group by duration.
+func (i *seriesIterator) Interval() time.Duration { return time.Duration(i.interval) }
@@ -0,0 +1,239 @@
+package influxdb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Ensure sorted lists of points can be merged in time order.
+func TestMergeSeriesPoints(t *testing.T) {
+	for i, tt := range []struct {
+		lists [][]int64
+		exp   []int64
+	}{
+		{lists: nil, exp: nil},
+		{lists: [][]int64{{1, 2, 3}}, exp: []int64{1, 2, 3}},
+		{lists: [][]int64{{1, 4, 7}, {}, {2, 5}, {3, 6, 8, 9}}, exp: []int64{1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{lists: [][]int64{{5, 10}, {1, 5}}, exp: []int64{1, 5, 5, 10}},
+	} {
+		lists := make([][]*seriesPoint, len(tt.lists))
+		for j, timestamps := range tt.lists {
+			for _, ts := range timestamps {
+				lists[j] = append(lists[j], &seriesPoint{timestamp: ts, values: map[string]interface{}{"list": j}})
+			}
+		}
+
+		var act []int64
+		points := mergeSeriesPoints(lists)
+		for _, p := range points {
+			act = append(act, p.timestamp)
+		}
+		if !reflect.DeepEqual(act, tt.exp) {
+			t.Errorf("%d. unexpected timestamps: %v", i, act)
+		}
+
+		// Points with the same timestamp are returned in list order.
+		for j := 1; j < len(points); j++ {
+			if points[j-1].timestamp == points[j].timestamp && points[j-1].values["list"].(int) > points[j].values["list"].(int) {
+				t.Errorf("%d. unexpected order at %d", i, j)
+			}
+		}
+	}
+}
+
+// Ensure points spilled to disk are merged in time order with points held in memory.
+func TestMergeReader_Spill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influxdb-spill-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := writeSpillFile(dir, []*seriesPoint{
+		{timestamp: 1, values: map[string]interface{}{"value": 1.5}},
+		{timestamp: 4, values: map[string]interface{}{"value": "foo"}},
+		{timestamp: 6, values: map[string]interface{}{"value": true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newMergeReader([]pointReader{f, &sliceReader{points: []*seriesPoint{
+		{timestamp: 2, values: map[string]interface{}{"value": 2.0}},
+		{timestamp: 4, values: map[string]interface{}{"value": 4.0}},
+	}}})
+
+	var act []interface{}
+	for p := r.peek(); p != nil; p = r.peek() {
+		act = append(act, p.timestamp, p.values["value"])
+		r.next()
+	}
+	if exp := []interface{}{int64(1), 1.5, int64(2), 2.0, int64(4), "foo", int64(4), 4.0, int64(6), true}; !reflect.DeepEqual(act, exp) {
+		t.Fatalf("unexpected points: %v", act)
+	}
+
+	// The file is removed once it has been read.
+	if _, err := os.Stat(f.f.Name()); !os.IsNotExist(err) {
+		t.Fatalf("unexpected stat error: %v", err)
+	}
+}
+
+// Ensure points are spilled as they are loaded once they would exceed the
+// query's limit, and the points already held in memory are released.
+func TestPointLoader_Spill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influxdb-spill-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	points := []*seriesPoint{
+		{timestamp: 1, values: map[string]interface{}{"value": 1.0}},
+		{timestamp: 2, values: map[string]interface{}{"value": 2.0}},
+		{timestamp: 3, values: map[string]interface{}{"value": 3.0}},
+	}
+	q := &runningQuery{limit: 2 * seriesPointSize(points[0]), spillDir: dir}
+	defer q.close()
+
+	l := q.loader()
+	for i, p := range points {
+		if err := l.add(p); err != nil {
+			t.Fatal(err)
+		}
+
+		// The third point moves every point to disk.
+		if spilled := l.spill != nil; spilled != (i == 2) {
+			t.Fatalf("%d. unexpected spill: %v", i, spilled)
+		}
+	}
+	if n := q.memory(); n != 0 {
+		t.Fatalf("unexpected memory: %d", n)
+	} else if q.limitExceeded() {
+		t.Fatal("unexpected limit exceeded")
+	}
+
+	r, err := l.reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var act []int64
+	for p := r.peek(); p != nil; p = r.peek() {
+		act = append(act, p.timestamp)
+		r.next()
+	}
+	if !reflect.DeepEqual(act, []int64{1, 2, 3}) {
+		t.Fatalf("unexpected timestamps: %v", act)
+	}
+}
+
+// Ensure a query without a spill directory stops loading once it exceeds its limit.
+func TestPointLoader_LimitExceeded(t *testing.T) {
+	p := &seriesPoint{timestamp: 1, values: map[string]interface{}{"value": 1.0}}
+	q := &runningQuery{limit: seriesPointSize(p)}
+	l := q.loader()
+	if err := l.add(p); err != nil {
+		t.Fatal(err)
+	} else if err := l.add(p); err != ErrQueryMemoryExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !q.limitExceeded() {
+		t.Fatal("expected limit exceeded")
+	}
+}
+
+// Ensure an error reading a spill file stops the iterator and is reported.
+func TestSeriesIterator_SpillError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influxdb-spill-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := writeSpillFile(dir, []*seriesPoint{
+		{timestamp: 1, values: map[string]interface{}{"value": 1.5}},
+		{timestamp: 2, values: map[string]interface{}{"value": 2.5}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Truncate the file in the middle of the second point.
+	if fi, err := f.f.Stat(); err != nil {
+		t.Fatal(err)
+	} else if err := f.f.Truncate(fi.Size() - 1); err != nil {
+		t.Fatal(err)
+	}
+
+	var reported error
+	itr := &seriesIterator{field: "value", imin: -1, setErr: func(err error) { reported = err }}
+	itr.load = func() pointReader { return newMergeReader([]pointReader{f}) }
+	itr.NextIterval()
+	if ts, v := itr.Next(); ts != 1 || v != 1.5 {
+		t.Fatalf("unexpected point: %d %v", ts, v)
+	} else if _, v := itr.Next(); v != nil {
+		t.Fatalf("unexpected value: %v", v)
+	} else if err := itr.Err(); err == nil || err.Error() != "read spill: unexpected EOF" {
+		t.Fatalf("unexpected error: %v", err)
+	} else if reported != itr.Err() {
+		t.Fatalf("unexpected reported error: %v", reported)
+	}
+
+	// The file is removed once it fails.
+	if _, err := os.Stat(f.f.Name()); !os.IsNotExist(err) {
+		t.Fatalf("unexpected stat error: %v", err)
+	}
+}
+
+// Ensure a shard's store stays readable after the shard is closed until it is released.
+func TestShard_Acquire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influxdb-shard-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sh := newShard()
+	if err := sh.open(filepath.Join(dir, "1")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := marshalPoint(1, time.Unix(0, 10), map[string]interface{}{"value": 100.0})
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := sh.writeSeries(nil, 1, [][]byte{data}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close the shard and remove its file while a reader holds the store.
+	st, err := sh.acquire()
+	if err != nil {
+		t.Fatal(err)
+	} else if err := sh.close(); err != nil {
+		t.Fatal(err)
+	} else if err := os.Remove(filepath.Join(dir, "1")); err != nil {
+		t.Fatal(err)
+	} else if _, err := sh.acquire(); err != ErrShardNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if points, _, err := st.readSeries(context.Background(), 1, 0, 0, 0, nil); err != nil {
+		t.Fatal(err)
+	} else if len(points) != 1 || points[0].timestamp != 10 || points[0].values["value"] != 100.0 {
+		t.Fatalf("unexpected points: %#v", points)
+	}
+
+	// Reads stop once their context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := st.readSeries(ctx, 1, 0, 0, 0, nil); err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The store is closed once it is released.
+	sh.release(st)
+	if _, _, err := st.readSeries(context.Background(), 1, 0, 0, 0, nil); err == nil {
+		t.Fatal("expected error reading released store")
+	}
+}
@@ -0,0 +1,156 @@
+package influxdb
+
+import (
+	"container/list"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// DefaultQueryCacheSize is the default number of parsed queries cached by the server.
+const DefaultQueryCacheSize = 1000
+
+// queryCache is a least-recently-used cache of parsed queries.
+//
+// Only parsed queries are cached. Plans are rebuilt on every execution
+// because they depend on the series that exist and the current time.
+type queryCache struct {
+	mu      sync.Mutex
+	size    int                      // maximum number of entries
+	list    *list.List               // entries ordered from most to least recently used
+	entries map[string]*list.Element // entries by key
+}
+
+// queryCacheEntry represents a cached query and its key.
+type queryCacheEntry struct {
+	key   string
+	query *influxql.Query
+}
+
+// newQueryCache returns a new instance of queryCache that holds up to size queries.
+func newQueryCache(size int) *queryCache {
+	return &queryCache{
+		size:    size,
+		list:    list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the query for a key and marks it as recently used.
+// Returns nil if the key is not in the cache.
+func (c *queryCache) get(key string) *influxql.Query {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[key]
+	if e == nil {
+		return nil
+	}
+	c.list.MoveToFront(e)
+	return e.Value.(*queryCacheEntry).query
+}
+
+// add inserts a query into the cache and evicts the least recently used
+// entries once the cache is full.
+func (c *queryCache) add(key string, q *influxql.Query) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e := c.entries[key]; e != nil {
+		e.Value.(*queryCacheEntry).query = q
+		c.list.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.list.PushFront(&queryCacheEntry{key: key, query: q})
+	c.evict()
+}
+
+// resize changes the maximum number of entries. A size of zero disables the cache.
+func (c *queryCache) resize(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size = size
+	c.evict()
+}
+
+// len returns the number of entries in the cache.
+func (c *queryCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.list.Len()
+}
+
+// evict removes the least recently used entries until the cache fits its size.
+func (c *queryCache) evict() {
+	for c.list.Len() > c.size {
+		e := c.list.Back()
+		c.list.Remove(e)
+		delete(c.entries, e.Value.(*queryCacheEntry).key)
+	}
+}
+
+// SetQueryCacheSize sets the maximum number of parsed queries the server caches.
+// A size of zero disables the cache.
+func (s *Server) SetQueryCacheSize(size int) {
+	if size < 0 {
+		size = 0
+	}
+	s.queryCache.resize(size)
+}
+
+// ParseQuery parses a query string for a database using the given bound parameters.
+// Parsed queries are cached by database, normalized query text and parameters so
+// queries that are issued repeatedly are only parsed once. Cache hits and misses
+// are recorded in the database statistics.
+func (s *Server) ParseQuery(database, q string, params map[string]interface{}) (*influxql.Query, error) {
+	// Build the cache key. Parameters are part of the key because they are
+	// substituted into the statements during parsing.
+	b, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	key := database + "\x00" + influxql.NormalizeQuery(q) + "\x00" + string(b)
+
+	// Return a copy of the cached query, if available.
+	if query := s.queryCache.get(key); query != nil {
+		s.addQueryCacheStat(database, StatQueryCacheHits)
+		return cloneQuery(query), nil
+	}
+	s.addQueryCacheStat(database, StatQueryCacheMisses)
+
+	// Otherwise parse the query and add it to the cache.
+	p := influxql.NewParser(strings.NewReader(q))
+	p.SetParams(params)
+	query, err := p.ParseQuery()
+	if err != nil {
+		return nil, err
+	}
+	s.queryCache.add(key, query)
+
+	return cloneQuery(query), nil
+}
+
+// addQueryCacheStat increments a query cache counter if the database exists.
+func (s *Server) addQueryCacheStat(database, name string) {
+	if st := s.DatabaseStats(database); st != nil {
+		st.Add(name, 1)
+	}
+}
+
+// cloneQuery returns a copy of a cached query that is safe to execute.
+// Planning folds conditions in place so select statements are copied.
+// Other statements are not modified during execution and are shared.
+func cloneQuery(q *influxql.Query) *influxql.Query {
+	other := &influxql.Query{Statements: make(influxql.Statements, len(q.Statements))}
+	for i, stmt := range q.Statements {
+		switch stmt := stmt.(type) {
+		case *influxql.SelectStatement:
+			other.Statements[i] = stmt.Clone()
+		case *influxql.ExplainStatement:
+			other.Statements[i] = &influxql.ExplainStatement{Statement: stmt.Statement.Clone(), Analyze: stmt.Analyze}
+		default:
+			other.Statements[i] = stmt
+		}
+	}
+	return other
+}
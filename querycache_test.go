@@ -0,0 +1,35 @@
+package influxdb
+
+import (
+	"testing"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Ensure the query cache evicts the least recently used query once it is full.
+func TestQueryCache_Evict(t *testing.T) {
+	c := newQueryCache(2)
+	q0, q1, q2 := &influxql.Query{}, &influxql.Query{}, &influxql.Query{}
+	c.add("0", q0)
+	c.add("1", q1)
+
+	// Use the first query so the second one is evicted instead.
+	if c.get("0") != q0 {
+		t.Fatal("expected query 0")
+	}
+	c.add("2", q2)
+	if c.len() != 2 {
+		t.Fatalf("unexpected len: %d", c.len())
+	} else if c.get("1") != nil {
+		t.Fatal("expected query 1 to be evicted")
+	} else if c.get("0") != q0 || c.get("2") != q2 {
+		t.Fatal("expected queries 0 and 2")
+	}
+
+	// Disabling the cache removes every query.
+	c.resize(0)
+	c.add("3", &influxql.Query{})
+	if c.len() != 0 {
+		t.Fatalf("unexpected len after disabling: %d", c.len())
+	}
+}
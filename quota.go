@@ -0,0 +1,70 @@
+package influxdb
+
+import (
+	"os"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// checkRetentionQuota returns ErrRetentionQuotaExceeded if a retention policy
+// duration is longer than a database's maximum retention. A zero duration
+// keeps data forever so it exceeds any maximum.
+func checkRetentionQuota(max, d time.Duration) error {
+	if max > 0 && (d == 0 || d > max) {
+		return ErrRetentionQuotaExceeded
+	}
+	return nil
+}
+
+// checkDiskQuota returns ErrDiskQuotaExceeded if the shards of a database
+// have reached its maximum size on disk.
+func (s *Server) checkDiskQuota(database string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	db := s.databases[database]
+	if db == nil || db.maxDiskBytes <= 0 {
+		return nil
+	}
+	if s.diskBytes(db) >= db.maxDiskBytes {
+		return ErrDiskQuotaExceeded
+	}
+	return nil
+}
+
+// diskBytes returns the size of the shard files of a database stored on
+// this server. The server lock must be held.
+func (s *Server) diskBytes(db *database) (n int64) {
+	for _, sh := range db.shards {
+		path := s.shardPath(sh.ID)
+		if path == "" {
+			continue
+		}
+		if fi, err := os.Stat(path); err == nil {
+			n += fi.Size()
+		}
+	}
+	return
+}
+
+// quotaStatNames is the ordered list of quota usage columns returned by
+// SHOW STATS FOR DATABASE. Maximums of zero are unlimited.
+var quotaStatNames = []string{"series", "maxSeries", "diskBytes", "maxDiskBytes", "maxRetention"}
+
+// quotaStats returns the quota usage values for a database in the order of
+// quotaStatNames. Returns nil if the database does not exist.
+func (s *Server) quotaStats(database string) []interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	db := s.databases[database]
+	if db == nil {
+		return nil
+	}
+	return []interface{}{
+		int64(db.seriesN()),
+		int64(db.maxSeries),
+		s.diskBytes(db),
+		db.maxDiskBytes,
+		influxql.FormatDuration(db.maxRetention),
+	}
+}
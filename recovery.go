@@ -0,0 +1,143 @@
+package influxdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// cleanShutdownFile is the name of the marker file written to the data
+// directory once every shard, index and the metastore have been closed. It is
+// removed when the server opens so that a crash leaves no marker behind.
+const cleanShutdownFile = "clean"
+
+// readShutdownMarker removes the clean shutdown marker from the data
+// directory. Returns true if the marker was present.
+func readShutdownMarker(path string) (bool, error) {
+	if err := os.Remove(filepath.Join(path, cleanShutdownFile)); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, syncDir(path)
+}
+
+// writeShutdownMarker writes the clean shutdown marker to the data directory.
+// The marker holds the time that the server closed.
+func writeShutdownMarker(path string) error {
+	f, err := os.OpenFile(filepath.Join(path, cleanShutdownFile), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(time.Now().UTC().Format(time.RFC3339Nano)); err != nil {
+		_ = f.Close()
+		return err
+	} else if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	}
+	return syncDir(path)
+}
+
+// syncDir flushes a directory so that files created in or removed from it
+// survive a crash.
+func syncDir(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// recoverData verifies the data directory after the server was not shut down
+// cleanly. The pages of the metastore and of every shard are checked and
+// indexes stored on disk are read in full so that records left partially
+// written are truncated. Progress is logged as each shard is checked.
+//
+// Problems found in pages are logged and the server still starts, since
+// "influxd inspect" is needed to repair them. Returns an error if a shard or
+// index can't be read at all.
+func (s *Server) recoverData() error {
+	start := time.Now()
+	s.Logger.Printf("recovery: server was not shut down cleanly, verifying data")
+
+	// Check the metastore's pages.
+	var problemN int
+	if err := s.meta.db.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			s.Logger.Printf("recovery: meta: %s", err)
+			problemN++
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("meta: %s", err)
+	}
+
+	// Read indexes stored on disk.
+	var indexN int
+	for _, db := range s.databases {
+		if db.indexLog == nil {
+			continue
+		}
+		idx := newSeriesIndex()
+		if err := db.indexLog.load(idx); err != nil {
+			return fmt.Errorf("index %s: %s", db.name, err)
+		}
+		db.indexMu.Lock()
+		db.idx = idx
+		db.indexMu.Unlock()
+		indexN++
+	}
+
+	// Check the pages of each shard. Shards are closed again once checked
+	// so that they're still opened on first use.
+	var shards []*Shard
+	for _, db := range s.databases {
+		for _, sh := range db.shards {
+			shards = append(shards, sh)
+		}
+	}
+	sort.Sort(Shards(shards))
+	for i, sh := range shards {
+		errs, err := sh.check()
+		if err != nil {
+			return fmt.Errorf("shard %d: %s", sh.ID, err)
+		}
+		for _, err := range errs {
+			s.Logger.Printf("recovery: shard %d: %s", sh.ID, err)
+		}
+		problemN += len(errs)
+		sh.evict()
+
+		s.Logger.Printf("recovery: checked shard %d (%d/%d)", sh.ID, i+1, len(shards))
+	}
+
+	s.Logger.Printf("recovery: checked %d shards and %d indexes in %s, %d problems found",
+		len(shards), indexN, time.Since(start), problemN)
+	return nil
+}
+
+// check opens the shard's store and returns the problems found in its pages.
+func (s *Shard) check() ([]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.openStore(); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	err := s.store.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		return nil
+	})
+	return errs, err
+}
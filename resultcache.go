@@ -0,0 +1,202 @@
+package influxdb
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// DefaultResultCacheMinAge is the default length of time a query's time range
+// must have ended before its results can be cached.
+const DefaultResultCacheMinAge = time.Minute
+
+// resultCache is a least-recently-used cache of select statement results.
+//
+// Only statements whose time range ended at least minAge ago are cached since
+// recent data is still being written. Entries are removed when a shard they
+// read from is written to or deleted, and all entries for a database are
+// removed when shards are added to it or it is dropped.
+type resultCache struct {
+	mu      sync.Mutex
+	size    int                            // maximum number of entries
+	minAge  time.Duration                  // minimum time since the end of a cached time range
+	gen     uint64                         // incremented on every invalidation
+	list    *list.List                     // entries ordered from most to least recently used
+	entries map[string]*list.Element       // entries by key
+	shards  map[uint64]map[string]struct{} // entry keys by shard id
+}
+
+// resultCacheEntry represents the cached rows for a statement.
+type resultCacheEntry struct {
+	key      string
+	database string
+	shardIDs []uint64
+	rows     []*influxql.Row
+}
+
+// newResultCache returns a new instance of resultCache.
+// The cache is disabled until it is given a size.
+func newResultCache() *resultCache {
+	return &resultCache{
+		minAge:  DefaultResultCacheMinAge,
+		list:    list.New(),
+		entries: make(map[string]*list.Element),
+		shards:  make(map[uint64]map[string]struct{}),
+	}
+}
+
+// cacheable returns true if results for a time range ending at max can be
+// cached at the given time.
+func (c *resultCache) cacheable(max, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size > 0 && !max.After(now.Add(-c.minAge))
+}
+
+// generation returns the current invalidation generation. Results computed
+// after reading the generation can only be added if it is unchanged.
+func (c *resultCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// get returns a copy of the cached rows for a key and marks it as recently used.
+func (c *resultCache) get(key string) ([]*influxql.Row, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[key]
+	if e == nil {
+		return nil, false
+	}
+	c.list.MoveToFront(e)
+	return copyRows(e.Value.(*resultCacheEntry).rows), true
+}
+
+// add inserts rows into the cache unless the cache has been invalidated since
+// gen was read. A copy of the rows is stored.
+func (c *resultCache) add(key, database string, shardIDs []uint64, rows []*influxql.Row, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size == 0 || c.gen != gen {
+		return
+	}
+	if e := c.entries[key]; e != nil {
+		c.remove(e)
+	}
+
+	entry := &resultCacheEntry{key: key, database: database, shardIDs: shardIDs, rows: copyRows(rows)}
+	c.entries[key] = c.list.PushFront(entry)
+	for _, id := range shardIDs {
+		if c.shards[id] == nil {
+			c.shards[id] = make(map[string]struct{})
+		}
+		c.shards[id][key] = struct{}{}
+	}
+	c.evict()
+}
+
+// invalidateShard removes all entries that read from a shard.
+func (c *resultCache) invalidateShard(id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for key := range c.shards[id] {
+		c.remove(c.entries[key])
+	}
+}
+
+// invalidateDatabase removes all entries for a database.
+func (c *resultCache) invalidateDatabase(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for e := c.list.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(*resultCacheEntry).database == name {
+			c.remove(e)
+		}
+		e = next
+	}
+}
+
+// setConfig changes the maximum number of entries and the minimum age of a
+// cached time range. A size of zero disables the cache.
+func (c *resultCache) setConfig(size int, minAge time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size, c.minAge = size, minAge
+	c.evict()
+}
+
+// len returns the number of entries in the cache.
+func (c *resultCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.list.Len()
+}
+
+// evict removes the least recently used entries until the cache fits its size.
+func (c *resultCache) evict() {
+	for c.list.Len() > c.size {
+		c.remove(c.list.Back())
+	}
+}
+
+// remove deletes an entry from the cache and the shard index.
+func (c *resultCache) remove(e *list.Element) {
+	entry := e.Value.(*resultCacheEntry)
+	c.list.Remove(e)
+	delete(c.entries, entry.key)
+	for _, id := range entry.shardIDs {
+		delete(c.shards[id], entry.key)
+		if len(c.shards[id]) == 0 {
+			delete(c.shards, id)
+		}
+	}
+}
+
+// copyRows returns a copy of rows that is safe for the caller to modify.
+func copyRows(rows []*influxql.Row) []*influxql.Row {
+	if rows == nil {
+		return nil
+	}
+	other := make([]*influxql.Row, len(rows))
+	for i, row := range rows {
+		r := *row
+		if row.Values != nil {
+			r.Values = make([][]interface{}, len(row.Values))
+			for j, values := range row.Values {
+				r.Values[j] = append([]interface{}(nil), values...)
+			}
+		}
+		other[i] = &r
+	}
+	return other
+}
+
+// resultCacheKey returns the cache key for a statement. The time range the
+// statement was planned with is included since it may be relative to now().
+func resultCacheKey(stmt string, min, max time.Time, database string, opt QueryOptions) string {
+	return fmt.Sprintf("%s\x00%s\x00%t\x00%d\x00%d\x00%d\x00%d\x00%s", database, opt.RetentionPolicy, opt.Rollup, opt.MaxPointsScanned, opt.MaxRowLimit, min.UnixNano(), max.UnixNano(), stmt)
+}
+
+// SetResultCache sets the number of select statement results the server caches
+// and how long a statement's time range must have ended before it is cached.
+// A size of zero disables the cache.
+func (s *Server) SetResultCache(size int, minAge time.Duration) {
+	if size < 0 {
+		size = 0
+	}
+	s.resultCache.setConfig(size, minAge)
+}
+
+// addResultCacheStat increments a result cache counter if the database exists.
+func (s *Server) addResultCacheStat(database, name string) {
+	if st := s.DatabaseStats(database); st != nil {
+		st.Add(name, 1)
+	}
+}
@@ -0,0 +1,80 @@
+package influxdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Ensure the result cache only caches time ranges that ended long enough ago.
+func TestResultCache_Cacheable(t *testing.T) {
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newResultCache()
+	if c.cacheable(now.Add(-time.Hour), now) {
+		t.Fatal("expected disabled cache to be uncacheable")
+	}
+
+	c.setConfig(10, time.Minute)
+	if !c.cacheable(now.Add(-time.Minute), now) {
+		t.Fatal("expected range ending a minute ago to be cacheable")
+	} else if c.cacheable(now.Add(-time.Second), now) {
+		t.Fatal("expected recent range to be uncacheable")
+	}
+}
+
+// Ensure the result cache removes entries when their shards or database change.
+func TestResultCache_Invalidate(t *testing.T) {
+	c := newResultCache()
+	c.setConfig(10, time.Minute)
+	rows := []*influxql.Row{{Name: "cpu"}}
+	c.add("0", "foo", []uint64{1, 2}, rows, c.generation())
+	c.add("1", "foo", []uint64{2}, rows, c.generation())
+	c.add("2", "bar", []uint64{3}, rows, c.generation())
+
+	// Invalidating a shard only removes entries that read from it.
+	c.invalidateShard(1)
+	if _, ok := c.get("0"); ok {
+		t.Fatal("expected entry 0 to be removed")
+	} else if _, ok := c.get("1"); !ok {
+		t.Fatal("expected entry 1")
+	}
+
+	// Results computed before an invalidation are not added.
+	gen := c.generation()
+	c.invalidateShard(3)
+	c.add("3", "foo", []uint64{4}, rows, gen)
+	if _, ok := c.get("3"); ok {
+		t.Fatal("expected stale entry to be ignored")
+	}
+
+	// Invalidating a database removes all of its entries.
+	c.add("4", "bar", []uint64{5}, rows, c.generation())
+	c.invalidateDatabase("foo")
+	if c.len() != 1 {
+		t.Fatalf("unexpected len: %d", c.len())
+	} else if _, ok := c.get("4"); !ok {
+		t.Fatal("expected entry 4")
+	}
+}
+
+// Ensure the result cache evicts the least recently used entry once it is full.
+func TestResultCache_Evict(t *testing.T) {
+	c := newResultCache()
+	c.setConfig(2, time.Minute)
+	c.add("0", "foo", []uint64{1}, nil, c.generation())
+	c.add("1", "foo", []uint64{1}, nil, c.generation())
+	c.get("0")
+	c.add("2", "foo", []uint64{1}, nil, c.generation())
+	if _, ok := c.get("1"); ok {
+		t.Fatal("expected entry 1 to be evicted")
+	} else if c.len() != 2 || len(c.shards[1]) != 2 {
+		t.Fatalf("unexpected len: %d/%d", c.len(), len(c.shards[1]))
+	}
+
+	// Disabling the cache removes every entry.
+	c.setConfig(0, time.Minute)
+	if c.len() != 0 || len(c.shards) != 0 {
+		t.Fatalf("unexpected len after disabling: %d", c.len())
+	}
+}
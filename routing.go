@@ -0,0 +1,215 @@
+package influxdb
+
+import (
+	"path"
+	"sort"
+
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// RoutingRule directs points written to a database to another database or
+// retention policy. A point matches if its measurement matches the
+// Measurement glob pattern, if set, and it has every tag in Tags. Rules are
+// checked in order of their names and the first match is used.
+type RoutingRule struct {
+	Name            string            `json:"name"`
+	Measurement     string            `json:"measurement,omitempty"`
+	Tags            map[string]string `json:"tags,omitempty"`
+	Database        string            `json:"database,omitempty"`
+	RetentionPolicy string            `json:"retentionPolicy,omitempty"`
+}
+
+// validate returns an error if the rule is missing a name, matches every
+// point, has an invalid pattern or does not change where points are written.
+func (r *RoutingRule) validate() error {
+	if r.Name == "" {
+		return ErrRoutingRuleNameRequired
+	} else if r.Measurement == "" && len(r.Tags) == 0 {
+		return ErrInvalidRoutingRule
+	} else if _, err := path.Match(r.Measurement, ""); err != nil {
+		return ErrInvalidRoutingRule
+	} else if r.Database == "" && r.RetentionPolicy == "" {
+		return ErrInvalidRoutingRule
+	}
+	return nil
+}
+
+// matches returns true if the point is routed by the rule.
+func (r *RoutingRule) matches(p *Point) bool {
+	if r.Measurement != "" {
+		if ok, _ := path.Match(r.Measurement, p.Name); !ok {
+			return false
+		}
+	}
+	for k, v := range r.Tags {
+		if p.Tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// pointRoute represents the points of a write that go to the same database
+// and retention policy, with the index of each point in the write.
+type pointRoute struct {
+	database        string
+	retentionPolicy string
+	points          []*Point
+	indexes         []int
+}
+
+// routePoints groups points by the database and retention policy they are
+// routed to. Points that don't match a rule stay in the database and
+// retention policy they were written to. Routes are not followed again from
+// the database a point is routed to.
+func (s *Server) routePoints(database, retentionPolicy string, points []*Point) []*pointRoute {
+	s.mu.RLock()
+	var rules routingRules
+	if db := s.databases[database]; db != nil {
+		for _, r := range db.routingRules {
+			rules = append(rules, r)
+		}
+	}
+	s.mu.RUnlock()
+
+	// Only copy the points if at least one is routed elsewhere.
+	if len(rules) == 0 {
+		return []*pointRoute{{database: database, retentionPolicy: retentionPolicy, points: points}}
+	}
+	sort.Sort(rules)
+
+	var routes []*pointRoute
+	for i, p := range points {
+		db, rp := database, retentionPolicy
+		for _, r := range rules {
+			if r.matches(p) {
+				if r.Database != "" {
+					db, rp = r.Database, ""
+				}
+				if r.RetentionPolicy != "" {
+					rp = r.RetentionPolicy
+				}
+				break
+			}
+		}
+
+		// Add the point to the route of its database and retention policy.
+		var route *pointRoute
+		for _, other := range routes {
+			if other.database == db && other.retentionPolicy == rp {
+				route = other
+				break
+			}
+		}
+		if route == nil {
+			route = &pointRoute{database: db, retentionPolicy: rp}
+			routes = append(routes, route)
+		}
+		route.points = append(route.points, p)
+		route.indexes = append(route.indexes, i)
+	}
+	return routes
+}
+
+// RoutingRules returns the routing rules of a database, sorted by name.
+func (s *Server) RoutingRules(database string) ([]*RoutingRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+
+	a := make(routingRules, 0, len(db.routingRules))
+	for _, r := range db.routingRules {
+		a = append(a, r)
+	}
+	sort.Sort(a)
+	return a, nil
+}
+
+// SetRoutingRule adds a routing rule to a database, replacing any existing
+// rule with the same name.
+func (s *Server) SetRoutingRule(database string, r *RoutingRule) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+	c := &setRoutingRuleCommand{Database: database, Rule: r}
+	_, err := s.broadcast(setRoutingRuleMessageType, c)
+	return err
+}
+
+func (s *Server) applySetRoutingRule(m *messaging.Message) (err error) {
+	var c setRoutingRuleCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// Replace the rule.
+	db.routingRules[c.Rule.Name] = c.Rule
+
+	// Persist to metastore.
+	err = s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+
+	return
+}
+
+type setRoutingRuleCommand struct {
+	Database string       `json:"database"`
+	Rule     *RoutingRule `json:"rule"`
+}
+
+// DeleteRoutingRule removes a routing rule from a database.
+func (s *Server) DeleteRoutingRule(database, name string) error {
+	c := &deleteRoutingRuleCommand{Database: database, Name: name}
+	_, err := s.broadcast(deleteRoutingRuleMessageType, c)
+	return err
+}
+
+func (s *Server) applyDeleteRoutingRule(m *messaging.Message) (err error) {
+	var c deleteRoutingRuleCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	} else if db.routingRules[c.Name] == nil {
+		return ErrRoutingRuleNotFound
+	}
+
+	// Remove the rule.
+	delete(db.routingRules, c.Name)
+
+	// Persist to metastore.
+	err = s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+
+	return
+}
+
+type deleteRoutingRuleCommand struct {
+	Database string `json:"database"`
+	Name     string `json:"name"`
+}
+
+// routingRules represents a list of rules sortable by name.
+type routingRules []*RoutingRule
+
+func (a routingRules) Len() int           { return len(a) }
+func (a routingRules) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (a routingRules) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
@@ -0,0 +1,121 @@
+package influxdb_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb"
+)
+
+// Ensure routing rules can be set, listed and deleted, and are persisted.
+func TestServer_RoutingRules(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	rule := &influxdb.RoutingRule{Name: "logs", Measurement: "logs_*", Database: "logs"}
+	if err := s.SetRoutingRule("foo", rule); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetRoutingRule("foo", &influxdb.RoutingRule{Name: "dev", Tags: map[string]string{"env": "dev"}, RetentionPolicy: "short"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+
+	if rules, err := s.RoutingRules("foo"); err != nil {
+		t.Fatal(err)
+	} else if len(rules) != 2 || rules[0].Name != "dev" || !reflect.DeepEqual(rules[1], rule) {
+		t.Fatalf("unexpected rules: %s", mustMarshalJSON(rules))
+	}
+
+	if err := s.DeleteRoutingRule("foo", "dev"); err != nil {
+		t.Fatal(err)
+	} else if err := s.DeleteRoutingRule("foo", "dev"); err != influxdb.ErrRoutingRuleNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	} else if rules, _ := s.RoutingRules("foo"); len(rules) != 1 {
+		t.Fatalf("unexpected rules: %s", mustMarshalJSON(rules))
+	}
+}
+
+// Ensure invalid routing rules are rejected.
+func TestServer_SetRoutingRule_Invalid(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	for i, tt := range []struct {
+		rule *influxdb.RoutingRule
+		err  error
+	}{
+		{rule: &influxdb.RoutingRule{Measurement: "logs_*", Database: "logs"}, err: influxdb.ErrRoutingRuleNameRequired},
+		{rule: &influxdb.RoutingRule{Name: "r", Database: "logs"}, err: influxdb.ErrInvalidRoutingRule},
+		{rule: &influxdb.RoutingRule{Name: "r", Measurement: "logs_[", Database: "logs"}, err: influxdb.ErrInvalidRoutingRule},
+		{rule: &influxdb.RoutingRule{Name: "r", Measurement: "logs_*"}, err: influxdb.ErrInvalidRoutingRule},
+	} {
+		if err := s.SetRoutingRule("foo", tt.rule); err != tt.err {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		}
+	}
+}
+
+// Ensure points are written to the database and retention policy of the first matching rule.
+func TestServer_WritePoints_Routed(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: time.Hour})
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "short", Duration: time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateDatabase("logs")
+	s.CreateRetentionPolicy("logs", &influxdb.RetentionPolicy{Name: "week", Duration: time.Hour})
+	s.SetDefaultRetentionPolicy("logs", "week")
+	s.SetRoutingRule("foo", &influxdb.RoutingRule{Name: "a", Measurement: "logs_*", Database: "logs"})
+	s.SetRoutingRule("foo", &influxdb.RoutingRule{Name: "b", Tags: map[string]string{"env": "dev"}, RetentionPolicy: "short"})
+
+	now := mustParseTime("2000-01-01T00:00:00Z")
+	if err := s.WritePoints("foo", "raw", []*influxdb.Point{
+		{Name: "cpu", Timestamp: now, Values: map[string]interface{}{"value": 1.0}},
+		{Name: "logs_nginx", Tags: map[string]string{"env": "dev"}, Timestamp: now, Values: map[string]interface{}{"value": 2.0}},
+		{Name: "cpu", Tags: map[string]string{"env": "dev"}, Timestamp: now, Values: map[string]interface{}{"value": 3.0}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteSeries("foo", "raw", "logs_app", nil, now, map[string]interface{}{"value": 4.0}); err != nil {
+		t.Fatal(err)
+	}
+	s.Sync(c.index)
+
+	for i, tt := range []struct {
+		database        string
+		retentionPolicy string
+		query           string
+		values          string
+	}{
+		{database: "foo", retentionPolicy: "raw", query: `SELECT value FROM cpu`, values: `[[946684800000000,1]]`},
+		{database: "foo", retentionPolicy: "short", query: `SELECT value FROM cpu`, values: `[[946684800000000,3]]`},
+		{database: "logs", query: `SELECT value FROM logs_nginx`, values: `[[946684800000000,2]]`},
+		{database: "logs", query: `SELECT value FROM logs_app`, values: `[[946684800000000,4]]`},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.query), tt.database, nil, influxdb.QueryOptions{RetentionPolicy: tt.retentionPolicy})
+		if results[0].Err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, results[0].Err)
+		} else if len(results[0].Rows) != 1 {
+			t.Fatalf("%d. unexpected rows: %s", i, mustMarshalJSON(results[0].Rows))
+		} else if values := mustMarshalJSON(results[0].Rows[0].Values); values != tt.values {
+			t.Errorf("%d. unexpected values: %s", i, values)
+		}
+	}
+
+	// Points are validated against the database they are routed to and
+	// errors refer to their index in the write.
+	strict := true
+	s.UpdateDatabase("logs", &influxdb.DatabaseUpdate{StrictSchema: &strict})
+	err := s.WritePoints("foo", "raw", []*influxdb.Point{
+		{Name: "cpu", Timestamp: now, Values: map[string]interface{}{"value": 1.0}},
+		{Name: "logs_nginx", Timestamp: now, Values: map[string]interface{}{"value": 2.0}},
+	})
+	if err == nil || err.Error() != `point 1: measurement not declared` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
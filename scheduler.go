@@ -0,0 +1,256 @@
+package influxdb
+
+import (
+	"container/heap"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultJobConcurrency is the default number of background jobs run at once.
+	DefaultJobConcurrency = 2
+
+	// DefaultMaxQueuedJobs is the default number of background jobs waiting to run.
+	DefaultMaxQueuedJobs = 100
+)
+
+// Background job priorities. Waiting jobs with a higher priority start first.
+const (
+	jobPriorityLow    = iota // scrubbing and compaction
+	jobPriorityNormal        // purging deletions
+	jobPriorityHigh          // downsampling, which readers wait on
+)
+
+// errJobScheduled is returned when a job with the same name is already
+// running or waiting to run.
+var errJobScheduled = errors.New("job already scheduled")
+
+// JobInfo represents a background job that is running or waiting to run.
+type JobInfo struct {
+	Name      string    `json:"name"`
+	Priority  string    `json:"priority"`
+	State     string    `json:"state"`
+	QueuedAt  time.Time `json:"queuedAt"`
+	StartedAt time.Time `json:"startedAt,omitempty"`
+}
+
+// scheduler runs the server's background jobs, such as compactions and
+// scrubs, within budgets shared by all of them so that maintenance can't
+// starve queries and writes. Only concurrency jobs run at once and the rest
+// wait in order of priority. Jobs that read or write shards wait on the
+// scheduler's throttle to stay within the IO budget.
+//
+// Jobs run on the goroutine that submits them. The queue is bounded and a job
+// isn't queued again while a job with the same name is waiting or running.
+type scheduler struct {
+	mu          sync.Mutex
+	concurrency int             // jobs run at once
+	maxQueued   int             // jobs waiting to run
+	seq         uint64          // order that jobs were queued in
+	running     map[string]*job // running jobs by name
+	queued      map[string]*job // waiting jobs by name
+	queue       jobQueue        // waiting jobs by priority
+
+	throttle *throttle // bytes per second read or written by all jobs
+}
+
+// newScheduler returns a scheduler with the default budgets.
+func newScheduler() *scheduler {
+	return &scheduler{
+		concurrency: DefaultJobConcurrency,
+		maxQueued:   DefaultMaxQueuedJobs,
+		running:     make(map[string]*job),
+		queued:      make(map[string]*job),
+		throttle:    newThrottle(0),
+	}
+}
+
+// job represents a background job that is running or waiting to run.
+type job struct {
+	name      string
+	priority  int
+	seq       uint64
+	queuedAt  time.Time
+	startedAt time.Time
+	ready     chan struct{} // closed once the job may start
+	index     int           // position in the queue
+}
+
+// setLimits sets the budgets shared by jobs and starts waiting jobs that fit
+// within a higher concurrency.
+func (s *scheduler) setLimits(concurrency, maxQueued int, maxThroughput int64) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.concurrency, s.maxQueued = concurrency, maxQueued
+	s.throttle.setRate(maxThroughput)
+	s.startQueued()
+}
+
+// run runs fn as a job once a slot is free and returns its error. Returns
+// ErrJobQueueFull if too many jobs are waiting, errJobScheduled if a job with
+// the same name is waiting or running and ErrServerClosed if closing is
+// closed before the job starts.
+func (s *scheduler) run(name string, priority int, closing <-chan struct{}, fn func() error) error {
+	s.mu.Lock()
+	if s.running[name] != nil || s.queued[name] != nil {
+		s.mu.Unlock()
+		return errJobScheduled
+	}
+
+	s.seq++
+	j := &job{name: name, priority: priority, seq: s.seq, queuedAt: time.Now(), ready: make(chan struct{})}
+	if len(s.running) < s.concurrency && s.queue.Len() == 0 {
+		s.start(j)
+	} else if s.queue.Len() >= s.maxQueued {
+		s.mu.Unlock()
+		return ErrJobQueueFull
+	} else {
+		s.queued[name] = j
+		heap.Push(&s.queue, j)
+	}
+	s.mu.Unlock()
+
+	// Wait for a slot, unless the server closes first.
+	select {
+	case <-j.ready:
+	case <-closing:
+		s.mu.Lock()
+		if s.queued[name] == j {
+			heap.Remove(&s.queue, j.index)
+			delete(s.queued, name)
+			s.mu.Unlock()
+			return ErrServerClosed
+		}
+		s.mu.Unlock()
+		s.finish(j)
+		return ErrServerClosed
+	}
+	defer s.finish(j)
+
+	return fn()
+}
+
+// start marks a job as running. Must be called with mu held.
+func (s *scheduler) start(j *job) {
+	j.startedAt = time.Now()
+	s.running[j.name] = j
+	close(j.ready)
+}
+
+// startQueued starts waiting jobs while slots are free. Must be called with
+// mu held.
+func (s *scheduler) startQueued() {
+	for len(s.running) < s.concurrency && s.queue.Len() > 0 {
+		j := heap.Pop(&s.queue).(*job)
+		delete(s.queued, j.name)
+		s.start(j)
+	}
+}
+
+// finish frees a job's slot and starts the next waiting job.
+func (s *scheduler) finish(j *job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, j.name)
+	s.startQueued()
+}
+
+// jobs returns the running jobs followed by the waiting jobs in the order
+// that they'll start.
+func (s *scheduler) jobs() []*JobInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	running := make(jobsByStart, 0, len(s.running))
+	for _, j := range s.running {
+		running = append(running, j)
+	}
+	sort.Sort(running)
+	queued := append(jobsByStart{}, s.queue...)
+	sort.Sort(queued)
+
+	a := make([]*JobInfo, 0, len(running)+len(queued))
+	for _, j := range running {
+		a = append(a, &JobInfo{Name: j.name, Priority: jobPriorityName(j.priority), State: "running", QueuedAt: j.queuedAt, StartedAt: j.startedAt})
+	}
+	for _, j := range queued {
+		a = append(a, &JobInfo{Name: j.name, Priority: jobPriorityName(j.priority), State: "queued", QueuedAt: j.queuedAt})
+	}
+	return a
+}
+
+// jobPriorityName returns the name of a job priority.
+func jobPriorityName(priority int) string {
+	switch priority {
+	case jobPriorityHigh:
+		return "high"
+	case jobPriorityNormal:
+		return "normal"
+	default:
+		return "low"
+	}
+}
+
+// jobsByStart represents a list of jobs in the order that they start: by
+// priority and then by the order that they were queued in.
+type jobsByStart []*job
+
+func (a jobsByStart) Len() int      { return len(a) }
+func (a jobsByStart) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a jobsByStart) Less(i, j int) bool {
+	if a[i].priority != a[j].priority {
+		return a[i].priority > a[j].priority
+	}
+	return a[i].seq < a[j].seq
+}
+
+// jobQueue is a heap of waiting jobs in the order that they start.
+type jobQueue []*job
+
+func (q jobQueue) Len() int           { return len(q) }
+func (q jobQueue) Less(i, j int) bool { return jobsByStart(q).Less(i, j) }
+func (q jobQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+
+func (q *jobQueue) Push(x interface{}) {
+	j := x.(*job)
+	j.index = len(*q)
+	*q = append(*q, j)
+}
+
+func (q *jobQueue) Pop() interface{} {
+	old := *q
+	j := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return j
+}
+
+// runJob runs fn as a background job and logs its error. The job is skipped
+// if a job with the same name is already scheduled.
+func (s *Server) runJob(name string, priority int, closing <-chan struct{}, fn func() error) {
+	if err := s.jobs.run(name, priority, closing, fn); err == errJobScheduled || err == ErrServerClosed {
+		return
+	} else if err != nil {
+		s.Logger.Printf("%s: %s", name, err)
+	}
+}
+
+// SetJobLimits sets the budgets shared by background jobs: the number run at
+// once, the number waiting to run and the bytes per second they read and
+// write. A throughput of zero is unlimited.
+func (s *Server) SetJobLimits(concurrency, maxQueued int, maxThroughput int64) {
+	s.jobs.setLimits(concurrency, maxQueued, maxThroughput)
+}
+
+// Jobs returns the background jobs that are running or waiting to run.
+func (s *Server) Jobs() []*JobInfo {
+	return s.jobs.jobs()
+}
@@ -0,0 +1,119 @@
+package influxdb
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Ensure the scheduler runs jobs within its concurrency, starts waiting jobs
+// by priority and rejects jobs over its queue limit.
+func TestScheduler(t *testing.T) {
+	s := newScheduler()
+	s.setLimits(1, 3, 0)
+	closing := make(chan struct{})
+
+	// Hold the only slot with a running job.
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go s.run("a", jobPriorityLow, closing, func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+
+	// Queue jobs behind it and record the order that they run in.
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	for i, j := range []struct {
+		name     string
+		priority int
+	}{{"b", jobPriorityLow}, {"c", jobPriorityHigh}, {"d", jobPriorityNormal}} {
+		j := j
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.run(j.name, j.priority, closing, func() error {
+				mu.Lock()
+				order = append(order, j.name)
+				mu.Unlock()
+				return nil
+			}); err != nil {
+				t.Error(err)
+			}
+		}()
+		waitForJobs(t, s, i+2)
+	}
+
+	if err := s.run("a", jobPriorityLow, closing, nil); err != errJobScheduled {
+		t.Fatalf("unexpected error for duplicate job: %v", err)
+	} else if err := s.run("e", jobPriorityLow, closing, nil); err != ErrJobQueueFull {
+		t.Fatalf("unexpected error for full queue: %v", err)
+	}
+
+	var names, states []string
+	for _, j := range s.jobs() {
+		names, states = append(names, j.Name), append(states, j.State)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "c", "d", "b"}) {
+		t.Fatalf("unexpected jobs: %v", names)
+	} else if !reflect.DeepEqual(states, []string{"running", "queued", "queued", "queued"}) {
+		t.Fatalf("unexpected states: %v", states)
+	}
+
+	// Release the running job and verify the others run by priority.
+	close(release)
+	wg.Wait()
+	if !reflect.DeepEqual(order, []string{"c", "d", "b"}) {
+		t.Fatalf("unexpected order: %v", order)
+	} else if a := s.jobs(); len(a) != 0 {
+		t.Fatalf("unexpected jobs after run: %d", len(a))
+	}
+}
+
+// Ensure waiting jobs are dropped when the server closes.
+func TestScheduler_Closing(t *testing.T) {
+	s := newScheduler()
+	s.setLimits(1, 10, 0)
+	closing := make(chan struct{})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go s.run("a", jobPriorityLow, closing, func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	defer close(release)
+
+	errs := make(chan error)
+	go func() {
+		errs <- s.run("b", jobPriorityLow, closing, func() error {
+			t.Error("job run after close")
+			return nil
+		})
+	}()
+	waitForJobs(t, s, 2)
+
+	close(closing)
+	if err := <-errs; err != ErrServerClosed {
+		t.Fatalf("unexpected error: %v", err)
+	} else if a := s.jobs(); len(a) != 1 || a[0].Name != "a" {
+		t.Fatalf("unexpected jobs: %v", a)
+	}
+}
+
+// waitForJobs waits until n jobs are running or waiting to run.
+func waitForJobs(t *testing.T, s *scheduler, n int) {
+	for i := 0; i < 100; i++ {
+		if len(s.jobs()) == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d jobs", n)
+}
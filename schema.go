@@ -0,0 +1,262 @@
+package influxdb
+
+import (
+	"sort"
+
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// Duplicate policies determine how a point is stored when its series already
+// has a point at the same timestamp.
+const (
+	DuplicateLast   = "last"   // replace the existing point, the default
+	DuplicateSum    = "sum"    // add numeric fields to the existing values
+	DuplicateMin    = "min"    // keep the lower of each numeric field
+	DuplicateMax    = "max"    // keep the higher of each numeric field
+	DuplicateReject = "reject" // keep the existing point and drop the new one
+)
+
+// MeasurementSchema declares the fields and tag keys that a measurement
+// accepts. Schemas are only enforced in databases with strict schemas enabled.
+//
+// Duplicates sets the policy for points written at the timestamp of an
+// existing point and applies whether or not schemas are enforced.
+type MeasurementSchema struct {
+	Name       string         `json:"name"`
+	Fields     []*FieldSchema `json:"fields,omitempty"`
+	Tags       []string       `json:"tags,omitempty"`
+	Duplicates string         `json:"duplicates,omitempty"`
+}
+
+// FieldSchema declares the name and data type of a field.
+type FieldSchema struct {
+	Name string            `json:"name"`
+	Type influxql.DataType `json:"type"`
+}
+
+// field returns the declared field by name. Returns nil if not declared.
+func (ms *MeasurementSchema) field(name string) *FieldSchema {
+	for _, f := range ms.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// hasTag returns true if the tag key is declared.
+func (ms *MeasurementSchema) hasTag(key string) bool {
+	for _, k := range ms.Tags {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// validate returns an error if the schema is missing a name, declares a
+// field with a type that cannot be stored or has an unknown duplicate policy.
+func (ms *MeasurementSchema) validate() error {
+	if ms.Name == "" {
+		return ErrMeasurementNameRequired
+	}
+	switch ms.Duplicates {
+	case "", DuplicateLast, DuplicateSum, DuplicateMin, DuplicateMax, DuplicateReject:
+	default:
+		return ErrInvalidDuplicatePolicy
+	}
+	for _, f := range ms.Fields {
+		if f.Name == "" {
+			return ErrFieldNameRequired
+		}
+		switch f.Type {
+		case influxql.Number, influxql.Integer, influxql.Unsigned, influxql.Boolean, influxql.String, influxql.Histogram:
+		default:
+			return ErrInvalidFieldType
+		}
+	}
+	return nil
+}
+
+// checkSchema returns a *PointError if the database enforces schemas and the
+// point writes to an undeclared measurement, tag key or field, or writes a
+// field with a different type than it was declared with.
+func (db *database) checkSchema(p *Point) error {
+	if !db.strictSchema {
+		return nil
+	}
+
+	ms := db.schemas[p.Name]
+	if ms == nil {
+		return &PointError{Err: ErrMeasurementNotDeclared}
+	}
+	for k := range p.Tags {
+		if !ms.hasTag(k) {
+			return &PointError{Key: k, Err: ErrTagNotDeclared}
+		}
+	}
+
+	// Check fields by the types they are stored as.
+	data, err := appendValues(nil, p.Values)
+	if err != nil {
+		return &PointError{Err: err}
+	}
+	return valueTypes(data, func(key string, typ influxql.DataType) error {
+		if f := ms.field(key); f == nil {
+			return &PointError{Key: key, Err: ErrFieldNotDeclared}
+		} else if f.Type != typ {
+			return &PointError{Key: key, Err: ErrFieldTypeConflict}
+		}
+		return nil
+	})
+}
+
+// duplicatePolicies returns the duplicate policy of each series written by
+// the encoded points. Series of measurements that replace duplicates are omitted.
+func (db *database) duplicatePolicies(points [][]byte) (map[uint32]string, error) {
+	var m map[uint32]string
+	for _, data := range points {
+		id, _, err := unmarshalPointHeader(data)
+		if err != nil {
+			return nil, err
+		}
+		s := db.index().series[id]
+		if s == nil {
+			continue
+		}
+		if ms := db.schemas[s.measurement.Name]; ms != nil && ms.Duplicates != "" && ms.Duplicates != DuplicateLast {
+			if m == nil {
+				m = make(map[uint32]string)
+			}
+			m[id] = ms.Duplicates
+		}
+	}
+	return m, nil
+}
+
+// checkSchemas checks each point against the schemas of the database.
+// Returns PointErrors for every point that does not match its schema.
+func (s *Server) checkSchemas(database string, points []*Point) PointErrors {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil || !db.strictSchema {
+		return nil
+	}
+
+	var errs PointErrors
+	for i, p := range points {
+		if err := db.checkSchema(p); err != nil {
+			e := err.(*PointError)
+			e.Index = i
+			errs = append(errs, e)
+		}
+	}
+	return errs
+}
+
+// MeasurementSchemas returns the declared measurement schemas for a database, sorted by name.
+func (s *Server) MeasurementSchemas(database string) ([]*MeasurementSchema, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+
+	a := make(measurementSchemas, 0, len(db.schemas))
+	for _, ms := range db.schemas {
+		a = append(a, ms)
+	}
+	sort.Sort(a)
+	return a, nil
+}
+
+// SetMeasurementSchema declares the schema of a measurement in a database,
+// replacing any existing declaration.
+func (s *Server) SetMeasurementSchema(database string, ms *MeasurementSchema) error {
+	if err := ms.validate(); err != nil {
+		return err
+	}
+	c := &setMeasurementSchemaCommand{Database: database, Schema: ms}
+	_, err := s.broadcast(setMeasurementSchemaMessageType, c)
+	return err
+}
+
+func (s *Server) applySetMeasurementSchema(m *messaging.Message) (err error) {
+	var c setMeasurementSchemaCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// Replace the schema.
+	db.schemas[c.Schema.Name] = c.Schema
+
+	// Persist to metastore.
+	err = s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+
+	return
+}
+
+type setMeasurementSchemaCommand struct {
+	Database string             `json:"database"`
+	Schema   *MeasurementSchema `json:"schema"`
+}
+
+// DeleteMeasurementSchema removes the declared schema of a measurement.
+// Writes to the measurement are rejected while the database enforces schemas.
+func (s *Server) DeleteMeasurementSchema(database, name string) error {
+	c := &deleteMeasurementSchemaCommand{Database: database, Name: name}
+	_, err := s.broadcast(deleteMeasurementSchemaMessageType, c)
+	return err
+}
+
+func (s *Server) applyDeleteMeasurementSchema(m *messaging.Message) (err error) {
+	var c deleteMeasurementSchemaCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	} else if db.schemas[c.Name] == nil {
+		return ErrMeasurementSchemaNotFound
+	}
+
+	// Remove the schema.
+	delete(db.schemas, c.Name)
+
+	// Persist to metastore.
+	err = s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+
+	return
+}
+
+type deleteMeasurementSchemaCommand struct {
+	Database string `json:"database"`
+	Name     string `json:"name"`
+}
+
+// measurementSchemas represents a list of schemas sortable by name.
+type measurementSchemas []*MeasurementSchema
+
+func (a measurementSchemas) Len() int           { return len(a) }
+func (a measurementSchemas) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (a measurementSchemas) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
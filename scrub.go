@@ -0,0 +1,452 @@
+package influxdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// ScrubConfig represents the settings used to scrub shards in the background.
+//
+// Each scrub verifies the checksum of every block stored in the server's
+// shards. Corrupt blocks are moved to a quarantine bucket so that queries
+// skip them. Quarantined blocks are repaired from the other data nodes that
+// own the shard, authenticating as Username if it is set.
+type ScrubConfig struct {
+	Interval time.Duration // time between scrubs
+	Username string
+	Password string
+}
+
+// ScrubState represents the result of the last scrub.
+type ScrubState struct {
+	LastScrub   time.Time `json:"lastScrub,omitempty"`
+	Checked     int       `json:"checked"`     // blocks verified by the last scrub
+	Corrupt     int       `json:"corrupt"`     // blocks found corrupt by the last scrub
+	Repaired    int       `json:"repaired"`    // blocks repaired by the last scrub
+	Quarantined int       `json:"quarantined"` // blocks still quarantined
+}
+
+// blockKey identifies a block of values within a shard.
+type blockKey struct {
+	seriesID  uint32
+	timestamp int64
+}
+
+// StartScrubbing starts scrubbing shards in the background until the server
+// is closed.
+func (s *Server) StartScrubbing(c ScrubConfig) error {
+	if c.Interval <= 0 {
+		return ErrInvalidScrubInterval
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing == nil {
+		return ErrServerClosed
+	}
+
+	s.wg.Add(1)
+	go s.scrubber(c, s.closing)
+	return nil
+}
+
+// scrubber scrubs every shard each interval until closing is closed.
+func (s *Server) scrubber(c ScrubConfig, closing <-chan struct{}) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closing:
+			return
+		case <-ticker.C:
+			s.runJob("scrub", jobPriorityLow, closing, func() error {
+				_, err := s.Scrub(c.Username, c.Password)
+				return err
+			})
+		}
+	}
+}
+
+// ScrubState returns the result of the last scrub.
+func (s *Server) ScrubState() ScrubState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.scrubState
+}
+
+// Scrub verifies the checksum of every block in the server's shards and
+// quarantines the corrupt ones. Quarantined blocks are then repaired from
+// the other data nodes that own their shard, authenticating as username if
+// it is set. Blocks are read within the IO budget of background jobs.
+func (s *Server) Scrub(username, password string) (ScrubState, error) {
+	s.scrubMu.Lock()
+	defer s.scrubMu.Unlock()
+
+	st := ScrubState{LastScrub: time.Now().UTC()}
+	for _, sh := range s.scrubShards() {
+		checked, corrupt, err := s.scrubShard(sh)
+		if err != nil {
+			return st, fmt.Errorf("shard %d: %s", sh.ID, err)
+		}
+		st.Checked += checked
+		st.Corrupt += corrupt
+		if corrupt > 0 {
+			s.Logger.Printf("scrub: shard %d: quarantined %d corrupt blocks", sh.ID, corrupt)
+		}
+
+		repaired, quarantined, err := s.repairShard(sh, username, password)
+		if err != nil {
+			return st, fmt.Errorf("shard %d: %s", sh.ID, err)
+		}
+		st.Repaired += repaired
+		st.Quarantined += quarantined
+	}
+
+	s.mu.Lock()
+	s.scrubState = st
+	s.mu.Unlock()
+	return st, nil
+}
+
+// scrubShards returns every open shard.
+func (s *Server) scrubShards() []*Shard {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var a []*Shard
+	for _, db := range s.databases {
+		for _, sh := range db.shards {
+			a = append(a, sh)
+		}
+	}
+	return a
+}
+
+// scrubShard verifies every block in a shard and quarantines the corrupt
+// ones. Returns the number of blocks checked and quarantined.
+func (s *Server) scrubShard(sh *Shard) (checked, corrupt int, err error) {
+	st, err := sh.acquire()
+	if err == ErrShardNotFound {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+	defer sh.release(st)
+
+	var keys []blockKey
+	if err := st.View(func(tx *bolt.Tx) error {
+		dict := newStringDictionary(tx)
+		values := tx.Bucket([]byte("values"))
+		return values.ForEach(func(k, _ []byte) error {
+			seriesID := btou32(k)
+			return values.Bucket(k).ForEach(func(k, v []byte) error {
+				_ = s.jobs.throttle.wait(len(k)+len(v), nil)
+				checked++
+				if _, err := unmarshalStoredValues(v, dict.lookup); err != nil {
+					keys = append(keys, blockKey{seriesID, int64(btou64(k))})
+				}
+				return nil
+			})
+		})
+	}); err != nil {
+		return 0, 0, err
+	}
+	if len(keys) == 0 {
+		return checked, 0, nil
+	}
+
+	corrupt, err = sh.quarantine(keys)
+	return checked, corrupt, err
+}
+
+// quarantine moves corrupt blocks from the shard's values into its quarantine
+// bucket. Blocks that are no longer corrupt are skipped since they may have
+// been rewritten since they were checked. Returns the number of blocks moved.
+func (s *Shard) quarantine(keys []blockKey) (n int, err error) {
+	defer s.cache.touch(s)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.openStore(); err == ErrShardNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	s.writeN++
+	err = s.store.Update(func(tx *bolt.Tx) error {
+		if s.compacted {
+			if err := tx.Bucket([]byte("meta")).Delete([]byte("compacted")); err != nil {
+				return err
+			}
+			s.compacted = false
+		}
+
+		dict := newStringDictionary(tx)
+		for _, key := range keys {
+			b := tx.Bucket([]byte("values")).Bucket(u32tob(key.seriesID))
+			if b == nil {
+				continue
+			}
+			k := u64tob(uint64(key.timestamp))
+			v := b.Get(k)
+			if v == nil {
+				continue
+			} else if _, err := unmarshalStoredValues(v, dict.lookup); err == nil {
+				continue
+			}
+
+			q, err := tx.Bucket([]byte("quarantine")).CreateBucketIfNotExists(u32tob(key.seriesID))
+			if err != nil {
+				return err
+			}
+			if err := q.Put(k, append([]byte(nil), v...)); err != nil {
+				return err
+			}
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return
+}
+
+// quarantined returns the keys of the blocks in the shard's quarantine.
+func (st *shardStore) quarantined() (a []blockKey, err error) {
+	err = st.View(func(tx *bolt.Tx) error {
+		q := tx.Bucket([]byte("quarantine"))
+		return q.ForEach(func(k, _ []byte) error {
+			seriesID := btou32(k)
+			return q.Bucket(k).ForEach(func(k, _ []byte) error {
+				a = append(a, blockKey{seriesID, int64(btou64(k))})
+				return nil
+			})
+		})
+	})
+	return
+}
+
+// restore replaces a quarantined block with values encoded by appendValues.
+// Values written to the block since it was quarantined are kept.
+func (s *Shard) restore(key blockKey, data []byte) error {
+	defer s.cache.touch(s)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.openStore(); err != nil {
+		return err
+	}
+	s.writeN++
+	return s.store.Update(func(tx *bolt.Tx) error {
+		if s.compacted {
+			if err := tx.Bucket([]byte("meta")).Delete([]byte("compacted")); err != nil {
+				return err
+			}
+			s.compacted = false
+		}
+
+		b, err := tx.Bucket([]byte("values")).CreateBucketIfNotExists(u32tob(key.seriesID))
+		if err != nil {
+			return err
+		}
+		k := u64tob(uint64(key.timestamp))
+		if b.Get(k) == nil {
+			dict := newStringDictionary(tx)
+			value, err := internStrings(data, dict.intern)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(k, appendChecksum(nil, value)); err != nil {
+				return err
+			}
+		}
+
+		q := tx.Bucket([]byte("quarantine"))
+		if qb := q.Bucket(u32tob(key.seriesID)); qb != nil {
+			if err := qb.Delete(k); err != nil {
+				return err
+			}
+			if k, _ := qb.Cursor().First(); k == nil {
+				return q.DeleteBucket(u32tob(key.seriesID))
+			}
+		}
+		return nil
+	})
+}
+
+// repairShard restores the quarantined blocks of a shard from the other data
+// nodes that own it. Returns the number of blocks repaired and the number
+// still quarantined.
+func (s *Server) repairShard(sh *Shard, username, password string) (repaired, quarantined int, err error) {
+	st, err := sh.acquire()
+	if err == ErrShardNotFound {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+	keys, err := st.quarantined()
+	sh.release(st)
+	if err != nil || len(keys) == 0 {
+		return 0, 0, err
+	}
+
+	database, nodes := s.shardReplicas(sh)
+	var lastErr error
+	for _, key := range keys {
+		data, err := fetchBlock(nodes, database, sh.ID, key, username, password)
+		if err != nil {
+			lastErr = err
+			quarantined++
+			continue
+		}
+		if err := sh.restore(key, data); err != nil {
+			return repaired, quarantined, err
+		}
+		repaired++
+	}
+	if lastErr != nil {
+		s.Logger.Printf("scrub: shard %d: %d blocks not repaired: %s", sh.ID, quarantined, lastErr)
+	}
+	return repaired, quarantined, nil
+}
+
+// shardReplicas returns the database of a shard and the other data nodes
+// that own it. Shards without owners are held by every data node.
+func (s *Server) shardReplicas(sh *Shard) (database string, a []*DataNode) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if db := s.databasesByShard[sh.ID]; db != nil {
+		database = db.name
+	}
+	for _, n := range s.dataNodes {
+		if n.ID == s.id {
+			continue
+		}
+		owned := len(sh.dataNodeIDs) == 0
+		for _, id := range sh.dataNodeIDs {
+			if id == n.ID {
+				owned = true
+			}
+		}
+		if owned {
+			a = append(a, n)
+		}
+	}
+	sort.Sort(dataNodes(a))
+	return
+}
+
+// scrubClient is used to fetch blocks from other data nodes.
+var scrubClient = &http.Client{Timeout: 30 * time.Second}
+
+// fetchBlock returns a block read from the first data node that has an
+// intact copy of it. The block is returned without its checksum.
+func fetchBlock(nodes []*DataNode, database string, shardID uint64, key blockKey, username, password string) ([]byte, error) {
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no replicas")
+	}
+
+	var err error
+	for _, n := range nodes {
+		var data []byte
+		if data, err = fetchNodeBlock(n, database, shardID, key, username, password); err == nil {
+			return data, nil
+		}
+	}
+	return nil, err
+}
+
+// fetchNodeBlock reads a block from a data node and verifies its checksum.
+func fetchNodeBlock(n *DataNode, database string, shardID uint64, key blockKey, username, password string) ([]byte, error) {
+	u := *n.URL
+	u.Path = fmt.Sprintf("/db/%s/shards/%d/blocks", url.QueryEscape(database), shardID)
+	u.RawQuery = url.Values{
+		"series": {strconv.FormatUint(uint64(key.seriesID), 10)},
+		"time":   {strconv.FormatInt(key.timestamp, 10)},
+	}.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := scrubClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("data node %d: %s", n.ID, resp.Status)
+	}
+
+	data, err := verifyChecksum(body)
+	if err != nil {
+		return nil, fmt.Errorf("data node %d: %s", n.ID, err)
+	} else if _, err := unmarshalValues(data); err != nil {
+		return nil, fmt.Errorf("data node %d: %s", n.ID, err)
+	}
+	return data, nil
+}
+
+// ReadBlock returns the values stored in a shard for a series at a timestamp
+// with their checksum. Strings are included in the block so that it can be
+// restored into another shard. Returns ErrChecksumMismatch if the block is
+// corrupt on this node.
+func (s *Server) ReadBlock(database string, shardID uint64, seriesID uint32, timestamp int64) ([]byte, error) {
+	s.mu.RLock()
+	db := s.databases[database]
+	if db == nil {
+		s.mu.RUnlock()
+		return nil, ErrDatabaseNotFound
+	}
+	sh := db.shards[shardID]
+	if sh == nil {
+		s.mu.RUnlock()
+		return nil, ErrShardNotFound
+	}
+	st, err := sh.acquire()
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	defer sh.release(st)
+
+	var values map[string]interface{}
+	if err := st.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("values")).Bucket(u32tob(seriesID))
+		if b == nil {
+			return ErrBlockNotFound
+		}
+		v := b.Get(u64tob(uint64(timestamp)))
+		if v == nil {
+			return ErrBlockNotFound
+		}
+		var err error
+		values, err = unmarshalStoredValues(v, newStringDictionary(tx).lookup)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	data, err := appendValues(nil, values)
+	if err != nil {
+		return nil, err
+	}
+	return appendChecksum(nil, data), nil
+}
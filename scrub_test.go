@@ -0,0 +1,132 @@
+package influxdb_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/influxdb/influxdb"
+)
+
+// Ensure corrupt blocks are quarantined by a scrub and repaired from a replica.
+func TestServer_Scrub(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	mustWriteScrubPoints(s)
+	s.Sync(c.index)
+
+	// A replica holding the same data.
+	rc := NewMessagingClient()
+	replica := OpenServer(rc)
+	defer replica.Close()
+	mustWriteScrubPoints(replica)
+	replica.Sync(rc.index)
+
+	// Corrupt a block while the server is stopped.
+	shards, _ := s.Shards("foo")
+	id := shards[0].ID
+	path := s.Path()
+	s.Server.Close()
+	db, err := bolt.Open(filepath.Join(path, "shards", strconv.FormatUint(id, 10)), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("values")).Bucket([]byte{0, 0, 0, 1})
+		k, v := b.Cursor().First()
+		v = append([]byte(nil), v...)
+		v[len(v)-1] ^= 1
+		return b.Put(k, v)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if err := s.Server.Open(path); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(c); err != nil {
+		t.Fatal(err)
+	}
+
+	// Queries fail until the block is quarantined.
+	q := MustParseQuery(`SELECT count(value) FROM cpu`)
+	if results := s.ExecuteQuery(q, "foo", nil, influxdb.QueryOptions{}); results[0].Err != influxdb.ErrChecksumMismatch {
+		t.Fatalf("unexpected error: %v", results[0].Err)
+	}
+
+	// Scrub without any replicas to repair from.
+	if st, err := s.Scrub("", ""); err != nil {
+		t.Fatal(err)
+	} else if st.Checked != 3 || st.Corrupt != 1 || st.Repaired != 0 || st.Quarantined != 1 {
+		t.Fatalf("unexpected state: %s", mustMarshalJSON(st))
+	}
+	if results := s.ExecuteQuery(q, "foo", nil, influxdb.QueryOptions{}); results[0].Err != nil {
+		t.Fatal(results[0].Err)
+	} else if v := results[0].Rows[0].Values[0][1]; fmt.Sprint(v) != "2" {
+		t.Fatalf("unexpected count: %v", v)
+	}
+
+	// The quarantined block is reported by the health endpoint.
+	h := NewHTTPServer(s)
+	defer h.Close()
+	status, body := MustHTTP("GET", h.URL+`/health`, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if st := s.ScrubState(); body != fmt.Sprintf(`{"status":"degraded","writes":{"pendingPoints":0,"unappliedWrites":0},"scrub":{"lastScrub":"%s","checked":3,"corrupt":1,"repaired":0,"quarantined":1}}`, st.LastScrub.Format(time.RFC3339Nano)) {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Repair the block from the replica.
+	rh := NewHTTPServer(replica)
+	defer rh.Close()
+	u, _ := url.Parse(rh.URL)
+	if err := s.CreateDataNode(u); err != nil {
+		t.Fatal(err)
+	}
+	s.Sync(c.index)
+	if st, err := s.Scrub("", ""); err != nil {
+		t.Fatal(err)
+	} else if st.Checked != 2 || st.Corrupt != 0 || st.Repaired != 1 || st.Quarantined != 0 {
+		t.Fatalf("unexpected state: %s", mustMarshalJSON(st))
+	}
+	if results := s.ExecuteQuery(q, "foo", nil, influxdb.QueryOptions{}); results[0].Err != nil {
+		t.Fatal(results[0].Err)
+	} else if v := results[0].Rows[0].Values[0][1]; fmt.Sprint(v) != "3" {
+		t.Fatalf("unexpected count: %v", v)
+	}
+
+	// The repaired block verifies on the next scrub.
+	if st, err := s.Scrub("", ""); err != nil {
+		t.Fatal(err)
+	} else if st.Checked != 3 || st.Corrupt != 0 || st.Quarantined != 0 {
+		t.Fatalf("unexpected state: %s", mustMarshalJSON(st))
+	}
+}
+
+// Ensure scrubbing requires a positive interval.
+func TestServer_StartScrubbing_InvalidInterval(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	if err := s.StartScrubbing(influxdb.ScrubConfig{}); err != influxdb.ErrInvalidScrubInterval {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// mustWriteScrubPoints creates a database and writes three points to a single shard.
+func mustWriteScrubPoints(s *Server) {
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "myspace")
+	if err := s.WritePoints("foo", "myspace", []*influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": 1.0, "status": "ok"}},
+		{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": 2.0}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverb"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": 3.0}},
+	}); err != nil {
+		panic(err)
+	}
+}
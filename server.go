@@ -1,6 +1,7 @@
 package influxdb
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -8,12 +9,15 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"code.google.com/p/go.crypto/bcrypt"
+	"github.com/influxdb/influxdb/influxql"
 	"github.com/influxdb/influxdb/messaging"
 )
 
@@ -40,32 +44,60 @@ const (
 
 const (
 	// Data node messages
-	createDataNodeMessageType = messaging.MessageType(0x00)
-	deleteDataNodeMessageType = messaging.MessageType(0x01)
+	createDataNodeMessageType  = messaging.MessageType(0x00)
+	deleteDataNodeMessageType  = messaging.MessageType(0x01)
+	setDataNodeRoleMessageType = messaging.MessageType(0x02)
 
 	// Database messages
 	createDatabaseMessageType = messaging.MessageType(0x10)
 	deleteDatabaseMessageType = messaging.MessageType(0x11)
+	renameDatabaseMessageType = messaging.MessageType(0x12)
+	updateDatabaseMessageType = messaging.MessageType(0x13)
+
+	// Measurement schema messages
+	setMeasurementSchemaMessageType    = messaging.MessageType(0x14)
+	deleteMeasurementSchemaMessageType = messaging.MessageType(0x15)
+
+	// Tag guard messages
+	setTagGuardMessageType    = messaging.MessageType(0x16)
+	deleteTagGuardMessageType = messaging.MessageType(0x17)
+
+	// Alert rule messages
+	setAlertRuleMessageType    = messaging.MessageType(0x18)
+	deleteAlertRuleMessageType = messaging.MessageType(0x19)
+
+	// Routing rule messages
+	setRoutingRuleMessageType    = messaging.MessageType(0x1A)
+	deleteRoutingRuleMessageType = messaging.MessageType(0x1B)
 
 	// Retention policy messages
 	createRetentionPolicyMessageType     = messaging.MessageType(0x20)
 	updateRetentionPolicyMessageType     = messaging.MessageType(0x21)
 	deleteRetentionPolicyMessageType     = messaging.MessageType(0x22)
 	setDefaultRetentionPolicyMessageType = messaging.MessageType(0x23)
+	updateDownsampleMessageType          = messaging.MessageType(0x24)
 
 	// User messages
 	createUserMessageType = messaging.MessageType(0x30)
 	updateUserMessageType = messaging.MessageType(0x31)
 	deleteUserMessageType = messaging.MessageType(0x32)
 
+	// Token messages
+	createTokenMessageType = messaging.MessageType(0x33)
+	deleteTokenMessageType = messaging.MessageType(0x34)
+
 	// Shard messages
 	createShardIfNotExistsMessageType = messaging.MessageType(0x40)
+	deleteShardMessageType            = messaging.MessageType(0x41)
+	deletePointsMessageType           = messaging.MessageType(0x42)
+	purgeDeletionMessageType          = messaging.MessageType(0x43)
 
 	// Series messages
 	createSeriesIfNotExistsMessageType = messaging.MessageType(0x50)
 
 	// Write raw data messages (per-topic)
-	writeSeriesMessageType = messaging.MessageType(0x80)
+	writeSeriesMessageType      = messaging.MessageType(0x80)
+	writeSeriesBatchMessageType = messaging.MessageType(0x81)
 )
 
 // Server represents a collection of metadata and raw metric data.
@@ -75,6 +107,14 @@ type Server struct {
 	path string
 	done chan struct{} // goroutine close notification
 
+	closing chan struct{}  // closed when the server is closed
+	wg      sync.WaitGroup // background goroutines
+
+	drainMu         sync.Mutex
+	inflight        int           // running queries and writes
+	drained         chan struct{} // set once the server starts to close, closed when inflight reaches zero
+	shutdownTimeout time.Duration // maximum time Close waits for inflight to reach zero
+
 	client MessagingClient  // broker client
 	index  uint64           // highest broadcast index seen
 	errors map[uint64]error // message errors
@@ -86,18 +126,85 @@ type Server struct {
 	databases        map[string]*database // databases by name
 	databasesByShard map[uint64]*database // databases by shard id
 	users            map[string]*User     // user by name
+
+	statsMu sync.Mutex
+	stats   map[string]*Stats // statistics by database name
+
+	queryCache *queryCache // parsed queries by database and query text
+
+	scrubMu    sync.Mutex // serializes scrubs
+	scrubState ScrubState // result of the last scrub, protected by mu
+
+	backfillMu sync.Mutex
+	backfillID uint64      // id of the last backfill started
+	backfills  []*Backfill // running and recently finished backfills
+
+	resultCache *resultCache // select statement results
+
+	queryConcurrency int         // shards read at once for each series in a query
+	shards           *shardCache // shard stores that are open
+	jobs             *scheduler  // background jobs and their budgets
+
+	queriesMu sync.Mutex
+	queryID   uint64                   // id of the last statement started
+	queries   map[uint64]*runningQuery // running statements by id
+	spillDir  string                   // directory for points that exceed a statement's memory limit
+
+	cursorsMu     sync.Mutex
+	cursors       map[string]*queryCursor // open cursors by token
+	maxCursors    int                     // cursors open at once
+	cursorTimeout time.Duration           // time a cursor stays open between pages
+
+	pointLimits PointLimits   // restrictions on written points
+	precision   TimePrecision // unit written timestamps are truncated to
+	batcher     *pointBatcher // coalesces concurrent writes to a shard
+	writeIDs    *writeIDCache // recent write request ids by database
+
+	writeMu            sync.Mutex
+	pendingPoints      int    // points queued or being published
+	publishedIndex     uint64 // highest index of a published write
+	maxPendingPoints   int    // pending points before writes are rejected
+	maxUnappliedWrites int    // unapplied writes before writes are rejected
+
+	auditMu      sync.Mutex
+	auditFile    *os.File      // append-only log of administrative actions
+	auditPath    string        // path of the audit log
+	auditExport  bool          // hold entries for export to the monitoring database
+	auditPending []*AuditEntry // entries not yet exported
+
+	tailsMu sync.Mutex
+	tails   map[*Tail]struct{} // subscriptions to written points
+
+	// The logging interface used by the server for query logs.
+	Logger *log.Logger
 }
 
 // NewServer returns a new instance of Server.
 func NewServer() *Server {
-	return &Server{
+	s := &Server{
 		meta:             &metastore{},
 		dataNodes:        make(map[uint64]*DataNode),
 		databases:        make(map[string]*database),
 		databasesByShard: make(map[uint64]*database),
 		users:            make(map[string]*User),
 		errors:           make(map[uint64]error),
-	}
+		stats:            make(map[string]*Stats),
+		queryCache:       newQueryCache(DefaultQueryCacheSize),
+		resultCache:      newResultCache(),
+		queryConcurrency: runtime.GOMAXPROCS(0),
+		shards:           newShardCache(0),
+		jobs:             newScheduler(),
+		queries:          make(map[uint64]*runningQuery),
+		cursors:          make(map[string]*queryCursor),
+		maxCursors:       DefaultMaxCursors,
+		cursorTimeout:    DefaultCursorTimeout,
+		tails:            make(map[*Tail]struct{}),
+		writeIDs:         newWriteIDCache(DefaultWriteIDCacheSize, DefaultWriteIDTTL),
+		shutdownTimeout:  DefaultShutdownTimeout,
+		Logger:           log.New(os.Stderr, "[server] ", log.LstdFlags),
+	}
+	s.batcher = newPointBatcher(s.publishPoints)
+	return s
 }
 
 // ID returns the data node id for the server.
@@ -141,18 +248,45 @@ func (s *Server) Open(path string) error {
 		return err
 	}
 
-	// Open metadata store.
+	// Open metadata store. A directory without one is new and has nothing
+	// to recover.
+	_, err := os.Stat(filepath.Join(path, "meta"))
+	existed := err == nil
 	if err := s.meta.open(filepath.Join(path, "meta")); err != nil {
 		return fmt.Errorf("meta: %s", err)
 	}
+	if f := s.meta.format; f.version < MetaFormat {
+		s.Logger.Printf("meta: format version %d is older than %d, run \"influxd upgrade\" once every node is upgraded", f.version, MetaFormat)
+	}
+
+	// Set the server path.
+	s.path = path
 
 	// Load state from metastore.
 	if err := s.load(); err != nil {
+		s.path = ""
+		_ = s.meta.close()
 		return fmt.Errorf("load: %s", err)
 	}
 
-	// Set the server path.
-	s.path = path
+	// Verify the data if the server wasn't shut down cleanly. The marker is
+	// removed first so that a crash during recovery runs it again.
+	clean, err := readShutdownMarker(path)
+	if err == nil && existed && !clean {
+		err = s.recoverData()
+	}
+	if err != nil {
+		_ = s.closeFiles()
+		s.path = ""
+		return fmt.Errorf("recover: %s", err)
+	}
+
+	s.closing = make(chan struct{})
+
+	// Accept queries and writes again if the server was previously closed.
+	s.drainMu.Lock()
+	s.drained = nil
+	s.drainMu.Unlock()
 
 	return nil
 }
@@ -160,8 +294,33 @@ func (s *Server) Open(path string) error {
 // opened returns true when the server is open.
 func (s *Server) opened() bool { return s.path != "" }
 
-// Close shuts down the server.
+// Close shuts down the server. New queries and writes are rejected with
+// ErrServerShuttingDown and running ones are given until the shutdown
+// timeout to finish before the shards are closed.
 func (s *Server) Close() error {
+	s.mu.RLock()
+	opened := s.opened()
+	s.mu.RUnlock()
+	if opened {
+		s.drain()
+	}
+
+	// Stop background goroutines before acquiring the lock for the rest
+	// of the shutdown since they may be waiting on it.
+	s.mu.Lock()
+	closing := s.closing
+	s.closing = nil
+	s.mu.Unlock()
+	if closing != nil {
+		close(closing)
+		s.wg.Wait()
+	}
+
+	// Stop sending points to tails. Notifying tails acquires the server
+	// lock so they're closed before acquiring it here.
+	s.closeTails()
+	s.closeCursors()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -172,8 +331,13 @@ func (s *Server) Close() error {
 	// Close message processing.
 	s.setClient(nil)
 
-	// Close metastore.
-	_ = s.meta.close()
+	// Close shards, index logs and the metastore. The next start skips
+	// recovery if they all closed cleanly.
+	if err := s.closeFiles(); err != nil {
+		s.Logger.Printf("close: %s", err)
+	} else if err := writeShutdownMarker(s.path); err != nil {
+		s.Logger.Printf("write shutdown marker: %s", err)
+	}
 
 	// Remove path.
 	s.path = ""
@@ -181,6 +345,28 @@ func (s *Server) Close() error {
 	return nil
 }
 
+// closeFiles closes the shards, index logs and metastore. Returns the first
+// error but closes every file.
+func (s *Server) closeFiles() error {
+	var errs []error
+	for _, db := range s.databases {
+		for _, sh := range db.shards {
+			errs = append(errs, sh.close())
+		}
+		if db.indexLog != nil {
+			errs = append(errs, db.indexLog.close())
+		}
+	}
+	errs = append(errs, s.meta.close())
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // load reads the state of the server from the metastore.
 func (s *Server) load() error {
 	return s.meta.view(func(tx *metatx) error {
@@ -192,19 +378,24 @@ func (s *Server) load() error {
 		for _, db := range tx.databases() {
 			s.databases[db.name] = db
 
-			for sh := range db.shards {
-				s.databasesByShard[sh] = db
+			for id, sh := range db.shards {
+				s.databasesByShard[id] = db
+
+				// Shards are opened when they're first used.
+				sh.attach(s.shardPath(sh.ID), s.shards)
+			}
+
+			// Indexes stored on disk are read when they're first used.
+			if db.indexType == DiskIndex {
+				if err := s.openIndex(tx, db); err != nil {
+					return err
+				}
+				continue
 			}
 
 			// load the index
 			log.Printf("Loading metadata index for %s\n", db.name)
-			err := s.meta.view(func(tx *metatx) error {
-				tx.indexDatabase(db)
-				return nil
-			})
-			if err != nil {
-				return err
-			}
+			tx.indexDatabase(db.name, db.idx)
 		}
 
 		// Load users.
@@ -303,6 +494,18 @@ func (s *Server) sync(index uint64) error {
 	}
 }
 
+// Sync blocks until a given index (or a higher index) has been seen.
+// Returns any error associated with the command.
+func (s *Server) Sync(index uint64) error { return s.sync(index) }
+
+// Index returns the highest broker index applied by the server.
+// It can be used as the snapshot of a query.
+func (s *Server) Index() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index
+}
+
 // Initialize creates a new data node and initializes the server's id to 1.
 func (s *Server) Initialize(u *url.URL) error {
 	// Create a new data node.
@@ -359,6 +562,18 @@ func (s *Server) DataNodes() (a []*DataNode) {
 	return
 }
 
+// MetaNodes returns the URLs of the brokers that hold the cluster's metadata.
+// Returns nil if the messaging client doesn't report its brokers.
+func (s *Server) MetaNodes() []*url.URL {
+	c, ok := s.Client().(interface {
+		URLs() []*url.URL
+	})
+	if !ok {
+		return nil
+	}
+	return c.URLs()
+}
+
 // CreateDataNode creates a new data node with a given URL.
 func (s *Server) CreateDataNode(u *url.URL) error {
 	c := &createDataNodeCommand{URL: u.String()}
@@ -389,6 +604,7 @@ func (s *Server) applyCreateDataNode(m *messaging.Message) (err error) {
 	// Create data node.
 	n := newDataNode()
 	n.URL = u
+	n.Role = DataNodeRoleData
 
 	// Persist to metastore.
 	err = s.meta.mustUpdate(func(tx *metatx) error {
@@ -433,6 +649,55 @@ func (s *Server) applyDeleteDataNode(m *messaging.Message) (err error) {
 	return
 }
 
+// SetDataNodeRole changes the role of a data node. Nodes with the query role
+// keep receiving shard data but don't accept writes.
+func (s *Server) SetDataNodeRole(id uint64, role string) error {
+	c := &setDataNodeRoleCommand{ID: id, Role: role}
+	_, err := s.broadcast(setDataNodeRoleMessageType, c)
+	return err
+}
+
+func (s *Server) applySetDataNodeRole(m *messaging.Message) (err error) {
+	var c setDataNodeRoleCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate parameters.
+	n := s.dataNodes[c.ID]
+	if n == nil {
+		return ErrDataNodeNotFound
+	} else if !isValidDataNodeRole(c.Role) {
+		return ErrInvalidDataNodeRole
+	}
+
+	// Persist a copy so that the node is unchanged if the update fails.
+	other := *n
+	other.Role = c.Role
+	if err = s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDataNode(&other)
+	}); err != nil {
+		return
+	}
+	s.dataNodes[c.ID] = &other
+
+	return
+}
+
+type setDataNodeRoleCommand struct {
+	ID   uint64 `json:"id"`
+	Role string `json:"role"`
+}
+
+// QueryOnly returns true if the server's own data node has the query role.
+func (s *Server) QueryOnly() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := s.dataNodes[s.id]
+	return n != nil && n.QueryOnly()
+}
+
 type deleteDataNodeCommand struct {
 	ID uint64 `json:"id"`
 }
@@ -444,6 +709,23 @@ func (s *Server) DatabaseExists(name string) bool {
 	return s.databases[name] != nil
 }
 
+// DatabaseReadOnly returns true if the database rejects writes.
+// Disabled databases are also read-only.
+func (s *Server) DatabaseReadOnly(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	db := s.databases[name]
+	return db != nil && (db.readOnly || db.disabled)
+}
+
+// DatabaseDisabled returns true if the database rejects writes and queries.
+func (s *Server) DatabaseDisabled(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	db := s.databases[name]
+	return db != nil && db.disabled
+}
+
 // Databases returns a sorted list of all database names.
 func (s *Server) Databases() (a []string) {
 	s.mu.RLock()
@@ -510,7 +792,34 @@ func (s *Server) applyDeleteDatabase(m *messaging.Message) (err error) {
 	err = s.meta.mustUpdate(func(tx *metatx) error { return tx.deleteDatabase(c.Name) })
 
 	// Delete the database entry.
+	db := s.databases[c.Name]
 	delete(s.databases, c.Name)
+	s.resultCache.invalidateDatabase(c.Name)
+
+	// Close the database's shards and remove their data files. Stores are
+	// kept open until running queries have finished reading them.
+	for _, sh := range db.shards {
+		delete(s.databasesByShard, sh.ID)
+		_ = sh.close()
+		if path := s.shardPath(sh.ID); path != "" {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				s.Logger.Printf("delete database: %s", err)
+			}
+		}
+	}
+
+	// Remove the index log.
+	if db.indexLog != nil {
+		if err := db.indexLog.remove(); err != nil && !os.IsNotExist(err) {
+			s.Logger.Printf("delete database: %s", err)
+		}
+	}
+
+	// Reset the database statistics.
+	s.statsMu.Lock()
+	delete(s.stats, c.Name)
+	s.statsMu.Unlock()
+
 	return
 }
 
@@ -518,13 +827,180 @@ type deleteDatabaseCommand struct {
 	Name string `json:"name"`
 }
 
-// shardByTimestamp returns a shard that owns a given timestamp for a database.
-func (s *Server) shardByTimestamp(database, policy string, id uint32, timestamp time.Time) (*Shard, error) {
+// RenameDatabase renames an existing database. Its retention policies and
+// shards are kept as they are and are addressed by the new name.
+func (s *Server) RenameDatabase(name, newName string) error {
+	c := &renameDatabaseCommand{Name: name, NewName: newName}
+	_, err := s.broadcast(renameDatabaseMessageType, c)
+	return err
+}
+
+func (s *Server) applyRenameDatabase(m *messaging.Message) (err error) {
+	var c renameDatabaseCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	db := s.databases[c.Name]
+	if db == nil {
+		return ErrDatabaseNotFound
+	} else if c.NewName == "" {
+		return ErrDatabaseNameRequired
+	} else if s.databases[c.NewName] != nil {
+		return ErrDatabaseExists
+	}
+
+	// Move the database in the metastore and update its name.
+	db.name = c.NewName
+	err = s.meta.mustUpdate(func(tx *metatx) error {
+		if err := tx.renameDatabase(c.Name, c.NewName); err != nil {
+			return err
+		}
+		return tx.saveDatabase(db)
+	})
+
+	// Move the index log.
+	if db.indexLog != nil {
+		if err := db.indexLog.rename(s.indexPath(c.NewName)); err != nil {
+			s.Logger.Printf("rename database: %s", err)
+		}
+	}
+
+	// Move the database entry. Shards are looked up by id so they
+	// remain addressable under the new name.
+	delete(s.databases, c.Name)
+	s.databases[c.NewName] = db
+	s.resultCache.invalidateDatabase(c.Name)
+
+	// Move the database statistics.
+	s.statsMu.Lock()
+	if st := s.stats[c.Name]; st != nil {
+		delete(s.stats, c.Name)
+		s.stats[c.NewName] = st
+	}
+	s.statsMu.Unlock()
+
+	return
+}
+
+type renameDatabaseCommand struct {
+	Name    string `json:"name"`
+	NewName string `json:"newName"`
+}
+
+// DatabaseUpdate represents a change to the flags and quotas of a database.
+// Fields that are nil are left unchanged. Quotas of zero are unlimited.
+type DatabaseUpdate struct {
+	ReadOnly     *bool `json:"readOnly,omitempty"`
+	Disabled     *bool `json:"disabled,omitempty"`
+	StrictSchema *bool `json:"strictSchema,omitempty"`
+
+	MaxSeries    *int           `json:"maxSeries,omitempty"`
+	MaxDiskBytes *int64         `json:"maxDiskBytes,omitempty"`
+	MaxRetention *time.Duration `json:"maxRetention,omitempty"`
+
+	// Index is where the series index is stored: MemoryIndex or DiskIndex.
+	Index *string `json:"index,omitempty"`
+}
+
+// UpdateDatabase sets the flags that mark a database as read-only or disabled,
+// the quotas that limit its series, disk usage and retention policies and
+// where its series index is stored.
+func (s *Server) UpdateDatabase(name string, u *DatabaseUpdate) error {
+	if u.Index != nil && *u.Index != MemoryIndex && *u.Index != DiskIndex {
+		return ErrInvalidIndexType
+	}
+	c := &updateDatabaseCommand{
+		Name:         name,
+		ReadOnly:     u.ReadOnly,
+		Disabled:     u.Disabled,
+		StrictSchema: u.StrictSchema,
+		MaxSeries:    u.MaxSeries,
+		MaxDiskBytes: u.MaxDiskBytes,
+		MaxRetention: u.MaxRetention,
+		Index:        u.Index,
+	}
+	_, err := s.broadcast(updateDatabaseMessageType, c)
+	return err
+}
+
+func (s *Server) applyUpdateDatabase(m *messaging.Message) (err error) {
+	var c updateDatabaseCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	db := s.databases[c.Name]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// Existing retention policies must fit within a new retention quota.
+	if c.MaxRetention != nil {
+		for _, rp := range db.policies {
+			if err := checkRetentionQuota(*c.MaxRetention, rp.Duration); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Move the series index before anything else changes.
+	if c.Index != nil {
+		if err := s.setIndexType(db, *c.Index); err != nil {
+			return err
+		}
+	}
+
+	// Update the flags and quotas that are set.
+	if c.ReadOnly != nil {
+		db.readOnly = *c.ReadOnly
+	}
+	if c.Disabled != nil {
+		db.disabled = *c.Disabled
+	}
+	if c.StrictSchema != nil {
+		db.strictSchema = *c.StrictSchema
+	}
+	if c.MaxSeries != nil {
+		db.maxSeries = *c.MaxSeries
+	}
+	if c.MaxDiskBytes != nil {
+		db.maxDiskBytes = *c.MaxDiskBytes
+	}
+	if c.MaxRetention != nil {
+		db.maxRetention = *c.MaxRetention
+	}
+
+	// Persist to metastore.
+	err = s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+
+	return
+}
+
+type updateDatabaseCommand struct {
+	Name         string         `json:"name"`
+	ReadOnly     *bool          `json:"readOnly,omitempty"`
+	Disabled     *bool          `json:"disabled,omitempty"`
+	StrictSchema *bool          `json:"strictSchema,omitempty"`
+	MaxSeries    *int           `json:"maxSeries,omitempty"`
+	MaxDiskBytes *int64         `json:"maxDiskBytes,omitempty"`
+	MaxRetention *time.Duration `json:"maxRetention,omitempty"`
+	Index        *string        `json:"index,omitempty"`
+}
+
+// shardByTimestamp returns a shard that owns a given timestamp for a series key in a database.
+func (s *Server) shardByTimestamp(database, policy string, key []byte, timestamp time.Time) (*Shard, error) {
 	db := s.databases[database]
 	if db == nil {
 		return nil, ErrDatabaseNotFound
 	}
-	return db.shardByTimestamp(policy, id, timestamp)
+	return db.shardByTimestamp(policy, key, timestamp)
 }
 
 // Shards returns a list of all shards for a database.
@@ -547,6 +1023,69 @@ func (s *Server) Shards(database string) ([]*Shard, error) {
 	return shards, nil
 }
 
+// ShardInfos returns the metadata for the shards in a database that overlap
+// a time range, sorted by id. A zero from or to time leaves that side of the
+// range unbounded. Returns an error if the database doesn't exist.
+func (s *Server) ShardInfos(database string, from, to time.Time) ([]*ShardInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Lookup database.
+	db := s.databases[database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+
+	now := time.Now()
+	a := make([]*ShardInfo, 0, len(db.shards))
+	for _, rp := range db.policies {
+		for _, sh := range rp.Shards {
+			// Ignore shards outside of the time range.
+			if !from.IsZero() && sh.EndTime.Before(from) {
+				continue
+			} else if !to.IsZero() && sh.StartTime.After(to) {
+				continue
+			}
+
+			info := &ShardInfo{
+				ID:              sh.ID,
+				RetentionPolicy: rp.Name,
+				StartTime:       sh.StartTime,
+				EndTime:         sh.EndTime,
+				DataNodeIDs:     append([]uint64{}, sh.dataNodeIDs...),
+				State:           ShardCold,
+			}
+			if now.Before(sh.EndTime) {
+				info.State = ShardHot
+			}
+
+			// Read the series count and size from the local store.
+			st, err := sh.acquire()
+			if err != nil && err != ErrShardNotFound {
+				return nil, err
+			} else if st != nil {
+				seriesN, size, err := st.stats()
+				sh.release(st)
+				if err != nil {
+					return nil, err
+				}
+				info.SeriesN, info.Size = seriesN, size
+			}
+
+			a = append(a, info)
+		}
+	}
+	sort.Sort(shardInfos(a))
+	return a, nil
+}
+
+// shardInfos represents a list of shard metadata, sortable by id.
+type shardInfos []*ShardInfo
+
+func (p shardInfos) Len() int           { return len(p) }
+func (p shardInfos) Less(i, j int) bool { return p[i].ID < p[j].ID }
+func (p shardInfos) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
 // shardsByTimestamp returns all shards that own a given timestamp for a database.
 func (s *Server) shardsByTimestamp(database, policy string, timestamp time.Time) ([]*Shard, error) {
 	db := s.databases[database]
@@ -559,16 +1098,37 @@ func (s *Server) shardsByTimestamp(database, policy string, timestamp time.Time)
 // CreateShardsIfNotExist creates all the shards for a retention policy for the interval a timestamp falls into.
 // Note that multiple shards can be created for each bucket of time.
 func (s *Server) CreateShardsIfNotExists(database, policy string, timestamp time.Time) error {
-	c := &createShardIfNotExistsCommand{Database: database, Policy: policy, Timestamp: timestamp}
-	_, err := s.broadcast(createShardIfNotExistsMessageType, c)
-	return err
+	// Determine the number of shards in the group.
+	s.mu.RLock()
+	db := s.databases[database]
+	if db == nil {
+		s.mu.RUnlock()
+		return ErrDatabaseNotFound
+	}
+	rp := db.policies[policy]
+	if rp == nil {
+		s.mu.RUnlock()
+		return ErrRetentionPolicyNotFound
+	}
+	splitN := rp.splitN()
+	s.mu.RUnlock()
+
+	// Each command adds one shard to the group until it has SplitN shards.
+	// Commands from concurrent writers beyond that are ignored.
+	for i := 0; i < splitN; i++ {
+		c := &createShardIfNotExistsCommand{Database: database, Policy: policy, Timestamp: timestamp}
+		if _, err := s.broadcast(createShardIfNotExistsMessageType, c); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// createShardIfNotExists returns the shard for a given retention policy, series, and timestamp.
+// createShardIfNotExists returns the shard for a given retention policy, series key, and timestamp.
 // If it doesn't exist, it will create all shards for the given timestamp
-func (s *Server) createShardIfNotExists(database, policy string, id uint32, timestamp time.Time) (*Shard, error) {
+func (s *Server) createShardIfNotExists(database, policy string, key []byte, timestamp time.Time) (*Shard, error) {
 	// Check if shard exists first.
-	sh, err := s.shardByTimestamp(database, policy, id, timestamp)
+	sh, err := s.shardByTimestamp(database, policy, key, timestamp)
 	if err != nil {
 		return nil, err
 	} else if sh != nil {
@@ -581,7 +1141,7 @@ func (s *Server) createShardIfNotExists(database, policy string, id uint32, time
 	}
 
 	// Lookup the shard again.
-	return s.shardByTimestamp(database, policy, id, timestamp)
+	return s.shardByTimestamp(database, policy, key, timestamp)
 }
 
 func (s *Server) applyCreateShardIfNotExists(m *messaging.Message) (err error) {
@@ -603,36 +1163,43 @@ func (s *Server) applyCreateShardIfNotExists(m *messaging.Message) (err error) {
 		return ErrRetentionPolicyNotFound
 	}
 
-	// If we can match to an existing shard date range then just ignore request.
-	for _, sh := range rp.Shards {
-		if timeBetweenInclusive(c.Timestamp, sh.StartTime, sh.EndTime) {
-			return nil
-		}
-	}
-
-	// If no shards match then create a new one.
+	// If the group for the date range already has all of its shards then just
+	// ignore request. Otherwise add a shard to the group, or start a new group.
 	sh := newShard()
 	sh.ID = m.Index
-	sh.StartTime = c.Timestamp.Truncate(rp.Duration).UTC()
-	sh.EndTime = sh.StartTime.Add(rp.Duration).UTC()
+	if group := rp.shardGroupByTimestamp(c.Timestamp); len(group) >= rp.splitN() {
+		return nil
+	} else if len(group) > 0 {
+		sh.StartTime, sh.EndTime = group[0].StartTime, group[0].EndTime
+	} else {
+		sh.StartTime = c.Timestamp.Truncate(rp.Duration).UTC()
+		sh.EndTime = sh.StartTime.Add(rp.Duration).UTC()
+	}
 
-	// Open shard.
-	if err := sh.open(s.shardPath(sh.ID)); err != nil {
+	// Open shard. The cache may close it again while it's idle.
+	sh.attach(s.shardPath(sh.ID), s.shards)
+	if err := sh.open(sh.path); err != nil {
 		panic("unable to open shard: " + err.Error())
 	}
+	s.shards.touch(sh)
 
-	// Persist to metastore if a shard was created.
+	// Persist to metastore with the new shard so that it is loaded on restart.
+	db.shards[sh.ID] = sh
+	rp.Shards = append(rp.Shards, sh)
 	if err = s.meta.mustUpdate(func(tx *metatx) error {
 		return tx.saveDatabase(db)
 	}); err != nil {
+		delete(db.shards, sh.ID)
+		rp.Shards = rp.Shards[:len(rp.Shards)-1]
 		_ = sh.close()
 		return
 	}
 
 	// Add to lookups.
 	s.databasesByShard[sh.ID] = db
-	db.shards[sh.ID] = sh
-	rp.Shards = append(rp.Shards, sh)
+
+	// Cached results may not have read from a shard covering its time range.
+	s.resultCache.invalidateDatabase(db.name)
 
 	// TODO: Subscribe to shard if it matches the server's index.
 
@@ -645,20 +1212,183 @@ type createShardIfNotExistsCommand struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
-// User returns a user by username
-// Returns nil if the user does not exist.
-func (s *Server) User(name string) *User {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	return s.users[name]
-}
-
-// Users returns a list of all users, sorted by name.
-func (s *Server) Users() (a []*User) {
+// DeleteShard removes a shard and its data from every server in the cluster.
+// Returns an error if the shard is owned by other data nodes but not this one.
+func (s *Server) DeleteShard(database string, id uint64) error {
+	// Validate the shard before broadcasting the removal.
 	s.mu.RLock()
-	defer s.mu.RUnlock()
-	for _, u := range s.users {
-		a = append(a, u)
+	db := s.databases[database]
+	if db == nil {
+		s.mu.RUnlock()
+		return ErrDatabaseNotFound
+	}
+	sh := db.shards[id]
+	if sh == nil {
+		s.mu.RUnlock()
+		return ErrShardNotFound
+	}
+	owned := len(sh.dataNodeIDs) == 0
+	for _, nodeID := range sh.dataNodeIDs {
+		if nodeID == s.id {
+			owned = true
+		}
+	}
+	s.mu.RUnlock()
+	if !owned {
+		return ErrShardOwnedElsewhere
+	}
+
+	c := &deleteShardCommand{Database: database, ID: id}
+	_, err := s.broadcast(deleteShardMessageType, c)
+	return err
+}
+
+func (s *Server) applyDeleteShard(m *messaging.Message) (err error) {
+	var c deleteShardCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Retrieve database and shard.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+	sh := db.shards[c.ID]
+	if sh == nil {
+		return ErrShardNotFound
+	}
+
+	return s.dropShard(db, sh)
+}
+
+// dropShard removes a shard from the database, closes it and removes its
+// data file. Must be called with the lock held.
+func (s *Server) dropShard(db *database, sh *Shard) (err error) {
+	// Remove from lookups.
+	delete(db.shards, sh.ID)
+	delete(s.databasesByShard, sh.ID)
+	s.resultCache.invalidateShard(sh.ID)
+	for _, rp := range db.policies {
+		for i, other := range rp.Shards {
+			if other == sh {
+				rp.Shards = append(rp.Shards[:i], rp.Shards[i+1:]...)
+				break
+			}
+		}
+	}
+
+	// Persist to metastore.
+	if err = s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	}); err != nil {
+		return
+	}
+
+	// Close the shard and remove its data file.
+	_ = sh.close()
+	if path := s.shardPath(sh.ID); path != "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			s.Logger.Printf("delete shard: %s", err)
+		}
+	}
+
+	return
+}
+
+type deleteShardCommand struct {
+	Database string `json:"database"`
+	ID       uint64 `json:"id"`
+}
+
+// DeletePoints removes the points written before a time from a measurement,
+// or from every measurement in the database if the name is blank. Shards
+// that end before the time are dropped. Points in shards that overlap it are
+// masked from queries by a deletion until they're purged from disk.
+func (s *Server) DeletePoints(database, measurement string, before time.Time) error {
+	c := &deletePointsCommand{Database: database, Measurement: measurement, Before: before, Time: time.Now().UTC()}
+	_, err := s.broadcast(deletePointsMessageType, c)
+	return err
+}
+
+func (s *Server) applyDeletePoints(m *messaging.Message) error {
+	var c deletePointsCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Retrieve database.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	idx, err := db.loadIndex()
+	if err != nil {
+		return err
+	} else if c.Measurement != "" && idx.measurements[c.Measurement] == nil {
+		return ErrMeasurementNotFound
+	}
+
+	var pending bool
+	for _, sh := range db.shards {
+		if !sh.StartTime.Before(c.Before) {
+			continue
+		}
+
+		// Drop whole shards when every measurement is being deleted.
+		if c.Measurement == "" && !sh.EndTime.After(c.Before) {
+			if err := s.dropShard(db, sh); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Otherwise the points are masked until they're purged.
+		pending = true
+		s.resultCache.invalidateShard(sh.ID)
+	}
+	if !pending {
+		return nil
+	}
+
+	// Add a deletion with the index of the command as its epoch.
+	db.deletions[m.Index] = &Deletion{
+		ID:          m.Index,
+		Measurement: c.Measurement,
+		Before:      c.Before,
+		CreatedAt:   c.Time,
+	}
+
+	// Persist to metastore.
+	return s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+}
+
+type deletePointsCommand struct {
+	Database    string    `json:"database"`
+	Measurement string    `json:"measurement,omitempty"`
+	Before      time.Time `json:"before"`
+	Time        time.Time `json:"time"`
+}
+
+// User returns a user by username
+// Returns nil if the user does not exist.
+func (s *Server) User(name string) *User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.users[name]
+}
+
+// Users returns a list of all users, sorted by name.
+func (s *Server) Users() (a []*User) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.users {
+		a = append(a, u)
 	}
 	sort.Sort(users(a))
 	return a
@@ -768,6 +1498,16 @@ func (s *Server) applyUpdateUser(m *messaging.Message) (err error) {
 		u.Hash = string(hash)
 	}
 
+	// Update the admin flag, if set.
+	if c.Admin != nil {
+		u.Admin = *c.Admin
+	}
+
+	// Replace the grants, if set.
+	if c.Grants != nil {
+		u.Grants = *c.Grants
+	}
+
 	// Persist to metastore.
 	return s.meta.mustUpdate(func(tx *metatx) error {
 		return tx.saveUser(u)
@@ -775,8 +1515,26 @@ func (s *Server) applyUpdateUser(m *messaging.Message) (err error) {
 }
 
 type updateUserCommand struct {
-	Username string `json:"username"`
-	Password string `json:"password,omitempty"`
+	Username string    `json:"username"`
+	Password string    `json:"password,omitempty"`
+	Admin    *bool     `json:"admin,omitempty"`
+	Grants   *[]string `json:"grants,omitempty"`
+}
+
+// SetUserGrants replaces the privileges granted to a user. An empty list of
+// grants gives the user all privileges again.
+func (s *Server) SetUserGrants(username string, grants []string) error {
+	for _, g := range grants {
+		if _, _, _, err := ParseTokenScope(g); err != nil {
+			return err
+		}
+	}
+	if grants == nil {
+		grants = []string{}
+	}
+	c := &updateUserCommand{Username: username, Grants: &grants}
+	_, err := s.broadcast(updateUserMessageType, c)
+	return err
 }
 
 // DeleteUser removes a user from the server.
@@ -867,11 +1625,12 @@ func (s *Server) RetentionPolicies(database string) ([]*RetentionPolicy, error)
 // CreateRetentionPolicy creates a retention policy for a database.
 func (s *Server) CreateRetentionPolicy(database string, rp *RetentionPolicy) error {
 	c := &createRetentionPolicyCommand{
-		Database: database,
-		Name:     rp.Name,
-		Duration: rp.Duration,
-		ReplicaN: rp.ReplicaN,
-		SplitN:   rp.SplitN,
+		Database:   database,
+		Name:       rp.Name,
+		Duration:   rp.Duration,
+		ReplicaN:   rp.ReplicaN,
+		SplitN:     rp.SplitN,
+		Downsample: rp.Downsample,
 	}
 	_, err := s.broadcast(createRetentionPolicyMessageType, c)
 	return err
@@ -892,14 +1651,26 @@ func (s *Server) applyCreateRetentionPolicy(m *messaging.Message) error {
 		return ErrRetentionPolicyNameRequired
 	} else if db.policies[c.Name] != nil {
 		return ErrRetentionPolicyExists
+	} else if err := checkRetentionQuota(db.maxRetention, c.Duration); err != nil {
+		return err
+	}
+
+	// Validate the downsampling rule, if set.
+	if c.Downsample != nil {
+		if err := c.Downsample.validate(); err != nil {
+			return err
+		} else if c.Name == db.defaultRetentionPolicy {
+			return ErrDownsampleDefaultPolicy
+		}
 	}
 
 	// Add policy to the database.
 	db.policies[c.Name] = &RetentionPolicy{
-		Name:     c.Name,
-		Duration: c.Duration,
-		ReplicaN: c.ReplicaN,
-		SplitN:   c.SplitN,
+		Name:       c.Name,
+		Duration:   c.Duration,
+		ReplicaN:   c.ReplicaN,
+		SplitN:     c.SplitN,
+		Downsample: c.Downsample,
 	}
 
 	// Persist to metastore.
@@ -911,24 +1682,28 @@ func (s *Server) applyCreateRetentionPolicy(m *messaging.Message) error {
 }
 
 type createRetentionPolicyCommand struct {
-	Database string        `json:"database"`
-	Name     string        `json:"name"`
-	Duration time.Duration `json:"duration"`
-	ReplicaN uint32        `json:"replicaN"`
-	SplitN   uint32        `json:"splitN"`
+	Database   string        `json:"database"`
+	Name       string        `json:"name"`
+	Duration   time.Duration `json:"duration"`
+	ReplicaN   uint32        `json:"replicaN"`
+	SplitN     uint32        `json:"splitN"`
+	Downsample *Downsample   `json:"downsample,omitempty"`
 }
 
 // UpdateRetentionPolicy updates an existing retention policy on a database.
 func (s *Server) UpdateRetentionPolicy(database, name string, rp *RetentionPolicy) error {
-	c := &updateRetentionPolicyCommand{Database: database, Name: name, NewName: rp.Name}
+	c := &updateRetentionPolicyCommand{Database: database, Name: name, NewName: rp.Name, Downsample: rp.Downsample}
 	_, err := s.broadcast(updateRetentionPolicyMessageType, c)
 	return err
 }
 
 type updateRetentionPolicyCommand struct {
-	Database string `json:"database"`
-	Name     string `json:"name"`
-	NewName  string `json:"newName"`
+	Database   string         `json:"database"`
+	Name       string         `json:"name"`
+	NewName    string         `json:"newName"`
+	Duration   *time.Duration `json:"duration,omitempty"`
+	ReplicaN   *uint32        `json:"replicaN,omitempty"`
+	Downsample *Downsample    `json:"downsample,omitempty"`
 }
 
 func (s *Server) applyUpdateRetentionPolicy(m *messaging.Message) (err error) {
@@ -952,11 +1727,53 @@ func (s *Server) applyUpdateRetentionPolicy(m *messaging.Message) (err error) {
 		return ErrRetentionPolicyNotFound
 	}
 
-	// Update the policy name, if not blank.
+	// Validate the new duration against the database's retention quota.
+	if c.Duration != nil {
+		if err := checkRetentionQuota(db.maxRetention, *c.Duration); err != nil {
+			return err
+		}
+	}
+
+	// Validate the new downsampling rule.
+	if c.Downsample != nil {
+		if err := c.Downsample.validate(); err != nil {
+			return err
+		} else if c.Name == db.defaultRetentionPolicy {
+			return ErrDownsampleDefaultPolicy
+		}
+	}
+
+	// Update the policy name, if not blank. The default policy follows the
+	// rename and the policy keeps its shards.
 	if c.NewName != c.Name && c.NewName != "" {
+		if db.policies[c.NewName] != nil {
+			return ErrRetentionPolicyExists
+		}
 		delete(db.policies, p.Name)
 		p.Name = c.NewName
 		db.policies[p.Name] = p
+		if db.defaultRetentionPolicy == c.Name {
+			db.defaultRetentionPolicy = c.NewName
+		}
+		s.resultCache.invalidateDatabase(db.name)
+	}
+
+	// Update the duration and replication factor, if set.
+	if c.Duration != nil {
+		p.Duration = *c.Duration
+	}
+	if c.ReplicaN != nil {
+		p.ReplicaN = *c.ReplicaN
+	}
+
+	// Replace the downsampling rule, if set. Intervals that have already
+	// been aggregated are not aggregated again.
+	if c.Downsample != nil {
+		if p.Downsample != nil {
+			c.Downsample.Completed = p.Downsample.Completed
+		}
+		p.Downsample = c.Downsample
+		s.resultCache.invalidateDatabase(db.name)
 	}
 
 	// Persist to metastore.
@@ -993,6 +1810,7 @@ func (s *Server) applyDeleteRetentionPolicy(m *messaging.Message) (err error) {
 
 	// Remove retention policy.
 	delete(db.policies, c.Name)
+	s.resultCache.invalidateDatabase(db.name)
 
 	// Persist to metastore.
 	err = s.meta.mustUpdate(func(tx *metatx) error {
@@ -1027,6 +1845,8 @@ func (s *Server) applySetDefaultRetentionPolicy(m *messaging.Message) (err error
 		return ErrDatabaseNotFound
 	} else if db.policies[c.Name] == nil {
 		return ErrRetentionPolicyNotFound
+	} else if db.policies[c.Name].Downsample != nil {
+		return ErrDownsampleDefaultPolicy
 	}
 
 	// Update default policy.
@@ -1058,23 +1878,17 @@ func (s *Server) applyCreateSeriesIfNotExists(m *messaging.Message) error {
 		return ErrDatabaseNotFound
 	}
 
-	if _, series := db.MeasurementAndSeries(c.Name, c.Tags); series != nil {
-		return nil
-	}
-
-	// save to the metastore and add it to the in memory index
-	var series *Series
-	err := s.meta.mustUpdate(func(tx *metatx) error {
-		var err error
-		series, err = tx.createSeries(db.name, c.Name, c.Tags)
-		return err
-	})
-	if err != nil {
+	if _, err := db.loadIndex(); err != nil {
 		return err
+	} else if _, series := db.MeasurementAndSeries(c.Name, c.Tags); series != nil {
+		return nil
+	} else if db.maxSeries > 0 && db.seriesN() >= db.maxSeries {
+		return ErrSeriesQuotaExceeded
 	}
-	db.addSeriesToIndex(c.Name, series)
 
-	return nil
+	// save to the index storage and add it to the in memory index
+	_, err := s.saveSeries(db, c.Name, c.Tags)
+	return err
 }
 
 type createSeriesIfNotExistsCommand struct {
@@ -1084,97 +1898,215 @@ type createSeriesIfNotExistsCommand struct {
 }
 
 // WriteSeries writes series data to the database.
+// Returns a *PointError if the point fails validation.
 func (s *Server) WriteSeries(database, retentionPolicy, name string, tags map[string]string, timestamp time.Time, values map[string]interface{}) error {
-	// Find the id for the series and tagset
-	id, err := s.createSeriesIfNotExists(database, name, tags)
-	if err != nil {
+	p := &Point{Name: name, Tags: tags, Timestamp: timestamp, Values: values}
+	route := s.routePoints(database, retentionPolicy, []*Point{p})[0]
+	database, retentionPolicy = route.database, route.retentionPolicy
+	if err := s.loadIndex(database); err != nil {
+		s.addWriteErrors(database, 1)
 		return err
 	}
 
-	// If the retention policy is not set, use the default for this database.
-	if retentionPolicy == "" {
-		rp, err := s.DefaultRetentionPolicy(database)
-		if err != nil {
-			return fmt.Errorf("failed to determine default retention policy: %s", err.Error())
-		}
-		retentionPolicy = rp.Name
+	l := s.PointLimits()
+	min, max := s.timestampWindow(database, retentionPolicy, l)
+	if err := p.validate(l, min, max); err != nil {
+		s.addWriteErrors(database, 1)
+		return err
+	} else if errs := s.checkSchemas(database, []*Point{p}); len(errs) > 0 {
+		s.addWriteErrors(database, 1)
+		return errs[0]
+	}
+	points, errs := s.guardTags(database, []*Point{p})
+	if len(errs) > 0 {
+		s.addWriteErrors(database, 1)
+		return errs[0]
+	} else if errs := s.checkFields(database, points); len(errs) > 0 {
+		s.addWriteErrors(database, 1)
+		return errs[0]
+	}
+	return s.writePoints(database, retentionPolicy, points, nil)
+}
+
+// writePoints publishes validated points to the broker and updates the
+// database statistics. Points are queued with concurrent writes to the same
+// shard so they can be committed together. Returns once every point has been
+// published or an error occurs. If published is set then the index of every
+// point that was published is marked in it.
+func (s *Server) writePoints(database, retentionPolicy string, points []*Point, published []bool) error {
+	if err := s.begin(); err != nil {
+		s.addWriteErrors(database, len(points))
+		return err
 	}
+	defer s.end()
 
-	// Now write it into the shard.
-	sh, err := s.createShardIfNotExists(database, retentionPolicy, id, timestamp)
-	if err != nil {
-		return fmt.Errorf("create shard(%s/%s): %s", retentionPolicy, timestamp.Format(time.RFC3339Nano), err)
+	// Reject the points if the database has reached its disk quota.
+	if err := s.checkDiskQuota(database); err != nil {
+		s.addWriteErrors(database, len(points))
+		return err
 	}
 
-	// Encode point to a byte slice.
-	data, err := marshalPoint(id, timestamp, values)
-	if err != nil {
-		return err
+	// Encode every point before queuing any so that nothing is written if a
+	// series or shard cannot be created. Points are encoded into a single
+	// buffer to avoid allocating for each point.
+	precision := s.TimestampPrecision().Duration()
+	topicIDs := make([]uint64, len(points))
+	offsets := make([]int, len(points)+1)
+	buf := make([]byte, 0, len(points)*64)
+	for i, p := range points {
+		// Truncate the timestamp without modifying the caller's point.
+		if precision > 1 {
+			other := *p
+			other.Timestamp = p.Timestamp.Truncate(precision)
+			p = &other
+		}
+
+		var err error
+		if topicIDs[i], buf, err = s.encodePoint(buf, database, retentionPolicy, p); err != nil {
+			s.addWriteErrors(database, 1)
+			return err
+		}
+		offsets[i+1] = len(buf)
+	}
+	data := make([][]byte, len(points))
+	for i := range data {
+		data[i] = buf[offsets[i]:offsets[i+1]:offsets[i+1]]
 	}
 
-	// Publish "write series" message on shard's topic to broker.
-	m := &messaging.Message{
-		Type:    writeSeriesMessageType,
-		TopicID: sh.ID,
-		Data:    data,
+	// Track the points as pending until they have been published.
+	s.addPendingPoints(len(points))
+	defer s.addPendingPoints(-len(points))
+
+	// Queue the points and wait for their batches to be published.
+	batches := make([]*pointBatch, len(points))
+	for i := range points {
+		batches[i] = s.batcher.add(topicIDs[i], data[i])
 	}
 
-	_, err = s.client.Publish(m)
+	var err error
+	st := s.DatabaseStats(database)
+	for i, b := range batches {
+		if e := b.wait(); e != nil {
+			if err == nil {
+				err = e
+			}
+			if st != nil {
+				st.Add(StatWriteErrors, 1)
+			}
+		} else {
+			if published != nil {
+				published[i] = true
+			}
+			if st != nil {
+				st.Add(StatPointsWritten, 1)
+				st.Add(StatBytesIn, int64(len(data[i])))
+			}
+		}
+	}
 	return err
 }
 
-func (s *Server) applyWriteSeries(m *messaging.Message) error {
-	s.mu.RLock()
+// addWriteErrors increments the write error count for a database, if it exists.
+func (s *Server) addWriteErrors(database string, n int) {
+	if st := s.DatabaseStats(database); st != nil {
+		st.Add(StatWriteErrors, int64(n))
+	}
+}
 
-	// Retrieve the database.
-	db := s.databasesByShard[m.TopicID]
-	if db == nil {
-		s.mu.RUnlock()
-		return ErrDatabaseNotFound
+// WritePoints writes a batch of points to the database.
+// Points matching a routing rule of the database are written to the rule's
+// database and retention policy instead. Every point is validated first so
+// that no points are written if any point is invalid. Validation errors for
+// every invalid point are returned as PointErrors.
+func (s *Server) WritePoints(database, retentionPolicy string, points []*Point) error {
+	return s.writeRoutes(database, retentionPolicy, points, nil)
+}
+
+// writeRoutes writes points like WritePoints. If published is set then the
+// index of every point that was published is marked in it, so that a write
+// that fails part way can be retried without the published points.
+func (s *Server) writeRoutes(database, retentionPolicy string, points []*Point, published []bool) error {
+	routes := s.routePoints(database, retentionPolicy, points)
+	for _, r := range routes {
+		if err := s.loadIndex(r.database); err != nil {
+			s.addWriteErrors(database, len(points))
+			return err
+		}
 	}
 
-	// Retrieve the shard.
-	sh := db.shards[m.TopicID]
-	if sh == nil {
-		s.mu.RUnlock()
-		return ErrShardNotFound
+	var errs PointErrors
+	for _, r := range routes {
+		validated, e := s.validatePoints(r.database, r.retentionPolicy, r.points)
+		for _, pe := range e {
+			if r.indexes != nil {
+				pe.Index = r.indexes[pe.Index]
+			}
+			errs = append(errs, pe)
+		}
+		r.points = validated
+	}
+	if len(errs) > 0 {
+		sort.Sort(errs)
+		s.addWriteErrors(database, len(errs))
+		return errs
 	}
-	s.mu.RUnlock()
 
-	// TODO: enable some way to specify if the data should be overwritten
-	overwrite := true
+	// Reject the points if the write path is backed up.
+	if err := s.WriteBackpressure(); err != nil {
+		s.addWriteErrors(database, len(points))
+		return err
+	}
 
-	// Write to shard.
-	return sh.writeSeries(overwrite, m.Data)
+	for _, r := range routes {
+		var a []bool
+		if published != nil {
+			a = make([]bool, len(r.points))
+		}
+		err := s.writePoints(r.database, r.retentionPolicy, r.points, a)
+		for i, ok := range a {
+			if ok && r.indexes != nil {
+				published[r.indexes[i]] = true
+			} else if ok {
+				published[i] = true
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (s *Server) createSeriesIfNotExists(database, name string, tags map[string]string) (uint32, error) {
-	// Try to find series locally first.
-	s.mu.RLock()
-	idx := s.databases[database]
-	if _, series := idx.MeasurementAndSeries(name, tags); series != nil {
-		s.mu.RUnlock()
-		return series.ID, nil
+// validatePoints checks points against the point limits, schemas, tag guards
+// and field types of the database they are written to. Returns the points
+// with guarded tag values replaced or PointErrors for every invalid point.
+func (s *Server) validatePoints(database, retentionPolicy string, points []*Point) ([]*Point, PointErrors) {
+	l := s.PointLimits()
+	min, max := s.timestampWindow(database, retentionPolicy, l)
+	var errs PointErrors
+	for i, p := range points {
+		if err := p.validate(l, min, max); err != nil {
+			e := err.(*PointError)
+			e.Index = i
+			errs = append(errs, e)
+		}
 	}
-	// release the read lock so the broadcast can actually go through and acquire the write lock
-	s.mu.RUnlock()
-
-	// If it doesn't exist then create a message and broadcast.
-	c := &createSeriesIfNotExistsCommand{Database: database, Name: name, Tags: tags}
-	_, err := s.broadcast(createSeriesIfNotExistsMessageType, c)
-	if err != nil {
-		return 0, err
+	if len(errs) == 0 {
+		errs = s.checkSchemas(database, points)
 	}
-
-	// Lookup series again.
-	_, series := idx.MeasurementAndSeries(name, tags)
-	if series == nil {
-		return 0, ErrSeriesNotFound
+	if len(errs) == 0 {
+		points, errs = s.guardTags(database, points)
 	}
-	return series.ID, nil
+	if len(errs) == 0 {
+		errs = s.checkFields(database, points)
+	}
+	return points, errs
 }
 
-func (s *Server) MeasurementNames(database string) []string {
+// checkFields returns PointErrors for points that write a field with a
+// different type than the measurement's existing field or an earlier point,
+// so that conflicts are reported to the writer instead of when applied.
+func (s *Server) checkFields(database string, points []*Point) PointErrors {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -1183,30 +2115,1022 @@ func (s *Server) MeasurementNames(database string) []string {
 		return nil
 	}
 
-	return db.names
+	var errs PointErrors
+	batch := make(batchFields)
+	for i, p := range points {
+		for _, e := range db.checkFields(p, batch, nil) {
+			e.Index = i
+			errs = append(errs, e)
+		}
+	}
+	return errs
 }
 
-func (s *Server) MeasurementSeriesIDs(database, measurement string) SeriesIDs {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// SetWriteBackpressure sets the limits at which WritePoints rejects writes
+// instead of queuing them. maxPendingPoints is the number of points waiting
+// to be published to the broker and maxUnappliedWrites is the number of
+// published writes that the server has not applied yet. Zero disables a limit.
+func (s *Server) SetWriteBackpressure(maxPendingPoints, maxUnappliedWrites int) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.maxPendingPoints, s.maxUnappliedWrites = maxPendingPoints, maxUnappliedWrites
+}
 
-	db := s.databases[database]
-	if db == nil {
-		return nil
-	}
+// WriteState represents the load on the server's write path.
+type WriteState struct {
+	PendingPoints   int    `json:"pendingPoints"`
+	UnappliedWrites int    `json:"unappliedWrites"`
+	Backpressure    string `json:"backpressure,omitempty"` // reason writes are rejected, if any
+}
 
-	return db.SeriesIDs([]string{measurement}, nil)
+// WriteState returns the current load on the write path.
+func (s *Server) WriteState() WriteState {
+	st, _ := s.writeState()
+	return st
 }
 
-// processor runs in a separate goroutine and processes all incoming broker messages.
-func (s *Server) processor(client MessagingClient, done chan struct{}) {
-	for {
-		// Read incoming message.
-		var m *messaging.Message
-		select {
-		case <-done:
-			return
-		case m = <-client.C():
+// WriteBackpressure returns ErrWriteQueueFull or ErrWriteLogBehind if the
+// write path is backed up and writes should be retried later.
+func (s *Server) WriteBackpressure() error {
+	_, err := s.writeState()
+	return err
+}
+
+// writeState returns the load on the write path and the reason writes are
+// rejected, if any.
+func (s *Server) writeState() (WriteState, error) {
+	s.mu.RLock()
+	index := s.index
+	s.mu.RUnlock()
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	var st WriteState
+	st.PendingPoints = s.pendingPoints
+	if s.publishedIndex > index {
+		st.UnappliedWrites = int(s.publishedIndex - index)
+	}
+
+	// Determine if writes should be rejected.
+	var err error
+	if s.shuttingDown() {
+		err = ErrServerShuttingDown
+	} else if s.maxPendingPoints > 0 && st.PendingPoints >= s.maxPendingPoints {
+		err = ErrWriteQueueFull
+	} else if s.maxUnappliedWrites > 0 && st.UnappliedWrites >= s.maxUnappliedWrites {
+		err = ErrWriteLogBehind
+	}
+	if err != nil {
+		st.Backpressure = err.Error()
+	}
+	return st, err
+}
+
+// addPendingPoints adjusts the number of points waiting to be published.
+func (s *Server) addPendingPoints(n int) {
+	s.writeMu.Lock()
+	s.pendingPoints += n
+	s.writeMu.Unlock()
+}
+
+// SetGroupCommit sets the maximum number of points written to a shard in a
+// single commit and how long a write waits for other writes to join it.
+// Batching is disabled if the size is one or less or the delay is zero.
+func (s *Server) SetGroupCommit(size int, delay time.Duration) {
+	s.batcher.setConfig(size, delay)
+}
+
+// PointLimits returns the limits enforced on written points.
+func (s *Server) PointLimits() PointLimits {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pointLimits
+}
+
+// SetPointLimits sets the limits enforced on written points.
+func (s *Server) SetPointLimits(l PointLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pointLimits = l
+}
+
+// TimestampPrecision returns the unit that written timestamps are truncated to.
+func (s *Server) TimestampPrecision() TimePrecision {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.precision
+}
+
+// SetTimestampPrecision sets the unit that written timestamps are truncated to.
+// Nanosecond precision, the default, keeps timestamps as they are written.
+func (s *Server) SetTimestampPrecision(p TimePrecision) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.precision = p
+}
+
+// timestampWindow returns the range of timestamps that can be written to a
+// retention policy under the point limits. Zero times are unbounded.
+func (s *Server) timestampWindow(database, retentionPolicy string, l PointLimits) (min, max time.Time) {
+	now := time.Now()
+	if l.MaxFuture > 0 {
+		max = now.Add(l.MaxFuture)
+	}
+
+	// Points older than the retention policy's duration would be dropped
+	// with their shard, so they can be rejected up front.
+	if l.RejectExpired {
+		var rp *RetentionPolicy
+		if retentionPolicy == "" {
+			rp, _ = s.DefaultRetentionPolicy(database)
+		} else {
+			rp, _ = s.RetentionPolicy(database, retentionPolicy)
+		}
+		if rp != nil && rp.Duration > 0 {
+			min = now.Add(-rp.Duration)
+		}
+	}
+	return
+}
+
+// encodePoint creates the series and shard for a point, if needed, and
+// appends its encoding to b. Returns the id of the shard's topic and the
+// extended buffer.
+func (s *Server) encodePoint(b []byte, database, retentionPolicy string, p *Point) (uint64, []byte, error) {
+	// Find the id for the series and tagset
+	id, err := s.createSeriesIfNotExists(database, p.Name, p.Tags)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// If the retention policy is not set, use the default for this database.
+	if retentionPolicy == "" {
+		rp, err := s.DefaultRetentionPolicy(database)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to determine default retention policy: %s", err.Error())
+		}
+		retentionPolicy = rp.Name
+	}
+
+	// Find the shard to write it into.
+	sh, err := s.createShardIfNotExists(database, retentionPolicy, seriesKey(p.Name, p.Tags), p.Timestamp)
+	if err != nil {
+		return 0, nil, fmt.Errorf("create shard(%s/%s): %s", retentionPolicy, p.Timestamp.Format(time.RFC3339Nano), err)
+	}
+
+	// Encode point to the buffer.
+	b, err = appendPoint(b, id, p.Timestamp, p.Values)
+	if err != nil {
+		return 0, nil, err
+	}
+	return sh.ID, b, nil
+}
+
+// publishPoints publishes encoded points on a shard's topic to the broker.
+// A single point is sent as a "write series" message. Multiple points are
+// sent as one "write series batch" message so they are committed together.
+func (s *Server) publishPoints(topicID uint64, points [][]byte) error {
+	m := &messaging.Message{
+		Type:    writeSeriesMessageType,
+		TopicID: topicID,
+	}
+	if len(points) == 1 {
+		m.Data = points[0]
+	} else {
+		m.Type = writeSeriesBatchMessageType
+		m.Data = marshalPointBatch(points)
+	}
+
+	index, err := s.client.Publish(m)
+	if err != nil {
+		return err
+	}
+
+	// Track the highest published index to measure how far behind writes are applied.
+	s.writeMu.Lock()
+	if index > s.publishedIndex {
+		s.publishedIndex = index
+	}
+	s.writeMu.Unlock()
+
+	return nil
+}
+
+func (s *Server) applyWriteSeries(m *messaging.Message) error {
+	return s.applyWritePoints(m.TopicID, m.Index, [][]byte{m.Data})
+}
+
+func (s *Server) applyWriteSeriesBatch(m *messaging.Message) error {
+	points, err := unmarshalPointBatch(m.Data)
+	if err != nil {
+		return err
+	}
+	return s.applyWritePoints(m.TopicID, m.Index, points)
+}
+
+// applyWritePoints writes encoded points to a shard in a single transaction.
+// The points are stored with the index of the message as their sequence number.
+func (s *Server) applyWritePoints(topicID, index uint64, points [][]byte) error {
+	s.mu.RLock()
+
+	// Retrieve the database.
+	db := s.databasesByShard[topicID]
+	if db == nil {
+		s.mu.RUnlock()
+		return ErrDatabaseNotFound
+	}
+
+	// Retrieve the shard.
+	sh := db.shards[topicID]
+	if sh == nil {
+		s.mu.RUnlock()
+		return ErrShardNotFound
+	}
+
+	// Read the index before the points' series and fields are looked up.
+	if _, err := db.loadIndex(); err != nil {
+		s.mu.RUnlock()
+		return err
+	}
+
+	// Look up how each series handles points at existing timestamps.
+	name := db.name
+	policies, err := db.duplicatePolicies(points)
+	if err != nil {
+		s.mu.RUnlock()
+		return err
+	}
+	s.mu.RUnlock()
+
+	// Register the points' fields on their measurements. Writes are checked
+	// for field type conflicts before they're published but concurrent writes
+	// can still create the same field with different types. Only the points
+	// that conflict are dropped so the rest of a group commit is written.
+	valid := make([][]byte, 0, len(points))
+	for _, data := range points {
+		if err := s.createFieldsIfNotExists(db, data); err == ErrFieldTypeConflict || err == ErrFieldOverflow {
+			s.Logger.Printf("write series: drop point in shard %d: %s", topicID, err)
+			continue
+		} else if err != nil {
+			return err
+		}
+		valid = append(valid, data)
+	}
+	points = valid
+
+	// Sync the fields created by the points to disk once for the batch.
+	s.mu.Lock()
+	err = s.syncIndex(db)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Write to shard.
+	dropped, err := sh.writeSeries(policies, index, points)
+	if err != nil {
+		return err
+	}
+	if dropped > 0 {
+		s.databaseStats(name).Add(StatDuplicatesDropped, int64(dropped))
+	}
+
+	// Remove cached results that read from the shard.
+	s.resultCache.invalidateShard(topicID)
+
+	// Send the points to any tails of the database.
+	s.notifyTails(db, points)
+
+	return nil
+}
+
+// createFieldsIfNotExists adds any new fields in an encoded point to the
+// point's measurement. Fields are assigned ids locally by each server.
+func (s *Server) createFieldsIfNotExists(db *database, data []byte) error {
+	id, _, err := unmarshalPointHeader(data)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Ignore if the server was closed while the point was being applied.
+	if !s.opened() {
+		return ErrServerClosed
+	}
+
+	// Find the measurement for the series.
+	m := db.MeasurementBySeriesID(id)
+	if m == nil {
+		return ErrSeriesNotFound
+	}
+
+	// Create any fields that don't exist yet.
+	n := len(m.Fields)
+	if err := valueTypes(data[pointHeaderSize:], func(key string, typ influxql.DataType) error {
+		_, err := m.createFieldIfNotExists(key, typ)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	// Persist to the index storage if fields were added.
+	if len(m.Fields) == n {
+		return nil
+	}
+	return s.saveMeasurement(db, m)
+}
+
+func (s *Server) createSeriesIfNotExists(database, name string, tags map[string]string) (uint32, error) {
+	// Try to find series locally first.
+	s.mu.RLock()
+	idx := s.databases[database]
+	if _, series := idx.MeasurementAndSeries(name, tags); series != nil {
+		s.mu.RUnlock()
+		return series.ID, nil
+	}
+	// release the read lock so the broadcast can actually go through and acquire the write lock
+	s.mu.RUnlock()
+
+	// If it doesn't exist then create a message and broadcast.
+	c := &createSeriesIfNotExistsCommand{Database: database, Name: name, Tags: tags}
+	_, err := s.broadcast(createSeriesIfNotExistsMessageType, c)
+	if err != nil {
+		return 0, err
+	}
+
+	// Lookup series again.
+	_, series := idx.MeasurementAndSeries(name, tags)
+	if series == nil {
+		return 0, ErrSeriesNotFound
+	}
+	return series.ID, nil
+}
+
+func (s *Server) MeasurementNames(database string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return nil
+	}
+
+	return db.index().names
+}
+
+func (s *Server) MeasurementSeriesIDs(database, measurement string) SeriesIDs {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return nil
+	}
+
+	return db.SeriesIDs([]string{measurement}, nil)
+}
+
+// QueryOptions represents per-query settings for executing a query.
+type QueryOptions struct {
+	// If true, the execution plan and per-stage statistics are
+	// returned with the results of each statement.
+	Trace bool
+
+	// The id of the request that issued the query. It is included in
+	// the query log so that it can be correlated with other logs.
+	RequestID string
+
+	// The maximum number of points each statement can read.
+	// A value of zero means that there is no limit.
+	MaxPointsScanned int
+
+	// The address of the client that issued the query. It is recorded in
+	// the audit log for statements that change metadata.
+	RemoteAddr string
+
+	// The retention policy that statements read from. If blank, statements
+	// read from the default retention policy if the database has downsampling
+	// rules and from every retention policy otherwise.
+	RetentionPolicy string
+
+	// If true and no retention policy is set, select statements may read from
+	// downsampled retention policies instead of the default retention policy.
+	Rollup bool
+
+	// The maximum number of bytes each statement can allocate.
+	// A value of zero means that there is no limit.
+	MaxMemory int64
+
+	// The maximum number of values returned for each series of a select
+	// statement. Series with more values are truncated and marked as partial.
+	// A value of zero means that there is no limit.
+	MaxRowLimit int
+
+	// If set, statements only read points written at or before this broker
+	// index, as returned by Index, so that related queries read the same
+	// data while writes continue. Points overwritten since the snapshot are
+	// not read.
+	Snapshot uint64
+
+	// Statements stop reading shards once the context is done, such as when
+	// the client disconnects or the request's deadline passes, and remaining
+	// statements are not executed. A nil context is never done.
+	Context context.Context
+
+	// If set, select statements return at most this many values. If more
+	// values remain, the result has a token that NextPage reads the next
+	// page from without executing the statement again. Statements with more
+	// values keep executing after the context is done until their cursor is
+	// read to the end or expires. Traced statements are not paged.
+	PageSize int
+}
+
+// ctx returns the options' context or a context that is never done.
+func (opt *QueryOptions) ctx() context.Context {
+	if opt.Context == nil {
+		return context.Background()
+	}
+	return opt.Context
+}
+
+// queryContextErr returns the error for a statement stopped by its context.
+// Other errors are returned as is.
+func queryContextErr(err error) error {
+	switch err {
+	case context.DeadlineExceeded:
+		return ErrQueryTimeout
+	case context.Canceled:
+		return ErrQueryCanceled
+	}
+	return err
+}
+
+// ExecuteQuery executes an InfluxQL query against a database.
+// Returns a result for each statement in the query, in order. A failed
+// statement does not prevent the remaining statements from executing.
+func (s *Server) ExecuteQuery(q *influxql.Query, database string, user *User, opt QueryOptions) Results {
+	results := make(Results, len(q.Statements))
+
+	// Reject the query if the server is shutting down.
+	if err := s.begin(); err != nil {
+		for i := range results {
+			results[i] = &Result{StatementID: i, Err: err}
+		}
+		return results
+	}
+	defer s.end()
+
+	for i, stmt := range q.Statements {
+		// Capture the statement text first since planning modifies the statement.
+		text := stmt.String()
+		start := time.Now()
+		rq := s.startQuery(database, user, text, opt.MaxMemory)
+
+		if err := opt.ctx().Err(); err != nil {
+			results[i] = &Result{Err: err}
+		} else {
+			results[i] = s.executeStatement(stmt, database, user, opt, rq)
+		}
+		results[i].Err = queryContextErr(results[i].Err)
+		results[i].StatementID = i
+
+		// Statements with more pages finish when their cursor is closed.
+		if results[i].Next == "" {
+			s.finishQuery(rq)
+		}
+
+		// Record successful metadata changes in the audit log.
+		if action, target := auditAction(stmt, database); action != "" && results[i].Err == nil {
+			s.audit(user, opt.RemoteAddr, action, target)
+		}
+
+		// Update the database statistics.
+		if st := s.DatabaseStats(database); st != nil {
+			st.Add(StatQueriesExecuted, 1)
+			if results[i].Err != nil {
+				st.Add(StatQueryErrors, 1)
+			}
+		}
+
+		s.logQuery(opt.RequestID, database, text, results[i], time.Since(start))
+	}
+	return results
+}
+
+// executeStatement executes a single statement against a database.
+func (s *Server) executeStatement(stmt influxql.Statement, database string, user *User, opt QueryOptions, rq *runningQuery) *Result {
+	// Users authenticated with a token must be able to read the database.
+	// Select statements check each measurement they read as they are planned
+	// while other statements need to be able to read every measurement.
+	if !user.Authorize(influxql.ReadPrivilege, database) {
+		return &Result{Err: ErrReadAccessDenied}
+	}
+	switch stmt.(type) {
+	case *influxql.SelectStatement, *influxql.ExplainStatement:
+	default:
+		if !user.AuthorizeDatabase(influxql.ReadPrivilege, database) {
+			return &Result{Err: ErrReadAccessDenied}
+		}
+	}
+
+	// Read the database's index so that an error reading it fails the statement.
+	if err := s.loadIndex(database); err != nil {
+		return &Result{Err: err}
+	}
+
+	switch stmt := stmt.(type) {
+	case *influxql.SelectStatement:
+		return s.executeSelectStatement(stmt, database, user, opt, rq)
+	case *influxql.ExplainStatement:
+		return s.executeExplainStatement(stmt, database, user, opt, rq)
+	case *influxql.ShowStatsStatement:
+		return s.executeShowStatsStatement(stmt, database, user)
+	case *influxql.ShowQueriesStatement:
+		return s.executeShowQueriesStatement(user)
+	case *influxql.ShowDeletionsStatement:
+		return s.executeShowDeletionsStatement(database, user)
+	case *influxql.AlterDatabaseStatement:
+		return s.executeAlterDatabaseStatement(stmt, user)
+	case *influxql.AlterRetentionPolicyStatement:
+		return s.executeAlterRetentionPolicyStatement(stmt, user)
+	case *influxql.DeleteStatement:
+		return s.executeDeleteStatement(stmt, database, user)
+	case *influxql.ShowAuditStatement:
+		return s.executeShowAuditStatement(stmt, user)
+	case *influxql.ListSeriesStatement:
+		return s.executeListSeriesStatement(stmt, database)
+	case *influxql.ListTagValuesStatement:
+		return s.executeListTagValuesStatement(stmt, database)
+	default:
+		return &Result{Err: ErrInvalidQuery}
+	}
+}
+
+// logQuery writes a log line for an executed statement.
+func (s *Server) logQuery(requestID, database, stmt string, r *Result, d time.Duration) {
+	msg := fmt.Sprintf("query id=%s db=%q series=%d duration=%s stmt=%q", requestID, database, len(r.Rows), d, stmt)
+	if r.Err != nil {
+		msg += fmt.Sprintf(" err=%q", r.Err.Error())
+	}
+	s.Logger.Println(msg)
+}
+
+// executeShowStatsStatement returns a row of statistics for each database.
+// Statements for a single database also return its quota usage. Non-admin
+// users only see the statistics for the current database.
+func (s *Server) executeShowStatsStatement(stmt *influxql.ShowStatsStatement, database string, user *User) *Result {
+	names := s.Databases()
+	if stmt.Database != "" {
+		if user != nil && !user.Admin && stmt.Database != database {
+			return &Result{Err: ErrReadAccessDenied}
+		} else if !s.DatabaseExists(stmt.Database) {
+			return &Result{Err: ErrDatabaseNotFound}
+		}
+		names = []string{stmt.Database}
+	} else if user != nil && !user.Admin {
+		names = []string{database}
+	}
+
+	var rows []*influxql.Row
+	for _, name := range names {
+		st := s.DatabaseStats(name)
+		if st == nil {
+			continue
+		}
+
+		row := &influxql.Row{
+			Name:    "database",
+			Tags:    map[string]string{"database": name},
+			Columns: databaseStatNames,
+		}
+		values := make([]interface{}, len(databaseStatNames))
+		for i, k := range databaseStatNames {
+			values[i] = st.Get(k)
+		}
+		if stmt.Database != "" {
+			row.Columns = append(append([]string{}, databaseStatNames...), quotaStatNames...)
+			values = append(values, s.quotaStats(name)...)
+		}
+		row.Values = append(row.Values, values)
+		rows = append(rows, row)
+	}
+
+	return &Result{Rows: rows}
+}
+
+// executeAlterDatabaseStatement renames a database or updates its flags.
+// Requires an admin user.
+func (s *Server) executeAlterDatabaseStatement(stmt *influxql.AlterDatabaseStatement, user *User) *Result {
+	if user != nil && !user.Admin {
+		return &Result{Err: ErrAdminRequired}
+	}
+	if stmt.NewName != "" {
+		return &Result{Err: s.RenameDatabase(stmt.Name, stmt.NewName)}
+	}
+	return &Result{Err: s.UpdateDatabase(stmt.Name, &DatabaseUpdate{ReadOnly: stmt.ReadOnly, Disabled: stmt.Disabled})}
+}
+
+// executeAlterRetentionPolicyStatement updates and renames a retention policy.
+// The policy is set as the default after it has been renamed. Requires an admin user.
+func (s *Server) executeAlterRetentionPolicyStatement(stmt *influxql.AlterRetentionPolicyStatement, user *User) *Result {
+	if user != nil && !user.Admin {
+		return &Result{Err: ErrAdminRequired}
+	}
+
+	c := &updateRetentionPolicyCommand{Database: stmt.Database, Name: stmt.Name, NewName: stmt.NewName, Duration: stmt.Duration}
+	if stmt.Replication != nil {
+		n := uint32(*stmt.Replication)
+		c.ReplicaN = &n
+	}
+	if _, err := s.broadcast(updateRetentionPolicyMessageType, c); err != nil {
+		return &Result{Err: err}
+	}
+
+	if stmt.Default {
+		name := stmt.Name
+		if stmt.NewName != "" {
+			name = stmt.NewName
+		}
+		if err := s.SetDefaultRetentionPolicy(stmt.Database, name); err != nil {
+			return &Result{Err: err}
+		}
+	}
+	return &Result{}
+}
+
+// executeDeleteStatement removes the points before the time in the statement's
+// condition from a measurement, or from every measurement in the database.
+// Requires an admin user.
+func (s *Server) executeDeleteStatement(stmt *influxql.DeleteStatement, database string, user *User) *Result {
+	if user != nil && !user.Admin {
+		return &Result{Err: ErrAdminRequired}
+	}
+
+	// Read the measurement to delete from, if set.
+	var name string
+	if stmt.Source != nil {
+		m, ok := stmt.Source.(*influxql.Measurement)
+		if !ok {
+			return &Result{Err: ErrInvalidQuery}
+		}
+		name = m.Name
+	}
+
+	before, err := deleteTimeBound(stmt.Condition, time.Now().UTC())
+	if err != nil {
+		return &Result{Err: err}
+	}
+	if err := s.DeletePoints(database, name, before); err != nil {
+		return &Result{Err: err}
+	}
+	return &Result{}
+}
+
+// deleteTimeBound returns the time that points are deleted before from a
+// condition made up of upper bounds on time, such as "time < now() - 90d".
+// Returns ErrInvalidDeleteCondition for any other condition.
+func deleteTimeBound(cond influxql.Expr, now time.Time) (before time.Time, err error) {
+	var walk func(expr influxql.Expr) error
+	walk = func(expr influxql.Expr) error {
+		switch expr := expr.(type) {
+		case *influxql.ParenExpr:
+			return walk(expr.Expr)
+		case *influxql.BinaryExpr:
+			if expr.Op == influxql.AND {
+				if err := walk(expr.LHS); err != nil {
+					return err
+				}
+				return walk(expr.RHS)
+			}
+
+			ref, ok := expr.LHS.(*influxql.VarRef)
+			if !ok || strings.ToLower(ref.Val) != "time" {
+				return ErrInvalidDeleteCondition
+			}
+			lit, ok := expr.RHS.(*influxql.TimeLiteral)
+			if !ok {
+				return ErrInvalidDeleteCondition
+			}
+
+			t := lit.Val
+			switch expr.Op {
+			case influxql.LT:
+			case influxql.LTE:
+				t = t.Add(time.Nanosecond)
+			default:
+				return ErrInvalidDeleteCondition
+			}
+			if before.IsZero() || t.Before(before) {
+				before = t
+			}
+			return nil
+		default:
+			return ErrInvalidDeleteCondition
+		}
+	}
+
+	if cond == nil {
+		return time.Time{}, ErrInvalidDeleteCondition
+	} else if err := walk(influxql.Fold(influxql.CloneExpr(cond), &now)); err != nil {
+		return time.Time{}, err
+	}
+	return before, nil
+}
+
+// executeSelectStatement plans and executes a select statement and returns all rows.
+// Results for time ranges that ended long enough ago are served from the result cache.
+func (s *Server) executeSelectStatement(stmt *influxql.SelectStatement, database string, user *User, opt QueryOptions, rq *runningQuery) *Result {
+	// Paged statements keep executing after the request returns so they
+	// stop with their cursor instead of the request's context.
+	if opt.PageSize > 0 && !opt.Trace {
+		return s.executePagedSelectStatement(stmt, database, user, opt, rq)
+	}
+
+	// Capture the statement text first since planning modifies the statement.
+	text := stmt.String()
+	e, q, _, err := s.planAndExecute(stmt, database, user, opt, rq, false)
+	if err != nil {
+		return &Result{Err: err}
+	}
+
+	// Report the resolution of the retention policy read by rollup queries.
+	var resolution *Resolution
+	if opt.Rollup && q.rp != nil {
+		resolution = newResolution(q.rp)
+	}
+
+	// Return cached rows, if available. Traced statements and snapshot reads
+	// are always executed.
+	min, max := e.TimeRange()
+	cacheable := !opt.Trace && opt.Snapshot == 0 && s.resultCache.cacheable(max, time.Now())
+	key := resultCacheKey(text, min, max, database, opt)
+	if cacheable {
+		if rows, ok := s.resultCache.get(key); ok {
+			s.addResultCacheStat(database, StatResultCacheHits)
+			return &Result{Rows: rows, Resolution: resolution}
+		}
+		s.addResultCacheStat(database, StatResultCacheMisses)
+	}
+
+	// Execute the statement. Results are only cached if no shards are
+	// changed between reading the cache generation and finishing execution.
+	gen := s.resultCache.generation()
+	ch, shardIDs, err := s.execute(e, q)
+	if err != nil {
+		return &Result{Err: err}
+	}
+
+	// Read all rows from the executor. Values over the row limit are dropped.
+	var rows []*influxql.Row
+	for row := range ch {
+		if opt.MaxRowLimit > 0 && len(row.Values) > opt.MaxRowLimit {
+			row.Values, row.Partial = row.Values[:opt.MaxRowLimit:opt.MaxRowLimit], true
+		}
+		rows = append(rows, row)
+		rq.alloc(rowSize(row))
+	}
+
+	// Discard partial results if execution was halted or a shard couldn't be read.
+	if err := e.Err(); err != nil {
+		return &Result{Err: err}
+	} else if err := q.Err(); err != nil {
+		return &Result{Err: err}
+	} else if rq.limitExceeded() {
+		return &Result{Err: ErrQueryMemoryExceeded}
+	}
+	result := &Result{Rows: rows, Resolution: resolution}
+
+	// Save the rows for repeated statements.
+	if cacheable {
+		s.resultCache.add(key, database, shardIDs, rows, gen)
+	}
+
+	// Include the execution plan if tracing is enabled.
+	if opt.Trace {
+		result.Trace = s.plan(e, q)
+	}
+
+	return result
+}
+
+// executePagedSelectStatement executes a select statement and returns its
+// first page of values. Pages are not cached.
+func (s *Server) executePagedSelectStatement(stmt *influxql.SelectStatement, database string, user *User, opt QueryOptions, rq *runningQuery) *Result {
+	ctx, cancel := context.WithCancel(context.Background())
+	opt.Context = ctx
+	e, q, ch, err := s.planAndExecute(stmt, database, user, opt, rq, true)
+	if err != nil {
+		cancel()
+		return &Result{Err: err}
+	}
+
+	c := &queryCursor{
+		ch:          ch,
+		e:           e,
+		q:           q,
+		rq:          rq,
+		cancel:      cancel,
+		pageSize:    opt.PageSize,
+		maxRowLimit: opt.MaxRowLimit,
+	}
+	if user != nil {
+		c.user = user.Name
+	}
+	if opt.Rollup && q.rp != nil {
+		c.resolution = newResolution(q.rp)
+	}
+	return s.openCursor(c)
+}
+
+// executeExplainStatement returns the execution plan for a statement as a row.
+// If the statement is analyzed then it is executed and its results are discarded.
+func (s *Server) executeExplainStatement(stmt *influxql.ExplainStatement, database string, user *User, opt QueryOptions, rq *runningQuery) *Result {
+	e, q, ch, err := s.planAndExecute(stmt.Statement, database, user, opt, rq, stmt.Analyze)
+	if err != nil {
+		return &Result{Err: err}
+	}
+
+	// Drain the rows so that the statistics are complete.
+	if ch != nil {
+		for _ = range ch {
+		}
+	}
+	if err := q.Err(); err != nil {
+		return &Result{Err: err}
+	} else if rq.limitExceeded() {
+		return &Result{Err: ErrQueryMemoryExceeded}
+	}
+
+	return &Result{Rows: []*influxql.Row{s.plan(e, q).Row(stmt.Analyze)}}
+}
+
+// SetQueryConcurrency sets the maximum number of shards read at once for each
+// series in a query. Values less than one default to the number of CPUs.
+func (s *Server) SetQueryConcurrency(n int) {
+	if n < 1 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queryConcurrency = n
+}
+
+// planAndExecute creates an executor for a select statement on a database.
+// Memory allocated while reading shards is recorded on the running query and
+// planning fails if the user can't read one of the statement's measurements.
+// If execute is false then the statement is only planned and no channel is returned.
+func (s *Server) planAndExecute(stmt *influxql.SelectStatement, database string, user *User, opt QueryOptions, rq *runningQuery, execute bool) (*influxql.Executor, *dbq, <-chan *influxql.Row, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Find the database.
+	db := s.databases[database]
+	if db == nil {
+		return nil, nil, nil, ErrDatabaseNotFound
+	}
+
+	// Restrict reads to the retention policy, if set.
+	q := &dbq{db: db, concurrency: s.queryConcurrency, query: rq, ctx: opt.ctx(), snapshot: opt.Snapshot}
+	if opt.RetentionPolicy != "" {
+		if q.rp = db.policies[opt.RetentionPolicy]; q.rp == nil {
+			return nil, nil, nil, ErrRetentionPolicyNotFound
+		}
+	}
+
+	// Plan the statement.
+	p := influxql.NewPlanner(q)
+	p.MaxPointsScanned = opt.MaxPointsScanned
+	p.MaxRowLimit = opt.MaxRowLimit
+	p.Context = q.ctx
+	p.Authorize = func(name string) error {
+		if !user.AuthorizeMeasurement(influxql.ReadPrivilege, database, name) {
+			return ErrReadAccessDenied
+		}
+		return nil
+	}
+	e, err := p.Plan(stmt)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Choose the retention policy to read from if the database has
+	// downsampling rules and none was set.
+	if opt.RetentionPolicy == "" {
+		min, _ := e.TimeRange()
+		q.rp = db.readPolicy(stmt, min, e.Interval(), time.Now(), opt.Rollup)
+	}
+	if !execute {
+		return e, q, nil, nil
+	}
+
+	// Begin execution. Iterators are created while the lock is held.
+	ch, err := e.Execute()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return e, q, ch, nil
+}
+
+// execute begins execution of a planned statement and returns the ids of the
+// shards it reads from.
+func (s *Server) execute(e *influxql.Executor, q *dbq) (<-chan *influxql.Row, []uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Ignore if the database was dropped after the statement was planned.
+	if s.databases[q.db.name] != q.db {
+		return nil, nil, ErrDatabaseNotFound
+	}
+
+	var shardIDs []uint64
+	for _, sh := range q.shards(e.TimeRange()) {
+		shardIDs = append(shardIDs, sh.ID)
+	}
+
+	// Iterators are created while the lock is held.
+	ch, err := e.Execute()
+	if err != nil {
+		return nil, nil, err
+	}
+	return ch, shardIDs, nil
+}
+
+// plan returns the execution plan for an executor, including the shards it reads.
+// Once executed, each shard reports the points it returned, the time spent
+// reading it and the time its reads waited for a worker.
+func (s *Server) plan(e *influxql.Executor, q *dbq) *influxql.PlanNode {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := e.Plan()
+	min, max := e.TimeRange()
+	shards := &influxql.PlanNode{Name: "shards"}
+	for _, sh := range q.shards(min, max) {
+		n := &influxql.PlanNode{
+			Name:   "shard",
+			Detail: fmt.Sprintf("id=%d %s - %s", sh.ID, sh.StartTime.Format(time.RFC3339), sh.EndTime.Format(time.RFC3339)),
+		}
+		if sp := q.span(sh.ID); sp != nil {
+			n.Detail += fmt.Sprintf(" series=%d bytes=%d queue=%s", sp.series, sp.bytes, sp.queue)
+			n.Rows, n.Duration = sp.points, sp.scan
+		}
+		shards.Children = append(shards.Children, n)
+	}
+	shards.Rows = len(shards.Children)
+	n.Children = append([]*influxql.PlanNode{shards}, n.Children...)
+	return n
+}
+
+// Result represents a resultset returned from a single statement.
+type Result struct {
+	StatementID int // index of the statement in the query
+	Rows        []*influxql.Row
+	Trace       *influxql.PlanNode
+	Resolution  *Resolution // set for rollup queries on databases with downsampling rules
+	Next        string      // token of the next page, set if more values remain
+	Err         error
+}
+
+// MarshalJSON encodes the result into JSON.
+func (r *Result) MarshalJSON() ([]byte, error) {
+	// Define a struct that outputs "error" as a string.
+	var o struct {
+		StatementID int                `json:"statement_id"`
+		Rows        []*influxql.Row    `json:"rows,omitempty"`
+		Trace       *influxql.PlanNode `json:"trace,omitempty"`
+		Resolution  *Resolution        `json:"resolution,omitempty"`
+		Next        string             `json:"next,omitempty"`
+		Err         string             `json:"error,omitempty"`
+	}
+
+	// Copy fields to output struct.
+	o.StatementID = r.StatementID
+	o.Rows = r.Rows
+	o.Trace = r.Trace
+	o.Resolution = r.Resolution
+	o.Next = r.Next
+	if r.Err != nil {
+		o.Err = r.Err.Error()
+	}
+
+	return json.Marshal(&o)
+}
+
+// Results represents a list of statement results.
+type Results []*Result
+
+// processor runs in a separate goroutine and processes all incoming broker messages.
+func (s *Server) processor(client MessagingClient, done chan struct{}) {
+	for {
+		// Read incoming message.
+		var m *messaging.Message
+		select {
+		case <-done:
+			return
+		case m = <-client.C():
 		}
 
 		// Process message.
@@ -1214,20 +3138,48 @@ func (s *Server) processor(client MessagingClient, done chan struct{}) {
 		switch m.Type {
 		case writeSeriesMessageType:
 			err = s.applyWriteSeries(m)
+		case writeSeriesBatchMessageType:
+			err = s.applyWriteSeriesBatch(m)
 		case createDataNodeMessageType:
 			err = s.applyCreateDataNode(m)
 		case deleteDataNodeMessageType:
 			err = s.applyDeleteDataNode(m)
+		case setDataNodeRoleMessageType:
+			err = s.applySetDataNodeRole(m)
 		case createDatabaseMessageType:
 			err = s.applyCreateDatabase(m)
 		case deleteDatabaseMessageType:
 			err = s.applyDeleteDatabase(m)
+		case renameDatabaseMessageType:
+			err = s.applyRenameDatabase(m)
+		case updateDatabaseMessageType:
+			err = s.applyUpdateDatabase(m)
+		case setMeasurementSchemaMessageType:
+			err = s.applySetMeasurementSchema(m)
+		case deleteMeasurementSchemaMessageType:
+			err = s.applyDeleteMeasurementSchema(m)
+		case setTagGuardMessageType:
+			err = s.applySetTagGuard(m)
+		case deleteTagGuardMessageType:
+			err = s.applyDeleteTagGuard(m)
+		case setAlertRuleMessageType:
+			err = s.applySetAlertRule(m)
+		case deleteAlertRuleMessageType:
+			err = s.applyDeleteAlertRule(m)
+		case setRoutingRuleMessageType:
+			err = s.applySetRoutingRule(m)
+		case deleteRoutingRuleMessageType:
+			err = s.applyDeleteRoutingRule(m)
 		case createUserMessageType:
 			err = s.applyCreateUser(m)
 		case updateUserMessageType:
 			err = s.applyUpdateUser(m)
 		case deleteUserMessageType:
 			err = s.applyDeleteUser(m)
+		case createTokenMessageType:
+			err = s.applyCreateToken(m)
+		case deleteTokenMessageType:
+			err = s.applyDeleteToken(m)
 		case createRetentionPolicyMessageType:
 			err = s.applyCreateRetentionPolicy(m)
 		case updateRetentionPolicyMessageType:
@@ -1236,8 +3188,16 @@ func (s *Server) processor(client MessagingClient, done chan struct{}) {
 			err = s.applyDeleteRetentionPolicy(m)
 		case createShardIfNotExistsMessageType:
 			err = s.applyCreateShardIfNotExists(m)
+		case deleteShardMessageType:
+			err = s.applyDeleteShard(m)
+		case deletePointsMessageType:
+			err = s.applyDeletePoints(m)
+		case purgeDeletionMessageType:
+			err = s.applyPurgeDeletion(m)
 		case setDefaultRetentionPolicyMessageType:
 			err = s.applySetDefaultRetentionPolicy(m)
+		case updateDownsampleMessageType:
+			err = s.applyUpdateDownsample(m)
 		case createSeriesIfNotExistsMessageType:
 			err = s.applyCreateSeriesIfNotExists(m)
 		}
@@ -1263,13 +3223,32 @@ type MessagingClient interface {
 
 // DataNode represents a data node in the cluster.
 type DataNode struct {
-	ID  uint64
-	URL *url.URL
+	ID   uint64
+	URL  *url.URL
+	Role string
 }
 
+const (
+	// DataNodeRoleData is the role of a node that accepts writes and queries.
+	DataNodeRoleData = "data"
+
+	// DataNodeRoleQuery is the role of a node that holds shard replicas for
+	// queries but is excluded from write targets, so that heavy queries can
+	// be isolated from the ingest path.
+	DataNodeRoleQuery = "query"
+)
+
 // newDataNode returns an instance of DataNode.
 func newDataNode() *DataNode { return &DataNode{} }
 
+// QueryOnly returns true if the node doesn't accept writes.
+func (n *DataNode) QueryOnly() bool { return n.Role == DataNodeRoleQuery }
+
+// isValidDataNodeRole returns true if role is a known data node role.
+func isValidDataNodeRole(role string) bool {
+	return role == DataNodeRoleData || role == DataNodeRoleQuery
+}
+
 type dataNodes []*DataNode
 
 func (p dataNodes) Len() int           { return len(p) }
@@ -1282,10 +3261,18 @@ var BcryptCost = 10
 
 // User represents a user account on the system.
 // It can be given read/write permissions to individual databases.
+//
+// Grants use the syntax of token scopes, such as "read:db" or "read:db:cpu*".
+// A user without grants has all privileges; otherwise the user only has the
+// privileges in its grants.
 type User struct {
-	Name  string `json:"name"`
-	Hash  string `json:"hash"`
-	Admin bool   `json:"admin,omitempty"`
+	Name   string   `json:"name"`
+	Hash   string   `json:"hash"`
+	Admin  bool     `json:"admin,omitempty"`
+	Grants []string `json:"grants,omitempty"`
+	Tokens []*Token `json:"tokens,omitempty"`
+
+	token *Token // set if authenticated with an API token
 }
 
 // Authenticate returns nil if the password matches the user's password.
@@ -1,17 +1,28 @@
 package influxdb_test
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
+	"math"
+	"net"
 	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"code.google.com/p/go.crypto/bcrypt"
 	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/influxql"
 	"github.com/influxdb/influxdb/messaging"
 )
 
@@ -27,6 +38,60 @@ func TestServer_Open(t *testing.T) {
 	}
 }
 
+// Ensure the data is only verified on startup if the server wasn't shut
+// down cleanly and that a partial index record is truncated by recovery.
+func TestServer_Open_Recovery(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	var buf bytes.Buffer
+	s.Logger = log.New(&buf, "", 0)
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	typ := influxdb.DiskIndex
+	s.UpdateDatabase("foo", &influxdb.DatabaseUpdate{Index: &typ})
+	s.WriteSeries("foo", "raw", "cpu", map[string]string{"host": "a"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": float64(1)})
+	s.Sync(c.index)
+
+	// A clean restart skips recovery and removes the marker.
+	marker := filepath.Join(s.Path(), "clean")
+	s.Restart()
+	if strings.Contains(buf.String(), "recovery") {
+		t.Fatalf("unexpected recovery: %s", buf.String())
+	} else if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Fatalf("marker not removed: %v", err)
+	}
+
+	// Simulate a crash during an index write by removing the marker and
+	// leaving a partial record at the end of the index log.
+	path := s.Path()
+	if err := s.Server.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := os.Remove(marker); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := os.OpenFile(filepath.Join(path, "indexes", "foo"), os.O_WRONLY|os.O_APPEND, 0600)
+	f.Write([]byte{0, 0, 1})
+	f.Close()
+	if err := s.Server.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	s.SetClient(c)
+	if !regexp.MustCompile(`recovery: checked shard \d+ \(1/1\)\n`).MatchString(buf.String()) ||
+		!strings.Contains(buf.String(), "recovery: checked 1 shards and 1 indexes in ") ||
+		!strings.Contains(buf.String(), ", 0 problems found") {
+		t.Fatalf("unexpected log: %s", buf.String())
+	}
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 00:01:00" GROUP BY host`), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if s := mustMarshalJSON(results[0].Rows); s != `[{"name":"cpu","tags":{"host":"a"},"columns":["time","sum"],"values":[[946684800000000,1]]}]` {
+		t.Fatalf("unexpected rows: %s", s)
+	}
+}
+
 // Ensure an error is returned when opening an already open server.
 func TestServer_Open_ErrServerOpen(t *testing.T) { t.Skip("pending") }
 
@@ -70,6 +135,40 @@ func TestServer_CreateDatabase_ErrDataNodeExists(t *testing.T) {
 	}
 }
 
+// Ensure the server can change the role of a node.
+func TestServer_SetDataNodeRole(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Nodes are created with the data role.
+	u, _ := url.Parse("http://localhost:80000")
+	if err := s.Initialize(u); err != nil {
+		t.Fatal(err)
+	} else if n := s.DataNode(1); n.Role != influxdb.DataNodeRoleData {
+		t.Fatalf("unexpected role: %s", n.Role)
+	} else if s.QueryOnly() {
+		t.Fatal("unexpected query-only server")
+	}
+
+	// Change the role and verify it persists.
+	if err := s.SetDataNodeRole(1, influxdb.DataNodeRoleQuery); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+	if n := s.DataNode(1); n.Role != influxdb.DataNodeRoleQuery || !n.QueryOnly() {
+		t.Fatalf("unexpected role: %s", n.Role)
+	} else if !s.QueryOnly() {
+		t.Fatal("expected query-only server")
+	}
+
+	// Unknown roles and nodes are rejected.
+	if err := s.SetDataNodeRole(1, "ingest"); err != influxdb.ErrInvalidDataNodeRole {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.SetDataNodeRole(100, influxdb.DataNodeRoleData); err != influxdb.ErrDataNodeNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 // Ensure the server can delete a node.
 func TestServer_DeleteDataNode(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -156,6 +255,136 @@ func TestServer_DropDatabase_ErrDatabaseNotFound(t *testing.T) {
 	}
 }
 
+// Ensure the server can rename a database and keep its data and series.
+func TestServer_RenameDatabase(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.WriteSeries("foo", "raw", "cpu", map[string]string{"region": "us"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": float64(1)})
+	s.Sync(c.index)
+
+	// Rename the database.
+	results := s.ExecuteQuery(MustParseQuery(`ALTER DATABASE foo RENAME TO bar`), "", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if s.DatabaseExists("foo") || !s.DatabaseExists("bar") {
+		t.Fatalf("database not renamed: %v", s.Databases())
+	}
+	s.Restart()
+
+	// Write a new series and verify both series are read from the renamed database.
+	s.WriteSeries("bar", "raw", "cpu", map[string]string{"region": "eu"}, mustParseTime("2000-01-01T00:00:10Z"), map[string]interface{}{"value": float64(2)})
+	s.Sync(c.index)
+
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 00:01:00" GROUP BY time(1m), region`), "bar", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if s := mustMarshalJSON(results[0].Rows); s != `[{"name":"cpu","tags":{"region":"us"},"columns":["time","sum"],"values":[[946684800000000,1]]},{"name":"cpu","tags":{"region":"eu"},"columns":["time","sum"],"values":[[946684800000000,2]]}]` {
+		t.Fatalf("unexpected rows: %s", s)
+	}
+}
+
+// Ensure the server returns an error when renaming a database to an existing name.
+func TestServer_RenameDatabase_ErrDatabaseExists(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateDatabase("bar")
+	if err := s.RenameDatabase("foo", "bar"); err != influxdb.ErrDatabaseExists {
+		t.Fatal(err)
+	}
+	if err := s.RenameDatabase("no_such_db", "baz"); err != influxdb.ErrDatabaseNotFound {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server can move the series index of a database to disk and back
+// and keep its series across restarts.
+func TestServer_UpdateDatabase_Index(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.WriteSeries("foo", "raw", "cpu", map[string]string{"region": "us"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": float64(1)})
+	s.Sync(c.index)
+
+	// Move the index to disk.
+	typ := influxdb.DiskIndex
+	if err := s.UpdateDatabase("foo", &influxdb.DatabaseUpdate{Index: &typ}); err != nil {
+		t.Fatal(err)
+	} else if _, err := os.Stat(filepath.Join(s.Path(), "indexes", "foo")); err != nil {
+		t.Fatalf("index log not created: %s", err)
+	}
+	s.Restart()
+
+	// Write a new series to the disk index and restart again.
+	s.WriteSeries("foo", "raw", "cpu", map[string]string{"region": "eu"}, mustParseTime("2000-01-01T00:00:10Z"), map[string]interface{}{"value": float64(2)})
+	s.Sync(c.index)
+	s.Restart()
+
+	query := `SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 00:01:00" GROUP BY time(1m), region`
+	exp := `[{"name":"cpu","tags":{"region":"us"},"columns":["time","sum"],"values":[[946684800000000,1]]},{"name":"cpu","tags":{"region":"eu"},"columns":["time","sum"],"values":[[946684800000000,2]]}]`
+	results := s.ExecuteQuery(MustParseQuery(query), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if s := mustMarshalJSON(results[0].Rows); s != exp {
+		t.Fatalf("unexpected rows: %s", s)
+	}
+
+	// Move the index back into the metastore.
+	typ = influxdb.MemoryIndex
+	if err := s.UpdateDatabase("foo", &influxdb.DatabaseUpdate{Index: &typ}); err != nil {
+		t.Fatal(err)
+	} else if _, err := os.Stat(filepath.Join(s.Path(), "indexes", "foo")); !os.IsNotExist(err) {
+		t.Fatalf("index log not removed: %v", err)
+	}
+	s.Restart()
+
+	results = s.ExecuteQuery(MustParseQuery(query), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if s := mustMarshalJSON(results[0].Rows); s != exp {
+		t.Fatalf("unexpected rows: %s", s)
+	}
+
+	typ = "bad"
+	if err := s.UpdateDatabase("foo", &influxdb.DatabaseUpdate{Index: &typ}); err != influxdb.ErrInvalidIndexType {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the server can mark a database as read-only or disabled.
+func TestServer_ExecuteQuery_AlterDatabase(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	results := s.ExecuteQuery(MustParseQuery(`ALTER DATABASE foo READ ONLY; ALTER DATABASE foo DISABLE`), "foo", nil, influxdb.QueryOptions{})
+	for _, r := range results {
+		if r.Err != nil {
+			t.Fatalf("unexpected error: %s", r.Err)
+		}
+	}
+	s.Restart()
+
+	if !s.DatabaseReadOnly("foo") || !s.DatabaseDisabled("foo") {
+		t.Fatal("database flags not kept after restart")
+	}
+
+	// Enabling the database keeps it read-only.
+	results = s.ExecuteQuery(MustParseQuery(`ALTER DATABASE foo ENABLE`), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if !s.DatabaseReadOnly("foo") || s.DatabaseDisabled("foo") {
+		t.Fatal("unexpected database flags")
+	}
+}
+
 // Ensure the server can return a list of all databases.
 func TestServer_Databases(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -307,6 +536,244 @@ func TestServer_Users(t *testing.T) {
 	}
 }
 
+// Ensure the server can issue and authenticate API tokens.
+func TestServer_CreateToken(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateUser("susy", "pass", true)
+
+	// Create a token.
+	tok, token, err := s.CreateToken("susy", []string{"read:foo", "write:foo"})
+	if err != nil {
+		t.Fatal(err)
+	} else if tok.ID == "" || token == "" {
+		t.Fatalf("unexpected token: %#v, %q", tok, token)
+	}
+	s.Restart()
+
+	// Ensure the token is persisted without the token itself.
+	if a, err := s.Tokens("susy"); err != nil {
+		t.Fatal(err)
+	} else if len(a) != 1 || a[0].ID != tok.ID || a[0].Hash == token || !reflect.DeepEqual(a[0].Scopes, []string{"read:foo", "write:foo"}) {
+		t.Fatalf("unexpected tokens: %#v", a)
+	}
+
+	// Authenticate with the token. The user is limited to the token's scopes.
+	u, err := s.AuthenticateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	} else if u.Name != "susy" || u.Admin {
+		t.Fatalf("unexpected user: %#v", u)
+	} else if !u.Authorize(influxql.ReadPrivilege, "foo") || !u.Authorize(influxql.WritePrivilege, "foo") {
+		t.Fatal("expected foo privileges")
+	} else if u.Authorize(influxql.ReadPrivilege, "bar") {
+		t.Fatal("unexpected bar privilege")
+	}
+
+	if _, err := s.AuthenticateToken("xxx"); err != influxdb.ErrInvalidToken {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure the server rejects tokens with invalid scopes.
+func TestServer_CreateToken_ErrInvalidTokenScope(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateUser("susy", "pass", false)
+
+	if _, _, err := s.CreateToken("susy", nil); err != influxdb.ErrTokenScopesRequired {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, scope := range []string{"read", "read:", ":foo", "admin:foo", "read:foo:", "read:foo:cpu["} {
+		if _, _, err := s.CreateToken("susy", []string{scope}); err != influxdb.ErrInvalidTokenScope {
+			t.Fatalf("unexpected error(%s): %s", scope, err)
+		}
+	}
+	if _, _, err := s.CreateToken("bob", []string{"read:foo"}); err != influxdb.ErrUserNotFound {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure the server can revoke API tokens.
+func TestServer_DeleteToken(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateUser("susy", "pass", false)
+	tok, token, _ := s.CreateToken("susy", []string{"read:foo"})
+
+	if err := s.DeleteToken("susy", tok.ID); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+
+	if _, err := s.AuthenticateToken(token); err != influxdb.ErrInvalidToken {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := s.DeleteToken("susy", tok.ID); err != influxdb.ErrTokenNotFound {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure statements are only executed on databases in a token's scopes.
+func TestServer_ExecuteQuery_TokenScopes(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateDatabase("bar")
+	s.CreateUser("susy", "pass", true)
+	_, token, _ := s.CreateToken("susy", []string{"read:foo"})
+	u, _ := s.AuthenticateToken(token)
+
+	if res := s.ExecuteQuery(MustParseQuery(`SHOW STATS`), "foo", u, influxdb.QueryOptions{}); res[0].Err != nil {
+		t.Fatal(res[0].Err)
+	}
+	if res := s.ExecuteQuery(MustParseQuery(`SHOW STATS`), "bar", u, influxdb.QueryOptions{}); res[0].Err != influxdb.ErrReadAccessDenied {
+		t.Fatalf("unexpected error: %s", res[0].Err)
+	}
+	if res := s.ExecuteQuery(MustParseQuery(`ALTER DATABASE foo READ ONLY`), "foo", u, influxdb.QueryOptions{}); res[0].Err != influxdb.ErrAdminRequired {
+		t.Fatalf("unexpected error: %s", res[0].Err)
+	}
+}
+
+// Ensure select statements only read measurements in a token's scopes.
+func TestServer_ExecuteQuery_MeasurementScopes(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateUser("susy", "pass", false)
+	if err := s.WritePoints("foo", "raw", []*influxdb.Point{
+		{Name: "cpu_load", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": 1.0}},
+		{Name: "billing_total", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": 2.0}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.Sync(c.index)
+
+	_, token, _ := s.CreateToken("susy", []string{"read:foo:cpu*"})
+	u, _ := s.AuthenticateToken(token)
+	if !u.Authorize(influxql.ReadPrivilege, "foo") || u.AuthorizeDatabase(influxql.ReadPrivilege, "foo") {
+		t.Fatal("unexpected database privileges")
+	} else if !u.AuthorizeMeasurement(influxql.ReadPrivilege, "foo", "cpu_load") || u.AuthorizeMeasurement(influxql.ReadPrivilege, "foo", "billing_total") {
+		t.Fatal("unexpected measurement privileges")
+	}
+
+	if res := s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM cpu_load`), "foo", u, influxdb.QueryOptions{}); res[0].Err != nil {
+		t.Fatal(res[0].Err)
+	} else if v := res[0].Rows[0].Values[0][1]; fmt.Sprint(v) != "1" {
+		t.Fatalf("unexpected count: %v", v)
+	}
+	if res := s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM billing_total`), "foo", u, influxdb.QueryOptions{}); res[0].Err != influxdb.ErrReadAccessDenied {
+		t.Fatalf("unexpected error: %v", res[0].Err)
+	}
+
+	// Statements that aren't limited to a measurement need the whole database.
+	if res := s.ExecuteQuery(MustParseQuery(`LIST SERIES`), "foo", u, influxdb.QueryOptions{}); res[0].Err != influxdb.ErrReadAccessDenied {
+		t.Fatalf("unexpected error: %v", res[0].Err)
+	}
+}
+
+// Ensure select statements only read measurements granted to a user.
+func TestServer_ExecuteQuery_UserGrants(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateUser("susy", "pass", false)
+	if err := s.WritePoints("foo", "raw", []*influxdb.Point{
+		{Name: "cpu_load", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": 1.0}},
+		{Name: "billing_total", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": 2.0}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.Sync(c.index)
+
+	if err := s.SetUserGrants("susy", []string{"read:foo:cpu*"}); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetUserGrants("susy", []string{"read"}); err != influxdb.ErrInvalidTokenScope {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	u, err := s.Authenticate("susy", "pass")
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(u.Grants, []string{"read:foo:cpu*"}) {
+		t.Fatalf("unexpected grants: %v", u.Grants)
+	}
+
+	if res := s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM cpu_load`), "foo", u, influxdb.QueryOptions{}); res[0].Err != nil {
+		t.Fatal(res[0].Err)
+	}
+	if res := s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM billing_total`), "foo", u, influxdb.QueryOptions{}); res[0].Err != influxdb.ErrReadAccessDenied {
+		t.Fatalf("unexpected error: %v", res[0].Err)
+	}
+	if u.Authorize(influxql.WritePrivilege, "foo") {
+		t.Fatal("unexpected write privilege")
+	}
+
+	// Tokens can't exceed the user's grants.
+	_, token, _ := s.CreateToken("susy", []string{"read:foo"})
+	tu, _ := s.AuthenticateToken(token)
+	if res := s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM billing_total`), "foo", tu, influxdb.QueryOptions{}); res[0].Err != influxdb.ErrReadAccessDenied {
+		t.Fatalf("unexpected error: %v", res[0].Err)
+	}
+
+	// Removing the grants restores all privileges.
+	if err := s.SetUserGrants("susy", nil); err != nil {
+		t.Fatal(err)
+	} else if res := s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM billing_total`), "foo", u, influxdb.QueryOptions{}); res[0].Err != nil {
+		t.Fatal(res[0].Err)
+	}
+}
+
+// Ensure the server can filter points by timestamps in any supported format
+// and by comparing time with a field.
+func TestServer_ExecuteQuery_TimeConditions(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	deadline := float64(mustParseTime("2000-01-01T00:00:30Z").UnixNano())
+	if err := s.WritePoints("foo", "raw", []*influxdb.Point{
+		{Name: "jobs", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": 1.0, "deadline": deadline}},
+		{Name: "jobs", Timestamp: mustParseTime("2000-01-01T00:01:00Z"), Values: map[string]interface{}{"value": 2.0, "deadline": deadline}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.Sync(c.index)
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{q: `SELECT value FROM jobs WHERE time > deadline`, exp: `[2]`},
+		{q: `SELECT value FROM jobs WHERE deadline >= time`, exp: `[1]`},
+		{q: `SELECT value FROM jobs WHERE time >= '2000-01-01T01:00:30+01:00'`, exp: `[2]`},
+		{q: `SELECT value FROM jobs WHERE time < 946684830s`, exp: `[1]`},
+		{q: `SELECT value FROM jobs WHERE time < 946684830000000000`, exp: `[1]`},
+		{q: `SELECT value FROM jobs WHERE time >= '2000-01-01' AND time < '2000-01-01 00:02:00' - 1m - 30s`, exp: `[1]`},
+	} {
+		res := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil, influxdb.QueryOptions{})
+		if res[0].Err != nil {
+			t.Errorf("%d. %s: %s", i, tt.q, res[0].Err)
+			continue
+		}
+		var values []interface{}
+		for _, row := range res[0].Rows {
+			for _, v := range row.Values {
+				values = append(values, v[1])
+			}
+		}
+		if s := fmt.Sprint(values); s != tt.exp {
+			t.Errorf("%d. %s: unexpected values: %s", i, tt.q, s)
+		}
+	}
+}
+
 // Ensure the server does not return non-existent users
 func TestServer_NonExistingUsers(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -488,9 +955,70 @@ func TestServer_SetDefaultRetentionPolicy_ErrRetentionPolicyNotFound(t *testing.
 	}
 }
 
+// Ensure the server can rename a retention policy and keep its shards.
+func TestServer_ExecuteQuery_AlterRetentionPolicy(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.WriteSeries("foo", "raw", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": float64(1)})
+	s.Sync(c.index)
+
+	results := s.ExecuteQuery(MustParseQuery(`ALTER RETENTION POLICY raw ON foo DURATION 2h RENAME TO hourly`), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	}
+	s.Restart()
+
+	// Verify the policy was renamed and is still the default.
+	if rp, _ := s.RetentionPolicy("foo", "raw"); rp != nil {
+		t.Fatal("retention policy not renamed")
+	} else if rp, _ := s.DefaultRetentionPolicy("foo"); rp == nil || rp.Name != "hourly" {
+		t.Fatalf("unexpected default policy: %#v", rp)
+	} else if rp.Duration != 2*time.Hour {
+		t.Fatalf("unexpected duration: %s", rp.Duration)
+	} else if len(rp.Shards) != 1 {
+		t.Fatalf("unexpected shard count: %d", len(rp.Shards))
+	}
+
+	// Verify the data is read through the new name.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 00:01:00" GROUP BY time(1m)`), "foo", nil, influxdb.QueryOptions{RetentionPolicy: "hourly"})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if s := mustMarshalJSON(results[0].Rows); s != `[{"name":"cpu","columns":["time","sum"],"values":[[946684800000000,1]]}]` {
+		t.Fatalf("unexpected rows: %s", s)
+	}
+
+	// Renaming onto an existing policy fails.
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "daily", Duration: 24 * time.Hour})
+	results = s.ExecuteQuery(MustParseQuery(`ALTER RETENTION POLICY hourly ON foo RENAME TO daily`), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != influxdb.ErrRetentionPolicyExists {
+		t.Fatalf("unexpected error: %v", results[0].Err)
+	}
+}
+
+// Ensure the server only allows admin users to alter databases and retention policies.
+func TestServer_ExecuteQuery_Alter_ErrAdminRequired(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+
+	u := &influxdb.User{Name: "susy"}
+	results := s.ExecuteQuery(MustParseQuery(`ALTER DATABASE foo RENAME TO bar; ALTER RETENTION POLICY raw ON foo RENAME TO hourly`), "foo", u, influxdb.QueryOptions{})
+	for _, r := range results {
+		if r.Err != influxdb.ErrAdminRequired {
+			t.Fatalf("unexpected error: %v", r.Err)
+		}
+	}
+}
+
 // Ensure the database can write data to the database.
 func TestServer_WriteSeries(t *testing.T) {
-	s := OpenServer(NewMessagingClient())
+	c := NewMessagingClient()
+	s := OpenServer(c)
 	defer s.Close()
 	s.CreateDatabase("foo")
 	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
@@ -505,85 +1033,2210 @@ func TestServer_WriteSeries(t *testing.T) {
 	if err := s.WriteSeries("foo", "myspace", name, tags, timestamp, values); err != nil {
 		t.Fatal(err)
 	}
+	s.Sync(c.index)
 
-	t.Skip("pending")
-
-	// TODO: Execute a query and record all series found.
-	// q := mustParseQuery(`select myval from cpu_load`)
-	// if err := db.ExecuteQuery(q); err != nil {
-	// 	t.Fatal(err)
-	// }
+	// Execute a query and verify the point was written.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu_load`), "foo", nil, influxdb.QueryOptions{})
+	if len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("unexpected results: %s", mustMarshalJSON(results))
+	} else if s := mustMarshalJSON(results); s != `[{"statement_id":0,"rows":[{"name":"cpu_load","columns":["time","sum"],"values":[[0,23.2]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
 }
 
-func TestServer_CreateShardIfNotExist(t *testing.T) {
-	s := OpenServer(NewMessagingClient())
+// Ensure the server sends written points that match a tail's condition.
+func TestServer_Tail(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
 	defer s.Close()
 	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
 
-	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != nil {
+	tail, err := s.Tail("foo", "cpu", MustParseExpr(`host = 'serverA' AND value > 1`), influxdb.TailOptions{})
+	if err != nil {
 		t.Fatal(err)
 	}
+	defer tail.Close()
 
-	if err := s.CreateShardsIfNotExists("foo", "bar", time.Time{}); err != nil {
-		t.Fatal(err)
+	// Write points that don't match and one that does.
+	timestamp := mustParseTime("2000-01-01T00:00:00Z")
+	s.WriteSeries("foo", "myspace", "cpu", map[string]string{"host": "serverB"}, timestamp, map[string]interface{}{"value": 2.0})
+	s.WriteSeries("foo", "myspace", "cpu", map[string]string{"host": "serverA"}, timestamp, map[string]interface{}{"value": 1.0})
+	s.WriteSeries("foo", "myspace", "mem", map[string]string{"host": "serverA"}, timestamp, map[string]interface{}{"value": 2.0})
+	s.WriteSeries("foo", "myspace", "cpu", map[string]string{"host": "serverA"}, timestamp.Add(time.Second), map[string]interface{}{"value": 3.0})
+	s.Sync(c.index)
+
+	select {
+	case p := <-tail.C:
+		if p.Name != "cpu" || p.Tags["host"] != "serverA" || !p.Timestamp.Equal(timestamp.Add(time.Second)) || p.Values["value"] != 3.0 {
+			t.Fatalf("unexpected point: %#v", p)
+		}
+	default:
+		t.Fatal("expected point")
+	}
+	select {
+	case p := <-tail.C:
+		t.Fatalf("unexpected point: %#v", p)
+	default:
+	}
+
+	// Closing the tail closes its channel.
+	tail.Close()
+	if _, ok := <-tail.C; ok {
+		t.Fatal("expected closed channel")
+	}
+
+	// Tails require an existing database and a measurement.
+	if _, err := s.Tail("no_such_db", "cpu", nil, influxdb.TailOptions{}); err != influxdb.ErrDatabaseNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := s.Tail("foo", "", nil, influxdb.TailOptions{}); err != influxdb.ErrMeasurementNameRequired {
+		t.Fatalf("unexpected error: %v", err)
 	}
+}
 
-	if ss, err := s.Shards("foo"); err != nil {
-		t.Fatal(err)
-	} else if len(ss) != 1 {
-		t.Fatalf("expected 1 shard but found %d", len(ss))
+// Ensure a tail drops points according to its policy once its buffer is full.
+func TestServer_Tail_DropPolicy(t *testing.T) {
+	for i, tt := range []struct {
+		policy influxdb.TailDropPolicy
+		values []float64
+	}{
+		{policy: influxdb.TailDropNewest, values: []float64{1, 2}},
+		{policy: influxdb.TailDropOldest, values: []float64{3, 4}},
+	} {
+		c := NewMessagingClient()
+		s := OpenServer(c)
+		s.CreateDatabase("foo")
+		s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+
+		tail, err := s.Tail("foo", "cpu", nil, influxdb.TailOptions{BufferSize: 2, DropPolicy: tt.policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for v := 1; v <= 4; v++ {
+			s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z").Add(time.Duration(v)*time.Second), map[string]interface{}{"value": float64(v)})
+		}
+		s.Sync(c.index)
+
+		if n := tail.Dropped(); n != 2 {
+			t.Errorf("%d. unexpected dropped count: %d", i, n)
+		}
+		for _, v := range tt.values {
+			if p := <-tail.C; p.Values["value"] != v {
+				t.Errorf("%d. unexpected value: %v", i, p.Values["value"])
+			}
+		}
+		s.Close()
 	}
 }
 
-func TestServer_Measurements(t *testing.T) {
-	s := OpenServer(NewMessagingClient())
+// Ensure the server commits concurrent writes to the same shard together.
+func TestServer_WriteSeries_GroupCommit(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
 	defer s.Close()
 	s.CreateDatabase("foo")
 	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
-	s.CreateUser("susy", "pass", false)
-
-	// Write series with one point to the database.
-	timestamp := mustParseTime("2000-01-01T00:00:00Z")
-
-	tags := map[string]string{"host": "servera.influx.com", "region": "uswest"}
-	values := map[string]interface{}{"value": 23.2}
-
-	if err := s.WriteSeries("foo", "myspace", "cpu_load", tags, timestamp, values); err != nil {
+	s.CreateShardsIfNotExists("foo", "myspace", mustParseTime("2000-01-01T00:00:00Z"))
+	s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 1.0})
+	s.Sync(c.index)
+
+	// Count the points in each published write message.
+	var mu sync.Mutex
+	var sizes []int
+	c.PublishFunc = func(m *messaging.Message) (uint64, error) {
+		mu.Lock()
+		sizes = append(sizes, bytes.Count(m.Data, []byte("value")))
+		mu.Unlock()
+		return c.send(m)
+	}
+
+	// Write from multiple goroutines. The batch is only published once full.
+	s.SetGroupCommit(10, time.Hour)
+	var wg sync.WaitGroup
+	for i := 1; i <= 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z").Add(time.Duration(i)*time.Second), map[string]interface{}{"value": 1.0}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// A partial batch is published once the delay expires.
+	s.SetGroupCommit(10, 10*time.Millisecond)
+	if err := s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:01:00Z"), map[string]interface{}{"value": 1.0}); err != nil {
 		t.Fatal(err)
 	}
+	s.Sync(c.index)
 
-	expectedMeasurementNames := []string{"cpu_load"}
-	expectedSeriesIDs := influxdb.SeriesIDs([]uint32{uint32(1)})
-	names := s.MeasurementNames("foo")
-	if !reflect.DeepEqual(names, expectedMeasurementNames) {
-		t.Fatalf("Mesurements not the same:\n  exp: %s\n  got: %s", expectedMeasurementNames, names)
-	}
-	ids := s.MeasurementSeriesIDs("foo", "foo")
-	if !ids.Equals(expectedSeriesIDs) {
-		t.Fatalf("Series IDs not the same:\n  exp: %s\n  got: %s", expectedSeriesIDs, ids)
+	if !reflect.DeepEqual(sizes, []int{10, 1}) {
+		t.Fatalf("unexpected batch sizes: %v", sizes)
 	}
 
-	s.Restart()
-
-	names = s.MeasurementNames("foo")
-	if !reflect.DeepEqual(names, expectedMeasurementNames) {
-		t.Fatalf("Mesurements not the same:\n  exp: %s\n  got: %s", expectedMeasurementNames, names)
-	}
-	ids = s.MeasurementSeriesIDs("foo", "foo")
-	if !ids.Equals(expectedSeriesIDs) {
-		t.Fatalf("Series IDs not the same:\n  exp: %s\n  got: %s", expectedSeriesIDs, ids)
+	// Verify every point was written.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM cpu`), "foo", nil, influxdb.QueryOptions{})
+	if s := mustMarshalJSON(results); s != `[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","count"],"values":[[0,12]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
 	}
 }
 
-func mustMarshalJSON(v interface{}) string {
-	b, err := json.Marshal(v)
-	if err != nil {
-		panic("marshal: " + err.Error())
+// Ensure a field type conflict only drops the conflicting point of a group commit.
+func TestServer_WriteSeries_GroupCommit_FieldTypeConflict(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "myspace")
+	s.SetGroupCommit(2, time.Hour)
+
+	// Write a float and an integer to a new field from two writers. Both are
+	// valid when checked so they're committed together.
+	var wg sync.WaitGroup
+	for i, v := range []interface{}{1.5, int64(2)} {
+		wg.Add(1)
+		go func(i int, v interface{}) {
+			defer wg.Done()
+			if err := s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z").Add(time.Duration(i)*time.Second), map[string]interface{}{"value": v}); err != nil {
+				t.Error(err)
+			}
+		}(i, v)
+	}
+	wg.Wait()
+	s.Sync(c.index)
+
+	// Verify the first point applied was written.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM cpu`), "foo", nil, influxdb.QueryOptions{})
+	if s := mustMarshalJSON(results); s != `[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","count"],"values":[[0,1]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+
+	// Writers are rejected before their points are published if they
+	// conflict with an existing field or another point in the write.
+	s.SetGroupCommit(2, 10*time.Millisecond)
+	timestamp := mustParseTime("2000-01-01T00:01:00Z")
+	if err := s.WriteSeries("foo", "myspace", "mem", nil, timestamp, map[string]interface{}{"free": 1.5}); err != nil {
+		t.Fatal(err)
+	}
+	s.Sync(c.index)
+	if err := s.WriteSeries("foo", "myspace", "mem", nil, timestamp, map[string]interface{}{"free": int64(2)}); err == nil || err.Error() != `point 0: "free": field type conflict` {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.WritePoints("foo", "myspace", []*influxdb.Point{
+		{Name: "disk", Timestamp: timestamp, Values: map[string]interface{}{"used": 1.5}},
+		{Name: "disk", Timestamp: timestamp, Values: map[string]interface{}{"used": int64(2)}},
+	}); err == nil || err.Error() != `point 1: "used": field type conflict` {
+		t.Fatalf("unexpected error: %v", err)
 	}
-	return string(b)
 }
 
-func measurementsEqual(l influxdb.Measurements, r influxdb.Measurements) bool {
+// Ensure the server rejects writes while too many points are waiting to be published.
+func TestServer_WritePoints_ErrWriteQueueFull(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 1.0})
+	s.Sync(c.index)
+	s.SetGroupCommit(2, time.Hour)
+	s.SetWriteBackpressure(1, 0)
+
+	// Start a write that waits for its batch to fill.
+	points := func(t time.Time) []*influxdb.Point {
+		return []*influxdb.Point{{Name: "cpu", Timestamp: t, Values: map[string]interface{}{"value": 1.0}}}
+	}
+	errc := make(chan error)
+	go func() { errc <- s.WritePoints("foo", "myspace", points(mustParseTime("2000-01-01T00:00:01Z"))) }()
+	for i := 0; s.WriteState().PendingPoints == 0; i++ {
+		if i == 100 {
+			t.Fatal("timed out waiting for pending point")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Writes should be rejected while the point is pending.
+	if err := s.WritePoints("foo", "myspace", points(mustParseTime("2000-01-01T00:00:02Z"))); err != influxdb.ErrWriteQueueFull {
+		t.Fatalf("unexpected error: %v", err)
+	} else if st := s.WriteState(); st.Backpressure != "write queue full" {
+		t.Fatalf("unexpected state: %#v", st)
+	}
+
+	// Remove the limit and fill the batch so both writes complete.
+	s.SetWriteBackpressure(0, 0)
+	if err := s.WritePoints("foo", "myspace", points(mustParseTime("2000-01-01T00:00:03Z"))); err != nil {
+		t.Fatal(err)
+	} else if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if st := s.WriteState(); st.PendingPoints != 0 || st.Backpressure != "" {
+		t.Fatalf("unexpected state: %#v", st)
+	}
+}
+
+// Ensure retrying a write that failed part way only writes the points that
+// weren't published.
+func TestServer_WritePointsOnce_PartialRetry(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: time.Hour})
+	s.CreateDatabase("logs")
+	s.SetRoutingRule("foo", &influxdb.RoutingRule{Name: "a", Measurement: "logs_*", Database: "logs", RetentionPolicy: "week"})
+
+	// The point routed to logs fails because its retention policy doesn't exist.
+	now := mustParseTime("2000-01-01T00:00:00Z")
+	points := []*influxdb.Point{
+		{Name: "cpu", Timestamp: now, Values: map[string]interface{}{"value": 1.0}},
+		{Name: "logs_nginx", Timestamp: now, Values: map[string]interface{}{"value": 2.0}},
+	}
+	if _, err := s.WritePointsOnce("foo", "raw", "abc", points); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// The retry only writes the point routed to logs.
+	s.CreateRetentionPolicy("logs", &influxdb.RetentionPolicy{Name: "week", Duration: time.Hour})
+	if duplicate, err := s.WritePointsOnce("foo", "raw", "abc", points); err != nil {
+		t.Fatal(err)
+	} else if duplicate {
+		t.Fatal("unexpected duplicate")
+	}
+	if n := s.DatabaseStats("foo").Get(influxdb.StatPointsWritten); n != 1 {
+		t.Fatalf("unexpected points written to foo: %d", n)
+	} else if n := s.DatabaseStats("logs").Get(influxdb.StatPointsWritten); n != 1 {
+		t.Fatalf("unexpected points written to logs: %d", n)
+	}
+
+	// Once the write succeeds, retries are duplicates.
+	if duplicate, err := s.WritePointsOnce("foo", "raw", "abc", points); err != nil {
+		t.Fatal(err)
+	} else if !duplicate {
+		t.Fatal("expected duplicate")
+	}
+}
+
+// Ensure the server rejects points that fail validation.
+func TestServer_WritePoints_Invalid(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.SetPointLimits(influxdb.PointLimits{MaxFields: 2, MaxTags: 1, MaxKeyLength: 5, MaxValueLength: 3})
+
+	timestamp := mustParseTime("2000-01-01T00:00:00Z")
+	for i, tt := range []struct {
+		point *influxdb.Point
+		err   string
+	}{
+		{point: &influxdb.Point{Name: "cpu", Tags: map[string]string{"host": "a"}, Values: map[string]interface{}{"value": 1.0, "ok": "yes"}}},
+		{point: &influxdb.Point{Name: "", Values: map[string]interface{}{"value": 1.0}}, err: `point 0: measurement name required`},
+		{point: &influxdb.Point{Name: "cpu_load", Values: map[string]interface{}{"value": 1.0}}, err: `point 0: key too long`},
+		{point: &influxdb.Point{Name: "c\xffu", Values: map[string]interface{}{"value": 1.0}}, err: `point 0: invalid UTF-8`},
+		{point: &influxdb.Point{Name: "cpu", Tags: map[string]string{"host": "a", "dc": "b"}, Values: map[string]interface{}{"value": 1.0}}, err: `point 0: too many tags`},
+		{point: &influxdb.Point{Name: "cpu", Tags: map[string]string{"": "a"}, Values: map[string]interface{}{"value": 1.0}}, err: `point 0: key required`},
+		{point: &influxdb.Point{Name: "cpu", Tags: map[string]string{"host": "abcd"}, Values: map[string]interface{}{"value": 1.0}}, err: `point 0: "host": value too long`},
+		{point: &influxdb.Point{Name: "cpu", Tags: map[string]string{"host": "\xff"}, Values: map[string]interface{}{"value": 1.0}}, err: `point 0: "host": invalid UTF-8`},
+		{point: &influxdb.Point{Name: "cpu", Values: map[string]interface{}{}}, err: `point 0: fields required`},
+		{point: &influxdb.Point{Name: "cpu", Values: map[string]interface{}{"a": 1.0, "b": 2.0, "c": 3.0}}, err: `point 0: too many fields`},
+		{point: &influxdb.Point{Name: "cpu", Values: map[string]interface{}{"values": 1.0}}, err: `point 0: "values": key too long`},
+		{point: &influxdb.Point{Name: "cpu", Values: map[string]interface{}{"value": "abcd"}}, err: `point 0: "value": value too long`},
+		{point: &influxdb.Point{Name: "cpu", Values: map[string]interface{}{"value": math.NaN()}}, err: `point 0: "value": invalid float: NaN and infinity are not supported`},
+		{point: &influxdb.Point{Name: "cpu", Values: map[string]interface{}{"value": math.Inf(-1)}}, err: `point 0: "value": invalid float: NaN and infinity are not supported`},
+		{point: &influxdb.Point{Name: "cpu", Values: map[string]interface{}{"value": 1}}, err: `point 0: "value": unsupported value: 1`},
+	} {
+		tt.point.Timestamp = timestamp
+		err := s.WritePoints("foo", "myspace", []*influxdb.Point{tt.point})
+		if tt.err == "" && err != nil {
+			t.Errorf("%d. unexpected error: %s", i, err)
+		} else if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%d. error mismatch: exp=%s, got=%v", i, tt.err, err)
+		}
+	}
+
+	// Ensure every invalid point in a batch is reported and nothing is written.
+	err := s.WritePoints("foo", "myspace", []*influxdb.Point{
+		{Name: "", Timestamp: timestamp, Values: map[string]interface{}{"value": 1.0}},
+		{Name: "cpu", Timestamp: timestamp, Values: map[string]interface{}{"value": 1.0}},
+		{Name: "cpu", Timestamp: timestamp, Values: map[string]interface{}{"value": math.NaN()}},
+	})
+	if errs, ok := err.(influxdb.PointErrors); !ok || len(errs) != 2 {
+		t.Fatalf("unexpected error: %#v", err)
+	} else if errs[0].Index != 0 || errs[0].Err != influxdb.ErrMeasurementNameRequired {
+		t.Fatalf("unexpected first error: %s", errs[0])
+	} else if errs[1].Index != 2 || errs[1].Key != "value" || errs[1].Err != influxdb.ErrInvalidFloat {
+		t.Fatalf("unexpected second error: %s", errs[1])
+	}
+
+	// Ensure single series writes are validated as well.
+	if err := s.WriteSeries("foo", "myspace", "cpu", nil, timestamp, map[string]interface{}{"value": math.Inf(1)}); err == nil || err.Error() != `point 0: "value": invalid float: NaN and infinity are not supported` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if n := s.DatabaseStats("foo").Get(influxdb.StatPointsWritten); n != 1 {
+		t.Fatalf("unexpected points written: %d", n)
+	}
+}
+
+// Ensure the server rejects timestamps outside the allowed window.
+func TestServer_WritePoints_TimestampWindow(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 24 * time.Hour})
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "forever"})
+	s.SetDefaultRetentionPolicy("foo", "myspace")
+	s.SetPointLimits(influxdb.PointLimits{MaxFuture: 1 * time.Hour, RejectExpired: true})
+
+	now := time.Now().UTC()
+	for i, tt := range []struct {
+		rp        string
+		timestamp time.Time
+		err       error
+	}{
+		{rp: "myspace", timestamp: now},
+		{rp: "myspace", timestamp: now.Add(30 * time.Minute)},
+		{rp: "myspace", timestamp: now.Add(-23 * time.Hour)},
+		{rp: "myspace", timestamp: now.Add(2 * time.Hour), err: influxdb.ErrTimestampInFuture},
+		{rp: "myspace", timestamp: now.Add(-25 * time.Hour), err: influxdb.ErrTimestampExpired},
+		{rp: "", timestamp: now.Add(-25 * time.Hour), err: influxdb.ErrTimestampExpired},
+		{rp: "forever", timestamp: now.Add(-25 * time.Hour)},
+		{rp: "forever", timestamp: now.Add(2 * time.Hour), err: influxdb.ErrTimestampInFuture},
+	} {
+		err := s.WritePoints("foo", tt.rp, []*influxdb.Point{{Name: "cpu", Timestamp: tt.timestamp, Values: map[string]interface{}{"value": 1.0}}})
+		if tt.err == nil && err != nil {
+			t.Errorf("%d. unexpected error: %s", i, err)
+		} else if errs, ok := err.(influxdb.PointErrors); tt.err != nil && (!ok || errs[0].Err != tt.err) {
+			t.Errorf("%d. error mismatch: exp=%s, got=%v", i, tt.err, err)
+		}
+	}
+
+	// Ensure single series writes are validated as well.
+	if err := s.WriteSeries("foo", "myspace", "cpu", nil, now.Add(2*time.Hour), map[string]interface{}{"value": 1.0}); err == nil || err.Error() != `point 0: timestamp too far in the future` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the server truncates written timestamps to its precision.
+func TestServer_WritePoints_TimestampPrecision(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "myspace")
+	s.SetTimestampPrecision(influxdb.SecondPrecision)
+
+	p := &influxdb.Point{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:01.999999Z"), Values: map[string]interface{}{"value": 1.0}}
+	if err := s.WritePoints("foo", "myspace", []*influxdb.Point{p}); err != nil {
+		t.Fatal(err)
+	} else if !p.Timestamp.Equal(mustParseTime("2000-01-01T00:00:01.999999Z")) {
+		t.Fatalf("point modified: %s", p.Timestamp)
+	}
+	s.Sync(c.index)
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 00:01:00"`), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if s := mustMarshalJSON(results[0].Rows); s != `[{"name":"cpu","columns":["time","value"],"values":[[946684801000000,1]]}]` {
+		t.Fatalf("unexpected rows: %s", s)
+	}
+}
+
+// Ensure a database with strict schemas only accepts declared measurements, tags and fields.
+// Ensure points at existing timestamps are stored by their measurement's duplicate policy.
+func TestServer_WriteSeries_DuplicatePolicy(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	if err := s.SetMeasurementSchema("foo", &influxdb.MeasurementSchema{Name: "mem", Duplicates: "avg"}); err != influxdb.ErrInvalidDuplicatePolicy {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, policy := range []string{influxdb.DuplicateSum, influxdb.DuplicateMin, influxdb.DuplicateMax, influxdb.DuplicateReject} {
+		if err := s.SetMeasurementSchema("foo", &influxdb.MeasurementSchema{Name: policy, Duplicates: policy}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Restart()
+
+	// Write two points at the same timestamp to each measurement.
+	timestamp := mustParseTime("2000-01-01T00:00:00Z")
+	for _, name := range []string{"last", "sum", "min", "max", "reject"} {
+		s.WriteSeries("foo", "raw", name, nil, timestamp, map[string]interface{}{"value": 2.0, "ok": true})
+		s.WriteSeries("foo", "raw", name, nil, timestamp, map[string]interface{}{"value": 5.0})
+	}
+	s.Sync(c.index)
+
+	for i, tt := range []struct {
+		name   string
+		values string
+	}{
+		{name: "last", values: `[[946684800000000,5]]`},
+		{name: "sum", values: `[[946684800000000,7]]`},
+		{name: "min", values: `[[946684800000000,2]]`},
+		{name: "max", values: `[[946684800000000,5]]`},
+		{name: "reject", values: `[[946684800000000,2]]`},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(`SELECT value FROM `+tt.name), "foo", nil, influxdb.QueryOptions{})
+		if results[0].Err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, results[0].Err)
+		} else if values := mustMarshalJSON(results[0].Rows[0].Values); values != tt.values {
+			t.Errorf("%d. %s: unexpected values: %s", i, tt.name, values)
+		}
+	}
+
+	// Fields only in the existing point are kept when merging.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT ok FROM sum`), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if values := mustMarshalJSON(results[0].Rows[0].Values); values != `[[946684800000000,true]]` {
+		t.Fatalf("unexpected values: %s", values)
+	}
+
+	if n := s.DatabaseStats("foo").Get(influxdb.StatDuplicatesDropped); n != 1 {
+		t.Fatalf("unexpected duplicates dropped: %d", n)
+	}
+}
+
+func TestServer_WritePoints_StrictSchema(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "myspace")
+
+	// Declare a schema and enable enforcement.
+	if err := s.SetMeasurementSchema("foo", &influxdb.MeasurementSchema{Name: "cpu", Fields: []*influxdb.FieldSchema{{Name: "value", Type: influxql.Number}}, Tags: []string{"host"}}); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetMeasurementSchema("foo", &influxdb.MeasurementSchema{Name: "mem", Fields: []*influxdb.FieldSchema{{Name: "free", Type: "float"}}}); err != influxdb.ErrInvalidFieldType {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	strict := true
+	if err := s.UpdateDatabase("foo", &influxdb.DatabaseUpdate{StrictSchema: &strict}); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+
+	// Verify the schema was persisted.
+	if a, err := s.MeasurementSchemas("foo"); err != nil {
+		t.Fatal(err)
+	} else if len(a) != 1 || a[0].Name != "cpu" {
+		t.Fatalf("unexpected schemas: %s", mustMarshalJSON(a))
+	}
+
+	now := time.Now().UTC()
+	for i, tt := range []struct {
+		p   *influxdb.Point
+		err string
+	}{
+		{p: &influxdb.Point{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: now, Values: map[string]interface{}{"value": 1.0}}},
+		{p: &influxdb.Point{Name: "mem", Timestamp: now, Values: map[string]interface{}{"free": 1.0}}, err: `point 0: measurement not declared`},
+		{p: &influxdb.Point{Name: "cpu", Tags: map[string]string{"region": "uswest"}, Timestamp: now, Values: map[string]interface{}{"value": 1.0}}, err: `point 0: "region": tag not declared`},
+		{p: &influxdb.Point{Name: "cpu", Timestamp: now, Values: map[string]interface{}{"idle": 1.0}}, err: `point 0: "idle": field not declared`},
+		{p: &influxdb.Point{Name: "cpu", Timestamp: now, Values: map[string]interface{}{"value": "high"}}, err: `point 0: "value": field type conflict`},
+	} {
+		if err := s.WritePoints("foo", "myspace", []*influxdb.Point{tt.p}); tt.err == "" && err != nil {
+			t.Errorf("%d. unexpected error: %s", i, err)
+		} else if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%d. error mismatch: exp=%s, got=%v", i, tt.err, err)
+		}
+	}
+
+	// Ensure single series writes are checked as well.
+	if err := s.WriteSeries("foo", "myspace", "mem", nil, now, map[string]interface{}{"free": 1.0}); err == nil || err.Error() != `point 0: measurement not declared` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Ensure writes are accepted once enforcement is disabled.
+	strict = false
+	if err := s.UpdateDatabase("foo", &influxdb.DatabaseUpdate{StrictSchema: &strict}); err != nil {
+		t.Fatal(err)
+	} else if err := s.WritePoints("foo", "myspace", []*influxdb.Point{{Name: "mem", Timestamp: now, Values: map[string]interface{}{"free": 1.0}}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure tag guards reject or rewrite tag values outside their limits.
+func TestServer_WritePoints_TagGuard(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "myspace")
+
+	if err := s.SetTagGuard("foo", &influxdb.TagGuard{Measurement: "cpu", Key: "host"}); err != influxdb.ErrInvalidTagGuard {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.SetTagGuard("foo", &influxdb.TagGuard{Measurement: "cpu", Key: "host", MaxValues: 2}); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetTagGuard("foo", &influxdb.TagGuard{Measurement: "cpu", Key: "region", Allow: []string{"uswest", "useast"}, Other: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+
+	// Verify the guards were persisted.
+	if a, err := s.TagGuards("foo"); err != nil {
+		t.Fatal(err)
+	} else if len(a) != 2 || a[0].Key != "host" || a[1].Key != "region" {
+		t.Fatalf("unexpected guards: %s", mustMarshalJSON(a))
+	}
+
+	now := time.Now().UTC()
+	point := func(host, region string) *influxdb.Point {
+		return &influxdb.Point{Name: "cpu", Tags: map[string]string{"host": host, "region": region}, Timestamp: now, Values: map[string]interface{}{"value": 1.0}}
+	}
+
+	// Write two hosts and ensure a third is rejected, including within a batch.
+	if err := s.WritePoints("foo", "myspace", []*influxdb.Point{point("servera", "uswest")}); err != nil {
+		t.Fatal(err)
+	} else if err := s.WritePoints("foo", "myspace", []*influxdb.Point{point("serverb", "useast"), point("serverc", "useast")}); err == nil || err.Error() != `point 1: "host": tag value limit exceeded` {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.WritePoints("foo", "myspace", []*influxdb.Point{point("serverb", "useast"), point("servera", "useast")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write a region outside the allowlist and ensure it's rewritten.
+	p := point("servera", "req-1234")
+	if err := s.WritePoints("foo", "myspace", []*influxdb.Point{p}); err != nil {
+		t.Fatal(err)
+	} else if p.Tags["region"] != "req-1234" {
+		t.Fatalf("caller's point modified: %v", p.Tags)
+	}
+	s.Sync(c.index)
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM cpu GROUP BY region`), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if s := mustMarshalJSON(results[0].Rows); s != `[{"name":"cpu","tags":{"region":"other"},"columns":["time","count"],"values":[[0,1]]},{"name":"cpu","tags":{"region":"useast"},"columns":["time","count"],"values":[[0,2]]},{"name":"cpu","tags":{"region":"uswest"},"columns":["time","count"],"values":[[0,1]]}]` {
+		t.Fatalf("unexpected rows: %s", s)
+	}
+
+	// Ensure guards can be removed.
+	if err := s.DeleteTagGuard("foo", "cpu", "host"); err != nil {
+		t.Fatal(err)
+	} else if err := s.DeleteTagGuard("foo", "cpu", "host"); err != influxdb.ErrTagGuardNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.WritePoints("foo", "myspace", []*influxdb.Point{point("serverc", "uswest")}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the numeric values of tags and fields can be grouped into buckets.
+func TestServer_ExecuteQuery_GroupByBucket(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	for i, p := range []struct {
+		code    string
+		latency float64
+	}{
+		{"200", 20}, {"200", 150}, {"204", 90}, {"404", 30}, {"503", 800}, {"none", 600},
+	} {
+		ts := mustParseTime("2000-01-01T00:00:00Z").Add(time.Duration(i) * time.Second)
+		if err := s.WriteSeries("foo", "raw", "http", map[string]string{"code": p.code}, ts, map[string]interface{}{"latency": p.latency}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Sync(c.index)
+
+	for i, tt := range []struct {
+		q   string
+		key string
+		exp map[string]string
+	}{
+		// Field values are split into their buckets, even within a series.
+		{
+			q:   `SELECT count(latency) FROM http GROUP BY bucket(latency, 100, 500, 'fast', 'ok', 'slow')`,
+			key: "latency",
+			exp: map[string]string{"fast": `[[0,3]]`, "ok": `[[0,1]]`, "slow": `[[0,2]]`},
+		},
+
+		// Tag values are labeled by their range. Values that aren't numbers
+		// have a blank label.
+		{
+			q:   `SELECT count(latency) FROM http GROUP BY bucket(code, 300, 500)`,
+			key: "code",
+			exp: map[string]string{"<300": `[[0,3]]`, "300-500": `[[0,1]]`, ">=500": `[[0,1]]`, "": `[[0,1]]`},
+		},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil, influxdb.QueryOptions{})
+		if results[0].Err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, results[0].Err)
+		}
+		act := make(map[string]string)
+		for _, row := range results[0].Rows {
+			act[row.Tags[tt.key]] = mustMarshalJSON(row.Values)
+		}
+		if !reflect.DeepEqual(act, tt.exp) {
+			t.Fatalf("%d. unexpected rows: %s", i, mustMarshalJSON(results[0].Rows))
+		}
+	}
+
+	// Empty buckets are left out for both fields and tags and rows are
+	// returned in bucket order.
+	for i, tt := range []struct {
+		q   string
+		key string
+		exp []string
+	}{
+		{
+			q:   `SELECT count(latency) FROM http GROUP BY bucket(latency, 10, 100, 500, 1000)`,
+			key: "latency",
+			exp: []string{"10-100=[[0,3]]", "100-500=[[0,1]]", "500-1000=[[0,2]]"},
+		},
+		{
+			q:   `SELECT count(latency) FROM http GROUP BY bucket(code, 100, 300, 500)`,
+			key: "code",
+			exp: []string{"100-300=[[0,3]]", "300-500=[[0,1]]", ">=500=[[0,1]]", "=[[0,1]]"},
+		},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil, influxdb.QueryOptions{})
+		if results[0].Err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, results[0].Err)
+		}
+		var act []string
+		for _, row := range results[0].Rows {
+			act = append(act, row.Tags[tt.key]+"="+mustMarshalJSON(row.Values))
+		}
+		if !reflect.DeepEqual(act, tt.exp) {
+			t.Fatalf("%d. unexpected rows: %v", i, act)
+		}
+	}
+
+	// Ensure invalid buckets return an error.
+	for i, tt := range []struct {
+		q   string
+		err string
+	}{
+		{q: `SELECT count(latency) FROM http GROUP BY bucket(latency)`, err: `expected a tag or field and at least one bound for bucket()`},
+		{q: `SELECT count(latency) FROM http GROUP BY bucket(latency, 500, 100)`, err: `expected ascending bounds for bucket()`},
+		{q: `SELECT count(latency) FROM http GROUP BY bucket(latency, 100, 'fast')`, err: `expected 2 labels for bucket(), got 1`},
+		{q: `SELECT count(latency) FROM http GROUP BY mean(latency)`, err: `invalid dimension: mean(latency)`},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil, influxdb.QueryOptions{})
+		if results[0].Err == nil || results[0].Err.Error() != tt.err {
+			t.Errorf("%d. unexpected error: %v", i, results[0].Err)
+		}
+	}
+}
+
+// Ensure queries with a snapshot don't read points written after it.
+func TestServer_ExecuteQuery_Snapshot(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.WriteSeries("foo", "raw", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 1.0})
+	s.WriteSeries("foo", "raw", "cpu", nil, mustParseTime("2000-01-01T00:00:10Z"), map[string]interface{}{"value": 2.0})
+	s.Sync(c.index)
+	snapshot := s.Index()
+
+	// Write to the same shard, overwrite a point and write to a new shard.
+	s.WriteSeries("foo", "raw", "cpu", nil, mustParseTime("2000-01-01T00:00:20Z"), map[string]interface{}{"value": 10.0})
+	s.WriteSeries("foo", "raw", "cpu", nil, mustParseTime("2000-01-01T00:00:10Z"), map[string]interface{}{"value": 20.0})
+	s.WriteSeries("foo", "raw", "cpu", nil, mustParseTime("2000-01-01T05:00:00Z"), map[string]interface{}{"value": 100.0})
+	s.Sync(c.index)
+	s.Restart()
+
+	for i, tt := range []struct {
+		snapshot uint64
+		rows     string
+	}{
+		{snapshot: snapshot, rows: `[{"name":"cpu","columns":["time","sum"],"values":[[0,1]]}]`},
+		{snapshot: 0, rows: `[{"name":"cpu","columns":["time","sum"],"values":[[0,131]]}]`},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil, influxdb.QueryOptions{Snapshot: tt.snapshot})
+		if results[0].Err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, results[0].Err)
+		} else if rows := mustMarshalJSON(results[0].Rows); rows != tt.rows {
+			t.Fatalf("%d. unexpected rows: %s", i, rows)
+		}
+	}
+}
+
+// Ensure the server can return the execution plan for a query.
+func TestServer_ExecuteQuery_Explain(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.WriteSeries("foo", "myspace", "cpu", map[string]string{"host": "serverA"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 10.0})
+	s.WriteSeries("foo", "myspace", "cpu", map[string]string{"host": "serverB"}, mustParseTime("2000-01-01T00:00:10Z"), map[string]interface{}{"value": 20.0})
+	s.Sync(c.index)
+
+	// Explain the query without executing it.
+	results := s.ExecuteQuery(MustParseQuery(`EXPLAIN SELECT sum(value) FROM cpu WHERE host = 'serverA'`), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if row := results[0].Rows[0]; !reflect.DeepEqual(row.Columns, []string{"stage", "detail"}) {
+		t.Fatalf("unexpected columns: %s", row.Columns)
+	} else if s := mustMarshalJSON(row.Values); s != `[["select","SELECT sum(value) FROM cpu WHERE host = \"serverA\""],["  shards",""],["    shard","id=4 2000-01-01T00:00:00Z - 2000-01-01T01:00:00Z"],["  reduce","sum(value)"],["    index","tag index: cpu host=\"serverA\""],["    iterator","series=1 field=1 type=number"]]` {
+		t.Fatalf("unexpected plan: %s", s)
+	}
+
+	// Analyze the query and verify the row counts.
+	results = s.ExecuteQuery(MustParseQuery(`EXPLAIN ANALYZE SELECT sum(value) FROM cpu`), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if row := results[0].Rows[0]; !reflect.DeepEqual(row.Columns, []string{"stage", "detail", "rows", "duration"}) {
+		t.Fatalf("unexpected columns: %s", row.Columns)
+	} else if len(row.Values) != 7 {
+		t.Fatalf("unexpected stage count: %d", len(row.Values))
+	} else if row.Values[0][2] != 1 || row.Values[4][1] != "measurement scan: cpu" || row.Values[4][2] != 2 || row.Values[5][2] != 1 {
+		t.Fatalf("unexpected plan: %s", mustMarshalJSON(row.Values))
+	}
+
+	// Each shard reports the series, points and bytes read from it.
+	if row := results[0].Rows[0]; row.Values[2][2] != 2 || !regexp.MustCompile(`^id=4 \S+ - \S+ series=2 bytes=[1-9][0-9]* queue=\S+$`).MatchString(row.Values[2][1].(string)) {
+		t.Fatalf("unexpected shard stage: %s", mustMarshalJSON(row.Values[2]))
+	}
+}
+
+// Ensure the server can read a series from multiple shards concurrently.
+func TestServer_ExecuteQuery_MultipleShards(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.SetQueryConcurrency(2)
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	for i := 0; i < 5; i++ {
+		timestamp := mustParseTime("2000-01-01T00:00:00Z").Add(time.Duration(4-i) * time.Hour)
+		s.WriteSeries("foo", "myspace", "cpu", nil, timestamp, map[string]interface{}{"value": float64(i)})
+	}
+	s.Sync(c.index)
+
+	if a, err := s.Shards("foo"); err != nil {
+		t.Fatal(err)
+	} else if len(a) != 5 {
+		t.Fatalf("unexpected shard count: %d", len(a))
+	}
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 05:00:00" GROUP BY time(1h)`), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if s := mustMarshalJSON(results[0].Rows); s != `[{"name":"cpu","columns":["time","sum"],"values":[[946684800000000,4],[946688400000000,3],[946692000000000,2],[946695600000000,1],[946699200000000,0]]}]` {
+		t.Fatalf("unexpected rows: %s", s)
+	}
+}
+
+// Ensure the server stores string fields in a dictionary and can filter by them.
+func TestServer_ExecuteQuery_StringFields(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "myspace")
+	s.WriteSeries("foo", "myspace", "logs", map[string]string{"host": "servera"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"level": "error", "msg": "disk full"})
+	s.WriteSeries("foo", "myspace", "logs", map[string]string{"host": "servera"}, mustParseTime("2000-01-01T00:00:10Z"), map[string]interface{}{"level": "info", "msg": "started"})
+	s.WriteSeries("foo", "myspace", "logs", map[string]string{"host": "serverb"}, mustParseTime("2000-01-01T00:00:20Z"), map[string]interface{}{"level": "error", "msg": "timeout", "code": 504.0})
+	s.Sync(c.index)
+
+	exec := func(q string) string {
+		results := s.ExecuteQuery(MustParseQuery(q), "foo", nil, influxdb.QueryOptions{})
+		if results[0].Err != nil {
+			t.Fatalf("unexpected error: %s", results[0].Err)
+		}
+		return mustMarshalJSON(results[0].Rows)
+	}
+	if act := exec(`SELECT msg FROM logs WHERE level = 'error' AND time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00"`); act != `[{"name":"logs","columns":["time","msg"],"values":[[946684800000000,"disk full"],[946684820000000,"timeout"]]}]` {
+		t.Fatalf("unexpected rows: %s", act)
+	}
+	if act := exec(`SELECT msg FROM logs WHERE msg =~ /^(disk|time)/ AND code > 500 AND time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00"`); act != `[{"name":"logs","columns":["time","msg"],"values":[[946684820000000,"timeout"]]}]` {
+		t.Fatalf("unexpected regex rows: %s", act)
+	}
+	if act := exec(`SELECT distinct(level) FROM logs WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00"`); act != `[{"name":"logs","columns":["time","distinct"],"values":[[946684800000000,["error","info"]]]}]` {
+		t.Fatalf("unexpected distinct rows: %s", act)
+	}
+}
+
+// Ensure the server caches results for time ranges in the past until the data changes.
+func TestServer_ExecuteQuery_ResultCache(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.SetResultCache(10, time.Minute)
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 10.0})
+	s.Sync(c.index)
+
+	query := `SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 00:01:00"`
+	exec := func() string {
+		results := s.ExecuteQuery(MustParseQuery(query), "foo", nil, influxdb.QueryOptions{})
+		if results[0].Err != nil {
+			t.Fatalf("unexpected error: %s", results[0].Err)
+		}
+		return mustMarshalJSON(results[0].Rows)
+	}
+
+	// Execute the query and modify its results. The second execution is served
+	// from the cache and is unaffected.
+	results := s.ExecuteQuery(MustParseQuery(query), "foo", nil, influxdb.QueryOptions{})
+	results[0].Rows[0].Values[0][1] = 100.0
+	if act := exec(); act != `[{"name":"cpu","columns":["time","sum"],"values":[[946684800000000,10]]}]` {
+		t.Fatalf("unexpected rows: %s", act)
+	}
+
+	// Queries ending at the current time are not cached.
+	if results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil, influxdb.QueryOptions{}); results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	}
+
+	st := s.DatabaseStats("foo")
+	if n := st.Get(influxdb.StatResultCacheHits); n != 1 {
+		t.Fatalf("unexpected cache hits: %d", n)
+	} else if n := st.Get(influxdb.StatResultCacheMisses); n != 1 {
+		t.Fatalf("unexpected cache misses: %d", n)
+	}
+
+	// Writing to the shard removes the cached results.
+	s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:10Z"), map[string]interface{}{"value": 20.0})
+	s.Sync(c.index)
+	if act := exec(); act != `[{"name":"cpu","columns":["time","sum"],"values":[[946684800000000,30]]}]` {
+		t.Fatalf("unexpected rows after write: %s", act)
+	} else if n := st.Get(influxdb.StatResultCacheMisses); n != 2 {
+		t.Fatalf("unexpected cache misses after write: %d", n)
+	}
+}
+
+// Ensure the server can delete points before a time from every measurement.
+func TestServer_ExecuteQuery_Delete(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "myspace")
+	s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 1.0})
+	s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T02:00:00Z"), map[string]interface{}{"value": 2.0})
+	s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T02:30:00Z"), map[string]interface{}{"value": 4.0})
+	s.WriteSeries("foo", "myspace", "mem", nil, mustParseTime("2000-01-01T02:10:00Z"), map[string]interface{}{"value": 8.0})
+	s.Sync(c.index)
+
+	if a, _ := s.Shards("foo"); len(a) != 2 {
+		t.Fatalf("unexpected shard count: %d", len(a))
+	}
+
+	// Delete points before 02:15 from all measurements.
+	if res := s.ExecuteQuery(MustParseQuery(`DELETE WHERE time < "2000-01-01 02:15:00"`), "foo", nil, influxdb.QueryOptions{}); res[0].Err != nil {
+		t.Fatalf("unexpected error: %s", res[0].Err)
+	}
+
+	// The shard ending before the time is dropped and the other is kept.
+	if a, _ := s.Shards("foo"); len(a) != 1 {
+		t.Fatalf("unexpected shard count after delete: %d", len(a))
+	}
+
+	exec := func(q string) string {
+		results := s.ExecuteQuery(MustParseQuery(q), "foo", nil, influxdb.QueryOptions{})
+		if results[0].Err != nil {
+			t.Fatalf("unexpected error: %s", results[0].Err)
+		}
+		return mustMarshalJSON(results[0].Rows)
+	}
+	if act := exec(`SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 03:00:00"`); act != `[{"name":"cpu","columns":["time","sum"],"values":[[946684800000000,4]]}]` {
+		t.Fatalf("unexpected cpu rows: %s", act)
+	}
+	if act := exec(`SELECT sum(value) FROM mem WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 03:00:00"`); act != `[{"name":"mem","columns":["time","sum"],"values":[[946684800000000,0]]}]` {
+		t.Fatalf("unexpected mem rows: %s", act)
+	}
+}
+
+// Ensure the server can delete points before a time from a single measurement.
+func TestServer_ExecuteQuery_DeleteFromMeasurement(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "myspace")
+	s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 1.0})
+	s.WriteSeries("foo", "myspace", "mem", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 2.0})
+	s.Sync(c.index)
+
+	if res := s.ExecuteQuery(MustParseQuery(`DELETE FROM cpu WHERE time <= "2000-01-01 00:00:00"`), "foo", nil, influxdb.QueryOptions{}); res[0].Err != nil {
+		t.Fatalf("unexpected error: %s", res[0].Err)
+	}
+
+	// The shard is kept since other measurements have points in it.
+	if a, _ := s.Shards("foo"); len(a) != 1 {
+		t.Fatalf("unexpected shard count after delete: %d", len(a))
+	}
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM mem WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00"`), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if act := mustMarshalJSON(results[0].Rows); act != `[{"name":"mem","columns":["time","sum"],"values":[[946684800000000,2]]}]` {
+		t.Fatalf("unexpected mem rows: %s", act)
+	}
+
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00"`), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if act := mustMarshalJSON(results[0].Rows); act != `[{"name":"cpu","columns":["time","sum"],"values":[[946684800000000,0]]}]` {
+		t.Fatalf("unexpected cpu rows: %s", act)
+	}
+
+	// Deleting from a measurement that does not exist returns an error.
+	if res := s.ExecuteQuery(MustParseQuery(`DELETE FROM no_such_measurement WHERE time < now()`), "foo", nil, influxdb.QueryOptions{}); res[0].Err != influxdb.ErrMeasurementNotFound {
+		t.Fatalf("unexpected error: %s", res[0].Err)
+	}
+}
+
+// Ensure deletes mask points until they're purged and keep points written afterward.
+func TestServer_ExecuteQuery_ShowDeletions(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "myspace")
+	s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 1.0})
+	s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:40:00Z"), map[string]interface{}{"value": 2.0})
+	s.Sync(c.index)
+
+	if res := s.ExecuteQuery(MustParseQuery(`DELETE FROM cpu WHERE time < "2000-01-01 00:30:00"`), "foo", nil, influxdb.QueryOptions{}); res[0].Err != nil {
+		t.Fatalf("unexpected error: %s", res[0].Err)
+	}
+
+	// Points written into the deleted range after the delete are kept.
+	s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:10:00Z"), map[string]interface{}{"value": 4.0})
+	s.Sync(c.index)
+
+	exec := func(q string) string {
+		results := s.ExecuteQuery(MustParseQuery(q), "foo", nil, influxdb.QueryOptions{})
+		if results[0].Err != nil {
+			t.Fatalf("unexpected error: %s", results[0].Err)
+		}
+		return mustMarshalJSON(results[0].Rows)
+	}
+	const sum = `SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00"`
+	const exp = `[{"name":"cpu","columns":["time","sum"],"values":[[946684800000000,6]]}]`
+	if act := exec(sum); act != exp {
+		t.Fatalf("unexpected rows: %s", act)
+	}
+
+	// The deletion is listed until it's purged, including after a restart.
+	s.Restart()
+	a, err := s.Deletions("foo")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(a) != 1 || a[0].Measurement != "cpu" || !a[0].Before.Equal(mustParseTime("2000-01-01T00:30:00Z")) {
+		t.Fatalf("unexpected deletions: %s", mustMarshalJSON(a))
+	}
+	if act := exec(`SHOW DELETIONS`); act != `[{"name":"deletions","columns":["id","measurement","before","created_at"],"values":[[`+strconv.FormatUint(a[0].ID, 10)+`,"cpu","2000-01-01T00:30:00Z","`+a[0].CreatedAt.Format(time.RFC3339Nano)+`"]]}]` {
+		t.Fatalf("unexpected deletions: %s", act)
+	}
+	if act := exec(sum); act != exp {
+		t.Fatalf("unexpected rows after restart: %s", act)
+	}
+
+	// Purging removes the deletion and the points stay deleted.
+	if err := s.PurgeDeletions("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if act := exec(`SHOW DELETIONS`); act != `[{"name":"deletions","columns":["id","measurement","before","created_at"]}]` {
+		t.Fatalf("unexpected deletions after purge: %s", act)
+	}
+	if act := exec(sum); act != exp {
+		t.Fatalf("unexpected rows after purge: %s", act)
+	}
+
+	// Only admins can list deletions.
+	s.CreateUser("susy", "pass", false)
+	if res := s.ExecuteQuery(MustParseQuery(`SHOW DELETIONS`), "foo", s.User("susy"), influxdb.QueryOptions{}); res[0].Err != influxdb.ErrAdminRequired {
+		t.Fatalf("unexpected error: %s", res[0].Err)
+	}
+}
+
+// Ensure the server rejects deletes with invalid conditions or from non-admin users.
+func TestServer_ExecuteQuery_Delete_Errors(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateUser("susy", "pass", false)
+
+	for _, q := range []string{
+		`DELETE WHERE time > now() - 1h`,
+		`DELETE WHERE host = 'serverA'`,
+		`DELETE WHERE time < now() OR time < now() - 1h`,
+	} {
+		if res := s.ExecuteQuery(MustParseQuery(q), "foo", nil, influxdb.QueryOptions{}); res[0].Err != influxdb.ErrInvalidDeleteCondition {
+			t.Errorf("%s: unexpected error: %s", q, res[0].Err)
+		}
+	}
+
+	if res := s.ExecuteQuery(MustParseQuery(`DELETE WHERE time < now()`), "foo", s.User("susy"), influxdb.QueryOptions{}); res[0].Err != influxdb.ErrAdminRequired {
+		t.Fatalf("unexpected error: %s", res[0].Err)
+	}
+}
+
+// Ensure the server can list tag values across measurements.
+func TestServer_ExecuteQuery_ListTagValues(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "myspace")
+	s.WriteSeries("foo", "myspace", "cpu", map[string]string{"host": "serverA", "region": "uswest"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 1.0})
+	s.WriteSeries("foo", "myspace", "cpu", map[string]string{"host": "serverB", "region": "useast"}, mustParseTime("2000-01-01T02:00:00Z"), map[string]interface{}{"value": 2.0})
+	s.WriteSeries("foo", "myspace", "cpu_idle", map[string]string{"host": "serverB"}, mustParseTime("2000-01-01T02:00:00Z"), map[string]interface{}{"value": 3.0})
+	s.WriteSeries("foo", "myspace", "mem", map[string]string{"host": "serverC"}, mustParseTime("2000-01-01T02:10:00Z"), map[string]interface{}{"value": 4.0})
+	s.Sync(c.index)
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{q: `SHOW TAG VALUES WITH KEY = host`, exp: `[{"name":"host","columns":["value"],"values":[["serverA"],["serverB"],["serverC"]]}]`},
+		{q: `SHOW TAG VALUES FROM /^cpu/`, exp: `[{"name":"host","columns":["value"],"values":[["serverA"],["serverB"]]},{"name":"region","columns":["value"],"values":[["useast"],["uswest"]]}]`},
+		{q: `SHOW TAG VALUES FROM mem WITH KEY IN (host, region)`, exp: `[{"name":"host","columns":["value"],"values":[["serverC"]]}]`},
+		{q: `SHOW TAG VALUES FROM cpu WITH KEY = host WHERE region = 'useast'`, exp: `[{"name":"host","columns":["value"],"values":[["serverB"]]}]`},
+		{q: `SHOW TAG VALUES WITH KEY = host WHERE time >= "2000-01-01 01:00:00"`, exp: `[{"name":"host","columns":["value"],"values":[["serverB"],["serverC"]]}]`},
+		{q: `SHOW TAG VALUES WITH KEY = host WHERE time < "2000-01-01 01:00:00"`, exp: `[{"name":"host","columns":["value"],"values":[["serverA"]]}]`},
+		{q: `SHOW TAG VALUES WITH KEY = host WHERE time > "2000-01-02 00:00:00"`, exp: `null`},
+		{q: `LIST TAG VALUES WITH KEY = host LIMIT 1`, exp: `[{"name":"host","columns":["value"],"values":[["serverA"]]}]`},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil, influxdb.QueryOptions{})
+		if results[0].Err != nil {
+			t.Errorf("%d. %s: unexpected error: %s", i, tt.q, results[0].Err)
+		} else if act := mustMarshalJSON(results[0].Rows); act != tt.exp {
+			t.Errorf("%d. %s: unexpected rows:\n\nexp=%s\n\ngot=%s\n\n", i, tt.q, tt.exp, act)
+		}
+	}
+
+	if res := s.ExecuteQuery(MustParseQuery(`SHOW TAG VALUES WHERE value > 1`), "foo", nil, influxdb.QueryOptions{}); res[0].Err != influxdb.ErrInvalidSeriesCondition {
+		t.Fatalf("unexpected error: %s", res[0].Err)
+	}
+}
+
+// Ensure the server can list series a page at a time.
+func TestServer_ExecuteQuery_ListSeries(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "myspace")
+	s.WriteSeries("foo", "myspace", "mem", map[string]string{"host": "serverC"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 1.0})
+	s.WriteSeries("foo", "myspace", "cpu", map[string]string{"host": "serverA", "region": "uswest"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 2.0})
+	s.WriteSeries("foo", "myspace", "cpu", map[string]string{"host": "serverB", "region": "useast"}, mustParseTime("2000-01-01T02:00:00Z"), map[string]interface{}{"value": 3.0})
+	s.Sync(c.index)
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{q: `SHOW SERIES`, exp: `[{"name":"cpu","columns":["id","host","region"],"values":[[2,"serverA","uswest"],[3,"serverB","useast"]]},{"name":"mem","columns":["id","host"],"values":[[1,"serverC"]]}]`},
+		{q: `SHOW SERIES LIMIT 2`, exp: `[{"name":"cpu","columns":["id","host","region"],"values":[[2,"serverA","uswest"],[3,"serverB","useast"]]}]`},
+		{q: `SHOW SERIES LIMIT 2 OFFSET 1`, exp: `[{"name":"cpu","columns":["id","host","region"],"values":[[3,"serverB","useast"]]},{"name":"mem","columns":["id","host"],"values":[[1,"serverC"]]}]`},
+		{q: `SHOW SERIES OFFSET 3`, exp: `null`},
+		{q: `SHOW SERIES FROM cpu WHERE region = 'uswest'`, exp: `[{"name":"cpu","columns":["id","host","region"],"values":[[2,"serverA","uswest"]]}]`},
+		{q: `SHOW SERIES WHERE time >= "2000-01-01 01:00:00"`, exp: `[{"name":"cpu","columns":["id","host","region"],"values":[[3,"serverB","useast"]]}]`},
+		{q: `SHOW SERIES ESTIMATE`, exp: `[{"name":"cpu","columns":["count"],"values":[[2]]},{"name":"mem","columns":["count"],"values":[[1]]}]`},
+		{q: `SHOW TAG VALUES ESTIMATE`, exp: `[{"name":"host","columns":["count"],"values":[[3]]},{"name":"region","columns":["count"],"values":[[2]]}]`},
+		{q: `SHOW TAG VALUES ESTIMATE FROM mem WITH KEY IN (host, region)`, exp: `[{"name":"host","columns":["count"],"values":[[1]]}]`},
+		{q: `SHOW TAG VALUES WITH KEY = host LIMIT 1 OFFSET 1`, exp: `[{"name":"host","columns":["value"],"values":[["serverB"]]}]`},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil, influxdb.QueryOptions{})
+		if results[0].Err != nil {
+			t.Errorf("%d. %s: unexpected error: %s", i, tt.q, results[0].Err)
+		} else if act := mustMarshalJSON(results[0].Rows); act != tt.exp {
+			t.Errorf("%d. %s: unexpected rows:\n\nexp=%s\n\ngot=%s\n\n", i, tt.q, tt.exp, act)
+		}
+	}
+
+	for _, q := range []string{
+		`SHOW SERIES ESTIMATE WHERE host = 'serverA'`,
+		`SHOW TAG VALUES ESTIMATE WHERE time > now() - 1h`,
+	} {
+		if res := s.ExecuteQuery(MustParseQuery(q), "foo", nil, influxdb.QueryOptions{}); res[0].Err != influxdb.ErrEstimateCondition {
+			t.Errorf("%s: unexpected error: %s", q, res[0].Err)
+		}
+	}
+}
+
+// Ensure the server downsamples the default retention policy into policies
+// with downsampling rules and reads old time ranges from them for rollup queries.
+func TestServer_Downsample(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "5m", Downsample: &influxdb.Downsample{After: time.Hour, Interval: 5 * time.Minute}}); err != nil {
+		t.Fatal(err)
+	}
+	s.WriteSeries("foo", "raw", "cpu", map[string]string{"host": "a"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 10.0})
+	s.WriteSeries("foo", "raw", "cpu", map[string]string{"host": "a"}, mustParseTime("2000-01-01T00:01:00Z"), map[string]interface{}{"value": 20.0})
+	s.WriteSeries("foo", "raw", "cpu", map[string]string{"host": "a"}, mustParseTime("2000-01-01T00:07:00Z"), map[string]interface{}{"value": 30.0})
+	s.WriteSeries("foo", "raw", "cpu", map[string]string{"host": "b"}, mustParseTime("2000-01-01T00:02:00Z"), map[string]interface{}{"value": 6.0})
+	s.WriteSeries("foo", "raw", "cpu", map[string]string{"host": "b"}, mustParseTime("2000-01-01T00:11:00Z"), map[string]interface{}{"value": 8.0})
+	s.Sync(c.index)
+
+	// Aggregate the intervals that ended before 00:12.
+	if err := s.Downsample(mustParseTime("2000-01-01T00:12:00Z")); err != nil {
+		t.Fatal(err)
+	}
+	s.Sync(c.index)
+
+	exec := func(q string, opt influxdb.QueryOptions) string {
+		results := s.ExecuteQuery(MustParseQuery(q), "foo", nil, opt)
+		if results[0].Err != nil {
+			t.Fatalf("unexpected error: %s", results[0].Err)
+		}
+		return mustMarshalJSON(results[0].Rows)
+	}
+
+	// The downsampled policy has a mean for each interval and series with values.
+	if act := exec(`SELECT mean(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 00:15:00" GROUP BY time(5m), host`, influxdb.QueryOptions{RetentionPolicy: "5m"}); act != `[{"name":"cpu","tags":{"host":"b"},"columns":["time","mean"],"values":[[946684800000000,6],[946685100000000,null],[946685400000000,null]]},{"name":"cpu","tags":{"host":"a"},"columns":["time","mean"],"values":[[946684800000000,15],[946685100000000,30],[946685400000000,null]]}]` {
+		t.Fatalf("unexpected downsampled rows: %s", act)
+	}
+
+	// Queries that don't allow rollups only read the default policy.
+	if act := exec(`SELECT mean(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 00:10:00" GROUP BY time(10m)`, influxdb.QueryOptions{}); act != `[{"name":"cpu","columns":["time","mean"],"values":[[946684800000000,16.5]]}]` {
+		t.Fatalf("unexpected raw rows: %s", act)
+	}
+
+	// Old means grouped by a multiple of the interval are read from the
+	// downsampled policy and the results report its resolution.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT mean(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 00:10:00" GROUP BY time(10m)`), "foo", nil, influxdb.QueryOptions{Rollup: true})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if act := mustMarshalJSON(results[0].Rows); act != `[{"name":"cpu","columns":["time","mean"],"values":[[946684800000000,17]]}]` {
+		t.Fatalf("unexpected rollup rows: %s", act)
+	} else if r := results[0].Resolution; r == nil || r.RetentionPolicy != "5m" || r.Interval != 5*time.Minute {
+		t.Fatalf("unexpected resolution: %#v", r)
+	}
+
+	// Other functions and intervals are read from the default policy.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 00:10:00" GROUP BY time(10m)`), "foo", nil, influxdb.QueryOptions{Rollup: true})
+	if act := mustMarshalJSON(results[0].Rows); act != `[{"name":"cpu","columns":["time","sum"],"values":[[946684800000000,66]]}]` {
+		t.Fatalf("unexpected sum rows: %s", act)
+	} else if r := results[0].Resolution; r == nil || r.RetentionPolicy != "raw" || r.Interval != 0 {
+		t.Fatalf("unexpected resolution: %#v", r)
+	}
+	if act := exec(`SELECT mean(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 00:10:00" GROUP BY time(2m)`, influxdb.QueryOptions{Rollup: true}); act != `[{"name":"cpu","columns":["time","mean"],"values":[[946684800000000,15],[946684920000000,6],[946685040000000,null],[946685160000000,30],[946685280000000,null]]}]` {
+		t.Fatalf("unexpected raw rows: %s", act)
+	}
+
+	// Progress is recorded and kept after a restart.
+	s.Restart()
+	if rp, _ := s.RetentionPolicy("foo", "5m"); !rp.Downsample.Completed.Equal(mustParseTime("2000-01-01T00:10:00Z")) {
+		t.Fatalf("unexpected completed time: %s", rp.Downsample.Completed)
+	}
+}
+
+// Ensure a backfill recomputes downsampled intervals with points written late.
+func TestServer_StartBackfill(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "5m", Downsample: &influxdb.Downsample{After: time.Hour, Interval: 5 * time.Minute}})
+	s.WriteSeries("foo", "raw", "cpu", map[string]string{"host": "a"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 10.0})
+	s.WriteSeries("foo", "raw", "cpu", map[string]string{"host": "a"}, mustParseTime("2000-01-01T00:07:00Z"), map[string]interface{}{"value": 30.0})
+	s.Sync(c.index)
+	if err := s.Downsample(mustParseTime("2000-01-01T00:12:00Z")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Import a point into an interval that has already been aggregated.
+	s.WriteSeries("foo", "raw", "cpu", map[string]string{"host": "a"}, mustParseTime("2000-01-01T00:01:00Z"), map[string]interface{}{"value": 20.0})
+	s.Sync(c.index)
+
+	b, err := s.StartBackfill("foo", "5m", mustParseTime("2000-01-01T00:01:00Z"), mustParseTime("2000-01-01T00:09:00Z"), 0)
+	if err != nil {
+		t.Fatal(err)
+	} else if !b.Start.Equal(mustParseTime("2000-01-01T00:00:00Z")) || !b.End.Equal(mustParseTime("2000-01-01T00:10:00Z")) {
+		t.Fatalf("unexpected range: %s - %s", b.Start, b.End)
+	}
+
+	// Wait for the backfill to finish.
+	for i := 0; ; i++ {
+		if a := s.Backfills(); len(a) == 1 && a[0].Done {
+			if a[0].Err != "" || a[0].PointsWritten != 2 || !a[0].Completed.Equal(b.End) {
+				t.Fatalf("unexpected backfill: %s", mustMarshalJSON(a[0]))
+			}
+			break
+		} else if i == 100 {
+			t.Fatal("backfill timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.Sync(c.index)
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT mean(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 00:10:00" GROUP BY time(5m)`), "foo", nil, influxdb.QueryOptions{RetentionPolicy: "5m"})
+	if results[0].Err != nil {
+		t.Fatal(results[0].Err)
+	} else if act := mustMarshalJSON(results[0].Rows); act != `[{"name":"cpu","columns":["time","mean"],"values":[[946684800000000,15],[946685100000000,30]]}]` {
+		t.Fatalf("unexpected rows: %s", act)
+	}
+
+	// Only policies with downsampling rules can be backfilled.
+	if _, err := s.StartBackfill("foo", "raw", mustParseTime("2000-01-01T00:00:00Z"), mustParseTime("2000-01-01T00:10:00Z"), 0); err != influxdb.ErrDownsampleNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := s.StartBackfill("foo", "5m", mustParseTime("2000-01-01T00:10:00Z"), mustParseTime("2000-01-01T00:10:00Z"), 0); err != influxdb.ErrInvalidBackfillRange {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := s.StartBackfill("bar", "5m", mustParseTime("2000-01-01T00:00:00Z"), mustParseTime("2000-01-01T00:10:00Z"), 0); err != influxdb.ErrDatabaseNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the server rejects invalid downsampling rules.
+func TestServer_CreateRetentionPolicy_Downsample_Errors(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw"})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Downsample: &influxdb.Downsample{}}); err != influxdb.ErrDownsampleIntervalRequired {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Downsample: &influxdb.Downsample{Interval: time.Minute, Function: "max"}}); err != influxdb.ErrInvalidDownsampleFunction {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := s.UpdateRetentionPolicy("foo", "raw", &influxdb.RetentionPolicy{Downsample: &influxdb.Downsample{Interval: time.Minute}}); err != influxdb.ErrDownsampleDefaultPolicy {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// A downsampled policy cannot become the default.
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Downsample: &influxdb.Downsample{Interval: time.Minute, Function: "sum"}})
+	if err := s.SetDefaultRetentionPolicy("foo", "bar"); err != influxdb.ErrDownsampleDefaultPolicy {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure the server can return a trace of a query with its results.
+func TestServer_ExecuteQuery_Trace(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 10.0})
+	s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:10Z"), map[string]interface{}{"value": 20.0})
+	s.Sync(c.index)
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM cpu`), "foo", nil, influxdb.QueryOptions{Trace: true})
+	if r := results[0]; r.Err != nil {
+		t.Fatalf("unexpected error: %s", r.Err)
+	} else if len(r.Rows) != 1 || r.Rows[0].Values[0][1] != int64(2) {
+		t.Fatalf("unexpected rows: %s", mustMarshalJSON(r.Rows))
+	} else if r.Trace == nil || r.Trace.Name != "select" || r.Trace.Rows != 1 {
+		t.Fatalf("unexpected trace: %s", mustMarshalJSON(r.Trace))
+	} else if n := r.Trace.Children[1].Children[1]; n.Name != "iterator" || n.Rows != 1 {
+		t.Fatalf("unexpected iterator trace: %s", mustMarshalJSON(n))
+	}
+}
+
+// Ensure the server tracks write and query statistics per database.
+// Ensure the UDP server writes received series using its time precision.
+func TestUDPServer(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "myspace")
+
+	// Start a UDP server on a random port.
+	u := influxdb.NewUDPServer(s.Server)
+	u.Database = "foo"
+	u.Precision = influxdb.MillisecondPrecision
+	u.Addr = &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}
+	if err := u.ListenAndServe(); err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+
+	// Send a series to the server.
+	conn, err := net.Dial("udp", u.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(`[{"name":"cpu","columns":["time","value"],"points":[[946684800000,23.2]]}]`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the point to be written.
+	for i := 0; s.DatabaseStats("foo").Get(influxdb.StatPointsWritten) == 0; i++ {
+		if i == 100 {
+			t.Fatal("timed out waiting for point")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.Sync(c.index)
+
+	// Verify the point was written with the correct timestamp.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= '2000-01-01' AND time < '2000-01-01T00:00:01Z'`), "foo", nil, influxdb.QueryOptions{})
+	if s := mustMarshalJSON(results); s != `[{"statement_id":0,"rows":[{"name":"cpu","columns":["time","sum"],"values":[[946684800000000,23.2]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+}
+
+func TestServer_DatabaseStats(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateDatabase("bar")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+
+	// Write two points and fail a third write.
+	s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 10.0})
+	s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:10Z"), map[string]interface{}{"value": 20.0})
+	if err := s.WriteSeries("foo", "no_such_policy", "cpu", nil, mustParseTime("2000-01-01T00:00:20Z"), map[string]interface{}{"value": 30.0}); err == nil {
+		t.Fatal("expected error")
+	}
+	s.Sync(c.index)
+
+	// Execute one successful and one failed statement.
+	s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu; SELECT sum(value) FROM mem`), "foo", nil, influxdb.QueryOptions{})
+
+	// Verify the statistics on the database.
+	st := s.DatabaseStats("foo")
+	if n := st.Get(influxdb.StatPointsWritten); n != 2 {
+		t.Fatalf("unexpected points written: %d", n)
+	} else if n := st.Get(influxdb.StatBytesIn); n <= 0 {
+		t.Fatalf("unexpected bytes in: %d", n)
+	} else if n := st.Get(influxdb.StatWriteErrors); n != 1 {
+		t.Fatalf("unexpected write errors: %d", n)
+	} else if n := st.Get(influxdb.StatQueriesExecuted); n != 2 {
+		t.Fatalf("unexpected queries executed: %d", n)
+	} else if n := st.Get(influxdb.StatQueryErrors); n != 1 {
+		t.Fatalf("unexpected query errors: %d", n)
+	}
+
+	// Verify that other databases are not affected.
+	if n := s.DatabaseStats("bar").Get(influxdb.StatPointsWritten); n != 0 {
+		t.Fatalf("unexpected points written on other database: %d", n)
+	}
+
+	// Verify that statistics are not returned for missing databases.
+	if s.DatabaseStats("no_such_db") != nil {
+		t.Fatal("expected nil stats")
+	}
+}
+
+// Ensure the server can return per-database statistics through a query.
+func TestServer_ExecuteQuery_ShowStats(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateDatabase("bar")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.CreateUser("susy", "pass", false)
+	s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 10.0})
+	s.Sync(c.index)
+
+	// Admins can see all databases.
+	results := s.ExecuteQuery(MustParseQuery(`SHOW STATS`), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if len(results[0].Rows) != 2 {
+		t.Fatalf("unexpected row count: %d", len(results[0].Rows))
+	} else if row := results[0].Rows[1]; row.Tags["database"] != "foo" {
+		t.Fatalf("unexpected tags: %v", row.Tags)
+	} else if !reflect.DeepEqual(row.Columns, []string{"pointsWritten", "bytesIn", "writeErrors", "queriesExecuted", "queryErrors", "queryCacheHits", "queryCacheMisses", "resultCacheHits", "resultCacheMisses", "duplicatesDropped", "timestampsCorrected"}) {
+		t.Fatalf("unexpected columns: %v", row.Columns)
+	} else if v := row.Values[0]; v[0] != int64(1) || v[1].(int64) <= 0 || v[2] != int64(0) {
+		t.Fatalf("unexpected values: %v", v)
+	}
+
+	// Non-admin users only see the current database.
+	results = s.ExecuteQuery(MustParseQuery(`SHOW STATS`), "bar", s.User("susy"), influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if len(results[0].Rows) != 1 {
+		t.Fatalf("unexpected row count: %d", len(results[0].Rows))
+	} else if s := mustMarshalJSON(results[0].Rows[0]); s != `{"name":"database","tags":{"database":"bar"},"columns":["pointsWritten","bytesIn","writeErrors","queriesExecuted","queryErrors","queryCacheHits","queryCacheMisses","resultCacheHits","resultCacheMisses","duplicatesDropped","timestampsCorrected"],"values":[[0,0,0,0,0,0,0,0,0,0,0]]}` {
+		t.Fatalf("unexpected row: %s", s)
+	}
+}
+
+// Ensure the server returns quota usage for a single database.
+func TestServer_ExecuteQuery_ShowStatsForDatabase(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateDatabase("bar")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.CreateUser("susy", "pass", false)
+	maxSeries, maxRetention := 10, 24*time.Hour
+	s.UpdateDatabase("foo", &influxdb.DatabaseUpdate{MaxSeries: &maxSeries, MaxRetention: &maxRetention})
+	s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 10.0})
+	s.Sync(c.index)
+
+	results := s.ExecuteQuery(MustParseQuery(`SHOW STATS FOR DATABASE foo`), "", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if len(results[0].Rows) != 1 {
+		t.Fatalf("unexpected row count: %d", len(results[0].Rows))
+	} else if row := results[0].Rows[0]; !reflect.DeepEqual(row.Columns[11:], []string{"series", "maxSeries", "diskBytes", "maxDiskBytes", "maxRetention"}) {
+		t.Fatalf("unexpected columns: %v", row.Columns)
+	} else if v := row.Values[0][11:]; v[0] != int64(1) || v[1] != int64(10) || v[2].(int64) <= 0 || v[3] != int64(0) || v[4] != "1d" {
+		t.Fatalf("unexpected values: %v", v)
+	}
+
+	// Non-admin users can only see the current database.
+	results = s.ExecuteQuery(MustParseQuery(`SHOW STATS FOR DATABASE foo`), "bar", s.User("susy"), influxdb.QueryOptions{})
+	if results[0].Err != influxdb.ErrReadAccessDenied {
+		t.Fatalf("unexpected error: %v", results[0].Err)
+	}
+}
+
+// Ensure the server rejects writes that create more series than a database allows.
+func TestServer_WriteSeries_ErrSeriesQuotaExceeded(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	maxSeries := 2
+	if err := s.UpdateDatabase("foo", &influxdb.DatabaseUpdate{MaxSeries: &maxSeries}); err != nil {
+		t.Fatal(err)
+	}
+
+	timestamp := mustParseTime("2000-01-01T00:00:00Z")
+	for _, host := range []string{"a", "b"} {
+		if err := s.WriteSeries("foo", "raw", "cpu", map[string]string{"host": host}, timestamp, map[string]interface{}{"value": 1.0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.WriteSeries("foo", "raw", "cpu", map[string]string{"host": "c"}, timestamp, map[string]interface{}{"value": 1.0}); err != influxdb.ErrSeriesQuotaExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Existing series can still be written to.
+	if err := s.WriteSeries("foo", "raw", "cpu", map[string]string{"host": "a"}, timestamp.Add(time.Second), map[string]interface{}{"value": 2.0}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server rejects writes once a database has reached its disk quota.
+func TestServer_WriteSeries_ErrDiskQuotaExceeded(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	maxDiskBytes := int64(1)
+	s.UpdateDatabase("foo", &influxdb.DatabaseUpdate{MaxDiskBytes: &maxDiskBytes})
+
+	// The first write creates the database's first shard.
+	timestamp := mustParseTime("2000-01-01T00:00:00Z")
+	if err := s.WriteSeries("foo", "raw", "cpu", nil, timestamp, map[string]interface{}{"value": 1.0}); err != nil {
+		t.Fatal(err)
+	}
+	s.Sync(c.index)
+
+	if err := s.WriteSeries("foo", "raw", "cpu", nil, timestamp.Add(time.Second), map[string]interface{}{"value": 1.0}); err != influxdb.ErrDiskQuotaExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the server rejects retention policies that keep data longer than a database allows.
+func TestServer_CreateRetentionPolicy_ErrRetentionQuotaExceeded(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "forever"})
+
+	// The quota cannot be set below an existing policy.
+	maxRetention := 24 * time.Hour
+	if err := s.UpdateDatabase("foo", &influxdb.DatabaseUpdate{MaxRetention: &maxRetention}); err != influxdb.ErrRetentionQuotaExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.DeleteRetentionPolicy("foo", "forever")
+	if err := s.UpdateDatabase("foo", &influxdb.DatabaseUpdate{MaxRetention: &maxRetention}); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "weekly", Duration: 7 * 24 * time.Hour}); err != influxdb.ErrRetentionQuotaExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "forever"}); err != influxdb.ErrRetentionQuotaExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "daily", Duration: 24 * time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Altering a policy is also checked.
+	results := s.ExecuteQuery(MustParseQuery(`ALTER RETENTION POLICY daily ON foo DURATION 2d`), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != influxdb.ErrRetentionQuotaExceeded {
+		t.Fatalf("unexpected error: %v", results[0].Err)
+	}
+}
+
+// Ensure the server records metadata changes in the audit log.
+func TestServer_ExecuteQuery_ShowAudit(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateUser("susy", "pass", false)
+	s.CreateUser("admin", "pass", true)
+
+	// Reading the audit log requires a log file.
+	results := s.ExecuteQuery(MustParseQuery(`SHOW AUDIT`), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != influxdb.ErrAuditLogDisabled {
+		t.Fatalf("unexpected error: %v", results[0].Err)
+	}
+
+	path := tempfile()
+	defer os.Remove(path)
+	if err := s.SetAuditLog(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only successful statements are recorded.
+	opt := influxdb.QueryOptions{RemoteAddr: "10.0.0.1"}
+	s.ExecuteQuery(MustParseQuery(`ALTER DATABASE foo READ ONLY; ALTER DATABASE no_such_db DISABLE; ALTER DATABASE foo READ WRITE`), "foo", s.User("admin"), opt)
+	s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu`), "foo", s.User("admin"), opt)
+
+	results = s.ExecuteQuery(MustParseQuery(`SHOW AUDIT LIMIT 1`), "foo", s.User("admin"), influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if row := results[0].Rows[0]; !reflect.DeepEqual(row.Columns, []string{"time", "user", "source", "action", "target"}) {
+		t.Fatalf("unexpected columns: %v", row.Columns)
+	} else if len(row.Values) != 1 {
+		t.Fatalf("unexpected entry count: %d", len(row.Values))
+	} else if v := row.Values[0]; v[1] != "admin" || v[2] != "10.0.0.1" || v[3] != "alter database" || v[4] != "foo" {
+		t.Fatalf("unexpected values: %v", v)
+	}
+
+	// The log is kept across restarts.
+	s.Restart()
+	if a, err := s.AuditLog(0); err != nil {
+		t.Fatal(err)
+	} else if len(a) != 2 {
+		t.Fatalf("unexpected entry count: %d", len(a))
+	}
+
+	// Non-admin users cannot read the audit log.
+	results = s.ExecuteQuery(MustParseQuery(`SHOW AUDIT`), "foo", s.User("susy"), influxdb.QueryOptions{})
+	if results[0].Err != influxdb.ErrAdminRequired {
+		t.Fatalf("unexpected error: %v", results[0].Err)
+	}
+}
+
+// Ensure the server lists running statements.
+func TestServer_ExecuteQuery_ShowQueries(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateUser("susy", "pass", false)
+
+	// The statement itself is listed while it runs.
+	results := s.ExecuteQuery(MustParseQuery(`SHOW QUERIES`), "foo", s.User("susy"), influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if row := results[0].Rows[0]; !reflect.DeepEqual(row.Columns, []string{"id", "database", "user", "query", "duration", "memory"}) {
+		t.Fatalf("unexpected columns: %v", row.Columns)
+	} else if len(row.Values) != 1 {
+		t.Fatalf("unexpected value count: %d", len(row.Values))
+	} else if v := row.Values[0]; v[0] != uint64(1) || v[1] != "foo" || v[2] != "susy" || v[3] != "SHOW QUERIES" || v[5] != int64(0) {
+		t.Fatalf("unexpected values: %v", v)
+	}
+
+	// Finished statements are removed.
+	results = s.ExecuteQuery(MustParseQuery(`SHOW QUERIES`), "foo", nil, influxdb.QueryOptions{})
+	if v := results[0].Rows[0].Values; len(v) != 1 || v[0][0] != uint64(2) {
+		t.Fatalf("unexpected values: %v", v)
+	}
+}
+
+// Ensure the server aborts statements that allocate more memory than their limit.
+func TestServer_ExecuteQuery_ErrQueryMemoryExceeded(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	for i := 0; i < 10; i++ {
+		s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z").Add(time.Duration(i)*time.Second), map[string]interface{}{"value": float64(i)})
+	}
+	s.Sync(c.index)
+
+	for i, tt := range []struct {
+		max int64
+		err error
+	}{
+		{max: 0, err: nil},
+		{max: 1 << 20, err: nil},
+		{max: 100, err: influxdb.ErrQueryMemoryExceeded},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil, influxdb.QueryOptions{MaxMemory: tt.max})
+		if err := results[0].Err; err != tt.err {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		}
+	}
+}
+
+// Ensure the server stops statements once the request's context is done.
+func TestServer_ExecuteQuery_Context(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": float64(1)})
+	s.Sync(c.index)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	for i, tt := range []struct {
+		ctx context.Context
+		err error
+	}{
+		{ctx: nil, err: nil},
+		{ctx: context.Background(), err: nil},
+		{ctx: canceled, err: influxdb.ErrQueryCanceled},
+		{ctx: expired, err: influxdb.ErrQueryTimeout},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu; SELECT count(value) FROM cpu`), "foo", nil, influxdb.QueryOptions{Context: tt.ctx})
+		for j, r := range results {
+			if r.Err != tt.err {
+				t.Errorf("%d.%d. unexpected error: %v", i, j, r.Err)
+			}
+		}
+	}
+}
+
+// Ensure the server spills points to disk instead of exceeding a query's memory limit.
+func TestServer_ExecuteQuery_QuerySpill(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 200; j++ {
+			s.WriteSeries("foo", "myspace", "cpu", map[string]string{"host": fmt.Sprintf("server%d", i)}, mustParseTime("2000-01-01T00:00:00Z").Add(time.Duration(j)*time.Second), map[string]interface{}{"value": float64(j)})
+		}
+	}
+	s.Sync(c.index)
+
+	q := `SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 00:04:00" GROUP BY time(1m), host`
+	exp := s.ExecuteQuery(MustParseQuery(q), "foo", nil, influxdb.QueryOptions{})
+	if exp[0].Err != nil {
+		t.Fatalf("unexpected error: %s", exp[0].Err)
+	}
+
+	// The query is killed without a spill directory.
+	results := s.ExecuteQuery(MustParseQuery(q), "foo", nil, influxdb.QueryOptions{MaxMemory: 8000})
+	if err := results[0].Err; err != influxdb.ErrQueryMemoryExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The query completes with the same results once points can be spilled.
+	dir := tempfile()
+	defer os.RemoveAll(dir)
+	s.SetQuerySpillDir(dir)
+	results = s.ExecuteQuery(MustParseQuery(q), "foo", nil, influxdb.QueryOptions{MaxMemory: 8000})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if !reflect.DeepEqual(results, exp) {
+		t.Fatalf("unexpected results: %s", mustMarshalJSON(results))
+	}
+
+	// Spilled files are removed once the query completes.
+	if fis, err := ioutil.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	} else if len(fis) != 0 {
+		t.Fatalf("unexpected spill file count: %d", len(fis))
+	}
+
+	// The query fails with the spill error if points can't be spilled.
+	path := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	s.SetQuerySpillDir(filepath.Join(path, "spill"))
+	results = s.ExecuteQuery(MustParseQuery(q), "foo", nil, influxdb.QueryOptions{MaxMemory: 8000})
+	if err := results[0].Err; err == nil || !strings.HasPrefix(err.Error(), "write spill: ") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the server caches parsed queries and records cache hits and misses.
+func TestServer_ParseQuery(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "myspace")
+	s.WriteSeries("foo", "myspace", "cpu", map[string]string{"host": "servera"}, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 10.0})
+	s.Sync(c.index)
+
+	// Parse and execute a query. Planning folds the condition in place.
+	q, err := s.ParseQuery("foo", `SELECT sum(value) FROM cpu WHERE host = $host AND 1 = 1`, map[string]interface{}{"host": "servera"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := q.String()
+	if results := s.ExecuteQuery(q, "foo", nil, influxdb.QueryOptions{}); results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	}
+
+	// The same query with different formatting is served from the cache, unmodified.
+	other, err := s.ParseQuery("foo", `select sum(value)  from cpu where host=$host and 1=1`, map[string]interface{}{"host": "servera"})
+	if err != nil {
+		t.Fatal(err)
+	} else if other == q {
+		t.Fatal("expected a copy of the cached query")
+	} else if other.String() != exp {
+		t.Fatalf("unexpected query: %s", other)
+	}
+
+	// Different parameters and databases are cached separately.
+	if _, err := s.ParseQuery("foo", `SELECT sum(value) FROM cpu WHERE host = $host AND 1 = 1`, map[string]interface{}{"host": "serverb"}); err != nil {
+		t.Fatal(err)
+	} else if _, err := s.ParseQuery("bar", `SELECT sum(value) FROM cpu WHERE host = $host AND 1 = 1`, map[string]interface{}{"host": "servera"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Parse errors are not cached.
+	if _, err := s.ParseQuery("foo", `SELECT`, nil); err == nil {
+		t.Fatal("expected error")
+	} else if _, err := s.ParseQuery("foo", `SELECT`, nil); err == nil {
+		t.Fatal("expected error")
+	}
+
+	st := s.DatabaseStats("foo")
+	if n := st.Get(influxdb.StatQueryCacheHits); n != 1 {
+		t.Fatalf("unexpected cache hits: %d", n)
+	} else if n := st.Get(influxdb.StatQueryCacheMisses); n != 4 {
+		t.Fatalf("unexpected cache misses: %d", n)
+	}
+}
+
+// Ensure the server periodically writes statistics to a monitoring database.
+func TestServer_StartSelfMonitoring(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	// Signal when a point is written to the broker.
+	written := make(chan struct{}, 1)
+	c.PublishFunc = func(m *messaging.Message) (uint64, error) {
+		if m.Type == messaging.MessageType(0x80) {
+			select {
+			case written <- struct{}{}:
+			default:
+			}
+		}
+		return c.send(m)
+	}
+
+	if err := s.StartSelfMonitoring("_internal", "default", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	} else if !s.DatabaseExists("_internal") {
+		t.Fatal("expected monitoring database")
+	} else if rp, _ := s.RetentionPolicy("_internal", "default"); rp == nil {
+		t.Fatal("expected monitoring retention policy")
+	}
+
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for statistics")
+	}
+}
+
+// Ensure self-monitoring writes audit log entries to the monitoring database.
+func TestServer_StartSelfMonitoring_Audit(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if err := s.StartSelfMonitoring("_internal", "default", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	results := s.ExecuteQuery(MustParseQuery(`ALTER DATABASE foo READ ONLY`), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	}
+
+	// Wait for the audit series to be created.
+	timeout := time.After(time.Second)
+	for {
+		for _, name := range s.MeasurementNames("_internal") {
+			if name == "audit" {
+				return
+			}
+		}
+		select {
+		case <-timeout:
+			t.Fatal("timeout waiting for audit series")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// Ensure self-monitoring requires a positive interval.
+func TestServer_StartSelfMonitoring_ErrInvalidMonitorInterval(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	if err := s.StartSelfMonitoring("_internal", "default", 0); err != influxdb.ErrInvalidMonitorInterval {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure the server compacts cold shards in the background without changing their data.
+func TestServer_StartCompaction(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	for i := 0; i < 200; i++ {
+		s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z").Add(time.Duration(i)*time.Second), map[string]interface{}{"value": float64(i)})
+	}
+	s.Sync(c.index)
+
+	q := `SELECT sum(value) FROM cpu`
+	exp := s.ExecuteQuery(MustParseQuery(q), "foo", nil, influxdb.QueryOptions{})
+	infos, err := s.ShardInfos("foo", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(infos) != 1 {
+		t.Fatalf("unexpected shard count: %d", len(infos))
+	}
+	size := infos[0].Size
+
+	if err := s.StartCompaction(influxdb.CompactionConfig{CheckInterval: 10 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the shard to shrink.
+	for timeout := time.After(5 * time.Second); ; {
+		if infos, _ := s.ShardInfos("foo", time.Time{}, time.Time{}); infos[0].Size < size {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatal("timeout waiting for compaction")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	if results := s.ExecuteQuery(MustParseQuery(q), "foo", nil, influxdb.QueryOptions{}); !reflect.DeepEqual(results, exp) {
+		t.Fatalf("unexpected results: %s", mustMarshalJSON(results))
+	}
+}
+
+// Ensure compaction requires a positive check interval.
+func TestServer_StartCompaction_ErrInvalidCompactionInterval(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	if err := s.StartCompaction(influxdb.CompactionConfig{}); err != influxdb.ErrInvalidCompactionInterval {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure closing the server waits for running writes and rejects new queries.
+func TestServer_Close_Drain(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer os.RemoveAll(s.Path())
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "myspace")
+	s.Sync(c.index)
+
+	// Hold the next write until it is released.
+	published, release := make(chan struct{}), make(chan struct{})
+	c.PublishFunc = func(m *messaging.Message) (uint64, error) {
+		if m.Type == messaging.MessageType(0x80) {
+			close(published)
+			<-release
+		}
+		return c.send(m)
+	}
+	writeErr := make(chan error)
+	go func() {
+		writeErr <- s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 100.0})
+	}()
+	<-published
+
+	// Close the server while the write is running.
+	closed := make(chan error)
+	go func() { closed <- s.Server.Close() }()
+
+	// New queries and writes are rejected once the server is shutting down.
+	for {
+		results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil, influxdb.QueryOptions{})
+		if results[0].Err == influxdb.ErrServerShuttingDown {
+			break
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+	if err := s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 100.0}); err != influxdb.ErrServerShuttingDown {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The server waits for the running write before closing.
+	select {
+	case <-closed:
+		t.Fatal("server closed before write finished")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	if err := <-writeErr; err != nil {
+		t.Fatalf("unexpected write error: %s", err)
+	} else if err := <-closed; err != nil {
+		t.Fatalf("unexpected close error: %s", err)
+	}
+}
+
+// Ensure closing the server stops waiting for running writes after the shutdown timeout.
+func TestServer_Close_DrainTimeout(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer os.RemoveAll(s.Path())
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.Sync(c.index)
+	s.SetShutdownTimeout(10 * time.Millisecond)
+
+	// Hold the next write until the server has closed.
+	published, release := make(chan struct{}), make(chan struct{})
+	c.PublishFunc = func(m *messaging.Message) (uint64, error) {
+		if m.Type == messaging.MessageType(0x80) {
+			close(published)
+			<-release
+			return m.Index, nil
+		}
+		return c.send(m)
+	}
+	defer close(release)
+	go s.WriteSeries("foo", "myspace", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z"), map[string]interface{}{"value": 100.0})
+	<-published
+
+	closed := make(chan error)
+	go func() { closed <- s.Server.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("unexpected close error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for close")
+	}
+}
+
+func TestServer_CreateShardIfNotExist(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.CreateShardsIfNotExists("foo", "bar", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ss, err := s.Shards("foo"); err != nil {
+		t.Fatal(err)
+	} else if len(ss) != 1 {
+		t.Fatalf("expected 1 shard but found %d", len(ss))
+	}
+}
+
+// Ensure a shard group is split into SplitN shards and each series is written
+// to a single shard of the group.
+func TestServer_WriteSeries_SplitN(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: time.Hour, SplitN: 3})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	// Write two points to each of several series.
+	for i := 0; i < 10; i++ {
+		tags := map[string]string{"host": fmt.Sprintf("server%d", i)}
+		for _, ts := range []string{"2000-01-01T00:00:00Z", "2000-01-01T00:30:00Z"} {
+			if err := s.WriteSeries("foo", "raw", "cpu", tags, mustParseTime(ts), map[string]interface{}{"value": float64(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	s.Restart()
+
+	if ss, err := s.Shards("foo"); err != nil {
+		t.Fatal(err)
+	} else if len(ss) != 3 {
+		t.Fatalf("unexpected shard count: %d", len(ss))
+	}
+
+	// Every point is returned.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00" GROUP BY host`), "foo", nil, influxdb.QueryOptions{})
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Rows) != 10 {
+		t.Fatalf("unexpected row count: %d", len(res.Rows))
+	} else {
+		for _, row := range res.Rows {
+			if s := mustMarshalJSON(row.Values); s != `[[946684800000000,2]]` {
+				t.Fatalf("unexpected row: %s", mustMarshalJSON(row))
+			}
+		}
+	}
+}
+
+// Ensure the server can delete a shard and its data.
+func TestServer_DeleteShard(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	s.CreateShardsIfNotExists("foo", "bar", mustParseTime("2000-01-01T00:00:00Z"))
+
+	// Retrieve the shard and verify its data file exists.
+	ss, err := s.Shards("foo")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ss) != 1 {
+		t.Fatalf("unexpected shard count: %d", len(ss))
+	}
+	path := filepath.Join(s.Path(), "shards", strconv.FormatUint(ss[0].ID, 10))
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Delete the shard.
+	if err := s.DeleteShard("foo", ss[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected shard file to be removed: %v", err)
+	}
+
+	// Verify the shard is removed from the database and retention policy after restart.
+	s.Restart()
+	if ss, _ := s.Shards("foo"); len(ss) != 0 {
+		t.Fatalf("unexpected shard count after delete: %d", len(ss))
+	} else if rp, _ := s.RetentionPolicy("foo", "bar"); len(rp.Shards) != 0 {
+		t.Fatalf("unexpected retention policy shard count after delete: %d", len(rp.Shards))
+	}
+
+	// Deleting it again returns an error.
+	if err := s.DeleteShard("foo", ss[0].ID); err != influxdb.ErrShardNotFound {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := s.DeleteShard("no_such_db", ss[0].ID); err != influxdb.ErrDatabaseNotFound {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure queries and writes read shards that were closed to stay within the
+// open shard limit.
+func TestServer_MaxOpenShards(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.SetMaxOpenShards(1)
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	for i, ts := range []string{"2000-01-01T00:00:00Z", "2000-01-01T01:00:00Z", "2000-01-01T02:00:00Z"} {
+		s.WriteSeries("foo", "raw", "cpu", nil, mustParseTime(ts), map[string]interface{}{"value": float64(i + 1)})
+	}
+	s.Sync(c.index)
+	s.Restart()
+
+	// Write to the first shard again after it was opened lazily.
+	s.WriteSeries("foo", "raw", "cpu", nil, mustParseTime("2000-01-01T00:00:10Z"), map[string]interface{}{"value": float64(10)})
+	s.Sync(c.index)
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 03:00:00" GROUP BY time(1h)`), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if s := mustMarshalJSON(results[0].Rows); s != `[{"name":"cpu","columns":["time","sum"],"values":[[946684800000000,11],[946688400000000,2],[946692000000000,3]]}]` {
+		t.Fatalf("unexpected rows: %s", s)
+	}
+}
+
+func TestServer_Measurements(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "myspace", Duration: 1 * time.Hour})
+	s.CreateUser("susy", "pass", false)
+
+	// Write series with one point to the database.
+	timestamp := mustParseTime("2000-01-01T00:00:00Z")
+
+	tags := map[string]string{"host": "servera.influx.com", "region": "uswest"}
+	values := map[string]interface{}{"value": 23.2}
+
+	if err := s.WriteSeries("foo", "myspace", "cpu_load", tags, timestamp, values); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedMeasurementNames := []string{"cpu_load"}
+	expectedSeriesIDs := influxdb.SeriesIDs([]uint32{uint32(1)})
+	names := s.MeasurementNames("foo")
+	if !reflect.DeepEqual(names, expectedMeasurementNames) {
+		t.Fatalf("Mesurements not the same:\n  exp: %s\n  got: %s", expectedMeasurementNames, names)
+	}
+	ids := s.MeasurementSeriesIDs("foo", "foo")
+	if !ids.Equals(expectedSeriesIDs) {
+		t.Fatalf("Series IDs not the same:\n  exp: %s\n  got: %s", expectedSeriesIDs, ids)
+	}
+
+	s.Restart()
+
+	names = s.MeasurementNames("foo")
+	if !reflect.DeepEqual(names, expectedMeasurementNames) {
+		t.Fatalf("Mesurements not the same:\n  exp: %s\n  got: %s", expectedMeasurementNames, names)
+	}
+	ids = s.MeasurementSeriesIDs("foo", "foo")
+	if !ids.Equals(expectedSeriesIDs) {
+		t.Fatalf("Series IDs not the same:\n  exp: %s\n  got: %s", expectedSeriesIDs, ids)
+	}
+}
+
+func mustMarshalJSON(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic("marshal: " + err.Error())
+	}
+	return string(b)
+}
+
+func measurementsEqual(l influxdb.Measurements, r influxdb.Measurements) bool {
 	if mustMarshalJSON(l) == mustMarshalJSON(r) {
 		return true
 	}
@@ -645,10 +3298,14 @@ func (s *Server) Close() {
 
 // MessagingClient represents a test client for the messaging broker.
 type MessagingClient struct {
+	mu    sync.Mutex
 	index uint64
 	c     chan *messaging.Message
 
 	PublishFunc func(*messaging.Message) (uint64, error)
+
+	// Brokers are returned by URLs().
+	Brokers []*url.URL
 }
 
 // NewMessagingClient returns a new instance of MessagingClient.
@@ -661,6 +3318,8 @@ func NewMessagingClient() *MessagingClient {
 // Publish attaches an autoincrementing index to the message.
 // This function also execute's the client's PublishFunc mock function.
 func (c *MessagingClient) Publish(m *messaging.Message) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.index++
 	m.Index = c.index
 	return c.PublishFunc(m)
@@ -676,6 +3335,9 @@ func (c *MessagingClient) send(m *messaging.Message) (uint64, error) {
 // C returns a channel for streaming message.
 func (c *MessagingClient) C() <-chan *messaging.Message { return c.c }
 
+// URLs returns the client's brokers.
+func (c *MessagingClient) URLs() []*url.URL { return c.Brokers }
+
 // tempfile returns a temporary path.
 func tempfile() string {
 	f, _ := ioutil.TempFile("", "influxdb-")
@@ -694,6 +3356,24 @@ func mustParseTime(s string) time.Time {
 	return t
 }
 
+// MustParseQuery parses an InfluxQL query. Panic on error.
+func MustParseQuery(s string) *influxql.Query {
+	q, err := influxql.NewParser(strings.NewReader(s)).ParseQuery()
+	if err != nil {
+		panic(err.Error())
+	}
+	return q
+}
+
+// MustParseExpr parses an InfluxQL expression. Panic on error.
+func MustParseExpr(s string) influxql.Expr {
+	expr, err := influxql.NewParser(strings.NewReader(s)).ParseExpr()
+	if err != nil {
+		panic(err.Error())
+	}
+	return expr
+}
+
 // errstr is an ease-of-use function to convert an error to a string.
 func errstr(err error) string {
 	if err != nil {
@@ -0,0 +1,146 @@
+package influxdb
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultSessionIdleTimeout is the default length of time a session can go
+// unused before it expires.
+const DefaultSessionIdleTimeout = 30 * time.Minute
+
+// sessionCookieName is the name of the cookie that holds the session id.
+const sessionCookieName = "influxdb_session"
+
+// csrfHeader is the header that carries the session's CSRF token on requests
+// that change state.
+const csrfHeader = "X-CSRF-Token"
+
+// session represents a user logged in through the login endpoint.
+type session struct {
+	user     *User
+	hash     string // user's password hash at login
+	csrf     string // token required on requests that change state
+	lastSeen time.Time
+}
+
+// allows returns true if the request can be made with the session. Requests
+// that can change state must include the session's CSRF token since browsers
+// send the cookie with requests from other sites.
+func (s *session) allows(r *http.Request) bool {
+	switch r.Method {
+	case "GET", "HEAD":
+		return true
+	}
+	token := r.Header.Get(csrfHeader)
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.csrf)) == 1
+}
+
+// sessionStore holds the sessions of a handler by the hash of their id.
+// Sessions are kept in memory so they end when the server restarts.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// newSessionStore returns a new instance of sessionStore.
+func newSessionStore() *sessionStore {
+	return &sessionStore{sessions: make(map[string]*session)}
+}
+
+// create starts a session for a user. Returns the session id, which is only
+// stored as a hash, and the session. Expired sessions are removed.
+func (st *sessionStore) create(u *User, idle time.Duration, now time.Time) (string, *session, error) {
+	id, err := randomHex(32)
+	if err != nil {
+		return "", nil, err
+	}
+	csrf, err := randomHex(32)
+	if err != nil {
+		return "", nil, err
+	}
+	s := &session{user: u, hash: u.Hash, csrf: csrf, lastSeen: now}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for k, other := range st.sessions {
+		if now.Sub(other.lastSeen) > idle {
+			delete(st.sessions, k)
+		}
+	}
+	st.sessions[hashToken(id)] = s
+	return id, s, nil
+}
+
+// get returns the session for an id and marks it as used. Returns nil if the
+// session doesn't exist or has been idle for longer than idle.
+func (st *sessionStore) get(id string, idle time.Duration, now time.Time) *session {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	key := hashToken(id)
+	s := st.sessions[key]
+	if s == nil {
+		return nil
+	} else if now.Sub(s.lastSeen) > idle {
+		delete(st.sessions, key)
+		return nil
+	}
+	s.lastSeen = now
+	return s
+}
+
+// delete ends a session.
+func (st *sessionStore) delete(id string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.sessions, hashToken(id))
+}
+
+// sessionID returns the session id from the request's cookie, if any.
+func sessionID(r *http.Request) string {
+	if c, err := r.Cookie(sessionCookieName); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// sessionUser returns the session of a request and its user. Users from the
+// server's store are looked up again so that sessions end once the user is
+// dropped or their password changes. Returns ErrInvalidSession or
+// ErrInvalidCSRFToken if the request can't use the session.
+func (h *Handler) sessionUser(r *http.Request, id string) (*session, *User, error) {
+	s := h.sessions.get(id, h.SessionIdleTimeout, time.Now())
+	if s == nil {
+		return nil, nil, ErrInvalidSession
+	} else if !s.allows(r) {
+		return nil, nil, ErrInvalidCSRFToken
+	}
+
+	if _, ok := h.Authenticator.(*Server); !ok {
+		return s, s.user, nil
+	}
+	u := h.server.User(s.user.Name)
+	if u == nil || u.Hash != s.hash {
+		h.sessions.delete(id)
+		return nil, nil, ErrInvalidSession
+	}
+	return s, u, nil
+}
+
+// setSessionCookie sets the session cookie on a response. An empty id clears it.
+func (h *Handler) setSessionCookie(w http.ResponseWriter, r *http.Request, id string) {
+	c := &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   h.SecureCookies || r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	}
+	if id == "" {
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+}
@@ -1,11 +1,12 @@
 package influxdb
 
 import (
-	"encoding/json"
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
-	"unsafe"
 
 	"github.com/boltdb/bolt"
 )
@@ -19,7 +20,13 @@ type Shard struct {
 	replicaN    []uint64 // replication factor
 	dataNodeIDs []uint64 // owner nodes
 
-	store *bolt.DB
+	path  string      // path of the store, empty once the shard is closed
+	cache *shardCache // limits the stores open at once
+	store *shardStore // nil until the shard is first used
+
+	mu        sync.Mutex // protects the store from writes and readers while it is replaced
+	compacted bool       // true if not written to since the last compaction
+	writeN    uint64     // number of writes since the shard was opened
 }
 
 // newShard returns a new initialized Shard instance.
@@ -34,57 +41,426 @@ func (s *Shard) open(path string) error {
 	if s.store != nil {
 		return errors.New("shard already open")
 	}
+	s.path = path
+	return s.openStore()
+}
+
+// attach sets the path of the shard's store without opening it. The store is
+// opened when the shard is first read or written and may be closed again by
+// the cache while the shard is idle.
+func (s *Shard) attach(path string, c *shardCache) {
+	s.path, s.cache = path, c
+}
+
+// openStore opens the shard's store if it isn't open. Returns
+// ErrShardNotFound if the shard has been closed. Must be called with mu held.
+func (s *Shard) openStore() error {
+	if s.store != nil {
+		return nil
+	} else if s.path == "" {
+		return ErrShardNotFound
+	}
 
 	// Open store on shard.
-	store, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	store, err := bolt.Open(s.path, 0600, &bolt.Options{Timeout: 1 * time.Second})
 	if err != nil {
 		return err
 	}
-	s.store = store
+	s.store = &shardStore{DB: store}
 
 	// Initialize store.
 	if err := s.init(); err != nil {
-		_ = s.close()
+		_ = s.closeStore()
 		return fmt.Errorf("init: %s", err)
 	}
 
 	return nil
 }
 
-// init creates top-level buckets in the datastore.
+// init creates top-level buckets in the datastore and reads whether the
+// shard has been compacted.
 func (s *Shard) init() error {
 	return s.store.Update(func(tx *bolt.Tx) error {
+		// Write new shards in the current format and refuse to open shards
+		// in a format this node can't read.
+		if tx.Bucket([]byte("values")) == nil {
+			b, err := tx.CreateBucketIfNotExists([]byte("meta"))
+			if err != nil {
+				return err
+			} else if err := writeFormat(b, storeFormat{version: ShardFormat, minReader: shardMinReader}); err != nil {
+				return err
+			}
+		} else if b := tx.Bucket([]byte("meta")); b != nil {
+			if err := readFormat(b).check(ShardFormat); err != nil {
+				return err
+			}
+		}
+
 		_, _ = tx.CreateBucketIfNotExists([]byte("values"))
+		_, _ = tx.CreateBucketIfNotExists([]byte("seqs"))
+		_, _ = tx.CreateBucketIfNotExists([]byte("quarantine"))
+		if b, err := tx.CreateBucketIfNotExists([]byte("dictionary")); err == nil {
+			_, _ = b.CreateBucketIfNotExists([]byte("ids"))
+			_, _ = b.CreateBucketIfNotExists([]byte("strings"))
+		}
+		b, _ := tx.CreateBucketIfNotExists([]byte("meta"))
+		s.compacted = b != nil && b.Get([]byte("compacted")) != nil
 		return nil
 	})
 }
 
-// close shuts down the shard's store.
+// close shuts down the shard's store. A store that is held by readers is
+// closed once they have all released it. The shard isn't opened again.
 func (s *Shard) close() error {
-	return s.store.Close()
+	s.mu.Lock()
+	s.path = ""
+	err := s.closeStore()
+	s.mu.Unlock()
+
+	s.cache.remove(s)
+	return err
 }
 
-// writeSeries writes series data to a shard.
-func (s *Shard) writeSeries(overwrite bool, data []byte) error {
-	id, timestamp, values, err := unmarshalPoint(data)
-	if err != nil {
-		return err
+// evict closes the shard's store to free its file while the shard is idle.
+// The store is opened again when the shard is next used. Returns false if
+// the store is held by readers.
+func (s *Shard) evict() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.store != nil && s.store.refs > 0 {
+		return false
 	}
+	_ = s.closeStore()
+	return true
+}
 
-	// TODO: make this work
-	fmt.Println("writeSeries: ", id, timestamp, values)
-	return s.store.Update(func(tx *bolt.Tx) error {
-		return nil // TODO
+// closeStore detaches the store from the shard and closes it if it has no
+// readers. Must be called with mu held.
+func (s *Shard) closeStore() error {
+	st := s.store
+	if st == nil {
+		return nil
+	}
+	s.store = nil
+
+	if st.refs > 0 {
+		st.retired = true
+		return nil
+	}
+	return st.Close()
+}
+
+// acquire returns the shard's current store, opening it if needed, and holds
+// it open until it is released, even if the shard is compacted or dropped in
+// the meantime. Returns ErrShardNotFound if the shard is closed or isn't
+// stored on this node.
+func (s *Shard) acquire() (*shardStore, error) {
+	s.mu.Lock()
+	if err := s.openStore(); err != nil {
+		s.mu.Unlock()
+		if err != ErrShardNotFound {
+			err = fmt.Errorf("open shard %d: %s", s.ID, err)
+		}
+		return nil, err
+	}
+	st := s.store
+	st.refs++
+	s.mu.Unlock()
+
+	s.cache.touch(s)
+	return st, nil
+}
+
+// release releases a store returned by acquire. The store is closed if the
+// shard has since closed it and this was the last reader.
+func (s *Shard) release(st *shardStore) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st.refs--; st.refs == 0 && st.retired {
+		_ = st.Close()
+	}
+}
+
+// writeSeries writes encoded points to a shard in a single transaction.
+// The sequence number of each point is set to seq, the broker index of the
+// write, so that snapshot reads can skip points written after the snapshot.
+//
+// Points at the timestamp of an existing point replace it unless policies
+// sets a duplicate policy for the series. Returns the number of points
+// dropped by a "reject" policy.
+func (s *Shard) writeSeries(policies map[uint32]string, seq uint64, points [][]byte) (dropped int, err error) {
+	defer s.cache.touch(s)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.openStore(); err != nil {
+		return 0, err
+	}
+	s.writeN++
+	err = s.store.Update(func(tx *bolt.Tx) error {
+		// Clear the compaction marker so the shard is compacted again.
+		if s.compacted {
+			if err := tx.Bucket([]byte("meta")).Delete([]byte("compacted")); err != nil {
+				return err
+			}
+			s.compacted = false
+		}
+
+		dict := newStringDictionary(tx)
+		for _, data := range points {
+			id, timestamp, err := unmarshalPointHeader(data)
+			if err != nil {
+				return err
+			}
+
+			// Values are stored in a bucket per series, keyed by timestamp.
+			b, err := tx.Bucket([]byte("values")).CreateBucketIfNotExists(u32tob(id))
+			if err != nil {
+				return err
+			}
+			key := u64tob(uint64(timestamp))
+
+			// Values are stored as they were encoded, unless the series has a
+			// duplicate policy and a point exists at the timestamp, with
+			// strings moved to the shard's dictionary.
+			value := data[pointHeaderSize:]
+			if v := b.Get(key); v != nil && policies[id] != "" {
+				if policies[id] == DuplicateReject {
+					dropped++
+					continue
+				}
+				if value, err = mergeDuplicate(policies[id], v, value, dict.lookup); err != nil {
+					return err
+				}
+			}
+			if value, err = internStrings(value, dict.intern); err != nil {
+				return err
+			}
+			value = appendChecksum(nil, value)
+
+			if err := b.Put(key, value); err != nil {
+				return err
+			}
+
+			// Sequence numbers are stored in a parallel bucket per series.
+			seqs, err := tx.Bucket([]byte("seqs")).CreateBucketIfNotExists(u32tob(id))
+			if err != nil {
+				return err
+			} else if err := seqs.Put(key, u64tob(seq)); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
+	return
+}
+
+// deletePoints removes the points masked by a deletion from the given series,
+// or from every series if seriesIDs is nil. The shard is compacted again
+// afterward to reclaim the space. Returns the number of points removed.
+func (s *Shard) deletePoints(seriesIDs []uint32, mask deleteMask) (n int, err error) {
+	defer s.cache.touch(s)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.openStore(); err != nil {
+		return 0, err
+	}
+	s.writeN++
+	err = s.store.Update(func(tx *bolt.Tx) error {
+		if s.compacted {
+			if err := tx.Bucket([]byte("meta")).Delete([]byte("compacted")); err != nil {
+				return err
+			}
+			s.compacted = false
+		}
+
+		// Find the series buckets to delete from.
+		values, seqs := tx.Bucket([]byte("values")), tx.Bucket([]byte("seqs"))
+		if seriesIDs == nil {
+			c := values.Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				seriesIDs = append(seriesIDs, btou32(k))
+			}
+		}
+
+		for _, id := range seriesIDs {
+			b := values.Bucket(u32tob(id))
+			if b == nil {
+				continue
+			}
+			sb := seqs.Bucket(u32tob(id))
+
+			// Find the masked points first as points written after the
+			// deletion are kept and deleting moves the cursor.
+			var keys [][]byte
+			c := b.Cursor()
+			for k, _ := c.First(); k != nil && int64(btou64(k)) < mask.before; k, _ = c.Next() {
+				var seq []byte
+				if sb != nil {
+					seq = sb.Get(k)
+				}
+				if masked([]deleteMask{mask}, int64(btou64(k)), seq) {
+					keys = append(keys, append([]byte{}, k...))
+				}
+			}
+
+			for _, k := range keys {
+				if sb != nil {
+					if err := sb.Delete(k); err != nil {
+						return err
+					}
+				}
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+				n++
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// shardStore represents an open store for a shard. Readers hold a reference
+// to the store so that it is not closed while they are reading it.
+type shardStore struct {
+	*bolt.DB
+	refs    int  // number of readers, protected by the shard's mu
+	retired bool // true once the shard closes the store, protected by the shard's mu
+}
+
+// readSeries returns the points for a series within a time range, sorted by time.
+// Also returns the size of the encoded points read, in bytes.
+func (st *shardStore) readSeries(ctx context.Context, seriesID uint32, min, max int64, snapshot uint64, masks []deleteMask) (a []*seriesPoint, size int64, err error) {
+	_, size, err = st.scanSeries(ctx, seriesID, min, max, snapshot, masks, func(p *seriesPoint) error {
+		a = append(a, p)
+		return nil
+	})
+	return
+}
+
+// scanSeries calls fn with each point for a series within a time range, in
+// time order, and stops with the error fn returns, if any.
+// The min time is inclusive and the max time is exclusive. A zero max is unbounded.
+// If snapshot is non-zero then points with a higher sequence number are skipped.
+// Points masked by pending deletions are also skipped. Reading stops with the
+// context's error once it is done. Returns the number of points read and the
+// size of the encoded points read, in bytes.
+func (st *shardStore) scanSeries(ctx context.Context, seriesID uint32, min, max int64, snapshot uint64, masks []deleteMask, fn func(p *seriesPoint) error) (n int, size int64, err error) {
+	done := ctx.Done()
+	err = st.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("values")).Bucket(u32tob(seriesID))
+		if b == nil {
+			return nil
+		}
+
+		// Points written before sequence numbers were stored have none and
+		// are always read unless they're deleted.
+		var seqs *bolt.Bucket
+		if snapshot != 0 || len(masks) > 0 {
+			if sb := tx.Bucket([]byte("seqs")); sb != nil {
+				seqs = sb.Bucket(u32tob(seriesID))
+			}
+		}
+
+		dict := newStringDictionary(tx)
+		c := b.Cursor()
+		for k, v := c.Seek(u64tob(uint64(min))); k != nil; k, v = c.Next() {
+			timestamp := int64(btou64(k))
+			if max != 0 && timestamp >= max {
+				break
+			}
+
+			select {
+			case <-done:
+				return ctx.Err()
+			default:
+			}
+
+			var seq []byte
+			if seqs != nil {
+				seq = seqs.Get(k)
+			}
+			if snapshot != 0 && seq != nil && btou64(seq) > snapshot {
+				continue
+			} else if masked(masks, timestamp, seq) {
+				continue
+			}
+
+			values, err := unmarshalStoredValues(v, dict.lookup)
+			if err != nil {
+				return err
+			}
+			n, size = n+1, size+int64(len(k)+len(v))
+			if err := fn(&seriesPoint{timestamp: timestamp, values: values}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// seriesWithPoints returns the series that have points within a time range.
+// The min time is inclusive and the max time is exclusive. A zero max is unbounded.
+func (st *shardStore) seriesWithPoints(seriesIDs SeriesIDs, min, max int64) (a SeriesIDs, err error) {
+	err = st.View(func(tx *bolt.Tx) error {
+		values := tx.Bucket([]byte("values"))
+		for _, id := range seriesIDs {
+			b := values.Bucket(u32tob(id))
+			if b == nil {
+				continue
+			}
+			if k, _ := b.Cursor().Seek(u64tob(uint64(min))); k != nil && (max == 0 || int64(btou64(k)) < max) {
+				a = append(a, id)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// stats returns the number of series and the size of the store, in bytes.
+func (st *shardStore) stats() (seriesN int, size int64, err error) {
+	err = st.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("values")).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			seriesN++
+		}
+		size = tx.Size()
+		return nil
+	})
+	return
 }
 
 func (s *Shard) deleteSeries(name string) error {
 	panic("not yet implemented") // TODO
 }
 
+// Shard states.
+const (
+	ShardHot  = "hot"  // shard covers the current time and receives new writes
+	ShardCold = "cold" // shard only covers past times
+)
+
+// ShardInfo represents the metadata about a shard that is returned by the API.
+type ShardInfo struct {
+	ID              uint64    `json:"id"`
+	RetentionPolicy string    `json:"retentionPolicy"`
+	StartTime       time.Time `json:"startTime"`
+	EndTime         time.Time `json:"endTime"`
+	DataNodeIDs     []uint64  `json:"dataNodeIDs"`
+	SeriesN         int       `json:"seriesN"`
+	Size            int64     `json:"size"`
+	State           string    `json:"state"`
+}
+
 // Shards represents a list of shards.
 type Shards []*Shard
 
+func (p Shards) Len() int           { return len(p) }
+func (p Shards) Less(i, j int) bool { return p[i].ID < p[j].ID }
+func (p Shards) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
 // IDs returns a slice of all shard ids.
 func (p Shards) IDs() []uint64 {
 	ids := make([]uint64, len(p))
@@ -94,24 +470,18 @@ func (p Shards) IDs() []uint64 {
 	return ids
 }
 
-func marshalPoint(seriesID uint32, timestamp time.Time, values map[string]interface{}) ([]byte, error) {
-	b := make([]byte, 12)
-	*(*uint32)(unsafe.Pointer(&b[0])) = seriesID
-	*(*int64)(unsafe.Pointer(&b[4])) = timestamp.UnixNano()
-
-	d, err := json.Marshal(values)
-	if err != nil {
-		return nil, err
-	}
-	return append(b, d...), err
+// seriesPoint represents the values of a series at a point in time.
+type seriesPoint struct {
+	timestamp int64
+	values    map[string]interface{}
 }
 
-func unmarshalPoint(data []byte) (uint32, time.Time, map[string]interface{}, error) {
-	id := *(*uint32)(unsafe.Pointer(&data[0]))
-	ts := *(*int64)(unsafe.Pointer(&data[4]))
-	timestamp := time.Unix(0, ts)
-	var v map[string]interface{}
-
-	err := json.Unmarshal(data[12:], &v)
-	return id, timestamp, v, err
+// u32tob converts a uint32 into a 4-byte slice.
+func u32tob(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
 }
+
+// btou32 converts a 4-byte slice into a uint32.
+func btou32(b []byte) uint32 { return binary.BigEndian.Uint32(b) }
@@ -0,0 +1,96 @@
+package influxdb
+
+import (
+	"container/list"
+	"sync"
+)
+
+// shardCache limits the number of shard stores that are open at once. Stores
+// are opened when their shard is first read or written and the stores of the
+// least recently used shards are closed once more than max are open. Stores
+// held by readers are not closed.
+//
+// The cache's lock is taken before a shard's lock so shards must not be
+// touched while their own lock is held.
+type shardCache struct {
+	mu    sync.Mutex
+	max   int                      // stores open at once, zero is unlimited
+	lru   *list.List               // shards with open stores, most recently used first
+	elems map[*Shard]*list.Element // elements of lru by shard
+}
+
+// newShardCache returns a new cache that keeps up to max stores open.
+func newShardCache(max int) *shardCache {
+	return &shardCache{
+		max:   max,
+		lru:   list.New(),
+		elems: make(map[*Shard]*list.Element),
+	}
+}
+
+// setMax sets the number of stores open at once and closes stores over it.
+func (c *shardCache) setMax(max int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.max = max
+	c.evict()
+}
+
+// touch marks a shard as the most recently used and closes the stores of
+// the least recently used shards over the limit.
+func (c *shardCache) touch(sh *Shard) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e := c.elems[sh]; e != nil {
+		c.lru.MoveToFront(e)
+	} else {
+		c.elems[sh] = c.lru.PushFront(sh)
+	}
+	c.evict()
+}
+
+// remove stops tracking a shard once it has been closed.
+func (c *shardCache) remove(sh *Shard) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e := c.elems[sh]; e != nil {
+		c.lru.Remove(e)
+		delete(c.elems, sh)
+	}
+}
+
+// len returns the number of shards with open stores.
+func (c *shardCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// evict closes stores, oldest first, until at most max are open. The most
+// recently used shard is kept. Must be called with mu held.
+func (c *shardCache) evict() {
+	if c.max <= 0 {
+		return
+	}
+	for e := c.lru.Back(); e != nil && e != c.lru.Front() && c.lru.Len() > c.max; {
+		prev := e.Prev()
+		if sh := e.Value.(*Shard); sh.evict() {
+			c.lru.Remove(e)
+			delete(c.elems, sh)
+		}
+		e = prev
+	}
+}
+
+// SetMaxOpenShards sets the number of shard stores kept open at once. Shards
+// are opened when they're first read or written and the least recently used
+// ones are closed once more are open. Zero keeps every shard open once used.
+func (s *Server) SetMaxOpenShards(n int) {
+	s.shards.setMax(n)
+}
@@ -0,0 +1,92 @@
+package influxdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Ensure shards are opened when first used and the least recently used idle
+// shards are closed once more than the limit are open.
+func TestShardCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influxdb-shard-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := newShardCache(2)
+	var shards []*Shard
+	for i := 1; i <= 3; i++ {
+		sh := newShard()
+		sh.ID = uint64(i)
+		sh.attach(filepath.Join(dir, strconv.Itoa(i)), c)
+		defer sh.close()
+		shards = append(shards, sh)
+	}
+	if c.len() != 0 || shards[0].store != nil {
+		t.Fatal("shard opened before use")
+	}
+
+	// Hold the first shard and use the others.
+	held, err := shards[0].acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sh := range shards[1:] {
+		if st, err := sh.acquire(); err != nil {
+			t.Fatalf("shard %d not opened: %s", sh.ID, err)
+		} else {
+			sh.release(st)
+		}
+	}
+
+	// The held shard is kept open so the idle one used before last is closed.
+	if c.len() != 2 {
+		t.Fatalf("unexpected open shards: %d", c.len())
+	} else if shards[0].store == nil || shards[1].store != nil || shards[2].store == nil {
+		t.Fatal("unexpected shard closed")
+	}
+	shards[0].release(held)
+
+	// Shards are reopened when used again.
+	if _, err := shards[1].writeSeries(nil, 1, nil); err != nil {
+		t.Fatal(err)
+	} else if shards[1].store == nil || shards[0].store != nil {
+		t.Fatal("least recently used shard not closed")
+	}
+
+	// Closed shards are not reopened.
+	shards[1].close()
+	if _, err := shards[1].acquire(); err != ErrShardNotFound {
+		t.Fatalf("closed shard reopened: %v", err)
+	} else if c.len() != 1 {
+		t.Fatalf("unexpected open shards: %d", c.len())
+	}
+}
+
+// Ensure a shard that can't be opened returns an error when acquired.
+func TestShard_Acquire_OpenError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influxdb-shard-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Write a file that isn't a valid store.
+	path := filepath.Join(dir, "1")
+	if err := ioutil.WriteFile(path, make([]byte, 4096), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sh := newShard()
+	sh.ID = 1
+	sh.attach(path, newShardCache(0))
+	defer sh.close()
+	if _, err := sh.acquire(); err == nil || err == ErrShardNotFound || !strings.HasPrefix(err.Error(), "open shard 1: ") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package influxdb
+
+import (
+	"time"
+)
+
+// DefaultShutdownTimeout is the default length of time Close waits for
+// running queries and writes to finish.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// SetShutdownTimeout sets how long Close waits for running queries and writes
+// to finish and for published writes to be applied before closing shards.
+func (s *Server) SetShutdownTimeout(d time.Duration) {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+	s.shutdownTimeout = d
+}
+
+// begin registers a query or write as running. Returns ErrServerShuttingDown
+// once the server has started to close.
+func (s *Server) begin() error {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+	if s.drained != nil {
+		return ErrServerShuttingDown
+	}
+	s.inflight++
+	return nil
+}
+
+// end marks a query or write registered with begin as finished.
+func (s *Server) end() {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+	if s.inflight--; s.inflight == 0 && s.drained != nil {
+		close(s.drained)
+	}
+}
+
+// shuttingDown returns true once the server has started to close.
+func (s *Server) shuttingDown() bool {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+	return s.drained != nil
+}
+
+// drain rejects new queries and writes and waits for running ones to finish
+// and for published writes to be applied. Gives up after the shutdown timeout.
+func (s *Server) drain() {
+	s.drainMu.Lock()
+	if s.drained != nil {
+		s.drainMu.Unlock()
+		return
+	}
+	s.drained = make(chan struct{})
+	if s.inflight == 0 {
+		close(s.drained)
+	}
+	drained, timeout := s.drained, time.After(s.shutdownTimeout)
+	s.drainMu.Unlock()
+
+	// Wait for running queries and writes.
+	select {
+	case <-drained:
+	case <-timeout:
+		s.Logger.Printf("shutdown: timed out waiting for running queries and writes")
+		return
+	}
+
+	// Wait for the writes published by this server to be applied.
+	s.writeMu.Lock()
+	index := s.publishedIndex
+	s.writeMu.Unlock()
+	for {
+		s.mu.RLock()
+		applied := s.index >= index || s.client == nil
+		s.mu.RUnlock()
+		if applied {
+			return
+		}
+
+		select {
+		case <-timeout:
+			s.Logger.Printf("shutdown: timed out waiting for writes to be applied")
+			return
+		case <-time.After(1 * time.Millisecond):
+		}
+	}
+}
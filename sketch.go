@@ -0,0 +1,83 @@
+package influxdb
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// sketchPrecision is the number of hash bits used to select a register.
+// Sketches use 2^sketchPrecision bytes and have a standard error of about
+// 1.04/sqrt(2^sketchPrecision), which is 3% at a precision of 10.
+const sketchPrecision = 10
+
+// sketch is a HyperLogLog sketch that estimates the number of distinct
+// values added to it in a fixed amount of memory.
+type sketch struct {
+	registers [1 << sketchPrecision]uint8
+}
+
+// newSketch returns a new, empty sketch.
+func newSketch() *sketch { return &sketch{} }
+
+// add adds a value to the sketch.
+func (s *sketch) add(v string) {
+	h := hashString(v)
+
+	// The top bits select the register. The register keeps the highest
+	// position of the first set bit seen in the remaining bits.
+	i := h >> (64 - sketchPrecision)
+	w := h<<sketchPrecision | 1<<(sketchPrecision-1)
+	rank := uint8(1)
+	for w&(1<<63) == 0 {
+		rank++
+		w <<= 1
+	}
+	if rank > s.registers[i] {
+		s.registers[i] = rank
+	}
+}
+
+// merge adds the values of another sketch to the sketch.
+func (s *sketch) merge(other *sketch) {
+	for i, r := range other.registers {
+		if r > s.registers[i] {
+			s.registers[i] = r
+		}
+	}
+}
+
+// count returns the estimated number of distinct values in the sketch.
+func (s *sketch) count() uint64 {
+	m := float64(len(s.registers))
+
+	var sum float64
+	var zeros int
+	for _, r := range s.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	// Use linear counting for small cardinalities, where it's more accurate.
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return uint64(e + 0.5)
+}
+
+// hashString returns a 64-bit hash of a string. FNV-1a is mixed with a
+// finalizer so that every bit of the hash depends on every input bit.
+func hashString(v string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(v))
+	x := h.Sum64()
+
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
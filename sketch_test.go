@@ -0,0 +1,43 @@
+package influxdb
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Ensure a sketch counts small sets of distinct values exactly.
+func TestSketch_Count_Small(t *testing.T) {
+	s := newSketch()
+	for i := 0; i < 3; i++ {
+		s.add("serverA")
+		s.add("serverB")
+		s.add("serverC")
+	}
+	if n := s.count(); n != 3 {
+		t.Fatalf("unexpected count: %d", n)
+	}
+}
+
+// Ensure a sketch estimates large sets of distinct values within its error.
+func TestSketch_Count_Large(t *testing.T) {
+	s := newSketch()
+	for i := 0; i < 100000; i++ {
+		s.add("host" + strconv.Itoa(i))
+	}
+	if n := s.count(); n < 90000 || n > 110000 {
+		t.Fatalf("unexpected count: %d", n)
+	}
+}
+
+// Ensure merged sketches estimate the union of their values.
+func TestSketch_Merge(t *testing.T) {
+	a, b := newSketch(), newSketch()
+	for i := 0; i < 100; i++ {
+		a.add(strconv.Itoa(i))
+		b.add(strconv.Itoa(i + 50))
+	}
+	a.merge(b)
+	if n := a.count(); n < 145 || n > 155 {
+		t.Fatalf("unexpected count: %d", n)
+	}
+}
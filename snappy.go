@@ -0,0 +1,177 @@
+package influxdb
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// maxSnappyDecodedSize is the largest block that will be decompressed.
+const maxSnappyDecodedSize = 32 << 20
+
+var (
+	// errSnappyCorrupt is returned when a snappy block is invalid.
+	errSnappyCorrupt = errors.New("snappy: corrupt input")
+
+	// errSnappyTooLarge is returned when a snappy block decodes to more
+	// than maxSnappyDecodedSize bytes.
+	errSnappyTooLarge = errors.New("snappy: decoded block is too large")
+)
+
+// Snappy element tags, stored in the low two bits of each element's first byte.
+const (
+	snappyLiteral = 0
+	snappyCopy1   = 1 // copy with a 1-byte offset
+	snappyCopy2   = 2 // copy with a 2-byte offset
+	snappyCopy4   = 3 // copy with a 4-byte offset
+)
+
+// snappyDecode decodes a block in the snappy block format, as used by the
+// Prometheus remote storage protocol. The block is the uvarint length of
+// the decoded data followed by elements that are either literal bytes or
+// copies of earlier decoded bytes.
+func snappyDecode(src []byte) ([]byte, error) {
+	n, i := binary.Uvarint(src)
+	if i <= 0 {
+		return nil, errSnappyCorrupt
+	} else if n > maxSnappyDecodedSize {
+		return nil, errSnappyTooLarge
+	}
+	src = src[i:]
+
+	dst := make([]byte, 0, n)
+	for len(src) > 0 {
+		var length, offset int
+		switch src[0] & 0x3 {
+		case snappyLiteral:
+			// Lengths under 60 are stored in the tag. Otherwise the tag holds
+			// the number of following bytes storing the length.
+			length = int(src[0] >> 2)
+			src = src[1:]
+			if length >= 60 {
+				size := length - 59
+				if len(src) < size {
+					return nil, errSnappyCorrupt
+				}
+				length = 0
+				for j := size - 1; j >= 0; j-- {
+					length = length<<8 | int(src[j])
+				}
+				src = src[size:]
+			}
+			length++
+			if length <= 0 || length > len(src) || len(dst)+length > int(n) {
+				return nil, errSnappyCorrupt
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+
+		case snappyCopy1:
+			if len(src) < 2 {
+				return nil, errSnappyCorrupt
+			}
+			length = 4 + int(src[0]>>2)&0x7
+			offset = int(src[0]&0xe0)<<3 | int(src[1])
+			src = src[2:]
+
+		case snappyCopy2:
+			if len(src) < 3 {
+				return nil, errSnappyCorrupt
+			}
+			length = 1 + int(src[0]>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+
+		case snappyCopy4:
+			if len(src) < 5 {
+				return nil, errSnappyCorrupt
+			}
+			length = 1 + int(src[0]>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+
+		// Copies may overlap the bytes they produce so copy byte by byte.
+		if offset <= 0 || offset > len(dst) || len(dst)+length > int(n) {
+			return nil, errSnappyCorrupt
+		}
+		for j := len(dst) - offset; length > 0; j, length = j+1, length-1 {
+			dst = append(dst, dst[j])
+		}
+	}
+
+	if len(dst) != int(n) {
+		return nil, errSnappyCorrupt
+	}
+	return dst, nil
+}
+
+// snappyEncode encodes data in the snappy block format. Repeated sequences
+// of four or more bytes within the last 64KB are replaced by copies.
+func snappyEncode(src []byte) []byte {
+	dst := make([]byte, binary.MaxVarintLen64, len(src)/2+16)
+	dst = dst[:binary.PutUvarint(dst, uint64(len(src)))]
+
+	// The table holds the last position, plus one, of each hashed sequence.
+	const tableBits = 14
+	var table [1 << tableBits]int
+
+	lit := 0 // start of the bytes not yet written
+	for i := 0; i+4 <= len(src); {
+		v := binary.LittleEndian.Uint32(src[i:])
+		h := (v * 0x1e35a7bd) >> (32 - tableBits)
+		candidate := table[h] - 1
+		table[h] = i + 1
+
+		if candidate < 0 || i-candidate > 0xffff || binary.LittleEndian.Uint32(src[candidate:]) != v {
+			i++
+			continue
+		}
+
+		// Extend the match and write the pending literal and the copy.
+		n := 4
+		for i+n < len(src) && src[candidate+n] == src[i+n] {
+			n++
+		}
+		dst = appendSnappyLiteral(dst, src[lit:i])
+		dst = appendSnappyCopy(dst, i-candidate, n)
+		i += n
+		lit = i
+	}
+	return appendSnappyLiteral(dst, src[lit:])
+}
+
+// appendSnappyLiteral appends a literal element to dst.
+func appendSnappyLiteral(dst, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+
+	switch n := len(lit) - 1; {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|snappyLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|snappyLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|snappyLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|snappyLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|snappyLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, lit...)
+}
+
+// appendSnappyCopy appends copy elements to dst for a match of length n at
+// an offset of less than 64KB. Each element copies at most 64 bytes.
+func appendSnappyCopy(dst []byte, offset, n int) []byte {
+	for n > 0 {
+		length := n
+		if length > 64 {
+			length = 64
+		}
+		dst = append(dst, byte(length-1)<<2|snappyCopy2, byte(offset), byte(offset>>8))
+		n -= length
+	}
+	return dst
+}
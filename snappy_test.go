@@ -0,0 +1,82 @@
+package influxdb
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// Ensure blocks encoded by the reference implementation can be decoded.
+func TestSnappyDecode(t *testing.T) {
+	for i, tt := range []struct {
+		data []byte
+		exp  string
+	}{
+		{data: []byte{0x0}, exp: ""},
+		{data: []byte{0x1, 0x0, 0x61}, exp: "a"},
+		{data: []byte{0xb, 0x28, 0x68, 0x65, 0x6c, 0x6c, 0x6f, 0x20, 0x77, 0x6f, 0x72, 0x6c, 0x64}, exp: "hello world"},
+		{data: []byte{0x1d, 0x14, 0x68, 0x65, 0x6c, 0x6c, 0x6f, 0x20, 0x46, 0x6, 0x0, 0x10, 0x77, 0x6f, 0x72, 0x6c, 0x64}, exp: "hello hello hello hello world"},
+		{data: []byte{0x50, 0xc, 0x61, 0x62, 0x63, 0x64, 0xfe, 0x4, 0x0, 0x2e, 0x4, 0x0}, exp: strings.Repeat("abcd", 20)},
+
+		// Copies with 1 and 4-byte offsets.
+		{data: []byte{0x8, 0xc, 0x61, 0x62, 0x63, 0x64, 0x1, 0x4}, exp: "abcdabcd"},
+		{data: []byte{0x8, 0xc, 0x61, 0x62, 0x63, 0x64, 0xf, 0x4, 0x0, 0x0, 0x0}, exp: "abcdabcd"},
+
+		// Literal with its length in the following byte.
+		{data: append([]byte{0x64, 0xf0, 0x63}, strings.Repeat("x", 100)...), exp: strings.Repeat("x", 100)},
+	} {
+		if b, err := snappyDecode(tt.data); err != nil {
+			t.Errorf("%d. unexpected error: %s", i, err)
+		} else if string(b) != tt.exp {
+			t.Errorf("%d. unexpected data: %q", i, b)
+		}
+	}
+}
+
+// Ensure invalid blocks return an error.
+func TestSnappyDecode_Corrupt(t *testing.T) {
+	for i, tt := range []struct {
+		data []byte
+		err  error
+	}{
+		{data: nil, err: errSnappyCorrupt},
+		{data: []byte{0x2, 0x0, 0x61}, err: errSnappyCorrupt},
+		{data: []byte{0x1, 0x4, 0x61, 0x62}, err: errSnappyCorrupt},
+		{data: []byte{0x4, 0x1, 0x4}, err: errSnappyCorrupt},
+		{data: []byte{0x8, 0xc, 0x61, 0x62, 0x63, 0x64, 0x1, 0x5}, err: errSnappyCorrupt},
+		{data: []byte{0x8, 0xc, 0x61, 0x62, 0x63, 0x64, 0x2, 0x4}, err: errSnappyCorrupt},
+		{data: []byte{0xff, 0xff, 0xff, 0xff, 0xf}, err: errSnappyTooLarge},
+	} {
+		if _, err := snappyDecode(tt.data); err != tt.err {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		}
+	}
+}
+
+// Ensure encoded blocks can be decoded and repeated data is compressed.
+func TestSnappyEncode(t *testing.T) {
+	rand := rand.New(rand.NewSource(0))
+	for i, data := range [][]byte{
+		nil,
+		[]byte("a"),
+		[]byte(strings.Repeat("abcd", 1000)),
+		[]byte(strings.Repeat("x", 100000)),
+		func() []byte {
+			b := make([]byte, 100000)
+			rand.Read(b)
+			return b
+		}(),
+	} {
+		enc := snappyEncode(data)
+		if b, err := snappyDecode(enc); err != nil {
+			t.Errorf("%d. unexpected error: %s", i, err)
+		} else if !bytes.Equal(b, data) {
+			t.Errorf("%d. data mismatch", i)
+		}
+	}
+
+	if n := len(snappyEncode([]byte(strings.Repeat("abcd", 1000)))); n > 300 {
+		t.Fatalf("unexpected encoded size: %d", n)
+	}
+}
@@ -0,0 +1,241 @@
+package influxdb
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// pointReader reads points in timestamp order.
+type pointReader interface {
+	// peek returns the next point without consuming it.
+	// Returns nil once all points have been read.
+	peek() *seriesPoint
+
+	// next consumes the point returned by peek.
+	next()
+
+	// err returns the error that stopped the reader before all of its
+	// points were read, if any.
+	err() error
+}
+
+// sliceReader reads points from an in-memory list.
+type sliceReader struct {
+	points []*seriesPoint
+}
+
+func (r *sliceReader) peek() *seriesPoint {
+	if len(r.points) == 0 {
+		return nil
+	}
+	return r.points[0]
+}
+
+func (r *sliceReader) next() { r.points = r.points[1:] }
+
+func (r *sliceReader) err() error { return nil }
+
+// spillFile represents a sorted run of points written to disk because the
+// statement reading them would have exceeded its memory limit.
+//
+// Each point is encoded as an 8-byte timestamp, a 4-byte length and the
+// values encoded as they are in shards.
+type spillFile struct {
+	f   *os.File
+	w   *bufio.Writer // set while points are being written
+	r   *bufio.Reader
+	p   *seriesPoint // next point, nil if not yet read
+	eof bool
+	e   error // error reading the file, if any
+}
+
+// writeSpillFile writes points to a new temporary file in dir and returns
+// the file positioned at the first point.
+func writeSpillFile(dir string, points []*seriesPoint) (*spillFile, error) {
+	s, err := createSpillFile(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range points {
+		if err := s.write(p); err != nil {
+			s.close()
+			return nil, err
+		}
+	}
+	if err := s.rewind(); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// createSpillFile creates a new temporary file in dir that points can be
+// written to. The file must be rewound before its points are read.
+func createSpillFile(dir string) (*spillFile, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile(dir, "spill")
+	if err != nil {
+		return nil, err
+	}
+	return &spillFile{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// write appends a point to the file. Points must be written in time order.
+func (s *spillFile) write(p *seriesPoint) error {
+	b, err := appendValues(nil, p.values)
+	if err != nil {
+		return err
+	}
+	var hdr [12]byte
+	binary.BigEndian.PutUint64(hdr[0:8], uint64(p.timestamp))
+	binary.BigEndian.PutUint32(hdr[8:12], uint32(len(b)))
+	if _, err := s.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err = s.w.Write(b)
+	return err
+}
+
+// rewind flushes the points written to the file and positions it at the first point.
+func (s *spillFile) rewind() error {
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	if _, err := s.f.Seek(0, os.SEEK_SET); err != nil {
+		return err
+	}
+	s.w, s.r = nil, bufio.NewReader(s.f)
+	return nil
+}
+
+// peek returns the next point in the file. The file is removed once all of
+// its points have been read or it can't be read, and the error is returned
+// by err.
+func (s *spillFile) peek() *seriesPoint {
+	if s.p == nil && !s.eof {
+		p, err := s.read()
+		if err != nil {
+			s.e = fmt.Errorf("read spill: %s", err)
+			s.close()
+		}
+		s.p, s.eof = p, p == nil
+	}
+	return s.p
+}
+
+func (s *spillFile) next() { s.p = nil }
+
+func (s *spillFile) err() error { return s.e }
+
+// read decodes the next point from the file.
+// Returns nil and removes the file once all points have been read.
+func (s *spillFile) read() (*seriesPoint, error) {
+	var hdr [12]byte
+	if _, err := io.ReadFull(s.r, hdr[:]); err == io.EOF {
+		s.close()
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, binary.BigEndian.Uint32(hdr[8:12]))
+	if _, err := io.ReadFull(s.r, b); err != nil {
+		return nil, err
+	}
+
+	p := &seriesPoint{timestamp: int64(binary.BigEndian.Uint64(hdr[0:8]))}
+	values, err := unmarshalValues(b)
+	if err != nil {
+		return nil, err
+	}
+	p.values = values
+	return p, nil
+}
+
+// close closes and removes the file. It is safe to call more than once.
+func (s *spillFile) close() { closeSpillFile(s.f) }
+
+// closeSpillFile closes and removes a spill file, ignoring errors.
+func closeSpillFile(f *os.File) {
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+}
+
+// mergeReader merges readers that are each sorted by timestamp. Points with
+// the same timestamp are ordered by the index of their reader.
+type mergeReader struct {
+	readers []pointReader
+	h       pointReaderHeap
+}
+
+// newMergeReader returns a reader that merges a list of readers.
+func newMergeReader(readers []pointReader) *mergeReader {
+	r := &mergeReader{readers: readers, h: make(pointReaderHeap, 0, len(readers))}
+	for i, rd := range readers {
+		if rd.peek() != nil {
+			r.h = append(r.h, &pointReaderItem{reader: rd, index: i})
+		}
+	}
+	heap.Init(&r.h)
+	return r
+}
+
+func (r *mergeReader) peek() *seriesPoint {
+	if len(r.h) == 0 {
+		return nil
+	}
+	return r.h[0].reader.peek()
+}
+
+func (r *mergeReader) next() {
+	item := r.h[0]
+	if item.reader.next(); item.reader.peek() != nil {
+		heap.Fix(&r.h, 0)
+	} else {
+		heap.Pop(&r.h)
+	}
+}
+
+// err returns the first error from the merged readers.
+func (r *mergeReader) err() error {
+	for _, rd := range r.readers {
+		if err := rd.err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pointReaderItem represents a reader in a pointReaderHeap.
+type pointReaderItem struct {
+	reader pointReader
+	index  int // position of the reader, used to break ties
+}
+
+// pointReaderHeap is a min-heap of readers ordered by their next point.
+type pointReaderHeap []*pointReaderItem
+
+func (h pointReaderHeap) Len() int { return len(h) }
+func (h pointReaderHeap) Less(i, j int) bool {
+	if ti, tj := h[i].reader.peek().timestamp, h[j].reader.peek().timestamp; ti != tj {
+		return ti < tj
+	}
+	return h[i].index < h[j].index
+}
+func (h pointReaderHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *pointReaderHeap) Push(x interface{}) { *h = append(*h, x.(*pointReaderItem)) }
+
+func (h *pointReaderHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,153 @@
+package influxdb
+
+import (
+	"sync"
+	"time"
+)
+
+// Database statistic names.
+const (
+	StatPointsWritten    = "pointsWritten"    // number of points written
+	StatBytesIn          = "bytesIn"          // number of encoded point bytes written
+	StatWriteErrors      = "writeErrors"      // number of failed writes
+	StatQueriesExecuted  = "queriesExecuted"  // number of statements executed
+	StatQueryErrors      = "queryErrors"      // number of statements that returned an error
+	StatQueryCacheHits   = "queryCacheHits"   // number of queries served from the parsed query cache
+	StatQueryCacheMisses = "queryCacheMisses" // number of queries that had to be parsed
+
+	StatResultCacheHits   = "resultCacheHits"   // number of statements served from the result cache
+	StatResultCacheMisses = "resultCacheMisses" // number of cacheable statements that had to be executed
+
+	StatDuplicatesDropped   = "duplicatesDropped"   // number of points dropped by a "reject" duplicate policy
+	StatTimestampsCorrected = "timestampsCorrected" // number of points whose timestamps were outside an input's skew bounds
+)
+
+// databaseStatNames is the ordered list of statistics tracked per database.
+var databaseStatNames = []string{
+	StatPointsWritten,
+	StatBytesIn,
+	StatWriteErrors,
+	StatQueriesExecuted,
+	StatQueryErrors,
+	StatQueryCacheHits,
+	StatQueryCacheMisses,
+	StatResultCacheHits,
+	StatResultCacheMisses,
+	StatDuplicatesDropped,
+	StatTimestampsCorrected,
+}
+
+// Stats represents a set of named counters.
+type Stats struct {
+	mu     sync.RWMutex
+	values map[string]int64
+}
+
+// NewStats returns a new instance of Stats.
+func NewStats() *Stats {
+	return &Stats{values: make(map[string]int64)}
+}
+
+// Add increments a counter by delta.
+func (s *Stats) Add(key string, delta int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] += delta
+}
+
+// Get returns the current value of a counter.
+func (s *Stats) Get(key string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values[key]
+}
+
+// databaseStats returns the statistics for a database.
+// The statistics are created if they do not exist yet.
+func (s *Server) databaseStats(name string) *Stats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	st := s.stats[name]
+	if st == nil {
+		st = NewStats()
+		s.stats[name] = st
+	}
+	return st
+}
+
+// DatabaseStats returns the statistics for a database.
+// Returns nil if the database does not exist.
+func (s *Server) DatabaseStats(name string) *Stats {
+	if !s.DatabaseExists(name) {
+		return nil
+	}
+	return s.databaseStats(name)
+}
+
+// StartSelfMonitoring periodically writes the statistics for every database
+// into a measurement named "database" on the given database and retention
+// policy. Each point is tagged with the name of the database it describes.
+// Audit log entries are written into a measurement named "audit".
+// The database and retention policy are created if they do not exist.
+// Monitoring stops when the server is closed.
+func (s *Server) StartSelfMonitoring(database, retention string, interval time.Duration) error {
+	if interval <= 0 {
+		return ErrInvalidMonitorInterval
+	}
+
+	// Create the database and retention policy, if necessary.
+	if err := s.CreateDatabase(database); err != nil && err != ErrDatabaseExists {
+		return err
+	}
+	rp := NewRetentionPolicy(retention)
+	if err := s.CreateRetentionPolicy(database, rp); err != nil && err != ErrRetentionPolicyExists {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing == nil {
+		return ErrServerClosed
+	}
+
+	s.auditMu.Lock()
+	s.auditExport = true
+	s.auditMu.Unlock()
+
+	s.wg.Add(1)
+	go s.monitor(database, retention, interval, s.closing)
+	return nil
+}
+
+// monitor writes statistics every interval until closing is closed.
+func (s *Server) monitor(database, retention string, interval time.Duration, closing <-chan struct{}) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closing:
+			return
+		case t := <-ticker.C:
+			for _, name := range s.Databases() {
+				st := s.DatabaseStats(name)
+				if st == nil {
+					continue
+				}
+
+				values := make(map[string]interface{})
+				for _, k := range databaseStatNames {
+					values[k] = float64(st.Get(k))
+				}
+
+				tags := map[string]string{"database": name}
+				if err := s.WriteSeries(database, retention, "database", tags, t.UTC(), values); err != nil {
+					s.Logger.Printf("monitor: %s", err)
+				}
+			}
+			s.exportAudit(database, retention)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPort represents the default StatsD port.
+	DefaultPort = 8125
+
+	// DefaultFlushInterval represents the default time between writes of
+	// aggregated metrics.
+	DefaultFlushInterval = 10 * time.Second
+
+	// udpBufferSize is the largest UDP packet that can be received.
+	udpBufferSize = 65536
+)
+
+// DefaultPercentiles are the percentiles of timer values written by default.
+var DefaultPercentiles = []float64{90}
+
+// Metric types.
+const (
+	Counter   = "c"
+	Gauge     = "g"
+	Timer     = "ms"
+	Histogram = "h" // aggregated as a timer
+)
+
+var (
+	// ErrBindAddressRequired is returned when starting the Server
+	// without a UDP listening address.
+	ErrBindAddressRequired = errors.New("bind address required")
+
+	// ErrServerClosed return when closing an already closed statsd server.
+	ErrServerClosed = errors.New("server already closed")
+
+	// ErrDatabaseNotSpecified retuned when no database was specified in the config file
+	ErrDatabaseNotSpecified = errors.New("database was not specified in config")
+
+	// ErrInvalidPercentile is returned when a percentile is not between 0 and 100.
+	ErrInvalidPercentile = errors.New("percentile must be greater than 0 and at most 100")
+)
+
+// SeriesWriter defines the interface for the destination of the data.
+type SeriesWriter interface {
+	WriteSeries(database, retentionPolicy, name string, tags map[string]string, timestamp time.Time, values map[string]interface{}) error
+}
+
+// Metric represents a single StatsD metric.
+type Metric struct {
+	Name string
+	Tags map[string]string
+	Type string
+
+	// The value of the metric. Gauge values are added to the current value
+	// if Relative is set.
+	Value    float64
+	Relative bool
+
+	// The fraction of events sent by the client. Counts are scaled up by
+	// the inverse of the rate.
+	SampleRate float64
+}
+
+// Parse parses a single StatsD line:
+//
+//	name:value|type[|@sample_rate][|#tag:value,...]
+//
+// Gauge values prefixed with a sign are relative to the current value.
+func Parse(line string) (*Metric, error) {
+	i := strings.IndexByte(line, ':')
+	if i <= 0 {
+		return nil, fmt.Errorf("received %q which doesn't have a name", line)
+	}
+	parts := strings.Split(line[i+1:], "|")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("received %q which doesn't have a type", line)
+	}
+
+	m := &Metric{Name: line[:i], Type: parts[1], SampleRate: 1}
+	switch m.Type {
+	case Counter, Gauge, Timer, Histogram:
+	default:
+		return nil, fmt.Errorf("received %q which has an unsupported type: %s", line, m.Type)
+	}
+
+	// Parse the value.
+	v, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, fmt.Errorf("received %q which has an invalid value", line)
+	}
+	m.Value = v
+	m.Relative = m.Type == Gauge && (parts[0][0] == '+' || parts[0][0] == '-')
+
+	// Parse the optional sample rate and tags.
+	for _, p := range parts[2:] {
+		switch {
+		case strings.HasPrefix(p, "@"):
+			rate, err := strconv.ParseFloat(p[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return nil, fmt.Errorf("received %q which has an invalid sample rate", line)
+			}
+			m.SampleRate = rate
+		case strings.HasPrefix(p, "#"):
+			m.Tags = make(map[string]string)
+			for _, tag := range strings.Split(p[1:], ",") {
+				kv := strings.SplitN(tag, ":", 2)
+				if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+					return nil, fmt.Errorf("received %q which has an invalid tag: %q", line, tag)
+				}
+				m.Tags[kv[0]] = kv[1]
+			}
+		default:
+			return nil, fmt.Errorf("received %q which has an unknown section: %q", line, p)
+		}
+	}
+
+	return m, nil
+}
+
+// key returns a string identifying the metric's name and tags.
+func (m *Metric) key() string {
+	keys := make([]string, 0, len(m.Tags))
+	for k := range m.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	s := m.Name
+	for _, k := range keys {
+		s += "," + k + "=" + m.Tags[k]
+	}
+	return s
+}
+
+// Server aggregates StatsD metrics received over UDP and writes them every
+// flush interval. Counters are written as the sum of their increments and
+// gauges as their last value, both in a "value" field. Timers are written
+// with the count, sum, mean, lower, upper and standard deviation of their
+// values and a field for each percentile, such as "p90".
+//
+// Counters and timers are reset after each flush. Gauges keep their value
+// so relative updates apply to it but are only written if they were updated.
+type Server struct {
+	writer SeriesWriter
+
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	conn     *net.UDPConn
+	closing  chan struct{}
+	counters map[string]*counter
+	gauges   map[string]*gauge
+	timers   map[string]*timer
+
+	// The database and retention policy to write into.
+	// Uses the database's default retention policy if blank.
+	Database        string
+	RetentionPolicy string
+
+	// The time between writes of aggregated metrics.
+	FlushInterval time.Duration
+
+	// The percentiles of timer values to write.
+	Percentiles []float64
+
+	Logger *log.Logger
+}
+
+type counter struct {
+	name  string
+	tags  map[string]string
+	value float64
+}
+
+type gauge struct {
+	name    string
+	tags    map[string]string
+	value   float64
+	updated bool
+}
+
+type timer struct {
+	name   string
+	tags   map[string]string
+	count  float64
+	values []float64
+}
+
+// NewServer returns a new instance of Server.
+func NewServer(w SeriesWriter) *Server {
+	return &Server{
+		writer:        w,
+		counters:      make(map[string]*counter),
+		gauges:        make(map[string]*gauge),
+		timers:        make(map[string]*timer),
+		FlushInterval: DefaultFlushInterval,
+		Percentiles:   DefaultPercentiles,
+		Logger:        log.New(os.Stderr, "[statsd] ", log.LstdFlags),
+	}
+}
+
+// ListenAndServe instructs the Server to start processing StatsD data
+// on the given interface. iface must be in the form host:port.
+func (s *Server) ListenAndServe(iface string) error {
+	if iface == "" {
+		return ErrBindAddressRequired
+	} else if s.Database == "" {
+		return ErrDatabaseNotSpecified
+	}
+	for _, p := range s.Percentiles {
+		if p <= 0 || p > 100 {
+			return ErrInvalidPercentile
+		}
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", iface)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.conn = conn
+	s.closing = make(chan struct{})
+	s.mu.Unlock()
+
+	s.wg.Add(2)
+	go s.serve(conn)
+	go s.run(s.FlushInterval, s.closing)
+	return nil
+}
+
+// Addr returns the address the server is listening on.
+// Returns nil if the server is not listening.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.LocalAddr()
+}
+
+// Close stops the server from listening and writes the remaining metrics.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	conn := s.conn
+	if conn != nil {
+		close(s.closing)
+	}
+	s.conn = nil
+	s.mu.Unlock()
+
+	if conn == nil {
+		return ErrServerClosed
+	}
+	err := conn.Close()
+	s.wg.Wait()
+
+	s.Flush()
+	return err
+}
+
+// serve reads packets off the connection and aggregates their metrics.
+func (s *Server) serve(conn *net.UDPConn) {
+	defer s.wg.Done()
+
+	buf := make([]byte, udpBufferSize)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+			if err := s.Handle(line); err != nil {
+				s.Logger.Printf("%s", err)
+			}
+		}
+	}
+}
+
+// run flushes the aggregated metrics every interval until closing is closed.
+func (s *Server) run(interval time.Duration, closing <-chan struct{}) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closing:
+			return
+		case <-ticker.C:
+			s.Flush()
+		}
+	}
+}
+
+// Handle parses a line and adds its metric to the aggregates.
+func (s *Server) Handle(line string) error {
+	m, err := Parse(line)
+	if err != nil {
+		return err
+	}
+	key := m.key()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch m.Type {
+	case Counter:
+		c := s.counters[key]
+		if c == nil {
+			c = &counter{name: m.Name, tags: m.Tags}
+			s.counters[key] = c
+		}
+		c.value += m.Value / m.SampleRate
+
+	case Gauge:
+		g := s.gauges[key]
+		if g == nil {
+			g = &gauge{name: m.Name, tags: m.Tags}
+			s.gauges[key] = g
+		}
+		if m.Relative {
+			g.value += m.Value
+		} else {
+			g.value = m.Value
+		}
+		g.updated = true
+
+	case Timer, Histogram:
+		t := s.timers[key]
+		if t == nil {
+			t = &timer{name: m.Name, tags: m.Tags}
+			s.timers[key] = t
+		}
+		t.count += 1 / m.SampleRate
+		t.values = append(t.values, m.Value)
+	}
+	return nil
+}
+
+// Flush writes the aggregated metrics and resets the counters and timers.
+func (s *Server) Flush() {
+	now := time.Now().UTC()
+
+	s.mu.Lock()
+	counters, timers := s.counters, s.timers
+	s.counters, s.timers = make(map[string]*counter), make(map[string]*timer)
+
+	var gauges []*gauge
+	for _, g := range s.gauges {
+		if g.updated {
+			gauges = append(gauges, &gauge{name: g.name, tags: g.tags, value: g.value})
+			g.updated = false
+		}
+	}
+	s.mu.Unlock()
+
+	for _, c := range counters {
+		s.write(c.name, c.tags, now, map[string]interface{}{"value": c.value})
+	}
+	for _, g := range gauges {
+		s.write(g.name, g.tags, now, map[string]interface{}{"value": g.value})
+	}
+	for _, t := range timers {
+		s.write(t.name, t.tags, now, t.fields(s.Percentiles))
+	}
+}
+
+// write writes a single point and logs any error.
+func (s *Server) write(name string, tags map[string]string, timestamp time.Time, values map[string]interface{}) {
+	if err := s.writer.WriteSeries(s.Database, s.RetentionPolicy, name, tags, timestamp, values); err != nil {
+		s.Logger.Printf("write %s: %s", name, err)
+	}
+}
+
+// fields returns the summary statistics of a timer's values.
+func (t *timer) fields(percentiles []float64) map[string]interface{} {
+	sort.Float64s(t.values)
+	n := float64(len(t.values))
+
+	var sum float64
+	for _, v := range t.values {
+		sum += v
+	}
+	mean := sum / n
+
+	var variance float64
+	for _, v := range t.values {
+		variance += (v - mean) * (v - mean)
+	}
+
+	values := map[string]interface{}{
+		"count":  t.count,
+		"sum":    sum,
+		"mean":   mean,
+		"lower":  t.values[0],
+		"upper":  t.values[len(t.values)-1],
+		"stddev": math.Sqrt(variance / n),
+	}
+
+	// Percentiles use the nearest rank of the sorted values.
+	for _, p := range percentiles {
+		i := int(math.Ceil(p/100*n)) - 1
+		if i < 0 {
+			i = 0
+		}
+		name := "p" + strings.Replace(strconv.FormatFloat(p, 'f', -1, 64), ".", "_", -1)
+		values[name] = t.values[i]
+	}
+	return values
+}
@@ -0,0 +1,187 @@
+package statsd_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/statsd"
+)
+
+func Test_Parse(t *testing.T) {
+	var tests = []struct {
+		line string
+		m    *statsd.Metric
+		err  string
+	}{
+		{line: "hits:1|c", m: &statsd.Metric{Name: "hits", Type: "c", Value: 1, SampleRate: 1}},
+		{line: "hits:2|c|@0.1", m: &statsd.Metric{Name: "hits", Type: "c", Value: 2, SampleRate: 0.1}},
+		{line: "temp:-4.5|g", m: &statsd.Metric{Name: "temp", Type: "g", Value: -4.5, Relative: true, SampleRate: 1}},
+		{line: "temp:20|g|#host:a,region:uswest", m: &statsd.Metric{Name: "temp", Tags: map[string]string{"host": "a", "region": "uswest"}, Type: "g", Value: 20, SampleRate: 1}},
+		{line: "api.latency:320|ms", m: &statsd.Metric{Name: "api.latency", Type: "ms", Value: 320, SampleRate: 1}},
+		{line: "size:1024|h|@0.5|#host:a", m: &statsd.Metric{Name: "size", Tags: map[string]string{"host": "a"}, Type: "h", Value: 1024, SampleRate: 0.5}},
+
+		{line: "hits", err: `received "hits" which doesn't have a name`},
+		{line: ":1|c", err: `received ":1|c" which doesn't have a name`},
+		{line: "hits:1", err: `received "hits:1" which doesn't have a type`},
+		{line: "hits:1|s", err: `received "hits:1|s" which has an unsupported type: s`},
+		{line: "hits:x|c", err: `received "hits:x|c" which has an invalid value`},
+		{line: "hits:NaN|c", err: `received "hits:NaN|c" which has an invalid value`},
+		{line: "hits:1|c|@0", err: `received "hits:1|c|@0" which has an invalid sample rate`},
+		{line: "hits:1|c|@2", err: `received "hits:1|c|@2" which has an invalid sample rate`},
+		{line: "hits:1|c|#host", err: `received "hits:1|c|#host" which has an invalid tag: "host"`},
+		{line: "hits:1|c|x", err: `received "hits:1|c|x" which has an unknown section: "x"`},
+	}
+
+	for i, tt := range tests {
+		m, err := statsd.Parse(tt.line)
+		if errstr(err) != tt.err {
+			t.Errorf("%d. %s: error mismatch: exp=%s, got=%v", i, tt.line, tt.err, err)
+		} else if !reflect.DeepEqual(m, tt.m) {
+			t.Errorf("%d. %s: metric mismatch:\n\nexp=%#v\n\ngot=%#v", i, tt.line, tt.m, m)
+		}
+	}
+}
+
+func Test_Server_Flush(t *testing.T) {
+	var w testSeriesWriter
+	s := statsd.NewServer(&w)
+	s.Database = "statsd"
+	s.Percentiles = []float64{50, 99.9}
+
+	for _, line := range []string{
+		"hits:1|c",
+		"hits:2|c|@0.5",
+		"hits:1|c|#host:a",
+		"temp:20|g",
+		"temp:+5|g",
+		"temp:-1|g",
+		"latency:30|ms",
+		"latency:10|ms",
+		"latency:20|ms|@0.5",
+	} {
+		if err := s.Handle(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Flush()
+
+	if exp := []string{
+		`hits map[] map[value:5]`,
+		`hits map[host:a] map[value:1]`,
+		`latency map[] map[count:4 lower:10 mean:20 p50:20 p99_9:30 stddev:8.16496580927726 sum:60 upper:30]`,
+		`temp map[] map[value:24]`,
+	}; !reflect.DeepEqual(w.points(), exp) {
+		t.Fatalf("unexpected points:\n\nexp=%v\n\ngot=%v", exp, w.points())
+	}
+
+	// Counters and timers are reset. Gauges are only written when updated
+	// and relative updates apply to their previous value.
+	w.reset()
+	s.Handle("hits:3|c")
+	s.Flush()
+	s.Handle("temp:+1|g")
+	s.Flush()
+	if exp := []string{`hits map[] map[value:3]`, `temp map[] map[value:25]`}; !reflect.DeepEqual(w.points(), exp) {
+		t.Fatalf("unexpected points:\n\nexp=%v\n\ngot=%v", exp, w.points())
+	}
+}
+
+func Test_Server_ListenAndServe(t *testing.T) {
+	var w testSeriesWriter
+	s := statsd.NewServer(&w)
+	s.Database = "statsd"
+	s.RetentionPolicy = "raw"
+	s.FlushInterval = time.Hour
+	s.Logger = log.New(ioutil.Discard, "", 0)
+
+	if err := s.ListenAndServe("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("udp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hits:1|c\nhits:2|c\nbad\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the packet to be aggregated. Metrics are flushed on close.
+	time.Sleep(100 * time.Millisecond)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	} else if exp := []string{`hits map[] map[value:3]`}; !reflect.DeepEqual(w.points(), exp) {
+		t.Fatalf("unexpected points:\n\nexp=%v\n\ngot=%v", exp, w.points())
+	} else if w.database != "statsd" || w.retentionPolicy != "raw" {
+		t.Fatalf("unexpected destination: %s.%s", w.database, w.retentionPolicy)
+	}
+
+	if err := s.Close(); err != statsd.ErrServerClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func Test_Server_ListenAndServe_Invalid(t *testing.T) {
+	var w testSeriesWriter
+	s := statsd.NewServer(&w)
+	if err := s.ListenAndServe(""); err != statsd.ErrBindAddressRequired {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.ListenAndServe("127.0.0.1:0"); err != statsd.ErrDatabaseNotSpecified {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s.Database = "statsd"
+	s.Percentiles = []float64{0}
+	if err := s.ListenAndServe("127.0.0.1:0"); err != statsd.ErrInvalidPercentile {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// testSeriesWriter records written points.
+type testSeriesWriter struct {
+	mu              sync.Mutex
+	database        string
+	retentionPolicy string
+	a               []string
+}
+
+func (w *testSeriesWriter) WriteSeries(database, retentionPolicy, name string, tags map[string]string, timestamp time.Time, values map[string]interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	w.database, w.retentionPolicy = database, retentionPolicy
+	w.a = append(w.a, fmt.Sprintf("%s %v %v", name, tags, values))
+	return nil
+}
+
+// points returns the written points in sorted order.
+func (w *testSeriesWriter) points() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	a := append([]string{}, w.a...)
+	sort.Strings(a)
+	return a
+}
+
+func (w *testSeriesWriter) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.a = nil
+}
+
+func errstr(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
@@ -0,0 +1,131 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// streamKeepAlive is how often a comment is sent on an idle event stream so
+// that proxies don't close the connection.
+const streamKeepAlive = 15 * time.Second
+
+// serveStream sends the points written to a measurement as server-sent
+// events. Each point is a "message" event whose data is a JSON row. Points
+// dropped because the client fell behind are reported by a "dropped" event
+// before the next point.
+func (h *Handler) serveStream(w http.ResponseWriter, r *http.Request, u *User) {
+	q := r.URL.Query()
+
+	// Ensure the database exists and the user can read the measurement.
+	db, measurement := q.Get("db"), q.Get("measurement")
+	if db == "" {
+		h.error(w, ErrDatabaseRequired.Error(), http.StatusBadRequest)
+		return
+	} else if measurement == "" {
+		h.error(w, ErrMeasurementNameRequired.Error(), http.StatusBadRequest)
+		return
+	} else if !h.server.DatabaseExists(db) {
+		h.error(w, ErrDatabaseNotFound.Error(), http.StatusNotFound)
+		return
+	} else if h.server.DatabaseDisabled(db) {
+		h.error(w, ErrDatabaseDisabled.Error(), http.StatusForbidden)
+		return
+	} else if !u.AuthorizeMeasurement(influxql.ReadPrivilege, db, measurement) {
+		h.error(w, ErrReadAccessDenied.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Parse the condition on the tags and fields of the points.
+	var cond influxql.Expr
+	if s := q.Get("where"); s != "" {
+		var err error
+		if cond, err = influxql.NewParser(strings.NewReader(s)).ParseExpr(); err != nil {
+			h.error(w, "parse error: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Parse the precision of returned timestamps. Defaults to microseconds.
+	precision := MicrosecondPrecision
+	if s := q.Get("time_precision"); s != "" {
+		var err error
+		if precision, err = ParseTimePrecision(s); err != nil {
+			h.error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Parse the buffer size and which points are dropped when it's full.
+	var opt TailOptions
+	if s := q.Get("buffer"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			h.error(w, "invalid buffer: "+s, http.StatusBadRequest)
+			return
+		}
+		opt.BufferSize = n
+	}
+	if s := q.Get("drop"); s != "" {
+		var err error
+		if opt.DropPolicy, err = ParseTailDropPolicy(s); err != nil {
+			h.error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	t, err := h.server.Tail(db, measurement, cond, opt)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer t.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(streamKeepAlive)
+	defer ticker.Stop()
+
+	var dropped int
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			_, err = io.WriteString(w, ": keep-alive\n\n")
+		case p, ok := <-t.C:
+			if !ok {
+				return
+			}
+
+			// Report any points dropped since the last point was sent.
+			if n := t.Dropped(); n > dropped {
+				fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d}\n\n", n-dropped)
+				dropped = n
+			}
+
+			b, _ := json.Marshal(p.row(precision))
+			_, err = fmt.Fprintf(w, "data: %s\n\n", b)
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
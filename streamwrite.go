@@ -0,0 +1,376 @@
+package influxdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+const (
+	// DefaultStreamWritePort is the default port of the streaming write listener.
+	DefaultStreamWritePort = 8087
+
+	// DefaultStreamWriteBatchSize is the default number of points written at
+	// once from a connection.
+	DefaultStreamWriteBatchSize = 5000
+
+	// DefaultStreamWriteAckInterval is the default time lines wait before
+	// they're written and acknowledged.
+	DefaultStreamWriteAckInterval = time.Second
+)
+
+// StreamWriteServer accepts long-lived TCP connections that stream points in
+// line protocol. Each connection starts with a JSON handshake line naming the
+// database, retention policy, timestamp precision and credentials:
+//
+//	{"db": "foo", "rp": "", "precision": "s", "u": "user", "p": "pass"}
+//
+// An API token can be sent as "token" instead of a username and password.
+// The server replies with {"ok": true}, or with {"error": "..."} and closes
+// the connection. The client then writes one point per line. Points are
+// written in batches of BatchSize or every AckInterval and each batch is
+// acknowledged with the number of lines read so far and the lines that could
+// not be written:
+//
+//	{"ack": 1000, "errors": [{"line": 12, "error": "invalid field format"}]}
+//
+// Lines that can't be parsed or are rejected don't stop the stream. If a
+// batch can't be written at all, such as when the write path is backed up,
+// the ack has an "error" and none of the lines since the previous ack were
+// written.
+type StreamWriteServer struct {
+	server *Server
+
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closing  chan struct{}
+
+	// Rejects connections without valid credentials if set.
+	RequireAuthentication bool
+
+	// The maximum number of points written at once from a connection and
+	// the time lines wait before they are written and acknowledged.
+	BatchSize   int
+	AckInterval time.Duration
+
+	// How timestamps are assigned to written points.
+	Timestamps TimestampPolicy
+}
+
+// NewStreamWriteServer returns an instance of StreamWriteServer attached to a Server.
+func NewStreamWriteServer(s *Server) *StreamWriteServer {
+	return &StreamWriteServer{
+		server:      s,
+		BatchSize:   DefaultStreamWriteBatchSize,
+		AckInterval: DefaultStreamWriteAckInterval,
+	}
+}
+
+// ListenAndServe opens a TCP listener on addr and accepts connections in a
+// separate goroutine.
+func (s *StreamWriteServer) ListenAndServe(addr string) error {
+	if addr == "" {
+		return ErrBindAddressRequired
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.listener = l
+	s.conns = make(map[net.Conn]struct{})
+	s.closing = make(chan struct{})
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go s.serve(l)
+	return nil
+}
+
+// Addr returns the address the server is listening on.
+// Returns nil if the server is not listening.
+func (s *StreamWriteServer) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close stops accepting connections and closes open connections. Points
+// already read from a connection are still written.
+func (s *StreamWriteServer) Close() error {
+	s.mu.Lock()
+	l := s.listener
+	if l != nil {
+		close(s.closing)
+		for conn := range s.conns {
+			_ = conn.Close()
+		}
+	}
+	s.listener = nil
+	s.mu.Unlock()
+
+	if l == nil {
+		return ErrServerClosed
+	}
+	err := l.Close()
+	s.wg.Wait()
+	return err
+}
+
+// serve accepts connections until the listener is closed.
+func (s *StreamWriteServer) serve(l net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.listener == nil {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.handleConn(conn)
+	}
+}
+
+// streamWriteHandshake is the first line sent on a connection.
+type streamWriteHandshake struct {
+	Database        string `json:"db"`
+	RetentionPolicy string `json:"rp,omitempty"`
+	Precision       string `json:"precision,omitempty"`
+	Username        string `json:"u,omitempty"`
+	Password        string `json:"p,omitempty"`
+	Token           string `json:"token,omitempty"`
+}
+
+// streamWriteReply is the response to a handshake.
+type streamWriteReply struct {
+	OK  bool   `json:"ok,omitempty"`
+	Err string `json:"error,omitempty"`
+}
+
+// streamWriteAck is sent after each batch of lines is written.
+type streamWriteAck struct {
+	Ack    int                `json:"ack"`
+	Err    string             `json:"error,omitempty"`
+	Errors []*streamLineError `json:"errors,omitempty"`
+}
+
+// streamLineError is the reason a line of a stream was not written.
+type streamLineError struct {
+	Line int    `json:"line"`
+	Err  string `json:"error"`
+}
+
+// handleConn authorizes a connection and writes the points streamed on it.
+func (s *StreamWriteServer) handleConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	enc := json.NewEncoder(conn)
+
+	// Read the handshake and authorize the write.
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return
+	}
+	var hs streamWriteHandshake
+	if err := json.Unmarshal(line, &hs); err != nil {
+		_ = enc.Encode(&streamWriteReply{Err: err.Error()})
+		return
+	}
+	w, err := s.authorize(&hs)
+	if err != nil {
+		_ = enc.Encode(&streamWriteReply{Err: err.Error()})
+		return
+	}
+	if err := enc.Encode(&streamWriteReply{OK: true}); err != nil {
+		return
+	}
+
+	// Read lines in a separate goroutine so batches can be written on an interval.
+	lines, done := make(chan []byte), make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(lines)
+		for {
+			line, err := r.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) > 0 {
+				select {
+				case lines <- line:
+				case <-done:
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(s.AckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				_ = enc.Encode(w.flush())
+				return
+			}
+			w.add(line)
+			if len(w.points) >= s.BatchSize {
+				if err := enc.Encode(w.flush()); err != nil {
+					return
+				}
+			}
+		case <-ticker.C:
+			if w.n > w.acked {
+				if err := enc.Encode(w.flush()); err != nil {
+					return
+				}
+			}
+		case <-s.closing:
+			_ = enc.Encode(w.flush())
+			return
+		}
+	}
+}
+
+// authorize checks the handshake's credentials and that its user can write
+// to the database. Returns a writer for the connection's points.
+func (s *StreamWriteServer) authorize(hs *streamWriteHandshake) (*streamWriter, error) {
+	precision := NanosecondPrecision
+	if hs.Precision != "" {
+		p, err := ParseTimePrecision(hs.Precision)
+		if err != nil {
+			return nil, err
+		}
+		precision = p
+	}
+
+	// Authenticate the user, if credentials are sent or required.
+	var u *User
+	var err error
+	if hs.Token != "" {
+		u, err = s.server.AuthenticateToken(hs.Token)
+	} else if hs.Username != "" || s.RequireAuthentication {
+		u, err = s.server.Authenticate(hs.Username, hs.Password)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case s.server.QueryOnly():
+		return nil, ErrQueryOnlyNode
+	case !s.server.DatabaseExists(hs.Database):
+		return nil, ErrDatabaseNotFound
+	case s.server.DatabaseDisabled(hs.Database):
+		return nil, ErrDatabaseDisabled
+	case s.server.DatabaseReadOnly(hs.Database):
+		return nil, ErrDatabaseReadOnly
+	case !u.Authorize(influxql.WritePrivilege, hs.Database):
+		return nil, ErrWriteAccessDenied
+	}
+
+	return &streamWriter{
+		server:          s.server,
+		user:            u,
+		database:        hs.Database,
+		retentionPolicy: hs.RetentionPolicy,
+		precision:       precision,
+		timestamps:      s.Timestamps,
+	}, nil
+}
+
+// streamWriter batches the points of a connection.
+type streamWriter struct {
+	server          *Server
+	user            *User
+	database        string
+	retentionPolicy string
+	precision       TimePrecision
+	timestamps      TimestampPolicy
+
+	n      int                // lines read
+	acked  int                // lines acknowledged
+	points []*Point           // points waiting to be written
+	lines  []int              // line number of each point
+	errs   []*streamLineError // lines rejected since the last ack
+}
+
+// add parses a line and adds its point to the batch.
+func (w *streamWriter) add(line []byte) {
+	w.n++
+	p, err := ParseLineBytes(bytes.TrimSpace(line), w.precision, time.Now())
+	if err != nil {
+		w.errs = append(w.errs, &streamLineError{Line: w.n, Err: err.Error()})
+		return
+	} else if !w.user.AuthorizeMeasurement(influxql.WritePrivilege, w.database, p.Name) {
+		w.errs = append(w.errs, &streamLineError{Line: w.n, Err: ErrWriteAccessDenied.Error()})
+		return
+	}
+	w.points = append(w.points, p)
+	w.lines = append(w.lines, w.n)
+}
+
+// flush writes the batch and returns its acknowledgement. Invalid points are
+// reported and the rest of the batch is written without them.
+func (w *streamWriter) flush() *streamWriteAck {
+	points, lines := w.points, w.lines
+	var err error
+	if len(points) > 0 {
+		w.server.CorrectTimestamps(w.database, w.timestamps, points)
+		err = w.server.WritePoints(w.database, w.retentionPolicy, points)
+	}
+	if errs, ok := err.(PointErrors); ok {
+		invalid := make(map[int]bool, len(errs))
+		for _, e := range errs {
+			invalid[e.Index] = true
+			w.errs = append(w.errs, &streamLineError{Line: lines[e.Index], Err: e.Err.Error()})
+		}
+
+		var valid []*Point
+		for i, p := range points {
+			if !invalid[i] {
+				valid = append(valid, p)
+			}
+		}
+		err = nil
+		if len(valid) > 0 {
+			err = w.server.WritePoints(w.database, w.retentionPolicy, valid)
+		}
+	}
+
+	ack := &streamWriteAck{Ack: w.n, Errors: w.errs}
+	if err != nil {
+		ack.Err = err.Error()
+	}
+	w.points, w.lines, w.errs = nil, nil, nil
+	w.acked = w.n
+	return ack
+}
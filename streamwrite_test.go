@@ -0,0 +1,108 @@
+package influxdb_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb"
+)
+
+// Ensure points streamed on a connection are written and acknowledged in batches.
+func TestStreamWriteServer(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	sw := influxdb.NewStreamWriteServer(s.Server)
+	sw.BatchSize = 2
+	sw.AckInterval = 10 * time.Millisecond
+	if err := sw.ListenAndServe("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer sw.Close()
+
+	conn, err := net.Dial("tcp", sw.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	if reply := MustStreamWrite(t, conn, r, `{"db":"foo","precision":"s"}`); reply != `{"ok":true}` {
+		t.Fatalf("unexpected reply: %s", reply)
+	}
+
+	// The batch is written once it has two points. The invalid line is reported.
+	fmt.Fprint(conn, "cpu value=1 946684800\ncpu value=\n")
+	if ack := MustStreamWrite(t, conn, r, "cpu value=2 946684801"); ack != `{"ack":3,"errors":[{"line":2,"error":"invalid field: \"value=\": missing value"}]}` {
+		t.Fatalf("unexpected ack: %s", ack)
+	}
+
+	// Smaller batches are written after the ack interval.
+	if ack := MustStreamWrite(t, conn, r, "cpu value=3 946684802"); ack != `{"ack":4}` {
+		t.Fatalf("unexpected ack: %s", ack)
+	}
+	s.Sync(c.index)
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil, influxdb.QueryOptions{})
+	if results[0].Err != nil {
+		t.Fatalf("unexpected error: %s", results[0].Err)
+	} else if rows := mustMarshalJSON(results[0].Rows); rows != `[{"name":"cpu","columns":["time","sum"],"values":[[0,6]]}]` {
+		t.Fatalf("unexpected rows: %s", rows)
+	}
+}
+
+// Ensure connections are rejected if the handshake can't write to the database.
+func TestStreamWriteServer_Handshake(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateUser("susy", "pass", false)
+
+	sw := influxdb.NewStreamWriteServer(s.Server)
+	sw.RequireAuthentication = true
+	if err := sw.ListenAndServe("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer sw.Close()
+
+	for i, tt := range []struct {
+		handshake string
+		reply     string
+	}{
+		{handshake: `{"db":"foo"}`, reply: `{"error":"user not found"}`},
+		{handshake: `{"db":"foo","u":"susy","p":"wrong"}`, reply: `{"error":"invalid credentials"}`},
+		{handshake: `{"db":"bar","u":"susy","p":"pass"}`, reply: `{"error":"database not found"}`},
+		{handshake: `{"db":"foo","u":"susy","p":"pass","precision":"d"}`, reply: `{"error":"Unknown time precision d"}`},
+		{handshake: `{"db":"foo","u":"susy","p":"pass"}`, reply: `{"ok":true}`},
+	} {
+		conn, err := net.Dial("tcp", sw.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply := MustStreamWrite(t, conn, bufio.NewReader(conn), tt.handshake); reply != tt.reply {
+			t.Errorf("%d. unexpected reply: %s", i, reply)
+		}
+		conn.Close()
+	}
+}
+
+// MustStreamWrite writes a line to a stream and returns the next line of the response.
+func MustStreamWrite(t *testing.T, conn net.Conn, r *bufio.Reader, line string) string {
+	if _, err := fmt.Fprintln(conn, line); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(reply)
+}
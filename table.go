@@ -0,0 +1,220 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// columnKind is the type of a column of a flattened result.
+type columnKind int
+
+const (
+	columnUnknown columnKind = iota
+	columnInteger
+	columnUnsigned
+	columnFloat
+	columnBoolean
+	columnString
+	columnTimestamp
+)
+
+// String returns the name of the type.
+func (k columnKind) String() string {
+	switch k {
+	case columnInteger:
+		return "integer"
+	case columnUnsigned:
+		return "unsigned"
+	case columnFloat:
+		return "float"
+	case columnBoolean:
+		return "boolean"
+	case columnString:
+		return "string"
+	case columnTimestamp:
+		return "timestamp"
+	}
+	return "unknown"
+}
+
+// resultColumn represents a column of a flattened result and where its
+// values are read from in each row.
+type resultColumn struct {
+	name   string
+	kind   columnKind
+	series bool // read from the row's name
+	tag    bool // read from the row's tags instead of its values
+}
+
+// value returns the column's value in a row's values. Index maps the row's
+// columns to their position. Returns nil if the row doesn't have the column.
+func (c *resultColumn) value(row *influxql.Row, index map[string]int, values []interface{}) interface{} {
+	if c.series {
+		return row.Name
+	} else if c.tag {
+		if v, ok := row.Tags[c.name]; ok {
+			return v
+		}
+		return nil
+	} else if i, ok := index[c.name]; ok && i < len(values) {
+		return values[i]
+	}
+	return nil
+}
+
+// resultColumns returns the columns of rows flattened into a single table:
+// the series name, each tag key in order and the columns of the rows in the
+// order they're first seen. Columns are typed from their values.
+func resultColumns(rows []*influxql.Row) []*resultColumn {
+	// The first column is the series name.
+	columns := []*resultColumn{{name: "name", kind: columnString, series: true}}
+
+	// Add a column for every tag key.
+	keys := make(map[string]bool)
+	for _, row := range rows {
+		for k := range row.Tags {
+			keys[k] = true
+		}
+	}
+	tagKeys := make([]string, 0, len(keys))
+	for k := range keys {
+		tagKeys = append(tagKeys, k)
+	}
+	sort.Strings(tagKeys)
+	for _, k := range tagKeys {
+		columns = append(columns, &resultColumn{name: k, kind: columnString, tag: true})
+	}
+
+	// Add the value columns in the order they're first seen.
+	index := make(map[string]*resultColumn)
+	for _, row := range rows {
+		for i, name := range row.Columns {
+			c := index[name]
+			if c == nil {
+				c = &resultColumn{name: name}
+				index[name] = c
+				columns = append(columns, c)
+			}
+			for _, values := range row.Values {
+				if i < len(values) && values[i] != nil {
+					c.kind = mergeColumnKind(c.kind, columnKindOf(values[i]))
+				}
+			}
+		}
+	}
+
+	// Columns without any values are typed as strings.
+	for _, c := range columns {
+		if c.kind == columnUnknown {
+			c.kind = columnString
+		} else if c.name == "time" && c.kind == columnInteger {
+			c.kind = columnTimestamp
+		}
+	}
+	return columns
+}
+
+// columnKindOf returns the column type of a value.
+func columnKindOf(v interface{}) columnKind {
+	switch v.(type) {
+	case int64:
+		return columnInteger
+	case uint64:
+		return columnUnsigned
+	case float64:
+		return columnFloat
+	case bool:
+		return columnBoolean
+	}
+	return columnString
+}
+
+// mergeColumnKind returns the type of a column with values of two types.
+// Numbers of different types are widened to floats and other mixed values
+// to strings.
+func mergeColumnKind(a, b columnKind) columnKind {
+	switch {
+	case a == columnUnknown || a == b:
+		return b
+	case (a == columnInteger || a == columnUnsigned || a == columnFloat) && (b == columnInteger || b == columnUnsigned || b == columnFloat):
+		return columnFloat
+	}
+	return columnString
+}
+
+// convertColumnValue returns a value as the type of its column. Numbers in
+// float columns are converted to floats and values in string columns that
+// aren't strings are encoded as JSON.
+func convertColumnValue(kind columnKind, v interface{}) interface{} {
+	switch kind {
+	case columnFloat:
+		switch v := v.(type) {
+		case int64:
+			return float64(v)
+		case uint64:
+			return float64(v)
+		}
+	case columnString:
+		switch v.(type) {
+		case nil, string:
+		default:
+			b, _ := json.Marshal(v)
+			return string(b)
+		}
+	}
+	return v
+}
+
+// TableResult represents the result of a statement flattened into a single
+// table for clients that expect a fixed set of typed columns, such as SQL
+// drivers and CSV exporters. Each series contributes its values as rows with
+// its name and tag values in the leading columns.
+type TableResult struct {
+	StatementID int             `json:"statement_id"`
+	Columns     []*TableColumn  `json:"columns,omitempty"`
+	Values      [][]interface{} `json:"values,omitempty"`
+	Partial     bool            `json:"partial,omitempty"`
+	Next        string          `json:"next,omitempty"`
+	Err         string          `json:"error,omitempty"`
+}
+
+// TableColumn describes a column of a TableResult.
+type TableColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Tag  bool   `json:"tag,omitempty"`
+}
+
+// NewTableResult flattens the rows of a result into a TableResult.
+func NewTableResult(r *Result) *TableResult {
+	t := &TableResult{StatementID: r.StatementID, Next: r.Next}
+	if r.Err != nil {
+		t.Err = r.Err.Error()
+		return t
+	} else if len(r.Rows) == 0 {
+		return t
+	}
+
+	columns := resultColumns(r.Rows)
+	for _, c := range columns {
+		t.Columns = append(t.Columns, &TableColumn{Name: c.name, Type: c.kind.String(), Tag: c.tag || c.series})
+	}
+
+	for _, row := range r.Rows {
+		index := make(map[string]int, len(row.Columns))
+		for i, name := range row.Columns {
+			index[name] = i
+		}
+		for _, values := range row.Values {
+			a := make([]interface{}, len(columns))
+			for i, c := range columns {
+				a[i] = convertColumnValue(c.kind, c.value(row, index, values))
+			}
+			t.Values = append(t.Values, a)
+		}
+		t.Partial = t.Partial || row.Partial
+	}
+	return t
+}
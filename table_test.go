@@ -0,0 +1,58 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Ensure mixed value types are widened.
+func TestMergeColumnKind(t *testing.T) {
+	for i, tt := range []struct {
+		a, b columnKind
+		exp  columnKind
+	}{
+		{a: columnUnknown, b: columnBoolean, exp: columnBoolean},
+		{a: columnInteger, b: columnInteger, exp: columnInteger},
+		{a: columnInteger, b: columnUnsigned, exp: columnFloat},
+		{a: columnFloat, b: columnInteger, exp: columnFloat},
+		{a: columnFloat, b: columnBoolean, exp: columnString},
+		{a: columnString, b: columnInteger, exp: columnString},
+	} {
+		if k := mergeColumnKind(tt.a, tt.b); k != tt.exp {
+			t.Errorf("%d. unexpected kind: %d", i, k)
+		}
+	}
+}
+
+// Ensure series are flattened into a single table with typed columns.
+func TestNewTableResult(t *testing.T) {
+	tr := NewTableResult(&Result{
+		StatementID: 1,
+		Rows: []*influxql.Row{
+			{
+				Name:    "cpu",
+				Tags:    map[string]string{"host": "serverA"},
+				Columns: []string{"time", "value"},
+				Values:  [][]interface{}{{int64(10), float64(1.5)}, {int64(20), int64(2)}},
+			},
+			{
+				Name:    "cpu",
+				Tags:    map[string]string{"region": "uswest"},
+				Columns: []string{"time", "value", "extra"},
+				Values:  [][]interface{}{{int64(30), float64(3), []interface{}{"a"}}},
+				Partial: true,
+			},
+		},
+	})
+	if b, _ := json.Marshal(tr); string(b) != `{"statement_id":1,"columns":[{"name":"name","type":"string","tag":true},{"name":"host","type":"string","tag":true},{"name":"region","type":"string","tag":true},{"name":"time","type":"timestamp"},{"name":"value","type":"float"},{"name":"extra","type":"string"}],"values":[["cpu","serverA",null,10,1.5,null],["cpu","serverA",null,20,2,null],["cpu",null,"uswest",30,3,"[\"a\"]"]],"partial":true}` {
+		t.Fatalf("unexpected result: %s", b)
+	}
+
+	// Errors are returned without columns.
+	if b, _ := json.Marshal(NewTableResult(&Result{Err: errors.New("marker")})); string(b) != `{"statement_id":0,"error":"marker"}` {
+		t.Fatalf("unexpected result: %s", b)
+	}
+}
@@ -0,0 +1,274 @@
+package influxdb
+
+import (
+	"sort"
+
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// TagGuard limits the values written for a tag key of a measurement. Values
+// can be restricted to an allowlist, capped at a number of distinct values,
+// or both. Writes that violate the guard are rejected unless Other is set,
+// in which case the violating value is replaced with Other.
+type TagGuard struct {
+	Measurement string   `json:"measurement"`
+	Key         string   `json:"key"`
+	MaxValues   int      `json:"maxValues,omitempty"`
+	Allow       []string `json:"allow,omitempty"`
+	Other       string   `json:"other,omitempty"`
+}
+
+// validate returns an error if the guard is missing a measurement or tag key
+// or does not restrict any values.
+func (g *TagGuard) validate() error {
+	if g.Measurement == "" {
+		return ErrMeasurementNameRequired
+	} else if g.Key == "" {
+		return ErrTagKeyRequired
+	} else if g.MaxValues <= 0 && len(g.Allow) == 0 {
+		return ErrInvalidTagGuard
+	}
+	return nil
+}
+
+// check returns an error if value is not allowed by the guard. Values in
+// existing are already indexed and never count against the limit. New
+// values that are accepted are added to seen so that later points in the
+// same batch count them as well.
+func (g *TagGuard) check(value string, existing map[string]SeriesIDs, seen map[string]bool) error {
+	if len(g.Allow) > 0 && !g.allows(value) {
+		return ErrTagValueNotAllowed
+	}
+	if g.MaxValues > 0 {
+		if _, ok := existing[value]; ok || seen[value] {
+			return nil
+		} else if len(existing)+len(seen) >= g.MaxValues {
+			return ErrTagValueLimitExceeded
+		}
+		seen[value] = true
+	}
+	return nil
+}
+
+// allows returns true if value is in the allowlist.
+func (g *TagGuard) allows(value string) bool {
+	for _, v := range g.Allow {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// tagGuardKey identifies a tag key within a measurement.
+type tagGuardKey struct {
+	measurement string
+	key         string
+}
+
+// setTagGuard adds or replaces the guard for a measurement's tag key.
+func (db *database) setTagGuard(g *TagGuard) {
+	guards := db.tagGuards[g.Measurement]
+	if guards == nil {
+		guards = make(map[string]*TagGuard)
+		db.tagGuards[g.Measurement] = guards
+	}
+	guards[g.Key] = g
+}
+
+// guardTags checks the guarded tags of a point. Returns a copy of the point
+// if any tag values were replaced by their guard's other value. Returns a
+// *PointError if a value violates a guard without an other value.
+func (db *database) guardTags(p *Point, seen map[tagGuardKey]map[string]bool) (*Point, error) {
+	guards := db.tagGuards[p.Name]
+	if len(guards) == 0 {
+		return p, nil
+	}
+	m := db.index().measurements[p.Name]
+
+	var tags map[string]string
+	for k, v := range p.Tags {
+		g := guards[k]
+		if g == nil {
+			continue
+		}
+
+		// Lookup the values already indexed and added by the batch.
+		var existing map[string]SeriesIDs
+		if m != nil {
+			existing = m.seriesByTagKeyValue[k]
+		}
+		key := tagGuardKey{p.Name, k}
+		if seen[key] == nil {
+			seen[key] = make(map[string]bool)
+		}
+
+		if err := g.check(v, existing, seen[key]); err == nil {
+			continue
+		} else if g.Other == "" {
+			return nil, &PointError{Key: k, Err: err}
+		}
+
+		// Replace the value without modifying the caller's tags.
+		if tags == nil {
+			tags = make(map[string]string, len(p.Tags))
+			for k, v := range p.Tags {
+				tags[k] = v
+			}
+		}
+		tags[k] = g.Other
+	}
+
+	if tags == nil {
+		return p, nil
+	}
+	other := *p
+	other.Tags = tags
+	return &other, nil
+}
+
+// guardTags checks points against the tag guards of a database. Returns the
+// points with violating tag values replaced by their guard's other value, or
+// PointErrors for every point with a value that its guard rejects.
+func (s *Server) guardTags(database string, points []*Point) ([]*Point, PointErrors) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil || len(db.tagGuards) == 0 {
+		return points, nil
+	}
+
+	var errs PointErrors
+	a := make([]*Point, len(points))
+	seen := make(map[tagGuardKey]map[string]bool)
+	for i, p := range points {
+		other, err := db.guardTags(p, seen)
+		if err != nil {
+			e := err.(*PointError)
+			e.Index = i
+			errs = append(errs, e)
+			continue
+		}
+		a[i] = other
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return a, nil
+}
+
+// TagGuards returns the tag guards of a database, sorted by measurement and tag key.
+func (s *Server) TagGuards(database string) ([]*TagGuard, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+
+	a := make(tagGuards, 0)
+	for _, guards := range db.tagGuards {
+		for _, g := range guards {
+			a = append(a, g)
+		}
+	}
+	sort.Sort(a)
+	return a, nil
+}
+
+// SetTagGuard adds a guard to a tag key of a measurement, replacing any
+// existing guard for the key. Values that are already indexed are kept but
+// count against the guard's limit.
+func (s *Server) SetTagGuard(database string, g *TagGuard) error {
+	if err := g.validate(); err != nil {
+		return err
+	}
+	c := &setTagGuardCommand{Database: database, Guard: g}
+	_, err := s.broadcast(setTagGuardMessageType, c)
+	return err
+}
+
+func (s *Server) applySetTagGuard(m *messaging.Message) (err error) {
+	var c setTagGuardCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// Replace the guard.
+	db.setTagGuard(c.Guard)
+
+	// Persist to metastore.
+	err = s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+
+	return
+}
+
+type setTagGuardCommand struct {
+	Database string    `json:"database"`
+	Guard    *TagGuard `json:"guard"`
+}
+
+// DeleteTagGuard removes the guard from a tag key of a measurement.
+func (s *Server) DeleteTagGuard(database, measurement, key string) error {
+	c := &deleteTagGuardCommand{Database: database, Measurement: measurement, Key: key}
+	_, err := s.broadcast(deleteTagGuardMessageType, c)
+	return err
+}
+
+func (s *Server) applyDeleteTagGuard(m *messaging.Message) (err error) {
+	var c deleteTagGuardCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	} else if db.tagGuards[c.Measurement][c.Key] == nil {
+		return ErrTagGuardNotFound
+	}
+
+	// Remove the guard.
+	delete(db.tagGuards[c.Measurement], c.Key)
+	if len(db.tagGuards[c.Measurement]) == 0 {
+		delete(db.tagGuards, c.Measurement)
+	}
+
+	// Persist to metastore.
+	err = s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+
+	return
+}
+
+type deleteTagGuardCommand struct {
+	Database    string `json:"database"`
+	Measurement string `json:"measurement"`
+	Key         string `json:"key"`
+}
+
+// tagGuards represents a list of guards sortable by measurement and tag key.
+type tagGuards []*TagGuard
+
+func (a tagGuards) Len() int { return len(a) }
+func (a tagGuards) Less(i, j int) bool {
+	if a[i].Measurement != a[j].Measurement {
+		return a[i].Measurement < a[j].Measurement
+	}
+	return a[i].Key < a[j].Key
+}
+func (a tagGuards) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
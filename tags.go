@@ -0,0 +1,373 @@
+package influxdb
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// executeListSeriesStatement returns a row for each measurement in the
+// statement's source with the id and tag values of its series. Series are
+// ordered by measurement name and id so that pages of results are stable.
+func (s *Server) executeListSeriesStatement(stmt *influxql.ListSeriesStatement, database string) *Result {
+	if stmt.Estimate {
+		return s.estimateSeries(stmt, database)
+	}
+
+	series, err := s.matchSeries(database, stmt.Source, stmt.Condition)
+	if err != nil {
+		return &Result{Err: err}
+	}
+	i, j := pageBounds(len(series), stmt.Limit, stmt.Offset)
+	series = series[i:j]
+
+	// Build a row for each run of series from the same measurement.
+	var rows []*influxql.Row
+	for len(series) > 0 {
+		n := 1
+		for n < len(series) && series[n].measurement == series[0].measurement {
+			n++
+		}
+
+		keys := seriesTagKeys(series[:n])
+		row := &influxql.Row{Name: series[0].measurement.Name, Columns: append([]string{"id"}, keys...)}
+		for _, ser := range series[:n] {
+			values := []interface{}{ser.ID}
+			for _, k := range keys {
+				values = append(values, ser.Tags[k])
+			}
+			row.Values = append(row.Values, values)
+		}
+		rows = append(rows, row)
+		series = series[n:]
+	}
+	return &Result{Rows: rows}
+}
+
+// executeListTagValuesStatement returns a row of sorted values for each tag
+// key of the measurements in the statement's source. Statements bounded on
+// time only return values of series with points in that range, so that
+// template queries over recent data skip series that are no longer written.
+func (s *Server) executeListTagValuesStatement(stmt *influxql.ListTagValuesStatement, database string) *Result {
+	if stmt.Estimate {
+		return s.estimateTagValues(stmt, database)
+	}
+
+	series, err := s.matchSeries(database, stmt.Source, stmt.Condition)
+	if err != nil {
+		return &Result{Err: err}
+	}
+	keys := stmt.TagKeys
+	if len(keys) == 0 {
+		keys = seriesTagKeys(series)
+	}
+
+	// Build a row of values for each tag key.
+	var rows []*influxql.Row
+	for _, key := range keys {
+		values := make(TagValues)
+		for _, ser := range series {
+			if v, ok := ser.Tags[key]; ok {
+				values[v] = true
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		a := values.ToSlice()
+		i, j := pageBounds(len(a), stmt.Limit, stmt.Offset)
+		row := &influxql.Row{Name: key, Columns: []string{"value"}}
+		for _, v := range a[i:j] {
+			row.Values = append(row.Values, []interface{}{v})
+		}
+		rows = append(rows, row)
+	}
+	return &Result{Rows: rows}
+}
+
+// estimateSeries returns a row with the estimated number of series in each
+// measurement of a statement's source. Estimates are read from sketches
+// maintained by the index instead of walking the series.
+func (s *Server) estimateSeries(stmt *influxql.ListSeriesStatement, database string) *Result {
+	if stmt.Condition != nil {
+		return &Result{Err: ErrEstimateCondition}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	db := s.databases[database]
+	if db == nil {
+		return &Result{Err: ErrDatabaseNotFound}
+	}
+
+	var rows []*influxql.Row
+	for _, name := range db.sourceNames(stmt.Source) {
+		if m := db.index().measurements[name]; m != nil {
+			rows = append(rows, &influxql.Row{Name: name, Columns: []string{"count"}, Values: [][]interface{}{{m.seriesSketch.count()}}})
+		}
+	}
+	return &Result{Rows: rows}
+}
+
+// estimateTagValues returns a row with the estimated number of values of
+// each tag key across the measurements of a statement's source.
+func (s *Server) estimateTagValues(stmt *influxql.ListTagValuesStatement, database string) *Result {
+	if stmt.Condition != nil {
+		return &Result{Err: ErrEstimateCondition}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	db := s.databases[database]
+	if db == nil {
+		return &Result{Err: ErrDatabaseNotFound}
+	}
+
+	names := db.sourceNames(stmt.Source)
+	keys := stmt.TagKeys
+	if len(keys) == 0 {
+		keys = db.TagKeys(names)
+	}
+
+	// Merge the sketches of each key across measurements since
+	// measurements can share values.
+	var rows []*influxql.Row
+	for _, key := range keys {
+		var sk *sketch
+		for _, name := range names {
+			m := db.index().measurements[name]
+			if m == nil || m.tagSketches[key] == nil {
+				continue
+			} else if sk == nil {
+				sk = newSketch()
+			}
+			sk.merge(m.tagSketches[key])
+		}
+		if sk != nil {
+			rows = append(rows, &influxql.Row{Name: key, Columns: []string{"count"}, Values: [][]interface{}{{sk.count()}}})
+		}
+	}
+	return &Result{Rows: rows}
+}
+
+// matchSeries returns the series of the measurements in a source that match
+// the tag filters in a condition, ordered by measurement name and id.
+// Conditions bounded on time only match series with points in that range.
+func (s *Server) matchSeries(database string, src influxql.Source, cond influxql.Expr) ([]*Series, error) {
+	// Expand "now()" and split the condition into tag filters and time bounds.
+	now := time.Now().UTC()
+	if cond != nil {
+		cond = influxql.Fold(influxql.CloneExpr(cond), &now)
+	}
+	filters, err := seriesFilters(cond)
+	if err != nil {
+		return nil, err
+	}
+	min, max := influxql.TimeRange(cond)
+	bounded := !min.IsZero() || !max.IsZero()
+
+	s.mu.RLock()
+	db := s.databases[database]
+	if db == nil {
+		s.mu.RUnlock()
+		return nil, ErrDatabaseNotFound
+	}
+
+	// Find the series matching the tag filters.
+	var series []*Series
+	var ids SeriesIDs
+	for _, name := range db.sourceNames(src) {
+		m := db.index().measurements[name]
+		if m == nil {
+			continue
+		}
+
+		a := m.ids
+		if len(filters) > 0 {
+			a = db.seriesIDsByName(name, filters)
+		}
+		for _, id := range a {
+			series = append(series, db.index().series[id])
+		}
+		ids = ids.Union(a)
+	}
+
+	// Hold the stores of the shards overlapping the time range so they can
+	// be read after the server lock has been released.
+	var shards []*Shard
+	var stores []*shardStore
+	if bounded {
+		for _, sh := range db.shards {
+			if !max.IsZero() && sh.StartTime.After(max) {
+				continue
+			} else if sh.EndTime.Before(min) {
+				continue
+			}
+			st, e := sh.acquire()
+			if e == ErrShardNotFound {
+				continue
+			} else if e != nil {
+				err = e
+				break
+			}
+			shards, stores = append(shards, sh), append(stores, st)
+		}
+	}
+	s.mu.RUnlock()
+
+	defer func() {
+		for i, st := range stores {
+			shards[i].release(st)
+		}
+	}()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !bounded {
+		return series, nil
+	}
+
+	// Only keep the series with points in the time range.
+	var lo, hi int64
+	if !min.IsZero() {
+		lo = min.UnixNano()
+	}
+	if !max.IsZero() {
+		hi = max.UnixNano() + 1
+	}
+
+	found := make(map[uint32]bool)
+	for _, st := range stores {
+		a, err := st.seriesWithPoints(ids, lo, hi)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range a {
+			found[id] = true
+		}
+	}
+
+	var a []*Series
+	for _, ser := range series {
+		if found[ser.ID] {
+			a = append(a, ser)
+		}
+	}
+	return a, nil
+}
+
+// seriesTagKeys returns the sorted tag keys used by a set of series.
+func seriesTagKeys(series []*Series) []string {
+	set := make(map[string]bool)
+	for _, ser := range series {
+		for k := range ser.Tags {
+			set[k] = true
+		}
+	}
+
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// pageBounds returns the bounds of a page of n results.
+// A zero limit returns every result after the offset.
+func pageBounds(n, limit, offset int) (i, j int) {
+	if offset > n {
+		offset = n
+	}
+	i, j = offset, n
+	if limit > 0 && i+limit < j {
+		j = i + limit
+	}
+	return
+}
+
+// seriesFilters returns the tag filters in a condition made up of tag
+// comparisons, such as "region = 'uswest'", and bounds on time. Time bounds
+// are skipped. Returns ErrInvalidSeriesCondition for any other condition.
+func seriesFilters(cond influxql.Expr) ([]*TagFilter, error) {
+	var filters []*TagFilter
+	var walk func(expr influxql.Expr) error
+	walk = func(expr influxql.Expr) error {
+		switch expr := expr.(type) {
+		case *influxql.ParenExpr:
+			return walk(expr.Expr)
+		case *influxql.BinaryExpr:
+			if expr.Op == influxql.AND {
+				if err := walk(expr.LHS); err != nil {
+					return err
+				}
+				return walk(expr.RHS)
+			}
+
+			ref, ok := expr.LHS.(*influxql.VarRef)
+			if !ok {
+				return ErrInvalidSeriesCondition
+			}
+
+			// Time bounds are read separately.
+			if strings.ToLower(ref.Val) == "time" {
+				if _, ok := expr.RHS.(*influxql.TimeLiteral); !ok {
+					return ErrInvalidSeriesCondition
+				}
+				switch expr.Op {
+				case influxql.EQ, influxql.LT, influxql.LTE, influxql.GT, influxql.GTE:
+					return nil
+				}
+				return ErrInvalidSeriesCondition
+			}
+
+			lit, ok := expr.RHS.(*influxql.StringLiteral)
+			if !ok || (expr.Op != influxql.EQ && expr.Op != influxql.NEQ) {
+				return ErrInvalidSeriesCondition
+			}
+			filters = append(filters, &TagFilter{Key: ref.Val, Value: lit.Val, Not: expr.Op == influxql.NEQ})
+			return nil
+		default:
+			return ErrInvalidSeriesCondition
+		}
+	}
+
+	if cond == nil {
+		return nil, nil
+	} else if err := walk(cond); err != nil {
+		return nil, err
+	}
+	return filters, nil
+}
+
+// sourceNames returns the names of the measurements in a source that exist
+// in the database, in sorted order. A nil source returns every measurement.
+func (db *database) sourceNames(src influxql.Source) []string {
+	var measurements influxql.Measurements
+	switch src := src.(type) {
+	case nil:
+		return db.index().names
+	case *influxql.Measurement:
+		measurements = influxql.Measurements{src}
+	case *influxql.Join:
+		measurements = src.Measurements
+	case *influxql.Merge:
+		measurements = src.Measurements
+	}
+
+	var names []string
+	for _, name := range db.index().names {
+		for _, m := range measurements {
+			if (m.Regex != nil && m.Regex.Val.MatchString(name)) || (m.Regex == nil && m.Name == name) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,249 @@
+package influxdb
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+const (
+	// DefaultTailBufferSize is the number of points buffered for a tail
+	// before points are dropped.
+	DefaultTailBufferSize = 1000
+
+	// MaxTailBufferSize is the largest buffer a tail can request.
+	MaxTailBufferSize = 100000
+)
+
+// TailDropPolicy specifies which points are dropped when a tail's buffer is full.
+type TailDropPolicy int
+
+const (
+	// TailDropNewest drops newly written points until the reader catches up.
+	TailDropNewest TailDropPolicy = iota
+
+	// TailDropOldest drops the oldest buffered point to make room for
+	// each newly written point.
+	TailDropOldest
+)
+
+// ParseTailDropPolicy parses the name of a drop policy.
+func ParseTailDropPolicy(s string) (TailDropPolicy, error) {
+	switch s {
+	case "newest":
+		return TailDropNewest, nil
+	case "oldest":
+		return TailDropOldest, nil
+	}
+	return 0, fmt.Errorf("invalid drop policy: %s", s)
+}
+
+// TailOptions represents the settings of a tail.
+type TailOptions struct {
+	// Number of points buffered before points are dropped.
+	// Defaults to DefaultTailBufferSize.
+	BufferSize int
+
+	// Points dropped once the buffer is full.
+	DropPolicy TailDropPolicy
+}
+
+// Tail represents a subscription to the points written to a measurement.
+// Points are sent on C as they are applied by the server. Writes are never
+// blocked by a tail so points are dropped if the reader falls behind.
+//
+// Only points written to shards stored on this server are seen.
+type Tail struct {
+	C <-chan *TailPoint
+
+	c           chan *TailPoint
+	server      *Server
+	database    string
+	measurement string
+	condition   influxql.Expr
+	policy      TailDropPolicy
+	dropped     int  // points dropped because the buffer was full, protected by server.tailsMu
+	closed      bool // protected by server.tailsMu
+}
+
+// TailPoint represents a point sent to a tail.
+type TailPoint struct {
+	Name      string
+	Tags      map[string]string
+	Timestamp time.Time
+	Values    map[string]interface{}
+}
+
+// Tail returns a subscription to the points written to a measurement. If
+// condition is not nil then only points matching it are sent. Conditions
+// can reference the point's tags and fields.
+func (s *Server) Tail(database, measurement string, condition influxql.Expr, opt TailOptions) (*Tail, error) {
+	if measurement == "" {
+		return nil, ErrMeasurementNameRequired
+	} else if !s.DatabaseExists(database) {
+		return nil, ErrDatabaseNotFound
+	}
+
+	size := opt.BufferSize
+	if size <= 0 {
+		size = DefaultTailBufferSize
+	} else if size > MaxTailBufferSize {
+		size = MaxTailBufferSize
+	}
+
+	c := make(chan *TailPoint, size)
+	t := &Tail{
+		C:           c,
+		c:           c,
+		server:      s,
+		database:    database,
+		measurement: measurement,
+		condition:   condition,
+		policy:      opt.DropPolicy,
+	}
+
+	s.tailsMu.Lock()
+	s.tails[t] = struct{}{}
+	s.tailsMu.Unlock()
+	return t, nil
+}
+
+// Close stops sending points to the tail and closes C.
+func (t *Tail) Close() {
+	t.server.tailsMu.Lock()
+	defer t.server.tailsMu.Unlock()
+	t.close()
+}
+
+// close removes the tail from the server. Must be called with tailsMu held.
+func (t *Tail) close() {
+	if t.closed {
+		return
+	}
+	t.closed = true
+	delete(t.server.tails, t)
+	close(t.c)
+}
+
+// Dropped returns the number of points dropped because the tail's reader
+// fell behind.
+func (t *Tail) Dropped() int {
+	t.server.tailsMu.Lock()
+	defer t.server.tailsMu.Unlock()
+	return t.dropped
+}
+
+// match returns true if a point in the tail's database should be sent.
+func (t *Tail) match(p *TailPoint) bool {
+	if p.Name != t.measurement {
+		return false
+	} else if t.condition == nil {
+		return true
+	}
+
+	// Evaluate the condition against the tags and fields of the point.
+	m := make(map[string]interface{}, len(p.Tags)+len(p.Values))
+	for k, v := range p.Tags {
+		m[k] = v
+	}
+	for k, v := range p.Values {
+		m[k] = v
+	}
+	return influxql.Eval(t.condition, m) == true
+}
+
+// send buffers a point for the reader. Must be called with tailsMu held.
+func (t *Tail) send(p *TailPoint) {
+	select {
+	case t.c <- p:
+		return
+	default:
+	}
+
+	// Make room for the point by discarding the oldest one, if requested.
+	// The reader may empty the buffer in the meantime so neither is blocking.
+	if t.policy == TailDropOldest {
+		select {
+		case <-t.c:
+			t.dropped++
+		default:
+		}
+		select {
+		case t.c <- p:
+			return
+		default:
+		}
+	}
+	t.dropped++
+}
+
+// notifyTails sends points applied to a database to matching tails.
+func (s *Server) notifyTails(db *database, points [][]byte) {
+	s.tailsMu.Lock()
+	defer s.tailsMu.Unlock()
+
+	// Ignore the points if nothing is tailing the database.
+	var tails []*Tail
+	for t := range s.tails {
+		if t.database == db.name {
+			tails = append(tails, t)
+		}
+	}
+	if len(tails) == 0 {
+		return
+	}
+
+	for _, data := range points {
+		id, timestamp, values, err := unmarshalPoint(data)
+		if err != nil {
+			continue
+		}
+
+		// Find the measurement and tags of the point's series.
+		s.mu.RLock()
+		series := db.SeriesByID(id)
+		s.mu.RUnlock()
+		if series == nil || series.measurement == nil {
+			continue
+		}
+		p := &TailPoint{Name: series.measurement.Name, Tags: series.Tags, Timestamp: timestamp.UTC(), Values: values}
+
+		for _, t := range tails {
+			if t.match(p) {
+				t.send(p)
+			}
+		}
+	}
+}
+
+// row returns the point as a row with a time column followed by its fields
+// in sorted order.
+func (p *TailPoint) row(precision TimePrecision) *influxql.Row {
+	keys := make([]string, 0, len(p.Values))
+	for k := range p.Values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	values := []interface{}{precision.Timestamp(p.Timestamp)}
+	for _, k := range keys {
+		values = append(values, p.Values[k])
+	}
+	return &influxql.Row{
+		Name:    p.Name,
+		Tags:    p.Tags,
+		Columns: append([]string{"time"}, keys...),
+		Values:  [][]interface{}{values},
+	}
+}
+
+// closeTails closes all tails on the server.
+func (s *Server) closeTails() {
+	s.tailsMu.Lock()
+	defer s.tailsMu.Unlock()
+	for t := range s.tails {
+		t.close()
+	}
+}
@@ -0,0 +1,178 @@
+package influxdb
+
+import (
+	"strings"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// DatabaseTemplate represents the retention policies and users created along
+// with a database by ProvisionDatabase. Templates are decoded from JSON or TOML
+// so the same document can be used to provision many databases.
+type DatabaseTemplate struct {
+	RetentionPolicies []*TemplateRetentionPolicy `json:"retentionPolicies,omitempty" toml:"retention-policy"`
+	ContinuousQueries []string                   `json:"continuousQueries,omitempty" toml:"continuous-queries"`
+	Users             []*TemplateUser            `json:"users,omitempty" toml:"user"`
+}
+
+// TemplateRetentionPolicy represents a retention policy in a database template.
+type TemplateRetentionPolicy struct {
+	Name string `json:"name" toml:"name"`
+
+	// Length of time data is kept, such as "7d". Defaults to DefaultShardRetention.
+	Duration string `json:"duration,omitempty" toml:"duration"`
+
+	ReplicaN uint32 `json:"replicaN,omitempty" toml:"replica-n"`
+	SplitN   uint32 `json:"splitN,omitempty" toml:"split-n"`
+
+	// If true, the policy becomes the database's default retention policy.
+	Default bool `json:"default,omitempty" toml:"default"`
+}
+
+// TemplateUser represents a user in a database template. Occurrences of
+// "$database" in the name are replaced with the name of the database.
+//
+// Grants are token scopes without the database, such as "read", "write" or
+// "read:cpu*". If set, an API token with the grants is issued to the user.
+type TemplateUser struct {
+	Name     string   `json:"name" toml:"name"`
+	Password string   `json:"password" toml:"password"`
+	Admin    bool     `json:"admin,omitempty" toml:"admin"`
+	Grants   []string `json:"grants,omitempty" toml:"grants"`
+}
+
+// username returns the name of the user for a database.
+func (u *TemplateUser) username(database string) string {
+	return strings.Replace(u.Name, "$database", database, -1)
+}
+
+// scopes returns the user's grants as token scopes on a database.
+func (u *TemplateUser) scopes(database string) []string {
+	var a []string
+	for _, g := range u.Grants {
+		p := strings.SplitN(g, ":", 2)
+		scope := p[0] + ":" + database
+		if len(p) == 2 {
+			scope += ":" + p[1]
+		}
+		a = append(a, scope)
+	}
+	return a
+}
+
+// policies returns the template's retention policies and the name of the
+// default policy. Returns an error if the template is invalid.
+func (t *DatabaseTemplate) policies() (a []*RetentionPolicy, defaultPolicy string, err error) {
+	for _, p := range t.RetentionPolicies {
+		if p.Name == "" {
+			return nil, "", ErrRetentionPolicyNameRequired
+		}
+		for _, other := range a {
+			if other.Name == p.Name {
+				return nil, "", ErrRetentionPolicyExists
+			}
+		}
+
+		rp := NewRetentionPolicy(p.Name)
+		if p.Duration != "" {
+			if rp.Duration, err = influxql.ParseDuration(p.Duration); err != nil || rp.Duration <= 0 {
+				return nil, "", ErrInvalidRetentionDuration
+			}
+		}
+		if p.ReplicaN > 0 {
+			rp.ReplicaN = p.ReplicaN
+		}
+		if p.SplitN > 0 {
+			rp.SplitN = p.SplitN
+		}
+		if p.Default {
+			if defaultPolicy != "" {
+				return nil, "", ErrMultipleDefaultRetentionPolicies
+			}
+			defaultPolicy = p.Name
+		}
+		a = append(a, rp)
+	}
+	return a, defaultPolicy, nil
+}
+
+// ProvisionDatabase creates a database with the retention policies and users
+// of a template. Returns the API tokens issued to the template's users, keyed
+// by username. If any step fails then the database and the users created so
+// far are deleted.
+func (s *Server) ProvisionDatabase(name string, t *DatabaseTemplate) (tokens map[string]string, err error) {
+	if name == "" {
+		return nil, ErrDatabaseNameRequired
+	} else if len(t.ContinuousQueries) > 0 {
+		return nil, ErrContinuousQueriesNotSupported
+	}
+
+	// Validate the whole template before changing anything.
+	policies, defaultPolicy, err := t.policies()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, u := range t.Users {
+		username := u.username(name)
+		if username == "" {
+			return nil, ErrUsernameRequired
+		} else if seen[username] || s.User(username) != nil {
+			return nil, ErrUserExists
+		}
+		seen[username] = true
+
+		for _, scope := range u.scopes(name) {
+			if _, _, _, err := ParseTokenScope(scope); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if s.DatabaseExists(name) {
+		return nil, ErrDatabaseExists
+	}
+
+	// Create the database and roll it back if a later step fails.
+	if err := s.CreateDatabase(name); err != nil {
+		return nil, err
+	}
+	var users []string
+	defer func() {
+		if err != nil {
+			for _, username := range users {
+				_ = s.DeleteUser(username)
+			}
+			_ = s.DeleteDatabase(name)
+			tokens = nil
+		}
+	}()
+
+	for _, rp := range policies {
+		if err = s.CreateRetentionPolicy(name, rp); err != nil {
+			return
+		}
+	}
+	if defaultPolicy != "" {
+		if err = s.SetDefaultRetentionPolicy(name, defaultPolicy); err != nil {
+			return
+		}
+	}
+
+	tokens = make(map[string]string)
+	for _, u := range t.Users {
+		username := u.username(name)
+		if err = s.CreateUser(username, u.Password, u.Admin); err != nil {
+			return
+		}
+		users = append(users, username)
+
+		if len(u.Grants) > 0 {
+			var token string
+			if _, token, err = s.CreateToken(username, u.scopes(name)); err != nil {
+				return
+			}
+			tokens[username] = token
+		}
+	}
+	return tokens, nil
+}
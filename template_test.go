@@ -0,0 +1,115 @@
+package influxdb_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Ensure a database can be provisioned from a template.
+func TestServer_ProvisionDatabase(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	tokens, err := s.ProvisionDatabase("acme", &influxdb.DatabaseTemplate{
+		RetentionPolicies: []*influxdb.TemplateRetentionPolicy{
+			{Name: "raw", Duration: "7d", Default: true},
+			{Name: "archive", Duration: "52w", ReplicaN: 2},
+		},
+		Users: []*influxdb.TemplateUser{
+			{Name: "$database_writer", Password: "pass", Grants: []string{"write", "read:cpu*"}},
+			{Name: "$database_admin", Password: "pass", Admin: true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(tokens) != 1 || tokens["acme_writer"] == "" {
+		t.Fatalf("unexpected tokens: %v", tokens)
+	}
+	s.Restart()
+
+	// Verify the retention policies.
+	if rp, err := s.DefaultRetentionPolicy("acme"); err != nil {
+		t.Fatal(err)
+	} else if rp.Name != "raw" || rp.Duration != 7*24*time.Hour {
+		t.Fatalf("unexpected default policy: %#v", rp)
+	}
+	if rp, err := s.RetentionPolicy("acme", "archive"); err != nil {
+		t.Fatal(err)
+	} else if rp.Duration != 52*7*24*time.Hour || rp.ReplicaN != 2 || rp.SplitN != influxdb.DefaultSplitN {
+		t.Fatalf("unexpected policy: %#v", rp)
+	}
+
+	// Verify the users and the writer's token.
+	if u := s.User("acme_admin"); u == nil || !u.Admin {
+		t.Fatalf("unexpected admin: %#v", u)
+	}
+	u, err := s.AuthenticateToken(tokens["acme_writer"])
+	if err != nil {
+		t.Fatal(err)
+	} else if u.Name != "acme_writer" || u.Admin {
+		t.Fatalf("unexpected user: %#v", u)
+	} else if !u.AuthorizeDatabase(influxql.WritePrivilege, "acme") || !u.AuthorizeMeasurement(influxql.ReadPrivilege, "acme", "cpu0") {
+		t.Fatal("expected acme privileges")
+	} else if u.AuthorizeMeasurement(influxql.ReadPrivilege, "acme", "mem") {
+		t.Fatal("unexpected mem privilege")
+	}
+}
+
+// Ensure invalid templates are rejected without creating the database.
+func TestServer_ProvisionDatabase_Invalid(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("taken")
+	s.CreateUser("susy", "pass", false)
+
+	for i, tt := range []struct {
+		name string
+		tmpl *influxdb.DatabaseTemplate
+		err  error
+	}{
+		{name: "", tmpl: &influxdb.DatabaseTemplate{}, err: influxdb.ErrDatabaseNameRequired},
+		{name: "taken", tmpl: &influxdb.DatabaseTemplate{}, err: influxdb.ErrDatabaseExists},
+		{name: "acme", tmpl: &influxdb.DatabaseTemplate{ContinuousQueries: []string{`CREATE CONTINUOUS QUERY cq ON acme BEGIN SELECT count(value) INTO cpu_1h FROM cpu GROUP BY time(1h) END`}}, err: influxdb.ErrContinuousQueriesNotSupported},
+		{name: "acme", tmpl: &influxdb.DatabaseTemplate{RetentionPolicies: []*influxdb.TemplateRetentionPolicy{{Duration: "1d"}}}, err: influxdb.ErrRetentionPolicyNameRequired},
+		{name: "acme", tmpl: &influxdb.DatabaseTemplate{RetentionPolicies: []*influxdb.TemplateRetentionPolicy{{Name: "raw"}, {Name: "raw"}}}, err: influxdb.ErrRetentionPolicyExists},
+		{name: "acme", tmpl: &influxdb.DatabaseTemplate{RetentionPolicies: []*influxdb.TemplateRetentionPolicy{{Name: "raw", Duration: "a week"}}}, err: influxdb.ErrInvalidRetentionDuration},
+		{name: "acme", tmpl: &influxdb.DatabaseTemplate{RetentionPolicies: []*influxdb.TemplateRetentionPolicy{{Name: "raw", Default: true}, {Name: "archive", Default: true}}}, err: influxdb.ErrMultipleDefaultRetentionPolicies},
+		{name: "acme", tmpl: &influxdb.DatabaseTemplate{Users: []*influxdb.TemplateUser{{Password: "pass"}}}, err: influxdb.ErrUsernameRequired},
+		{name: "acme", tmpl: &influxdb.DatabaseTemplate{Users: []*influxdb.TemplateUser{{Name: "susy"}}}, err: influxdb.ErrUserExists},
+		{name: "acme", tmpl: &influxdb.DatabaseTemplate{Users: []*influxdb.TemplateUser{{Name: "bob"}, {Name: "bob"}}}, err: influxdb.ErrUserExists},
+		{name: "acme", tmpl: &influxdb.DatabaseTemplate{Users: []*influxdb.TemplateUser{{Name: "bob", Grants: []string{"admin"}}}}, err: influxdb.ErrInvalidTokenScope},
+	} {
+		if _, err := s.ProvisionDatabase(tt.name, tt.tmpl); err != tt.err {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		}
+	}
+	if s.DatabaseExists("acme") {
+		t.Fatal("unexpected database")
+	}
+}
+
+// Ensure the database and users are deleted if provisioning fails part way.
+func TestServer_ProvisionDatabase_Rollback(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// The second user's password is too long to hash.
+	_, err := s.ProvisionDatabase("acme", &influxdb.DatabaseTemplate{
+		RetentionPolicies: []*influxdb.TemplateRetentionPolicy{{Name: "raw", Default: true}},
+		Users: []*influxdb.TemplateUser{
+			{Name: "alice", Password: "pass", Grants: []string{"read"}},
+			{Name: "bob", Password: strings.Repeat("x", 100)},
+		},
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	} else if s.DatabaseExists("acme") {
+		t.Fatal("unexpected database")
+	} else if s.User("alice") != nil || s.User("bob") != nil {
+		t.Fatal("unexpected user")
+	}
+}
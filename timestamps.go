@@ -0,0 +1,62 @@
+package influxdb
+
+import (
+	"time"
+)
+
+// TimestampPolicy controls how an input assigns timestamps to the points it
+// receives. Points without a timestamp are always assigned the time they're
+// received.
+//
+// Clients with bad clocks can write points far from the present, which
+// scatters them into shards that are otherwise unused. Skew bounds replace
+// timestamps more than a given duration from the server's clock with the
+// server's clock. A zero bound is not enforced.
+type TimestampPolicy struct {
+	// Ignore client timestamps and assign every point the time it's received.
+	ServerTime bool
+
+	MaxPastSkew   time.Duration // maximum time a timestamp can be behind the server's clock
+	MaxFutureSkew time.Duration // maximum time a timestamp can be ahead of the server's clock
+}
+
+// Timestamp returns the timestamp to write for a point timestamped t when
+// the server's clock reads now. Returns true if t was outside the skew
+// bounds and replaced.
+func (p TimestampPolicy) Timestamp(t, now time.Time) (time.Time, bool) {
+	if p.ServerTime {
+		return now, false
+	} else if p.MaxPastSkew > 0 && t.Before(now.Add(-p.MaxPastSkew)) {
+		return now, true
+	} else if p.MaxFutureSkew > 0 && t.After(now.Add(p.MaxFutureSkew)) {
+		return now, true
+	}
+	return t, false
+}
+
+// apply assigns timestamps to points under the policy. Returns the number of
+// points whose timestamps were outside the skew bounds.
+func (p TimestampPolicy) apply(points []*Point, now time.Time) int {
+	if !p.ServerTime && p.MaxPastSkew <= 0 && p.MaxFutureSkew <= 0 {
+		return 0
+	}
+
+	var n int
+	for _, pt := range points {
+		t, corrected := p.Timestamp(pt.Timestamp, now)
+		pt.Timestamp = t
+		if corrected {
+			n++
+		}
+	}
+	return n
+}
+
+// CorrectTimestamps assigns timestamps to points received by an input under
+// a policy. Points whose timestamps were outside the skew bounds are counted
+// in the database's statistics.
+func (s *Server) CorrectTimestamps(database string, p TimestampPolicy, points []*Point) {
+	if n := p.apply(points, time.Now().UTC()); n > 0 && s.DatabaseExists(database) {
+		s.databaseStats(database).Add(StatTimestampsCorrected, int64(n))
+	}
+}
@@ -0,0 +1,43 @@
+package influxdb_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb"
+)
+
+// Ensure timestamps are replaced with the server's clock outside the bounds.
+func TestTimestampPolicy_Timestamp(t *testing.T) {
+	now := time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, tt := range []struct {
+		policy    influxdb.TimestampPolicy
+		t         time.Time
+		exp       time.Time
+		corrected bool
+	}{
+		// No bounds.
+		{t: now.Add(-24 * time.Hour), exp: now.Add(-24 * time.Hour)},
+		{t: now.Add(24 * time.Hour), exp: now.Add(24 * time.Hour)},
+
+		// Server time.
+		{policy: influxdb.TimestampPolicy{ServerTime: true}, t: now.Add(-time.Second), exp: now},
+
+		// Past skew.
+		{policy: influxdb.TimestampPolicy{MaxPastSkew: time.Hour}, t: now.Add(-time.Hour), exp: now.Add(-time.Hour)},
+		{policy: influxdb.TimestampPolicy{MaxPastSkew: time.Hour}, t: now.Add(-time.Hour - 1), exp: now, corrected: true},
+		{policy: influxdb.TimestampPolicy{MaxPastSkew: time.Hour}, t: now.Add(24 * time.Hour), exp: now.Add(24 * time.Hour)},
+
+		// Future skew.
+		{policy: influxdb.TimestampPolicy{MaxFutureSkew: time.Minute}, t: now.Add(time.Minute), exp: now.Add(time.Minute)},
+		{policy: influxdb.TimestampPolicy{MaxFutureSkew: time.Minute}, t: now.Add(time.Minute + 1), exp: now, corrected: true},
+		{policy: influxdb.TimestampPolicy{MaxFutureSkew: time.Minute}, t: now.Add(-24 * time.Hour), exp: now.Add(-24 * time.Hour)},
+	} {
+		ts, corrected := tt.policy.Timestamp(tt.t, now)
+		if !ts.Equal(tt.exp) {
+			t.Errorf("%d. timestamp: exp=%s, got=%s", i, tt.exp, ts)
+		} else if corrected != tt.corrected {
+			t.Errorf("%d. corrected: exp=%v, got=%v", i, tt.corrected, corrected)
+		}
+	}
+}
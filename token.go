@@ -0,0 +1,239 @@
+package influxdb
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// Token represents a long-lived API token issued to a user.
+// Only the hash of the token is stored.
+type Token struct {
+	ID        string    `json:"id"`
+	Hash      string    `json:"hash"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ParseTokenScope parses a scope in the form "read:db" or "write:db". A scope
+// can be limited to measurements by appending a pattern, such as "read:db:cpu.*".
+// Patterns use the syntax of path.Match. The returned pattern is empty if the
+// scope covers the whole database.
+func ParseTokenScope(s string) (p influxql.Privilege, database, pattern string, err error) {
+	a := strings.SplitN(s, ":", 3)
+	if len(a) < 2 || a[1] == "" {
+		return 0, "", "", ErrInvalidTokenScope
+	}
+	switch a[0] {
+	case "read":
+		p = influxql.ReadPrivilege
+	case "write":
+		p = influxql.WritePrivilege
+	default:
+		return 0, "", "", ErrInvalidTokenScope
+	}
+	if len(a) == 3 {
+		if a[2] == "" {
+			return 0, "", "", ErrInvalidTokenScope
+		} else if _, err := path.Match(a[2], ""); err != nil {
+			return 0, "", "", ErrInvalidTokenScope
+		}
+		pattern = a[2]
+	}
+	return p, a[1], pattern, nil
+}
+
+// hashToken returns the hex-encoded hash of a token.
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// randomHex returns n random bytes as a hex-encoded string.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CreateToken issues a new API token to a user with the given scopes.
+// Returns the token's metadata and the token itself. The token cannot be
+// retrieved again once it has been returned.
+func (s *Server) CreateToken(username string, scopes []string) (*Token, string, error) {
+	if len(scopes) == 0 {
+		return nil, "", ErrTokenScopesRequired
+	}
+	for _, scope := range scopes {
+		if _, _, _, err := ParseTokenScope(scope); err != nil {
+			return nil, "", err
+		}
+	}
+
+	// Generate the token before broadcasting so that only its hash is replicated.
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+	token, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	t := &Token{ID: id, Hash: hashToken(token), Scopes: scopes, CreatedAt: time.Now().UTC()}
+	c := &createTokenCommand{Username: username, ID: t.ID, Hash: t.Hash, Scopes: t.Scopes, CreatedAt: t.CreatedAt}
+	if _, err := s.broadcast(createTokenMessageType, c); err != nil {
+		return nil, "", err
+	}
+	return t, token, nil
+}
+
+func (s *Server) applyCreateToken(m *messaging.Message) error {
+	var c createTokenCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate user.
+	u := s.users[c.Username]
+	if u == nil {
+		return ErrUserNotFound
+	}
+
+	// Add the token and persist to metastore.
+	u.Tokens = append(u.Tokens, &Token{ID: c.ID, Hash: c.Hash, Scopes: c.Scopes, CreatedAt: c.CreatedAt})
+	return s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveUser(u)
+	})
+}
+
+type createTokenCommand struct {
+	Username  string    `json:"username"`
+	ID        string    `json:"id"`
+	Hash      string    `json:"hash"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// DeleteToken revokes an API token issued to a user.
+func (s *Server) DeleteToken(username, id string) error {
+	c := &deleteTokenCommand{Username: username, ID: id}
+	_, err := s.broadcast(deleteTokenMessageType, c)
+	return err
+}
+
+func (s *Server) applyDeleteToken(m *messaging.Message) error {
+	var c deleteTokenCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate user.
+	u := s.users[c.Username]
+	if u == nil {
+		return ErrUserNotFound
+	}
+
+	// Remove the token.
+	for i, t := range u.Tokens {
+		if t.ID == c.ID {
+			u.Tokens = append(u.Tokens[:i:i], u.Tokens[i+1:]...)
+			return s.meta.mustUpdate(func(tx *metatx) error {
+				return tx.saveUser(u)
+			})
+		}
+	}
+	return ErrTokenNotFound
+}
+
+type deleteTokenCommand struct {
+	Username string `json:"username"`
+	ID       string `json:"id"`
+}
+
+// Tokens returns the API tokens issued to a user.
+func (s *Server) Tokens(username string) ([]*Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u := s.users[username]
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+	return u.Tokens, nil
+}
+
+// AuthenticateToken returns the user that an API token was issued to.
+// The user is never an admin and is limited to the token's scopes as well as
+// the user's own grants.
+func (s *Server) AuthenticateToken(token string) (*User, error) {
+	hash := hashToken(token)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.users {
+		for _, t := range u.Tokens {
+			if t.Hash == hash {
+				return &User{Name: u.Name, Grants: u.Grants, token: t}, nil
+			}
+		}
+	}
+	return nil, ErrInvalidToken
+}
+
+// Authorize returns true if the user has a privilege on a database or on
+// some of its measurements. Admins and users without grants have all
+// privileges. Users authenticated with an API token are also limited to the
+// token's scopes.
+func (u *User) Authorize(privilege influxql.Privilege, database string) bool {
+	return u.authorize(privilege, database, func(pattern string) bool { return true })
+}
+
+// AuthorizeDatabase returns true if the user has a privilege on every
+// measurement in a database.
+func (u *User) AuthorizeDatabase(privilege influxql.Privilege, database string) bool {
+	return u.authorize(privilege, database, func(pattern string) bool { return pattern == "" })
+}
+
+// AuthorizeMeasurement returns true if the user has a privilege on a measurement.
+func (u *User) AuthorizeMeasurement(privilege influxql.Privilege, database, measurement string) bool {
+	return u.authorize(privilege, database, func(pattern string) bool {
+		if pattern == "" {
+			return true
+		}
+		matched, _ := path.Match(pattern, measurement)
+		return matched
+	})
+}
+
+// authorize returns true if the user's grants and token scopes both allow a
+// privilege on a database with a measurement pattern accepted by fn.
+func (u *User) authorize(privilege influxql.Privilege, database string, fn func(pattern string) bool) bool {
+	if u == nil || u.Admin {
+		return true
+	} else if len(u.Grants) > 0 && !scopesAllow(u.Grants, privilege, database, fn) {
+		return false
+	} else if u.token != nil && !scopesAllow(u.token.Scopes, privilege, database, fn) {
+		return false
+	}
+	return true
+}
+
+// scopesAllow returns true if one of the scopes grants a privilege on a
+// database and its measurement pattern is accepted by fn.
+func scopesAllow(scopes []string, privilege influxql.Privilege, database string, fn func(pattern string) bool) bool {
+	for _, scope := range scopes {
+		if p, db, pattern, err := ParseTokenScope(scope); err == nil && p == privilege && db == database && fn(pattern) {
+			return true
+		}
+	}
+	return false
+}
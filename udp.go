@@ -1,17 +1,26 @@
 package influxdb
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"net"
 	"sync"
 )
 
-// UDPServer
+// udpBufferSize is the largest UDP packet that can be received.
+const udpBufferSize = 65536
+
+// ErrBindAddressRequired is returned when starting the UDP server without an address.
+var ErrBindAddressRequired = errors.New("bind address required")
+
+// UDPServer receives series data in the JSON write format over UDP.
 type UDPServer struct {
 	server *Server
 
 	mu   sync.Mutex
 	wg   sync.WaitGroup
-	done chan struct{} // close notification
+	conn *net.UDPConn
 
 	// The UDP address to listen on.
 	Addr *net.UDPAddr
@@ -19,20 +28,28 @@ type UDPServer struct {
 	// The name of the database to insert data into.
 	Database string
 
+	// The retention policy to insert data into.
+	// Uses the database's default retention policy if blank.
+	RetentionPolicy string
+
+	// The precision of timestamps in received series.
+	Precision TimePrecision
+
+	// How timestamps are assigned to received points.
+	Timestamps TimestampPolicy
+
 	// The user authorized to insert the data.
 	User *User
 }
 
 // NewUDPServer returns an instance of UDPServer attached to a Server.
+// Timestamps default to second precision.
 func NewUDPServer(server *Server) *UDPServer {
-	return &UDPServer{server: server}
+	return &UDPServer{server: server, Precision: SecondPrecision}
 }
 
-// ListenAndServe opens a UDP socket to listen for messages.
+// ListenAndServe opens a UDP socket and processes messages in a separate goroutine.
 func (s *UDPServer) ListenAndServe() error {
-	panic("not yet implemented: UDPServer.ListenAndServe()")
-
-	/* TEMPORARILY REMOVED FOR PROTOBUFS.
 	// Validate that server has a UDP address.
 	if s.Addr == nil {
 		return ErrBindAddressRequired
@@ -43,57 +60,77 @@ func (s *UDPServer) ListenAndServe() error {
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
 
-	// Read messages off the connection and handle them.
-	buffer := make([]byte, 2048)
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go s.serve(conn)
+	return nil
+}
+
+// LocalAddr returns the address the server is listening on.
+// Returns nil if the server is not listening.
+func (s *UDPServer) LocalAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.LocalAddr()
+}
+
+// Close stops the server from listening and waits for it to finish processing messages.
+func (s *UDPServer) Close() error {
+	s.mu.Lock()
+	conn := s.conn
+	s.conn = nil
+	s.mu.Unlock()
+
+	if conn == nil {
+		return ErrServerClosed
+	}
+	err := conn.Close()
+	s.wg.Wait()
+	return err
+}
+
+// serve reads messages off the connection and writes them to the database.
+func (s *UDPServer) serve(conn *net.UDPConn) {
+	defer s.wg.Done()
+
+	buffer := make([]byte, udpBufferSize)
 	for {
 		n, _, err := conn.ReadFromUDP(buffer)
-		if err != nil || n == 0 {
-			log.Error("UDP ReadFromUDP error: %s", err)
+		if err != nil {
+			return
+		} else if n == 0 {
 			continue
 		}
 
-		// Create a JSON decoder.
-		dec := json.NewDecoder(bytes.NewBuffer(buffer[0:n]))
-		dec.UseNumber()
-
 		// Deserialize data into series.
+		dec := json.NewDecoder(bytes.NewReader(buffer[:n]))
+		dec.UseNumber()
 		var a []*serializedSeries
 		if err := dec.Decode(&a); err != nil {
-			log.Error("UDP json error: %s", err)
+			s.server.Logger.Printf("udp: json error: %s", err)
 			continue
 		}
 
-		// Write data points to the data store.
-		for _, ss := range a {
-			if len(ss.Points) == 0 {
-				continue
-			}
-
-			// Convert to the internal series format.
-			series, err := ss.series(SecondPrecision)
-			if err != nil {
-				log.Error("udp cannot convert received data: %s", err)
-				continue
-			}
-
-			// TODO: Authorization.
-
-			// Lookup database.
-			db := s.server.Database(s.Database)
-			if db == nil {
-				log.Error("udp: %s", ErrDatabaseNotFound)
-				continue
-			}
-
-			// Write series data to server.
-			if err := db.WriteSeries(series); err != nil {
-				log.Error("udp: write data error: %s", err)
-				continue
-			}
+		// Convert to points.
+		points, err := serializedSeriesSlice(a).points(s.Precision)
+		if err != nil {
+			s.server.Logger.Printf("udp: cannot convert received data: %s", err)
+			continue
 		}
 
+		// TODO: Authorization.
+
+		// Write points to the database.
+		s.server.CorrectTimestamps(s.Database, s.Timestamps, points)
+		if err := s.server.WritePoints(s.Database, s.RetentionPolicy, points); err != nil {
+			s.server.Logger.Printf("udp: write data error: %s", err)
+		}
 	}
-	*/
 }
@@ -0,0 +1,226 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/go.net/websocket"
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// serveWebSocket upgrades a request to a WebSocket connection that runs
+// queries against a database and tails its measurements. Messages are JSON
+// encoded wsRequest and wsResponse values.
+func (h *Handler) serveWebSocket(w http.ResponseWriter, r *http.Request, u *User) {
+	q := r.URL.Query()
+
+	// Ensure the database exists and accepts queries.
+	db := q.Get(":db")
+	if !h.server.DatabaseExists(db) {
+		h.error(w, ErrDatabaseNotFound.Error(), http.StatusNotFound)
+		return
+	} else if h.server.DatabaseDisabled(db) {
+		h.error(w, ErrDatabaseDisabled.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Parse the precision of returned timestamps. Defaults to microseconds.
+	precision := MicrosecondPrecision
+	if s := q.Get("time_precision"); s != "" {
+		var err error
+		if precision, err = ParseTimePrecision(s); err != nil {
+			h.error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	websocket.Server{Handler: func(conn *websocket.Conn) {
+		s := &wsSession{
+			handler:   h,
+			conn:      conn,
+			request:   r,
+			user:      u,
+			database:  db,
+			precision: precision,
+			tails:     make(map[string]*Tail),
+		}
+		s.run()
+	}}.ServeHTTP(w, r)
+}
+
+// wsRequest represents a message sent by a WebSocket client. Each message
+// either runs a query, starts tailing a measurement or stops a tail. The id
+// is returned with every response to the message.
+type wsRequest struct {
+	ID string `json:"id,omitempty"`
+
+	// Statements to execute and their bound parameters.
+	Query  string                 `json:"q,omitempty"`
+	Params map[string]interface{} `json:"params,omitempty"`
+
+	// Measurement to tail and an optional condition on the tags and
+	// fields of its points.
+	Tail  string `json:"tail,omitempty"`
+	Where string `json:"where,omitempty"`
+
+	// If true, the tail with the same id is stopped.
+	Stop bool `json:"stop,omitempty"`
+}
+
+// wsResponse represents a message sent to a WebSocket client.
+type wsResponse struct {
+	ID      string        `json:"id,omitempty"`
+	Status  string        `json:"status,omitempty"`  // "tailing" or "stopped" for tail requests
+	Results Results       `json:"results,omitempty"` // results of a query
+	Series  *influxql.Row `json:"series,omitempty"`  // point received by a tail
+	Err     string        `json:"error,omitempty"`
+}
+
+// wsSession represents the state of a WebSocket connection.
+type wsSession struct {
+	handler   *Handler
+	conn      *websocket.Conn
+	request   *http.Request
+	user      *User
+	database  string
+	precision TimePrecision
+
+	tails map[string]*Tail // tails by request id
+	wg    sync.WaitGroup   // goroutines sending tailed points
+}
+
+// run reads and handles messages until the connection is closed.
+func (s *wsSession) run() {
+	defer s.wg.Wait()
+	defer s.conn.Close()
+	defer s.stopAll()
+
+	for {
+		var req wsRequest
+		if err := websocket.JSON.Receive(s.conn, &req); err == io.EOF {
+			return
+		} else if _, ok := err.(*json.SyntaxError); ok {
+			s.sendError("", err)
+			continue
+		} else if _, ok := err.(*json.UnmarshalTypeError); ok {
+			s.sendError("", err)
+			continue
+		} else if err != nil {
+			return
+		}
+
+		switch {
+		case req.Stop:
+			s.stop(req.ID)
+			s.send(&wsResponse{ID: req.ID, Status: "stopped"})
+		case req.Tail != "":
+			s.tail(&req)
+		case req.Query != "":
+			s.query(&req)
+		default:
+			s.send(&wsResponse{ID: req.ID, Err: "query or tail required"})
+		}
+	}
+}
+
+// query executes a query and sends its results.
+func (s *wsSession) query(req *wsRequest) {
+	h := s.handler
+	q, err := h.server.ParseQuery(s.database, req.Query, req.Params)
+	if err != nil {
+		s.send(&wsResponse{ID: req.ID, Err: "parse error: " + err.Error()})
+		return
+	}
+
+	// Ensure the user has not exceeded their query rate.
+	if u := s.user; u != nil && h.Limits.QueriesPerMinute > 0 {
+		qpm := float64(h.Limits.QueriesPerMinute)
+		if d := h.limiter.take("queries:"+u.Name, float64(len(q.Statements)), qpm/60, qpm, time.Now()); d > 0 {
+			s.sendError(req.ID, ErrRateLimitExceeded)
+			return
+		}
+	}
+
+	ctx, cancel := h.queryContext(s.request)
+	defer cancel()
+	opt := QueryOptions{
+		RequestID:        s.request.Header.Get("X-Request-Id"),
+		MaxPointsScanned: h.Limits.MaxPointsScanned,
+		MaxMemory:        h.Limits.MaxQueryMemory,
+		MaxRowLimit:      h.Limits.MaxRowLimit,
+		RemoteAddr:       remoteAddr(s.request),
+		Context:          ctx,
+	}
+	results := h.server.ExecuteQuery(q, s.database, s.user, opt)
+	if s.precision != MicrosecondPrecision {
+		convertResultTimes(results, s.precision)
+	}
+	s.send(&wsResponse{ID: req.ID, Results: results})
+}
+
+// tail starts sending the points written to a measurement. An existing tail
+// with the same id is replaced.
+func (s *wsSession) tail(req *wsRequest) {
+	if !s.user.AuthorizeMeasurement(influxql.ReadPrivilege, s.database, req.Tail) {
+		s.sendError(req.ID, ErrReadAccessDenied)
+		return
+	}
+
+	var cond influxql.Expr
+	if req.Where != "" {
+		var err error
+		if cond, err = influxql.NewParser(strings.NewReader(req.Where)).ParseExpr(); err != nil {
+			s.send(&wsResponse{ID: req.ID, Err: "parse error: " + err.Error()})
+			return
+		}
+	}
+
+	t, err := s.handler.server.Tail(s.database, req.Tail, cond, TailOptions{})
+	if err != nil {
+		s.sendError(req.ID, err)
+		return
+	}
+	s.stop(req.ID)
+	s.tails[req.ID] = t
+	s.send(&wsResponse{ID: req.ID, Status: "tailing"})
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for p := range t.C {
+			if err := s.send(&wsResponse{ID: req.ID, Series: p.row(s.precision)}); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// stop stops the tail with an id, if one exists.
+func (s *wsSession) stop(id string) {
+	if t := s.tails[id]; t != nil {
+		t.Close()
+		delete(s.tails, id)
+	}
+}
+
+// stopAll stops all tails on the connection.
+func (s *wsSession) stopAll() {
+	for id := range s.tails {
+		s.stop(id)
+	}
+}
+
+// send writes a message to the connection. Messages are written in a single
+// frame so they can be sent from multiple goroutines.
+func (s *wsSession) send(resp *wsResponse) error {
+	return websocket.JSON.Send(s.conn, resp)
+}
+
+// sendError writes an error message to the connection.
+func (s *wsSession) sendError(id string, err error) {
+	s.send(&wsResponse{ID: id, Err: err.Error()})
+}
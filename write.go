@@ -0,0 +1,563 @@
+package influxdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+	"unicode/utf8"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Point represents a single point of series data to be written.
+type Point struct {
+	Name      string
+	Tags      map[string]string
+	Timestamp time.Time
+	Values    map[string]interface{}
+}
+
+// validate returns a *PointError if the point cannot be written.
+// Zero values in the limits are not enforced. The timestamp must not be
+// before min or after max, unless they are zero.
+func (p *Point) validate(l PointLimits, min, max time.Time) error {
+	// Validate the measurement name.
+	if p.Name == "" {
+		return &PointError{Err: ErrMeasurementNameRequired}
+	} else if !utf8.ValidString(p.Name) {
+		return &PointError{Err: ErrInvalidUTF8}
+	} else if l.MaxKeyLength > 0 && len(p.Name) > l.MaxKeyLength {
+		return &PointError{Err: ErrKeyTooLong}
+	}
+
+	// Validate the timestamp.
+	if !max.IsZero() && p.Timestamp.After(max) {
+		return &PointError{Err: ErrTimestampInFuture}
+	} else if !min.IsZero() && p.Timestamp.Before(min) {
+		return &PointError{Err: ErrTimestampExpired}
+	}
+
+	// Validate tags.
+	if l.MaxTags > 0 && len(p.Tags) > l.MaxTags {
+		return &PointError{Err: ErrTooManyTags}
+	}
+	for k, v := range p.Tags {
+		if err := l.validateKey(k); err != nil {
+			return &PointError{Key: k, Err: err}
+		} else if err := l.validateString(v); err != nil {
+			return &PointError{Key: k, Err: err}
+		}
+	}
+
+	// Validate fields.
+	if len(p.Values) == 0 {
+		return &PointError{Err: ErrFieldsRequired}
+	} else if l.MaxFields > 0 && len(p.Values) > l.MaxFields {
+		return &PointError{Err: ErrTooManyFields}
+	}
+	for k, v := range p.Values {
+		if err := l.validateKey(k); err != nil {
+			return &PointError{Key: k, Err: err}
+		}
+
+		switch v := v.(type) {
+		case float64:
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return &PointError{Key: k, Err: ErrInvalidFloat}
+			}
+		case string:
+			if err := l.validateString(v); err != nil {
+				return &PointError{Key: k, Err: err}
+			}
+		case *influxql.HistogramValue:
+			if !v.Valid() {
+				return &PointError{Key: k, Err: ErrInvalidHistogram}
+			}
+		default:
+			if influxql.InspectDataType(v) == influxql.Unknown {
+				return &PointError{Key: k, Err: fmt.Errorf("unsupported value: %v", v)}
+			}
+		}
+	}
+	return nil
+}
+
+// PointLimits restricts the size and timestamps of points that can be written.
+// A zero value disables the corresponding limit.
+type PointLimits struct {
+	MaxFields      int // maximum number of fields per point
+	MaxTags        int // maximum number of tags per point
+	MaxKeyLength   int // maximum length of measurement names, tag keys and field keys
+	MaxValueLength int // maximum length of tag values and string field values
+
+	MaxFuture     time.Duration // maximum time a timestamp can be ahead of the server's clock
+	RejectExpired bool          // reject timestamps older than the retention policy's duration
+}
+
+// validateKey returns an error if a tag key or field key is invalid.
+func (l PointLimits) validateKey(s string) error {
+	if s == "" {
+		return ErrKeyRequired
+	} else if !utf8.ValidString(s) {
+		return ErrInvalidUTF8
+	} else if l.MaxKeyLength > 0 && len(s) > l.MaxKeyLength {
+		return ErrKeyTooLong
+	}
+	return nil
+}
+
+// validateString returns an error if a tag value or string field value is invalid.
+func (l PointLimits) validateString(s string) error {
+	if !utf8.ValidString(s) {
+		return ErrInvalidUTF8
+	} else if l.MaxValueLength > 0 && len(s) > l.MaxValueLength {
+		return ErrValueTooLong
+	}
+	return nil
+}
+
+// PointError is returned when a point cannot be written.
+type PointError struct {
+	Index int    // position of the point in the batch
+	Key   string // tag or field key that was rejected, if any
+	Err   error  // reason the point was rejected
+}
+
+// Error returns the string representation of the error.
+func (e *PointError) Error() string {
+	if e.Key != "" {
+		return fmt.Sprintf("point %d: %q: %s", e.Index, e.Key, e.Err)
+	}
+	return fmt.Sprintf("point %d: %s", e.Index, e.Err)
+}
+
+// MarshalJSON encodes the error as a JSON object.
+func (e *PointError) MarshalJSON() ([]byte, error) {
+	var o struct {
+		Index int    `json:"index"`
+		Key   string `json:"key,omitempty"`
+		Err   string `json:"error"`
+	}
+	o.Index, o.Key, o.Err = e.Index, e.Key, e.Err.Error()
+	return json.Marshal(&o)
+}
+
+// PointErrors is returned when one or more points in a batch cannot be written.
+type PointErrors []*PointError
+
+// Error returns the errors for each point, separated by semicolons.
+func (a PointErrors) Error() string {
+	var buf bytes.Buffer
+	for i, e := range a {
+		if i > 0 {
+			buf.WriteString("; ")
+		}
+		buf.WriteString(e.Error())
+	}
+	return buf.String()
+}
+
+func (a PointErrors) Len() int           { return len(a) }
+func (a PointErrors) Less(i, j int) bool { return a[i].Index < a[j].Index }
+func (a PointErrors) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// serializedSeries represents a series in the write format.
+// Each point is a row of values in the same order as the columns.
+// The optional "time" column holds the timestamp in the write precision.
+type serializedSeries struct {
+	Name    string            `json:"name"`
+	Tags    map[string]string `json:"tags,omitempty"`
+	Columns []string          `json:"columns"`
+	Points  [][]interface{}   `json:"points"`
+}
+
+// points converts the series to points. Points without a timestamp are
+// assigned the current time. Null values are ignored.
+func (s *serializedSeries) points(precision TimePrecision, now time.Time) ([]*Point, error) {
+	a := make([]*Point, 0, len(s.Points))
+	for _, row := range s.Points {
+		if len(row) != len(s.Columns) {
+			return nil, fmt.Errorf("series %q: expected %d values, got %d", s.Name, len(s.Columns), len(row))
+		}
+
+		p := &Point{Name: s.Name, Tags: s.Tags, Timestamp: now, Values: make(map[string]interface{})}
+		for i, col := range s.Columns {
+			v := row[i]
+			if v == nil {
+				continue
+			}
+
+			// Read the timestamp from the time column.
+			if col == "time" {
+				t, err := parseTimestamp(v, precision)
+				if err != nil {
+					return nil, fmt.Errorf("series %q: %s", s.Name, err)
+				}
+				p.Timestamp = t
+				continue
+			}
+
+			// Convert decoded JSON numbers to floats and objects to histograms.
+			switch value := v.(type) {
+			case json.Number:
+				f, err := value.Float64()
+				if err != nil {
+					return nil, fmt.Errorf("series %q: invalid number: %s", s.Name, value)
+				}
+				v = f
+			case map[string]interface{}:
+				h, err := parseHistogram(value)
+				if err != nil {
+					return nil, fmt.Errorf("series %q: %s", s.Name, err)
+				}
+				v = h
+			}
+			p.Values[col] = v
+		}
+		a = append(a, p)
+	}
+	return a, nil
+}
+
+// parseHistogram converts a decoded JSON object with "bounds" and "counts"
+// lists of numbers to a histogram value. The histogram is validated with the
+// rest of the point.
+func parseHistogram(o map[string]interface{}) (*influxql.HistogramValue, error) {
+	h := &influxql.HistogramValue{}
+	for k, v := range o {
+		var dst *[]float64
+		switch k {
+		case "bounds":
+			dst = &h.Bounds
+		case "counts":
+			dst = &h.Counts
+		default:
+			return nil, fmt.Errorf("invalid histogram key: %s", k)
+		}
+
+		a, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("histogram %s must be a list of numbers", k)
+		}
+		*dst = make([]float64, len(a))
+		for i, elem := range a {
+			switch n := elem.(type) {
+			case json.Number:
+				f, err := n.Float64()
+				if err != nil {
+					return nil, fmt.Errorf("invalid number: %s", n)
+				}
+				(*dst)[i] = f
+			case float64:
+				(*dst)[i] = n
+			default:
+				return nil, fmt.Errorf("histogram %s must be a list of numbers", k)
+			}
+		}
+	}
+	return h, nil
+}
+
+// serializedSeriesSlice represents a list of series in the write format.
+type serializedSeriesSlice []*serializedSeries
+
+// points converts every series to points. Returns an error if a series is
+// null.
+func (a serializedSeriesSlice) points(precision TimePrecision) ([]*Point, error) {
+	now := time.Now().UTC()
+	var points []*Point
+	for i, s := range a {
+		if s == nil {
+			return nil, fmt.Errorf("series %d: missing", i)
+		}
+		other, err := s.points(precision, now)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, other...)
+	}
+	return points, nil
+}
+
+// parseTimestamp converts a timestamp in the given precision to a time.
+// Fractional timestamps are supported for precisions coarser than nanoseconds.
+func parseTimestamp(v interface{}, precision TimePrecision) (time.Time, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return precision.Time(n), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid timestamp: %s", v)
+		}
+		return parseTimestamp(f, precision)
+	case int64:
+		return precision.Time(v), nil
+	case float64:
+		return time.Unix(0, int64(v*float64(precision.Duration()))).UTC(), nil
+	default:
+		return time.Time{}, fmt.Errorf("invalid timestamp: %v", v)
+	}
+}
+
+// errProtobufTruncated is returned when a protobuf message ends unexpectedly.
+var errProtobufTruncated = errors.New("protobuf: unexpected end of message")
+
+// Protobuf wire types.
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+// unmarshalProtobufSeries decodes a WriteRequest message, as defined in
+// write.proto, into series. Each point's timestamp is returned in a "time"
+// column before the series columns so that both write formats are converted
+// to points the same way.
+func unmarshalProtobufSeries(data []byte) ([]*serializedSeries, error) {
+	var a []*serializedSeries
+	err := decodeProtobuf(data, func(field, wire int, b *protoBuffer) error {
+		if field != 1 || wire != protoBytes {
+			return b.skip(wire)
+		}
+		buf, err := b.bytes()
+		if err != nil {
+			return err
+		}
+		s, err := unmarshalProtobufSeriesMessage(buf)
+		if err != nil {
+			return err
+		}
+		a = append(a, s)
+		return nil
+	})
+	return a, err
+}
+
+// unmarshalProtobufSeriesMessage decodes a single Series message.
+func unmarshalProtobufSeriesMessage(data []byte) (*serializedSeries, error) {
+	s := &serializedSeries{Columns: []string{"time"}}
+	var rows [][]byte
+	err := decodeProtobuf(data, func(field, wire int, b *protoBuffer) error {
+		if wire != protoBytes {
+			return b.skip(wire)
+		}
+		buf, err := b.bytes()
+		if err != nil {
+			return err
+		}
+
+		switch field {
+		case 1:
+			s.Name = string(buf)
+		case 2:
+			var key, value string
+			if err := decodeProtobuf(buf, func(field, wire int, b *protoBuffer) error {
+				if wire != protoBytes {
+					return b.skip(wire)
+				}
+				v, err := b.bytes()
+				if field == 1 {
+					key = string(v)
+				} else if field == 2 {
+					value = string(v)
+				}
+				return err
+			}); err != nil {
+				return err
+			}
+			if s.Tags == nil {
+				s.Tags = make(map[string]string)
+			}
+			s.Tags[key] = value
+		case 3:
+			s.Columns = append(s.Columns, string(buf))
+		case 4:
+			// Points are decoded once all columns are known.
+			rows = append(rows, buf)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode points.
+	for _, buf := range rows {
+		row, err := unmarshalProtobufPoint(buf)
+		if err != nil {
+			return nil, err
+		}
+		s.Points = append(s.Points, row)
+	}
+	return s, nil
+}
+
+// unmarshalProtobufPoint decodes a Point message into a row of values.
+// The first value is the timestamp, or nil if it is not set.
+func unmarshalProtobufPoint(data []byte) ([]interface{}, error) {
+	row := []interface{}{nil}
+	err := decodeProtobuf(data, func(field, wire int, b *protoBuffer) error {
+		switch {
+		case field == 1 && wire == protoVarint:
+			v, err := b.varint()
+			row[0] = int64(v)
+			return err
+		case field == 2 && wire == protoBytes:
+			buf, err := b.bytes()
+			if err != nil {
+				return err
+			}
+			v, err := unmarshalProtobufFieldValue(buf)
+			row = append(row, v)
+			return err
+		default:
+			return b.skip(wire)
+		}
+	})
+	return row, err
+}
+
+// unmarshalProtobufFieldValue decodes a FieldValue message.
+// Returns nil if no value is set.
+func unmarshalProtobufFieldValue(data []byte) (interface{}, error) {
+	var value interface{}
+	err := decodeProtobuf(data, func(field, wire int, b *protoBuffer) error {
+		switch {
+		case field == 1 && wire == protoFixed64:
+			v, err := b.fixed64()
+			value = math.Float64frombits(v)
+			return err
+		case field == 2 && wire == protoBytes:
+			v, err := b.bytes()
+			value = string(v)
+			return err
+		case field == 3 && wire == protoVarint:
+			v, err := b.varint()
+			value = v != 0
+			return err
+		case field == 4 && wire == protoVarint:
+			v, err := b.varint()
+			value = int64(v)
+			return err
+		case field == 5 && wire == protoVarint:
+			v, err := b.varint()
+			value = v
+			return err
+		default:
+			return b.skip(wire)
+		}
+	})
+	return value, err
+}
+
+// decodeProtobuf calls fn for each field in a protobuf message.
+// The function must consume or skip the field's value.
+func decodeProtobuf(data []byte, fn func(field, wire int, b *protoBuffer) error) error {
+	b := &protoBuffer{buf: data}
+	for len(b.buf) > 0 {
+		key, err := b.varint()
+		if err != nil {
+			return err
+		}
+		if err := fn(int(key>>3), int(key&0x7), b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// protoBuffer reads values in the protobuf wire format.
+type protoBuffer struct {
+	buf []byte
+}
+
+// varint reads a base 128 varint.
+func (b *protoBuffer) varint() (uint64, error) {
+	var v uint64
+	for i := 0; i < len(b.buf) && i < 10; i++ {
+		c := b.buf[i]
+		v |= uint64(c&0x7f) << (7 * uint(i))
+		if c < 0x80 {
+			b.buf = b.buf[i+1:]
+			return v, nil
+		}
+	}
+	return 0, errProtobufTruncated
+}
+
+// fixed64 reads a little endian 64-bit value.
+func (b *protoBuffer) fixed64() (uint64, error) {
+	if len(b.buf) < 8 {
+		return 0, errProtobufTruncated
+	}
+	var v uint64
+	for i := 7; i >= 0; i-- {
+		v = v<<8 | uint64(b.buf[i])
+	}
+	b.buf = b.buf[8:]
+	return v, nil
+}
+
+// bytes reads a length-delimited value.
+func (b *protoBuffer) bytes() ([]byte, error) {
+	n, err := b.varint()
+	if err != nil {
+		return nil, err
+	} else if n > uint64(len(b.buf)) {
+		return nil, errProtobufTruncated
+	}
+	v := b.buf[:n]
+	b.buf = b.buf[n:]
+	return v, nil
+}
+
+// skip discards a value of the given wire type.
+func (b *protoBuffer) skip(wire int) error {
+	switch wire {
+	case protoVarint:
+		_, err := b.varint()
+		return err
+	case protoFixed64:
+		_, err := b.fixed64()
+		return err
+	case protoBytes:
+		_, err := b.bytes()
+		return err
+	case protoFixed32:
+		if len(b.buf) < 4 {
+			return errProtobufTruncated
+		}
+		b.buf = b.buf[4:]
+		return nil
+	default:
+		return fmt.Errorf("protobuf: unsupported wire type: %d", wire)
+	}
+}
+
+// appendProtobufVarint appends a varint field to a protobuf message.
+func appendProtobufVarint(b []byte, field int, v uint64) []byte {
+	b = appendUvarint(b, uint64(field)<<3|protoVarint)
+	return appendUvarint(b, v)
+}
+
+// appendProtobufFixed64 appends a little endian 64-bit field to a protobuf message.
+func appendProtobufFixed64(b []byte, field int, v uint64) []byte {
+	b = appendUvarint(b, uint64(field)<<3|protoFixed64)
+	for i := 0; i < 8; i++ {
+		b = append(b, byte(v>>(8*uint(i))))
+	}
+	return b
+}
+
+// appendProtobufBytes appends a length-delimited field to a protobuf message.
+func appendProtobufBytes(b []byte, field int, v []byte) []byte {
+	b = appendUvarint(b, uint64(field)<<3|protoBytes)
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
@@ -0,0 +1,201 @@
+package influxdb
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultWriteIDCacheSize is the default number of write request ids remembered by the server.
+	DefaultWriteIDCacheSize = 10000
+
+	// DefaultWriteIDTTL is the default length of time a write request id is remembered.
+	DefaultWriteIDTTL = 10 * time.Minute
+)
+
+// writeIDCache remembers the ids of recent write requests so that retried
+// requests are not written twice. Successful writes are remembered, as are the
+// points published by writes that failed part way. Writes in progress and
+// partially failed writes are not evicted when the cache is full.
+type writeIDCache struct {
+	mu      sync.Mutex
+	size    int                      // maximum number of entries
+	ttl     time.Duration            // how long a successful write is remembered
+	list    *list.List               // entries ordered from newest to oldest
+	entries map[string]*list.Element // entries by key
+}
+
+// writeIDEntry represents a write request that is in progress or has completed.
+type writeIDEntry struct {
+	key       string
+	completed bool      // true once the write has finished
+	expires   time.Time // time the entry is forgotten, once completed
+
+	done chan struct{} // closed once the write has finished
+	err  error         // write error; set before done is closed
+
+	// Points published by the write, by index. Only set by the owner.
+	published []bool
+}
+
+// pinned returns true if the entry must not be evicted: its write is in
+// progress or it failed after publishing some of its points.
+func (e *writeIDEntry) pinned() bool { return !e.completed || e.err != nil }
+
+// partial returns true if any of the entry's points were published.
+func (e *writeIDEntry) partial() bool {
+	for _, ok := range e.published {
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// newWriteIDCache returns a new instance of writeIDCache.
+func newWriteIDCache(size int, ttl time.Duration) *writeIDCache {
+	return &writeIDCache{
+		size:    size,
+		ttl:     ttl,
+		list:    list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// begin returns the entry for a key. If no write is in progress or remembered
+// for the key then a new entry is added and owner is true. The owner must
+// perform the write and call finish. A new entry for a write that failed part
+// way keeps the points that were already published.
+func (c *writeIDCache) begin(key string, now time.Time) (e *writeIDEntry, owner bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Return the existing entry unless it has failed or expired.
+	var published []bool
+	if elem := c.entries[key]; elem != nil {
+		e := elem.Value.(*writeIDEntry)
+		if !e.completed || (e.err == nil && now.Before(e.expires)) {
+			return e, false
+		}
+		if e.err != nil && now.Before(e.expires) {
+			published = e.published
+		}
+		c.remove(elem)
+	}
+
+	// Add a new entry and evict the oldest entries once the cache is full.
+	e = &writeIDEntry{key: key, done: make(chan struct{}), published: published}
+	c.entries[key] = c.list.PushFront(e)
+	c.evict(now)
+	return e, true
+}
+
+// finish marks an entry's write as finished. Failed writes that published no
+// points are forgotten so that they can be retried from the start.
+func (c *writeIDCache) finish(e *writeIDEntry, err error, now time.Time) {
+	c.mu.Lock()
+	e.completed, e.err, e.expires = true, err, now.Add(c.ttl)
+	if elem := c.entries[e.key]; elem != nil && elem.Value == e && err != nil && !e.partial() {
+		c.remove(elem)
+	}
+	c.evict(now)
+	c.mu.Unlock()
+
+	close(e.done)
+}
+
+// resize changes the maximum number of entries and how long they are remembered.
+func (c *writeIDCache) resize(size int, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size, c.ttl = size, ttl
+	c.evict(time.Now())
+}
+
+// evict removes the oldest entries until the cache is no larger than its
+// size. Pinned entries are skipped unless they have expired.
+func (c *writeIDCache) evict(now time.Time) {
+	for elem := c.list.Back(); elem != nil && c.list.Len() > c.size; {
+		prev := elem.Prev()
+		if e := elem.Value.(*writeIDEntry); !e.pinned() || (e.completed && !now.Before(e.expires)) {
+			c.remove(elem)
+		}
+		elem = prev
+	}
+}
+
+// disabled returns true if write deduplication is turned off.
+func (c *writeIDCache) disabled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size == 0
+}
+
+// remove deletes an entry from the cache.
+func (c *writeIDCache) remove(elem *list.Element) {
+	c.list.Remove(elem)
+	delete(c.entries, elem.Value.(*writeIDEntry).key)
+}
+
+// SetWriteIDCache sets the number of write request ids the server remembers
+// and how long each is remembered. A size of zero disables deduplication.
+func (s *Server) SetWriteIDCache(size int, ttl time.Duration) {
+	if size < 0 {
+		size = 0
+	}
+	s.writeIDs.resize(size, ttl)
+}
+
+// WritePointsOnce writes a batch of points like WritePoints but skips the write
+// if a request with the same id was already written successfully to the
+// database. Concurrent requests with the same id wait for the first to finish
+// and return its result. Returns true if the write was skipped as a duplicate.
+// Retrying a write that failed part way only writes the points that weren't
+// published by the failed write.
+//
+// Request ids are remembered by the server that received the write.
+func (s *Server) WritePointsOnce(database, retentionPolicy, requestID string, points []*Point) (bool, error) {
+	// Write normally if no id is supplied or deduplication is disabled.
+	if requestID == "" || s.writeIDs.disabled() {
+		return false, s.WritePoints(database, retentionPolicy, points)
+	}
+
+	// Wait for any previous write with the same id.
+	e, owner := s.writeIDs.begin(database+"\x00"+requestID, time.Now())
+	if !owner {
+		<-e.done
+		return true, e.err
+	}
+
+	// Skip the points published by an earlier attempt. A retry with a
+	// different number of points is written as a new request.
+	if len(e.published) != len(points) {
+		e.published = make([]bool, len(points))
+	}
+	var pending []*Point
+	var indexes []int
+	for i, p := range points {
+		if !e.published[i] {
+			pending = append(pending, p)
+			indexes = append(indexes, i)
+		}
+	}
+
+	// Write the pending points and record which were published. Point errors
+	// refer to the index of the point in the request.
+	published := make([]bool, len(pending))
+	err := s.writeRoutes(database, retentionPolicy, pending, published)
+	for i, ok := range published {
+		if ok {
+			e.published[indexes[i]] = true
+		}
+	}
+	if errs, ok := err.(PointErrors); ok {
+		for _, pe := range errs {
+			pe.Index = indexes[pe.Index]
+		}
+	}
+	s.writeIDs.finish(e, err, time.Now())
+	return false, err
+}
@@ -0,0 +1,108 @@
+package influxdb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Ensure the write id cache remembers successful writes until they expire.
+func TestWriteIDCache(t *testing.T) {
+	c := newWriteIDCache(2, time.Minute)
+	now := time.Unix(0, 0)
+
+	// The first request owns the write.
+	e, owner := c.begin("a", now)
+	if !owner {
+		t.Fatal("expected owner")
+	}
+
+	// Requests made while the write is in progress wait on the same entry.
+	if other, owner := c.begin("a", now); owner || other != e {
+		t.Fatal("expected in-progress entry")
+	}
+	c.finish(e, nil, now)
+
+	// Successful writes are remembered until the ttl elapses.
+	if _, owner := c.begin("a", now.Add(59*time.Second)); owner {
+		t.Fatal("expected duplicate")
+	} else if _, owner := c.begin("a", now.Add(time.Minute)); !owner {
+		t.Fatal("expected expired entry to be replaced")
+	}
+}
+
+// Ensure failed writes are forgotten so they can be retried.
+func TestWriteIDCache_Error(t *testing.T) {
+	c := newWriteIDCache(2, time.Minute)
+	now := time.Unix(0, 0)
+
+	e, _ := c.begin("a", now)
+	c.finish(e, errors.New("marker"), now)
+	if err := e.err; err == nil || err.Error() != "marker" {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, owner := c.begin("a", now); !owner {
+		t.Fatal("expected failed write to be retried")
+	}
+}
+
+// Ensure the oldest ids are evicted once the cache is full.
+func TestWriteIDCache_Evict(t *testing.T) {
+	c := newWriteIDCache(2, time.Minute)
+	now := time.Unix(0, 0)
+	for _, key := range []string{"a", "b", "c"} {
+		e, _ := c.begin(key, now)
+		c.finish(e, nil, now)
+	}
+
+	if e, owner := c.begin("a", now); !owner {
+		t.Fatal("expected a to be evicted")
+	} else if _, owner := c.begin("c", now); owner {
+		t.Fatal("expected c to be remembered")
+	} else {
+		c.finish(e, nil, now)
+	}
+
+	// Disabling the cache removes every id.
+	c.resize(0, time.Minute)
+	if !c.disabled() || c.list.Len() != 0 {
+		t.Fatal("expected empty, disabled cache")
+	}
+}
+
+// Ensure writes in progress and writes that failed part way are not evicted.
+func TestWriteIDCache_Pinned(t *testing.T) {
+	c := newWriteIDCache(1, time.Minute)
+	now := time.Unix(0, 0)
+
+	// A write in progress is kept while other ids are added.
+	a, _ := c.begin("a", now)
+	b, _ := c.begin("b", now)
+	c.finish(b, nil, now)
+	if other, owner := c.begin("a", now); owner || other != a {
+		t.Fatal("expected in-progress entry")
+	}
+
+	// A write that failed after publishing some points keeps them for the retry.
+	a.published = []bool{true, false}
+	c.finish(a, errors.New("marker"), now)
+	for _, key := range []string{"c", "d"} {
+		e, _ := c.begin(key, now)
+		c.finish(e, nil, now)
+	}
+	if e, owner := c.begin("a", now); !owner {
+		t.Fatal("expected failed write to be retried")
+	} else if !reflect.DeepEqual(e.published, []bool{true, false}) {
+		t.Fatalf("unexpected published points: %v", e.published)
+	} else {
+		c.finish(e, nil, now)
+	}
+
+	// Partially failed writes are forgotten once they expire.
+	e, _ := c.begin("e", now)
+	e.published = []bool{true}
+	c.finish(e, errors.New("marker"), now)
+	if e, _ := c.begin("e", now.Add(time.Minute)); e.published != nil {
+		t.Fatalf("unexpected published points: %v", e.published)
+	}
+}